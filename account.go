@@ -0,0 +1,74 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+)
+
+func HandleDeleteAccount(w http.ResponseWriter, r *http.Request) {
+	userCtx, ok := r.Context().Value("user").(UserContext)
+	if !ok {
+		http.Error(w, "Unauthorized: user context missing", http.StatusUnauthorized)
+		return
+	}
+
+	err := DeprovisionUser(r.Context(), userCtx)
+	if err != nil {
+		log.Printf("Error deleting account for user %d: %v\n", userCtx.UserID, err)
+		http.Error(w, "Error deleting account", http.StatusInternalServerError)
+		return
+	}
+
+	w.WriteHeader(http.StatusOK)
+}
+
+// DeprovisionUser tears down everything bootstrapStorageAccount created for the
+// user before erasing their rows, so a failed storage cleanup can be retried.
+func DeprovisionUser(ctx context.Context, userCtx UserContext) error {
+	if os.Getenv("S3_ENDPOINT") != "" {
+		if err := deleteStaticWebsite(userCtx.oauthID); err != nil {
+			return fmt.Errorf("failed to delete bucket: %w", err)
+		}
+	}
+
+	// embedded sites are rendered from the database rows deleted below
+	switch location := resolveStorage(userCtx.Subdomain); location.Mode {
+	case storageModeShared:
+		if err := deleteBlobsWithPrefix(location.Account, location.Prefix); err != nil {
+			return fmt.Errorf("failed to delete blobs: %w", err)
+		}
+	case storageModeDedicated:
+		if err := deprovisionStorageAccount(userCtx.Subdomain); err != nil {
+			return fmt.Errorf("failed to delete storage account: %w", err)
+		}
+	}
+
+	tx, err := pool.Begin(ctx)
+	if err != nil {
+		return fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer tx.Rollback(ctx)
+
+	_, err = tx.Exec(ctx, "delete from recipes where user_id = $1", userCtx.UserID)
+	if err != nil {
+		return fmt.Errorf("failed to delete recipes: %w", err)
+	}
+
+	_, err = tx.Exec(ctx, "delete from users where id = $1", userCtx.UserID)
+	if err != nil {
+		return fmt.Errorf("failed to delete user: %w", err)
+	}
+
+	if err = tx.Commit(ctx); err != nil {
+		return fmt.Errorf("failed to commit account deletion: %w", err)
+	}
+	appCache.Delete(ctx, userCacheKey(userCtx.oauthID))
+	invalidateRecipes(userCtx.UserID)
+	revokeSessionTokens(ctx, userCtx.UserID)
+
+	log.Printf("deleted account of user %d", userCtx.UserID)
+	return nil
+}
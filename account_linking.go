@@ -0,0 +1,435 @@
+package main
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+)
+
+// linkCodeTTL is how long a link code can be redeemed, it is typed in right
+// after logging in with the other provider.
+const linkCodeTTL = 15 * time.Minute
+
+// maxLinkCodeAttempts bounds the codes one account can try a day, a code has
+// 40 bits and would otherwise be guessed eventually.
+const maxLinkCodeAttempts = 10
+
+var (
+	errLinkCodeInvalid  = errors.New("link code is invalid or expired")
+	errLinkCodeAttempts = errors.New("too many link code attempts")
+)
+
+// Tables whose rows simply move to the kept user. Tables holding one row per
+// user only move when the kept user has none, usage counters and the record
+// of published blobs stay with the linked user and are deleted with it.
+// Categories, slug redirects and source archives are merged, see
+// linkAccounts.
+var (
+	linkedUserTables    = []string{"webhooks", "recipe_ask_sessions", "recipe_revisions", "meal_plan_entries", "api_keys", "audit_log", "cooking_sessions", "judge_rejections", "generations", "generation_feedback"}
+	linkedSettingTables = []string{"notification_settings", "user_openai_keys", "index_settings", "user_settings"}
+)
+
+type LinkCode struct {
+	Code      string    `json:"code"`
+	ExpiresAt time.Time `json:"expiresAt"`
+}
+
+type Identity struct {
+	OauthID  string     `json:"oauthID"`
+	Provider string     `json:"provider,omitempty"`
+	Primary  bool       `json:"primary"`
+	LinkedAt *time.Time `json:"linkedAt,omitempty"`
+}
+
+type LinkResult struct {
+	UserID    int    `json:"userID"`
+	Subdomain string `json:"subdomain"`
+	Recipes   int    `json:"recipes"`
+}
+
+func hashLinkCode(code string) string {
+	sum := sha256.Sum256([]byte(strings.ToLower(strings.TrimSpace(code))))
+	return hex.EncodeToString(sum[:])
+}
+
+// HandleCreateLinkCode starts linking on the account that is kept: the code
+// is redeemed with HandleLinkAccount while logged in with the other
+// provider. A new code replaces the previous one.
+func HandleCreateLinkCode(w http.ResponseWriter, r *http.Request) {
+	userCtx, ok := r.Context().Value("user").(UserContext)
+	if !ok {
+		http.Error(w, "Unauthorized: user context missing", http.StatusUnauthorized)
+		return
+	}
+
+	random := make([]byte, 5)
+	if _, err := rand.Read(random); err != nil {
+		log.Printf("Error generating link code: %v\n", err)
+		http.Error(w, "Error creating link code", http.StatusInternalServerError)
+		return
+	}
+	code := LinkCode{Code: hex.EncodeToString(random), ExpiresAt: time.Now().Add(linkCodeTTL)}
+
+	_, err := pool.Exec(r.Context(), `
+		INSERT INTO account_link_codes (user_id, code_hash, expires_at) VALUES ($1, $2, $3)
+		ON CONFLICT (user_id) DO UPDATE SET code_hash = $2, expires_at = $3`,
+		userCtx.UserID, hashLinkCode(code.Code), code.ExpiresAt)
+	if err != nil {
+		log.Printf("Error creating link code for user %d: %v\n", userCtx.UserID, err)
+		http.Error(w, "Error creating link code", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+
+	err = json.NewEncoder(w).Encode(code)
+	if err != nil {
+		http.Error(w, "Error encoding JSON response", http.StatusInternalServerError)
+	}
+}
+
+// HandleLinkAccount merges the logged-in account into the one that created
+// the code: its login, recipes, collections and history move over and its
+// own site is deleted. Logging in with either provider opens the kept
+// account afterwards.
+func HandleLinkAccount(w http.ResponseWriter, r *http.Request) {
+	userCtx, ok := r.Context().Value("user").(UserContext)
+	if !ok {
+		http.Error(w, "Unauthorized: user context missing", http.StatusUnauthorized)
+		return
+	}
+
+	var req struct {
+		Code string `json:"code"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil || strings.TrimSpace(req.Code) == "" {
+		http.Error(w, "Missing code", http.StatusBadRequest)
+		return
+	}
+
+	if err := countLinkCodeAttempt(r.Context(), userCtx.UserID); err != nil {
+		if errors.Is(err, errLinkCodeAttempts) {
+			http.Error(w, "Too many attempts, please try again tomorrow", http.StatusTooManyRequests)
+			return
+		}
+		log.Printf("Error counting link code attempts of user %d: %v\n", userCtx.UserID, err)
+		http.Error(w, "Error linking accounts", http.StatusInternalServerError)
+		return
+	}
+
+	kept, err := userForLinkCode(r.Context(), req.Code)
+	if errors.Is(err, errLinkCodeInvalid) {
+		http.Error(w, "The code is invalid or expired", http.StatusBadRequest)
+		return
+	}
+	if err != nil {
+		log.Printf("Error getting user for link code: %v\n", err)
+		http.Error(w, "Error linking accounts", http.StatusInternalServerError)
+		return
+	}
+	if kept.UserID == userCtx.UserID {
+		http.Error(w, "The code belongs to this account", http.StatusBadRequest)
+		return
+	}
+
+	slugs, err := linkAccounts(r.Context(), kept, userCtx)
+	if errors.Is(err, errRecipeLimit) {
+		http.Error(w, "Together the accounts have more recipes than your plan allows", http.StatusForbidden)
+		return
+	}
+	if err != nil {
+		log.Printf("Error linking user %d into user %d: %v\n", userCtx.UserID, kept.UserID, err)
+		http.Error(w, "Error linking accounts", http.StatusInternalServerError)
+		return
+	}
+	invalidateRecipes(kept.UserID)
+	appCache.Delete(r.Context(), userCacheKey(userCtx.oauthID))
+
+	// The rows are gone already, this takes down the linked account's site.
+	if err := DeprovisionUser(r.Context(), userCtx); err != nil {
+		log.Printf("Error deleting linked account of user %d: %v\n", userCtx.UserID, err)
+	}
+
+	recordAudit(r.Context(), kept, AuditEntry{
+		Action: auditAccountLinked,
+		After:  &AuditSummary{Title: userCtx.FullName, Detail: strings.TrimSpace(userCtx.Provider + " " + userCtx.oauthID)},
+	})
+
+	for _, slug := range slugs {
+		if err := publishRecipeBlob(kept.Subdomain, kept.UserID, slug); err != nil {
+			log.Printf("Error publishing recipe: %v\n", err)
+			http.Error(w, "Failed to publish recipe", http.StatusInternalServerError)
+			return
+		}
+	}
+	if err := templateRecipesBlob(kept.Subdomain, kept.UserID); err != nil {
+		log.Printf("Error updating recipe template: %v\n", err)
+		http.Error(w, "Failed to update recipe template", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+
+	err = json.NewEncoder(w).Encode(LinkResult{UserID: kept.UserID, Subdomain: kept.Subdomain, Recipes: len(slugs)})
+	if err != nil {
+		http.Error(w, "Error encoding JSON response", http.StatusInternalServerError)
+	}
+}
+
+// HandleListIdentities lists the logins that open the account.
+func HandleListIdentities(w http.ResponseWriter, r *http.Request) {
+	userCtx, ok := r.Context().Value("user").(UserContext)
+	if !ok {
+		http.Error(w, "Unauthorized: user context missing", http.StatusUnauthorized)
+		return
+	}
+
+	rows, err := pool.Query(r.Context(), `
+		SELECT oauth_id, coalesce(oauth_provider, ''), true, NULL::timestamptz FROM users WHERE id = $1
+		UNION ALL
+		SELECT oauth_id, provider, false, linked_at FROM user_identities WHERE user_id = $1`,
+		userCtx.UserID)
+	if err != nil {
+		log.Printf("Error listing identities of user %d: %v\n", userCtx.UserID, err)
+		http.Error(w, "Error listing identities", http.StatusInternalServerError)
+		return
+	}
+	defer rows.Close()
+
+	identities := []Identity{}
+	for rows.Next() {
+		var identity Identity
+		if err := rows.Scan(&identity.OauthID, &identity.Provider, &identity.Primary, &identity.LinkedAt); err != nil {
+			log.Printf("Error scanning identity: %v\n", err)
+			http.Error(w, "Error listing identities", http.StatusInternalServerError)
+			return
+		}
+		identities = append(identities, identity)
+	}
+	if err := rows.Err(); err != nil {
+		log.Printf("Error listing identities of user %d: %v\n", userCtx.UserID, err)
+		http.Error(w, "Error listing identities", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+
+	err = json.NewEncoder(w).Encode(identities)
+	if err != nil {
+		http.Error(w, "Error encoding JSON response", http.StatusInternalServerError)
+	}
+}
+
+// countLinkCodeAttempt counts a redemption and returns errLinkCodeAttempts
+// once the user tried maxLinkCodeAttempts codes today.
+func countLinkCodeAttempt(ctx context.Context, userID int) error {
+	var count int
+	err := pool.QueryRow(ctx, `
+		INSERT INTO link_code_attempts (user_id, day, count) VALUES ($1, current_date, 1)
+		ON CONFLICT (user_id) DO UPDATE SET
+			count = CASE WHEN link_code_attempts.day = current_date THEN link_code_attempts.count + 1 ELSE 1 END,
+			day = current_date
+		RETURNING count`, userID).Scan(&count)
+	if err != nil {
+		return err
+	}
+	if count > maxLinkCodeAttempts {
+		return errLinkCodeAttempts
+	}
+	return nil
+}
+
+func userForLinkCode(ctx context.Context, code string) (UserContext, error) {
+	var userCtx UserContext
+	err := pool.QueryRow(ctx, `
+		SELECT u.id, u.oauth_id, coalesce(u.name, ''), coalesce(u.email, ''), coalesce(u.oauth_provider, ''), u.subdomain
+		FROM account_link_codes c JOIN users u ON u.id = c.user_id
+		WHERE c.code_hash = $1 AND c.expires_at > now() AND u.provisioning_status = $2`,
+		hashLinkCode(code), provisioningReady).Scan(&userCtx.UserID, &userCtx.oauthID, &userCtx.FullName, &userCtx.Email, &userCtx.Provider,
+		&userCtx.Subdomain)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return UserContext{}, errLinkCodeInvalid
+	}
+	return userCtx, err
+}
+
+// linkAccounts moves the linked user's data to the kept user in one
+// transaction and returns the slugs of the moved recipes. Slugs that are
+// taken in the kept collection get a suffix, featured recipes are unpinned
+// so the limit holds. It returns errRecipeLimit when the recipes of both
+// don't fit the kept user's plan.
+func linkAccounts(ctx context.Context, kept UserContext, linked UserContext) ([]string, error) {
+	_, limits, err := GetUserPlan(ctx, kept.UserID)
+	if err != nil {
+		return nil, err
+	}
+
+	var moved []string
+	err = inTransaction(ctx, func(tx pgx.Tx) error {
+		// The code is used up, a second redeem fails here.
+		tag, err := tx.Exec(ctx, "DELETE FROM account_link_codes WHERE user_id = $1", kept.UserID)
+		if err != nil {
+			return err
+		}
+		if tag.RowsAffected() == 0 {
+			return errLinkCodeInvalid
+		}
+
+		if limits.MaxRecipes != 0 {
+			var count int
+			err := tx.QueryRow(ctx, "SELECT count(*) FROM recipes WHERE user_id = $1 OR user_id = $2", kept.UserID, linked.UserID).Scan(&count)
+			if err != nil {
+				return err
+			}
+			if count > limits.MaxRecipes {
+				return errRecipeLimit
+			}
+		}
+
+		if moved, err = moveSlugged(ctx, tx, "recipes", kept.UserID, linked.UserID, ", featured_at = NULL"); err != nil {
+			return fmt.Errorf("failed to move recipes: %w", err)
+		}
+		// The old slugs of the moved recipes keep redirecting, unless the
+		// kept user has a recipe or a redirect there.
+		_, err = tx.Exec(ctx, `
+			UPDATE slug_redirects s SET user_id = $1
+			WHERE user_id = $2
+			  AND NOT EXISTS (SELECT 1 FROM slug_redirects WHERE user_id = $1 AND old_slug = s.old_slug)
+			  AND NOT EXISTS (SELECT 1 FROM recipes WHERE user_id = $1 AND slug = s.old_slug)`,
+			kept.UserID, linked.UserID)
+		if err != nil {
+			return fmt.Errorf("failed to move slug_redirects: %w", err)
+		}
+		if _, err := moveSlugged(ctx, tx, "collections", kept.UserID, linked.UserID, ""); err != nil {
+			return fmt.Errorf("failed to move collections: %w", err)
+		}
+		for _, table := range linkedUserTables {
+			if _, err := tx.Exec(ctx, "UPDATE "+table+" SET user_id = $1 WHERE user_id = $2", kept.UserID, linked.UserID); err != nil {
+				return fmt.Errorf("failed to move %s: %w", table, err)
+			}
+		}
+		for _, table := range linkedSettingTables {
+			_, err := tx.Exec(ctx, "UPDATE "+table+" SET user_id = $1 WHERE user_id = $2 AND NOT EXISTS (SELECT 1 FROM "+table+" WHERE user_id = $1)",
+				kept.UserID, linked.UserID)
+			if err != nil {
+				return fmt.Errorf("failed to move %s: %w", table, err)
+			}
+		}
+		// custom categories of the linked user follow the kept user's own
+		_, err = tx.Exec(ctx, `
+			UPDATE categories c SET user_id = $1,
+				sort_order = c.sort_order + (SELECT coalesce(max(sort_order), 0) + 1 FROM categories WHERE user_id = $1)
+			WHERE user_id = $2 AND NOT EXISTS (SELECT 1 FROM categories WHERE user_id = $1 AND name = c.name)`,
+			kept.UserID, linked.UserID)
+		if err != nil {
+			return fmt.Errorf("failed to move categories: %w", err)
+		}
+		for _, table := range []string{"source_archives", "source_rechecks"} {
+			_, err = tx.Exec(ctx, `
+				UPDATE `+table+` s SET user_id = $1
+				WHERE user_id = $2 AND NOT EXISTS (SELECT 1 FROM `+table+` WHERE user_id = $1 AND url = s.url)`,
+				kept.UserID, linked.UserID)
+			if err != nil {
+				return fmt.Errorf("failed to move %s: %w", table, err)
+			}
+		}
+
+		if _, err := tx.Exec(ctx, "UPDATE user_identities SET user_id = $1 WHERE user_id = $2", kept.UserID, linked.UserID); err != nil {
+			return err
+		}
+		_, err = tx.Exec(ctx, "INSERT INTO user_identities (oauth_id, user_id, provider) VALUES ($1, $2, $3)",
+			linked.oauthID, kept.UserID, linked.Provider)
+		return err
+	})
+	return moved, err
+}
+
+// moveSlugged moves the rows of a table with per-user slugs and returns the
+// new slugs. Recipe slugs the kept user redirects are taken as well.
+func moveSlugged(ctx context.Context, tx pgx.Tx, table string, keptID int, linkedID int, set string) ([]string, error) {
+	_, keptSlugs, err := querySlugged(ctx, tx, table, keptID)
+	if err != nil {
+		return nil, err
+	}
+	if table == "recipes" {
+		redirected, err := userRedirectedSlugs(ctx, tx, keptID)
+		if err != nil {
+			return nil, err
+		}
+		keptSlugs = append(keptSlugs, redirected...)
+	}
+	taken := map[string]bool{}
+	for _, slug := range keptSlugs {
+		taken[slug] = true
+	}
+	ids, slugs, err := querySlugged(ctx, tx, table, linkedID)
+	if err != nil {
+		return nil, err
+	}
+
+	for i, id := range ids {
+		slug := slugs[i]
+		for n := 2; taken[slugs[i]]; n++ {
+			slugs[i] = slug + "-" + strconv.Itoa(n)
+		}
+		taken[slugs[i]] = true
+
+		if _, err := tx.Exec(ctx, "UPDATE "+table+" SET user_id = $1, slug = $2"+set+" WHERE id = $3", keptID, slugs[i], id); err != nil {
+			return nil, err
+		}
+	}
+	return slugs, nil
+}
+
+func userRedirectedSlugs(ctx context.Context, tx pgx.Tx, userID int) ([]string, error) {
+	rows, err := tx.Query(ctx, "SELECT old_slug FROM slug_redirects WHERE user_id = $1", userID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var slugs []string
+	for rows.Next() {
+		var slug string
+		if err := rows.Scan(&slug); err != nil {
+			return nil, err
+		}
+		slugs = append(slugs, slug)
+	}
+	return slugs, rows.Err()
+}
+
+func querySlugged(ctx context.Context, tx pgx.Tx, table string, userID int) ([]int, []string, error) {
+	rows, err := tx.Query(ctx, "SELECT id, slug FROM "+table+" WHERE user_id = $1 ORDER BY id", userID)
+	if err != nil {
+		return nil, nil, err
+	}
+	defer rows.Close()
+
+	var ids []int
+	var slugs []string
+	for rows.Next() {
+		var id int
+		var slug string
+		if err := rows.Scan(&id, &slug); err != nil {
+			return nil, nil, err
+		}
+		ids = append(ids, id)
+		slugs = append(slugs, slug)
+	}
+	return ids, slugs, rows.Err()
+}
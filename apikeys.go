@@ -0,0 +1,196 @@
+package main
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+)
+
+// apiKeyPrefix marks personal API keys so they aren't mistaken for JWTs.
+const apiKeyPrefix = "rk_"
+
+type APIKey struct {
+	ID         int64      `json:"id"`
+	Name       string     `json:"name"`
+	Hint       string     `json:"hint"`
+	Key        string     `json:"key,omitempty"`
+	CreatedAt  time.Time  `json:"createdAt"`
+	LastUsedAt *time.Time `json:"lastUsedAt,omitempty"`
+}
+
+func hashAPIKey(key string) string {
+	sum := sha256.Sum256([]byte(key))
+	return hex.EncodeToString(sum[:])
+}
+
+func HandleListAPIKeys(w http.ResponseWriter, r *http.Request) {
+	userCtx, ok := r.Context().Value("user").(UserContext)
+	if !ok {
+		http.Error(w, "Unauthorized: user context missing", http.StatusUnauthorized)
+		return
+	}
+
+	rows, err := pool.Query(r.Context(), "SELECT id, name, hint, created_at, last_used_at FROM api_keys WHERE user_id = $1 ORDER BY id",
+		userCtx.UserID)
+	if err != nil {
+		log.Printf("Error getting API keys: %v\n", err)
+		http.Error(w, "Error getting API keys", http.StatusInternalServerError)
+		return
+	}
+	defer rows.Close()
+
+	keys := []APIKey{}
+	for rows.Next() {
+		var key APIKey
+		if err := rows.Scan(&key.ID, &key.Name, &key.Hint, &key.CreatedAt, &key.LastUsedAt); err != nil {
+			log.Printf("Error scanning API key: %v\n", err)
+			http.Error(w, "Error getting API keys", http.StatusInternalServerError)
+			return
+		}
+		keys = append(keys, key)
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+
+	err = json.NewEncoder(w).Encode(keys)
+	if err != nil {
+		http.Error(w, "Error encoding JSON response", http.StatusInternalServerError)
+	}
+}
+
+// HandleAddAPIKey returns the key once, only its hash is stored.
+func HandleAddAPIKey(w http.ResponseWriter, r *http.Request) {
+	userCtx, ok := r.Context().Value("user").(UserContext)
+	if !ok {
+		http.Error(w, "Unauthorized: user context missing", http.StatusUnauthorized)
+		return
+	}
+
+	var req struct {
+		Name string `json:"name"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "Invalid JSON payload", http.StatusBadRequest)
+		return
+	}
+	req.Name = strings.TrimSpace(req.Name)
+	if req.Name == "" {
+		http.Error(w, "Missing name", http.StatusBadRequest)
+		return
+	}
+
+	random := make([]byte, 24)
+	if _, err := rand.Read(random); err != nil {
+		log.Printf("Error generating API key: %v\n", err)
+		http.Error(w, "Error adding API key", http.StatusInternalServerError)
+		return
+	}
+
+	key := APIKey{Name: req.Name, Key: apiKeyPrefix + hex.EncodeToString(random)}
+	key.Hint = keyHint(key.Key)
+	err := pool.QueryRow(r.Context(), "INSERT INTO api_keys (user_id, name, key_hash, hint) VALUES ($1, $2, $3, $4) RETURNING id, created_at",
+		userCtx.UserID, key.Name, hashAPIKey(key.Key), key.Hint).Scan(&key.ID, &key.CreatedAt)
+	if err != nil {
+		log.Printf("Error adding API key: %v\n", err)
+		http.Error(w, "Error adding API key", http.StatusInternalServerError)
+		return
+	}
+	recordAudit(r.Context(), userCtx, AuditEntry{Action: auditAPIKeyCreated, After: &AuditSummary{Title: key.Name, Detail: key.Hint}})
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+
+	err = json.NewEncoder(w).Encode(key)
+	if err != nil {
+		http.Error(w, "Error encoding JSON response", http.StatusInternalServerError)
+	}
+}
+
+func HandleDeleteAPIKey(w http.ResponseWriter, r *http.Request) {
+	userCtx, ok := r.Context().Value("user").(UserContext)
+	if !ok {
+		http.Error(w, "Unauthorized: user context missing", http.StatusUnauthorized)
+		return
+	}
+
+	id, err := strconv.ParseInt(r.PathValue("id"), 10, 64)
+	if err != nil {
+		http.Error(w, "Invalid API key ID", http.StatusBadRequest)
+		return
+	}
+
+	var name, hint string
+	err = pool.QueryRow(r.Context(), "DELETE FROM api_keys WHERE id = $1 AND user_id = $2 RETURNING name, hint", id, userCtx.UserID).Scan(&name, &hint)
+	if err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			http.Error(w, "API key not found", http.StatusNotFound)
+			return
+		}
+		log.Printf("Error deleting API key: %v\n", err)
+		http.Error(w, "Error deleting API key", http.StatusInternalServerError)
+		return
+	}
+	recordAudit(r.Context(), userCtx, AuditEntry{Action: auditAPIKeyDeleted, Before: &AuditSummary{Title: name, Detail: hint}})
+
+	w.WriteHeader(http.StatusOK)
+}
+
+// RequireAPIKey authenticates with a personal API key instead of an OAuth
+// token. Bookmarklets can't set headers, so the key may also come as ?key=.
+func RequireAPIKey(next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		key := r.URL.Query().Get("key")
+		if auth := r.Header.Get("Authorization"); strings.HasPrefix(auth, "Bearer "+apiKeyPrefix) {
+			key = strings.TrimPrefix(auth, "Bearer ")
+		}
+		if !strings.HasPrefix(key, apiKeyPrefix) {
+			http.Error(w, "Missing API key", http.StatusUnauthorized)
+			return
+		}
+
+		userCtx, err := userForAPIKey(r.Context(), key)
+		if err != nil {
+			if errors.Is(err, pgx.ErrNoRows) {
+				http.Error(w, "Invalid API key", http.StatusUnauthorized)
+				return
+			}
+			log.Printf("Error checking API key: %v\n", err)
+			http.Error(w, "Error checking API key", http.StatusInternalServerError)
+			return
+		}
+
+		ctx := context.WithValue(r.Context(), "user", userCtx)
+		next(w, r.WithContext(ctx))
+	}
+}
+
+func userForAPIKey(ctx context.Context, key string) (UserContext, error) {
+	var userCtx UserContext
+	var provisioningStatus string
+	userCtx.via = viaAPIKey
+	err := pool.QueryRow(ctx, `
+		UPDATE api_keys k SET last_used_at = now() FROM users u
+		WHERE k.key_hash = $1 AND u.id = k.user_id
+		RETURNING u.id, u.oauth_id, coalesce(u.name, ''), coalesce(u.email, ''), coalesce(u.oauth_provider, ''), u.subdomain, u.provisioning_status`,
+		hashAPIKey(key)).Scan(&userCtx.UserID, &userCtx.oauthID, &userCtx.FullName, &userCtx.Email, &userCtx.Provider,
+		&userCtx.Subdomain, &provisioningStatus)
+	if err != nil {
+		return UserContext{}, err
+	}
+	if provisioningStatus != provisioningReady {
+		return UserContext{}, fmt.Errorf("storage of user %d is not provisioned yet", userCtx.UserID)
+	}
+	return userCtx, nil
+}
@@ -0,0 +1,241 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"log"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/jackc/pgx/v5"
+)
+
+const applianceSystemMessage = "You rewrite cooking recipes written in markdown for a kitchen appliance. Rewrite the method for the appliance " +
+	"with its settings, times and temperatures, and adjust ingredients only where the appliance needs it. " +
+	"Keep the markdown structure and the language of the recipe. " +
+	`Answer with a JSON object {"title": "...", "recipe": "..."} holding the title and the full markdown of the rewritten recipe.`
+
+// Appliance names a kitchen appliance a recipe can be converted for, Label
+// is shown on the site and Prompt tells the model.
+type Appliance struct {
+	Label  string
+	Prompt string
+}
+
+var appliances = map[string]Appliance{
+	"air-fryer":       {Label: "Heißluftfritteuse", Prompt: "an air fryer (basket, temperature in °C, shaking or turning halfway)"},
+	"thermomix":       {Label: "Thermomix", Prompt: "a Thermomix (steps with time, temperature and speed, reverse mode where needed)"},
+	"slow-cooker":     {Label: "Slow Cooker", Prompt: "a slow cooker (low and high settings, hours of cooking)"},
+	"pressure-cooker": {Label: "Schnellkochtopf", Prompt: "an electric pressure cooker (pressure level, cooking time, natural or quick release)"},
+}
+
+type applianceRequest struct {
+	RecipeID  int    `json:"recipeID"`
+	Appliance string `json:"appliance"`
+	OvenSettings
+}
+
+// HandleConvertAppliance stores a copy of the recipe rewritten for an
+// appliance as a variant linked to the original. Converting for an
+// appliance again replaces the earlier variant, the original is never
+// touched.
+func HandleConvertAppliance(w http.ResponseWriter, r *http.Request) {
+	userCtx, ok := r.Context().Value("user").(UserContext)
+	if !ok {
+		http.Error(w, "Unauthorized: user context missing", http.StatusUnauthorized)
+		return
+	}
+
+	var req applianceRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "Invalid JSON payload", http.StatusBadRequest)
+		return
+	}
+	if req.RecipeID == 0 {
+		http.Error(w, "Missing or invalid recipeID", http.StatusBadRequest)
+		return
+	}
+	appliance, supported := appliances[req.Appliance]
+	if !supported {
+		names := make([]string, 0, len(appliances))
+		for name := range appliances {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+		http.Error(w, "appliance must be one of "+strings.Join(names, ", "), http.StatusBadRequest)
+		return
+	}
+	if reason := req.OvenSettings.validate(); reason != "" {
+		http.Error(w, reason, http.StatusBadRequest)
+		return
+	}
+
+	var original Recipe
+	err := pool.QueryRow(r.Context(), `
+		SELECT id, title, content, category, slug, coalesce(translation_of, 0), coalesce(variant_of, 0)
+		FROM recipes WHERE id = $1 AND user_id = $2`,
+		req.RecipeID, userCtx.UserID).Scan(&original.ID, &original.Recipename, &original.Recipe, &original.Category, &original.Slug,
+		&original.TranslationOf, &original.VariantOf)
+	if err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			http.Error(w, "Recipe not found", http.StatusNotFound)
+			return
+		}
+		log.Printf("Error getting recipe: %v\n", err)
+		http.Error(w, "Error getting recipe", http.StatusInternalServerError)
+		return
+	}
+	if original.TranslationOf != 0 || original.VariantOf != 0 {
+		http.Error(w, "Convert the original recipe instead of a translation or variant", http.StatusBadRequest)
+		return
+	}
+
+	var converted struct {
+		Title  string `json:"title"`
+		Recipe string `json:"recipe"`
+	}
+	err = completeJSON(r.Context(), llmRequest{
+		Task:   llmTaskAppliance,
+		System: applianceSystemMessage,
+		Prompt: "Rewrite this recipe for " + appliance.Prompt + ":\n\nTitle: " + original.Recipename + "\n\n" + adjustForOven(original.Recipe, req.OvenSettings),
+	}, &converted)
+	if err != nil || converted.Title == "" || converted.Recipe == "" {
+		log.Printf("Error converting recipe %d for %s: %v\n", original.ID, req.Appliance, err)
+		http.Error(w, "Error converting recipe", http.StatusInternalServerError)
+		return
+	}
+
+	variant := Recipe{
+		Recipename: converted.Title,
+		Recipe:     converted.Recipe,
+		Category:   original.Category,
+		VariantOf:  original.ID,
+		Appliance:  req.Appliance,
+	}
+	// the times change with the appliance, the original's don't apply
+	addTimingEstimate(r.Context(), &variant)
+
+	created, err := saveVariant(r.Context(), userCtx.UserID, &variant)
+	if err != nil {
+		log.Printf("Error saving %s variant of recipe %d: %v\n", req.Appliance, original.ID, err)
+		http.Error(w, "Error saving variant", http.StatusInternalServerError)
+		return
+	}
+
+	// The original is published again for its variant links.
+	for _, slug := range []string{variant.Slug, original.Slug} {
+		if err := publishRecipeBlob(userCtx.Subdomain, userCtx.UserID, slug); err != nil {
+			log.Printf("Error publishing recipe: %v\n", err)
+			http.Error(w, "Failed to publish recipe", http.StatusInternalServerError)
+			return
+		}
+	}
+	if err := templateRecipesBlob(userCtx.Subdomain, userCtx.UserID); err != nil {
+		log.Printf("Error updating recipe template: %v\n", err)
+		http.Error(w, "Failed to update recipe template", http.StatusInternalServerError)
+		return
+	}
+
+	after := recipeAuditSummary(variant.Recipename, variant.Category, variant.Version, variant.Recipe)
+	after.Detail = req.Appliance + " variant of recipe " + strconv.Itoa(original.ID)
+	recordAudit(r.Context(), userCtx, AuditEntry{Action: auditRecipeConverted, RecipeID: variant.ID, Slug: variant.Slug, After: after})
+
+	if created {
+		emitEvent(userCtx.UserID, eventRecipeCreated, variant)
+	} else {
+		emitEvent(userCtx.UserID, eventRecipeUpdated, variant)
+	}
+	variant.URL = recipeURL(userCtx.Subdomain, variant.Slug)
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+
+	err = json.NewEncoder(w).Encode(variant)
+	if err != nil {
+		http.Error(w, "Error encoding JSON response", http.StatusInternalServerError)
+	}
+}
+
+// saveVariant inserts the variant or replaces the one for the same
+// appliance, it reports whether a new recipe was created.
+func saveVariant(ctx context.Context, userID int, variant *Recipe) (bool, error) {
+	var existingID int
+	err := pool.QueryRow(ctx, "SELECT id FROM recipes WHERE user_id = $1 AND variant_of = $2 AND appliance = $3",
+		userID, variant.VariantOf, variant.Appliance).Scan(&existingID)
+	if err != nil && !errors.Is(err, pgx.ErrNoRows) {
+		return false, err
+	}
+
+	variant.Slug, err = uniqueRecipeSlug(ctx, userID, variant.Recipename, existingID)
+	if err != nil {
+		return false, err
+	}
+
+	if existingID != 0 {
+		err = pool.QueryRow(ctx, `
+			UPDATE recipes SET title = $1, content = $2, slug = $3, prep_minutes = $4, cook_minutes = $5, total_minutes = $6, difficulty = $7,
+			updated_at = now(), version = version + 1, diet = ''
+			WHERE id = $8 RETURNING id, version`,
+			variant.Recipename, variant.Recipe, variant.Slug, variant.PrepTime, variant.CookTime, variant.TotalTime, variant.Difficulty,
+			existingID).Scan(&variant.ID, &variant.Version)
+	} else {
+		err = pool.QueryRow(ctx, `
+			INSERT INTO recipes (user_id, title, content, category, slug, prep_minutes, cook_minutes, total_minutes, difficulty, variant_of, appliance, draft)
+			VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11, (SELECT draft FROM recipes WHERE id = $10)) RETURNING id, version`,
+			userID, variant.Recipename, variant.Recipe, variant.Category, variant.Slug, variant.PrepTime, variant.CookTime, variant.TotalTime,
+			variant.Difficulty, variant.VariantOf, variant.Appliance).Scan(&variant.ID, &variant.Version)
+	}
+	if err != nil {
+		return false, err
+	}
+	invalidateRecipes(userID)
+
+	return existingID == 0, nil
+}
+
+// variantLinks links the original and its appliance variants, empty when
+// there are none.
+func variantLinks(ctx context.Context, userID int, recipeID int, variantOf int) (string, error) {
+	originalID := recipeID
+	if variantOf != 0 {
+		originalID = variantOf
+	}
+
+	rows, err := pool.Query(ctx, `
+		SELECT slug, appliance, id = $2 FROM recipes WHERE user_id = $1 AND (id = $2 OR variant_of = $2) AND NOT archived AND NOT draft
+		ORDER BY variant_of NULLS FIRST, appliance`,
+		userID, originalID)
+	if err != nil {
+		return "", err
+	}
+	defer rows.Close()
+
+	var links []string
+	for rows.Next() {
+		var slug, appliance string
+		var isOriginal bool
+		if err := rows.Scan(&slug, &appliance, &isOriginal); err != nil {
+			return "", err
+		}
+
+		label := appliances[appliance].Label
+		if appliance == veganVariant {
+			label = "Vegan"
+		}
+		if isOriginal {
+			label = "Original"
+		}
+		links = append(links, "["+label+"](./?recipe="+slug+")")
+	}
+	if err := rows.Err(); err != nil {
+		return "", err
+	}
+
+	if len(links) < 2 {
+		return "", nil
+	}
+	return "🍳 " + strings.Join(links, " · "), nil
+}
@@ -0,0 +1,131 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"log"
+	"net/http"
+	"strconv"
+
+	"github.com/jackc/pgx/v5"
+)
+
+// HandleArchiveRecipe and HandleUnarchiveRecipe switch a recipe between the
+// collection and the archive. Archived recipes are kept for reference: they
+// are left off the published site, the list and search unless asked for,
+// and suggestions.
+func HandleArchiveRecipe(w http.ResponseWriter, r *http.Request) {
+	setRecipeArchived(w, r, true)
+}
+
+func HandleUnarchiveRecipe(w http.ResponseWriter, r *http.Request) {
+	setRecipeArchived(w, r, false)
+}
+
+func setRecipeArchived(w http.ResponseWriter, r *http.Request, archived bool) {
+	userCtx, ok := r.Context().Value("user").(UserContext)
+	if !ok {
+		http.Error(w, "Unauthorized: user context missing", http.StatusUnauthorized)
+		return
+	}
+
+	recipeID, err := strconv.Atoi(r.PathValue("id"))
+	if err != nil {
+		http.Error(w, "Invalid recipe ID", http.StatusBadRequest)
+		return
+	}
+
+	recipe := Recipe{ID: recipeID, Archived: archived}
+	err = pool.QueryRow(r.Context(), `
+		UPDATE recipes SET archived = $3, featured_at = CASE WHEN $3 THEN NULL ELSE featured_at END
+		WHERE id = $1 AND user_id = $2 RETURNING title, category, slug, version`,
+		recipeID, userCtx.UserID, archived).Scan(&recipe.Recipename, &recipe.Category, &recipe.Slug, &recipe.Version)
+	if err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			http.Error(w, "Recipe not found", http.StatusNotFound)
+			return
+		}
+		log.Printf("Error archiving recipe: %v\n", err)
+		http.Error(w, "Error archiving recipe", http.StatusInternalServerError)
+		return
+	}
+
+	// Translations follow their original, the language switcher would link
+	// them otherwise.
+	translations, err := archiveTranslations(r.Context(), userCtx.UserID, recipeID, archived)
+	if err != nil {
+		log.Printf("Error archiving translations of recipe %d: %v\n", recipeID, err)
+		http.Error(w, "Error archiving recipe", http.StatusInternalServerError)
+		return
+	}
+	invalidateRecipes(userCtx.UserID)
+
+	action := auditRecipeArchived
+	if !archived {
+		action = auditRecipeUnarchived
+	}
+	recordAudit(r.Context(), userCtx, AuditEntry{
+		Action:   action,
+		RecipeID: recipeID,
+		Slug:     recipe.Slug,
+		After:    &AuditSummary{Title: recipe.Recipename, Category: recipe.Category, Version: recipe.Version},
+	})
+
+	for _, slug := range append([]string{recipe.Slug}, translations...) {
+		if err := publishRecipeBlob(userCtx.Subdomain, userCtx.UserID, slug); err != nil {
+			// the index rows would keep the old state without the change
+			invalidateRecipes(userCtx.UserID)
+			log.Printf("Error publishing recipe: %v\n", err)
+			http.Error(w, "Failed to publish recipe", http.StatusInternalServerError)
+			return
+		}
+	}
+	if err := templateRecipesBlob(userCtx.Subdomain, userCtx.UserID); err != nil {
+		log.Printf("Error updating recipe template: %v\n", err)
+		http.Error(w, "Failed to update recipe template", http.StatusInternalServerError)
+		return
+	}
+	if !archived {
+		recipe.URL = recipeURL(userCtx.Subdomain, recipe.Slug)
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+
+	err = json.NewEncoder(w).Encode(recipe)
+	if err != nil {
+		http.Error(w, "Error encoding JSON response", http.StatusInternalServerError)
+	}
+}
+
+// archiveTranslations returns the slugs of the recipe's translations.
+func archiveTranslations(ctx context.Context, userID int, recipeID int, archived bool) ([]string, error) {
+	rows, err := pool.Query(ctx, "UPDATE recipes SET archived = $3 WHERE translation_of = $1 AND user_id = $2 RETURNING slug",
+		recipeID, userID, archived)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var slugs []string
+	for rows.Next() {
+		var slug string
+		if err := rows.Scan(&slug); err != nil {
+			return nil, err
+		}
+		slugs = append(slugs, slug)
+	}
+	return slugs, rows.Err()
+}
+
+// withoutArchived drops archived recipes from a loaded list.
+func withoutArchived(recipes []Recipe) []Recipe {
+	kept := []Recipe{}
+	for _, recipe := range recipes {
+		if !recipe.Archived {
+			kept = append(kept, recipe)
+		}
+	}
+	return kept
+}
@@ -0,0 +1,220 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/jackc/pgx/v5"
+)
+
+// maxAskHistory bounds how many earlier messages of a session are sent along
+// with a new question.
+const maxAskHistory = 20
+
+const askSystemMessage = "You answer questions about the recipe below. Base your answer on the recipe. " +
+	"If the recipe does not cover the question, say so and give general cooking advice, clearly marked as such. " +
+	"Answer briefly and in the language of the question.\n\nRecipe:\n"
+
+type AskMessage struct {
+	Role      string    `json:"role"`
+	Content   string    `json:"content"`
+	CreatedAt time.Time `json:"createdAt"`
+}
+
+func HandleAskRecipe(w http.ResponseWriter, r *http.Request) {
+	userCtx, ok := r.Context().Value("user").(UserContext)
+	if !ok {
+		http.Error(w, "Unauthorized: user context missing", http.StatusUnauthorized)
+		return
+	}
+
+	recipeID, err := strconv.Atoi(r.PathValue("id"))
+	if err != nil {
+		http.Error(w, "Invalid recipe ID", http.StatusBadRequest)
+		return
+	}
+
+	var req struct {
+		Question  string `json:"question"`
+		SessionID string `json:"sessionID"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "Invalid JSON payload", http.StatusBadRequest)
+		return
+	}
+
+	req.Question = strings.TrimSpace(req.Question)
+	if req.Question == "" {
+		http.Error(w, "Missing question", http.StatusBadRequest)
+		return
+	}
+
+	var title, content string
+	err = pool.QueryRow(r.Context(), "SELECT title, content FROM recipes WHERE id = $1 AND user_id = $2",
+		recipeID, userCtx.UserID).Scan(&title, &content)
+	if err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			http.Error(w, "Recipe not found", http.StatusNotFound)
+			return
+		}
+		log.Printf("Error getting recipe: %v\n", err)
+		http.Error(w, "Error getting recipe", http.StatusInternalServerError)
+		return
+	}
+
+	sessionID, history, err := askSession(r.Context(), userCtx.UserID, recipeID, req.SessionID)
+	if err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			http.Error(w, "Session not found", http.StatusNotFound)
+			return
+		}
+		log.Printf("Error getting ask session: %v\n", err)
+		http.Error(w, "Error getting session", http.StatusInternalServerError)
+		return
+	}
+
+	answer, err := llm.Complete(r.Context(), llmRequest{
+		Task:    llmTaskRecipeQA,
+		System:  askSystemMessage + "# " + title + "\n\n" + content,
+		History: history,
+		Prompt:  req.Question,
+	})
+	if err != nil {
+		log.Printf("Error answering question: %v\n", err)
+		http.Error(w, "Error answering question", http.StatusInternalServerError)
+		return
+	}
+
+	_, err = pool.Exec(r.Context(), `
+		INSERT INTO recipe_ask_messages (session_id, role, content) VALUES ($1, $2, $3), ($1, $4, $5)`,
+		sessionID, llmRoleUser, req.Question, llmRoleAssistant, answer)
+	if err != nil {
+		log.Printf("Error saving ask messages: %v\n", err)
+		http.Error(w, "Error saving conversation", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+
+	err = json.NewEncoder(w).Encode(map[string]string{
+		"sessionID": sessionID,
+		"answer":    answer,
+	})
+	if err != nil {
+		http.Error(w, "Error encoding JSON response", http.StatusInternalServerError)
+	}
+}
+
+func HandleGetAskSession(w http.ResponseWriter, r *http.Request) {
+	userCtx, ok := r.Context().Value("user").(UserContext)
+	if !ok {
+		http.Error(w, "Unauthorized: user context missing", http.StatusUnauthorized)
+		return
+	}
+
+	recipeID, err := strconv.Atoi(r.PathValue("id"))
+	if err != nil {
+		http.Error(w, "Invalid recipe ID", http.StatusBadRequest)
+		return
+	}
+
+	sessionID, err := uuid.Parse(r.PathValue("sessionID"))
+	if err != nil {
+		http.Error(w, "Invalid session ID", http.StatusBadRequest)
+		return
+	}
+
+	var exists bool
+	err = pool.QueryRow(r.Context(), "SELECT EXISTS (SELECT 1 FROM recipe_ask_sessions WHERE id = $1 AND user_id = $2 AND recipe_id = $3)",
+		sessionID, userCtx.UserID, recipeID).Scan(&exists)
+	if err != nil {
+		log.Printf("Error getting ask session: %v\n", err)
+		http.Error(w, "Error getting session", http.StatusInternalServerError)
+		return
+	}
+	if !exists {
+		http.Error(w, "Session not found", http.StatusNotFound)
+		return
+	}
+
+	rows, err := pool.Query(r.Context(), "SELECT role, content, created_at FROM recipe_ask_messages WHERE session_id = $1 ORDER BY id", sessionID)
+	if err != nil {
+		log.Printf("Error getting ask messages: %v\n", err)
+		http.Error(w, "Error getting session", http.StatusInternalServerError)
+		return
+	}
+	defer rows.Close()
+
+	messages := []AskMessage{}
+	for rows.Next() {
+		var message AskMessage
+		if err := rows.Scan(&message.Role, &message.Content, &message.CreatedAt); err != nil {
+			log.Printf("Error scanning ask message: %v\n", err)
+			http.Error(w, "Error getting session", http.StatusInternalServerError)
+			return
+		}
+		messages = append(messages, message)
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+
+	err = json.NewEncoder(w).Encode(messages)
+	if err != nil {
+		http.Error(w, "Error encoding JSON response", http.StatusInternalServerError)
+	}
+}
+
+// askSession starts a new session when sessionID is empty, otherwise it loads
+// the most recent messages of the user's session for that recipe.
+func askSession(ctx context.Context, userID int, recipeID int, sessionID string) (string, []llmMessage, error) {
+	if sessionID == "" {
+		var id uuid.UUID
+		err := pool.QueryRow(ctx, "INSERT INTO recipe_ask_sessions (user_id, recipe_id) VALUES ($1, $2) RETURNING id",
+			userID, recipeID).Scan(&id)
+		return id.String(), nil, err
+	}
+
+	id, err := uuid.Parse(sessionID)
+	if err != nil {
+		return "", nil, pgx.ErrNoRows
+	}
+
+	var exists bool
+	err = pool.QueryRow(ctx, "SELECT EXISTS (SELECT 1 FROM recipe_ask_sessions WHERE id = $1 AND user_id = $2 AND recipe_id = $3)",
+		id, userID, recipeID).Scan(&exists)
+	if err != nil {
+		return "", nil, err
+	}
+	if !exists {
+		return "", nil, pgx.ErrNoRows
+	}
+
+	rows, err := pool.Query(ctx, `
+		SELECT role, content FROM (
+			SELECT id, role, content FROM recipe_ask_messages WHERE session_id = $1 ORDER BY id DESC LIMIT $2
+		) recent ORDER BY id`, id, maxAskHistory)
+	if err != nil {
+		return "", nil, err
+	}
+	defer rows.Close()
+
+	var history []llmMessage
+	for rows.Next() {
+		var message llmMessage
+		if err := rows.Scan(&message.Role, &message.Content); err != nil {
+			return "", nil, err
+		}
+		history = append(history, message)
+	}
+
+	return id.String(), history, rows.Err()
+}
@@ -0,0 +1,416 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+)
+
+// Smart speaker webhooks for Alexa skills and Google Assistant actions. Users
+// link their account with the same OAuth provider as the frontend, the
+// platforms then send the user's access token with every request. Which
+// recipe and step the user is at travels in the platform's session
+// attributes, so any instance can answer any turn. ALEXA_SKILL_ID and
+// GOOGLE_ACTIONS_PROJECT_ID name the skill and the action, a platform
+// without one is not served.
+
+// Intents configured in the skill and action, Alexa's built-in intents are
+// mapped onto them.
+const (
+	intentLaunch      = "LaunchIntent"
+	intentIngredients = "ReadIngredientsIntent"
+	intentStart       = "StartCookingIntent"
+	intentNext        = "NextStepIntent"
+	intentPrevious    = "PreviousStepIntent"
+	intentRepeat      = "RepeatStepIntent"
+	intentHelp        = "HelpIntent"
+	intentStop        = "StopIntent"
+)
+
+var alexaBuiltinIntents = map[string]string{
+	"AMAZON.NextIntent":     intentNext,
+	"AMAZON.PreviousIntent": intentPrevious,
+	"AMAZON.RepeatIntent":   intentRepeat,
+	"AMAZON.HelpIntent":     intentHelp,
+	"AMAZON.StopIntent":     intentStop,
+	"AMAZON.CancelIntent":   intentStop,
+}
+
+// assistantState is kept by the platform between turns. Step counts from 0,
+// -1 means no step was read yet.
+type assistantState struct {
+	RecipeID int `json:"recipeID,omitempty"`
+	Step     int `json:"step"`
+}
+
+type assistantTurn struct {
+	Intent string
+	Recipe string
+	German bool
+	State  assistantState
+}
+
+type assistantReply struct {
+	Speech string
+	End    bool
+	State  assistantState
+}
+
+type alexaRequest struct {
+	Session struct {
+		Application struct {
+			ApplicationID string `json:"applicationId"`
+		} `json:"application"`
+		Attributes assistantState `json:"attributes"`
+		User       struct {
+			AccessToken string `json:"accessToken"`
+		} `json:"user"`
+	} `json:"session"`
+	Request struct {
+		Type      string `json:"type"`
+		Locale    string `json:"locale"`
+		Timestamp string `json:"timestamp"`
+		Intent    struct {
+			Name  string `json:"name"`
+			Slots map[string]struct {
+				Value string `json:"value"`
+			} `json:"slots"`
+		} `json:"intent"`
+	} `json:"request"`
+}
+
+type googleRequest struct {
+	Handler struct {
+		Name string `json:"name"`
+	} `json:"handler"`
+	Intent struct {
+		Params map[string]struct {
+			Original string `json:"original"`
+			Resolved string `json:"resolved"`
+		} `json:"params"`
+	} `json:"intent"`
+	Session struct {
+		ID     string         `json:"id"`
+		Params assistantState `json:"params"`
+	} `json:"session"`
+	User struct {
+		Locale string `json:"locale"`
+		Params struct {
+			BearerToken string `json:"bearerToken"`
+		} `json:"params"`
+	} `json:"user"`
+}
+
+// maxAssistantRequestBytes bounds the bodies, they are read whole to check
+// the signature.
+const maxAssistantRequestBytes = 64 << 10
+
+func HandleAlexa(w http.ResponseWriter, r *http.Request) {
+	skillID := os.Getenv("ALEXA_SKILL_ID")
+	if skillID == "" {
+		http.Error(w, "Alexa skill not configured", http.StatusNotFound)
+		return
+	}
+
+	body, err := io.ReadAll(http.MaxBytesReader(w, r.Body, maxAssistantRequestBytes))
+	if err != nil {
+		http.Error(w, "Error reading request body", http.StatusBadRequest)
+		return
+	}
+	err = verifyAlexaSignature(r.Header.Get("SignatureCertChainUrl"), r.Header.Get("Signature-256"), body)
+	if err != nil {
+		log.Printf("Rejected Alexa request: %v\n", err)
+		http.Error(w, "Invalid signature", http.StatusBadRequest)
+		return
+	}
+
+	var req alexaRequest
+	if err := json.Unmarshal(body, &req); err != nil {
+		http.Error(w, "Invalid JSON payload", http.StatusBadRequest)
+		return
+	}
+	if !recentAlexaTimestamp(req.Request.Timestamp, time.Now()) {
+		http.Error(w, "Request too old", http.StatusBadRequest)
+		return
+	}
+	if req.Session.Application.ApplicationID != skillID {
+		http.Error(w, "Unknown skill", http.StatusForbidden)
+		return
+	}
+
+	if req.Request.Type == "SessionEndedRequest" {
+		writeAssistantResponse(w, map[string]string{"version": "1.0"})
+		return
+	}
+
+	turn := assistantTurn{
+		Intent: intentLaunch,
+		German: strings.HasPrefix(req.Request.Locale, "de"),
+		State:  req.Session.Attributes,
+	}
+	if req.Request.Type == "IntentRequest" {
+		turn.Intent = req.Request.Intent.Name
+		if builtin, ok := alexaBuiltinIntents[turn.Intent]; ok {
+			turn.Intent = builtin
+		}
+		turn.Recipe = req.Request.Intent.Slots["recipe"].Value
+	}
+
+	if req.Session.User.AccessToken == "" {
+		writeAssistantResponse(w, map[string]interface{}{
+			"version": "1.0",
+			"response": map[string]interface{}{
+				"outputSpeech":     alexaSpeech(linkAccountSpeech(turn.German)),
+				"card":             map[string]string{"type": "LinkAccount"},
+				"shouldEndSession": true,
+			},
+		})
+		return
+	}
+
+	withAssistantUser(w, r, req.Session.User.AccessToken, func(w http.ResponseWriter, r *http.Request) {
+		userCtx := r.Context().Value("user").(UserContext)
+
+		reply, err := answerAssistant(r.Context(), userCtx, turn)
+		if err != nil {
+			log.Printf("Error answering Alexa request: %v\n", err)
+			reply = assistantReply{Speech: assistantErrorSpeech(turn.German), State: turn.State}
+		}
+
+		writeAssistantResponse(w, map[string]interface{}{
+			"version":           "1.0",
+			"sessionAttributes": reply.State,
+			"response": map[string]interface{}{
+				"outputSpeech":     alexaSpeech(reply.Speech),
+				"shouldEndSession": reply.End,
+			},
+		})
+	})
+}
+
+func HandleGoogleAssistant(w http.ResponseWriter, r *http.Request) {
+	projectID := os.Getenv("GOOGLE_ACTIONS_PROJECT_ID")
+	if projectID == "" {
+		http.Error(w, "Google Assistant action not configured", http.StatusNotFound)
+		return
+	}
+	err := verifyGoogleAssistantToken(r.Header.Get("Google-Assistant-Signature"), projectID)
+	if err != nil {
+		log.Printf("Rejected Google Assistant request: %v\n", err)
+		if errors.Is(err, errUnsignedAssistantRequest) {
+			http.Error(w, "Invalid signature", http.StatusUnauthorized)
+		} else {
+			http.Error(w, "Error verifying request", http.StatusInternalServerError)
+		}
+		return
+	}
+
+	r.Body = http.MaxBytesReader(w, r.Body, maxAssistantRequestBytes)
+	var req googleRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "Invalid JSON payload", http.StatusBadRequest)
+		return
+	}
+
+	turn := assistantTurn{
+		Intent: req.Handler.Name,
+		German: strings.HasPrefix(req.User.Locale, "de"),
+		State:  req.Session.Params,
+	}
+	if recipe, ok := req.Intent.Params["recipe"]; ok {
+		turn.Recipe = recipe.Resolved
+		if turn.Recipe == "" {
+			turn.Recipe = recipe.Original
+		}
+	}
+
+	respond := func(w http.ResponseWriter, reply assistantReply) {
+		resp := map[string]interface{}{
+			"session": map[string]interface{}{"id": req.Session.ID, "params": reply.State},
+			"prompt": map[string]interface{}{
+				"override":    false,
+				"firstSimple": map[string]string{"speech": reply.Speech, "text": reply.Speech},
+			},
+		}
+		if reply.End {
+			resp["scene"] = map[string]interface{}{"next": map[string]string{"name": "actions.scene.END_CONVERSATION"}}
+		}
+		writeAssistantResponse(w, resp)
+	}
+
+	if req.User.Params.BearerToken == "" {
+		respond(w, assistantReply{Speech: linkAccountSpeech(turn.German), End: true})
+		return
+	}
+
+	withAssistantUser(w, r, req.User.Params.BearerToken, func(w http.ResponseWriter, r *http.Request) {
+		userCtx := r.Context().Value("user").(UserContext)
+
+		reply, err := answerAssistant(r.Context(), userCtx, turn)
+		if err != nil {
+			log.Printf("Error answering Google Assistant request: %v\n", err)
+			reply = assistantReply{Speech: assistantErrorSpeech(turn.German), State: turn.State}
+		}
+		respond(w, reply)
+	})
+}
+
+// withAssistantUser authenticates the linked account's token like a frontend
+// request, the platforms send it in the body instead of a header.
+func withAssistantUser(w http.ResponseWriter, r *http.Request, token string, next http.HandlerFunc) {
+	r.Header.Set("Authorization", "Bearer "+token)
+	RequireAuth(LoginMiddleware(next))(w, r)
+}
+
+func answerAssistant(ctx context.Context, userCtx UserContext, turn assistantTurn) (assistantReply, error) {
+	german := turn.German
+	reply := assistantReply{State: turn.State}
+
+	switch turn.Intent {
+	case intentLaunch, "":
+		reply.Speech = say(german, "Welches Rezept möchtest du kochen? Sag zum Beispiel: Lies mir die Zutaten für Lasagne vor.",
+			"Which recipe do you want to cook? Say for example: read me the ingredients for lasagne.")
+		return reply, nil
+	case intentHelp:
+		reply.Speech = say(german, "Ich kann dir die Zutaten eines Rezepts vorlesen und dich Schritt für Schritt durch die Zubereitung führen. Sag weiter, zurück oder wiederholen.",
+			"I can read you the ingredients of a recipe and guide you through it step by step. Say next, back or repeat.")
+		return reply, nil
+	case intentStop:
+		reply.Speech = say(german, "Guten Appetit!", "Enjoy your meal!")
+		reply.End = true
+		return reply, nil
+	}
+
+	// Naming a recipe starts over with it, otherwise the one from the session
+	// is used.
+	if turn.Recipe != "" {
+		recipeID, err := findRecipeByTitle(ctx, userCtx.UserID, turn.Recipe)
+		if err != nil {
+			if errors.Is(err, pgx.ErrNoRows) {
+				reply.Speech = say(german, "Ich habe kein Rezept für "+turn.Recipe+" gefunden.",
+					"I couldn't find a recipe for "+turn.Recipe+".")
+				return reply, nil
+			}
+			return reply, err
+		}
+		reply.State = assistantState{RecipeID: recipeID, Step: -1}
+	}
+	if reply.State.RecipeID == 0 {
+		reply.Speech = say(german, "Welches Rezept meinst du?", "Which recipe do you mean?")
+		return reply, nil
+	}
+
+	var title, content string
+	err := pool.QueryRow(ctx, "SELECT title, content FROM recipes WHERE id = $1 AND user_id = $2",
+		reply.State.RecipeID, userCtx.UserID).Scan(&title, &content)
+	if err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			reply.State = assistantState{}
+			reply.Speech = say(german, "Das Rezept gibt es nicht mehr.", "That recipe no longer exists.")
+			return reply, nil
+		}
+		return reply, err
+	}
+	recipe := parseRecipeMarkdown(content)
+
+	switch turn.Intent {
+	case intentIngredients:
+		reply.Speech = ingredientsSpeech(title, recipe.Ingredients, german)
+		return reply, nil
+	case intentStart:
+		reply.State.Step = 0
+	case intentNext:
+		reply.State.Step++
+	case intentPrevious:
+		reply.State.Step = max(reply.State.Step-1, 0)
+	case intentRepeat:
+		reply.State.Step = max(reply.State.Step, 0)
+	default:
+		reply.Speech = say(german, "Das habe ich nicht verstanden.", "Sorry, I didn't get that.")
+		return reply, nil
+	}
+
+	if len(recipe.Steps) == 0 {
+		reply.Speech = say(german, "Das Rezept hat keine Schritte, die ich vorlesen kann.", "The recipe has no steps I can read.")
+		return reply, nil
+	}
+	if reply.State.Step >= len(recipe.Steps) {
+		reply.State.Step = len(recipe.Steps)
+		reply.Speech = say(german, "Das war der letzte Schritt. Guten Appetit!", "That was the last step. Enjoy your meal!")
+		reply.End = true
+		return reply, nil
+	}
+
+	step := recipe.Steps[reply.State.Step]
+	reply.Speech = say(german,
+		fmt.Sprintf("Schritt %d von %d: %s", reply.State.Step+1, len(recipe.Steps), step.Text),
+		fmt.Sprintf("Step %d of %d: %s", reply.State.Step+1, len(recipe.Steps), step.Text))
+	return reply, nil
+}
+
+// findRecipeByTitle prefers the shortest matching title, so "Lasagne" finds
+// "Lasagne" before "Vegetarische Lasagne".
+func findRecipeByTitle(ctx context.Context, userID int, title string) (int, error) {
+	pattern := "%" + strings.NewReplacer(`\`, `\\`, "%", `\%`, "_", `\_`).Replace(strings.TrimSpace(title)) + "%"
+
+	var id int
+	err := pool.QueryRow(ctx, "SELECT id FROM recipes WHERE user_id = $1 AND title ILIKE $2 ORDER BY length(title), id LIMIT 1",
+		userID, pattern).Scan(&id)
+	return id, err
+}
+
+func ingredientsSpeech(title string, ingredients []RecipeIngredient, german bool) string {
+	if len(ingredients) == 0 {
+		return say(german, "Für "+title+" habe ich keine Zutatenliste.", "I have no ingredient list for "+title+".")
+	}
+
+	items := make([]string, len(ingredients))
+	for i, ingredient := range ingredients {
+		items[i] = ingredient.String()
+	}
+	list := items[0]
+	if len(items) > 1 {
+		list = strings.Join(items[:len(items)-1], ", ") + say(german, " und ", " and ") + items[len(items)-1]
+	}
+
+	return say(german, "Für "+title+" brauchst du: "+list+". Sag starten, um mit dem ersten Schritt zu beginnen.",
+		"For "+title+" you need: "+list+". Say start to begin with the first step.")
+}
+
+func linkAccountSpeech(german bool) string {
+	return say(german, "Bitte verknüpfe zuerst dein Konto in der App.", "Please link your account in the app first.")
+}
+
+func assistantErrorSpeech(german bool) string {
+	return say(german, "Da ist etwas schiefgegangen, bitte versuch es noch einmal.", "Something went wrong, please try again.")
+}
+
+func alexaSpeech(text string) map[string]string {
+	return map[string]string{"type": "PlainText", "text": text}
+}
+
+func say(german bool, de string, en string) string {
+	if german {
+		return de
+	}
+	return en
+}
+
+func writeAssistantResponse(w http.ResponseWriter, resp interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+
+	err := json.NewEncoder(w).Encode(resp)
+	if err != nil {
+		log.Println("Error writing response:", err)
+	}
+}
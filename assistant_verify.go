@@ -0,0 +1,216 @@
+package main
+
+import (
+	"crypto"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"path"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/MicahParks/keyfunc"
+	"github.com/golang-jwt/jwt/v4"
+)
+
+// Both platforms sign their requests, the bearer token in the body alone
+// would let anyone who has it send intents for the user. Alexa requests are
+// checked as Amazon documents for skills hosted outside of Lambda: the
+// signing certificate must come from Amazon's bucket, chain to a trusted root
+// and be issued to echo-api.amazon.com, the body must match Signature-256 and
+// the timestamp must be recent. Google Assistant sends a JWT signed by Google
+// whose audience is the Actions project.
+
+const (
+	alexaCertHost        = "s3.amazonaws.com"
+	alexaCertPathPrefix  = "/echo.api/"
+	alexaCertSubjectName = "echo-api.amazon.com"
+
+	// alexaMaxRequestAge is the tolerance Amazon allows for the timestamp.
+	alexaMaxRequestAge = 150 * time.Second
+
+	googleAssistantIssuer = "https://accounts.google.com"
+	googleCertsURL        = "https://www.googleapis.com/oauth2/v3/certs"
+)
+
+var errUnsignedAssistantRequest = errors.New("assistant request signature invalid")
+
+// alexaCertClient fetches the certificate chains, they are on Amazon's S3.
+var alexaCertClient = publicHTTPClient(10 * time.Second)
+
+var (
+	alexaCertsMu sync.Mutex
+	// alexaCerts are the verified leaf certificates by chain URL, Amazon
+	// uses few of them and rotates them rarely.
+	alexaCerts = map[string]*x509.Certificate{}
+)
+
+// validAlexaCertURL applies Amazon's rules for SignatureCertChainUrl:
+// https on port 443 of s3.amazonaws.com, below /echo.api/ once the path is
+// normalized.
+func validAlexaCertURL(raw string) bool {
+	certURL, err := url.Parse(raw)
+	if err != nil || !strings.EqualFold(certURL.Scheme, "https") || !strings.EqualFold(certURL.Hostname(), alexaCertHost) {
+		return false
+	}
+	if port := certURL.Port(); port != "" && port != "443" {
+		return false
+	}
+	return strings.HasPrefix(path.Clean(certURL.Path), alexaCertPathPrefix)
+}
+
+// alexaSigningCert downloads and verifies the chain at certURL, a cached leaf
+// is checked for expiry again.
+func alexaSigningCert(certURL string) (*x509.Certificate, error) {
+	now := time.Now()
+	alexaCertsMu.Lock()
+	leaf, found := alexaCerts[certURL]
+	alexaCertsMu.Unlock()
+	if found && now.After(leaf.NotBefore) && now.Before(leaf.NotAfter) {
+		return leaf, nil
+	}
+
+	resp, err := alexaCertClient.Get(certURL)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("fetching Alexa certificate chain: %s", resp.Status)
+	}
+	data, err := io.ReadAll(io.LimitReader(resp.Body, 64<<10))
+	if err != nil {
+		return nil, err
+	}
+
+	var chain []*x509.Certificate
+	for {
+		var block *pem.Block
+		block, data = pem.Decode(data)
+		if block == nil {
+			break
+		}
+		if block.Type != "CERTIFICATE" {
+			continue
+		}
+		cert, err := x509.ParseCertificate(block.Bytes)
+		if err != nil {
+			return nil, err
+		}
+		chain = append(chain, cert)
+	}
+	if len(chain) == 0 {
+		return nil, errors.New("no certificate in the Alexa certificate chain")
+	}
+
+	intermediates := x509.NewCertPool()
+	for _, cert := range chain[1:] {
+		intermediates.AddCert(cert)
+	}
+	leaf = chain[0]
+	_, err = leaf.Verify(x509.VerifyOptions{
+		DNSName:       alexaCertSubjectName,
+		Intermediates: intermediates,
+		KeyUsages:     []x509.ExtKeyUsage{x509.ExtKeyUsageAny},
+	})
+	if err != nil {
+		return nil, err
+	}
+	if _, ok := leaf.PublicKey.(*rsa.PublicKey); !ok {
+		return nil, errors.New("Alexa signing certificate has no RSA key")
+	}
+
+	alexaCertsMu.Lock()
+	alexaCerts[certURL] = leaf
+	alexaCertsMu.Unlock()
+	return leaf, nil
+}
+
+// verifyAlexaSignature checks the Signature-256 header over the raw body.
+func verifyAlexaSignature(certURL string, signature string, body []byte) error {
+	if !validAlexaCertURL(certURL) {
+		return fmt.Errorf("%w: certificate URL %q", errUnsignedAssistantRequest, certURL)
+	}
+	sig, err := base64.StdEncoding.DecodeString(signature)
+	if err != nil || len(sig) == 0 {
+		return fmt.Errorf("%w: missing or malformed signature", errUnsignedAssistantRequest)
+	}
+	leaf, err := alexaSigningCert(certURL)
+	if err != nil {
+		return fmt.Errorf("%w: %v", errUnsignedAssistantRequest, err)
+	}
+
+	digest := sha256.Sum256(body)
+	if err := rsa.VerifyPKCS1v15(leaf.PublicKey.(*rsa.PublicKey), crypto.SHA256, digest[:], sig); err != nil {
+		return fmt.Errorf("%w: %v", errUnsignedAssistantRequest, err)
+	}
+	return nil
+}
+
+// recentAlexaTimestamp rejects replays of old requests.
+func recentAlexaTimestamp(timestamp string, now time.Time) bool {
+	sent, err := time.Parse(time.RFC3339, timestamp)
+	if err != nil {
+		return false
+	}
+	age := now.Sub(sent)
+	return age <= alexaMaxRequestAge && age >= -alexaMaxRequestAge
+}
+
+var (
+	googleJWKSMu sync.Mutex
+	googleJWKS   *keyfunc.JWKS
+)
+
+// googleKeys fetches Google's keys on the first request, deployments without
+// an action never fetch them. A failed fetch is tried again on the next one.
+func googleKeys() (*keyfunc.JWKS, error) {
+	googleJWKSMu.Lock()
+	defer googleJWKSMu.Unlock()
+	if googleJWKS != nil {
+		return googleJWKS, nil
+	}
+	keys, err := keyfunc.Get(googleCertsURL, keyfunc.Options{
+		RefreshInterval:   time.Hour,
+		RefreshUnknownKID: true,
+	})
+	if err != nil {
+		return nil, err
+	}
+	googleJWKS = keys
+	return keys, nil
+}
+
+// verifyGoogleAssistantToken checks the Google-Assistant-Signature JWT.
+func verifyGoogleAssistantToken(tokenStr string, projectID string) error {
+	if tokenStr == "" {
+		return fmt.Errorf("%w: missing Google-Assistant-Signature", errUnsignedAssistantRequest)
+	}
+	keys, err := googleKeys()
+	if err != nil {
+		return fmt.Errorf("fetching Google certificates: %w", err)
+	}
+
+	var claims jwt.RegisteredClaims
+	_, err = jwt.ParseWithClaims(tokenStr, &claims, func(token *jwt.Token) (interface{}, error) {
+		if _, ok := token.Method.(*jwt.SigningMethodRSA); !ok {
+			return nil, fmt.Errorf("unexpected signing method %v", token.Header["alg"])
+		}
+		return keys.Keyfunc(token)
+	})
+	if err != nil {
+		return fmt.Errorf("%w: %v", errUnsignedAssistantRequest, err)
+	}
+	if claims.Issuer != googleAssistantIssuer || !claims.VerifyAudience(projectID, true) {
+		return fmt.Errorf("%w: token for another project", errUnsignedAssistantRequest)
+	}
+	return nil
+}
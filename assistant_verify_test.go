@@ -0,0 +1,87 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+// Amazon's examples of valid and invalid SignatureCertChainUrl values.
+func TestValidAlexaCertURL(t *testing.T) {
+	tests := map[string]bool{
+		"https://s3.amazonaws.com/echo.api/echo-api-cert.pem":             true,
+		"https://s3.amazonaws.com:443/echo.api/echo-api-cert.pem":         true,
+		"https://s3.amazonaws.com/echo.api/../echo.api/echo-api-cert.pem": true,
+		"HTTPS://s3.AmazonAWS.com/echo.api/echo-api-cert.pem":             true,
+		"http://s3.amazonaws.com/echo.api/echo-api-cert.pem":              false,
+		"https://notamazon.com/echo.api/echo-api-cert.pem":                false,
+		"https://s3.amazonaws.com/EcHo.aPi/echo-api-cert.pem":             false,
+		"https://s3.amazonaws.com/invalid.path/echo-api-cert.pem":         false,
+		"https://s3.amazonaws.com/echo.api/../invalid.path/cert.pem":      false,
+		"https://s3.amazonaws.com:563/echo.api/echo-api-cert.pem":         false,
+		"": false,
+	}
+	for raw, want := range tests {
+		if got := validAlexaCertURL(raw); got != want {
+			t.Errorf("validAlexaCertURL(%q) = %v, want %v", raw, got, want)
+		}
+	}
+}
+
+func TestRecentAlexaTimestamp(t *testing.T) {
+	now := time.Date(2026, 10, 14, 12, 0, 0, 0, time.UTC)
+	tests := map[string]bool{
+		"2026-10-14T12:00:00Z": true,
+		"2026-10-14T11:58:00Z": true,
+		"2026-10-14T11:57:00Z": false,
+		"2026-10-14T12:03:00Z": false,
+		"yesterday":            false,
+		"":                     false,
+	}
+	for timestamp, want := range tests {
+		if got := recentAlexaTimestamp(timestamp, now); got != want {
+			t.Errorf("recentAlexaTimestamp(%q) = %v, want %v", timestamp, got, want)
+		}
+	}
+}
+
+func TestAssistantRejectsUnsignedRequests(t *testing.T) {
+	t.Setenv("ALEXA_SKILL_ID", "amzn1.ask.skill.test")
+	t.Setenv("GOOGLE_ACTIONS_PROJECT_ID", "recipes-test")
+
+	body := `{"session":{"application":{"applicationId":"amzn1.ask.skill.test"},"user":{"accessToken":"x"}},"request":{"type":"LaunchRequest","timestamp":"` +
+		time.Now().UTC().Format(time.RFC3339) + `"}}`
+	req := httptest.NewRequest(http.MethodPost, "/api/v1/assistant/alexa", strings.NewReader(body))
+	req.Header.Set("SignatureCertChainUrl", "https://attacker.example.com/echo.api/cert.pem")
+	req.Header.Set("Signature-256", "c2lnbmF0dXJl")
+	rec := httptest.NewRecorder()
+	HandleAlexa(rec, req)
+	if rec.Code != http.StatusBadRequest {
+		t.Errorf("Alexa request with a foreign certificate: got %d, want %d", rec.Code, http.StatusBadRequest)
+	}
+
+	req = httptest.NewRequest(http.MethodPost, "/api/v1/assistant/google", strings.NewReader(`{}`))
+	rec = httptest.NewRecorder()
+	HandleGoogleAssistant(rec, req)
+	if rec.Code != http.StatusUnauthorized {
+		t.Errorf("Google request without a signature: got %d, want %d", rec.Code, http.StatusUnauthorized)
+	}
+}
+
+func TestAssistantRequiresConfiguration(t *testing.T) {
+	t.Setenv("ALEXA_SKILL_ID", "")
+	t.Setenv("GOOGLE_ACTIONS_PROJECT_ID", "")
+
+	for path, handler := range map[string]http.HandlerFunc{
+		"/api/v1/assistant/alexa":  HandleAlexa,
+		"/api/v1/assistant/google": HandleGoogleAssistant,
+	} {
+		rec := httptest.NewRecorder()
+		handler(rec, httptest.NewRequest(http.MethodPost, path, strings.NewReader(`{}`)))
+		if rec.Code != http.StatusNotFound {
+			t.Errorf("%s without configuration: got %d, want %d", path, rec.Code, http.StatusNotFound)
+		}
+	}
+}
@@ -0,0 +1,179 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"log"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+const (
+	auditRecipeCreated     = "recipe.created"
+	auditRecipeImported    = "recipe.imported"
+	auditRecipeUpdated     = "recipe.updated"
+	auditRecipeDeleted     = "recipe.deleted"
+	auditRecipeTranslated  = "recipe.translated"
+	auditRecipeConverted   = "recipe.converted"
+	auditRecipeArchived    = "recipe.archived"
+	auditRecipeUnarchived  = "recipe.unarchived"
+	auditRecipePublished   = "recipe.published"
+	auditRecipeMerged      = "recipe.merged"
+	auditRecipeFeatured    = "recipe.featured"
+	auditRecipeUnfeatured  = "recipe.unfeatured"
+	auditRecipeShared      = "recipe.shared"
+	auditRecipeUnshared    = "recipe.unshared"
+	auditRevisionAccepted  = "revision.accepted"
+	auditCollectionCreated = "collection.created"
+	auditCollectionUpdated = "collection.updated"
+	auditCollectionDeleted = "collection.deleted"
+	auditAPIKeyCreated     = "api-key.created"
+	auditAccountLinked     = "account.linked"
+	auditAPIKeyDeleted     = "api-key.deleted"
+	auditWebhookCreated    = "webhook.created"
+	auditWebhookDeleted    = "webhook.deleted"
+)
+
+// Ways a request can be authenticated, kept in UserContext.via. The zero
+// value is the OAuth login of the web app.
+const (
+	viaOAuth  = ""
+	viaAPIKey = "api-key"
+	viaEmail  = "email"
+	viaMCP    = "mcp"
+)
+
+const maxAuditPage = 200
+
+// AuditSummary is the part of a recipe worth showing before and after a
+// change, the content itself is kept in the recipe and its revisions.
+type AuditSummary struct {
+	Title       string `json:"title,omitempty"`
+	Category    string `json:"category,omitempty"`
+	Version     int    `json:"version,omitempty"`
+	Ingredients int    `json:"ingredients,omitempty"`
+	Detail      string `json:"detail,omitempty"`
+}
+
+type AuditEntry struct {
+	ID       int64         `json:"id"`
+	Actor    string        `json:"actor"`
+	Via      string        `json:"via"`
+	Action   string        `json:"action"`
+	RecipeID int           `json:"recipeID,omitempty"`
+	Slug     string        `json:"slug,omitempty"`
+	Before   *AuditSummary `json:"before,omitempty"`
+	After    *AuditSummary `json:"after,omitempty"`
+	// ClientIP is the address the change came from, empty for changes made
+	// in the background.
+	ClientIP  string    `json:"clientIP,omitempty"`
+	CreatedAt time.Time `json:"createdAt"`
+}
+
+func recipeAuditSummary(title string, category string, version int, content string) *AuditSummary {
+	return &AuditSummary{
+		Title:       title,
+		Category:    category,
+		Version:     version,
+		Ingredients: len(extractIngredientLines(content)),
+	}
+}
+
+// recordAudit writes the entry for the acting user. A failed write is
+// logged, it must not undo a change that already happened.
+func recordAudit(ctx context.Context, userCtx UserContext, entry AuditEntry) {
+	via := userCtx.via
+	if via == viaOAuth {
+		via = "oauth"
+	}
+	actor := userCtx.Email
+	if actor == "" {
+		actor = "user " + strconv.Itoa(userCtx.UserID)
+	}
+
+	var recipeID *int
+	if entry.RecipeID != 0 {
+		recipeID = &entry.RecipeID
+	}
+
+	_, err := pool.Exec(ctx, `
+		INSERT INTO audit_log (user_id, actor, via, action, recipe_id, slug, before, after, client_ip)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9)`,
+		userCtx.UserID, actor, via, entry.Action, recipeID, entry.Slug, entry.Before, entry.After, clientIP(ctx))
+	if err != nil {
+		log.Printf("Error recording audit entry %s for user %d: %v\n", entry.Action, userCtx.UserID, err)
+	}
+}
+
+// HandleGetAudit lists the newest entries first, ?before= takes the last ID
+// of the previous page.
+func HandleGetAudit(w http.ResponseWriter, r *http.Request) {
+	userCtx, ok := r.Context().Value("user").(UserContext)
+	if !ok {
+		http.Error(w, "Unauthorized: user context missing", http.StatusUnauthorized)
+		return
+	}
+
+	limit := 50
+	if value := r.URL.Query().Get("limit"); value != "" {
+		parsed, err := strconv.Atoi(value)
+		if err != nil || parsed <= 0 {
+			http.Error(w, "limit must be a positive number", http.StatusBadRequest)
+			return
+		}
+		limit = min(parsed, maxAuditPage)
+	}
+
+	var before int64
+	if value := r.URL.Query().Get("before"); value != "" {
+		parsed, err := strconv.ParseInt(value, 10, 64)
+		if err != nil {
+			http.Error(w, "Invalid before ID", http.StatusBadRequest)
+			return
+		}
+		before = parsed
+	}
+
+	var recipeID int
+	if value := r.URL.Query().Get("recipeID"); value != "" {
+		parsed, err := strconv.Atoi(value)
+		if err != nil {
+			http.Error(w, "Invalid recipe ID", http.StatusBadRequest)
+			return
+		}
+		recipeID = parsed
+	}
+
+	rows, err := pool.Query(r.Context(), `
+		SELECT id, actor, via, action, coalesce(recipe_id, 0), slug, before, after, client_ip, created_at FROM audit_log
+		WHERE user_id = $1 AND ($2 = 0 OR id < $2) AND ($3 = 0 OR recipe_id = $3)
+		ORDER BY id DESC LIMIT $4`,
+		userCtx.UserID, before, recipeID, limit)
+	if err != nil {
+		log.Printf("Error getting audit log: %v\n", err)
+		http.Error(w, "Error getting audit log", http.StatusInternalServerError)
+		return
+	}
+	defer rows.Close()
+
+	entries := []AuditEntry{}
+	for rows.Next() {
+		var entry AuditEntry
+		if err := rows.Scan(&entry.ID, &entry.Actor, &entry.Via, &entry.Action, &entry.RecipeID, &entry.Slug,
+			&entry.Before, &entry.After, &entry.ClientIP, &entry.CreatedAt); err != nil {
+			log.Printf("Error scanning audit entry: %v\n", err)
+			http.Error(w, "Error getting audit log", http.StatusInternalServerError)
+			return
+		}
+		entries = append(entries, entry)
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+
+	err = json.NewEncoder(w).Encode(entries)
+	if err != nil {
+		http.Error(w, "Error encoding JSON response", http.StatusInternalServerError)
+	}
+}
@@ -32,7 +32,7 @@ var (
 	accountsClient *armstorage.AccountsClient
 )
 
-func bootstrapStorageAccount(storageAccountName string, userid string) error {
+func initAccountsClient() error {
 	subscriptionID = os.Getenv("AZURE_SUBSCRIPTION_ID")
 	if len(subscriptionID) == 0 {
 		log.Println("AZURE_SUBSCRIPTION_ID is not set")
@@ -44,7 +44,6 @@ func bootstrapStorageAccount(storageAccountName string, userid string) error {
 		log.Printf("failed to obtain a credential: %v", err)
 		return err
 	}
-	ctx := context.Background()
 
 	storageClientFactory, err = armstorage.NewClientFactory(subscriptionID, cred, nil)
 	if err != nil {
@@ -53,6 +52,24 @@ func bootstrapStorageAccount(storageAccountName string, userid string) error {
 	}
 	accountsClient = storageClientFactory.NewAccountsClient()
 
+	return nil
+}
+
+// ensureStorageAccount creates the storage account unless a previous,
+// interrupted provisioning run already did.
+func ensureStorageAccount(storageAccountName string) error {
+	err := initAccountsClient()
+	if err != nil {
+		return err
+	}
+	ctx := context.Background()
+
+	_, err = storageAccountProperties(ctx, storageAccountName)
+	if err == nil {
+		log.Printf("storage account %s already exists", storageAccountName)
+		return nil
+	}
+
 	availability, err := checkNameAvailability(ctx, storageAccountName)
 	if err != nil {
 		log.Printf("error checking name availability: %v", err)
@@ -70,30 +87,25 @@ func bootstrapStorageAccount(storageAccountName string, userid string) error {
 	}
 	log.Println("storage account:", *storageAccount.ID)
 
-	err = assignBlobDataContributorRole(storageAccountName)
-	if err != nil {
-		log.Printf("error assigning role: %v", err)
-		return err
-	}
+	return nil
+}
 
-	_, err = storageAccountProperties(ctx, storageAccountName)
+func tagStorageAccount(storageAccountName string, userid string) error {
+	err := initAccountsClient()
 	if err != nil {
-		log.Printf("error getting storage account properties: %v", err)
 		return err
 	}
 
-	_, err = updateStorageAccount(ctx, storageAccountName, userid)
+	_, err = updateStorageAccount(context.Background(), storageAccountName, userid)
 	if err != nil {
 		log.Printf("error updating storage account: %v for user: %v", err, userid)
 		return err
 	}
 
-	err = enableStaticWebsite(storageAccountName)
-	if err != nil {
-		log.Printf("error enabling static website: %v", err)
-		return err
-	}
+	return nil
+}
 
+func copyDefaultWebsiteFiles(storageAccountName string) error {
 	filesToCopy := []string{
 		"index.html",
 		"libs/markdown-it.min.js",
@@ -347,8 +359,94 @@ func assignBlobDataContributorRole(storageAccountName string) error {
 		nil)
 
 	if err != nil {
+		if strings.Contains(err.Error(), "RoleAssignmentExists") {
+			return nil
+		}
 		return fmt.Errorf("failed to assign role: %v", err)
 	}
 
 	return nil
 }
+
+func deprovisionStorageAccount(storageAccountName string) error {
+	err := removeBlobDataContributorRole(storageAccountName)
+	if err != nil {
+		log.Printf("error removing role assignment: %v", err)
+		return err
+	}
+
+	err = initAccountsClient()
+	if err != nil {
+		return err
+	}
+
+	_, err = accountsClient.Delete(context.Background(), resourceGroupName, storageAccountName, nil)
+	if err != nil {
+		log.Printf("error deleting storage account %s: %v", storageAccountName, err)
+		return err
+	}
+	log.Println("deleted storage account:", storageAccountName)
+
+	return nil
+}
+
+func removeBlobDataContributorRole(storageAccountName string) error {
+	ctx := context.Background()
+	subscriptionID := os.Getenv("AZURE_SUBSCRIPTION_ID")
+	principalID := os.Getenv("AZURE_OBJECT_ID")
+
+	if subscriptionID == "" || principalID == "" {
+		return fmt.Errorf("missing AZURE_SUBSCRIPTION_ID or AZURE_OBJECT_ID")
+	}
+
+	cred, err := azidentity.NewDefaultAzureCredential(nil)
+	if err != nil {
+		return fmt.Errorf("failed to get credentials: %v", err)
+	}
+
+	clientFactory, err := armauthorization.NewClientFactory(subscriptionID, cred, nil)
+	if err != nil {
+		log.Printf("failed to create client factory: %v", err)
+		return err
+	}
+
+	roleAssignmentsClient := clientFactory.NewRoleAssignmentsClient()
+
+	scope := fmt.Sprintf(
+		"/subscriptions/%s/resourceGroups/%s/providers/Microsoft.Storage/storageAccounts/%s",
+		subscriptionID,
+		"recipe-generator",
+		storageAccountName,
+	)
+
+	// the filter also returns assignments above the scope, only the ones created
+	// for this storage account in bootstrapStorageAccount may be removed
+	pager := roleAssignmentsClient.NewListForScopePager(scope, &armauthorization.RoleAssignmentsClientListForScopeOptions{
+		Filter: to.Ptr(fmt.Sprintf("principalId eq '%s'", principalID)),
+	})
+	for pager.More() {
+		page, err := pager.NextPage(ctx)
+		if err != nil {
+			if strings.Contains(err.Error(), "ResourceNotFound") {
+				return nil
+			}
+			return fmt.Errorf("failed to list role assignments: %v", err)
+		}
+
+		for _, assignment := range page.Value {
+			if assignment.ID == nil || assignment.Properties == nil || assignment.Properties.Scope == nil {
+				continue
+			}
+			if !strings.EqualFold(*assignment.Properties.Scope, scope) {
+				continue
+			}
+
+			_, err = roleAssignmentsClient.DeleteByID(ctx, *assignment.ID, nil)
+			if err != nil {
+				return fmt.Errorf("failed to delete role assignment %s: %v", *assignment.ID, err)
+			}
+		}
+	}
+
+	return nil
+}
@@ -5,13 +5,20 @@ package main
 
 import (
 	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
 	"fmt"
 	"log"
+	"strings"
+	"time"
 
 	"github.com/Azure/azure-sdk-for-go/sdk/azcore/to"
 	"github.com/Azure/azure-sdk-for-go/sdk/azidentity"
 	"github.com/Azure/azure-sdk-for-go/sdk/storage/azblob"
+	"github.com/Azure/azure-sdk-for-go/sdk/storage/azblob/sas"
 	"github.com/Azure/azure-sdk-for-go/sdk/storage/azblob/service"
+	"github.com/jackc/pgx/v5"
 )
 
 func listAzureStorage() {
@@ -60,21 +67,203 @@ func blobstorageClient(storageAccountName string) (*azblob.Client, error) {
 	return client, nil
 }
 
+// addBlob skips the upload when the blob already holds the same content, which
+// saves a write transaction on every unchanged recipes.md re-render. The
+// hash covers the headers too, blobs uploaded before they changed are
+// uploaded again once.
 func addBlob(storageAccountName string, blob string, content string) error {
+	location := resolveStorage(storageAccountName)
+	if location.Mode == storageModeEmbedded {
+		return nil
+	}
+	ctx := context.Background()
+
+	headers := blobHTTPHeaders(blob, content)
+	sum := sha256.Sum256([]byte(*headers.BlobContentType + "\n" + *headers.BlobCacheControl + "\n" + content))
+	contentHash := hex.EncodeToString(sum[:])
+
+	var storedHash string
+	err := pool.QueryRow(ctx, `
+		SELECT p.content_hash FROM published_blobs p JOIN users u ON u.id = p.user_id
+		WHERE u.subdomain = $1 AND p.blob = $2`, storageAccountName, blob).Scan(&storedHash)
+	if err != nil && !errors.Is(err, pgx.ErrNoRows) {
+		log.Printf("Failed to look up hash of blob %s: %v", blob, err)
+	}
+	if storedHash == contentHash {
+		return nil
+	}
+
+	var tags map[string]string
+	if strings.HasPrefix(blob, "photos/") {
+		tags = map[string]string{photoBlobTag: photoBlobTagValue}
+	}
+	// the static website serves the stored type, print pages must be HTML
+	err = siteBlobs.Upload(ctx, location, blob, []byte(content), headers, tags)
+	if err != nil {
+		log.Printf("Failed to upload blob: %v", err)
+		return fmt.Errorf("failed to upload %s: %w", blob, err)
+	}
+
+	_, err = pool.Exec(ctx, `
+		INSERT INTO published_blobs (user_id, blob, content_hash) SELECT id, $2, $3 FROM users WHERE subdomain = $1
+		ON CONFLICT (user_id, blob) DO UPDATE SET content_hash = $3, updated_at = now()`,
+		storageAccountName, blob, contentHash)
+	if err != nil {
+		log.Printf("Failed to record hash of blob %s: %v", blob, err)
+	}
+
+	return nil
+}
+
+// removeBlob deletes a single published blob, a blob that is already gone
+// is fine.
+func removeBlob(storageAccountName string, blob string) error {
+	location := resolveStorage(storageAccountName)
+	if location.Mode == storageModeEmbedded {
+		return nil
+	}
+	ctx := context.Background()
+
+	err := siteBlobs.Delete(ctx, location, blob)
+	if err != nil {
+		log.Printf("Failed to delete blob %s: %v", blob, err)
+		return err
+	}
+
+	_, err = pool.Exec(ctx, "DELETE FROM published_blobs p USING users u WHERE u.id = p.user_id AND u.subdomain = $1 AND p.blob = $2",
+		storageAccountName, blob)
+	if err != nil {
+		log.Printf("Failed to forget hash of blob %s: %v", blob, err)
+	}
+
+	return nil
+}
+
+// downloadBlob reads a published blob, bloberror.BlobNotFound when there is
+// none.
+func downloadBlob(storageAccountName string, blob string) (string, error) {
+	content, err := siteBlobs.Download(context.Background(), resolveStorage(storageAccountName), blob)
+	return string(content), err
+}
+
+// listBlobs returns the names of the published blobs below prefix, without
+// the shared-mode prefix of the user.
+func listBlobs(storageAccountName string, prefix string) (map[string]bool, error) {
+	return siteBlobs.List(context.Background(), resolveStorage(storageAccountName), prefix)
+}
+
+// measureBlobs counts the blobs of the site and their bytes, with the
+// versions and snapshots that are billed as well.
+func measureBlobs(storageAccountName string) (int64, int64, error) {
+	location := resolveStorage(storageAccountName)
+	client, err := blobstorageClient(location.Account)
+	if err != nil {
+		return 0, 0, err
+	}
+
+	var count, bytes int64
+	pager := client.NewListBlobsFlatPager("$web", &azblob.ListBlobsFlatOptions{
+		Prefix:  to.Ptr(location.Prefix),
+		Include: azblob.ListBlobsInclude{Snapshots: true, Versions: true},
+	})
+	for pager.More() {
+		resp, err := pager.NextPage(context.Background())
+		if err != nil {
+			return 0, 0, err
+		}
+		for _, blob := range resp.Segment.BlobItems {
+			count++
+			if blob.Properties != nil && blob.Properties.ContentLength != nil {
+				bytes += *blob.Properties.ContentLength
+			}
+		}
+	}
+	return count, bytes, nil
+}
+
+// forgetBlobHash makes the next addBlob of the blob upload it, for blobs
+// that went missing although the hash says they are up to date.
+func forgetBlobHash(storageAccountName string, blob string) error {
+	_, err := pool.Exec(context.Background(), "DELETE FROM published_blobs p USING users u WHERE u.id = p.user_id AND u.subdomain = $1 AND p.blob = $2",
+		storageAccountName, blob)
+	return err
+}
+
+// deleteBlobsWithPrefix removes a shared-mode user's site from the shared account.
+func deleteBlobsWithPrefix(storageAccountName string, prefix string) error {
 	client, err := blobstorageClient(storageAccountName)
 	if err != nil {
 		log.Printf("Failed to create blob storage client: %v", err)
+		return err
 	}
 	ctx := context.Background()
 
-	_, err = client.UploadBuffer(ctx, "$web", blob, []byte(content), nil)
-	if err != nil {
-		log.Printf("Failed to upload blob: %v", err)
+	pager := client.NewListBlobsFlatPager("$web", &azblob.ListBlobsFlatOptions{
+		Prefix: to.Ptr(prefix),
+	})
+	for pager.More() {
+		resp, err := pager.NextPage(ctx)
+		if err != nil {
+			log.Printf("Failed to list blobs with prefix %s: %v", prefix, err)
+			return err
+		}
+
+		for _, blob := range resp.Segment.BlobItems {
+			_, err = client.DeleteBlob(ctx, "$web", *blob.Name, nil)
+			if err != nil {
+				log.Printf("Failed to delete blob %s: %v", *blob.Name, err)
+				return err
+			}
+		}
 	}
 
 	return nil
 }
 
+// userDelegationSASURL signs a SAS limited to the user's site. In shared mode it
+// is a directory SAS, which requires hierarchical namespace on the shared account.
+func userDelegationSASURL(location storageLocation, expiry time.Time) (string, error) {
+	cred, err := azidentity.NewDefaultAzureCredential(nil)
+	if err != nil {
+		return "", err
+	}
+
+	serviceURL := fmt.Sprintf("https://%s.blob.core.windows.net/", location.Account)
+	serviceClient, err := service.NewClient(serviceURL, cred, nil)
+	if err != nil {
+		log.Printf("Failed to create blob storage service client: %v", err)
+		return "", err
+	}
+
+	start := time.Now().UTC().Add(-5 * time.Minute)
+	delegationCredential, err := serviceClient.GetUserDelegationCredential(context.TODO(), service.KeyInfo{
+		Start:  to.Ptr(start.Format(sas.TimeFormat)),
+		Expiry: to.Ptr(expiry.UTC().Format(sas.TimeFormat)),
+	}, nil)
+	if err != nil {
+		log.Printf("Failed to get user delegation credential: %v", err)
+		return "", err
+	}
+
+	permissions := sas.ContainerPermissions{Read: true, Add: true, Create: true, Write: true, Delete: true, List: true}
+	signatureValues := sas.BlobSignatureValues{
+		Protocol:      sas.ProtocolHTTPS,
+		StartTime:     start,
+		ExpiryTime:    expiry.UTC(),
+		Permissions:   permissions.String(),
+		ContainerName: "$web",
+		Directory:     strings.TrimSuffix(location.Prefix, "/"),
+	}
+
+	queryParams, err := signatureValues.SignWithUserDelegation(delegationCredential)
+	if err != nil {
+		log.Printf("Failed to sign SAS: %v", err)
+		return "", err
+	}
+
+	return serviceURL + "$web/" + location.Prefix + "?" + queryParams.Encode(), nil
+}
+
 func enableStaticWebsite(accountName string) error {
 	cred, err := azidentity.NewDefaultAzureCredential(nil)
 	serviceURL := fmt.Sprintf("https://%s.blob.core.windows.net/", accountName)
@@ -105,7 +294,8 @@ func copyDefaultBlobs(destinationStorageAccountName string, blobpath string) err
 		return err
 	}
 
-	destClient, err := blobstorageClient(destinationStorageAccountName)
+	destination := resolveStorage(destinationStorageAccountName)
+	destClient, err := blobstorageClient(destination.Account)
 	if err != nil {
 		log.Printf("Failed to create blob storage client: %v", err)
 		return err
@@ -113,7 +303,7 @@ func copyDefaultBlobs(destinationStorageAccountName string, blobpath string) err
 	ctx := context.Background()
 
 	sourceBlob := sourceClient.ServiceClient().NewContainerClient("static-websites").NewBlockBlobClient(blobpath)
-	destBlob := destClient.ServiceClient().NewContainerClient("$web").NewBlockBlobClient(blobpath)
+	destBlob := destClient.ServiceClient().NewContainerClient("$web").NewBlockBlobClient(destination.Prefix + blobpath)
 	_, err = destBlob.StartCopyFromURL(ctx, sourceBlob.URL(), nil)
 	if err != nil {
 		log.Printf("Failed to copy blob: %v", err)
@@ -0,0 +1,70 @@
+package main
+
+import (
+	"crypto/md5"
+	"mime"
+	"path"
+	"strings"
+
+	"github.com/Azure/azure-sdk-for-go/sdk/azcore/to"
+	azblobblob "github.com/Azure/azure-sdk-for-go/sdk/storage/azblob/blob"
+)
+
+// Published blobs carry their type and cache headers, the static website
+// and any CDN in front of it serve what is stored. Pages change with every
+// publish and are only cached briefly, photos and the stylesheet are kept
+// longer.
+const (
+	pageCacheControl     = "public, max-age=60, must-revalidate"
+	metadataCacheControl = "public, max-age=3600"
+	assetCacheControl    = "public, max-age=86400"
+)
+
+// blobContentTypes are the types of what the site publishes, Go's mime table
+// has no markdown and differs between systems.
+var blobContentTypes = map[string]string{
+	".md":   "text/markdown; charset=utf-8",
+	".html": "text/html; charset=utf-8",
+	".css":  "text/css; charset=utf-8",
+	".js":   "text/javascript; charset=utf-8",
+	".xml":  "application/xml; charset=utf-8",
+	".txt":  "text/plain; charset=utf-8",
+	".json": "application/json",
+	".jpg":  "image/jpeg",
+	".jpeg": "image/jpeg",
+	".png":  "image/png",
+	".webp": "image/webp",
+}
+
+func blobContentType(blob string) string {
+	extension := strings.ToLower(path.Ext(blob))
+	if contentType, found := blobContentTypes[extension]; found {
+		return contentType
+	}
+	if contentType := mime.TypeByExtension(extension); contentType != "" {
+		return contentType
+	}
+	return "application/octet-stream"
+}
+
+func blobCacheControl(blob string) string {
+	switch {
+	case strings.HasPrefix(blob, "photos/") || blob == "print/print.css":
+		return assetCacheControl
+	case blob == "sitemap.xml" || blob == "robots.txt":
+		return metadataCacheControl
+	default:
+		return pageCacheControl
+	}
+}
+
+// blobHTTPHeaders are the headers stored with the blob. The Content-MD5 lets
+// clients and the CDN check what they got.
+func blobHTTPHeaders(blob string, content string) *azblobblob.HTTPHeaders {
+	sum := md5.Sum([]byte(content))
+	return &azblobblob.HTTPHeaders{
+		BlobContentType:  to.Ptr(blobContentType(blob)),
+		BlobCacheControl: to.Ptr(blobCacheControl(blob)),
+		BlobContentMD5:   sum[:],
+	}
+}
@@ -0,0 +1,143 @@
+package main
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"strings"
+	"sync"
+
+	"github.com/Azure/azure-sdk-for-go/sdk/azcore"
+	"github.com/Azure/azure-sdk-for-go/sdk/azcore/to"
+	"github.com/Azure/azure-sdk-for-go/sdk/storage/azblob"
+	azblobblob "github.com/Azure/azure-sdk-for-go/sdk/storage/azblob/blob"
+	"github.com/Azure/azure-sdk-for-go/sdk/storage/azblob/bloberror"
+)
+
+// blobStore holds the published blobs of the sites. addBlob and the other
+// helpers keep the bookkeeping in Postgres and leave the blobs to it, tests
+// swap in memoryBlobStore.
+type blobStore interface {
+	Upload(ctx context.Context, location storageLocation, blob string, content []byte, headers *azblobblob.HTTPHeaders, tags map[string]string) error
+	// Delete of a blob that is already gone is fine.
+	Delete(ctx context.Context, location storageLocation, blob string) error
+	// Download fails with bloberror.BlobNotFound when there is no blob.
+	Download(ctx context.Context, location storageLocation, blob string) ([]byte, error)
+	// List returns the blobs below prefix, without the location's prefix.
+	List(ctx context.Context, location storageLocation, prefix string) (map[string]bool, error)
+}
+
+var siteBlobs blobStore = azureBlobStore{}
+
+type azureBlobStore struct{}
+
+func (azureBlobStore) Upload(ctx context.Context, location storageLocation, blob string, content []byte, headers *azblobblob.HTTPHeaders, tags map[string]string) error {
+	client, err := blobstorageClient(location.Account)
+	if err != nil {
+		return err
+	}
+	_, err = client.UploadBuffer(ctx, "$web", location.Prefix+blob, content, &azblob.UploadBufferOptions{HTTPHeaders: headers, Tags: tags})
+	return err
+}
+
+func (azureBlobStore) Delete(ctx context.Context, location storageLocation, blob string) error {
+	client, err := blobstorageClient(location.Account)
+	if err != nil {
+		return err
+	}
+	_, err = client.DeleteBlob(ctx, "$web", location.Prefix+blob, nil)
+	if bloberror.HasCode(err, bloberror.BlobNotFound) {
+		return nil
+	}
+	return err
+}
+
+func (azureBlobStore) Download(ctx context.Context, location storageLocation, blob string) ([]byte, error) {
+	client, err := blobstorageClient(location.Account)
+	if err != nil {
+		return nil, err
+	}
+	resp, err := client.DownloadStream(ctx, "$web", location.Prefix+blob, nil)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	return io.ReadAll(resp.Body)
+}
+
+func (azureBlobStore) List(ctx context.Context, location storageLocation, prefix string) (map[string]bool, error) {
+	client, err := blobstorageClient(location.Account)
+	if err != nil {
+		return nil, err
+	}
+
+	names := map[string]bool{}
+	pager := client.NewListBlobsFlatPager("$web", &azblob.ListBlobsFlatOptions{
+		Prefix: to.Ptr(location.Prefix + prefix),
+	})
+	for pager.More() {
+		resp, err := pager.NextPage(ctx)
+		if err != nil {
+			return nil, err
+		}
+		for _, blob := range resp.Segment.BlobItems {
+			names[strings.TrimPrefix(*blob.Name, location.Prefix)] = true
+		}
+	}
+	return names, nil
+}
+
+// memoryBlobStore keeps the blobs of all accounts in a map, keyed by account
+// and full blob name.
+type memoryBlobStore struct {
+	mu    sync.Mutex
+	blobs map[string][]byte
+}
+
+func newMemoryBlobStore() *memoryBlobStore {
+	return &memoryBlobStore{blobs: map[string][]byte{}}
+}
+
+func memoryBlobKey(location storageLocation, blob string) string {
+	return location.Account + "/" + location.Prefix + blob
+}
+
+func (s *memoryBlobStore) Upload(_ context.Context, location storageLocation, blob string, content []byte, _ *azblobblob.HTTPHeaders, _ map[string]string) error {
+	s.mu.Lock()
+	s.blobs[memoryBlobKey(location, blob)] = append([]byte(nil), content...)
+	s.mu.Unlock()
+	return nil
+}
+
+func (s *memoryBlobStore) Delete(_ context.Context, location storageLocation, blob string) error {
+	s.mu.Lock()
+	delete(s.blobs, memoryBlobKey(location, blob))
+	s.mu.Unlock()
+	return nil
+}
+
+func (s *memoryBlobStore) Download(_ context.Context, location storageLocation, blob string) ([]byte, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	content, ok := s.blobs[memoryBlobKey(location, blob)]
+	if !ok {
+		return nil, &azcore.ResponseError{ErrorCode: string(bloberror.BlobNotFound), StatusCode: http.StatusNotFound}
+	}
+	return content, nil
+}
+
+func (s *memoryBlobStore) List(_ context.Context, location storageLocation, prefix string) (map[string]bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	names := map[string]bool{}
+	base := memoryBlobKey(location, "")
+	for key := range s.blobs {
+		if strings.HasPrefix(key, base+prefix) {
+			names[strings.TrimPrefix(key, base)] = true
+		}
+	}
+	return names, nil
+}
@@ -0,0 +1,319 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/jackc/pgx/v5"
+)
+
+// maxBulkRecipes bounds one bulk request, the whole change runs in a single
+// transaction.
+const maxBulkRecipes = 500
+
+type bulkRequest struct {
+	RecipeIDs    []int  `json:"recipeIDs"`
+	Category     string `json:"category,omitempty"`
+	CollectionID int    `json:"collectionID,omitempty"`
+}
+
+type BulkResult struct {
+	Recipes []Recipe `json:"recipes"`
+}
+
+var errBulkNotFound = errors.New("recipes not found")
+
+// decodeBulkRequest reads the body and checks the recipe IDs, it reports
+// whether the request can go ahead.
+func decodeBulkRequest(w http.ResponseWriter, r *http.Request) (bulkRequest, bool) {
+	var req bulkRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "Invalid JSON payload", http.StatusBadRequest)
+		return bulkRequest{}, false
+	}
+	req.RecipeIDs = uniqueInts(req.RecipeIDs)
+	if len(req.RecipeIDs) == 0 {
+		http.Error(w, "Missing recipeIDs", http.StatusBadRequest)
+		return bulkRequest{}, false
+	}
+	if len(req.RecipeIDs) > maxBulkRecipes {
+		http.Error(w, "At most "+strconv.Itoa(maxBulkRecipes)+" recipes per request", http.StatusBadRequest)
+		return bulkRequest{}, false
+	}
+	return req, true
+}
+
+// HandleBulkDeleteRecipes deletes several recipes and templates the index
+// once. Unknown IDs are skipped like with the single delete, the deleted
+// recipes are returned so a client can undo.
+func HandleBulkDeleteRecipes(w http.ResponseWriter, r *http.Request) {
+	userCtx, ok := r.Context().Value("user").(UserContext)
+	if !ok {
+		http.Error(w, "Unauthorized: user context missing", http.StatusUnauthorized)
+		return
+	}
+
+	req, ok := decodeBulkRequest(w, r)
+	if !ok {
+		return
+	}
+
+	// the sessions go with the recipes, their photos are looked up first
+	var photos []string
+	for _, recipeID := range req.RecipeIDs {
+		recipePhotos, err := sessionPhotos(r.Context(), userCtx.UserID, recipeID)
+		if err != nil {
+			log.Printf("Error getting cooking session photos: %v\n", err)
+			http.Error(w, "Error removing recipes", http.StatusInternalServerError)
+			return
+		}
+		photos = append(photos, recipePhotos...)
+	}
+
+	deleted := []Recipe{}
+	err := inTransaction(r.Context(), func(tx pgx.Tx) error {
+		rows, err := tx.Query(r.Context(), `
+			DELETE FROM recipes WHERE user_id = $1 AND id = ANY($2) RETURNING id, title, content, category, slug, version`,
+			userCtx.UserID, req.RecipeIDs)
+		if err != nil {
+			return err
+		}
+		defer rows.Close()
+
+		for rows.Next() {
+			var recipe Recipe
+			if err := rows.Scan(&recipe.ID, &recipe.Recipename, &recipe.Recipe, &recipe.Category, &recipe.Slug, &recipe.Version); err != nil {
+				return err
+			}
+			deleted = append(deleted, recipe)
+		}
+		return rows.Err()
+	})
+	if err != nil {
+		log.Printf("Error removing recipes: %v\n", err)
+		http.Error(w, "Error removing recipes", http.StatusInternalServerError)
+		return
+	}
+	invalidateRecipes(userCtx.UserID)
+
+	for _, recipe := range deleted {
+		recordAudit(r.Context(), userCtx, AuditEntry{
+			Action:   auditRecipeDeleted,
+			RecipeID: recipe.ID,
+			Slug:     recipe.Slug,
+			Before:   recipeAuditSummary(recipe.Recipename, recipe.Category, recipe.Version, recipe.Recipe),
+		})
+	}
+
+	if err := templateRecipesBlob(userCtx.Subdomain, userCtx.UserID); err != nil {
+		log.Printf("Error updating recipe template: %v\n", err)
+		http.Error(w, "Error updating recipe template", http.StatusInternalServerError)
+		return
+	}
+	removeSessionPhotos(userCtx.Subdomain, userCtx.UserID, photos)
+
+	for _, recipe := range deleted {
+		emitEvent(userCtx.UserID, eventRecipeDeleted, map[string]int{"id": recipe.ID})
+	}
+
+	writeBulkResult(w, deleted)
+}
+
+// HandleBulkMoveRecipes sets the category of several recipes. Either all of
+// them are moved or, when one is not found, none.
+func HandleBulkMoveRecipes(w http.ResponseWriter, r *http.Request) {
+	userCtx, ok := r.Context().Value("user").(UserContext)
+	if !ok {
+		http.Error(w, "Unauthorized: user context missing", http.StatusUnauthorized)
+		return
+	}
+
+	req, ok := decodeBulkRequest(w, r)
+	if !ok {
+		return
+	}
+	category := strings.TrimSpace(req.Category)
+	if category == "" {
+		http.Error(w, "Missing category", http.StatusBadRequest)
+		return
+	}
+
+	moved := []Recipe{}
+	previous := map[int]string{}
+	err := inTransaction(r.Context(), func(tx pgx.Tx) error {
+		rows, err := tx.Query(r.Context(), `
+			UPDATE recipes r SET category = $3, updated_at = now(), version = r.version + 1
+			FROM (SELECT id, category FROM recipes WHERE user_id = $1 AND id = ANY($2) FOR UPDATE) old
+			WHERE r.id = old.id
+			RETURNING r.id, r.title, r.slug, r.version, r.updated_at, old.category`,
+			userCtx.UserID, req.RecipeIDs, category)
+		if err != nil {
+			return err
+		}
+		defer rows.Close()
+
+		for rows.Next() {
+			recipe := Recipe{Category: category}
+			var before string
+			if err := rows.Scan(&recipe.ID, &recipe.Recipename, &recipe.Slug, &recipe.Version, &recipe.UpdatedAt, &before); err != nil {
+				return err
+			}
+			previous[recipe.ID] = before
+			moved = append(moved, recipe)
+		}
+		if err := rows.Err(); err != nil {
+			return err
+		}
+		if len(moved) != len(req.RecipeIDs) {
+			return errBulkNotFound
+		}
+		return nil
+	})
+	if errors.Is(err, errBulkNotFound) {
+		http.Error(w, "Recipe not found", http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		log.Printf("Error moving recipes: %v\n", err)
+		http.Error(w, "Error moving recipes", http.StatusInternalServerError)
+		return
+	}
+	invalidateRecipes(userCtx.UserID)
+
+	for _, recipe := range moved {
+		recordAudit(r.Context(), userCtx, AuditEntry{
+			Action:   auditRecipeUpdated,
+			RecipeID: recipe.ID,
+			Slug:     recipe.Slug,
+			Before:   &AuditSummary{Title: recipe.Recipename, Category: previous[recipe.ID], Version: recipe.Version - 1},
+			After:    &AuditSummary{Title: recipe.Recipename, Category: recipe.Category, Version: recipe.Version},
+		})
+	}
+
+	if err := templateRecipesBlob(userCtx.Subdomain, userCtx.UserID); err != nil {
+		log.Printf("Error updating recipe template: %v\n", err)
+		http.Error(w, "Failed to update recipe template", http.StatusInternalServerError)
+		return
+	}
+
+	for i, recipe := range moved {
+		emitEvent(userCtx.UserID, eventRecipeUpdated, recipe)
+		moved[i].URL = recipeURL(userCtx.Subdomain, recipe.Slug)
+	}
+
+	writeBulkResult(w, moved)
+}
+
+// HandleBulkCollectRecipes adds several recipes to a collection, recipes
+// already in it keep their place. Either all of them are added or, when one
+// is not found, none.
+func HandleBulkCollectRecipes(w http.ResponseWriter, r *http.Request) {
+	userCtx, ok := r.Context().Value("user").(UserContext)
+	if !ok {
+		http.Error(w, "Unauthorized: user context missing", http.StatusUnauthorized)
+		return
+	}
+
+	req, ok := decodeBulkRequest(w, r)
+	if !ok {
+		return
+	}
+
+	before, err := getCollection(r.Context(), userCtx.UserID, req.CollectionID)
+	if err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			http.Error(w, "Collection not found", http.StatusNotFound)
+			return
+		}
+		log.Printf("Error getting collection: %v\n", err)
+		http.Error(w, "Error getting collection", http.StatusInternalServerError)
+		return
+	}
+
+	err = inTransaction(r.Context(), func(tx pgx.Tx) error {
+		var found int
+		err := tx.QueryRow(r.Context(), "SELECT count(*) FROM recipes WHERE user_id = $1 AND id = ANY($2)",
+			userCtx.UserID, req.RecipeIDs).Scan(&found)
+		if err != nil {
+			return err
+		}
+		if found != len(req.RecipeIDs) {
+			return errBulkNotFound
+		}
+
+		_, err = tx.Exec(r.Context(), `
+			INSERT INTO collection_recipes (collection_id, recipe_id)
+			SELECT $1, id FROM recipes WHERE user_id = $2 AND id = ANY($3)
+			ON CONFLICT DO NOTHING`, before.ID, userCtx.UserID, req.RecipeIDs)
+		return err
+	})
+	if errors.Is(err, errBulkNotFound) {
+		http.Error(w, "Recipe not found", http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		log.Printf("Error adding recipes to collection %d: %v\n", before.ID, err)
+		http.Error(w, "Error updating collection", http.StatusInternalServerError)
+		return
+	}
+
+	collection, err := getCollection(r.Context(), userCtx.UserID, before.ID)
+	if err != nil {
+		log.Printf("Error getting collection: %v\n", err)
+		http.Error(w, "Error updating collection", http.StatusInternalServerError)
+		return
+	}
+	after := collectionAuditSummary(collection)
+	after.Detail += ", added " + strconv.Itoa(len(req.RecipeIDs)) + " recipes"
+	recordAudit(r.Context(), userCtx, AuditEntry{
+		Action: auditCollectionUpdated,
+		Slug:   collection.Slug,
+		Before: collectionAuditSummary(before),
+		After:  after,
+	})
+
+	if err := templateRecipesBlob(userCtx.Subdomain, userCtx.UserID); err != nil {
+		log.Printf("Error updating recipe template: %v\n", err)
+		http.Error(w, "Failed to update recipe template", http.StatusInternalServerError)
+		return
+	}
+	collection.URL = collectionURL(userCtx.Subdomain, collection.Slug)
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+
+	err = json.NewEncoder(w).Encode(collection)
+	if err != nil {
+		http.Error(w, "Error encoding JSON response", http.StatusInternalServerError)
+	}
+}
+
+// inTransaction runs fn in a transaction that is committed when fn succeeds
+// and rolled back otherwise.
+func inTransaction(ctx context.Context, fn func(tx pgx.Tx) error) error {
+	tx, err := pool.Begin(ctx)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback(ctx)
+
+	if err := fn(tx); err != nil {
+		return err
+	}
+	return tx.Commit(ctx)
+}
+
+func writeBulkResult(w http.ResponseWriter, recipes []Recipe) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+
+	err := json.NewEncoder(w).Encode(BulkResult{Recipes: recipes})
+	if err != nil {
+		http.Error(w, "Error encoding JSON response", http.StatusInternalServerError)
+	}
+}
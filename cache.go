@@ -0,0 +1,277 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"log"
+	"os"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+)
+
+const (
+	userCacheTTL    = 5 * time.Minute
+	recipesCacheTTL = 5 * time.Minute
+
+	// memoryCacheSweepInterval is how often expired entries nobody asks for
+	// again are dropped from the memory cache.
+	memoryCacheSweepInterval = 10 * time.Minute
+)
+
+// cache stores JSON encoded values. Errors are logged and treated as a miss,
+// the database stays the source of truth. Incr counts generations, see
+// recipesGeneration, and returns 0 when it fails. Add is Set for keys that
+// are not set yet and reports whether it stored the value, it also succeeds
+// when the cache fails.
+type cache interface {
+	Get(ctx context.Context, key string, dest interface{}) bool
+	Set(ctx context.Context, key string, value interface{}, ttl time.Duration)
+	Add(ctx context.Context, key string, value interface{}, ttl time.Duration) bool
+	Delete(ctx context.Context, keys ...string)
+	Incr(ctx context.Context, key string) int64
+}
+
+// memoryCache is per instance; run with REDIS_URL when several instances share
+// the database so invalidations reach all of them.
+type memoryCache struct {
+	mu      sync.Mutex
+	entries map[string]cacheEntry
+}
+
+type cacheEntry struct {
+	data    []byte
+	expires time.Time
+}
+
+type redisCache struct {
+	client *redis.Client
+}
+
+var appCache cache = newMemoryCache()
+
+// cachedUser is only stored once provisioning is ready, until then Login has
+// to see the current status.
+type cachedUser struct {
+	ID          int    `json:"id"`
+	Subdomain   string `json:"subdomain"`
+	StorageMode string `json:"storageMode"`
+}
+
+func initCache() {
+	redisURL := os.Getenv("REDIS_URL")
+	if redisURL == "" {
+		// invalidations would only reach the instance that made the change
+		if os.Getenv("MULTI_INSTANCE") == "true" {
+			log.Fatal("REDIS_URL is required when MULTI_INSTANCE=true")
+		}
+		if memory, ok := appCache.(*memoryCache); ok {
+			go memory.sweep(memoryCacheSweepInterval)
+		}
+		return
+	}
+
+	options, err := redis.ParseURL(redisURL)
+	if err != nil {
+		log.Fatalf("Invalid REDIS_URL: %v", err)
+	}
+
+	appCache = redisCache{client: redis.NewClient(options)}
+	log.Println("caching in redis")
+}
+
+func newMemoryCache() *memoryCache {
+	return &memoryCache{entries: map[string]cacheEntry{}}
+}
+
+func (c *memoryCache) Get(_ context.Context, key string, dest interface{}) bool {
+	c.mu.Lock()
+	entry, ok := c.entries[key]
+	if ok && !entry.expires.IsZero() && time.Now().After(entry.expires) {
+		delete(c.entries, key)
+		ok = false
+	}
+	c.mu.Unlock()
+
+	if !ok {
+		return false
+	}
+	return json.Unmarshal(entry.data, dest) == nil
+}
+
+func (c *memoryCache) Set(_ context.Context, key string, value interface{}, ttl time.Duration) {
+	data, err := json.Marshal(value)
+	if err != nil {
+		log.Printf("Failed to encode cache entry %s: %v", key, err)
+		return
+	}
+
+	c.mu.Lock()
+	c.entries[key] = cacheEntry{data: data, expires: time.Now().Add(ttl)}
+	c.mu.Unlock()
+}
+
+func (c *memoryCache) Add(_ context.Context, key string, value interface{}, ttl time.Duration) bool {
+	data, err := json.Marshal(value)
+	if err != nil {
+		log.Printf("Failed to encode cache entry %s: %v", key, err)
+		return true
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if entry, ok := c.entries[key]; ok && (entry.expires.IsZero() || time.Now().Before(entry.expires)) {
+		return false
+	}
+	c.entries[key] = cacheEntry{data: data, expires: time.Now().Add(ttl)}
+	return true
+}
+
+func (c *memoryCache) Delete(_ context.Context, keys ...string) {
+	c.mu.Lock()
+	for _, key := range keys {
+		delete(c.entries, key)
+	}
+	c.mu.Unlock()
+}
+
+// Incr keeps counters without expiry, like Redis does.
+func (c *memoryCache) Incr(_ context.Context, key string) int64 {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	var n int64
+	if entry, ok := c.entries[key]; ok {
+		_ = json.Unmarshal(entry.data, &n)
+	}
+	n++
+	data, _ := json.Marshal(n)
+	c.entries[key] = cacheEntry{data: data}
+	return n
+}
+
+// sweep drops expired entries, Get only drops the ones it is asked for.
+func (c *memoryCache) sweep(interval time.Duration) {
+	for range time.Tick(interval) {
+		now := time.Now()
+		c.mu.Lock()
+		for key, entry := range c.entries {
+			if !entry.expires.IsZero() && now.After(entry.expires) {
+				delete(c.entries, key)
+			}
+		}
+		c.mu.Unlock()
+	}
+}
+
+func (c redisCache) Get(ctx context.Context, key string, dest interface{}) bool {
+	data, err := c.client.Get(ctx, key).Bytes()
+	if err != nil {
+		if err != redis.Nil {
+			log.Printf("Failed to read cache entry %s: %v", key, err)
+		}
+		return false
+	}
+	return json.Unmarshal(data, dest) == nil
+}
+
+func (c redisCache) Set(ctx context.Context, key string, value interface{}, ttl time.Duration) {
+	data, err := json.Marshal(value)
+	if err != nil {
+		log.Printf("Failed to encode cache entry %s: %v", key, err)
+		return
+	}
+
+	if err := c.client.Set(ctx, key, data, ttl).Err(); err != nil {
+		log.Printf("Failed to write cache entry %s: %v", key, err)
+	}
+}
+
+func (c redisCache) Add(ctx context.Context, key string, value interface{}, ttl time.Duration) bool {
+	data, err := json.Marshal(value)
+	if err != nil {
+		log.Printf("Failed to encode cache entry %s: %v", key, err)
+		return true
+	}
+
+	added, err := c.client.SetNX(ctx, key, data, ttl).Result()
+	if err != nil {
+		log.Printf("Failed to write cache entry %s: %v", key, err)
+		return true
+	}
+	return added
+}
+
+func (c redisCache) Delete(ctx context.Context, keys ...string) {
+	if err := c.client.Del(ctx, keys...).Err(); err != nil {
+		log.Printf("Failed to delete cache entries %v: %v", keys, err)
+	}
+}
+
+func (c redisCache) Incr(ctx context.Context, key string) int64 {
+	n, err := c.client.Incr(ctx, key).Result()
+	if err != nil {
+		log.Printf("Failed to increment cache entry %s: %v", key, err)
+		return 0
+	}
+	return n
+}
+
+func (c redisCache) ping(ctx context.Context) error {
+	return c.client.Ping(ctx).Err()
+}
+
+func userCacheKey(oauthID string) string {
+	return "user:" + oauthID
+}
+
+// The cached recipes of a user are keyed by a generation that invalidation
+// bumps. A read that went to the database before an invalidation caches its
+// rows under the old generation, where nobody looks anymore, instead of
+// overwriting the delete.
+func recipesGenerationKey(userID int) string {
+	return "recipes-generation:" + strconv.Itoa(userID)
+}
+
+func recipesGeneration(ctx context.Context, userID int) int64 {
+	var generation int64
+	appCache.Get(ctx, recipesGenerationKey(userID), &generation)
+	return generation
+}
+
+func recipesCacheKey(userID int, generation int64) string {
+	return "recipes:" + strconv.Itoa(userID) + ":" + strconv.FormatInt(generation, 10)
+}
+
+func indexCacheKey(userID int) string {
+	return "index:" + strconv.Itoa(userID)
+}
+
+// The cached index is only stored by publishes, under the publish lock. Its
+// generation is bumped by every invalidation that doesn't come with a
+// publish of the changed recipes, see templateRecipesBlob.
+func indexGenerationKey(userID int) string {
+	return "index-generation:" + strconv.Itoa(userID)
+}
+
+func indexGeneration(ctx context.Context, userID int) int64 {
+	var generation int64
+	appCache.Get(ctx, indexGenerationKey(userID), &generation)
+	return generation
+}
+
+// invalidateRecipes drops the cached recipes and index rows of the user.
+func invalidateRecipes(userID int) {
+	appCache.Incr(context.Background(), recipesGenerationKey(userID))
+	appCache.Incr(context.Background(), indexGenerationKey(userID))
+	appCache.Delete(context.Background(), indexCacheKey(userID))
+}
+
+// invalidateRecipeList drops the cached recipes but keeps the index, for
+// changes published right after with templateRecipesBlob and their recipe
+// IDs, which patches it.
+func invalidateRecipeList(userID int) {
+	appCache.Incr(context.Background(), recipesGenerationKey(userID))
+}
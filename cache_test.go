@@ -0,0 +1,23 @@
+package main
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+func TestMemoryCacheAdd(t *testing.T) {
+	ctx := context.Background()
+	c := newMemoryCache()
+
+	if !c.Add(ctx, "token", true, time.Minute) {
+		t.Fatal("first Add did not store the key")
+	}
+	if c.Add(ctx, "token", true, time.Minute) {
+		t.Error("second Add stored a key that is set")
+	}
+
+	if !c.Add(ctx, "expired", true, -time.Second) || !c.Add(ctx, "expired", true, time.Minute) {
+		t.Error("Add did not replace an expired key")
+	}
+}
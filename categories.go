@@ -0,0 +1,219 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"log"
+	"maps"
+	"net/http"
+	"slices"
+	"sort"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+)
+
+// Category is how a category is shown as a section of recipes.md. Name is
+// the category stored on the recipes, Labels the section title per language
+// with DisplayName for the languages left out.
+type Category struct {
+	Name        string            `json:"name"`
+	DisplayName string            `json:"displayName"`
+	Emoji       string            `json:"emoji"`
+	SortOrder   int               `json:"sortOrder"`
+	Labels      map[string]string `json:"labels"`
+}
+
+func (c Category) label(language string) string {
+	if label := c.Labels[language]; label != "" {
+		return label
+	}
+	if c.DisplayName != "" {
+		return c.DisplayName
+	}
+	return c.Name
+}
+
+// defaultCategorySections are the sections of users without their own, the
+// index always listed them even when empty.
+var defaultCategorySections = []Category{
+	{Name: "Hauptgericht", DisplayName: "Hauptgerichte", Emoji: "🍝", SortOrder: 1, Labels: map[string]string{languageGerman: "Hauptgerichte", languageEnglish: "Main dishes"}},
+	{Name: "Vorspeise", DisplayName: "Vorspeisen", Emoji: "🥗", SortOrder: 2, Labels: map[string]string{languageGerman: "Vorspeisen", languageEnglish: "Starters"}},
+	{Name: "Dessert", DisplayName: "Desserts", Emoji: "🧁", SortOrder: 3, Labels: map[string]string{languageGerman: "Desserts", languageEnglish: "Desserts"}},
+	{Name: "Brot", DisplayName: "Brot", Emoji: "🍞", SortOrder: 4, Labels: map[string]string{languageGerman: "Brot", languageEnglish: "Bread"}},
+}
+
+func cloneDefaultCategories() []Category {
+	categories := make([]Category, len(defaultCategorySections))
+	for i, category := range defaultCategorySections {
+		category.Labels = maps.Clone(category.Labels)
+		categories[i] = category
+	}
+	return categories
+}
+
+// GetCategories returns the user's categories in section order, the
+// defaults when they have none.
+func GetCategories(ctx context.Context, userID int) ([]Category, error) {
+	rows, err := pool.Query(ctx, `
+		SELECT name, display_name, emoji, sort_order, labels FROM categories WHERE user_id = $1 ORDER BY sort_order, name`, userID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var categories []Category
+	for rows.Next() {
+		var category Category
+		if err := rows.Scan(&category.Name, &category.DisplayName, &category.Emoji, &category.SortOrder, &category.Labels); err != nil {
+			return nil, err
+		}
+		categories = append(categories, category)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	if len(categories) == 0 {
+		return cloneDefaultCategories(), nil
+	}
+	return categories, nil
+}
+
+// validateCategories trims the categories and returns why they are
+// rejected, empty when they are fine. Like section titles they end up in
+// the markdown, so line breaks are not allowed.
+func validateCategories(categories []Category) string {
+	if len(categories) > maxIndexSections {
+		return "Too many categories"
+	}
+	var names []string
+	for i := range categories {
+		category := &categories[i]
+		category.Name = strings.Join(strings.Fields(category.Name), " ")
+		category.DisplayName = strings.TrimSpace(category.DisplayName)
+		category.Emoji = strings.TrimSpace(category.Emoji)
+		if category.Name == "" {
+			return "Missing category name"
+		}
+		if slices.Contains(names, category.Name) {
+			return "Category " + category.Name + " is listed twice"
+		}
+		names = append(names, category.Name)
+		if utf8.RuneCountInString(category.Name) > maxSettingsCategoryLength {
+			return "Category names must be at most " + strconv.Itoa(maxSettingsCategoryLength) + " characters"
+		}
+		texts := category.DisplayName + category.Emoji
+		if category.Labels == nil {
+			category.Labels = map[string]string{}
+		}
+		for language, label := range category.Labels {
+			if language != languageGerman && language != languageEnglish {
+				return "labels must be de or en"
+			}
+			label = strings.TrimSpace(label)
+			if utf8.RuneCountInString(label) > maxSectionTitleRunes {
+				return "Category label too long"
+			}
+			category.Labels[language] = label
+			texts += label
+		}
+		if strings.ContainsAny(texts, "\r\n") {
+			return "Category names and labels must be a single line"
+		}
+		if utf8.RuneCountInString(category.DisplayName) > maxSectionTitleRunes || utf8.RuneCountInString(category.Emoji) > maxSectionEmojiRunes {
+			return "Category display name or emoji too long"
+		}
+	}
+	sort.SliceStable(categories, func(i, j int) bool { return categories[i].SortOrder < categories[j].SortOrder })
+	return ""
+}
+
+func HandleGetCategories(w http.ResponseWriter, r *http.Request) {
+	userCtx, ok := r.Context().Value("user").(UserContext)
+	if !ok {
+		http.Error(w, "Unauthorized: user context missing", http.StatusUnauthorized)
+		return
+	}
+
+	categories, err := GetCategories(r.Context(), userCtx.UserID)
+	if err != nil {
+		log.Printf("Error getting categories: %v\n", err)
+		http.Error(w, "Error getting categories", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+
+	err = json.NewEncoder(w).Encode(categories)
+	if err != nil {
+		http.Error(w, "Error encoding JSON response", http.StatusInternalServerError)
+	}
+}
+
+// HandleUpdateCategories replaces the user's categories and templates
+// recipes.md again. An empty list goes back to the defaults.
+func HandleUpdateCategories(w http.ResponseWriter, r *http.Request) {
+	userCtx, ok := r.Context().Value("user").(UserContext)
+	if !ok {
+		http.Error(w, "Unauthorized: user context missing", http.StatusUnauthorized)
+		return
+	}
+
+	var categories []Category
+	if err := json.NewDecoder(r.Body).Decode(&categories); err != nil {
+		http.Error(w, "Invalid JSON payload", http.StatusBadRequest)
+		return
+	}
+	if message := validateCategories(categories); message != "" {
+		http.Error(w, message, http.StatusBadRequest)
+		return
+	}
+
+	ctx := r.Context()
+	tx, err := pool.Begin(ctx)
+	if err != nil {
+		log.Printf("Error starting transaction: %v\n", err)
+		http.Error(w, "Error updating categories", http.StatusInternalServerError)
+		return
+	}
+	defer tx.Rollback(ctx)
+
+	if _, err := tx.Exec(ctx, "DELETE FROM categories WHERE user_id = $1", userCtx.UserID); err != nil {
+		log.Printf("Error updating categories: %v\n", err)
+		http.Error(w, "Error updating categories", http.StatusInternalServerError)
+		return
+	}
+	for _, category := range categories {
+		_, err := tx.Exec(ctx, `
+			INSERT INTO categories (user_id, name, display_name, emoji, sort_order, labels) VALUES ($1, $2, $3, $4, $5, $6)`,
+			userCtx.UserID, category.Name, category.DisplayName, category.Emoji, category.SortOrder, category.Labels)
+		if err != nil {
+			log.Printf("Error updating categories: %v\n", err)
+			http.Error(w, "Error updating categories", http.StatusInternalServerError)
+			return
+		}
+	}
+	if err := tx.Commit(ctx); err != nil {
+		log.Printf("Error updating categories: %v\n", err)
+		http.Error(w, "Error updating categories", http.StatusInternalServerError)
+		return
+	}
+
+	if err := templateRecipesBlob(userCtx.Subdomain, userCtx.UserID); err != nil {
+		log.Printf("Error updating recipe template: %v\n", err)
+		http.Error(w, "Failed to update recipe template", http.StatusInternalServerError)
+		return
+	}
+
+	if len(categories) == 0 {
+		categories = cloneDefaultCategories()
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+
+	err = json.NewEncoder(w).Encode(categories)
+	if err != nil {
+		http.Error(w, "Error encoding JSON response", http.StatusInternalServerError)
+	}
+}
@@ -0,0 +1,101 @@
+package main
+
+import (
+	"context"
+	"log"
+	"net"
+	"net/http"
+	"net/netip"
+	"os"
+	"strings"
+)
+
+// trustedProxies are the front-ends allowed to report the client in
+// X-Forwarded-For, set as TRUSTED_PROXIES with addresses or CIDR ranges.
+// Without it the header is ignored, anyone could send it.
+var trustedProxies []netip.Prefix
+
+func initTrustedProxies() {
+	trustedProxies = nil
+	for _, value := range strings.Split(os.Getenv("TRUSTED_PROXIES"), ",") {
+		value = strings.TrimSpace(value)
+		if value == "" {
+			continue
+		}
+		if !strings.Contains(value, "/") {
+			addr, err := netip.ParseAddr(value)
+			if err != nil {
+				log.Fatalf("Invalid address %q in TRUSTED_PROXIES: %v\n", value, err)
+			}
+			value = netip.PrefixFrom(addr, addr.BitLen()).String()
+		}
+		prefix, err := netip.ParsePrefix(value)
+		if err != nil {
+			log.Fatalf("Invalid range %q in TRUSTED_PROXIES: %v\n", value, err)
+		}
+		trustedProxies = append(trustedProxies, prefix.Masked())
+	}
+}
+
+func trustedProxy(addr netip.Addr) bool {
+	addr = addr.Unmap()
+	for _, prefix := range trustedProxies {
+		if prefix.Contains(addr) {
+			return true
+		}
+	}
+	return false
+}
+
+// realClientIP walks X-Forwarded-For from the right, every proxy appends
+// the address it got the request from. The first address that isn't a
+// trusted proxy is the client, the entries left of it can be forged.
+func realClientIP(r *http.Request) string {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		host = r.RemoteAddr
+	}
+	remote, err := netip.ParseAddr(host)
+	if err != nil || !trustedProxy(remote) {
+		return host
+	}
+
+	client := remote
+	hops := strings.Split(strings.Join(r.Header.Values("X-Forwarded-For"), ","), ",")
+	for i := len(hops) - 1; i >= 0; i-- {
+		hop, err := parseForwardedHop(strings.TrimSpace(hops[i]))
+		if err != nil {
+			break
+		}
+		client = hop.Unmap()
+		if !trustedProxy(client) {
+			break
+		}
+	}
+	return client.String()
+}
+
+// parseForwardedHop reads one X-Forwarded-For entry. Azure Application
+// Gateway appends the port as well, "203.0.113.7:51234" or
+// "[2001:db8::1]:51234".
+func parseForwardedHop(hop string) (netip.Addr, error) {
+	if addrPort, err := netip.ParseAddrPort(hop); err == nil {
+		return addrPort.Addr(), nil
+	}
+	return netip.ParseAddr(hop)
+}
+
+// withClientIP resolves the client address once per request for logging and
+// the audit log, see clientIP.
+func withClientIP(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ctx := context.WithValue(r.Context(), "clientIP", realClientIP(r))
+		next.ServeHTTP(w, r.WithContext(ctx))
+	})
+}
+
+// clientIP is empty outside of a request, like for background imports.
+func clientIP(ctx context.Context) string {
+	ip, _ := ctx.Value("clientIP").(string)
+	return ip
+}
@@ -0,0 +1,36 @@
+package main
+
+import (
+	"net/http/httptest"
+	"testing"
+)
+
+func TestRealClientIP(t *testing.T) {
+	t.Setenv("TRUSTED_PROXIES", "10.0.0.0/8")
+	initTrustedProxies()
+	t.Cleanup(func() { trustedProxies = nil })
+
+	tests := []struct {
+		name      string
+		remote    string
+		forwarded string
+		want      string
+	}{
+		{"direct", "203.0.113.7:51234", "198.51.100.1", "203.0.113.7"},
+		{"behind proxy", "10.0.0.2:443", "203.0.113.7", "203.0.113.7"},
+		{"gateway with port", "10.0.0.2:443", "203.0.113.7:51234", "203.0.113.7"},
+		{"ipv6 with port", "10.0.0.2:443", "[2001:db8::1]:51234", "2001:db8::1"},
+		{"forged entries", "10.0.0.2:443", "198.51.100.1, 203.0.113.7:51234, 10.0.0.3", "203.0.113.7"},
+		{"garbage", "10.0.0.2:443", "unknown", "10.0.0.2"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest("GET", "/health", nil)
+			req.RemoteAddr = tt.remote
+			req.Header.Set("X-Forwarded-For", tt.forwarded)
+			if got := realClientIP(req); got != tt.want {
+				t.Errorf("realClientIP = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,123 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"log"
+	"net/http"
+	"net/url"
+	"strings"
+
+	"github.com/jackc/pgx/v5"
+)
+
+// HandleClip is the bookmarklet endpoint: it imports the page at ?url= and
+// redirects to the published recipe. A page that was clipped before, or a
+// recipe with the same title, redirects to the existing recipe without a
+// generation. German is the default language, ?german=false switches it off.
+func HandleClip(w http.ResponseWriter, r *http.Request) {
+	userCtx, ok := r.Context().Value("user").(UserContext)
+	if !ok {
+		http.Error(w, "Unauthorized: user context missing", http.StatusUnauthorized)
+		return
+	}
+
+	source, err := normalizeSourceURL(r.URL.Query().Get("url"))
+	if err != nil {
+		http.Error(w, "url must be an absolute http or https URL", http.StatusBadRequest)
+		return
+	}
+
+	slug, err := recipeSlugBySource(r.Context(), userCtx.UserID, source)
+	if err == nil {
+		http.Redirect(w, r, recipeURL(userCtx.Subdomain, slug), http.StatusSeeOther)
+		return
+	}
+	if !errors.Is(err, pgx.ErrNoRows) {
+		log.Printf("Error checking clipped recipe: %v\n", err)
+		http.Error(w, "Error checking recipes", http.StatusInternalServerError)
+		return
+	}
+
+	if err := checkRecipeLimit(r.Context(), userCtx.UserID); err != nil {
+		if errors.Is(err, errRecipeLimit) {
+			http.Error(w, "Recipe limit of your plan reached", http.StatusForbidden)
+			return
+		}
+		log.Printf("Error counting recipes for user %d: %v\n", userCtx.UserID, err)
+		http.Error(w, "Error counting recipes", http.StatusInternalServerError)
+		return
+	}
+	if err := countGeneration(r.Context(), userCtx.UserID); err != nil {
+		if errors.Is(err, errGenerationLimit) {
+			http.Error(w, "Daily generation limit reached", http.StatusTooManyRequests)
+			return
+		}
+		log.Printf("Error counting generation for user %d: %v\n", userCtx.UserID, err)
+		http.Error(w, "Error counting generation", http.StatusInternalServerError)
+		return
+	}
+
+	ctx := withLLMBudget(r.Context(), newLLMBudget(r))
+	recipename, recipe, err := GenerateRecipeByLink(ctx, source, r.URL.Query().Get("german") != "false")
+	if errors.Is(err, errInputTooLarge) {
+		http.Error(w, errInputTooLarge.Error(), http.StatusUnprocessableEntity)
+		return
+	}
+	if err != nil {
+		log.Printf("Error clipping %s: %v\n", source, err)
+		http.Error(w, "Error generating recipe", http.StatusInternalServerError)
+		return
+	}
+
+	err = pool.QueryRow(r.Context(), "SELECT slug FROM recipes WHERE user_id = $1 AND lower(title) = lower($2) LIMIT 1",
+		userCtx.UserID, recipename).Scan(&slug)
+	if err == nil {
+		http.Redirect(w, r, recipeURL(userCtx.Subdomain, slug), http.StatusSeeOther)
+		return
+	}
+	if !errors.Is(err, pgx.ErrNoRows) {
+		log.Printf("Error checking clipped recipe: %v\n", err)
+		http.Error(w, "Error checking recipes", http.StatusInternalServerError)
+		return
+	}
+
+	added, err := addRecipe(r.Context(), userCtx, RecipeRequest{Recipename: recipename, Recipe: recipe, SourceURL: source})
+	if err != nil {
+		log.Printf("Error adding clipped recipe: %v\n", err)
+		http.Error(w, "Error adding recipe", http.StatusInternalServerError)
+		return
+	}
+
+	http.Redirect(w, r, added.URL, http.StatusSeeOther)
+}
+
+func recipeSlugBySource(ctx context.Context, userID int, source string) (string, error) {
+	var slug string
+	err := pool.QueryRow(ctx, "SELECT slug FROM recipes WHERE user_id = $1 AND source_url = $2 LIMIT 1", userID, source).Scan(&slug)
+	return slug, err
+}
+
+// normalizeSourceURL drops fragments and tracking parameters, so the same
+// page shared through different channels is recognized as a duplicate.
+func normalizeSourceURL(raw string) (string, error) {
+	parsed, err := url.Parse(strings.TrimSpace(raw))
+	if err != nil {
+		return "", err
+	}
+	if (parsed.Scheme != "http" && parsed.Scheme != "https") || parsed.Host == "" {
+		return "", errors.New("not an absolute http URL")
+	}
+
+	parsed.Host = strings.ToLower(parsed.Host)
+	parsed.Fragment = ""
+	query := parsed.Query()
+	for name := range query {
+		if strings.HasPrefix(name, "utm_") || name == "fbclid" || name == "gclid" {
+			query.Del(name)
+		}
+	}
+	parsed.RawQuery = query.Encode()
+
+	return parsed.String(), nil
+}
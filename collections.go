@@ -0,0 +1,620 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+)
+
+const maxCollectionNameLength = 100
+
+// Collection groups recipes across categories, a recipe can be in any number
+// of collections. RecipeIDs are in the order the recipes were added.
+type Collection struct {
+	ID          int       `json:"id"`
+	Name        string    `json:"name"`
+	Slug        string    `json:"slug"`
+	Description string    `json:"description"`
+	RecipeIDs   []int     `json:"recipeIDs"`
+	URL         string    `json:"url,omitempty"`
+	CreatedAt   time.Time `json:"createdAt"`
+}
+
+type collectionRequest struct {
+	Name        *string `json:"name"`
+	Description *string `json:"description"`
+	RecipeIDs   []int   `json:"recipeIDs"`
+}
+
+func collectionPath(slug string) string {
+	return "collections/" + slug + ".md"
+}
+
+func collectionURL(subdomain string, slug string) string {
+	return siteURL(subdomain) + "?collection=" + slug
+}
+
+const collectionColumns = `c.id, c.name, c.slug, c.description, c.created_at,
+	coalesce(array_agg(cr.recipe_id ORDER BY cr.added_at, cr.recipe_id) FILTER (WHERE cr.recipe_id IS NOT NULL), '{}')`
+
+func scanCollection(row pgx.Row) (Collection, error) {
+	var collection Collection
+	err := row.Scan(&collection.ID, &collection.Name, &collection.Slug, &collection.Description, &collection.CreatedAt, &collection.RecipeIDs)
+	return collection, err
+}
+
+func listCollections(ctx context.Context, userID int) ([]Collection, error) {
+	rows, err := pool.Query(ctx, `
+		SELECT `+collectionColumns+` FROM collections c LEFT JOIN collection_recipes cr ON cr.collection_id = c.id
+		WHERE c.user_id = $1 GROUP BY c.id ORDER BY lower(c.name), c.id`, userID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	collections := []Collection{}
+	for rows.Next() {
+		collection, err := scanCollection(rows)
+		if err != nil {
+			return nil, err
+		}
+		collections = append(collections, collection)
+	}
+	return collections, rows.Err()
+}
+
+func getCollection(ctx context.Context, userID int, collectionID int) (Collection, error) {
+	return scanCollection(pool.QueryRow(ctx, `
+		SELECT `+collectionColumns+` FROM collections c LEFT JOIN collection_recipes cr ON cr.collection_id = c.id
+		WHERE c.user_id = $1 AND c.id = $2 GROUP BY c.id`, userID, collectionID))
+}
+
+func getCollectionBySlug(ctx context.Context, userID int, slug string) (Collection, error) {
+	return scanCollection(pool.QueryRow(ctx, `
+		SELECT `+collectionColumns+` FROM collections c LEFT JOIN collection_recipes cr ON cr.collection_id = c.id
+		WHERE c.user_id = $1 AND c.slug = $2 GROUP BY c.id`, userID, slug))
+}
+
+func uniqueCollectionSlug(ctx context.Context, userID int, name string, excludeID int) (string, error) {
+	return uniqueSlug(ctx, slugify(name),
+		"SELECT slug FROM collections WHERE user_id = $3 AND id <> $4 AND (slug = $1 OR slug LIKE $2)", userID, excludeID)
+}
+
+// collectionRecipes returns the published recipes of a collection in their
+// order, archived ones and drafts are left out.
+func collectionRecipes(ctx context.Context, userID int, collectionID int) ([]Recipe, error) {
+	rows, err := pool.Query(ctx, `
+		SELECT r.id, r.title, r.content, r.slug, r.prep_minutes, r.cook_minutes, r.total_minutes, r.difficulty
+		FROM collection_recipes cr JOIN recipes r ON r.id = cr.recipe_id
+		WHERE cr.collection_id = $1 AND r.user_id = $2 AND NOT r.archived AND NOT r.draft
+		ORDER BY cr.added_at, r.id`, collectionID, userID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var recipes []Recipe
+	for rows.Next() {
+		var recipe Recipe
+		if err := rows.Scan(&recipe.ID, &recipe.Recipename, &recipe.Recipe, &recipe.Slug,
+			&recipe.PrepTime, &recipe.CookTime, &recipe.TotalTime, &recipe.Difficulty); err != nil {
+			return nil, err
+		}
+		recipes = append(recipes, recipe)
+	}
+	return recipes, rows.Err()
+}
+
+func renderCollectionPage(collection Collection, recipes []Recipe) string {
+	var page strings.Builder
+	page.WriteString("# " + collection.Name + "\n\n[Alle Rezepte](./)\n\n")
+	if collection.Description != "" {
+		page.WriteString(collection.Description + "\n\n")
+	}
+	if len(recipes) == 0 {
+		page.WriteString("Noch keine Rezepte in dieser Sammlung.\n")
+	}
+	for _, recipe := range recipes {
+		page.WriteString("- [" + recipe.Recipename + "](./?recipe=" + recipe.Slug + ")\n")
+	}
+	return page.String()
+}
+
+// renderCollectionsSection lists the collections at the end of the index,
+// empty when the user has none.
+func renderCollectionsSection(collections []Collection) string {
+	if len(collections) == 0 {
+		return ""
+	}
+	section := "📚 Sammlungen\n"
+	for _, collection := range collections {
+		section += "- [" + collection.Name + "](./?collection=" + collection.Slug + ")\n"
+	}
+	return section
+}
+
+// publishCollections uploads every collection page. It runs with the index
+// under the publish lock, so renamed and archived recipes are reflected.
+func publishCollections(ctx context.Context, subdomain string, userID int, collections []Collection) error {
+	for _, collection := range collections {
+		recipes, err := collectionRecipes(ctx, userID, collection.ID)
+		if err != nil {
+			return err
+		}
+		if err := addBlob(subdomain, collectionPath(collection.Slug), renderCollectionPage(collection, recipes)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func collectionAuditSummary(collection Collection) *AuditSummary {
+	return &AuditSummary{Title: collection.Name, Detail: strconv.Itoa(len(collection.RecipeIDs)) + " recipes"}
+}
+
+// validateCollectionRequest trims the fields and returns why the request is
+// rejected, empty when it is fine. The name ends up in the markdown, so line
+// breaks are not allowed.
+func validateCollectionRequest(request *collectionRequest, creating bool) string {
+	if request.Name != nil {
+		name := strings.TrimSpace(*request.Name)
+		request.Name = &name
+		if name == "" {
+			return "name must not be empty"
+		}
+		if len([]rune(name)) > maxCollectionNameLength {
+			return "name must be at most " + strconv.Itoa(maxCollectionNameLength) + " characters"
+		}
+		if strings.ContainsAny(name, "\r\n") {
+			return "name must be a single line"
+		}
+	} else if creating {
+		return "name is required"
+	}
+	if request.Description != nil {
+		description := strings.TrimSpace(*request.Description)
+		request.Description = &description
+	}
+	return ""
+}
+
+// addCollectionRecipes adds recipes of the user, recipes that are already in
+// the collection keep their place. It reports whether all recipes were found.
+func addCollectionRecipes(ctx context.Context, tx pgx.Tx, userID int, collectionID int, recipeIDs []int) (bool, error) {
+	if len(recipeIDs) == 0 {
+		return true, nil
+	}
+	tag, err := tx.Exec(ctx, `
+		INSERT INTO collection_recipes (collection_id, recipe_id)
+		SELECT $1, id FROM recipes WHERE user_id = $2 AND id = ANY($3)
+		ON CONFLICT DO NOTHING`, collectionID, userID, recipeIDs)
+	if err != nil {
+		return false, err
+	}
+	if int(tag.RowsAffected()) == len(recipeIDs) {
+		return true, nil
+	}
+
+	var found int
+	err = tx.QueryRow(ctx, "SELECT count(*) FROM recipes WHERE user_id = $1 AND id = ANY($2)", userID, recipeIDs).Scan(&found)
+	if err != nil {
+		return false, err
+	}
+	return found == len(uniqueInts(recipeIDs)), nil
+}
+
+func uniqueInts(values []int) []int {
+	seen := map[int]bool{}
+	var unique []int
+	for _, value := range values {
+		if !seen[value] {
+			seen[value] = true
+			unique = append(unique, value)
+		}
+	}
+	return unique
+}
+
+func HandleListCollections(w http.ResponseWriter, r *http.Request) {
+	userCtx, ok := r.Context().Value("user").(UserContext)
+	if !ok {
+		http.Error(w, "Unauthorized: user context missing", http.StatusUnauthorized)
+		return
+	}
+
+	collections, err := listCollections(r.Context(), userCtx.UserID)
+	if err != nil {
+		log.Printf("Error getting collections: %v\n", err)
+		http.Error(w, "Error getting collections", http.StatusInternalServerError)
+		return
+	}
+	for i := range collections {
+		collections[i].URL = collectionURL(userCtx.Subdomain, collections[i].Slug)
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+
+	err = json.NewEncoder(w).Encode(collections)
+	if err != nil {
+		http.Error(w, "Error encoding JSON response", http.StatusInternalServerError)
+	}
+}
+
+func HandleGetCollection(w http.ResponseWriter, r *http.Request) {
+	userCtx, ok := r.Context().Value("user").(UserContext)
+	if !ok {
+		http.Error(w, "Unauthorized: user context missing", http.StatusUnauthorized)
+		return
+	}
+
+	collection, found := loadCollection(w, r, userCtx.UserID)
+	if !found {
+		return
+	}
+	collection.URL = collectionURL(userCtx.Subdomain, collection.Slug)
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+
+	err := json.NewEncoder(w).Encode(collection)
+	if err != nil {
+		http.Error(w, "Error encoding JSON response", http.StatusInternalServerError)
+	}
+}
+
+// HandleAddCollection creates a collection, recipeIDs may fill it right away.
+func HandleAddCollection(w http.ResponseWriter, r *http.Request) {
+	userCtx, ok := r.Context().Value("user").(UserContext)
+	if !ok {
+		http.Error(w, "Unauthorized: user context missing", http.StatusUnauthorized)
+		return
+	}
+
+	var request collectionRequest
+	if err := json.NewDecoder(r.Body).Decode(&request); err != nil {
+		http.Error(w, "Invalid JSON payload", http.StatusBadRequest)
+		return
+	}
+	if message := validateCollectionRequest(&request, true); message != "" {
+		http.Error(w, message, http.StatusBadRequest)
+		return
+	}
+	description := ""
+	if request.Description != nil {
+		description = *request.Description
+	}
+
+	slug, err := uniqueCollectionSlug(r.Context(), userCtx.UserID, *request.Name, 0)
+	if err != nil {
+		log.Printf("Error generating collection slug: %v\n", err)
+		http.Error(w, "Error adding collection", http.StatusInternalServerError)
+		return
+	}
+
+	// the collection is only created together with all of its recipes
+	var collectionID int
+	err = inTransaction(r.Context(), func(tx pgx.Tx) error {
+		err := tx.QueryRow(r.Context(), "INSERT INTO collections (user_id, name, slug, description) VALUES ($1, $2, $3, $4) RETURNING id",
+			userCtx.UserID, *request.Name, slug, description).Scan(&collectionID)
+		if err != nil {
+			return err
+		}
+		allFound, err := addCollectionRecipes(r.Context(), tx, userCtx.UserID, collectionID, request.RecipeIDs)
+		if err != nil {
+			return err
+		}
+		if !allFound {
+			return errBulkNotFound
+		}
+		return nil
+	})
+	if errors.Is(err, errBulkNotFound) {
+		http.Error(w, "Recipe not found", http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		log.Printf("Error adding collection: %v\n", err)
+		http.Error(w, "Error adding collection", http.StatusInternalServerError)
+		return
+	}
+
+	collection, err := getCollection(r.Context(), userCtx.UserID, collectionID)
+	if err != nil {
+		log.Printf("Error getting collection: %v\n", err)
+		http.Error(w, "Error adding collection", http.StatusInternalServerError)
+		return
+	}
+	recordAudit(r.Context(), userCtx, AuditEntry{Action: auditCollectionCreated, Slug: collection.Slug, After: collectionAuditSummary(collection)})
+
+	if err := templateRecipesBlob(userCtx.Subdomain, userCtx.UserID); err != nil {
+		log.Printf("Error updating recipe template: %v\n", err)
+		http.Error(w, "Failed to update recipe template", http.StatusInternalServerError)
+		return
+	}
+	collection.URL = collectionURL(userCtx.Subdomain, collection.Slug)
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+
+	err = json.NewEncoder(w).Encode(collection)
+	if err != nil {
+		http.Error(w, "Error encoding JSON response", http.StatusInternalServerError)
+	}
+}
+
+// HandleUpdateCollection renames a collection or changes its description.
+// A new name moves the published page to a new slug.
+func HandleUpdateCollection(w http.ResponseWriter, r *http.Request) {
+	userCtx, ok := r.Context().Value("user").(UserContext)
+	if !ok {
+		http.Error(w, "Unauthorized: user context missing", http.StatusUnauthorized)
+		return
+	}
+
+	before, found := loadCollection(w, r, userCtx.UserID)
+	if !found {
+		return
+	}
+
+	var request collectionRequest
+	if err := json.NewDecoder(r.Body).Decode(&request); err != nil {
+		http.Error(w, "Invalid JSON payload", http.StatusBadRequest)
+		return
+	}
+	if message := validateCollectionRequest(&request, false); message != "" {
+		http.Error(w, message, http.StatusBadRequest)
+		return
+	}
+
+	updated := before
+	if request.Description != nil {
+		updated.Description = *request.Description
+	}
+	if request.Name != nil && *request.Name != before.Name {
+		updated.Name = *request.Name
+		slug, err := uniqueCollectionSlug(r.Context(), userCtx.UserID, updated.Name, before.ID)
+		if err != nil {
+			log.Printf("Error generating collection slug: %v\n", err)
+			http.Error(w, "Error updating collection", http.StatusInternalServerError)
+			return
+		}
+		updated.Slug = slug
+	}
+
+	_, err := pool.Exec(r.Context(), "UPDATE collections SET name = $1, slug = $2, description = $3 WHERE id = $4 AND user_id = $5",
+		updated.Name, updated.Slug, updated.Description, before.ID, userCtx.UserID)
+	if err != nil {
+		log.Printf("Error updating collection: %v\n", err)
+		http.Error(w, "Error updating collection", http.StatusInternalServerError)
+		return
+	}
+	recordAudit(r.Context(), userCtx, AuditEntry{
+		Action: auditCollectionUpdated,
+		Slug:   updated.Slug,
+		Before: collectionAuditSummary(before),
+		After:  collectionAuditSummary(updated),
+	})
+
+	if updated.Slug != before.Slug {
+		err = withUserLock(r.Context(), publishLockNamespace, userCtx.UserID, func() error {
+			return removeBlob(userCtx.Subdomain, collectionPath(before.Slug))
+		})
+		if err != nil {
+			log.Printf("Error removing collection page %s: %v\n", before.Slug, err)
+		}
+	}
+	if err := templateRecipesBlob(userCtx.Subdomain, userCtx.UserID); err != nil {
+		log.Printf("Error updating recipe template: %v\n", err)
+		http.Error(w, "Failed to update recipe template", http.StatusInternalServerError)
+		return
+	}
+	updated.URL = collectionURL(userCtx.Subdomain, updated.Slug)
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+
+	err = json.NewEncoder(w).Encode(updated)
+	if err != nil {
+		http.Error(w, "Error encoding JSON response", http.StatusInternalServerError)
+	}
+}
+
+// HandleDeleteCollection removes the collection and its page, the recipes
+// stay.
+func HandleDeleteCollection(w http.ResponseWriter, r *http.Request) {
+	userCtx, ok := r.Context().Value("user").(UserContext)
+	if !ok {
+		http.Error(w, "Unauthorized: user context missing", http.StatusUnauthorized)
+		return
+	}
+
+	collection, found := loadCollection(w, r, userCtx.UserID)
+	if !found {
+		return
+	}
+
+	_, err := pool.Exec(r.Context(), "DELETE FROM collections WHERE id = $1 AND user_id = $2", collection.ID, userCtx.UserID)
+	if err != nil {
+		log.Printf("Error deleting collection: %v\n", err)
+		http.Error(w, "Error deleting collection", http.StatusInternalServerError)
+		return
+	}
+	recordAudit(r.Context(), userCtx, AuditEntry{Action: auditCollectionDeleted, Slug: collection.Slug, Before: collectionAuditSummary(collection)})
+
+	err = withUserLock(r.Context(), publishLockNamespace, userCtx.UserID, func() error {
+		return removeBlob(userCtx.Subdomain, collectionPath(collection.Slug))
+	})
+	if err != nil {
+		log.Printf("Error removing collection page %s: %v\n", collection.Slug, err)
+	}
+	if err := templateRecipesBlob(userCtx.Subdomain, userCtx.UserID); err != nil {
+		log.Printf("Error updating recipe template: %v\n", err)
+		http.Error(w, "Failed to update recipe template", http.StatusInternalServerError)
+		return
+	}
+
+	w.WriteHeader(http.StatusOK)
+}
+
+func HandleAddCollectionRecipe(w http.ResponseWriter, r *http.Request) {
+	setCollectionRecipe(w, r, true)
+}
+
+func HandleRemoveCollectionRecipe(w http.ResponseWriter, r *http.Request) {
+	setCollectionRecipe(w, r, false)
+}
+
+// setCollectionRecipe adds or removes one recipe. Both are idempotent, adding
+// a recipe twice keeps its place and removing a missing one is no error.
+func setCollectionRecipe(w http.ResponseWriter, r *http.Request, add bool) {
+	userCtx, ok := r.Context().Value("user").(UserContext)
+	if !ok {
+		http.Error(w, "Unauthorized: user context missing", http.StatusUnauthorized)
+		return
+	}
+
+	recipeID, err := strconv.Atoi(r.PathValue("recipeID"))
+	if err != nil {
+		http.Error(w, "Invalid recipe ID", http.StatusBadRequest)
+		return
+	}
+
+	before, found := loadCollection(w, r, userCtx.UserID)
+	if !found {
+		return
+	}
+
+	detail := "removed recipe " + strconv.Itoa(recipeID)
+	if add {
+		detail = "added recipe " + strconv.Itoa(recipeID)
+		var allFound bool
+		err := inTransaction(r.Context(), func(tx pgx.Tx) error {
+			var err error
+			allFound, err = addCollectionRecipes(r.Context(), tx, userCtx.UserID, before.ID, []int{recipeID})
+			return err
+		})
+		if err != nil {
+			log.Printf("Error adding recipe to collection %d: %v\n", before.ID, err)
+			http.Error(w, "Error updating collection", http.StatusInternalServerError)
+			return
+		}
+		if !allFound {
+			http.Error(w, "Recipe not found", http.StatusNotFound)
+			return
+		}
+	} else {
+		_, err = pool.Exec(r.Context(), "DELETE FROM collection_recipes WHERE collection_id = $1 AND recipe_id = $2", before.ID, recipeID)
+		if err != nil {
+			log.Printf("Error removing recipe from collection %d: %v\n", before.ID, err)
+			http.Error(w, "Error updating collection", http.StatusInternalServerError)
+			return
+		}
+	}
+
+	collection, err := getCollection(r.Context(), userCtx.UserID, before.ID)
+	if err != nil {
+		log.Printf("Error getting collection: %v\n", err)
+		http.Error(w, "Error updating collection", http.StatusInternalServerError)
+		return
+	}
+	after := collectionAuditSummary(collection)
+	after.Detail += ", " + detail
+	recordAudit(r.Context(), userCtx, AuditEntry{
+		Action:   auditCollectionUpdated,
+		RecipeID: recipeID,
+		Slug:     collection.Slug,
+		Before:   collectionAuditSummary(before),
+		After:    after,
+	})
+
+	if err := templateRecipesBlob(userCtx.Subdomain, userCtx.UserID); err != nil {
+		log.Printf("Error updating recipe template: %v\n", err)
+		http.Error(w, "Failed to update recipe template", http.StatusInternalServerError)
+		return
+	}
+	collection.URL = collectionURL(userCtx.Subdomain, collection.Slug)
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+
+	err = json.NewEncoder(w).Encode(collection)
+	if err != nil {
+		http.Error(w, "Error encoding JSON response", http.StatusInternalServerError)
+	}
+}
+
+// HandleGetCollectionPDF exports the collection as a cookbook: a cover page
+// with the table of contents, then every recipe on a page of its own.
+func HandleGetCollectionPDF(w http.ResponseWriter, r *http.Request) {
+	userCtx, ok := r.Context().Value("user").(UserContext)
+	if !ok {
+		http.Error(w, "Unauthorized: user context missing", http.StatusUnauthorized)
+		return
+	}
+
+	collection, found := loadCollection(w, r, userCtx.UserID)
+	if !found {
+		return
+	}
+
+	recipes, err := collectionRecipes(r.Context(), userCtx.UserID, collection.ID)
+	if err != nil {
+		log.Printf("Error getting recipes of collection %d: %v\n", collection.ID, err)
+		http.Error(w, "Error exporting collection", http.StatusInternalServerError)
+		return
+	}
+
+	doc := newPDFDocument()
+	doc.space(120)
+	doc.text(collection.Name, 26, true, 0)
+	if collection.Description != "" {
+		doc.space(8)
+		doc.text(collection.Description, 12, false, 0)
+	}
+	doc.space(24)
+	doc.text("Inhalt", 14, true, 0)
+	for i, recipe := range recipes {
+		doc.text(strconv.Itoa(i+1)+". "+recipe.Recipename, 11, false, 10)
+	}
+	for _, recipe := range recipes {
+		doc.newPage()
+		recipeToPDF(doc, publishedRecipeMarkdown(recipe.Recipe, recipe.RecipeTiming))
+	}
+
+	w.Header().Set("Content-Type", "application/pdf")
+	w.Header().Set("Content-Disposition", `attachment; filename="`+collection.Slug+`.pdf"`)
+	w.WriteHeader(http.StatusOK)
+	if _, err := w.Write(doc.Bytes()); err != nil {
+		log.Printf("Error writing collection PDF: %v\n", err)
+	}
+}
+
+// loadCollection reads the collection of the {id} path value and answers the
+// request itself when there is none.
+func loadCollection(w http.ResponseWriter, r *http.Request, userID int) (Collection, bool) {
+	collectionID, err := strconv.Atoi(r.PathValue("id"))
+	if err != nil {
+		http.Error(w, "Invalid collection ID", http.StatusBadRequest)
+		return Collection{}, false
+	}
+
+	collection, err := getCollection(r.Context(), userID, collectionID)
+	if err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			http.Error(w, "Collection not found", http.StatusNotFound)
+			return Collection{}, false
+		}
+		log.Printf("Error getting collection: %v\n", err)
+		http.Error(w, "Error getting collection", http.StatusInternalServerError)
+		return Collection{}, false
+	}
+	return collection, true
+}
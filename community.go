@@ -0,0 +1,289 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+)
+
+// maxCommunityPage bounds one page of the community feed.
+const maxCommunityPage = 100
+
+// communityAuthor is the SQL for the author of a recipe of user u: the name
+// they chose in their settings, their subdomain otherwise. The name of the
+// account is never published.
+const communityAuthor = `coalesce((SELECT nullif(s.community_name, '') FROM user_settings s WHERE s.user_id = u.id), u.subdomain)`
+
+// CommunityRecipe is a recipe shared to the community feed, attributed to
+// communityAuthor and linked to their published page.
+type CommunityRecipe struct {
+	Recipe
+	Author   string    `json:"author"`
+	SharedAt time.Time `json:"sharedAt"`
+}
+
+// HandleShareRecipe adds a recipe to the community feed, sharing is opt-in
+// per recipe. A shared recipe stays in the feed with its current content
+// until HandleUnshareRecipe, it drops out while it is archived or a draft.
+func HandleShareRecipe(w http.ResponseWriter, r *http.Request) {
+	setRecipeShared(w, r, true)
+}
+
+func HandleUnshareRecipe(w http.ResponseWriter, r *http.Request) {
+	setRecipeShared(w, r, false)
+}
+
+func setRecipeShared(w http.ResponseWriter, r *http.Request, shared bool) {
+	userCtx, ok := r.Context().Value("user").(UserContext)
+	if !ok {
+		http.Error(w, "Unauthorized: user context missing", http.StatusUnauthorized)
+		return
+	}
+
+	recipeID, err := strconv.Atoi(r.PathValue("id"))
+	if err != nil {
+		http.Error(w, "Invalid recipe ID", http.StatusBadRequest)
+		return
+	}
+
+	var recipe Recipe
+	if shared {
+		err = pool.QueryRow(r.Context(), `
+			UPDATE recipes SET community_shared_at = coalesce(community_shared_at, now())
+			WHERE id = $1 AND user_id = $2 AND NOT archived AND NOT draft
+			RETURNING id, title, category, slug, version`,
+			recipeID, userCtx.UserID).Scan(&recipe.ID, &recipe.Recipename, &recipe.Category, &recipe.Slug, &recipe.Version)
+	} else {
+		err = pool.QueryRow(r.Context(), `
+			UPDATE recipes SET community_shared_at = NULL WHERE id = $1 AND user_id = $2
+			RETURNING id, title, category, slug, version`,
+			recipeID, userCtx.UserID).Scan(&recipe.ID, &recipe.Recipename, &recipe.Category, &recipe.Slug, &recipe.Version)
+	}
+	if errors.Is(err, pgx.ErrNoRows) {
+		writeShareRejection(w, r, userCtx.UserID, recipeID)
+		return
+	}
+	if err != nil {
+		log.Printf("Error sharing recipe: %v\n", err)
+		http.Error(w, "Error sharing recipe", http.StatusInternalServerError)
+		return
+	}
+
+	action := auditRecipeShared
+	if !shared {
+		action = auditRecipeUnshared
+	}
+	recordAudit(r.Context(), userCtx, AuditEntry{
+		Action:   action,
+		RecipeID: recipe.ID,
+		Slug:     recipe.Slug,
+		After:    &AuditSummary{Title: recipe.Recipename, Category: recipe.Category, Version: recipe.Version},
+	})
+	recipe.URL = recipeURL(userCtx.Subdomain, recipe.Slug)
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+
+	err = json.NewEncoder(w).Encode(recipe)
+	if err != nil {
+		http.Error(w, "Error encoding JSON response", http.StatusInternalServerError)
+	}
+}
+
+// writeShareRejection tells why a recipe could not be shared.
+func writeShareRejection(w http.ResponseWriter, r *http.Request, userID int, recipeID int) {
+	var archived, draft bool
+	err := pool.QueryRow(r.Context(), "SELECT archived, draft FROM recipes WHERE id = $1 AND user_id = $2",
+		recipeID, userID).Scan(&archived, &draft)
+	switch {
+	case errors.Is(err, pgx.ErrNoRows):
+		http.Error(w, "Recipe not found", http.StatusNotFound)
+	case err != nil:
+		log.Printf("Error getting recipe: %v\n", err)
+		http.Error(w, "Error sharing recipe", http.StatusInternalServerError)
+	case archived:
+		http.Error(w, "Archived recipes can't be shared", http.StatusBadRequest)
+	default:
+		http.Error(w, "Publish the draft before sharing it", http.StatusBadRequest)
+	}
+}
+
+// HandleGetCommunityRecipes lists the community feed, newest shares first.
+// q searches titles and content, category and diet filter like the
+// collection, limit and offset page through the feed.
+func HandleGetCommunityRecipes(w http.ResponseWriter, r *http.Request) {
+	query := r.URL.Query()
+	limit := 50
+	if value := query.Get("limit"); value != "" {
+		parsed, err := strconv.Atoi(value)
+		if err != nil || parsed <= 0 {
+			http.Error(w, "limit must be a positive number", http.StatusBadRequest)
+			return
+		}
+		limit = min(parsed, maxCommunityPage)
+	}
+	offset := 0
+	if value := query.Get("offset"); value != "" {
+		parsed, err := strconv.Atoi(value)
+		if err != nil || parsed < 0 {
+			http.Error(w, "offset must not be negative", http.StatusBadRequest)
+			return
+		}
+		offset = parsed
+	}
+	diet := query.Get("diet")
+	if diet != "" && !diets[diet] {
+		http.Error(w, "diet must be vegan, vegetarian or contains-meat", http.StatusBadRequest)
+		return
+	}
+
+	recipes, err := listCommunityRecipes(r.Context(), communityFilter{
+		Query:    strings.TrimSpace(query.Get("q")),
+		Category: query.Get("category"),
+		Diet:     diet,
+		Limit:    limit,
+		Offset:   offset,
+	})
+	if err != nil {
+		log.Printf("Error getting community recipes: %v\n", err)
+		http.Error(w, "Error getting community recipes", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+
+	err = json.NewEncoder(w).Encode(recipes)
+	if err != nil {
+		http.Error(w, "Error encoding JSON response", http.StatusInternalServerError)
+	}
+}
+
+// HandleGetCommunityRecipe returns one recipe of the community feed.
+func HandleGetCommunityRecipe(w http.ResponseWriter, r *http.Request) {
+	recipeID, err := strconv.Atoi(r.PathValue("id"))
+	if err != nil {
+		http.Error(w, "Invalid recipe ID", http.StatusBadRequest)
+		return
+	}
+
+	recipes, err := listCommunityRecipes(r.Context(), communityFilter{RecipeID: recipeID, Limit: 1})
+	if err != nil {
+		log.Printf("Error getting community recipe %d: %v\n", recipeID, err)
+		http.Error(w, "Error getting community recipe", http.StatusInternalServerError)
+		return
+	}
+	if len(recipes) == 0 {
+		http.Error(w, "Recipe not found", http.StatusNotFound)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+
+	err = json.NewEncoder(w).Encode(recipes[0])
+	if err != nil {
+		http.Error(w, "Error encoding JSON response", http.StatusInternalServerError)
+	}
+}
+
+// HandleForkCommunityRecipe saves a copy of a community recipe to the user's
+// own collection with the original as its parent, its source links the
+// author's published page.
+func HandleForkCommunityRecipe(w http.ResponseWriter, r *http.Request) {
+	userCtx, ok := r.Context().Value("user").(UserContext)
+	if !ok {
+		http.Error(w, "Unauthorized: user context missing", http.StatusUnauthorized)
+		return
+	}
+
+	recipeID, err := strconv.Atoi(r.PathValue("id"))
+	if err != nil {
+		http.Error(w, "Invalid recipe ID", http.StatusBadRequest)
+		return
+	}
+
+	recipes, err := listCommunityRecipes(r.Context(), communityFilter{RecipeID: recipeID, Limit: 1})
+	if err != nil {
+		log.Printf("Error getting community recipe %d: %v\n", recipeID, err)
+		http.Error(w, "Error getting community recipe", http.StatusInternalServerError)
+		return
+	}
+	if len(recipes) == 0 {
+		http.Error(w, "Recipe not found", http.StatusNotFound)
+		return
+	}
+	original := recipes[0]
+
+	fork, err := addRecipe(r.Context(), userCtx, RecipeRequest{
+		Recipename:     original.Recipename,
+		Recipe:         original.Recipe.Recipe,
+		RecipeCategory: original.Category,
+		RecipeTiming:   original.RecipeTiming,
+		SourceURL:      original.URL,
+		ParentRecipeID: original.ID,
+	})
+	if err != nil {
+		log.Printf("Error forking community recipe %d: %v\n", recipeID, err)
+		http.Error(w, "Error adding recipe", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("ETag", recipeETag(fork.Version))
+	w.WriteHeader(http.StatusCreated)
+
+	err = json.NewEncoder(w).Encode(fork)
+	if err != nil {
+		http.Error(w, "Error encoding JSON response", http.StatusInternalServerError)
+	}
+}
+
+// communityFilter selects from the community feed, RecipeID picks a single
+// recipe.
+type communityFilter struct {
+	RecipeID int
+	Query    string
+	Category string
+	Diet     string
+	Limit    int
+	Offset   int
+}
+
+func listCommunityRecipes(ctx context.Context, filter communityFilter) ([]CommunityRecipe, error) {
+	pattern := "%" + likeEscaper.Replace(filter.Query) + "%"
+	rows, err := pool.Query(ctx, `
+		SELECT r.id, r.title, r.content, r.category, r.slug, r.diet, r.prep_minutes, r.cook_minutes, r.total_minutes, r.difficulty,
+		       r.community_shared_at, `+communityAuthor+`, u.subdomain
+		FROM recipes r JOIN users u ON u.id = r.user_id
+		WHERE r.community_shared_at IS NOT NULL AND NOT r.archived AND NOT r.draft
+		  AND ($1 = 0 OR r.id = $1) AND (r.title ILIKE $2 OR r.content ILIKE $2) AND ($3 = '' OR r.category = $3)
+		  AND ($4 = '' OR r.diet = $4 OR ($4 = 'vegetarian' AND r.diet = 'vegan'))
+		ORDER BY r.community_shared_at DESC, r.id DESC LIMIT $5 OFFSET $6`,
+		filter.RecipeID, pattern, filter.Category, filter.Diet, filter.Limit, filter.Offset)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	recipes := []CommunityRecipe{}
+	for rows.Next() {
+		var recipe CommunityRecipe
+		var subdomain string
+		err := rows.Scan(&recipe.ID, &recipe.Recipename, &recipe.Recipe.Recipe, &recipe.Category, &recipe.Slug, &recipe.Diet,
+			&recipe.PrepTime, &recipe.CookTime, &recipe.TotalTime, &recipe.Difficulty, &recipe.SharedAt, &recipe.Author, &subdomain)
+		if err != nil {
+			return nil, err
+		}
+		recipe.URL = recipeURL(subdomain, recipe.Slug)
+		recipes = append(recipes, recipe)
+	}
+	return recipes, rows.Err()
+}
@@ -0,0 +1,222 @@
+package main
+
+import (
+	"bufio"
+	"compress/gzip"
+	"encoding/json"
+	"errors"
+	"net"
+	"net/http"
+	"strings"
+	"sync"
+)
+
+// Responses are gzip compressed for clients that accept it. Brotli would
+// compress markdown a little better, but neither the standard library nor
+// our dependencies have an encoder, and every browser takes gzip.
+const (
+	// compressMinBytes leaves small answers alone, the gzip header and the
+	// work don't pay off below it
+	compressMinBytes = 1024
+	// streamFlushEvery is how many list items writeJSONArray writes before
+	// it flushes
+	streamFlushEvery = 100
+)
+
+// compressedTypes are the content types worth compressing, images, audio
+// and PDFs are compressed already.
+var compressedTypes = []string{"application/json", "application/xml", "application/javascript", "text/"}
+
+var gzipWriters = sync.Pool{New: func() any { return gzip.NewWriter(nil) }}
+
+// withCompression compresses the response for clients that accept gzip.
+// WebSocket upgrades and range requests are passed through untouched.
+func withCompression(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Add("Vary", "Accept-Encoding")
+		if !acceptsGzip(r) || r.Header.Get("Upgrade") != "" || r.Header.Get("Range") != "" {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		cw := &compressWriter{ResponseWriter: w, status: http.StatusOK}
+		defer cw.close()
+		next.ServeHTTP(cw, r)
+	})
+}
+
+func acceptsGzip(r *http.Request) bool {
+	for _, encoding := range strings.Split(r.Header.Get("Accept-Encoding"), ",") {
+		name, params, _ := strings.Cut(strings.TrimSpace(encoding), ";")
+		if strings.EqualFold(strings.TrimSpace(name), "gzip") && strings.ReplaceAll(params, " ", "") != "q=0" {
+			return true
+		}
+	}
+	return false
+}
+
+// compressWriter holds the first compressMinBytes back to decide whether
+// the response is compressed. Flush decides early, streamed responses
+// start compressed as soon as they flush.
+type compressWriter struct {
+	http.ResponseWriter
+	status      int
+	wroteHeader bool
+	decided     bool
+	buffer      []byte
+	gz          *gzip.Writer
+}
+
+func (cw *compressWriter) WriteHeader(status int) {
+	if cw.wroteHeader {
+		return
+	}
+	// informational answers go out as they are
+	if status < http.StatusOK {
+		cw.ResponseWriter.WriteHeader(status)
+		return
+	}
+	cw.status, cw.wroteHeader = status, true
+	if !cw.compressible() {
+		cw.decide(false)
+	}
+}
+
+func (cw *compressWriter) Write(data []byte) (int, error) {
+	if !cw.wroteHeader {
+		cw.WriteHeader(http.StatusOK)
+	}
+	if !cw.decided {
+		cw.buffer = append(cw.buffer, data...)
+		if len(cw.buffer) >= compressMinBytes {
+			if err := cw.decide(true); err != nil {
+				return 0, err
+			}
+		}
+		return len(data), nil
+	}
+	if cw.gz != nil {
+		return cw.gz.Write(data)
+	}
+	return cw.ResponseWriter.Write(data)
+}
+
+// compressible reports whether the handler left the response open to
+// compression, by type, status and headers.
+func (cw *compressWriter) compressible() bool {
+	header := cw.Header()
+	if cw.status == http.StatusNoContent || cw.status == http.StatusNotModified || cw.status == http.StatusPartialContent {
+		return false
+	}
+	if header.Get("Content-Encoding") != "" || header.Get("Content-Range") != "" {
+		return false
+	}
+	contentType := header.Get("Content-Type")
+	for _, compressed := range compressedTypes {
+		if strings.HasPrefix(contentType, compressed) {
+			return true
+		}
+	}
+	return false
+}
+
+// decide writes the header, compressed or not, and what was held back.
+func (cw *compressWriter) decide(compress bool) error {
+	cw.decided = true
+	if compress {
+		header := cw.Header()
+		header.Del("Content-Length")
+		header.Set("Content-Encoding", "gzip")
+		// the compressed bytes differ, only a weak validator still holds
+		if etag := header.Get("ETag"); etag != "" && !strings.HasPrefix(etag, "W/") {
+			header.Set("ETag", "W/"+etag)
+		}
+		cw.gz = gzipWriters.Get().(*gzip.Writer)
+		cw.gz.Reset(cw.ResponseWriter)
+	}
+	cw.ResponseWriter.WriteHeader(cw.status)
+
+	buffered := cw.buffer
+	cw.buffer = nil
+	if len(buffered) == 0 {
+		return nil
+	}
+	var err error
+	if cw.gz != nil {
+		_, err = cw.gz.Write(buffered)
+	} else {
+		_, err = cw.ResponseWriter.Write(buffered)
+	}
+	return err
+}
+
+func (cw *compressWriter) Flush() {
+	if !cw.wroteHeader {
+		cw.WriteHeader(http.StatusOK)
+	}
+	if !cw.decided {
+		_ = cw.decide(cw.compressible())
+	}
+	if cw.gz != nil {
+		_ = cw.gz.Flush()
+	}
+	_ = http.NewResponseController(cw.ResponseWriter).Flush()
+}
+
+// close writes what is still held back uncompressed, it was too short,
+// and ends the gzip stream.
+func (cw *compressWriter) close() {
+	if !cw.wroteHeader {
+		// the handler wrote nothing, net/http answers 200 on its own
+		return
+	}
+	if !cw.decided {
+		_ = cw.decide(false)
+	}
+	if cw.gz != nil {
+		_ = cw.gz.Close()
+		gzipWriters.Put(cw.gz)
+		cw.gz = nil
+	}
+}
+
+func (cw *compressWriter) Hijack() (net.Conn, *bufio.ReadWriter, error) {
+	if hijacker, ok := cw.ResponseWriter.(http.Hijacker); ok {
+		return hijacker.Hijack()
+	}
+	return nil, nil, errors.New("response writer does not support hijacking")
+}
+
+func (cw *compressWriter) Unwrap() http.ResponseWriter {
+	return cw.ResponseWriter
+}
+
+// writeJSONArray encodes the items one by one instead of the whole list at
+// once, so a large collection goes out while it is encoded.
+func writeJSONArray[T any](w http.ResponseWriter, items []T) error {
+	if items == nil {
+		// as json.Encoder writes a nil slice
+		_, err := w.Write([]byte("null\n"))
+		return err
+	}
+	controller := http.NewResponseController(w)
+	if _, err := w.Write([]byte("[")); err != nil {
+		return err
+	}
+	encoder := json.NewEncoder(w)
+	for i, item := range items {
+		if i > 0 {
+			if _, err := w.Write([]byte(",")); err != nil {
+				return err
+			}
+		}
+		if err := encoder.Encode(item); err != nil {
+			return err
+		}
+		if (i+1)%streamFlushEvery == 0 {
+			_ = controller.Flush()
+		}
+	}
+	_, err := w.Write([]byte("]\n"))
+	return err
+}
@@ -0,0 +1,350 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+)
+
+// maxGalleryPhotos keeps the gallery of often cooked recipes short, the
+// newest photos are shown.
+const maxGalleryPhotos = 12
+
+const maxSessionNotesLength = 2000
+
+type CookingSession struct {
+	ID       int       `json:"id"`
+	RecipeID int       `json:"recipeID"`
+	CookedAt time.Time `json:"cookedAt"`
+	Notes    string    `json:"notes,omitempty"`
+	// Photo is the path of the photo on the published site, empty when the
+	// session was logged without one.
+	Photo    string `json:"photo,omitempty"`
+	PhotoURL string `json:"photoURL,omitempty"`
+}
+
+// sessionPhotoPath names the photo after the session, a recipe can be
+// renamed without moving its photos.
+func sessionPhotoPath(sessionID int, contentType string) string {
+	extension := ".jpg"
+	if contentType == "image/webp" {
+		extension = ".webp"
+	}
+	return "photos/" + strconv.Itoa(sessionID) + extension
+}
+
+// HandleAddCookingSession logs that a recipe was cooked. The multipart form
+// takes an optional photo of the result, notes and cookedAt (RFC 3339 or a
+// date, today when missing).
+func HandleAddCookingSession(w http.ResponseWriter, r *http.Request) {
+	userCtx, ok := r.Context().Value("user").(UserContext)
+	if !ok {
+		http.Error(w, "Unauthorized: user context missing", http.StatusUnauthorized)
+		return
+	}
+
+	recipeID, err := strconv.Atoi(r.PathValue("id"))
+	if err != nil {
+		http.Error(w, "Invalid recipe ID", http.StatusBadRequest)
+		return
+	}
+
+	// Parse multipart form data (10 MB max)
+	if err := r.ParseMultipartForm(10 << 20); err != nil {
+		http.Error(w, "Failed to parse form data", http.StatusBadRequest)
+		return
+	}
+
+	notes := strings.TrimSpace(r.FormValue("notes"))
+	if len([]rune(notes)) > maxSessionNotesLength {
+		http.Error(w, "notes must be at most "+strconv.Itoa(maxSessionNotesLength)+" characters", http.StatusBadRequest)
+		return
+	}
+	// notes are shown below the photo as a single paragraph
+	notes = strings.Join(strings.Fields(notes), " ")
+
+	cookedAt := time.Now()
+	if value := r.FormValue("cookedAt"); value != "" {
+		cookedAt, err = time.Parse(time.RFC3339, value)
+		if err != nil {
+			cookedAt, err = time.ParseInLocation(time.DateOnly, value, time.Local)
+		}
+		if err != nil {
+			http.Error(w, "cookedAt must be a date or an RFC 3339 timestamp", http.StatusBadRequest)
+			return
+		}
+	}
+
+	var photo []byte
+	if file, _, err := r.FormFile("photo"); err == nil {
+		data, err := io.ReadAll(file)
+		file.Close()
+		if err != nil {
+			http.Error(w, "Failed to read the photo", http.StatusBadRequest)
+			return
+		}
+		photo, err = prepareImage(data)
+		if err != nil {
+			if errors.Is(err, errUnsupportedImage) {
+				http.Error(w, "Unsupported image format, please upload a JPEG, PNG, HEIC or WebP", http.StatusUnsupportedMediaType)
+				return
+			}
+			if errors.Is(err, errImageTooLarge) {
+				http.Error(w, "Image too large, please upload a photo of at most 50 megapixels", http.StatusRequestEntityTooLarge)
+				return
+			}
+			log.Printf("Error preparing photo: %v\n", err)
+			http.Error(w, "Failed to prepare the photo", http.StatusInternalServerError)
+			return
+		}
+	} else if !errors.Is(err, http.ErrMissingFile) {
+		http.Error(w, "Failed to get the photo", http.StatusBadRequest)
+		return
+	}
+	if photo == nil && notes == "" && r.FormValue("cookedAt") == "" {
+		http.Error(w, "Send a photo, notes or cookedAt", http.StatusBadRequest)
+		return
+	}
+
+	var slug string
+	err = pool.QueryRow(r.Context(), "SELECT slug FROM recipes WHERE id = $1 AND user_id = $2", recipeID, userCtx.UserID).Scan(&slug)
+	if err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			http.Error(w, "Recipe not found", http.StatusNotFound)
+			return
+		}
+		log.Printf("Error getting recipe: %v\n", err)
+		http.Error(w, "Error getting recipe", http.StatusInternalServerError)
+		return
+	}
+
+	session := CookingSession{RecipeID: recipeID, CookedAt: cookedAt, Notes: notes}
+	err = pool.QueryRow(r.Context(), "INSERT INTO cooking_sessions (user_id, recipe_id, cooked_at, notes) VALUES ($1, $2, $3, $4) RETURNING id",
+		userCtx.UserID, recipeID, cookedAt, notes).Scan(&session.ID)
+	if err != nil {
+		log.Printf("Error adding cooking session: %v\n", err)
+		http.Error(w, "Error adding cooking session", http.StatusInternalServerError)
+		return
+	}
+
+	if photo != nil {
+		session.Photo = sessionPhotoPath(session.ID, http.DetectContentType(photo))
+		// Embedded sites have no storage, their photos are served from the
+		// database.
+		var photoData []byte
+		if resolveStorage(userCtx.Subdomain).Mode == storageModeEmbedded {
+			photoData = photo
+		}
+		_, err = pool.Exec(r.Context(), "UPDATE cooking_sessions SET photo = $1, photo_data = $2 WHERE id = $3",
+			session.Photo, photoData, session.ID)
+		if err == nil {
+			err = withUserLock(r.Context(), publishLockNamespace, userCtx.UserID, func() error {
+				return addBlob(userCtx.Subdomain, session.Photo, string(photo))
+			})
+		}
+		if err != nil {
+			log.Printf("Error storing photo of cooking session %d: %v\n", session.ID, err)
+			http.Error(w, "Error storing photo", http.StatusInternalServerError)
+			return
+		}
+		session.PhotoURL = siteURL(userCtx.Subdomain) + session.Photo
+	}
+
+	if err := publishRecipeBlob(userCtx.Subdomain, userCtx.UserID, slug); err != nil {
+		log.Printf("Error publishing recipe: %v\n", err)
+		http.Error(w, "Failed to publish recipe", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+
+	err = json.NewEncoder(w).Encode(session)
+	if err != nil {
+		http.Error(w, "Error encoding JSON response", http.StatusInternalServerError)
+	}
+}
+
+// HandleListCookingSessions lists the sessions of a recipe, newest first.
+func HandleListCookingSessions(w http.ResponseWriter, r *http.Request) {
+	userCtx, ok := r.Context().Value("user").(UserContext)
+	if !ok {
+		http.Error(w, "Unauthorized: user context missing", http.StatusUnauthorized)
+		return
+	}
+
+	recipeID, err := strconv.Atoi(r.PathValue("id"))
+	if err != nil {
+		http.Error(w, "Invalid recipe ID", http.StatusBadRequest)
+		return
+	}
+
+	sessions, err := cookingSessions(r.Context(), userCtx.UserID, recipeID, false)
+	if err != nil {
+		log.Printf("Error getting cooking sessions: %v\n", err)
+		http.Error(w, "Error getting cooking sessions", http.StatusInternalServerError)
+		return
+	}
+	for i := range sessions {
+		if sessions[i].Photo != "" {
+			sessions[i].PhotoURL = siteURL(userCtx.Subdomain) + sessions[i].Photo
+		}
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+
+	err = json.NewEncoder(w).Encode(sessions)
+	if err != nil {
+		http.Error(w, "Error encoding JSON response", http.StatusInternalServerError)
+	}
+}
+
+// HandleDeleteCookingSession removes a session and takes its photo off the
+// site.
+func HandleDeleteCookingSession(w http.ResponseWriter, r *http.Request) {
+	userCtx, ok := r.Context().Value("user").(UserContext)
+	if !ok {
+		http.Error(w, "Unauthorized: user context missing", http.StatusUnauthorized)
+		return
+	}
+
+	recipeID, err := strconv.Atoi(r.PathValue("id"))
+	if err != nil {
+		http.Error(w, "Invalid recipe ID", http.StatusBadRequest)
+		return
+	}
+	sessionID, err := strconv.Atoi(r.PathValue("sessionID"))
+	if err != nil {
+		http.Error(w, "Invalid session ID", http.StatusBadRequest)
+		return
+	}
+
+	var photo, slug string
+	err = pool.QueryRow(r.Context(), `
+		DELETE FROM cooking_sessions s USING recipes r
+		WHERE s.id = $1 AND s.recipe_id = $2 AND s.user_id = $3 AND r.id = s.recipe_id
+		RETURNING s.photo, r.slug`,
+		sessionID, recipeID, userCtx.UserID).Scan(&photo, &slug)
+	if err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			http.Error(w, "Cooking session not found", http.StatusNotFound)
+			return
+		}
+		log.Printf("Error deleting cooking session: %v\n", err)
+		http.Error(w, "Error deleting cooking session", http.StatusInternalServerError)
+		return
+	}
+
+	if err := publishRecipeBlob(userCtx.Subdomain, userCtx.UserID, slug); err != nil {
+		log.Printf("Error publishing recipe: %v\n", err)
+		http.Error(w, "Failed to publish recipe", http.StatusInternalServerError)
+		return
+	}
+	removeSessionPhotos(userCtx.Subdomain, userCtx.UserID, []string{photo})
+
+	w.WriteHeader(http.StatusOK)
+}
+
+// cookingSessions returns the sessions of a recipe newest first, withPhoto
+// only those with a photo, as many as the gallery shows.
+func cookingSessions(ctx context.Context, userID int, recipeID int, withPhoto bool) ([]CookingSession, error) {
+	limit := 0
+	if withPhoto {
+		limit = maxGalleryPhotos
+	}
+	rows, err := pool.Query(ctx, `
+		SELECT id, recipe_id, cooked_at, notes, photo FROM cooking_sessions
+		WHERE user_id = $1 AND recipe_id = $2 AND (NOT $3 OR photo <> '')
+		ORDER BY cooked_at DESC, id DESC LIMIT nullif($4, 0)`,
+		userID, recipeID, withPhoto, limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	sessions := []CookingSession{}
+	for rows.Next() {
+		var session CookingSession
+		if err := rows.Scan(&session.ID, &session.RecipeID, &session.CookedAt, &session.Notes, &session.Photo); err != nil {
+			return nil, err
+		}
+		sessions = append(sessions, session)
+	}
+	return sessions, rows.Err()
+}
+
+// sessionPhotos returns the photo paths of a recipe's sessions, to take them
+// off the site when the recipe is deleted.
+func sessionPhotos(ctx context.Context, userID int, recipeID int) ([]string, error) {
+	rows, err := pool.Query(ctx, "SELECT photo FROM cooking_sessions WHERE user_id = $1 AND recipe_id = $2 AND photo <> ''", userID, recipeID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var photos []string
+	for rows.Next() {
+		var photo string
+		if err := rows.Scan(&photo); err != nil {
+			return nil, err
+		}
+		photos = append(photos, photo)
+	}
+	return photos, rows.Err()
+}
+
+// removeSessionPhotos deletes photos from the site. A photo left behind is
+// logged, the session is gone already.
+func removeSessionPhotos(subdomain string, userID int, photos []string) {
+	err := withUserLock(context.Background(), publishLockNamespace, userID, func() error {
+		for _, photo := range photos {
+			if photo == "" {
+				continue
+			}
+			if err := removeBlob(subdomain, photo); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		log.Printf("Error removing cooking session photos of user %d: %v\n", userID, err)
+	}
+}
+
+// renderGallery lists the photos of a recipe for its published page, empty
+// when there are none.
+func renderGallery(sessions []CookingSession) string {
+	if len(sessions) == 0 {
+		return ""
+	}
+	var gallery strings.Builder
+	gallery.WriteString("## Galerie\n")
+	for _, session := range sessions {
+		date := session.CookedAt.Format("02.01.2006")
+		gallery.WriteString("\n![Gekocht am " + date + "](./" + session.Photo + ")\n\n*" + date + "*")
+		if session.Notes != "" {
+			gallery.WriteString(" – " + session.Notes)
+		}
+		gallery.WriteString("\n")
+	}
+	return gallery.String()
+}
+
+// getSessionPhoto returns a photo of an embedded site.
+func getSessionPhoto(ctx context.Context, userID int, photo string) ([]byte, time.Time, error) {
+	var data []byte
+	var cookedAt time.Time
+	err := pool.QueryRow(ctx, "SELECT photo_data, cooked_at FROM cooking_sessions WHERE user_id = $1 AND photo = $2 AND photo_data IS NOT NULL",
+		userID, photo).Scan(&data, &cookedAt)
+	return data, cookedAt, err
+}
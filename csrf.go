@@ -0,0 +1,99 @@
+package main
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"log"
+	"net/http"
+	"os"
+)
+
+// The browser frontend behind App Service authentication is logged in with
+// the Easy Auth session cookie, which the browser also sends on requests
+// other sites make. Mutating requests carrying the cookie need a token that
+// only the frontend can read.
+const (
+	easyAuthCookie = "AppServiceAuthSession"
+	csrfHeader     = "X-CSRF-Token"
+)
+
+var csrfKey []byte
+
+// initCSRF reads CSRF_SECRET. Without it a key is generated, tokens then
+// only hold on this instance and until a restart.
+func initCSRF() {
+	if secret, ok := lookupSecret("CSRF_SECRET"); ok && secret != "" {
+		csrfKey = []byte(secret)
+		return
+	}
+	if os.Getenv("MULTI_INSTANCE") == "true" {
+		log.Fatal("CSRF_SECRET is required when MULTI_INSTANCE=true")
+	}
+	csrfKey = make([]byte, 32)
+	if _, err := rand.Read(csrfKey); err != nil {
+		log.Fatalf("Unable to generate CSRF key: %v\n", err)
+	}
+}
+
+// csrfEnabled is true unless CSRF_PROTECTION=off, meant for deployments
+// without the cookie frontend.
+func csrfEnabled() bool {
+	return os.Getenv("CSRF_PROTECTION") != "off"
+}
+
+// csrfToken is bound to the session cookie, a new session gets a new token
+// and nothing has to be stored.
+func csrfToken(session string) string {
+	mac := hmac.New(sha256.New, csrfKey)
+	mac.Write([]byte(session))
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+// withCSRF checks the token on mutating requests that carry the session
+// cookie. API-key and bearer-token clients don't send the cookie and are
+// not affected. Browsers can't set the header cross-site, so webhooks and
+// assistants need no exception.
+func withCSRF(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.Method {
+		case http.MethodGet, http.MethodHead, http.MethodOptions:
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		cookie, err := r.Cookie(easyAuthCookie)
+		if !csrfEnabled() || err != nil || cookie.Value == "" {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		token := r.Header.Get(csrfHeader)
+		if token == "" || !hmac.Equal([]byte(token), []byte(csrfToken(cookie.Value))) {
+			http.Error(w, "Missing or invalid CSRF token", http.StatusForbidden)
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// HandleGetCSRFToken returns the token for the session cookie of the
+// request, the frontend sends it as X-CSRF-Token.
+func HandleGetCSRFToken(w http.ResponseWriter, r *http.Request) {
+	cookie, err := r.Cookie(easyAuthCookie)
+	if err != nil || cookie.Value == "" {
+		http.Error(w, "Missing session cookie", http.StatusBadRequest)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "no-store")
+	w.WriteHeader(http.StatusOK)
+
+	err = json.NewEncoder(w).Encode(map[string]string{"token": csrfToken(cookie.Value)})
+	if err != nil {
+		http.Error(w, "Error encoding JSON response", http.StatusInternalServerError)
+	}
+}
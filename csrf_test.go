@@ -0,0 +1,45 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestCSRF(t *testing.T) {
+	csrfKey = []byte("test-key")
+	t.Cleanup(func() { csrfKey = nil })
+	next := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusNoContent)
+	})
+
+	tests := []struct {
+		name   string
+		target string
+		auth   string
+		token  string
+		want   int
+	}{
+		{"without token", "/api/v1/recipe", "", "", http.StatusForbidden},
+		{"valid token", "/api/v1/recipe", "", csrfToken("session"), http.StatusNoContent},
+		{"key parameter", "/api/v1/recipe?key=" + apiKeyPrefix + "x", "", "", http.StatusForbidden},
+		{"key header", "/api/v1/recipe", "Bearer " + apiKeyPrefix + "x", "", http.StatusForbidden},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest("POST", tt.target, nil)
+			req.AddCookie(&http.Cookie{Name: easyAuthCookie, Value: "session"})
+			if tt.auth != "" {
+				req.Header.Set("Authorization", tt.auth)
+			}
+			if tt.token != "" {
+				req.Header.Set(csrfHeader, tt.token)
+			}
+			rec := httptest.NewRecorder()
+			withCSRF(next).ServeHTTP(rec, req)
+			if rec.Code != tt.want {
+				t.Errorf("status = %d, want %d", rec.Code, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,142 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"strconv"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgxpool"
+)
+
+// userByOauthID finds the user of an oauth ID, linked identities included.
+// While a linked account is being deleted its oauth ID matches two users, the
+// one it was linked to wins.
+const userByOauthID = "(oauth_id = $1 OR id = (SELECT user_id FROM user_identities WHERE oauth_id = $1)) ORDER BY oauth_id = $1 LIMIT 1"
+
+// Queries run on (nearly) every request. They are prepared on each new
+// connection under their own SQL text, so callers keep passing the SQL.
+const (
+	queryUserByOauthID = "SELECT subdomain, id, provisioning_status, storage_mode FROM users WHERE " + userByOauthID
+	queryRecipesByUser = "SELECT id, title, content, category, slug, version, updated_at, season_tags, prep_minutes, cook_minutes, total_minutes, difficulty, language, coalesce(translation_of, 0), archived, featured_at, cuisine, coalesce(variant_of, 0), appliance, draft, diet, coalesce(parent_recipe_id, 0), created_at, published_at, publish_error FROM recipes WHERE user_id = $1"
+	queryUserPlan      = "SELECT plan FROM users WHERE id = $1"
+)
+
+var preparedQueries = []string{queryUserByOauthID, queryRecipesByUser, queryUserPlan}
+
+var queryExecModes = map[string]pgx.QueryExecMode{
+	"cache_statement": pgx.QueryExecModeCacheStatement,
+	"cache_describe":  pgx.QueryExecModeCacheDescribe,
+	"describe_exec":   pgx.QueryExecModeDescribeExec,
+	"exec":            pgx.QueryExecModeExec,
+	"simple_protocol": pgx.QueryExecModeSimpleProtocol,
+}
+
+// dbPoolConfig applies the DB_* pool settings on top of DB_URL. Use
+// DB_STATEMENT_CACHE_MODE=exec or simple_protocol behind PgBouncer in
+// transaction mode, which cannot keep prepared statements.
+func dbPoolConfig(dbURL string) (*pgxpool.Config, error) {
+	config, err := pgxpool.ParseConfig(dbURL)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := envInt32("DB_MAX_CONNS", &config.MaxConns); err != nil {
+		return nil, err
+	}
+	if err := envInt32("DB_MIN_CONNS", &config.MinConns); err != nil {
+		return nil, err
+	}
+	if err := envDuration("DB_HEALTH_CHECK_PERIOD", &config.HealthCheckPeriod); err != nil {
+		return nil, err
+	}
+	if err := envDuration("DB_MAX_CONN_LIFETIME", &config.MaxConnLifetime); err != nil {
+		return nil, err
+	}
+	if err := envDuration("DB_MAX_CONN_IDLE_TIME", &config.MaxConnIdleTime); err != nil {
+		return nil, err
+	}
+
+	if value := os.Getenv("DB_STATEMENT_CACHE_MODE"); value != "" {
+		mode, ok := queryExecModes[value]
+		if !ok {
+			return nil, fmt.Errorf("unknown DB_STATEMENT_CACHE_MODE %q", value)
+		}
+		config.ConnConfig.DefaultQueryExecMode = mode
+	}
+
+	mode := config.ConnConfig.DefaultQueryExecMode
+	if mode != pgx.QueryExecModeExec && mode != pgx.QueryExecModeSimpleProtocol {
+		// Preparing fails for the connection migrateDB runs on before the
+		// schema is complete, which is fine: the query is then prepared by
+		// the statement cache on first use instead.
+		config.AfterConnect = func(ctx context.Context, conn *pgx.Conn) error {
+			for _, query := range preparedQueries {
+				if _, err := conn.Prepare(ctx, query, query); err != nil {
+					log.Printf("Failed to prepare %q: %v", query, err)
+				}
+			}
+			return nil
+		}
+	}
+
+	return config, nil
+}
+
+func envInt32(name string, dest *int32) error {
+	value := os.Getenv(name)
+	if value == "" {
+		return nil
+	}
+
+	n, err := strconv.ParseInt(value, 10, 32)
+	if err != nil {
+		return fmt.Errorf("invalid %s: %w", name, err)
+	}
+	*dest = int32(n)
+	return nil
+}
+
+func envDuration(name string, dest *time.Duration) error {
+	value := os.Getenv(name)
+	if value == "" {
+		return nil
+	}
+
+	d, err := time.ParseDuration(value)
+	if err != nil {
+		return fmt.Errorf("invalid %s: %w", name, err)
+	}
+	*dest = d
+	return nil
+}
+
+func HandleDBStats(w http.ResponseWriter, _ *http.Request) {
+	stat := pool.Stat()
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+
+	err := json.NewEncoder(w).Encode(map[string]interface{}{
+		"maxConns":                pool.Config().MaxConns,
+		"totalConns":              stat.TotalConns(),
+		"acquiredConns":           stat.AcquiredConns(),
+		"idleConns":               stat.IdleConns(),
+		"constructingConns":       stat.ConstructingConns(),
+		"acquireCount":            stat.AcquireCount(),
+		"acquireDurationMs":       stat.AcquireDuration().Milliseconds(),
+		"emptyAcquireCount":       stat.EmptyAcquireCount(),
+		"canceledAcquireCount":    stat.CanceledAcquireCount(),
+		"newConnsCount":           stat.NewConnsCount(),
+		"maxLifetimeDestroyCount": stat.MaxLifetimeDestroyCount(),
+		"maxIdleDestroyCount":     stat.MaxIdleDestroyCount(),
+	})
+	if err != nil {
+		log.Println("Error writing response:", err)
+	}
+}
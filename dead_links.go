@@ -0,0 +1,192 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"log"
+	"net/http"
+	"regexp"
+	"time"
+
+	"github.com/Azure/azure-sdk-for-go/sdk/storage/azblob/bloberror"
+	"github.com/jackc/pgx/v5"
+)
+
+const (
+	// deadLinkMissingPage is a link to a published recipe whose page is not
+	// in storage, the upload failed or the blob was removed.
+	deadLinkMissingPage = "missing-page"
+	// deadLinkUnknownRecipe is a link no published recipe has, the recipe
+	// was renamed, archived or deleted and the index is behind.
+	deadLinkUnknownRecipe = "unknown-recipe"
+)
+
+// indexRecipeLink matches the recipe links renderIndexSections writes.
+var indexRecipeLink = regexp.MustCompile(`\[([^\]\n]*)\]\(\./\?recipe=([^)\s]+)\)`)
+
+type DeadLink struct {
+	Slug     string `json:"slug"`
+	Title    string `json:"title"`
+	Reason   string `json:"reason"`
+	RecipeID int    `json:"recipeId,omitempty"`
+}
+
+// LinkReport is the outcome of checking the links of the published
+// recipes.md against the blobs in storage.
+type LinkReport struct {
+	CheckedAt time.Time  `json:"checkedAt"`
+	Links     int        `json:"links"`
+	Dead      []DeadLink `json:"dead"`
+	Repaired  bool       `json:"repaired,omitempty"`
+}
+
+// checkIndexLinks downloads the user's recipes.md and looks up every
+// recipe it links. Embedded sites are rendered from the database on each
+// request and can't have dead links.
+func checkIndexLinks(ctx context.Context, subdomain string, userID int) (LinkReport, error) {
+	report := LinkReport{CheckedAt: time.Now(), Dead: []DeadLink{}}
+	if resolveStorage(subdomain).Mode == storageModeEmbedded {
+		return report, nil
+	}
+
+	index, err := downloadBlob(subdomain, "recipes.md")
+	if bloberror.HasCode(err, bloberror.BlobNotFound) {
+		// nothing published yet, the next publish uploads it
+		return report, nil
+	}
+	if err != nil {
+		return report, err
+	}
+	pages, err := listBlobs(subdomain, "recipes/")
+	if err != nil {
+		return report, err
+	}
+
+	checked := map[string]bool{}
+	for _, match := range indexRecipeLink.FindAllStringSubmatch(index, -1) {
+		title, slug := match[1], match[2]
+		if checked[slug] {
+			continue
+		}
+		checked[slug] = true
+		report.Links++
+		if pages["recipes/"+slug+".md"] {
+			continue
+		}
+
+		dead := DeadLink{Slug: slug, Title: title, Reason: deadLinkUnknownRecipe}
+		err := pool.QueryRow(ctx, "SELECT id FROM recipes WHERE user_id = $1 AND slug = $2 AND NOT archived AND NOT draft", userID, slug).
+			Scan(&dead.RecipeID)
+		if err == nil {
+			dead.Reason = deadLinkMissingPage
+		} else if !errors.Is(err, pgx.ErrNoRows) {
+			return report, err
+		}
+		report.Dead = append(report.Dead, dead)
+	}
+	return report, nil
+}
+
+// repairIndexLinks uploads the missing pages and, for links to recipes that
+// are gone, the index again. The stored hashes are dropped first, they say
+// the blobs are up to date.
+func repairIndexLinks(subdomain string, userID int, report *LinkReport) error {
+	if len(report.Dead) == 0 {
+		return nil
+	}
+
+	for _, dead := range report.Dead {
+		if dead.Reason != deadLinkMissingPage {
+			continue
+		}
+		if err := forgetBlobHash(subdomain, "recipes/"+dead.Slug+".md"); err != nil {
+			return err
+		}
+		if err := publishRecipeBlob(subdomain, userID, dead.Slug); err != nil {
+			return err
+		}
+	}
+
+	if err := forgetBlobHash(subdomain, "recipes.md"); err != nil {
+		return err
+	}
+	if err := templateRecipesBlob(subdomain, userID); err != nil {
+		return err
+	}
+	report.Repaired = true
+	return nil
+}
+
+// checkDeadLinks checks the published index of every ready site and
+// repairs what it finds, it runs as a scheduled job.
+func checkDeadLinks(ctx context.Context) error {
+	rows, err := pool.Query(ctx, "SELECT id, subdomain FROM users WHERE provisioning_status = $1 AND storage_mode <> $2",
+		provisioningReady, storageModeEmbedded)
+	if err != nil {
+		return err
+	}
+
+	type site struct {
+		id        int
+		subdomain string
+	}
+	var sites []site
+	for rows.Next() {
+		var s site
+		if err := rows.Scan(&s.id, &s.subdomain); err != nil {
+			rows.Close()
+			return err
+		}
+		sites = append(sites, s)
+	}
+	rows.Close()
+
+	for _, s := range sites {
+		report, err := checkIndexLinks(ctx, s.subdomain, s.id)
+		if err != nil {
+			log.Printf("Error checking links of site %s: %v\n", s.subdomain, err)
+			continue
+		}
+		if len(report.Dead) == 0 {
+			continue
+		}
+		log.Printf("Found %d dead links of %d in the index of site %s, repairing\n", len(report.Dead), report.Links, s.subdomain)
+		if err := repairIndexLinks(s.subdomain, s.id, &report); err != nil {
+			log.Printf("Error repairing links of site %s: %v\n", s.subdomain, err)
+		}
+	}
+	return nil
+}
+
+// HandleCheckDeadLinks reports the dead links of the user's index, the POST
+// to /repair repairs them as well.
+func HandleCheckDeadLinks(w http.ResponseWriter, r *http.Request) {
+	userCtx, ok := r.Context().Value("user").(UserContext)
+	if !ok {
+		http.Error(w, "Unauthorized: user context missing", http.StatusUnauthorized)
+		return
+	}
+
+	report, err := checkIndexLinks(r.Context(), userCtx.Subdomain, userCtx.UserID)
+	if err != nil {
+		log.Printf("Error checking links of site %s: %v\n", userCtx.Subdomain, err)
+		http.Error(w, "Error checking links", http.StatusInternalServerError)
+		return
+	}
+	if r.Method == http.MethodPost {
+		if err := repairIndexLinks(userCtx.Subdomain, userCtx.UserID, &report); err != nil {
+			log.Printf("Error repairing links of site %s: %v\n", userCtx.Subdomain, err)
+			http.Error(w, "Error repairing links", http.StatusInternalServerError)
+			return
+		}
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+
+	err = json.NewEncoder(w).Encode(report)
+	if err != nil {
+		http.Error(w, "Error encoding JSON response", http.StatusInternalServerError)
+	}
+}
@@ -0,0 +1,245 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/jackc/pgx/v5"
+)
+
+const (
+	dietVegan        = "vegan"
+	dietVegetarian   = "vegetarian"
+	dietContainsMeat = "contains-meat"
+)
+
+// veganVariant is the appliance column of veganized variants, they are
+// linked to the original like the appliance variants.
+const veganVariant = "vegan"
+
+// dietBatchSize limits how many recipes are classified per job run, the rest
+// follow on the next run.
+const dietBatchSize = 50
+
+const dietSystemMessage = `You classify a recipe by diet. Answer with a JSON object {"diet": ""}.
+diet is "vegan" when nothing comes from animals (no meat, fish, dairy, eggs or honey), "vegetarian" when there is no meat or fish,
+and "contains-meat" otherwise. Gelatine and stock made from meat or fish count as meat.`
+
+const veganizeSystemMessage = "You make cooking recipes written in markdown vegan. Replace every ingredient from animals with a plant-based " +
+	"substitute in a fitting amount and adjust the steps where the substitute needs it. Keep the markdown structure and the language of the recipe. " +
+	`Answer with a JSON object {"title": "...", "recipe": "...", "substitutions": [{"original": "...", "replacement": "..."}]} ` +
+	"holding the title, the full markdown of the vegan recipe and the ingredients you replaced."
+
+var diets = map[string]bool{dietVegan: true, dietVegetarian: true, dietContainsMeat: true}
+
+// Substitution is an ingredient the veganized variant replaced.
+type Substitution struct {
+	Original    string `json:"original"`
+	Replacement string `json:"replacement"`
+}
+
+type VeganizedRecipe struct {
+	Recipe
+	Substitutions []Substitution `json:"substitutions"`
+}
+
+// matchesDiet reports whether a recipe's diet fits the filter, vegan recipes
+// are vegetarian too. Recipes that are not classified yet never match.
+func matchesDiet(diet string, filter string) bool {
+	if filter == dietVegetarian {
+		return diet == dietVegan || diet == dietVegetarian
+	}
+	return diet == filter
+}
+
+func filterRecipesByDiet(recipes []Recipe, diet string) []Recipe {
+	filtered := []Recipe{}
+	for _, recipe := range recipes {
+		if matchesDiet(recipe.Diet, diet) {
+			filtered = append(filtered, recipe)
+		}
+	}
+	return filtered
+}
+
+// classifyRecipeDiets fills recipes.diet for new recipes and recipes edited
+// since they were classified, it runs as a scheduled job.
+func classifyRecipeDiets(ctx context.Context) error {
+	rows, err := pool.Query(ctx, "SELECT id, user_id, title, content FROM recipes WHERE diet = '' ORDER BY id LIMIT $1", dietBatchSize)
+	if err != nil {
+		return err
+	}
+
+	type pendingRecipe struct {
+		id, userID     int
+		title, content string
+	}
+	var pending []pendingRecipe
+	for rows.Next() {
+		var recipe pendingRecipe
+		if err := rows.Scan(&recipe.id, &recipe.userID, &recipe.title, &recipe.content); err != nil {
+			rows.Close()
+			return err
+		}
+		pending = append(pending, recipe)
+	}
+	rows.Close()
+
+	for _, recipe := range pending {
+		diet, err := classifyDiet(ctx, recipe.title, recipe.content)
+		if err != nil {
+			log.Printf("Error classifying the diet of recipe %d: %v\n", recipe.id, err)
+			continue
+		}
+
+		_, err = pool.Exec(ctx, "UPDATE recipes SET diet = $1 WHERE id = $2", diet, recipe.id)
+		if err != nil {
+			return err
+		}
+		invalidateRecipes(recipe.userID)
+	}
+	return nil
+}
+
+func classifyDiet(ctx context.Context, title string, content string) (string, error) {
+	var answer struct {
+		Diet string `json:"diet"`
+	}
+	err := completeJSON(ctx, llmRequest{
+		Task:   llmTaskDiet,
+		System: dietSystemMessage,
+		Prompt: "# " + title + "\n\n" + content,
+	}, &answer)
+	if err != nil {
+		return "", err
+	}
+
+	diet := strings.ToLower(strings.TrimSpace(answer.Diet))
+	if !diets[diet] {
+		return "", errors.New("unknown diet " + strconv.Quote(answer.Diet))
+	}
+	return diet, nil
+}
+
+// HandleVeganizeRecipe stores a vegan copy of the recipe as a variant linked
+// to the original and returns it with the substitutions. Veganizing again
+// replaces the earlier variant.
+func HandleVeganizeRecipe(w http.ResponseWriter, r *http.Request) {
+	userCtx, ok := r.Context().Value("user").(UserContext)
+	if !ok {
+		http.Error(w, "Unauthorized: user context missing", http.StatusUnauthorized)
+		return
+	}
+
+	recipeID, err := strconv.Atoi(r.PathValue("id"))
+	if err != nil {
+		http.Error(w, "Invalid recipe ID", http.StatusBadRequest)
+		return
+	}
+
+	var original Recipe
+	err = pool.QueryRow(r.Context(), `
+		SELECT id, title, content, category, slug, coalesce(translation_of, 0), coalesce(variant_of, 0), diet
+		FROM recipes WHERE id = $1 AND user_id = $2`,
+		recipeID, userCtx.UserID).Scan(&original.ID, &original.Recipename, &original.Recipe, &original.Category, &original.Slug,
+		&original.TranslationOf, &original.VariantOf, &original.Diet)
+	if err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			http.Error(w, "Recipe not found", http.StatusNotFound)
+			return
+		}
+		log.Printf("Error getting recipe: %v\n", err)
+		http.Error(w, "Error getting recipe", http.StatusInternalServerError)
+		return
+	}
+	if original.TranslationOf != 0 || original.VariantOf != 0 {
+		http.Error(w, "Veganize the original recipe instead of a translation or variant", http.StatusBadRequest)
+		return
+	}
+	if original.Diet == dietVegan {
+		http.Error(w, "The recipe is already vegan", http.StatusConflict)
+		return
+	}
+
+	var converted struct {
+		Title         string         `json:"title"`
+		Recipe        string         `json:"recipe"`
+		Substitutions []Substitution `json:"substitutions"`
+	}
+	err = completeJSON(r.Context(), llmRequest{
+		Task:   llmTaskVeganize,
+		System: veganizeSystemMessage,
+		Prompt: "Make this recipe vegan:\n\nTitle: " + original.Recipename + "\n\n" + original.Recipe,
+	}, &converted)
+	if err != nil || converted.Title == "" || converted.Recipe == "" {
+		log.Printf("Error veganizing recipe %d: %v\n", original.ID, err)
+		http.Error(w, "Error veganizing recipe", http.StatusInternalServerError)
+		return
+	}
+
+	variant := Recipe{
+		Recipename: converted.Title,
+		Recipe:     converted.Recipe,
+		Category:   original.Category,
+		VariantOf:  original.ID,
+		Appliance:  veganVariant,
+		Diet:       dietVegan,
+	}
+	addTimingEstimate(r.Context(), &variant)
+
+	created, err := saveVariant(r.Context(), userCtx.UserID, &variant)
+	if err == nil {
+		_, err = pool.Exec(r.Context(), "UPDATE recipes SET diet = $1 WHERE id = $2", dietVegan, variant.ID)
+	}
+	if err != nil {
+		log.Printf("Error saving vegan variant of recipe %d: %v\n", original.ID, err)
+		http.Error(w, "Error saving variant", http.StatusInternalServerError)
+		return
+	}
+	invalidateRecipes(userCtx.UserID)
+
+	// The original is published again for its variant links.
+	for _, slug := range []string{variant.Slug, original.Slug} {
+		if err := publishRecipeBlob(userCtx.Subdomain, userCtx.UserID, slug); err != nil {
+			log.Printf("Error publishing recipe: %v\n", err)
+			http.Error(w, "Failed to publish recipe", http.StatusInternalServerError)
+			return
+		}
+	}
+	if err := templateRecipesBlob(userCtx.Subdomain, userCtx.UserID); err != nil {
+		log.Printf("Error updating recipe template: %v\n", err)
+		http.Error(w, "Failed to update recipe template", http.StatusInternalServerError)
+		return
+	}
+
+	after := recipeAuditSummary(variant.Recipename, variant.Category, variant.Version, variant.Recipe)
+	after.Detail = "vegan variant of recipe " + strconv.Itoa(original.ID)
+	recordAudit(r.Context(), userCtx, AuditEntry{Action: auditRecipeConverted, RecipeID: variant.ID, Slug: variant.Slug, After: after})
+
+	if created {
+		emitEvent(userCtx.UserID, eventRecipeCreated, variant)
+	} else {
+		emitEvent(userCtx.UserID, eventRecipeUpdated, variant)
+	}
+	variant.URL = recipeURL(userCtx.Subdomain, variant.Slug)
+
+	substitutions := []Substitution{}
+	for _, substitution := range converted.Substitutions {
+		if substitution.Original != "" && substitution.Replacement != "" {
+			substitutions = append(substitutions, substitution)
+		}
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+
+	err = json.NewEncoder(w).Encode(VeganizedRecipe{Recipe: variant, Substitutions: substitutions})
+	if err != nil {
+		http.Error(w, "Error encoding JSON response", http.StatusInternalServerError)
+	}
+}
@@ -0,0 +1,105 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"log"
+	"net/http"
+	"strconv"
+
+	"github.com/jackc/pgx/v5"
+)
+
+// HandlePublishRecipe puts a draft and its translations on the published
+// site: the pages are uploaded and the index is templated again. Publishing
+// a recipe that is already published uploads it again.
+func HandlePublishRecipe(w http.ResponseWriter, r *http.Request) {
+	userCtx, ok := r.Context().Value("user").(UserContext)
+	if !ok {
+		http.Error(w, "Unauthorized: user context missing", http.StatusUnauthorized)
+		return
+	}
+
+	recipeID, err := strconv.Atoi(r.PathValue("id"))
+	if err != nil {
+		http.Error(w, "Invalid recipe ID", http.StatusBadRequest)
+		return
+	}
+
+	recipe := Recipe{ID: recipeID}
+	err = pool.QueryRow(r.Context(), `
+		UPDATE recipes SET draft = false WHERE id = $1 AND user_id = $2 RETURNING title, category, slug, version, archived`,
+		recipeID, userCtx.UserID).Scan(&recipe.Recipename, &recipe.Category, &recipe.Slug, &recipe.Version, &recipe.Archived)
+	if err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			http.Error(w, "Recipe not found", http.StatusNotFound)
+			return
+		}
+		log.Printf("Error publishing recipe: %v\n", err)
+		http.Error(w, "Error publishing recipe", http.StatusInternalServerError)
+		return
+	}
+
+	translations, err := publishTranslations(r.Context(), userCtx.UserID, recipeID)
+	if err != nil {
+		log.Printf("Error publishing translations of recipe %d: %v\n", recipeID, err)
+		http.Error(w, "Error publishing recipe", http.StatusInternalServerError)
+		return
+	}
+	invalidateRecipes(userCtx.UserID)
+
+	recordAudit(r.Context(), userCtx, AuditEntry{
+		Action:   auditRecipePublished,
+		RecipeID: recipeID,
+		Slug:     recipe.Slug,
+		After:    &AuditSummary{Title: recipe.Recipename, Category: recipe.Category, Version: recipe.Version},
+	})
+
+	for _, slug := range append([]string{recipe.Slug}, translations...) {
+		if err := publishRecipeBlob(userCtx.Subdomain, userCtx.UserID, slug); err != nil {
+			// the index rows would keep the old state without the change
+			invalidateRecipes(userCtx.UserID)
+			log.Printf("Error publishing recipe: %v\n", err)
+			http.Error(w, "Failed to publish recipe", http.StatusInternalServerError)
+			return
+		}
+	}
+	if err := templateRecipesBlob(userCtx.Subdomain, userCtx.UserID); err != nil {
+		log.Printf("Error updating recipe template: %v\n", err)
+		http.Error(w, "Failed to update recipe template", http.StatusInternalServerError)
+		return
+	}
+	// archived recipes stay off the site until they are unarchived
+	if !recipe.Archived {
+		recipe.URL = recipeURL(userCtx.Subdomain, recipe.Slug)
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+
+	err = json.NewEncoder(w).Encode(recipe)
+	if err != nil {
+		http.Error(w, "Error encoding JSON response", http.StatusInternalServerError)
+	}
+}
+
+// publishTranslations returns the slugs of the recipe's translations.
+func publishTranslations(ctx context.Context, userID int, recipeID int) ([]string, error) {
+	rows, err := pool.Query(ctx, "UPDATE recipes SET draft = false WHERE translation_of = $1 AND user_id = $2 RETURNING slug",
+		recipeID, userID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var slugs []string
+	for rows.Next() {
+		var slug string
+		if err := rows.Scan(&slug); err != nil {
+			return nil, err
+		}
+		slugs = append(slugs, slug)
+	}
+	return slugs, rows.Err()
+}
@@ -0,0 +1,221 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"log"
+	"math"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// A pair's score weighs the embedding of title and ingredients against how
+// alike the titles are spelled, so "Pfannkuchen" and "Pfannkuchen (klassisch)"
+// pair up even when one lists an extra ingredient.
+const (
+	duplicateEmbeddingWeight = 0.7
+	duplicateTitleWeight     = 0.3
+	defaultDuplicateScore    = 0.85
+)
+
+type DuplicatePair struct {
+	// RecipeID and DuplicateID can be passed to the merge endpoint as they
+	// are, the older recipe is kept.
+	RecipeID       int     `json:"recipeID"`
+	DuplicateID    int     `json:"duplicateID"`
+	Score          float64 `json:"score"`
+	EmbeddingScore float64 `json:"embeddingScore"`
+	TitleScore     float64 `json:"titleScore"`
+}
+
+// DuplicateCluster groups recipes that are linked by pairs scoring at least
+// the threshold, Score is the best pair.
+type DuplicateCluster struct {
+	Score   float64         `json:"score"`
+	Recipes []Recipe        `json:"recipes"`
+	Pairs   []DuplicatePair `json:"pairs"`
+}
+
+type DuplicateReport struct {
+	MinScore float64            `json:"minScore"`
+	Clusters []DuplicateCluster `json:"clusters"`
+}
+
+// HandleFindDuplicates reports clusters of probable duplicates among the
+// user's recipes, best first. Translations, variants and archived recipes
+// are left out. minScore between 0 and 1 overrides the threshold.
+func HandleFindDuplicates(w http.ResponseWriter, r *http.Request) {
+	userCtx, ok := r.Context().Value("user").(UserContext)
+	if !ok {
+		http.Error(w, "Unauthorized: user context missing", http.StatusUnauthorized)
+		return
+	}
+
+	minScore := defaultDuplicateScore
+	if value := r.URL.Query().Get("minScore"); value != "" {
+		parsed, err := strconv.ParseFloat(value, 64)
+		if err != nil || parsed <= 0 || parsed > 1 {
+			http.Error(w, "minScore must be a number between 0 and 1", http.StatusBadRequest)
+			return
+		}
+		minScore = parsed
+	}
+
+	clusters, err := findDuplicates(r.Context(), userCtx.UserID, minScore)
+	if err != nil {
+		log.Printf("Error finding duplicates for user %d: %v\n", userCtx.UserID, err)
+		http.Error(w, "Error finding duplicates", http.StatusInternalServerError)
+		return
+	}
+	for _, cluster := range clusters {
+		for i := range cluster.Recipes {
+			if !cluster.Recipes[i].Draft {
+				cluster.Recipes[i].URL = recipeURL(userCtx.Subdomain, cluster.Recipes[i].Slug)
+			}
+		}
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+
+	err = json.NewEncoder(w).Encode(DuplicateReport{MinScore: minScore, Clusters: clusters})
+	if err != nil {
+		http.Error(w, "Error encoding JSON response", http.StatusInternalServerError)
+	}
+}
+
+// findDuplicates compares every recipe with every other one, a collection
+// holds a few hundred recipes at most so this stays cheap.
+func findDuplicates(ctx context.Context, userID int, minScore float64) ([]DuplicateCluster, error) {
+	if err := refreshRecipeEmbeddings(ctx, userID); err != nil {
+		return nil, err
+	}
+
+	rows, err := pool.Query(ctx, `
+		SELECT r.id, r.title, r.category, r.slug, r.draft, e.embedding FROM recipes r JOIN recipe_embeddings e ON e.recipe_id = r.id
+		WHERE r.user_id = $1 AND NOT r.archived AND r.translation_of IS NULL AND r.variant_of IS NULL
+		ORDER BY r.id`, userID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var recipes []Recipe
+	var embeddings [][]float32
+	for rows.Next() {
+		var recipe Recipe
+		var embedding []float32
+		if err := rows.Scan(&recipe.ID, &recipe.Recipename, &recipe.Category, &recipe.Slug, &recipe.Draft, &embedding); err != nil {
+			return nil, err
+		}
+		recipes = append(recipes, recipe)
+		embeddings = append(embeddings, embedding)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	bigrams := make([]map[string]int, len(recipes))
+	for i, recipe := range recipes {
+		bigrams[i] = titleBigrams(recipe.Recipename)
+	}
+
+	// pairs are joined into clusters with a union-find over the indexes
+	parent := make([]int, len(recipes))
+	for i := range parent {
+		parent[i] = i
+	}
+	var find func(int) int
+	find = func(i int) int {
+		if parent[i] != i {
+			parent[i] = find(parent[i])
+		}
+		return parent[i]
+	}
+
+	var pairs []DuplicatePair
+	var pairIndexes [][2]int
+	for i := range recipes {
+		for j := i + 1; j < len(recipes); j++ {
+			embeddingScore := cosineSimilarity(embeddings[i], embeddings[j])
+			titleScore := diceCoefficient(bigrams[i], bigrams[j])
+			score := duplicateEmbeddingWeight*embeddingScore + duplicateTitleWeight*titleScore
+			if score < minScore {
+				continue
+			}
+			pairs = append(pairs, DuplicatePair{
+				RecipeID:       recipes[i].ID,
+				DuplicateID:    recipes[j].ID,
+				Score:          roundScore(score),
+				EmbeddingScore: roundScore(embeddingScore),
+				TitleScore:     roundScore(titleScore),
+			})
+			pairIndexes = append(pairIndexes, [2]int{i, j})
+			parent[find(j)] = find(i)
+		}
+	}
+
+	byRoot := map[int]*DuplicateCluster{}
+	var roots []int
+	for p, pair := range pairs {
+		root := find(pairIndexes[p][0])
+		cluster, found := byRoot[root]
+		if !found {
+			cluster = &DuplicateCluster{}
+			byRoot[root] = cluster
+			roots = append(roots, root)
+		}
+		cluster.Pairs = append(cluster.Pairs, pair)
+		cluster.Score = max(cluster.Score, pair.Score)
+	}
+	for i, recipe := range recipes {
+		if cluster, found := byRoot[find(i)]; found {
+			cluster.Recipes = append(cluster.Recipes, recipe)
+		}
+	}
+
+	clusters := []DuplicateCluster{}
+	for _, root := range roots {
+		cluster := byRoot[root]
+		sort.SliceStable(cluster.Pairs, func(i, j int) bool {
+			return cluster.Pairs[i].Score > cluster.Pairs[j].Score
+		})
+		clusters = append(clusters, *cluster)
+	}
+	sort.SliceStable(clusters, func(i, j int) bool {
+		return clusters[i].Score > clusters[j].Score
+	})
+	return clusters, nil
+}
+
+// titleBigrams counts the letter pairs of the slugified title, so case,
+// umlauts and punctuation don't matter.
+func titleBigrams(title string) map[string]int {
+	letters := strings.ReplaceAll(slugify(title), "-", " ")
+	bigrams := map[string]int{}
+	for i := 0; i+2 <= len(letters); i++ {
+		bigrams[letters[i:i+2]]++
+	}
+	return bigrams
+}
+
+func diceCoefficient(a map[string]int, b map[string]int) float64 {
+	var shared, total int
+	for bigram, count := range a {
+		shared += min(count, b[bigram])
+		total += count
+	}
+	for _, count := range b {
+		total += count
+	}
+	if total == 0 {
+		return 0
+	}
+	return 2 * float64(shared) / float64(total)
+}
+
+func roundScore(score float64) float64 {
+	return math.Round(score*1000) / 1000
+}
@@ -0,0 +1,307 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"hash/fnv"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+)
+
+// An experiment sends a share of a task's generations to a variant with a
+// different model and/or an extra instruction. Users are assigned by a hash
+// of the experiment name and their ID, so a user stays in the same arm and
+// the arms can be compared through the feedback on their generations.
+const (
+	variantControl = "control"
+	variantTest    = "variant"
+)
+
+const experimentsCacheKey = "experiments"
+
+const experimentsCacheTTL = time.Minute
+
+type Experiment struct {
+	ID          int64      `json:"id"`
+	Name        string     `json:"name"`
+	Task        string     `json:"task"`
+	Model       string     `json:"model,omitempty"`
+	Instruction string     `json:"instruction,omitempty"`
+	Percent     int        `json:"percent"`
+	CreatedAt   time.Time  `json:"createdAt"`
+	EndedAt     *time.Time `json:"endedAt,omitempty"`
+}
+
+// experimentAssignment is the arm a completion ran in, see tracingLLM.
+type experimentAssignment struct {
+	ExperimentID int64
+	Variant      string
+}
+
+// experimentLLM applies the running experiment of the task to completions
+// made for a user.
+type experimentLLM struct {
+	llmProvider
+}
+
+func (e experimentLLM) Complete(ctx context.Context, req llmRequest) (string, error) {
+	// a model picked by the caller, like for a retry, is kept
+	userCtx, ok := ctx.Value("user").(UserContext)
+	if !ok || userCtx.UserID == 0 || req.Model != "" {
+		return e.llmProvider.Complete(ctx, req)
+	}
+
+	var experiment *Experiment
+	for _, running := range runningExperiments(ctx) {
+		if running.Task == req.Task {
+			experiment = &running
+			break
+		}
+	}
+	if experiment == nil {
+		return e.llmProvider.Complete(ctx, req)
+	}
+
+	assignment := experimentAssignment{ExperimentID: experiment.ID, Variant: experimentVariant(experiment.Name, userCtx.UserID, experiment.Percent)}
+	if assignment.Variant == variantTest {
+		if experiment.Model != "" {
+			req.Model = experiment.Model
+		}
+		if experiment.Instruction != "" {
+			// image tasks send the system message as the prompt
+			if req.System != "" {
+				req.System += "\n\n" + experiment.Instruction
+			} else {
+				req.Prompt += "\n\n" + experiment.Instruction
+			}
+		}
+	}
+	return e.llmProvider.Complete(context.WithValue(ctx, "experiment", assignment), req)
+}
+
+func experimentVariant(name string, userID int, percent int) string {
+	hash := fnv.New32a()
+	hash.Write([]byte(name + ":" + strconv.Itoa(userID)))
+	if int(hash.Sum32()%100) < percent {
+		return variantTest
+	}
+	return variantControl
+}
+
+// runningExperiments are cached for a minute, a failed lookup runs no
+// experiment.
+func runningExperiments(ctx context.Context) []Experiment {
+	var experiments []Experiment
+	if appCache.Get(ctx, experimentsCacheKey, &experiments) {
+		return experiments
+	}
+
+	experiments, err := queryExperiments(ctx, "WHERE ended_at IS NULL")
+	if err != nil {
+		log.Printf("Error getting running experiments: %v\n", err)
+		return nil
+	}
+	appCache.Set(ctx, experimentsCacheKey, experiments, experimentsCacheTTL)
+	return experiments
+}
+
+func queryExperiments(ctx context.Context, where string, args ...any) ([]Experiment, error) {
+	rows, err := pool.Query(ctx, `
+		SELECT id, name, task, model, instruction, percent, created_at, ended_at FROM experiments `+where+` ORDER BY id DESC`, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	experiments := []Experiment{}
+	for rows.Next() {
+		var experiment Experiment
+		err := rows.Scan(&experiment.ID, &experiment.Name, &experiment.Task, &experiment.Model, &experiment.Instruction,
+			&experiment.Percent, &experiment.CreatedAt, &experiment.EndedAt)
+		if err != nil {
+			return nil, err
+		}
+		experiments = append(experiments, experiment)
+	}
+	return experiments, rows.Err()
+}
+
+func HandleListExperiments(w http.ResponseWriter, r *http.Request) {
+	experiments, err := queryExperiments(r.Context(), "")
+	if err != nil {
+		log.Printf("Error listing experiments: %v\n", err)
+		http.Error(w, "Error listing experiments", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+
+	err = json.NewEncoder(w).Encode(experiments)
+	if err != nil {
+		http.Error(w, "Error encoding JSON response", http.StatusInternalServerError)
+	}
+}
+
+// HandleAddExperiment starts an experiment, only one can run per task. The
+// variant needs a model or an instruction, percent is its share of users.
+func HandleAddExperiment(w http.ResponseWriter, r *http.Request) {
+	var experiment Experiment
+	if err := json.NewDecoder(r.Body).Decode(&experiment); err != nil {
+		http.Error(w, "Invalid JSON payload", http.StatusBadRequest)
+		return
+	}
+	experiment.Name = strings.TrimSpace(experiment.Name)
+	experiment.Model = strings.TrimSpace(experiment.Model)
+	experiment.Instruction = strings.TrimSpace(experiment.Instruction)
+	switch {
+	case experiment.Name == "":
+		http.Error(w, "Missing name", http.StatusBadRequest)
+		return
+	case !generationTasks[experiment.Task]:
+		http.Error(w, "task must be a generation task like recipe or recipe-link", http.StatusBadRequest)
+		return
+	case experiment.Model == "" && experiment.Instruction == "":
+		http.Error(w, "The variant needs a model or an instruction", http.StatusBadRequest)
+		return
+	case experiment.Percent < 1 || experiment.Percent > 99:
+		http.Error(w, "percent must be between 1 and 99", http.StatusBadRequest)
+		return
+	}
+
+	err := pool.QueryRow(r.Context(), `
+		INSERT INTO experiments (name, task, model, instruction, percent) VALUES ($1, $2, $3, $4, $5)
+		ON CONFLICT DO NOTHING RETURNING id, created_at`,
+		experiment.Name, experiment.Task, experiment.Model, experiment.Instruction, experiment.Percent).Scan(&experiment.ID, &experiment.CreatedAt)
+	if errors.Is(err, pgx.ErrNoRows) {
+		http.Error(w, "The name is taken or an experiment already runs for the task", http.StatusConflict)
+		return
+	}
+	if err != nil {
+		log.Printf("Error adding experiment: %v\n", err)
+		http.Error(w, "Error adding experiment", http.StatusInternalServerError)
+		return
+	}
+	appCache.Delete(r.Context(), experimentsCacheKey)
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+
+	err = json.NewEncoder(w).Encode(experiment)
+	if err != nil {
+		http.Error(w, "Error encoding JSON response", http.StatusInternalServerError)
+	}
+}
+
+// HandleEndExperiment stops routing to the variant, the report stays.
+func HandleEndExperiment(w http.ResponseWriter, r *http.Request) {
+	id, err := strconv.ParseInt(r.PathValue("id"), 10, 64)
+	if err != nil {
+		http.Error(w, "Invalid experiment ID", http.StatusBadRequest)
+		return
+	}
+
+	tag, err := pool.Exec(r.Context(), "UPDATE experiments SET ended_at = now() WHERE id = $1 AND ended_at IS NULL", id)
+	if err != nil {
+		log.Printf("Error ending experiment %d: %v\n", id, err)
+		http.Error(w, "Error ending experiment", http.StatusInternalServerError)
+		return
+	}
+	if tag.RowsAffected() == 0 {
+		http.Error(w, "Experiment not found or already ended", http.StatusNotFound)
+		return
+	}
+	appCache.Delete(r.Context(), experimentsCacheKey)
+
+	w.WriteHeader(http.StatusOK)
+}
+
+// VariantReport compares one arm of an experiment. Tokens are estimated
+// from the characters sent and received, about four per token.
+type VariantReport struct {
+	Variant         string  `json:"variant"`
+	Generations     int     `json:"generations"`
+	Up              int     `json:"up"`
+	Down            int     `json:"down"`
+	Approval        float64 `json:"approval"`
+	Retries         int     `json:"retries"`
+	AvgLatencyMs    float64 `json:"avgLatencyMs"`
+	AvgInputTokens  float64 `json:"avgInputTokens"`
+	AvgOutputTokens float64 `json:"avgOutputTokens"`
+}
+
+type ExperimentReport struct {
+	Experiment Experiment      `json:"experiment"`
+	Variants   []VariantReport `json:"variants"`
+}
+
+// HandleGetExperimentReport aggregates quality and cost per arm. Retries
+// count the thumbs-down that asked the stronger model again.
+func HandleGetExperimentReport(w http.ResponseWriter, r *http.Request) {
+	id, err := strconv.ParseInt(r.PathValue("id"), 10, 64)
+	if err != nil {
+		http.Error(w, "Invalid experiment ID", http.StatusBadRequest)
+		return
+	}
+
+	experiments, err := queryExperiments(r.Context(), "WHERE id = $1", id)
+	if err != nil {
+		log.Printf("Error getting experiment %d: %v\n", id, err)
+		http.Error(w, "Error getting experiment report", http.StatusInternalServerError)
+		return
+	}
+	if len(experiments) == 0 {
+		http.Error(w, "Experiment not found", http.StatusNotFound)
+		return
+	}
+	report := ExperimentReport{Experiment: experiments[0], Variants: []VariantReport{}}
+
+	rows, err := pool.Query(r.Context(), `
+		SELECT g.variant, count(*),
+		       count(*) FILTER (WHERE f.rating = $2), count(*) FILTER (WHERE f.rating = $3),
+		       count(*) FILTER (WHERE EXISTS (SELECT 1 FROM generations retry WHERE retry.retry_of = g.id)),
+		       coalesce(avg(g.latency_ms), 0), avg(length(g.system) + length(g.prompt)) / 4, avg(length(g.response)) / 4
+		FROM generations g LEFT JOIN generation_feedback f ON f.generation_id = g.id
+		WHERE g.experiment_id = $1 AND g.retry_of IS NULL
+		GROUP BY g.variant ORDER BY g.variant`,
+		id, ratingUp, ratingDown)
+	if err != nil {
+		log.Printf("Error getting experiment report %d: %v\n", id, err)
+		http.Error(w, "Error getting experiment report", http.StatusInternalServerError)
+		return
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var variant VariantReport
+		err := rows.Scan(&variant.Variant, &variant.Generations, &variant.Up, &variant.Down, &variant.Retries,
+			&variant.AvgLatencyMs, &variant.AvgInputTokens, &variant.AvgOutputTokens)
+		if err != nil {
+			log.Printf("Error scanning experiment report: %v\n", err)
+			http.Error(w, "Error getting experiment report", http.StatusInternalServerError)
+			return
+		}
+		if rated := variant.Up + variant.Down; rated > 0 {
+			variant.Approval = float64(variant.Up) / float64(rated)
+		}
+		report.Variants = append(report.Variants, variant)
+	}
+	if err := rows.Err(); err != nil {
+		log.Printf("Error getting experiment report %d: %v\n", id, err)
+		http.Error(w, "Error getting experiment report", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+
+	err = json.NewEncoder(w).Encode(report)
+	if err != nil {
+		http.Error(w, "Error encoding JSON response", http.StatusInternalServerError)
+	}
+}
@@ -0,0 +1,128 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"log"
+	"net/http"
+	"sort"
+	"strconv"
+
+	"github.com/jackc/pgx/v5"
+)
+
+// maxFeaturedRecipes keeps the Favoriten section of the index short.
+const maxFeaturedRecipes = 6
+
+// HandleFeatureRecipe pins a recipe to the top of the published index.
+// Featuring a recipe that already is featured keeps its place.
+func HandleFeatureRecipe(w http.ResponseWriter, r *http.Request) {
+	setRecipeFeatured(w, r, true)
+}
+
+func HandleUnfeatureRecipe(w http.ResponseWriter, r *http.Request) {
+	setRecipeFeatured(w, r, false)
+}
+
+func setRecipeFeatured(w http.ResponseWriter, r *http.Request, featured bool) {
+	userCtx, ok := r.Context().Value("user").(UserContext)
+	if !ok {
+		http.Error(w, "Unauthorized: user context missing", http.StatusUnauthorized)
+		return
+	}
+
+	recipeID, err := strconv.Atoi(r.PathValue("id"))
+	if err != nil {
+		http.Error(w, "Invalid recipe ID", http.StatusBadRequest)
+		return
+	}
+
+	var recipe Recipe
+	if featured {
+		// The limit is checked in the same statement, two requests can't both
+		// take the last place.
+		err = pool.QueryRow(r.Context(), `
+			UPDATE recipes SET featured_at = coalesce(featured_at, now())
+			WHERE id = $1 AND user_id = $2 AND NOT archived AND NOT draft AND translation_of IS NULL
+			AND (featured_at IS NOT NULL OR (SELECT count(*) FROM recipes WHERE user_id = $2 AND featured_at IS NOT NULL) < $3)
+			RETURNING id, title, category, slug, version, featured_at`,
+			recipeID, userCtx.UserID, maxFeaturedRecipes).Scan(&recipe.ID, &recipe.Recipename, &recipe.Category, &recipe.Slug, &recipe.Version, &recipe.FeaturedAt)
+	} else {
+		err = pool.QueryRow(r.Context(), `
+			UPDATE recipes SET featured_at = NULL WHERE id = $1 AND user_id = $2
+			RETURNING id, title, category, slug, version, featured_at`,
+			recipeID, userCtx.UserID).Scan(&recipe.ID, &recipe.Recipename, &recipe.Category, &recipe.Slug, &recipe.Version, &recipe.FeaturedAt)
+	}
+	if errors.Is(err, pgx.ErrNoRows) {
+		writeFeatureRejection(w, r, userCtx.UserID, recipeID)
+		return
+	}
+	if err != nil {
+		log.Printf("Error featuring recipe: %v\n", err)
+		http.Error(w, "Error featuring recipe", http.StatusInternalServerError)
+		return
+	}
+	invalidateRecipes(userCtx.UserID)
+
+	action := auditRecipeFeatured
+	if !featured {
+		action = auditRecipeUnfeatured
+	}
+	recordAudit(r.Context(), userCtx, AuditEntry{
+		Action:   action,
+		RecipeID: recipe.ID,
+		Slug:     recipe.Slug,
+		After:    &AuditSummary{Title: recipe.Recipename, Category: recipe.Category, Version: recipe.Version},
+	})
+
+	if err := templateRecipesBlob(userCtx.Subdomain, userCtx.UserID); err != nil {
+		log.Printf("Error updating recipe template: %v\n", err)
+		http.Error(w, "Failed to update recipe template", http.StatusInternalServerError)
+		return
+	}
+	recipe.URL = recipeURL(userCtx.Subdomain, recipe.Slug)
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+
+	err = json.NewEncoder(w).Encode(recipe)
+	if err != nil {
+		http.Error(w, "Error encoding JSON response", http.StatusInternalServerError)
+	}
+}
+
+// writeFeatureRejection tells why a recipe could not be featured.
+func writeFeatureRejection(w http.ResponseWriter, r *http.Request, userID int, recipeID int) {
+	var archived, draft, translation bool
+	err := pool.QueryRow(r.Context(), "SELECT archived, draft, translation_of IS NOT NULL FROM recipes WHERE id = $1 AND user_id = $2",
+		recipeID, userID).Scan(&archived, &draft, &translation)
+	switch {
+	case errors.Is(err, pgx.ErrNoRows):
+		http.Error(w, "Recipe not found", http.StatusNotFound)
+	case err != nil:
+		log.Printf("Error getting recipe: %v\n", err)
+		http.Error(w, "Error featuring recipe", http.StatusInternalServerError)
+	case archived:
+		http.Error(w, "Archived recipes can't be featured", http.StatusBadRequest)
+	case draft:
+		http.Error(w, "Publish the draft before featuring it", http.StatusBadRequest)
+	case translation:
+		http.Error(w, "Feature the original recipe instead of a translation", http.StatusBadRequest)
+	default:
+		http.Error(w, "At most "+strconv.Itoa(maxFeaturedRecipes)+" recipes can be featured", http.StatusConflict)
+	}
+}
+
+// featuredFirst orders featured recipes first, in the order they were
+// featured, and keeps the order of the rest.
+func featuredFirst(recipes []Recipe) []Recipe {
+	sorted := append([]Recipe(nil), recipes...)
+	sort.SliceStable(sorted, func(i, j int) bool {
+		a, b := sorted[i].FeaturedAt, sorted[j].FeaturedAt
+		if a == nil || b == nil {
+			return a != nil && b == nil
+		}
+		return a.Before(*b)
+	})
+	return sorted
+}
@@ -0,0 +1,469 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"log"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+)
+
+// generationHeader carries the ID of the stored generation on the response
+// of a generation endpoint, feedback refers to it.
+const generationHeader = "X-Generation-ID"
+
+// generationRetention is how long prompts and answers are kept, they hold
+// what users typed and the pages they imported.
+const generationRetention = 90 * 24 * time.Hour
+
+const (
+	ratingUp   = "up"
+	ratingDown = "down"
+)
+
+// generationTasks are the completions that produce what the user rates,
+// the judge, recipe name and such around them are not stored.
+var generationTasks = map[string]bool{
+	llmTaskRecipe: true, llmTaskRecipeLink: true, llmTaskRecipeImage: true, llmTaskRecipeText: true,
+	llmTaskMultiRecipe: true, llmTaskUpdateRecipe: true, llmTaskAppliance: true, llmTaskVeganize: true,
+	llmTaskTranslate: true, llmTaskMenu: true,
+}
+
+// retryableTasks answer with the markdown of one recipe and can be asked
+// again from what was stored, photos are not kept.
+var retryableTasks = map[string]bool{llmTaskRecipe: true, llmTaskRecipeLink: true, llmTaskUpdateRecipe: true}
+
+// generationTrace holds the first completion of a generation task made for
+// a request, see tracingLLM.
+type generationTrace struct {
+	mu       sync.Mutex
+	recorded bool
+	task     string
+	model    string
+	system   string
+	prompt   string
+	response string
+	hasImage bool
+	latency  time.Duration
+	provider string
+	// experiment is the arm of a running experiment the completion ran
+	// in, see experimentLLM.
+	experiment experimentAssignment
+}
+
+// tracingLLM records completions into the trace of the request context.
+type tracingLLM struct {
+	llmProvider
+}
+
+func (t tracingLLM) Complete(ctx context.Context, req llmRequest) (string, error) {
+	// only fallbackLLM knows which provider answered, OpenAI without it
+	served := &servedBy{provider: providerOpenAI}
+	started := time.Now()
+	answer, err := t.llmProvider.Complete(context.WithValue(ctx, "servedBy", served), req)
+	trace, ok := ctx.Value("generation").(*generationTrace)
+	if err != nil || !ok || !generationTasks[req.Task] {
+		return answer, err
+	}
+
+	trace.mu.Lock()
+	defer trace.mu.Unlock()
+	if !trace.recorded {
+		model := req.Model
+		if model == "" {
+			model = modelFor(ctx)
+		}
+		trace.recorded, trace.task, trace.model = true, req.Task, model
+		trace.system, trace.prompt, trace.response, trace.hasImage = req.System, req.Prompt, answer, req.ImageBase64 != ""
+		trace.latency, trace.provider = time.Since(started), served.provider
+		trace.experiment, _ = ctx.Value("experiment").(experimentAssignment)
+	}
+	return answer, nil
+}
+
+// generationWriter stores the traced generation once the handler answers
+// with success, the ID goes out in generationHeader. When a completion was
+// turned away by limitedLLM the handler's error is replaced by a 422, so
+// every generation endpoint reports it the same way.
+type generationWriter struct {
+	http.ResponseWriter
+	r           *http.Request
+	userID      int
+	trace       *generationTrace
+	budget      *llmBudget
+	wroteHeader bool
+	replaced    bool
+}
+
+func (w *generationWriter) WriteHeader(status int) {
+	if !w.wroteHeader {
+		w.wroteHeader = true
+		if status >= http.StatusBadRequest && w.budget.tooLarge() {
+			w.replaced = true
+			w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+			w.ResponseWriter.WriteHeader(http.StatusUnprocessableEntity)
+			_, _ = w.ResponseWriter.Write([]byte(errInputTooLarge.Error() + "\n"))
+			return
+		}
+		if status < http.StatusMultipleChoices {
+			if id := storeGeneration(w.r.Context(), w.userID, w.r.Pattern, w.trace, 0); id != 0 {
+				w.Header().Set(generationHeader, strconv.FormatInt(id, 10))
+			}
+		}
+	}
+	w.ResponseWriter.WriteHeader(status)
+}
+
+func (w *generationWriter) Write(data []byte) (int, error) {
+	if !w.wroteHeader {
+		w.WriteHeader(http.StatusOK)
+	}
+	if w.replaced {
+		return len(data), nil
+	}
+	return w.ResponseWriter.Write(data)
+}
+
+func (w *generationWriter) Unwrap() http.ResponseWriter {
+	return w.ResponseWriter
+}
+
+// traceGenerations makes the generation of the request stored and puts the
+// endpoint budget in place, see GenerationLimitMiddleware.
+func traceGenerations(w http.ResponseWriter, r *http.Request, userID int) (http.ResponseWriter, *http.Request) {
+	trace, budget := &generationTrace{}, newLLMBudget(r)
+	ctx := context.WithValue(context.WithValue(r.Context(), "generation", trace), "budget", budget)
+	r = r.WithContext(ctx)
+	return &generationWriter{ResponseWriter: w, r: r, userID: userID, trace: trace, budget: budget}, r
+}
+
+func storeGeneration(ctx context.Context, userID int, endpoint string, trace *generationTrace, retryOf int64) int64 {
+	trace.mu.Lock()
+	defer trace.mu.Unlock()
+	if !trace.recorded {
+		return 0
+	}
+
+	var id int64
+	err := pool.QueryRow(context.WithoutCancel(ctx), `
+		INSERT INTO generations (user_id, endpoint, task, model, system, prompt, response, has_image, retry_of, latency_ms, experiment_id, variant, provider)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8, NULLIF($9, 0), $10, NULLIF($11, 0), $12, $13) RETURNING id`,
+		userID, endpoint, trace.task, trace.model, trace.system, trace.prompt, trace.response, trace.hasImage, retryOf,
+		trace.latency.Milliseconds(), trace.experiment.ExperimentID, trace.experiment.Variant, trace.provider).Scan(&id)
+	if err != nil {
+		log.Printf("Error storing generation for user %d: %v\n", userID, err)
+		return 0
+	}
+	return id
+}
+
+type GenerationFeedback struct {
+	GenerationID int64     `json:"generationID"`
+	Rating       string    `json:"rating"`
+	Comment      string    `json:"comment,omitempty"`
+	UpdatedAt    time.Time `json:"updatedAt"`
+	// Retry is the answer of the stronger model when a thumbs-down asked
+	// for one.
+	Retry *GenerationRetry `json:"retry,omitempty"`
+}
+
+type GenerationRetry struct {
+	GenerationID int64  `json:"generationID"`
+	Model        string `json:"model"`
+	Recipe       string `json:"recipe"`
+}
+
+// HandleAddFeedback rates a generation, rating again replaces the earlier
+// rating. A thumbs-down with retry asks the stronger model, see
+// FEEDBACK_RETRY_MODEL, with the stored prompt and counts as a generation.
+func HandleAddFeedback(w http.ResponseWriter, r *http.Request) {
+	userCtx, ok := r.Context().Value("user").(UserContext)
+	if !ok {
+		http.Error(w, "Unauthorized: user context missing", http.StatusUnauthorized)
+		return
+	}
+
+	var req struct {
+		GenerationID int64  `json:"generationID"`
+		Rating       string `json:"rating"`
+		Comment      string `json:"comment"`
+		Retry        bool   `json:"retry"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "Invalid JSON payload", http.StatusBadRequest)
+		return
+	}
+	if req.Rating != ratingUp && req.Rating != ratingDown {
+		http.Error(w, "rating must be up or down", http.StatusBadRequest)
+		return
+	}
+	if req.Retry && req.Rating != ratingDown {
+		http.Error(w, "Only a thumbs-down can ask for a retry", http.StatusBadRequest)
+		return
+	}
+	comment := strings.TrimSpace(req.Comment)
+	if len([]rune(comment)) > 2000 {
+		http.Error(w, "comment must be at most 2000 characters", http.StatusBadRequest)
+		return
+	}
+
+	var endpoint string
+	trace := &generationTrace{recorded: true}
+	err := pool.QueryRow(r.Context(), `
+		SELECT endpoint, task, model, system, prompt, has_image FROM generations WHERE id = $1 AND user_id = $2`,
+		req.GenerationID, userCtx.UserID).Scan(&endpoint, &trace.task, &trace.model, &trace.system, &trace.prompt, &trace.hasImage)
+	if errors.Is(err, pgx.ErrNoRows) {
+		http.Error(w, "Generation not found", http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		log.Printf("Error getting generation %d: %v\n", req.GenerationID, err)
+		http.Error(w, "Error saving feedback", http.StatusInternalServerError)
+		return
+	}
+	if req.Retry && (trace.hasImage || !retryableTasks[trace.task]) {
+		http.Error(w, "This generation can't be retried", http.StatusUnprocessableEntity)
+		return
+	}
+
+	feedback := GenerationFeedback{GenerationID: req.GenerationID, Rating: req.Rating, Comment: comment}
+	err = pool.QueryRow(r.Context(), `
+		INSERT INTO generation_feedback (generation_id, user_id, rating, comment) VALUES ($1, $2, $3, $4)
+		ON CONFLICT (generation_id) DO UPDATE SET rating = $3, comment = $4, updated_at = now()
+		RETURNING updated_at`,
+		req.GenerationID, userCtx.UserID, req.Rating, comment).Scan(&feedback.UpdatedAt)
+	if err != nil {
+		log.Printf("Error saving feedback for generation %d: %v\n", req.GenerationID, err)
+		http.Error(w, "Error saving feedback", http.StatusInternalServerError)
+		return
+	}
+
+	if req.Retry {
+		if err := countGeneration(r.Context(), userCtx.UserID); err != nil {
+			if errors.Is(err, errGenerationLimit) {
+				http.Error(w, "Daily generation limit reached", http.StatusTooManyRequests)
+				return
+			}
+			log.Printf("Error counting generation for user %d: %v\n", userCtx.UserID, err)
+			http.Error(w, "Error counting generation", http.StatusInternalServerError)
+			return
+		}
+
+		retry, err := retryGeneration(r.Context(), userCtx.UserID, endpoint, req.GenerationID, trace)
+		if err != nil {
+			log.Printf("Error retrying generation %d: %v\n", req.GenerationID, err)
+			http.Error(w, "Error generating recipe", http.StatusInternalServerError)
+			return
+		}
+		feedback.Retry = &retry
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+
+	err = json.NewEncoder(w).Encode(feedback)
+	if err != nil {
+		http.Error(w, "Error encoding JSON response", http.StatusInternalServerError)
+	}
+}
+
+// feedbackRetryModel is FEEDBACK_RETRY_MODEL, the premium model by default.
+func feedbackRetryModel() string {
+	if model := os.Getenv("FEEDBACK_RETRY_MODEL"); model != "" {
+		return model
+	}
+	return modelTiers[modelTierPremium]
+}
+
+func retryGeneration(ctx context.Context, userID int, endpoint string, original int64, stored *generationTrace) (GenerationRetry, error) {
+	retry := GenerationRetry{Model: feedbackRetryModel()}
+
+	trace := &generationTrace{}
+	ctx = context.WithValue(ctx, "generation", trace)
+	recipe, err := llm.Complete(ctx, llmRequest{Task: stored.task, System: stored.system, Prompt: stored.prompt, Model: retry.Model})
+	if err != nil {
+		return GenerationRetry{}, err
+	}
+	retry.Recipe = recipe
+	retry.GenerationID = storeGeneration(ctx, userID, endpoint, trace, original)
+	return retry, nil
+}
+
+type FeedbackReportRow struct {
+	Endpoint    string  `json:"endpoint"`
+	Task        string  `json:"task"`
+	Model       string  `json:"model"`
+	Provider    string  `json:"provider"`
+	Generations int     `json:"generations"`
+	Up          int     `json:"up"`
+	Down        int     `json:"down"`
+	Retries     int     `json:"retries"`
+	Approval    float64 `json:"approval"`
+}
+
+type FeedbackComment struct {
+	GenerationID int64     `json:"generationID"`
+	Endpoint     string    `json:"endpoint"`
+	Model        string    `json:"model"`
+	Rating       string    `json:"rating"`
+	Comment      string    `json:"comment"`
+	UpdatedAt    time.Time `json:"updatedAt"`
+}
+
+type FeedbackReport struct {
+	Since    time.Time           `json:"since"`
+	Rows     []FeedbackReportRow `json:"rows"`
+	Comments []FeedbackComment   `json:"comments"`
+}
+
+// HandleGetFeedbackReport aggregates the ratings of the last days (30 by
+// default) per endpoint, task, model and provider. Approval is the share of thumbs-up
+// among the rated generations, comments are the latest 50 thumbs-down ones.
+func HandleGetFeedbackReport(w http.ResponseWriter, r *http.Request) {
+	days := 30
+	if value := r.URL.Query().Get("days"); value != "" {
+		parsed, err := strconv.Atoi(value)
+		if err != nil || parsed <= 0 || parsed > 90 {
+			http.Error(w, "days must be between 1 and 90", http.StatusBadRequest)
+			return
+		}
+		days = parsed
+	}
+	report := FeedbackReport{Since: time.Now().AddDate(0, 0, -days), Rows: []FeedbackReportRow{}, Comments: []FeedbackComment{}}
+
+	rows, err := pool.Query(r.Context(), `
+		SELECT g.endpoint, g.task, g.model, g.provider, count(*),
+		       count(*) FILTER (WHERE f.rating = $2), count(*) FILTER (WHERE f.rating = $3), count(*) FILTER (WHERE g.retry_of IS NOT NULL)
+		FROM generations g LEFT JOIN generation_feedback f ON f.generation_id = g.id
+		WHERE g.created_at > $1
+		GROUP BY g.endpoint, g.task, g.model, g.provider ORDER BY count(*) DESC`,
+		report.Since, ratingUp, ratingDown)
+	if err != nil {
+		log.Printf("Error getting feedback report: %v\n", err)
+		http.Error(w, "Error getting feedback report", http.StatusInternalServerError)
+		return
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var row FeedbackReportRow
+		if err := rows.Scan(&row.Endpoint, &row.Task, &row.Model, &row.Provider, &row.Generations, &row.Up, &row.Down, &row.Retries); err != nil {
+			log.Printf("Error scanning feedback report: %v\n", err)
+			http.Error(w, "Error getting feedback report", http.StatusInternalServerError)
+			return
+		}
+		if rated := row.Up + row.Down; rated > 0 {
+			row.Approval = float64(row.Up) / float64(rated)
+		}
+		report.Rows = append(report.Rows, row)
+	}
+	if err := rows.Err(); err != nil {
+		log.Printf("Error getting feedback report: %v\n", err)
+		http.Error(w, "Error getting feedback report", http.StatusInternalServerError)
+		return
+	}
+
+	comments, err := pool.Query(r.Context(), `
+		SELECT g.id, g.endpoint, g.model, f.rating, f.comment, f.updated_at
+		FROM generation_feedback f JOIN generations g ON g.id = f.generation_id
+		WHERE f.updated_at > $1 AND f.rating = $2 AND f.comment <> ''
+		ORDER BY f.updated_at DESC LIMIT 50`,
+		report.Since, ratingDown)
+	if err != nil {
+		log.Printf("Error getting feedback comments: %v\n", err)
+		http.Error(w, "Error getting feedback report", http.StatusInternalServerError)
+		return
+	}
+	defer comments.Close()
+	for comments.Next() {
+		var comment FeedbackComment
+		err := comments.Scan(&comment.GenerationID, &comment.Endpoint, &comment.Model, &comment.Rating, &comment.Comment, &comment.UpdatedAt)
+		if err != nil {
+			log.Printf("Error scanning feedback comment: %v\n", err)
+			http.Error(w, "Error getting feedback report", http.StatusInternalServerError)
+			return
+		}
+		report.Comments = append(report.Comments, comment)
+	}
+	if err := comments.Err(); err != nil {
+		log.Printf("Error getting feedback comments: %v\n", err)
+		http.Error(w, "Error getting feedback report", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+
+	err = json.NewEncoder(w).Encode(report)
+	if err != nil {
+		http.Error(w, "Error encoding JSON response", http.StatusInternalServerError)
+	}
+}
+
+type Generation struct {
+	ID        int64     `json:"id"`
+	UserID    int       `json:"userID"`
+	Endpoint  string    `json:"endpoint"`
+	Task      string    `json:"task"`
+	Model     string    `json:"model"`
+	Provider  string    `json:"provider"`
+	System    string    `json:"system"`
+	Prompt    string    `json:"prompt"`
+	Response  string    `json:"response"`
+	HasImage  bool      `json:"hasImage"`
+	RetryOf   *int64    `json:"retryOf,omitempty"`
+	CreatedAt time.Time `json:"createdAt"`
+}
+
+// HandleGetGeneration returns a stored prompt and answer, for admins going
+// through the report.
+func HandleGetGeneration(w http.ResponseWriter, r *http.Request) {
+	id, err := strconv.ParseInt(r.PathValue("id"), 10, 64)
+	if err != nil {
+		http.Error(w, "Invalid generation ID", http.StatusBadRequest)
+		return
+	}
+
+	var generation Generation
+	err = pool.QueryRow(r.Context(), `
+		SELECT id, user_id, endpoint, task, model, provider, system, prompt, response, has_image, retry_of, created_at
+		FROM generations WHERE id = $1`, id).Scan(&generation.ID, &generation.UserID, &generation.Endpoint, &generation.Task,
+		&generation.Model, &generation.Provider, &generation.System, &generation.Prompt, &generation.Response, &generation.HasImage, &generation.RetryOf,
+		&generation.CreatedAt)
+	if errors.Is(err, pgx.ErrNoRows) {
+		http.Error(w, "Generation not found", http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		log.Printf("Error getting generation %d: %v\n", id, err)
+		http.Error(w, "Error getting generation", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+
+	err = json.NewEncoder(w).Encode(generation)
+	if err != nil {
+		http.Error(w, "Error encoding JSON response", http.StatusInternalServerError)
+	}
+}
+
+// expireGenerations drops prompts and answers past generationRetention along
+// with their feedback.
+func expireGenerations(ctx context.Context) error {
+	tag, err := pool.Exec(ctx, "DELETE FROM generations WHERE created_at < $1", time.Now().Add(-generationRetention))
+	if err != nil {
+		return err
+	}
+	if tag.RowsAffected() > 0 {
+		log.Printf("expired %d stored generations", tag.RowsAffected())
+	}
+	return nil
+}
@@ -0,0 +1,120 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"regexp"
+	"slices"
+	"strings"
+)
+
+// foodSafetyRule names a group of ingredients with the core temperature they
+// are safe at. The rules are curated instead of left to the model, a wrong
+// temperature here can make people ill.
+type foodSafetyRule struct {
+	pattern    *regexp.Regexp
+	german     string
+	english    string
+	celsius    int
+	fahrenheit int
+}
+
+// foodSafetyRules are checked in order and the first match of an ingredient
+// counts, so minced pork is minced meat rather than pork.
+var foodSafetyRules = []foodSafetyRule{
+	{regexp.MustCompile(`(?i)hähnchen|huhn|hühner|geflügel|\bpute|truthahn|\bente|\bgans\b|chicken|turkey|duck|goose|poultry`), "Geflügel", "poultry", 74, 165},
+	{regexp.MustCompile(`(?i)hackfleisch|\bhack\b|(?:rinder|schweine|lamm)hack|gehacktes\b|\bmett\b|ground (?:beef|pork|lamb|meat)|\bmince`), "Hackfleisch", "minced meat", 71, 160},
+	{regexp.MustCompile(`(?i)fisch|lachs|forelle|kabeljau|dorade|\bfish|salmon|trout|\bcod\b|tuna|haddock`), "Fisch", "fish", 63, 145},
+	{regexp.MustCompile(`(?i)schwein|kassler|kotelett|\bpork`), "Schwein", "pork", 63, 145},
+	{regexp.MustCompile(`(?i)\brind|\bkalb|\blamm|hirsch|\bbeef|\bveal|\blamb\b|venison`), "Rind, Kalb und Lamm (ganze Stücke)", "beef, veal and lamb (whole cuts)", 63, 145},
+}
+
+// foodSafetyExcluded are ingredients made from meat or fish that are not
+// cooked through in the recipe, like fish sauce or stock.
+var foodSafetyExcluded = regexp.MustCompile(`(?i)sauce|soße|brühe|fond|\bstock|broth|bouillon|gelatine|schmalz|\bfett|\bfat\b`)
+
+// riceIngredient gets its own cooling advice, cooked rice that is left warm
+// grows Bacillus cereus.
+var riceIngredient = regexp.MustCompile(`(?i)\breis\b|basmati|jasmin|risotto|\brice\b`)
+
+// addFoodSafetyNote appends core temperatures for the meat and fish in the
+// ingredients and how to cool leftovers. Users can turn the note off in
+// their settings, recipes without such ingredients are returned unchanged.
+func addFoodSafetyNote(ctx context.Context, markdown string) string {
+	settings := requestUserSettings(ctx)
+	if !settings.FoodSafetyNotes {
+		return markdown
+	}
+	return foodSafetyNote(markdown, settings.Units == unitsImperial)
+}
+
+func foodSafetyNote(markdown string, fahrenheit bool) string {
+	german := !strings.Contains(markdown, "## Ingredients")
+	if strings.Contains(markdown, foodSafetyLabel(german)) {
+		return markdown
+	}
+
+	var matched []foodSafetyRule
+	rice := false
+	for _, ingredient := range parseRecipeMarkdown(markdown).Ingredients {
+		if riceIngredient.MatchString(ingredient.Name) {
+			rice = true
+			continue
+		}
+		if foodSafetyExcluded.MatchString(ingredient.Name) {
+			continue
+		}
+		for _, rule := range foodSafetyRules {
+			if !rule.pattern.MatchString(ingredient.Name) {
+				continue
+			}
+			if !slices.ContainsFunc(matched, func(existing foodSafetyRule) bool { return existing.german == rule.german }) {
+				matched = append(matched, rule)
+			}
+			break
+		}
+	}
+	if len(matched) == 0 && !rice {
+		return markdown
+	}
+
+	var temperatures []string
+	for _, rule := range matched {
+		name, degrees := rule.english, fmt.Sprintf("%d °C", rule.celsius)
+		if german {
+			name = rule.german
+		}
+		if fahrenheit {
+			degrees = fmt.Sprintf("%d °F", rule.fahrenheit)
+		}
+		temperatures = append(temperatures, name+" "+degrees)
+	}
+
+	var sentences []string
+	if german {
+		if len(temperatures) > 0 {
+			sentences = append(sentences, "Kerntemperatur mindestens: "+strings.Join(temperatures, ", ")+".")
+		}
+		if rice {
+			sentences = append(sentences, "Gekochten Reis innerhalb einer Stunde abkühlen und im Kühlschrank höchstens einen Tag aufbewahren.")
+		}
+		sentences = append(sentences, "Reste innerhalb von zwei Stunden abkühlen lassen und gekühlt lagern.")
+	} else {
+		if len(temperatures) > 0 {
+			sentences = append(sentences, "Minimum core temperature: "+strings.Join(temperatures, ", ")+".")
+		}
+		if rice {
+			sentences = append(sentences, "Cool cooked rice within an hour and keep it in the fridge for no more than a day.")
+		}
+		sentences = append(sentences, "Cool leftovers within two hours and keep them refrigerated.")
+	}
+
+	return strings.TrimRight(markdown, "\n") + "\n\n> **" + foodSafetyLabel(german) + ":** " + strings.Join(sentences, " ") + "\n"
+}
+
+func foodSafetyLabel(german bool) string {
+	if german {
+		return "Lebensmittelsicherheit"
+	}
+	return "Food safety"
+}
@@ -0,0 +1,198 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/Azure/azure-sdk-for-go/sdk/storage/azblob/bloberror"
+)
+
+// publicRoutes answer without a Keycloak token, every other route has to
+// reject the request before it reaches the handler.
+var publicRoutes = map[string]bool{
+	"/health":                      true,
+	"GET /healthz":                 true,
+	"GET /readyz":                  true,
+	"GET /api/v1/csrf-token":       true,
+	"GET /calendar/{file}":         true,
+	"GET /u/{subdomain}/{path...}": true,
+	"GET /api/v1/public/{subdomain}/recipes/{slug}": true,
+	"POST /api/v1/assistant/alexa":                  true,
+	"POST /api/v1/assistant/google":                 true,
+	"POST /api/v1/inbound/mailgun":                  true,
+}
+
+// routeRequest builds a request matching pattern, wildcards become 1.
+func routeRequest(pattern string) *http.Request {
+	method, path, found := strings.Cut(pattern, " ")
+	if !found {
+		method, path = http.MethodPost, pattern
+	}
+	var target strings.Builder
+	for {
+		start := strings.Index(path, "{")
+		if start < 0 {
+			target.WriteString(path)
+			break
+		}
+		end := strings.Index(path[start:], "}")
+		target.WriteString(path[:start] + "1")
+		path = path[start+end+1:]
+	}
+	return httptest.NewRequest(method, target.String(), nil)
+}
+
+func TestRoutesRequireAuth(t *testing.T) {
+	mux := newRoutes()
+	if len(mux.patterns) == 0 {
+		t.Fatal("no routes registered")
+	}
+
+	for _, pattern := range mux.patterns {
+		if publicRoutes[pattern] {
+			continue
+		}
+		t.Run(pattern, func(t *testing.T) {
+			rec := httptest.NewRecorder()
+			mux.ServeHTTP(rec, routeRequest(pattern))
+			if rec.Code != http.StatusUnauthorized {
+				t.Errorf("got %d without a token, want %d", rec.Code, http.StatusUnauthorized)
+			}
+		})
+	}
+}
+
+func TestRoutesRejectForeignTokens(t *testing.T) {
+	mux := newRoutes()
+	for _, token := range []string{"not-a-jwt", "eyJhbGciOiJub25lIn0.eyJzdWIiOiJ4In0."} {
+		req := routeRequest("GET /api/v1/get-recipes")
+		req.Header.Set("Authorization", "Bearer "+token)
+		rec := httptest.NewRecorder()
+		mux.ServeHTTP(rec, req)
+		if rec.Code != http.StatusUnauthorized {
+			t.Errorf("token %q: got %d, want %d", token, rec.Code, http.StatusUnauthorized)
+		}
+	}
+}
+
+func TestRecipeHandlers(t *testing.T) {
+	requireDB(t)
+	user := newTestUser(t, newRoutes())
+
+	rec := user.do(http.MethodPost, "/api/v1/add-recipe", RecipeRequest{Recipename: "Pfannkuchen", Recipe: fakeRecipe, RecipeCategory: "Dessert"})
+	if rec.Code != http.StatusCreated {
+		t.Fatalf("add-recipe: got %d %q", rec.Code, rec.Body.String())
+	}
+	var added Recipe
+	decodeJSON(t, rec, &added)
+	if added.ID == 0 || added.Slug == "" {
+		t.Fatalf("add-recipe returned %+v", added)
+	}
+
+	rec = user.do(http.MethodGet, "/api/v1/get-recipes", nil)
+	if rec.Code != http.StatusOK {
+		t.Fatalf("get-recipes: got %d %q", rec.Code, rec.Body.String())
+	}
+	var recipes []Recipe
+	decodeJSON(t, rec, &recipes)
+	if len(recipes) != 1 || recipes[0].ID != added.ID {
+		t.Fatalf("get-recipes returned %+v, want the added recipe", recipes)
+	}
+
+	rec = user.do(http.MethodGet, fmt.Sprintf("/api/v1/recipes/%d", added.ID), nil)
+	if rec.Code != http.StatusOK {
+		t.Fatalf("get recipe: got %d %q", rec.Code, rec.Body.String())
+	}
+
+	rec = user.do(http.MethodDelete, "/api/v1/delete-recipe", map[string]int{"recipeID": added.ID})
+	if rec.Code != http.StatusOK {
+		t.Fatalf("delete-recipe: got %d %q", rec.Code, rec.Body.String())
+	}
+
+	rec = user.do(http.MethodGet, fmt.Sprintf("/api/v1/recipes/%d", added.ID), nil)
+	if rec.Code != http.StatusNotFound {
+		t.Fatalf("get deleted recipe: got %d, want %d", rec.Code, http.StatusNotFound)
+	}
+}
+
+func TestRecipeHandlersRejectInvalidInput(t *testing.T) {
+	requireDB(t)
+	user := newTestUser(t, newRoutes())
+
+	tests := []struct {
+		name   string
+		method string
+		target string
+		body   interface{}
+		want   int
+	}{
+		{"add without title", http.MethodPost, "/api/v1/add-recipe", RecipeRequest{Recipe: fakeRecipe}, http.StatusBadRequest},
+		{"add without content", http.MethodPost, "/api/v1/add-recipe", RecipeRequest{Recipename: "Leer"}, http.StatusBadRequest},
+		{"add with foreign parent", http.MethodPost, "/api/v1/add-recipe", RecipeRequest{Recipename: "Kopie", Recipe: fakeRecipe, ParentRecipeID: -1}, http.StatusBadRequest},
+		{"delete without id", http.MethodDelete, "/api/v1/delete-recipe", map[string]int{}, http.StatusBadRequest},
+		{"delete unknown recipe", http.MethodDelete, "/api/v1/delete-recipe", map[string]int{"recipeID": 999999999}, http.StatusNotFound},
+		{"get unknown recipe", http.MethodGet, "/api/v1/recipes/999999999", nil, http.StatusNotFound},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rec := user.do(tt.method, tt.target, tt.body)
+			if rec.Code != tt.want {
+				t.Errorf("got %d %q, want %d", rec.Code, rec.Body.String(), tt.want)
+			}
+		})
+	}
+}
+
+func TestUserHandlers(t *testing.T) {
+	requireDB(t)
+	user := newTestUser(t, newRoutes())
+
+	for _, target := range []string{"/api/v1/login", "/api/v1/user-info", "/api/v1/settings", "/api/v1/categories"} {
+		t.Run(target, func(t *testing.T) {
+			rec := user.do(http.MethodGet, target, nil)
+			if rec.Code != http.StatusOK {
+				t.Errorf("got %d %q", rec.Code, rec.Body.String())
+			}
+			if contentType := rec.Header().Get("Content-Type"); !strings.HasPrefix(contentType, "application/json") {
+				t.Errorf("got Content-Type %q", contentType)
+			}
+		})
+	}
+}
+
+func TestMemoryBlobStore(t *testing.T) {
+	store := newMemoryBlobStore()
+	ctx := context.Background()
+	location := storageLocation{Account: "shared", Prefix: "site/", Mode: storageModeShared}
+
+	if err := store.Upload(ctx, location, "recipes/pfannkuchen.md", []byte(fakeRecipe), nil, nil); err != nil {
+		t.Fatal(err)
+	}
+	content, err := store.Download(ctx, location, "recipes/pfannkuchen.md")
+	if err != nil || string(content) != fakeRecipe {
+		t.Fatalf("Download = %q, %v", content, err)
+	}
+
+	names, err := store.List(ctx, location, "recipes/")
+	if err != nil || len(names) != 1 || !names["recipes/pfannkuchen.md"] {
+		t.Fatalf("List = %v, %v", names, err)
+	}
+	other := storageLocation{Account: "shared", Prefix: "other/", Mode: storageModeShared}
+	if names, _ := store.List(ctx, other, ""); len(names) != 0 {
+		t.Fatalf("List of another site = %v", names)
+	}
+
+	if err := store.Delete(ctx, location, "recipes/pfannkuchen.md"); err != nil {
+		t.Fatal(err)
+	}
+	if err := store.Delete(ctx, location, "recipes/pfannkuchen.md"); err != nil {
+		t.Fatalf("deleting a missing blob: %v", err)
+	}
+	if _, err := store.Download(ctx, location, "recipes/pfannkuchen.md"); !bloberror.HasCode(err, bloberror.BlobNotFound) {
+		t.Fatalf("Download of a deleted blob = %v, want BlobNotFound", err)
+	}
+}
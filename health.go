@@ -0,0 +1,167 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/Azure/azure-sdk-for-go/sdk/azcore/policy"
+	"github.com/Azure/azure-sdk-for-go/sdk/azidentity"
+	"github.com/Azure/azure-sdk-for-go/sdk/storage/azblob"
+)
+
+const (
+	checkOK          = "ok"
+	checkFailed      = "failed"
+	checkSkipped     = "skipped"
+	readinessTimeout = 5 * time.Second
+)
+
+// openAICheckTTL keeps /readyz from calling the OpenAI API on every probe,
+// failures are retried sooner so a recovered key is noticed quickly.
+const (
+	openAICheckTTL       = 10 * time.Minute
+	openAIFailedCheckTTL = time.Minute
+)
+
+// DependencyStatus is public, the error is only logged: it names hosts,
+// accounts and database details.
+type DependencyStatus struct {
+	Status string `json:"status"`
+	Error  string `json:"-"`
+}
+
+var openAICheckCache struct {
+	sync.Mutex
+	err       error
+	checkedAt time.Time
+}
+
+// HandleReady reports 503 when any dependency is broken so the load balancer
+// stops routing traffic to this instance; /healthz only tells whether the
+// process is alive.
+func HandleReady(w http.ResponseWriter, r *http.Request) {
+	ctx, cancel := context.WithTimeout(r.Context(), readinessTimeout)
+	defer cancel()
+
+	checks := map[string]DependencyStatus{
+		"database": dependencyStatus(pool.Ping(ctx)),
+		"storage":  checkStorage(ctx),
+		"cache":    {Status: checkSkipped},
+		"openai":   {Status: checkSkipped},
+	}
+	if shared, ok := appCache.(redisCache); ok {
+		checks["cache"] = dependencyStatus(shared.ping(ctx))
+	}
+	if !testMode() {
+		checks["openai"] = dependencyStatus(checkOpenAIKey(ctx))
+	}
+
+	status := "ready"
+	code := http.StatusOK
+	for name, check := range checks {
+		if check.Status == checkFailed {
+			log.Printf("Readiness check %s failed: %s", name, check.Error)
+			status = "unavailable"
+			code = http.StatusServiceUnavailable
+		}
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(code)
+
+	err := json.NewEncoder(w).Encode(map[string]interface{}{
+		"status": status,
+		"checks": checks,
+	})
+	if err != nil {
+		log.Println("Error writing response:", err)
+	}
+}
+
+func dependencyStatus(err error) DependencyStatus {
+	if err != nil {
+		return DependencyStatus{Status: checkFailed, Error: err.Error()}
+	}
+	return DependencyStatus{Status: checkOK}
+}
+
+// checkStorage checks the backend new users are provisioned on. Dedicated
+// accounts are created per user, so only the Azure credential is verified.
+func checkStorage(ctx context.Context) DependencyStatus {
+	if os.Getenv("S3_ENDPOINT") != "" {
+		client, err := s3Client()
+		if err == nil {
+			_, err = client.ListBuckets(ctx)
+		}
+		if err != nil {
+			return dependencyStatus(fmt.Errorf("s3: %w", err))
+		}
+	}
+
+	switch configuredStorageMode() {
+	case storageModeEmbedded:
+		if os.Getenv("S3_ENDPOINT") == "" {
+			return DependencyStatus{Status: checkSkipped}
+		}
+		return DependencyStatus{Status: checkOK}
+	case storageModeShared:
+		return dependencyStatus(checkSharedStorageAccount(ctx))
+	default:
+		return dependencyStatus(checkAzureCredential(ctx))
+	}
+}
+
+func checkSharedStorageAccount(ctx context.Context) error {
+	cred, err := azidentity.NewDefaultAzureCredential(nil)
+	if err != nil {
+		return err
+	}
+
+	client, err := azblob.NewClient(fmt.Sprintf("https://%s.blob.core.windows.net/", sharedStorageAccount()), cred, nil)
+	if err != nil {
+		return err
+	}
+
+	_, err = client.ServiceClient().GetProperties(ctx, nil)
+	return err
+}
+
+func checkAzureCredential(ctx context.Context) error {
+	cred, err := azidentity.NewDefaultAzureCredential(nil)
+	if err != nil {
+		return err
+	}
+
+	_, err = cred.GetToken(ctx, policy.TokenRequestOptions{Scopes: []string{"https://management.azure.com/.default"}})
+	return err
+}
+
+func checkOpenAIKey(ctx context.Context) error {
+	openAICheckCache.Lock()
+	defer openAICheckCache.Unlock()
+
+	ttl := openAICheckTTL
+	if openAICheckCache.err != nil {
+		ttl = openAIFailedCheckTTL
+	}
+	if !openAICheckCache.checkedAt.IsZero() && time.Since(openAICheckCache.checkedAt) < ttl {
+		return openAICheckCache.err
+	}
+
+	key, found := serverOpenAIKey(ctx)
+	if !found {
+		openAICheckCache.err = errors.New("OPENAI_KEY not set")
+	} else {
+		openAICheckCache.err = validateOpenAIKey(ctx, openAICredentials{APIKey: key})
+	}
+	openAICheckCache.checkedAt = time.Now()
+
+	return openAICheckCache.err
+}
@@ -0,0 +1,104 @@
+package main
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// The frontend polls the recipe list, conditional GETs let it skip the
+// download while nothing changed. Last-Modified is the latest updated_at,
+// or the last publish when that is later. Featuring, archiving or
+// classifying a recipe leaves updated_at alone and a deleted recipe takes
+// its timestamp with it, so the ETag covers every column of the list
+// except the content, which bumps the version.
+
+// recipesFingerprint summarizes the user's recipes as the list shows them.
+// It is computed in the database and never reads the content.
+const recipesFingerprint = `
+	SELECT greatest(max(r.updated_at), u.site_updated_at),
+		coalesce(md5(string_agg(concat_ws(':', r.id, r.version, r.updated_at, r.title, r.category, r.slug, r.season_tags, r.archived, r.draft,
+			r.featured_at, r.cuisine, r.diet, r.prep_minutes, r.cook_minutes, r.total_minutes, r.difficulty, r.language, r.translation_of,
+			r.variant_of, r.appliance, r.parent_recipe_id, r.source_url, r.created_at,
+			r.published_at, r.publish_error), ',' ORDER BY r.id)), '')
+	FROM users u LEFT JOIN recipes r ON r.user_id = u.id
+	WHERE u.id = $1 GROUP BY u.site_updated_at`
+
+// revisionsFingerprint is the same for the revisions of a recipe, they
+// only change by being added or decided.
+const revisionsFingerprint = `
+	SELECT coalesce(max(greatest(created_at, decided_at)), 'epoch'), count(*) || ':' || coalesce(max(id), 0) || ':' || count(decided_at)
+	FROM recipe_revisions WHERE recipe_id = $1 AND user_id = $2`
+
+// notModified sets the validators of the response and answers 304 when the
+// client already has it. The ETag is weak, compression changes the bytes
+// but not what they mean. The query string is part of it, filters change
+// the answer.
+func notModified(w http.ResponseWriter, r *http.Request, fingerprint string, modified time.Time) bool {
+	sum := sha256.Sum256([]byte(fingerprint + "?" + r.URL.RawQuery))
+	etag := `W/"` + hex.EncodeToString(sum[:16]) + `"`
+	modified = modified.UTC().Truncate(time.Second)
+
+	header := w.Header()
+	header.Set("ETag", etag)
+	header.Set("Last-Modified", modified.Format(http.TimeFormat))
+	// per user, and checked with us on every use, unless the handler said
+	// otherwise
+	if header.Get("Cache-Control") == "" {
+		header.Set("Cache-Control", "private, no-cache")
+	}
+
+	if match := r.Header.Get("If-None-Match"); match != "" {
+		if !etagMatches(match, etag) {
+			return false
+		}
+	} else if since, err := http.ParseTime(r.Header.Get("If-Modified-Since")); err != nil || modified.After(since) {
+		return false
+	}
+
+	w.WriteHeader(http.StatusNotModified)
+	return true
+}
+
+// etagMatches compares weakly, as If-None-Match does.
+func etagMatches(header string, etag string) bool {
+	for _, candidate := range strings.Split(header, ",") {
+		candidate = strings.TrimSpace(candidate)
+		if candidate == "*" || strings.TrimPrefix(candidate, "W/") == strings.TrimPrefix(etag, "W/") {
+			return true
+		}
+	}
+	return false
+}
+
+// recipesNotModified is notModified for the recipe list of the user. A
+// failed lookup answers normally.
+func recipesNotModified(ctx context.Context, w http.ResponseWriter, r *http.Request, userID int) bool {
+	// the mock server runs without a database
+	if pool == nil {
+		return false
+	}
+	var modified time.Time
+	var fingerprint string
+	if err := pool.QueryRow(ctx, recipesFingerprint, userID).Scan(&modified, &fingerprint); err != nil {
+		return false
+	}
+	return notModified(w, r, "recipes:"+strconv.Itoa(userID)+":"+fingerprint, modified)
+}
+
+// revisionsNotModified is notModified for the revisions of a recipe.
+func revisionsNotModified(ctx context.Context, w http.ResponseWriter, r *http.Request, userID int, recipeID int) bool {
+	if pool == nil {
+		return false
+	}
+	var modified time.Time
+	var fingerprint string
+	if err := pool.QueryRow(ctx, revisionsFingerprint, recipeID, userID).Scan(&modified, &fingerprint); err != nil {
+		return false
+	}
+	return notModified(w, r, "revisions:"+strconv.Itoa(recipeID)+":"+fingerprint, modified)
+}
@@ -0,0 +1,125 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"time"
+)
+
+// HEIC and WebP have no decoder in the standard library. The image ships
+// heif-convert (libheif) and dwebp (libwebp), which turn them into a JPEG or
+// PNG that prepareImage then handles like any upload. Their dimensions are
+// read from the container first, so the pixel cap applies before a tool
+// decodes anything.
+
+const imageConvertTimeout = 30 * time.Second
+
+// convertImage runs tool on data written to a temporary file and returns
+// the file it wrote. args gets the input and output paths.
+func convertImage(data []byte, tool string, outputExt string, args func(in string, out string) []string) ([]byte, error) {
+	path, err := exec.LookPath(tool)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %s is not installed", errUnsupportedImage, tool)
+	}
+
+	dir, err := os.MkdirTemp("", "image-convert-")
+	if err != nil {
+		return nil, err
+	}
+	defer os.RemoveAll(dir)
+
+	in, out := filepath.Join(dir, "input"), filepath.Join(dir, "output"+outputExt)
+	if err := os.WriteFile(in, data, 0o600); err != nil {
+		return nil, err
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), imageConvertTimeout)
+	defer cancel()
+	output, err := exec.CommandContext(ctx, path, args(in, out)...).CombinedOutput()
+	if err != nil {
+		return nil, fmt.Errorf("%w: %s failed: %v: %s", errUnsupportedImage, tool, err, bytes.TrimSpace(output))
+	}
+	return os.ReadFile(out)
+}
+
+func convertHEIC(data []byte) ([]byte, error) {
+	return convertImage(data, "heif-convert", ".jpg", func(in string, out string) []string {
+		return []string{"-q", "90", in, out}
+	})
+}
+
+func convertWebP(data []byte) ([]byte, error) {
+	return convertImage(data, "dwebp", ".png", func(in string, out string) []string {
+		return []string{in, "-png", "-o", out}
+	})
+}
+
+// heicDimensions returns the largest extent of the ispe properties, the
+// primary image and its tiles each have one.
+func heicDimensions(data []byte) (int, int, error) {
+	width, height := 0, 0
+	for i := 0; ; {
+		at := bytes.Index(data[i:], []byte("ispe"))
+		if at < 0 {
+			break
+		}
+		at += i
+		// type, version and flags, then width and height
+		if at+16 > len(data) {
+			break
+		}
+		w := int(binary.BigEndian.Uint32(data[at+8:]))
+		h := int(binary.BigEndian.Uint32(data[at+12:]))
+		if int64(w)*int64(h) > int64(width)*int64(height) {
+			width, height = w, h
+		}
+		i = at + 4
+	}
+	if width == 0 || height == 0 {
+		return 0, 0, errors.New("HEIC without image extents")
+	}
+	return width, height, nil
+}
+
+// webpDimensions reads the canvas size of the first chunk: VP8X for
+// extended files, the key frame header of VP8 or the VP8L header otherwise.
+func webpDimensions(data []byte) (int, int, error) {
+	if len(data) < 30 || string(data[:4]) != "RIFF" || string(data[8:12]) != "WEBP" {
+		return 0, 0, errors.New("not a WebP file")
+	}
+	chunk := data[20:]
+	switch string(data[12:16]) {
+	case "VP8X":
+		width := 1 + (int(chunk[4]) | int(chunk[5])<<8 | int(chunk[6])<<16)
+		height := 1 + (int(chunk[7]) | int(chunk[8])<<8 | int(chunk[9])<<16)
+		return width, height, nil
+	case "VP8 ":
+		if chunk[3] != 0x9d || chunk[4] != 0x01 || chunk[5] != 0x2a {
+			return 0, 0, errors.New("VP8 without key frame")
+		}
+		width := int(binary.LittleEndian.Uint16(chunk[6:]) & 0x3fff)
+		height := int(binary.LittleEndian.Uint16(chunk[8:]) & 0x3fff)
+		return width, height, nil
+	case "VP8L":
+		if chunk[0] != 0x2f {
+			return 0, 0, errors.New("VP8L without signature")
+		}
+		bits := binary.LittleEndian.Uint32(chunk[1:])
+		return 1 + int(bits&0x3fff), 1 + int(bits>>14&0x3fff), nil
+	}
+	return 0, 0, errors.New("unknown WebP chunk")
+}
+
+// checkImagePixels applies imageMaxPixels to dimensions read from a header.
+func checkImagePixels(width int, height int) error {
+	if width <= 0 || height <= 0 || int64(width)*int64(height) > imageMaxPixels {
+		return fmt.Errorf("%w: %dx%d pixels", errImageTooLarge, width, height)
+	}
+	return nil
+}
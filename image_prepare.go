@@ -0,0 +1,281 @@
+package main
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"image"
+	"image/draw"
+	_ "image/gif"
+	"image/jpeg"
+	_ "image/png"
+	"net/http"
+)
+
+// The vision model scales images to fit 2048x2048 and then to 768 pixels on
+// the short side, anything larger only costs upload time.
+const (
+	imageMaxLongSide  = 2048
+	imageMaxShortSide = 768
+	imageJPEGQuality  = 82
+
+	// imageMaxPixels is checked before decoding, a few kilobytes of PNG can
+	// claim a canvas that takes gigabytes to decode. 48 megapixel phone
+	// photos fit.
+	imageMaxPixels = 50_000_000
+)
+
+var (
+	errUnsupportedImage = errors.New("unsupported image format")
+	errImageTooLarge    = errors.New("image too large")
+)
+
+// prepareImage turns an uploaded photo into an upright JPEG at the
+// resolution the vision model works with. The type is sniffed from the data,
+// browsers and mail clients send whatever the file extension suggests. HEIC
+// and WebP are converted first, see convertImage.
+func prepareImage(data []byte) ([]byte, error) {
+	contentType := http.DetectContentType(data)
+	switch {
+	case isHEIC(data):
+		return convertAndPrepare(data, heicDimensions, convertHEIC)
+	case contentType == "image/webp":
+		return convertAndPrepare(data, webpDimensions, convertWebP)
+	case contentType != "image/jpeg" && contentType != "image/png" && contentType != "image/gif":
+		return nil, fmt.Errorf("%w: %s", errUnsupportedImage, contentType)
+	}
+
+	config, _, err := image.DecodeConfig(bytes.NewReader(data))
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", errUnsupportedImage, err)
+	}
+	if err := checkImagePixels(config.Width, config.Height); err != nil {
+		return nil, err
+	}
+
+	img, format, err := image.Decode(bytes.NewReader(data))
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", errUnsupportedImage, err)
+	}
+
+	// Rotating after downscaling touches a fraction of the pixels.
+	img = downscale(img)
+	if format == "jpeg" {
+		img = applyOrientation(img, jpegOrientation(data))
+	} else {
+		img = flatten(img)
+	}
+
+	var out bytes.Buffer
+	if err := jpeg.Encode(&out, img, &jpeg.Options{Quality: imageJPEGQuality}); err != nil {
+		return nil, err
+	}
+	return out.Bytes(), nil
+}
+
+// convertAndPrepare checks the dimensions, converts and prepares the
+// result. A converter only writes JPEG or PNG, so this doesn't recurse
+// further.
+func convertAndPrepare(data []byte, dimensions func([]byte) (int, int, error), convert func([]byte) ([]byte, error)) ([]byte, error) {
+	width, height, err := dimensions(data)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", errUnsupportedImage, err)
+	}
+	if err := checkImagePixels(width, height); err != nil {
+		return nil, err
+	}
+	converted, err := convert(data)
+	if err != nil {
+		return nil, err
+	}
+	if contentType := http.DetectContentType(converted); contentType != "image/jpeg" && contentType != "image/png" {
+		return nil, fmt.Errorf("%w: converted to %s", errUnsupportedImage, contentType)
+	}
+	return prepareImage(converted)
+}
+
+// imageContentType names the type of a prepared image for its data URL.
+func imageContentType(base64Data string) string {
+	head, err := base64.StdEncoding.DecodeString(base64Data[:min(len(base64Data), 64)])
+	if err != nil {
+		return "image/jpeg"
+	}
+	return http.DetectContentType(head)
+}
+
+// flatten puts transparent PNGs and GIFs on white, JPEG would turn the
+// transparent parts black.
+func flatten(img image.Image) image.Image {
+	out := image.NewRGBA(img.Bounds())
+	draw.Draw(out, out.Bounds(), image.White, image.Point{}, draw.Src)
+	draw.Draw(out, out.Bounds(), img, img.Bounds().Min, draw.Over)
+	return out
+}
+
+// isHEIC checks the ISO base media file type box for HEIF brands.
+func isHEIC(data []byte) bool {
+	if len(data) < 12 || string(data[4:8]) != "ftyp" {
+		return false
+	}
+	switch string(data[8:12]) {
+	case "heic", "heix", "heim", "heis", "hevc", "hevx", "mif1", "msf1":
+		return true
+	}
+	return false
+}
+
+// jpegOrientation reads the EXIF orientation tag, 1 (upright) when there is
+// none.
+func jpegOrientation(data []byte) int {
+	if len(data) < 4 || data[0] != 0xFF || data[1] != 0xD8 {
+		return 1
+	}
+
+	for i := 2; i+4 <= len(data); {
+		if data[i] != 0xFF {
+			return 1
+		}
+		marker := data[i+1]
+		length := int(binary.BigEndian.Uint16(data[i+2:]))
+		if marker == 0xDA || length < 2 || i+2+length > len(data) {
+			return 1
+		}
+
+		segment := data[i+4 : i+2+length]
+		if marker == 0xE1 && bytes.HasPrefix(segment, []byte("Exif\x00\x00")) {
+			return exifOrientation(segment[6:])
+		}
+		i += 2 + length
+	}
+	return 1
+}
+
+func exifOrientation(tiff []byte) int {
+	if len(tiff) < 8 {
+		return 1
+	}
+
+	var order binary.ByteOrder
+	switch string(tiff[:2]) {
+	case "II":
+		order = binary.LittleEndian
+	case "MM":
+		order = binary.BigEndian
+	default:
+		return 1
+	}
+
+	ifd := int(order.Uint32(tiff[4:]))
+	if ifd+2 > len(tiff) {
+		return 1
+	}
+	entries := int(order.Uint16(tiff[ifd:]))
+	for e := 0; e < entries; e++ {
+		entry := ifd + 2 + e*12
+		if entry+12 > len(tiff) {
+			return 1
+		}
+		if order.Uint16(tiff[entry:]) == 0x0112 {
+			orientation := int(order.Uint16(tiff[entry+8:]))
+			if orientation < 1 || orientation > 8 {
+				return 1
+			}
+			return orientation
+		}
+	}
+	return 1
+}
+
+// applyOrientation rotates and mirrors the image so that it is displayed as
+// the camera app showed it.
+func applyOrientation(img image.Image, orientation int) image.Image {
+	if orientation == 1 {
+		return img
+	}
+
+	bounds := img.Bounds()
+	w, h := bounds.Dx(), bounds.Dy()
+	swap := orientation >= 5
+	outW, outH := w, h
+	if swap {
+		outW, outH = h, w
+	}
+
+	out := image.NewRGBA(image.Rect(0, 0, outW, outH))
+	for y := 0; y < h; y++ {
+		for x := 0; x < w; x++ {
+			var dx, dy int
+			switch orientation {
+			case 2:
+				dx, dy = w-1-x, y
+			case 3:
+				dx, dy = w-1-x, h-1-y
+			case 4:
+				dx, dy = x, h-1-y
+			case 5:
+				dx, dy = y, x
+			case 6:
+				dx, dy = h-1-y, x
+			case 7:
+				dx, dy = h-1-y, w-1-x
+			case 8:
+				dx, dy = y, w-1-x
+			}
+			out.Set(dx, dy, img.At(bounds.Min.X+x, bounds.Min.Y+y))
+		}
+	}
+	return out
+}
+
+// downscale shrinks the image with a box filter until it fits the model's
+// resolution, smaller images are returned unchanged.
+func downscale(img image.Image) image.Image {
+	bounds := img.Bounds()
+	w, h := bounds.Dx(), bounds.Dy()
+	long, short := max(w, h), min(w, h)
+	if long == 0 {
+		return img
+	}
+
+	scale := 1.0
+	if long > imageMaxLongSide {
+		scale = float64(imageMaxLongSide) / float64(long)
+	}
+	if float64(short)*scale > imageMaxShortSide {
+		scale = float64(imageMaxShortSide) / float64(short)
+	}
+	if scale >= 1 {
+		return img
+	}
+
+	src := image.NewRGBA(image.Rect(0, 0, w, h))
+	draw.Draw(src, src.Bounds(), img, bounds.Min, draw.Src)
+
+	outW, outH := max(1, int(float64(w)*scale)), max(1, int(float64(h)*scale))
+	out := image.NewRGBA(image.Rect(0, 0, outW, outH))
+	for y := 0; y < outH; y++ {
+		y0, y1 := y*h/outH, max((y+1)*h/outH, y*h/outH+1)
+		for x := 0; x < outW; x++ {
+			x0, x1 := x*w/outW, max((x+1)*w/outW, x*w/outW+1)
+
+			var r, g, b, a, n int
+			for sy := y0; sy < y1; sy++ {
+				row := src.Pix[sy*src.Stride:]
+				for sx := x0; sx < x1; sx++ {
+					p := row[sx*4 : sx*4+4]
+					r += int(p[0])
+					g += int(p[1])
+					b += int(p[2])
+					a += int(p[3])
+					n++
+				}
+			}
+
+			o := out.Pix[y*out.Stride+x*4:]
+			o[0], o[1], o[2], o[3] = uint8(r/n), uint8(g/n), uint8(b/n), uint8(a/n)
+		}
+	}
+	return out
+}
@@ -0,0 +1,85 @@
+package main
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"hash/crc32"
+	"image"
+	"image/png"
+	"testing"
+)
+
+// pngClaiming is a 1x1 PNG whose header claims width x height pixels.
+func pngClaiming(t *testing.T, width uint32, height uint32) []byte {
+	t.Helper()
+	var buf bytes.Buffer
+	if err := png.Encode(&buf, image.NewRGBA(image.Rect(0, 0, 1, 1))); err != nil {
+		t.Fatal(err)
+	}
+	data := buf.Bytes()
+	// signature, IHDR length and type, then width and height
+	binary.BigEndian.PutUint32(data[16:], width)
+	binary.BigEndian.PutUint32(data[20:], height)
+	binary.BigEndian.PutUint32(data[29:], crc32.ChecksumIEEE(data[12:29]))
+	return data
+}
+
+func TestPrepareImageRejectsPixelFloods(t *testing.T) {
+	_, err := prepareImage(pngClaiming(t, 100_000, 100_000))
+	if !errors.Is(err, errImageTooLarge) {
+		t.Errorf("got %v, want errImageTooLarge", err)
+	}
+}
+
+func TestPrepareImageDownscales(t *testing.T) {
+	var buf bytes.Buffer
+	if err := png.Encode(&buf, image.NewRGBA(image.Rect(0, 0, 3000, 1000))); err != nil {
+		t.Fatal(err)
+	}
+	prepared, err := prepareImage(buf.Bytes())
+	if err != nil {
+		t.Fatal(err)
+	}
+	config, format, err := image.DecodeConfig(bytes.NewReader(prepared))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if format != "jpeg" || config.Width != imageMaxLongSide || config.Height > imageMaxShortSide {
+		t.Errorf("got %s of %dx%d", format, config.Width, config.Height)
+	}
+}
+
+func TestWebPDimensions(t *testing.T) {
+	riff := func(chunk string, payload []byte) []byte {
+		data := append([]byte("RIFF\x00\x00\x00\x00WEBP"+chunk+"\x00\x00\x00\x00"), payload...)
+		return append(data, make([]byte, 16)...)
+	}
+
+	vp8l := make([]byte, 5)
+	vp8l[0] = 0x2f
+	binary.LittleEndian.PutUint32(vp8l[1:], 3999|2999<<14)
+	vp8x := []byte{0, 0, 0, 0, 0x9f, 0x0f, 0, 0xb7, 0x0b, 0}
+	vp8 := []byte{0, 0, 0, 0x9d, 0x01, 0x2a, 0xa0, 0x0f, 0xb8, 0x0b}
+
+	for name, data := range map[string][]byte{"VP8L": riff("VP8L", vp8l), "VP8X": riff("VP8X", vp8x), "VP8 ": riff("VP8 ", vp8)} {
+		width, height, err := webpDimensions(data)
+		if err != nil || width != 4000 || height != 3000 {
+			t.Errorf("%s: got %dx%d, %v", name, width, height, err)
+		}
+	}
+}
+
+func TestPrepareImageRejectsLargeHEIC(t *testing.T) {
+	data := []byte("\x00\x00\x00\x18ftypheic\x00\x00\x00\x00mif1heic")
+	ispe := make([]byte, 20)
+	binary.BigEndian.PutUint32(ispe, 20)
+	copy(ispe[4:], "ispe")
+	binary.BigEndian.PutUint32(ispe[12:], 100_000)
+	binary.BigEndian.PutUint32(ispe[16:], 100_000)
+	data = append(data, ispe...)
+
+	if _, err := prepareImage(data); !errors.Is(err, errImageTooLarge) {
+		t.Errorf("got %v, want errImageTooLarge", err)
+	}
+}
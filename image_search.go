@@ -0,0 +1,256 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"log"
+	"math"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// embeddingBatchSize is how many recipes are embedded per call, recipes
+// changed since their last embedding are caught up before every search.
+const embeddingBatchSize = 100
+
+const (
+	defaultImageMatches = 3
+	maxImageMatches     = 10
+)
+
+const dishCaptionSystemMessage = "You describe photos of dishes for a recipe search. Name the dish and list the ingredients " +
+	"you can see and how it is served, in one or two plain sentences without any formatting."
+
+type ImageMatch struct {
+	Recipe Recipe  `json:"recipe"`
+	Score  float64 `json:"score"`
+}
+
+type ImageSearchResult struct {
+	// Caption is what the vision model saw in the photo.
+	Caption string       `json:"caption"`
+	Matches []ImageMatch `json:"matches"`
+}
+
+// HandleFindRecipeByImage matches a photo of a dish against the user's
+// recipes: the vision model describes the photo and the description is
+// compared with embeddings of the recipes' titles and ingredients.
+func HandleFindRecipeByImage(w http.ResponseWriter, r *http.Request) {
+	userCtx, ok := r.Context().Value("user").(UserContext)
+	if !ok {
+		http.Error(w, "Unauthorized: user context missing", http.StatusUnauthorized)
+		return
+	}
+
+	// Parse multipart form data (10 MB max)
+	if err := r.ParseMultipartForm(10 << 20); err != nil {
+		http.Error(w, "Failed to parse form data", http.StatusBadRequest)
+		return
+	}
+
+	limit := defaultImageMatches
+	if value := r.FormValue("limit"); value != "" {
+		parsed, err := strconv.Atoi(value)
+		if err != nil || parsed <= 0 {
+			http.Error(w, "limit must be a positive number", http.StatusBadRequest)
+			return
+		}
+		limit = min(parsed, maxImageMatches)
+	}
+	isGerman, reason := parseIsGerman(r.Context(), r.FormValue("isGerman"))
+	if reason != "" {
+		http.Error(w, reason, http.StatusBadRequest)
+		return
+	}
+
+	file, _, err := r.FormFile("image")
+	if err != nil {
+		http.Error(w, "Failed to get the image file", http.StatusBadRequest)
+		return
+	}
+	defer file.Close()
+
+	base64Data, err := EncodeImageToBase64(file)
+	if err != nil {
+		if errors.Is(err, errUnsupportedImage) {
+			http.Error(w, "Unsupported image format, please upload a JPEG, PNG, HEIC or WebP", http.StatusUnsupportedMediaType)
+			return
+		}
+		if errors.Is(err, errImageTooLarge) {
+			http.Error(w, "Image too large, please upload a photo of at most 50 megapixels", http.StatusRequestEntityTooLarge)
+			return
+		}
+		log.Printf("Error preparing image: %v\n", err)
+		http.Error(w, "Failed to encode image to base64", http.StatusInternalServerError)
+		return
+	}
+
+	system := dishCaptionSystemMessage
+	if isGerman {
+		system += "\nAnswer in German."
+	}
+	caption, err := llm.Complete(r.Context(), llmRequest{
+		Task:        llmTaskDishCaption,
+		System:      system,
+		Prompt:      "Describe the dish in this photo.",
+		ImageBase64: base64Data,
+	})
+	caption = strings.TrimSpace(caption)
+	if err != nil || caption == "" {
+		log.Printf("Error describing dish photo: %v\n", err)
+		http.Error(w, "Error describing the photo", http.StatusInternalServerError)
+		return
+	}
+
+	matches, err := matchRecipes(r.Context(), userCtx.UserID, caption, limit)
+	if err != nil {
+		log.Printf("Error matching recipes for user %d: %v\n", userCtx.UserID, err)
+		http.Error(w, "Error matching recipes", http.StatusInternalServerError)
+		return
+	}
+	for i := range matches {
+		matches[i].Recipe.URL = recipeURL(userCtx.Subdomain, matches[i].Recipe.Slug)
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+
+	err = json.NewEncoder(w).Encode(ImageSearchResult{Caption: caption, Matches: matches})
+	if err != nil {
+		http.Error(w, "Error encoding JSON response", http.StatusInternalServerError)
+	}
+}
+
+// matchRecipes ranks the user's recipes by how close their embedding is to
+// the description, best first.
+func matchRecipes(ctx context.Context, userID int, description string, limit int) ([]ImageMatch, error) {
+	if err := refreshRecipeEmbeddings(ctx, userID); err != nil {
+		return nil, err
+	}
+
+	vectors, err := llm.Embed(ctx, []string{description})
+	if err != nil {
+		return nil, err
+	}
+	query := vectors[0]
+
+	rows, err := pool.Query(ctx, `
+		SELECT r.id, r.title, r.category, r.slug, e.embedding FROM recipes r JOIN recipe_embeddings e ON e.recipe_id = r.id
+		WHERE r.user_id = $1 AND NOT r.archived AND r.translation_of IS NULL`, userID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	matches := []ImageMatch{}
+	for rows.Next() {
+		var match ImageMatch
+		var embedding []float32
+		if err := rows.Scan(&match.Recipe.ID, &match.Recipe.Recipename, &match.Recipe.Category, &match.Recipe.Slug, &embedding); err != nil {
+			return nil, err
+		}
+		match.Score = roundScore(cosineSimilarity(query, embedding))
+		matches = append(matches, match)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	sort.SliceStable(matches, func(i, j int) bool {
+		return matches[i].Score > matches[j].Score
+	})
+	if len(matches) > limit {
+		matches = matches[:limit]
+	}
+	return matches, nil
+}
+
+// refreshRecipeEmbeddings embeds recipes that have no embedding yet or were
+// edited since, the stored version tells.
+func refreshRecipeEmbeddings(ctx context.Context, userID int) error {
+	for {
+		rows, err := pool.Query(ctx, `
+			SELECT r.id, r.version, r.title, r.category, r.content FROM recipes r LEFT JOIN recipe_embeddings e ON e.recipe_id = r.id
+			WHERE r.user_id = $1 AND NOT r.archived AND r.translation_of IS NULL AND (e.recipe_id IS NULL OR e.version <> r.version)
+			ORDER BY r.id LIMIT $2`, userID, embeddingBatchSize)
+		if err != nil {
+			return err
+		}
+
+		var ids, versions []int
+		var texts []string
+		for rows.Next() {
+			var id, version int
+			var title, category, content string
+			if err := rows.Scan(&id, &version, &title, &category, &content); err != nil {
+				rows.Close()
+				return err
+			}
+			ids = append(ids, id)
+			versions = append(versions, version)
+			texts = append(texts, recipeEmbeddingText(title, category, content))
+		}
+		rows.Close()
+		if err := rows.Err(); err != nil {
+			return err
+		}
+		if len(ids) == 0 {
+			return nil
+		}
+
+		vectors, err := llm.Embed(ctx, texts)
+		if err != nil {
+			return err
+		}
+		for i, id := range ids {
+			_, err := pool.Exec(ctx, `
+				INSERT INTO recipe_embeddings (recipe_id, version, embedding) VALUES ($1, $2, $3)
+				ON CONFLICT (recipe_id) DO UPDATE SET version = $2, embedding = $3`,
+				id, versions[i], vectors[i])
+			if err != nil {
+				return err
+			}
+		}
+		if len(ids) < embeddingBatchSize {
+			return nil
+		}
+	}
+}
+
+// recipeEmbeddingText holds what can be told from a photo, the steps would
+// only blur the match.
+func recipeEmbeddingText(title string, category string, content string) string {
+	var names []string
+	for _, ingredient := range parseRecipeMarkdown(content).Ingredients {
+		if name := ingredientKey(ingredient.Name); name != "" {
+			names = append(names, name)
+		}
+	}
+	text := title
+	if category != "" {
+		text += " (" + category + ")"
+	}
+	if len(names) > 0 {
+		text += ": " + strings.Join(names, ", ")
+	}
+	return text
+}
+
+func cosineSimilarity(a []float32, b []float32) float64 {
+	if len(a) != len(b) {
+		return 0
+	}
+	var dot, normA, normB float64
+	for i := range a {
+		dot += float64(a[i]) * float64(b[i])
+		normA += float64(a[i]) * float64(a[i])
+		normB += float64(b[i]) * float64(b[i])
+	}
+	if normA == 0 || normB == 0 {
+		return 0
+	}
+	return dot / (math.Sqrt(normA) * math.Sqrt(normB))
+}
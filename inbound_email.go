@@ -0,0 +1,395 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"os"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+)
+
+// inboundMaxAge rejects replayed webhook calls.
+const inboundMaxAge = 15 * time.Minute
+
+// inboundMinRecipeLength separates pasted recipes from short descriptions
+// like "Linsensuppe mit Speck".
+const inboundMinRecipeLength = 200
+
+var inboundLinkPattern = regexp.MustCompile(`https?://[^\s<>"]+`)
+
+type inboundAttachment struct {
+	Filename    string
+	ContentType string
+	Data        []byte
+}
+
+type inboundEmail struct {
+	Subject     string
+	Text        string
+	Attachments []inboundAttachment
+}
+
+func inboundAddress(token string) string {
+	return token + "@" + os.Getenv("INBOUND_EMAIL_DOMAIN")
+}
+
+// HandleGetInboundAddress returns the address that imports mailed recipes
+// into the user's collection, rotate=true replaces it.
+func HandleGetInboundAddress(w http.ResponseWriter, r *http.Request) {
+	userCtx, ok := r.Context().Value("user").(UserContext)
+	if !ok {
+		http.Error(w, "Unauthorized: user context missing", http.StatusUnauthorized)
+		return
+	}
+
+	if os.Getenv("INBOUND_EMAIL_DOMAIN") == "" {
+		http.Error(w, "Email import is not configured", http.StatusNotFound)
+		return
+	}
+
+	var token string
+	err := pool.QueryRow(r.Context(), "SELECT inbound_token FROM users WHERE id = $1", userCtx.UserID).Scan(&token)
+	if err != nil {
+		log.Printf("Error getting inbound token: %v\n", err)
+		http.Error(w, "Error getting email address", http.StatusInternalServerError)
+		return
+	}
+
+	if token == "" || r.URL.Query().Get("rotate") == "true" {
+		random := make([]byte, 12)
+		if _, err := rand.Read(random); err != nil {
+			log.Printf("Error generating inbound token: %v\n", err)
+			http.Error(w, "Error getting email address", http.StatusInternalServerError)
+			return
+		}
+		token = hex.EncodeToString(random)
+
+		_, err = pool.Exec(r.Context(), "UPDATE users SET inbound_token = $1 WHERE id = $2", token, userCtx.UserID)
+		if err != nil {
+			log.Printf("Error saving inbound token: %v\n", err)
+			http.Error(w, "Error getting email address", http.StatusInternalServerError)
+			return
+		}
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+
+	err = json.NewEncoder(w).Encode(map[string]string{"address": inboundAddress(token)})
+	if err != nil {
+		http.Error(w, "Error encoding JSON response", http.StatusInternalServerError)
+	}
+}
+
+// HandleMailgunInbound receives mails forwarded by a Mailgun route. The mail
+// is imported in the background, Mailgun retries calls that take too long.
+func HandleMailgunInbound(w http.ResponseWriter, r *http.Request) {
+	if err := r.ParseMultipartForm(25 << 20); err != nil {
+		http.Error(w, "Failed to parse form data", http.StatusBadRequest)
+		return
+	}
+
+	if !validMailgunSignature(r.FormValue("timestamp"), r.FormValue("token"), r.FormValue("signature")) {
+		http.Error(w, "Invalid signature", http.StatusUnauthorized)
+		return
+	}
+	// A signature stays valid for inboundMaxAge either side of its
+	// timestamp, its token is only accepted once. Mailgun does not retry a
+	// 406.
+	if !appCache.Add(r.Context(), mailgunTokenKey(r.FormValue("token")), true, 2*inboundMaxAge) {
+		http.Error(w, "Webhook already received", http.StatusNotAcceptable)
+		return
+	}
+
+	recipient := strings.ToLower(r.FormValue("recipient"))
+	token, domain, _ := strings.Cut(recipient, "@")
+	userCtx, err := userForInboundToken(r.Context(), token)
+	if err != nil {
+		// Unknown addresses are accepted, a rejection makes Mailgun retry.
+		if !errors.Is(err, pgx.ErrNoRows) {
+			log.Printf("Error getting user for inbound mail: %v\n", err)
+			http.Error(w, "Error getting user", http.StatusInternalServerError)
+			return
+		}
+		// the local part is the secret token, only the domain is logged
+		log.Printf("Dropping inbound mail to unknown address [redacted]@%s\n", domain)
+		w.WriteHeader(http.StatusOK)
+		return
+	}
+
+	mail := inboundEmail{Subject: r.FormValue("subject"), Text: r.FormValue("stripped-text")}
+	if mail.Text == "" {
+		mail.Text = r.FormValue("body-plain")
+	}
+
+	count, _ := strconv.Atoi(r.FormValue("attachment-count"))
+	for i := 1; i <= count; i++ {
+		file, header, err := r.FormFile("attachment-" + strconv.Itoa(i))
+		if err != nil {
+			continue
+		}
+		data, err := io.ReadAll(file)
+		_ = file.Close()
+		if err != nil {
+			continue
+		}
+		mail.Attachments = append(mail.Attachments, inboundAttachment{
+			Filename:    header.Filename,
+			ContentType: header.Header.Get("Content-Type"),
+			Data:        data,
+		})
+	}
+
+	go importInboundEmail(userCtx, mail)
+
+	w.WriteHeader(http.StatusOK)
+}
+
+func validMailgunSignature(timestamp string, token string, signature string) bool {
+	key := os.Getenv("MAILGUN_WEBHOOK_SIGNING_KEY")
+	if key == "" {
+		log.Println("MAILGUN_WEBHOOK_SIGNING_KEY not set, rejecting inbound mail")
+		return false
+	}
+
+	seconds, err := strconv.ParseInt(timestamp, 10, 64)
+	if err != nil || time.Since(time.Unix(seconds, 0)).Abs() > inboundMaxAge {
+		return false
+	}
+
+	mac := hmac.New(sha256.New, []byte(key))
+	mac.Write([]byte(timestamp + token))
+	expected := hex.EncodeToString(mac.Sum(nil))
+	return hmac.Equal([]byte(expected), []byte(signature))
+}
+
+func mailgunTokenKey(token string) string {
+	return "mailgun-token:" + token
+}
+
+func userForInboundToken(ctx context.Context, token string) (UserContext, error) {
+	if token == "" {
+		return UserContext{}, pgx.ErrNoRows
+	}
+
+	userCtx := UserContext{via: viaEmail}
+	err := pool.QueryRow(ctx, `
+		SELECT id, oauth_id, coalesce(name, ''), coalesce(email, ''), coalesce(oauth_provider, ''), subdomain
+		FROM users WHERE inbound_token = $1 AND provisioning_status = $2`,
+		token, provisioningReady).Scan(&userCtx.UserID, &userCtx.oauthID, &userCtx.FullName, &userCtx.Email, &userCtx.Provider,
+		&userCtx.Subdomain)
+	return userCtx, err
+}
+
+// importInboundEmail turns every photo and PDF into a recipe. Without usable
+// attachments the text is imported: a link is fetched, a pasted recipe is
+// reformatted and anything shorter is treated as a description. The
+// confirmation goes to the account address, never to the sender, so the
+// import address can't be used to relay mail.
+func importInboundEmail(userCtx UserContext, mail inboundEmail) {
+	ctx := context.WithValue(context.Background(), "user", userCtx)
+	// A mail has no language switch, recipes are written in German like the
+	// published site.
+	isGerman := true
+
+	batch := &importBatch{ctx: ctx, userCtx: userCtx}
+
+	_, limits, err := GetUserPlan(ctx, userCtx.UserID)
+	if err != nil {
+		log.Printf("Error getting plan for user %d: %v\n", userCtx.UserID, err)
+	}
+
+	for _, attachment := range mail.Attachments {
+		switch {
+		case strings.HasPrefix(attachment.ContentType, "image/"):
+			if !limits.Features[featureImageImport] {
+				batch.fail(attachment.Filename, "Foto-Import ist in deinem Tarif nicht enthalten")
+				continue
+			}
+			batch.add(attachment.Filename, "", func(ctx context.Context) (string, string, error) {
+				return recipeFromImage(ctx, attachment.Data, isGerman)
+			})
+		case attachment.ContentType == "application/pdf" || strings.HasSuffix(strings.ToLower(attachment.Filename), ".pdf"):
+			text := pdfText(attachment.Data)
+			if text == "" {
+				batch.fail(attachment.Filename, "enthält keinen lesbaren Text")
+				continue
+			}
+			batch.add(attachment.Filename, "", func(ctx context.Context) (string, string, error) {
+				return recipeFromText(ctx, text, isGerman)
+			})
+		}
+	}
+
+	text := strings.TrimSpace(mail.Text)
+	if len(batch.slugs) == 0 && len(batch.failures) == 0 && text != "" {
+		link := inboundLinkPattern.FindString(text)
+		switch {
+		case link != "" && len(text) < inboundMinRecipeLength:
+			link = strings.TrimRight(link, ".,;)")
+			if normalized, err := normalizeSourceURL(link); err == nil {
+				link = normalized
+			}
+			// fetched like a clip, only public addresses are reached
+			batch.add(link, link, func(ctx context.Context) (string, string, error) {
+				return GenerateRecipeByLink(ctx, link, isGerman)
+			})
+		case len(text) >= inboundMinRecipeLength:
+			batch.add("Text der Mail", "", func(ctx context.Context) (string, string, error) {
+				return recipeFromText(ctx, text, isGerman)
+			})
+		default:
+			batch.add("Beschreibung", "", func(ctx context.Context) (string, string, error) {
+				recipe, err := GenerateRecipeByName(ctx, text, isGerman)
+				if err != nil {
+					return "", "", err
+				}
+				recipename, err := openAIgenerateRecipeName(ctx, recipe, isGerman)
+				return recipename, recipe, err
+			})
+		}
+	}
+
+	batch.confirm(fmt.Sprintf("Re: %s", mail.Subject))
+}
+
+// importBatch imports several sources in the background and collects what
+// became of them for one confirmation mail.
+type importBatch struct {
+	ctx      context.Context
+	userCtx  UserContext
+	slugs    []string
+	failures []string
+}
+
+// add checks the plan's limits, generates the recipe and saves it. The
+// generated recipe is judged, so a source without a recipe is reported
+// instead of saved. Every recipe gets backgroundRecipeBudget.
+func (b *importBatch) add(describe string, sourceURL string, generate func(ctx context.Context) (string, string, error)) {
+	if err := checkRecipeLimit(b.ctx, b.userCtx.UserID); err != nil {
+		b.fail(describe, inboundErrorMessage(err))
+		return
+	}
+	if err := countGeneration(b.ctx, b.userCtx.UserID); err != nil {
+		b.fail(describe, inboundErrorMessage(err))
+		return
+	}
+
+	ctx := withLLMBudget(b.ctx, &llmBudget{remaining: backgroundRecipeBudget})
+	recipename, recipe, err := generate(ctx)
+	if err == nil {
+		if related, _ := isRecipeRelated(ctx, judgeModeImport, recipe); !related {
+			err = errors.New("no recipe found")
+		}
+	}
+	if errors.Is(err, errPrivateAddress) {
+		b.fail(describe, "Seite nicht erreichbar")
+		return
+	}
+	if errors.Is(err, errInputTooLarge) {
+		b.fail(describe, "zu lang, bitte kürzen")
+		return
+	}
+	if err != nil {
+		log.Printf("Error importing %s for user %d: %v\n", describe, b.userCtx.UserID, err)
+		b.fail(describe, "kein Rezept erkannt")
+		return
+	}
+
+	added, err := addRecipe(b.ctx, b.userCtx, RecipeRequest{Recipename: recipename, Recipe: recipe, SourceURL: sourceURL})
+	if err != nil {
+		log.Printf("Error saving imported recipe for user %d: %v\n", b.userCtx.UserID, err)
+		b.fail(describe, "konnte nicht gespeichert werden")
+		return
+	}
+	b.slugs = append(b.slugs, added.Slug)
+}
+
+func (b *importBatch) fail(describe string, reason string) {
+	b.failures = append(b.failures, describe+": "+reason)
+}
+
+// confirm mails the outcome to the account address, never to a sender, so
+// an import can't be used to relay mail.
+func (b *importBatch) confirm(subject string) {
+	userCtx := b.userCtx
+	if userCtx.Email == "" {
+		return
+	}
+
+	var body strings.Builder
+	if len(b.slugs) > 0 {
+		body.WriteString("Diese Rezepte wurden gespeichert:\n")
+		for _, slug := range b.slugs {
+			body.WriteString("- " + recipeURL(userCtx.Subdomain, slug) + "\n")
+		}
+	}
+	if len(b.failures) > 0 {
+		if body.Len() > 0 {
+			body.WriteString("\n")
+		}
+		body.WriteString("Nicht importiert:\n")
+		for _, failure := range b.failures {
+			body.WriteString("- " + failure + "\n")
+		}
+	}
+	if body.Len() == 0 {
+		body.WriteString("Es wurde nichts zum Importieren gefunden.\n")
+	}
+
+	err := newMailer().Send(userCtx.Email, subject, body.String())
+	if err != nil {
+		log.Printf("Error sending import confirmation to user %d: %v\n", userCtx.UserID, err)
+	}
+}
+
+// recipeFromImage generates a recipe from a photo of one.
+func recipeFromImage(ctx context.Context, data []byte, isGerman bool) (string, string, error) {
+	image, err := EncodeImageToBase64(bytes.NewReader(data))
+	if err != nil {
+		return "", "", err
+	}
+	recipe, err := GenerateRecipeByImage(ctx, image, isGerman)
+	if err != nil {
+		return "", "", err
+	}
+	recipename, err := openAIgenerateRecipeName(ctx, recipe, isGerman)
+	return recipename, recipe, err
+}
+
+// recipeFromText reformats a recipe that is already written down, the same
+// way as a fetched web page.
+func recipeFromText(ctx context.Context, text string, isGerman bool) (string, string, error) {
+	recipe, err := openAIgenerateRecipeLink(ctx, text, isGerman)
+	if err != nil {
+		return "", "", err
+	}
+	recipename, err := openAIgenerateRecipeName(ctx, recipe, isGerman)
+	return recipename, recipe, err
+}
+
+func inboundErrorMessage(err error) string {
+	switch {
+	case errors.Is(err, errRecipeLimit):
+		return "Rezeptlimit deines Tarifs erreicht"
+	case errors.Is(err, errGenerationLimit):
+		return "Tageslimit für Generierungen erreicht"
+	default:
+		log.Printf("Error checking limits for inbound mail: %v\n", err)
+		return "interner Fehler"
+	}
+}
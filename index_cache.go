@@ -0,0 +1,187 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"log"
+	"slices"
+	"sort"
+	"time"
+)
+
+// The index of a large collection used to be rendered from every recipe
+// with its content on each mutation. It is now rendered from index rows,
+// the recipes without their content, and cached with its link lists under
+// the publish lock. A change published with its recipe IDs reads only their
+// rows and renders only the sections they touch again. Any other change
+// goes through invalidateRecipes, which bumps the index generation: a
+// cached index of an older generation is not used, so a publish finishing
+// after the invalidation can't bring its rows back.
+const indexCacheTTL = time.Hour
+
+const queryIndexRecipes = "SELECT id, title, category, slug, season_tags, coalesce(translation_of, 0), archived, featured_at, cuisine, draft, created_at, updated_at FROM recipes WHERE user_id = $1"
+
+// cachedIndex is the cached state of a user's recipes.md. Layout tells
+// whether the links were rendered with the current settings and categories.
+type cachedIndex struct {
+	Generation int64      `json:"generation"`
+	Layout     string     `json:"layout"`
+	Recipes    []Recipe   `json:"recipes"`
+	Links      indexLinks `json:"links"`
+}
+
+// loadIndexRecipes reads the index rows of the user, all of them or only
+// the given recipes, in the order they were added.
+func loadIndexRecipes(ctx context.Context, userID int, recipeIDs ...int) ([]Recipe, error) {
+	query, args := queryIndexRecipes, []any{userID}
+	if len(recipeIDs) > 0 {
+		query, args = query+" AND id = ANY($2)", append(args, recipeIDs)
+	}
+	rows, err := pool.Query(ctx, query+" ORDER BY id", args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var recipes []Recipe
+	for rows.Next() {
+		var recipe Recipe
+		err := rows.Scan(&recipe.ID, &recipe.Recipename, &recipe.Category, &recipe.Slug, &recipe.SeasonTags, &recipe.TranslationOf,
+			&recipe.Archived, &recipe.FeaturedAt, &recipe.Cuisine, &recipe.Draft, &recipe.CreatedAt, &recipe.UpdatedAt)
+		if err != nil {
+			return nil, err
+		}
+		recipes = append(recipes, recipe)
+	}
+	return recipes, rows.Err()
+}
+
+func cachedIndexOf(ctx context.Context, userID int, generation int64) (cachedIndex, bool) {
+	var cached cachedIndex
+	if !appCache.Get(ctx, indexCacheKey(userID), &cached) || cached.Generation != generation {
+		return cachedIndex{}, false
+	}
+	return cached, true
+}
+
+// indexRecipes returns the cached index rows of the user, reading them all
+// when there are none. Only publishIndex stores them, under the lock.
+func indexRecipes(ctx context.Context, userID int) ([]Recipe, error) {
+	if cached, found := cachedIndexOf(ctx, userID, indexGeneration(ctx, userID)); found {
+		return cached.Recipes, nil
+	}
+	return loadIndexRecipes(ctx, userID)
+}
+
+// indexLayoutKey identifies what the links of the index are rendered with
+// besides the recipes.
+func indexLayoutKey(settings IndexSettings, categories []Category, language string) (string, error) {
+	layout, err := json.Marshal(struct {
+		Settings   IndexSettings `json:"settings"`
+		Categories []Category    `json:"categories"`
+		Language   string        `json:"language"`
+	}{settings, categories, language})
+	return string(layout), err
+}
+
+// templateRecipeChange publishes the index with the rows of the changed
+// recipes read again, a recipe without a row is gone. It runs under the
+// publish lock.
+func templateRecipeChange(storageAccountName string, userid int, generation int64, previous cachedIndex, recipeIDs []int) error {
+	fresh, err := loadIndexRecipes(context.Background(), userid, recipeIDs...)
+	if err != nil {
+		log.Printf("Failed to get recipes from database, error: %s", err)
+		return err
+	}
+
+	changed := map[int]bool{}
+	for _, id := range recipeIDs {
+		changed[id] = true
+	}
+	recipes := make([]Recipe, 0, len(previous.Recipes)+len(fresh))
+	for _, recipe := range previous.Recipes {
+		if !changed[recipe.ID] {
+			recipes = append(recipes, recipe)
+		}
+	}
+	recipes = append(recipes, fresh...)
+	sort.Slice(recipes, func(i, j int) bool { return recipes[i].ID < recipes[j].ID })
+
+	return publishIndex(storageAccountName, userid, generation, recipes, &previous, recipeIDs)
+}
+
+// publishIndex renders recipes.md from the rows and uploads it, then
+// publishes the collections and the sitemap. With a previous render only the
+// collections holding a changed recipe are published again, all of them
+// when one was deleted. An unchanged file is skipped by addBlob, which
+// compares with the hash recorded in the database. It runs under the
+// publish lock.
+func publishIndex(storageAccountName string, userid int, generation int64, recipes []Recipe, previous *cachedIndex, changed []int) error {
+	ctx := context.Background()
+	combinedTemplate, cached, err := renderRecipesIndexChange(userid, recipes, previous, changed)
+	if err != nil {
+		return err
+	}
+
+	err = addBlob(storageAccountName, "recipes.md", combinedTemplate)
+	recordIndexPublish(ctx, userid, err)
+	if err != nil {
+		log.Printf("Failed to add recipes to $web container of storage account  %s, error: %s", storageAccountName, err)
+		return err
+	}
+	// stored with the generation read before the rows, an invalidation
+	// since then makes readers pass it over
+	cached.Generation = generation
+	appCache.Set(ctx, indexCacheKey(userid), cached, indexCacheTTL)
+
+	collections, err := listCollections(ctx, userid)
+	if err != nil {
+		log.Printf("Failed to get collections from database, error: %s", err)
+		return err
+	}
+	if previous != nil {
+		collections = changedCollections(collections, recipes, changed)
+	}
+	err = publishCollections(ctx, storageAccountName, userid, collections)
+	if err != nil {
+		log.Printf("Failed to publish collections of storage account %s, error: %s", storageAccountName, err)
+		return err
+	}
+
+	err = publishSiteMetadata(ctx, storageAccountName, userid, recipes)
+	if err != nil {
+		log.Printf("Failed to publish sitemap of storage account %s, error: %s", storageAccountName, err)
+		return err
+	}
+
+	_, err = pool.Exec(ctx, "UPDATE users SET site_updated_at = now() WHERE id = $1", userid)
+	if err != nil {
+		log.Printf("Failed to record site update for user %d, error: %s", userid, err)
+		return err
+	}
+
+	return nil
+}
+
+// changedCollections are the collections holding one of the changed
+// recipes. A deleted recipe has left its collections already, all of them
+// are returned then.
+func changedCollections(collections []Collection, recipes []Recipe, changed []int) []Collection {
+	present := map[int]bool{}
+	for _, recipe := range recipes {
+		present[recipe.ID] = true
+	}
+	for _, id := range changed {
+		if !present[id] {
+			return collections
+		}
+	}
+
+	var holding []Collection
+	for _, collection := range collections {
+		if slices.ContainsFunc(collection.RecipeIDs, func(id int) bool { return slices.Contains(changed, id) }) {
+			holding = append(holding, collection)
+		}
+	}
+	return holding
+}
@@ -0,0 +1,486 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"log"
+	"maps"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/jackc/pgx/v5"
+)
+
+const (
+	indexGroupCategory = "category"
+	indexGroupTag      = "tag"
+	indexGroupCuisine  = "cuisine"
+	indexGroupNone     = "none"
+
+	indexSortAdded        = "added"
+	indexSortAlphabetical = "alphabetical"
+	indexSortNewest       = "newest"
+)
+
+const (
+	maxIndexSections     = 50
+	maxSectionTitleRunes = 60
+	maxSectionEmojiRunes = 8
+)
+
+// indexOtherSection is the section key for recipes no other section takes.
+const indexOtherSection = ""
+
+// IndexSettings is how recipes.md is laid out. Sections overrides the emoji
+// and title of a section, keyed by category, season tag or cuisine, the
+// empty key is the Sonstiges section.
+type IndexSettings struct {
+	Grouping string                  `json:"grouping"`
+	Sort     string                  `json:"sort"`
+	Sections map[string]SectionStyle `json:"sections"`
+}
+
+type SectionStyle struct {
+	Emoji string `json:"emoji"`
+	Title string `json:"title"`
+}
+
+var otherSectionStyle = SectionStyle{"🍴", "Sonstiges"}
+
+var seasonalTagOrder = []string{
+	seasonSpring, seasonSummer, seasonAutumn, seasonWinter,
+	holidayChristmas, holidayEaster, holidayHalloween, holidayValentines, holidayNewYear,
+}
+
+func defaultIndexSettings() IndexSettings {
+	return IndexSettings{Grouping: indexGroupCategory, Sort: indexSortAdded, Sections: map[string]SectionStyle{}}
+}
+
+func GetIndexSettings(ctx context.Context, userID int) (IndexSettings, error) {
+	settings := defaultIndexSettings()
+	err := pool.QueryRow(ctx, "SELECT grouping, sort, sections FROM index_settings WHERE user_id = $1", userID).
+		Scan(&settings.Grouping, &settings.Sort, &settings.Sections)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return defaultIndexSettings(), nil
+	}
+	if settings.Sections == nil {
+		settings.Sections = map[string]SectionStyle{}
+	}
+	return settings, err
+}
+
+func HandleGetIndexSettings(w http.ResponseWriter, r *http.Request) {
+	userCtx, ok := r.Context().Value("user").(UserContext)
+	if !ok {
+		http.Error(w, "Unauthorized: user context missing", http.StatusUnauthorized)
+		return
+	}
+
+	settings, err := GetIndexSettings(r.Context(), userCtx.UserID)
+	if err != nil {
+		log.Printf("Error getting index settings: %v\n", err)
+		http.Error(w, "Error getting index settings", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+
+	err = json.NewEncoder(w).Encode(settings)
+	if err != nil {
+		http.Error(w, "Error encoding JSON response", http.StatusInternalServerError)
+	}
+}
+
+// HandleUpdateIndexSettings stores the layout and templates recipes.md again.
+func HandleUpdateIndexSettings(w http.ResponseWriter, r *http.Request) {
+	userCtx, ok := r.Context().Value("user").(UserContext)
+	if !ok {
+		http.Error(w, "Unauthorized: user context missing", http.StatusUnauthorized)
+		return
+	}
+
+	settings := defaultIndexSettings()
+	if err := json.NewDecoder(r.Body).Decode(&settings); err != nil {
+		http.Error(w, "Invalid JSON payload", http.StatusBadRequest)
+		return
+	}
+	if message := validateIndexSettings(&settings); message != "" {
+		http.Error(w, message, http.StatusBadRequest)
+		return
+	}
+
+	_, err := pool.Exec(r.Context(), `
+		INSERT INTO index_settings (user_id, grouping, sort, sections) VALUES ($1, $2, $3, $4)
+		ON CONFLICT (user_id) DO UPDATE SET grouping = $2, sort = $3, sections = $4`,
+		userCtx.UserID, settings.Grouping, settings.Sort, settings.Sections)
+	if err != nil {
+		log.Printf("Error updating index settings: %v\n", err)
+		http.Error(w, "Error updating index settings", http.StatusInternalServerError)
+		return
+	}
+
+	if settings.Grouping == indexGroupCuisine {
+		if err := classifyCuisines(r.Context(), userCtx.UserID); err != nil {
+			log.Printf("Error classifying cuisines for user %d: %v\n", userCtx.UserID, err)
+		}
+	}
+
+	if err := templateRecipesBlob(userCtx.Subdomain, userCtx.UserID); err != nil {
+		log.Printf("Error updating recipe template: %v\n", err)
+		http.Error(w, "Failed to update recipe template", http.StatusInternalServerError)
+		return
+	}
+
+	w.WriteHeader(http.StatusOK)
+}
+
+// validateIndexSettings fills in defaults and returns why the settings are
+// rejected, empty when they are fine. Section texts end up in the markdown,
+// so line breaks are not allowed.
+func validateIndexSettings(settings *IndexSettings) string {
+	if settings.Grouping == "" {
+		settings.Grouping = indexGroupCategory
+	}
+	if settings.Sort == "" {
+		settings.Sort = indexSortAdded
+	}
+	if settings.Sections == nil {
+		settings.Sections = map[string]SectionStyle{}
+	}
+
+	switch settings.Grouping {
+	case indexGroupCategory, indexGroupTag, indexGroupCuisine, indexGroupNone:
+	default:
+		return "grouping must be one of category, tag, cuisine, none"
+	}
+	switch settings.Sort {
+	case indexSortAdded, indexSortAlphabetical, indexSortNewest:
+	default:
+		return "sort must be one of added, alphabetical, newest"
+	}
+
+	if len(settings.Sections) > maxIndexSections {
+		return "Too many sections"
+	}
+	for key, style := range settings.Sections {
+		style.Emoji = strings.TrimSpace(style.Emoji)
+		style.Title = strings.TrimSpace(style.Title)
+		if strings.ContainsAny(key+style.Emoji+style.Title, "\r\n") {
+			return "Section titles must be a single line"
+		}
+		if utf8.RuneCountInString(style.Title) > maxSectionTitleRunes || utf8.RuneCountInString(style.Emoji) > maxSectionEmojiRunes {
+			return "Section title or emoji too long"
+		}
+		settings.Sections[key] = style
+	}
+	return ""
+}
+
+type indexSection struct {
+	key   string
+	style SectionStyle
+	// keepEmpty lists the section without recipes
+	keepEmpty bool
+}
+
+// indexLinks are the rendered link lists of the index, by section key. They
+// are cached with the index rows, a change renders only the lists of the
+// sections it touches again.
+type indexLinks struct {
+	Featured string            `json:"featured"`
+	Sections map[string]string `json:"sections"`
+}
+
+// indexChange is the render a change starts from: the rows and links of the
+// last render and the recipes changed since.
+type indexChange struct {
+	recipes []Recipe
+	links   indexLinks
+	changed []int
+}
+
+// renderIndexSections lays out the recipe links of the published index. The
+// category grouping lists the user's categories, see GetCategories, with
+// the labels of their language. The default settings and categories render
+// the index as it always looked. With a change only the sections its
+// recipes are or were in are rendered again, the rest is taken from the
+// last render.
+func renderIndexSections(recipes []Recipe, settings IndexSettings, categories []Category, language string, change *indexChange) (string, indexLinks) {
+	listed := listedIndexRecipes(recipes, settings)
+	sections, keys := indexLayoutSections(listed, settings, categories, language)
+
+	var links indexLinks
+	var only map[string]bool
+	featured := true
+	if change != nil {
+		links = indexLinks{Featured: change.links.Featured, Sections: maps.Clone(change.links.Sections)}
+		only, featured = touchedIndexSections(change, recipes, keys)
+	}
+	if links.Sections == nil {
+		links.Sections = map[string]string{}
+	}
+	renderIndexLinks(&links, listed, keys, only, featured)
+
+	var parts []string
+	if links.Featured != "" {
+		parts = append(parts, "⭐ Favoriten\n"+links.Featured)
+	}
+	for _, section := range sections {
+		list := links.Sections[section.key]
+		if list == "" && !section.keepEmpty {
+			continue
+		}
+		header := strings.TrimSpace(section.style.Emoji + " " + section.style.Title)
+		if header != "" {
+			header += "\n"
+		}
+		parts = append(parts, header+list)
+	}
+	return strings.Join(parts, "\n"), links
+}
+
+// listedIndexRecipes are the recipes the index links to, in its order.
+func listedIndexRecipes(recipes []Recipe, settings IndexSettings) []Recipe {
+	var listed []Recipe
+	linked := map[string]int{}
+	for _, recipe := range recipes {
+		// translations are reached through the language switcher of the original
+		if recipe.TranslationOf != 0 || recipe.Archived || recipe.Draft {
+			continue
+		}
+		// a link has to lead to exactly one recipe
+		if other, taken := linked[recipe.Slug]; taken || recipe.Slug == "" {
+			log.Printf("Leaving recipe %d out of the index, its slug %q is empty or taken by recipe %d\n", recipe.ID, recipe.Slug, other)
+			continue
+		}
+		linked[recipe.Slug] = recipe.ID
+		listed = append(listed, recipe)
+	}
+	return featuredFirst(sortIndexRecipes(listed, settings.Sort))
+}
+
+// indexLayoutSections returns the sections of the grouping in their order,
+// and the keys of the sections a recipe is listed in.
+func indexLayoutSections(listed []Recipe, settings IndexSettings, categories []Category, language string) ([]indexSection, func(Recipe) []string) {
+	var sections []indexSection
+	known := map[string]bool{}
+	section := func(key string, style SectionStyle, keepEmpty bool) {
+		if known[key] {
+			return
+		}
+		if custom, found := settings.Sections[key]; found {
+			style = custom
+		}
+		known[key] = true
+		sections = append(sections, indexSection{key: key, style: style, keepEmpty: keepEmpty})
+	}
+	other := []string{indexOtherSection}
+
+	switch settings.Grouping {
+	case indexGroupCategory:
+		for _, category := range categories {
+			section(category.Name, SectionStyle{category.Emoji, category.label(language)}, true)
+		}
+		section(indexOtherSection, otherSectionStyle, true)
+		return sections, func(recipe Recipe) []string {
+			if !known[recipe.Category] {
+				return other
+			}
+			return []string{recipe.Category}
+		}
+	case indexGroupTag:
+		for _, tag := range seasonalTagOrder {
+			emoji, title, _ := strings.Cut(seasonalTagLabels[tag], " ")
+			section(tag, SectionStyle{emoji, title}, false)
+		}
+		section(indexOtherSection, otherSectionStyle, false)
+		return sections, func(recipe Recipe) []string {
+			var keys []string
+			for _, tag := range recipe.SeasonTags {
+				if known[tag] && tag != indexOtherSection {
+					keys = append(keys, tag)
+				}
+			}
+			if len(keys) == 0 {
+				return other
+			}
+			return keys
+		}
+	case indexGroupCuisine:
+		cuisines := map[string]bool{}
+		for _, recipe := range listed {
+			if recipe.Cuisine != "" {
+				cuisines[recipe.Cuisine] = true
+			}
+		}
+		names := make([]string, 0, len(cuisines))
+		for name := range cuisines {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+		for _, name := range names {
+			section(name, SectionStyle{"🌍", name}, false)
+		}
+		section(indexOtherSection, otherSectionStyle, false)
+		return sections, func(recipe Recipe) []string {
+			return []string{recipe.Cuisine}
+		}
+	default:
+		// one list, headed only when the user gave it a title
+		section(indexOtherSection, SectionStyle{}, true)
+		return sections, func(Recipe) []string {
+			return other
+		}
+	}
+}
+
+// touchedIndexSections are the sections a change renders again: those the
+// changed recipes are or were in, and those of recipes whose title or link
+// depends on them, sharing a title or a slug. featured is set when the
+// favourites are among them.
+func touchedIndexSections(change *indexChange, recipes []Recipe, keys func(Recipe) []string) (map[string]bool, bool) {
+	changed := map[int]bool{}
+	for _, id := range change.changed {
+		changed[id] = true
+	}
+
+	touched, featured := map[string]bool{}, false
+	titles, slugs := map[string]bool{}, map[string]bool{}
+	touch := func(recipe Recipe) {
+		for _, key := range keys(recipe) {
+			touched[key] = true
+		}
+		featured = featured || recipe.FeaturedAt != nil
+	}
+	for _, rows := range [][]Recipe{change.recipes, recipes} {
+		for _, recipe := range rows {
+			if changed[recipe.ID] {
+				slugs[recipe.Slug] = true
+			}
+		}
+	}
+	// a recipe sharing a slug may come into or drop out of the index, which
+	// changes the titles of the recipes sharing its title
+	for _, rows := range [][]Recipe{change.recipes, recipes} {
+		for _, recipe := range rows {
+			if changed[recipe.ID] || slugs[recipe.Slug] {
+				touch(recipe)
+				titles[slugify(recipe.Recipename)] = true
+			}
+		}
+	}
+	for _, recipe := range recipes {
+		if titles[slugify(recipe.Recipename)] {
+			touch(recipe)
+		}
+	}
+	return touched, featured
+}
+
+// renderIndexLinks renders the link lists of the sections in only, all of
+// them when only is nil, and the favourites when featured is set.
+func renderIndexLinks(links *indexLinks, listed []Recipe, keys func(Recipe) []string, only map[string]bool, featured bool) {
+	titles := indexTitles(listed)
+	link := func(recipe Recipe) string {
+		// relative, so the index also works below a shared-mode prefix
+		return "- [" + titles[recipe.ID] + "](./?recipe=" + recipe.Slug + ")\n"
+	}
+
+	if only == nil {
+		clear(links.Sections)
+	}
+	for key := range only {
+		delete(links.Sections, key)
+	}
+	if featured {
+		links.Featured = ""
+	}
+	for _, recipe := range listed {
+		if featured && recipe.FeaturedAt != nil {
+			links.Featured += link(recipe)
+		}
+		for _, key := range keys(recipe) {
+			if only == nil || only[key] {
+				links.Sections[key] += link(recipe)
+			}
+		}
+	}
+}
+
+// indexTitles tells recipes of the same title apart in the index, by
+// category when they differ, else by the day they were added and last by
+// a number in the order they were added. Titles count as the same when
+// their slugs would be.
+func indexTitles(recipes []Recipe) map[int]string {
+	titles := make(map[int]string, len(recipes))
+	clashes := map[string][]Recipe{}
+	for _, recipe := range recipes {
+		titles[recipe.ID] = recipe.Recipename
+		key := slugify(recipe.Recipename)
+		clashes[key] = append(clashes[key], recipe)
+	}
+
+	for _, group := range clashes {
+		if len(group) < 2 {
+			continue
+		}
+		sort.Slice(group, func(i, j int) bool { return group[i].ID < group[j].ID })
+
+		suffix := func(recipe Recipe) string { return recipe.Category }
+		if !distinct(group, suffix) {
+			suffix = func(recipe Recipe) string {
+				if recipe.CreatedAt == nil {
+					return ""
+				}
+				return recipe.CreatedAt.Format("02.01.2006")
+			}
+		}
+		if !distinct(group, suffix) {
+			suffix = nil
+		}
+		for i, recipe := range group {
+			label := strconv.Itoa(i + 1)
+			if suffix != nil {
+				label = suffix(recipe)
+			}
+			titles[recipe.ID] = recipe.Recipename + " (" + label + ")"
+		}
+	}
+	return titles
+}
+
+// distinct reports whether label gives every recipe its own non-empty
+// label.
+func distinct(recipes []Recipe, label func(Recipe) string) bool {
+	seen := map[string]bool{}
+	for _, recipe := range recipes {
+		value := label(recipe)
+		if value == "" || seen[value] {
+			return false
+		}
+		seen[value] = true
+	}
+	return true
+}
+
+func sortIndexRecipes(recipes []Recipe, order string) []Recipe {
+	sorted := append([]Recipe(nil), recipes...)
+	sort.SliceStable(sorted, func(i, j int) bool {
+		switch order {
+		case indexSortAlphabetical:
+			a, b := strings.ToLower(sorted[i].Recipename), strings.ToLower(sorted[j].Recipename)
+			if a != b {
+				return a < b
+			}
+		case indexSortNewest:
+			return sorted[i].ID > sorted[j].ID
+		}
+		return sorted[i].ID < sorted[j].ID
+	})
+	return sorted
+}
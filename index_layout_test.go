@@ -0,0 +1,107 @@
+package main
+
+import (
+	"io"
+	"log"
+	"math/rand/v2"
+	"os"
+	"strconv"
+	"testing"
+	"time"
+)
+
+func TestIndexChangeMatchesFullRender(t *testing.T) {
+	// taken slugs are logged on every render
+	log.SetOutput(io.Discard)
+	t.Cleanup(func() { log.SetOutput(os.Stderr) })
+
+	random := rand.New(rand.NewPCG(1, 2))
+	categories := []Category{{Name: "Dessert", Emoji: "🍰"}, {Name: "Hauptgericht", Emoji: "🍝"}}
+	titles := []string{"Pfannkuchen", "Focaccia", "Spaghetti Carbonara", "Pfannkuchen!", "Tiramisu"}
+	names := []string{"Dessert", "Hauptgericht", "Brot", ""}
+	cuisines := []string{"", "Italienisch", "Deutsch"}
+	tags := [][]string{nil, {seasonSummer}, {seasonWinter, holidayChristmas}}
+
+	nextID := 0
+	row := func(id int) Recipe {
+		created := time.Date(2026, 1, 1+random.IntN(3), 0, 0, 0, 0, time.UTC)
+		recipe := Recipe{
+			ID:         id,
+			Recipename: titles[random.IntN(len(titles))],
+			Category:   names[random.IntN(len(names))],
+			Cuisine:    cuisines[random.IntN(len(cuisines))],
+			SeasonTags: tags[random.IntN(len(tags))],
+			Slug:       "rezept-" + strconv.Itoa(random.IntN(12)),
+			Archived:   random.IntN(8) == 0,
+			CreatedAt:  &created,
+		}
+		if random.IntN(5) == 0 {
+			featured := created.Add(time.Duration(random.IntN(100)) * time.Hour)
+			recipe.FeaturedAt = &featured
+		}
+		return recipe
+	}
+	var recipes []Recipe
+	for range 20 {
+		nextID++
+		recipes = append(recipes, row(nextID))
+	}
+
+	for _, grouping := range []string{indexGroupCategory, indexGroupTag, indexGroupCuisine, indexGroupNone} {
+		for _, order := range []string{indexSortAdded, indexSortAlphabetical, indexSortNewest} {
+			settings := IndexSettings{Grouping: grouping, Sort: order, Sections: map[string]SectionStyle{}}
+			_, links := renderIndexSections(recipes, settings, categories, "de", nil)
+			current := recipes
+
+			for step := range 30 {
+				var changed []int
+				var next []Recipe
+				pick := current[random.IntN(len(current))].ID
+				switch step % 3 {
+				case 0:
+					for _, recipe := range current {
+						if recipe.ID == pick {
+							recipe = row(recipe.ID)
+						}
+						next = append(next, recipe)
+					}
+				case 1:
+					for _, recipe := range current {
+						if recipe.ID != pick {
+							next = append(next, recipe)
+						}
+					}
+				default:
+					nextID++
+					pick = nextID
+					next = append(append(next, current...), row(nextID))
+				}
+				changed = append(changed, pick)
+
+				want, wantLinks := renderIndexSections(next, settings, categories, "de", nil)
+				got, gotLinks := renderIndexSections(next, settings, categories, "de", &indexChange{recipes: current, links: links, changed: changed})
+				if got != want {
+					t.Fatalf("%s/%s step %d: change rendered\n%s\nwant\n%s", grouping, order, step, got, want)
+				}
+				for key, list := range wantLinks.Sections {
+					if gotLinks.Sections[key] != list {
+						t.Fatalf("%s/%s step %d: links of section %q differ", grouping, order, step, key)
+					}
+				}
+				current, links = next, gotLinks
+			}
+		}
+	}
+}
+
+func TestChangedCollections(t *testing.T) {
+	collections := []Collection{{ID: 1, RecipeIDs: []int{1, 2}}, {ID: 2, RecipeIDs: []int{3}}}
+	recipes := []Recipe{{ID: 1}, {ID: 2}, {ID: 3}}
+
+	if got := changedCollections(collections, recipes, []int{3}); len(got) != 1 || got[0].ID != 2 {
+		t.Errorf("changed recipe 3: got %v", got)
+	}
+	if got := changedCollections(collections, recipes[:2], []int{3}); len(got) != 2 {
+		t.Errorf("deleted recipe 3: got %v, want all collections", got)
+	}
+}
@@ -0,0 +1,382 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"log"
+	"net/http"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+)
+
+// The ingredient dictionary maps the names recipes use to one canonical
+// ingredient with its German and English name and default unit, so
+// "Zwiebeln", "rote Zwiebel" and "onions" end up on the same line of a
+// shopping list. It starts from baseIngredients and grows from what the
+// recipes contain, see populateIngredients.
+const (
+	ingredientsCacheKey = "ingredients"
+	ingredientsCacheTTL = 10 * time.Minute
+	// ingredientBatchSize is how many unknown names are classified per run
+	ingredientBatchSize = 50
+	// ingredientMinRecipes keeps one-off names out of the dictionary
+	ingredientMinRecipes = 2
+)
+
+const ingredientSystemMessage = "You canonicalize recipe ingredient names. For every name in the list answer with the plain " +
+	"ingredient it names, without amounts, preparation or brand, in German and English singular, and the unit it is usually " +
+	"counted in (g, ml, Stück, EL, TL, Bund, Prise or empty). Answer with a JSON object " +
+	"{\"ingredients\": [{\"name\": \"<name from the list>\", \"de\": \"Zwiebel\", \"en\": \"onion\", \"unit\": \"Stück\"}]}. " +
+	"Leave out names that are not an ingredient."
+
+type Ingredient struct {
+	ID          int64    `json:"id"`
+	NameDE      string   `json:"nameDE"`
+	NameEN      string   `json:"nameEN"`
+	DefaultUnit string   `json:"defaultUnit"`
+	Synonyms    []string `json:"synonyms"`
+}
+
+// name is the canonical name in the recipe's language.
+func (i Ingredient) name(isGerman bool) string {
+	if isGerman || i.NameEN == "" {
+		return i.NameDE
+	}
+	return i.NameEN
+}
+
+// baseIngredients are added on the first run, synonyms are lower case.
+var baseIngredients = []Ingredient{
+	{NameDE: "Mehl", NameEN: "flour", DefaultUnit: "g", Synonyms: []string{"weizenmehl", "wheat flour", "all-purpose flour"}},
+	{NameDE: "Zucker", NameEN: "sugar", DefaultUnit: "g", Synonyms: []string{"weißer zucker", "kristallzucker", "white sugar"}},
+	{NameDE: "Salz", NameEN: "salt", DefaultUnit: "Prise", Synonyms: []string{"meersalz", "sea salt"}},
+	{NameDE: "Pfeffer", NameEN: "pepper", DefaultUnit: "Prise", Synonyms: []string{"schwarzer pfeffer", "black pepper"}},
+	{NameDE: "Butter", NameEN: "butter", DefaultUnit: "g"},
+	{NameDE: "Ei", NameEN: "egg", DefaultUnit: "Stück", Synonyms: []string{"eier", "eggs"}},
+	{NameDE: "Milch", NameEN: "milk", DefaultUnit: "ml", Synonyms: []string{"vollmilch", "whole milk"}},
+	{NameDE: "Sahne", NameEN: "cream", DefaultUnit: "ml", Synonyms: []string{"schlagsahne", "heavy cream", "whipping cream"}},
+	{NameDE: "Olivenöl", NameEN: "olive oil", DefaultUnit: "EL", Synonyms: []string{"natives olivenöl", "extra virgin olive oil"}},
+	{NameDE: "Öl", NameEN: "oil", DefaultUnit: "EL", Synonyms: []string{"pflanzenöl", "rapsöl", "vegetable oil"}},
+	{NameDE: "Zwiebel", NameEN: "onion", DefaultUnit: "Stück", Synonyms: []string{"zwiebeln", "onions"}},
+	{NameDE: "Knoblauch", NameEN: "garlic", DefaultUnit: "Zehe", Synonyms: []string{"knoblauchzehe", "knoblauchzehen", "garlic clove", "garlic cloves"}},
+	{NameDE: "Tomate", NameEN: "tomato", DefaultUnit: "Stück", Synonyms: []string{"tomaten", "tomatoes"}},
+	{NameDE: "Kartoffel", NameEN: "potato", DefaultUnit: "g", Synonyms: []string{"kartoffeln", "potatoes"}},
+	{NameDE: "Karotte", NameEN: "carrot", DefaultUnit: "Stück", Synonyms: []string{"karotten", "möhre", "möhren", "carrots"}},
+	{NameDE: "Paprika", NameEN: "bell pepper", DefaultUnit: "Stück", Synonyms: []string{"paprikaschote", "paprikaschoten", "bell peppers"}},
+	{NameDE: "Zitrone", NameEN: "lemon", DefaultUnit: "Stück", Synonyms: []string{"zitronen", "lemons"}},
+	{NameDE: "Apfel", NameEN: "apple", DefaultUnit: "Stück", Synonyms: []string{"äpfel", "apples"}},
+	{NameDE: "Reis", NameEN: "rice", DefaultUnit: "g"},
+	{NameDE: "Nudeln", NameEN: "pasta", DefaultUnit: "g", Synonyms: []string{"pasta", "spaghetti", "noodles"}},
+	{NameDE: "Hackfleisch", NameEN: "ground meat", DefaultUnit: "g", Synonyms: []string{"gehacktes", "minced meat", "ground beef"}},
+	{NameDE: "Hähnchenbrust", NameEN: "chicken breast", DefaultUnit: "g", Synonyms: []string{"hühnerbrust", "hähnchenbrustfilet", "chicken breasts"}},
+	{NameDE: "Speck", NameEN: "bacon", DefaultUnit: "g", Synonyms: []string{"bacon", "schinkenspeck"}},
+	{NameDE: "Käse", NameEN: "cheese", DefaultUnit: "g", Synonyms: []string{"geriebener käse", "grated cheese"}},
+	{NameDE: "Parmesan", NameEN: "parmesan", DefaultUnit: "g", Synonyms: []string{"parmigiano", "parmigiano reggiano"}},
+	{NameDE: "Joghurt", NameEN: "yogurt", DefaultUnit: "g", Synonyms: []string{"naturjoghurt", "plain yogurt", "yoghurt"}},
+	{NameDE: "Quark", NameEN: "quark", DefaultUnit: "g", Synonyms: []string{"magerquark"}},
+	{NameDE: "Petersilie", NameEN: "parsley", DefaultUnit: "Bund", Synonyms: []string{"glatte petersilie", "flat-leaf parsley"}},
+	{NameDE: "Schnittlauch", NameEN: "chives", DefaultUnit: "Bund"},
+	{NameDE: "Basilikum", NameEN: "basil", DefaultUnit: "Bund"},
+	{NameDE: "Backpulver", NameEN: "baking powder", DefaultUnit: "TL"},
+	{NameDE: "Hefe", NameEN: "yeast", DefaultUnit: "g", Synonyms: []string{"trockenhefe", "frischhefe", "dry yeast"}},
+	{NameDE: "Gemüsebrühe", NameEN: "vegetable stock", DefaultUnit: "ml", Synonyms: []string{"brühe", "vegetable broth", "stock"}},
+	{NameDE: "Wasser", NameEN: "water", DefaultUnit: "ml"},
+	{NameDE: "Honig", NameEN: "honey", DefaultUnit: "EL"},
+	{NameDE: "Senf", NameEN: "mustard", DefaultUnit: "TL"},
+	{NameDE: "Essig", NameEN: "vinegar", DefaultUnit: "EL"},
+	{NameDE: "Sojasauce", NameEN: "soy sauce", DefaultUnit: "EL", Synonyms: []string{"sojasoße"}},
+	{NameDE: "Zimt", NameEN: "cinnamon", DefaultUnit: "TL"},
+	{NameDE: "Vanillezucker", NameEN: "vanilla sugar", DefaultUnit: "Päckchen"},
+}
+
+// ingredientDictionary looks up canonical ingredients by lower cased names,
+// synonyms included.
+type ingredientDictionary struct {
+	ingredients []Ingredient
+	byName      map[string]int
+}
+
+func newIngredientDictionary(ingredients []Ingredient) ingredientDictionary {
+	dictionary := ingredientDictionary{ingredients: ingredients, byName: map[string]int{}}
+	for i, ingredient := range ingredients {
+		names := append([]string{ingredient.NameDE, ingredient.NameEN}, ingredient.Synonyms...)
+		for _, name := range names {
+			if name = strings.ToLower(strings.TrimSpace(name)); name != "" {
+				if _, taken := dictionary.byName[name]; !taken {
+					dictionary.byName[name] = i
+				}
+			}
+		}
+	}
+	return dictionary
+}
+
+// lookup tries the name without notes, then its last word for "rote
+// Zwiebel", each also without a plural ending.
+func (d ingredientDictionary) lookup(name string) (Ingredient, bool) {
+	key := strings.ToLower(ingredientKey(name))
+	if key == "" {
+		return Ingredient{}, false
+	}
+	candidates := []string{key}
+	if fields := strings.Fields(key); len(fields) > 1 {
+		candidates = append(candidates, fields[len(fields)-1])
+	}
+	for _, candidate := range candidates {
+		for _, form := range singularForms(candidate) {
+			if i, found := d.byName[form]; found {
+				return d.ingredients[i], true
+			}
+		}
+	}
+	return Ingredient{}, false
+}
+
+// singularForms are the word as it is and with common German and English
+// plural endings taken off.
+func singularForms(word string) []string {
+	forms := []string{word}
+	for _, suffix := range []string{"es", "en", "s", "n"} {
+		if stem, found := strings.CutSuffix(word, suffix); found && len([]rune(stem)) >= 3 {
+			forms = append(forms, stem)
+		}
+	}
+	return forms
+}
+
+// loadIngredientDictionary returns the dictionary cached for ten minutes,
+// without a database or when it can't be read it is built from
+// baseIngredients.
+func loadIngredientDictionary(ctx context.Context) ingredientDictionary {
+	var ingredients []Ingredient
+	if appCache.Get(ctx, ingredientsCacheKey, &ingredients) {
+		return newIngredientDictionary(ingredients)
+	}
+	if pool == nil {
+		return newIngredientDictionary(baseIngredients)
+	}
+
+	ingredients, err := queryIngredients(ctx, "")
+	if err != nil {
+		log.Printf("Error loading the ingredient dictionary: %v\n", err)
+		return newIngredientDictionary(baseIngredients)
+	}
+	appCache.Set(ctx, ingredientsCacheKey, ingredients, ingredientsCacheTTL)
+	return newIngredientDictionary(ingredients)
+}
+
+func queryIngredients(ctx context.Context, search string) ([]Ingredient, error) {
+	rows, err := pool.Query(ctx, `
+		SELECT id, name_de, name_en, default_unit, synonyms FROM ingredients
+		WHERE $1::text = '' OR name_de ILIKE '%' || $1 || '%' OR name_en ILIKE '%' || $1 || '%' OR lower($1) = ANY(synonyms)
+		ORDER BY name_de`, search)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	ingredients := []Ingredient{}
+	for rows.Next() {
+		var ingredient Ingredient
+		if err := rows.Scan(&ingredient.ID, &ingredient.NameDE, &ingredient.NameEN, &ingredient.DefaultUnit, &ingredient.Synonyms); err != nil {
+			return nil, err
+		}
+		ingredients = append(ingredients, ingredient)
+	}
+	return ingredients, rows.Err()
+}
+
+// normalizeIngredients fills in the canonical name of every ingredient the
+// dictionary knows.
+func normalizeIngredients(dictionary ingredientDictionary, ingredients []ParsedIngredient, isGerman bool) []ParsedIngredient {
+	for i := range ingredients {
+		if ingredient, found := dictionary.lookup(ingredients[i].Name); found {
+			ingredients[i].Canonical = ingredient.name(isGerman)
+		}
+	}
+	return ingredients
+}
+
+// canonicalIngredientName is the canonical name when there is one and the
+// name without notes otherwise.
+func canonicalIngredientName(dictionary ingredientDictionary, name string, isGerman bool) string {
+	if ingredient, found := dictionary.lookup(name); found {
+		return ingredient.name(isGerman)
+	}
+	return ingredientKey(name)
+}
+
+// populateIngredients adds baseIngredients once and then has the model
+// canonicalize names that appear in several recipes but are not in the
+// dictionary yet. Names of an existing ingredient become its synonyms.
+// It runs as a scheduled job.
+func populateIngredients(ctx context.Context) error {
+	for _, ingredient := range baseIngredients {
+		_, err := pool.Exec(ctx, `
+			INSERT INTO ingredients (name_de, name_en, default_unit, synonyms) VALUES ($1, $2, $3, $4) ON CONFLICT DO NOTHING`,
+			ingredient.NameDE, ingredient.NameEN, ingredient.DefaultUnit, ingredient.Synonyms)
+		if err != nil {
+			return err
+		}
+	}
+	appCache.Delete(ctx, ingredientsCacheKey)
+
+	unknown, err := unknownIngredientNames(ctx)
+	if err != nil || len(unknown) == 0 {
+		return err
+	}
+
+	var answer struct {
+		Ingredients []struct {
+			Name string `json:"name"`
+			DE   string `json:"de"`
+			EN   string `json:"en"`
+			Unit string `json:"unit"`
+		} `json:"ingredients"`
+	}
+	err = completeJSON(ctx, llmRequest{
+		Task:   llmTaskIngredients,
+		System: ingredientSystemMessage,
+		Prompt: strings.Join(unknown, "\n"),
+	}, &answer)
+	if err != nil {
+		return err
+	}
+
+	for _, entry := range answer.Ingredients {
+		name := strings.ToLower(strings.TrimSpace(entry.Name))
+		nameDE, nameEN := strings.TrimSpace(entry.DE), strings.ToLower(strings.TrimSpace(entry.EN))
+		if name == "" || nameDE == "" || !slices.Contains(unknown, name) {
+			continue
+		}
+		_, err := pool.Exec(ctx, `
+			INSERT INTO ingredients (name_de, name_en, default_unit, synonyms) VALUES ($1, $2, $3, ARRAY[$4::text])
+			ON CONFLICT ((lower(name_de))) DO UPDATE SET synonyms = array_append(ingredients.synonyms, $4::text)
+			WHERE NOT $4::text = ANY(ingredients.synonyms)`,
+			nameDE, nameEN, strings.TrimSpace(entry.Unit), name)
+		if err != nil {
+			return err
+		}
+	}
+	appCache.Delete(ctx, ingredientsCacheKey)
+	return nil
+}
+
+// unknownIngredientNames are the most used lower cased names the dictionary
+// has no entry for.
+func unknownIngredientNames(ctx context.Context) ([]string, error) {
+	dictionary := loadIngredientDictionary(ctx)
+
+	rows, err := pool.Query(ctx, "SELECT content FROM recipes WHERE translation_of IS NULL AND NOT archived")
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	counts := map[string]int{}
+	for rows.Next() {
+		var content string
+		if err := rows.Scan(&content); err != nil {
+			return nil, err
+		}
+		seen := map[string]bool{}
+		for _, ingredient := range parseRecipeMarkdown(content).Ingredients {
+			name := strings.ToLower(ingredientKey(ingredient.Name))
+			if name == "" || seen[name] {
+				continue
+			}
+			seen[name] = true
+			if _, found := dictionary.lookup(name); !found {
+				counts[name]++
+			}
+		}
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	var names []string
+	for _, entry := range sortedCounts(counts, ingredientBatchSize) {
+		if entry.Count >= ingredientMinRecipes {
+			names = append(names, entry.Name)
+		}
+	}
+	return names, nil
+}
+
+// HandleListIngredients lists the dictionary, q searches names and
+// synonyms.
+func HandleListIngredients(w http.ResponseWriter, r *http.Request) {
+	ingredients, err := queryIngredients(r.Context(), strings.TrimSpace(r.URL.Query().Get("q")))
+	if err != nil {
+		log.Printf("Error listing ingredients: %v\n", err)
+		http.Error(w, "Error listing ingredients", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+
+	err = json.NewEncoder(w).Encode(ingredients)
+	if err != nil {
+		http.Error(w, "Error encoding JSON response", http.StatusInternalServerError)
+	}
+}
+
+// HandleUpdateIngredient corrects an entry of the dictionary, synonyms are
+// replaced as a whole.
+func HandleUpdateIngredient(w http.ResponseWriter, r *http.Request) {
+	id, err := strconv.ParseInt(r.PathValue("id"), 10, 64)
+	if err != nil {
+		http.Error(w, "Invalid ingredient ID", http.StatusBadRequest)
+		return
+	}
+
+	var ingredient Ingredient
+	if err := json.NewDecoder(r.Body).Decode(&ingredient); err != nil {
+		http.Error(w, "Invalid JSON payload", http.StatusBadRequest)
+		return
+	}
+	ingredient.ID = id
+	ingredient.NameDE = strings.TrimSpace(ingredient.NameDE)
+	ingredient.NameEN = strings.TrimSpace(ingredient.NameEN)
+	ingredient.DefaultUnit = strings.TrimSpace(ingredient.DefaultUnit)
+	if ingredient.NameDE == "" {
+		http.Error(w, "Missing nameDE", http.StatusBadRequest)
+		return
+	}
+	synonyms := []string{}
+	for _, synonym := range ingredient.Synonyms {
+		if synonym = strings.ToLower(strings.TrimSpace(synonym)); synonym != "" && !slices.Contains(synonyms, synonym) {
+			synonyms = append(synonyms, synonym)
+		}
+	}
+	ingredient.Synonyms = synonyms
+
+	err = pool.QueryRow(r.Context(), `
+		UPDATE ingredients SET name_de = $2, name_en = $3, default_unit = $4, synonyms = $5 WHERE id = $1 RETURNING id`,
+		id, ingredient.NameDE, ingredient.NameEN, ingredient.DefaultUnit, ingredient.Synonyms).Scan(&ingredient.ID)
+	if errors.Is(err, pgx.ErrNoRows) {
+		http.Error(w, "Ingredient not found", http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		log.Printf("Error updating ingredient %d: %v\n", id, err)
+		http.Error(w, "Error updating ingredient", http.StatusInternalServerError)
+		return
+	}
+	appCache.Delete(r.Context(), ingredientsCacheKey)
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+
+	err = json.NewEncoder(w).Encode(ingredient)
+	if err != nil {
+		http.Error(w, "Error encoding JSON response", http.StatusInternalServerError)
+	}
+}
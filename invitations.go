@@ -0,0 +1,195 @@
+package main
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"log"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+)
+
+// SIGNUP_MODE=invite closes sign-up: a new oauth ID is only registered, and
+// gets storage provisioned, when it is allowlisted or brings an unused
+// invitation code. Existing users are not affected.
+const (
+	signupOpen   = "open"
+	signupInvite = "invite"
+)
+
+// inviteCodeHeader carries the invitation code on the first login.
+const inviteCodeHeader = "X-Invite-Code"
+
+var errNotInvited = errors.New("an invitation is required to sign up")
+
+// Invitation either allowlists an oauth ID or holds a code that the first
+// user to log in with it redeems.
+type Invitation struct {
+	ID         int64      `json:"id"`
+	Code       string     `json:"code,omitempty"`
+	OauthID    string     `json:"oauthID,omitempty"`
+	Note       string     `json:"note,omitempty"`
+	CreatedAt  time.Time  `json:"createdAt"`
+	ExpiresAt  *time.Time `json:"expiresAt,omitempty"`
+	RedeemedBy *int       `json:"redeemedBy,omitempty"`
+	RedeemedAt *time.Time `json:"redeemedAt,omitempty"`
+}
+
+func configuredSignupMode() string {
+	if os.Getenv("SIGNUP_MODE") == signupInvite {
+		return signupInvite
+	}
+	return signupOpen
+}
+
+// redeemInvitation claims the invitation of a user that is registered in tx,
+// errNotInvited rolls the registration back. The claim locks the row, two
+// logins can't redeem the same code.
+func redeemInvitation(ctx context.Context, tx pgx.Tx, userID int, oauthID string, code string) error {
+	if configuredSignupMode() != signupInvite {
+		return nil
+	}
+
+	var id int64
+	err := tx.QueryRow(ctx, `
+		UPDATE invitations SET redeemed_by = $1, redeemed_at = now()
+		WHERE id = (
+			SELECT id FROM invitations
+			WHERE (oauth_id = $2 OR ($3 <> '' AND code = $3)) AND redeemed_at IS NULL AND (expires_at IS NULL OR expires_at > now())
+			ORDER BY oauth_id NULLS LAST LIMIT 1 FOR UPDATE
+		) RETURNING id`,
+		userID, oauthID, strings.TrimSpace(code)).Scan(&id)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return errNotInvited
+	}
+	if err != nil {
+		return err
+	}
+	log.Printf("user %d redeemed invitation %d", userID, id)
+	return nil
+}
+
+// HandleAddInvitation allowlists oauthID or, without one, creates a code to
+// hand out. expiresInDays limits how long it can be redeemed.
+func HandleAddInvitation(w http.ResponseWriter, r *http.Request) {
+	userCtx, ok := r.Context().Value("user").(UserContext)
+	if !ok {
+		http.Error(w, "Unauthorized: user context missing", http.StatusUnauthorized)
+		return
+	}
+
+	var req struct {
+		OauthID       string `json:"oauthID"`
+		Note          string `json:"note"`
+		ExpiresInDays int    `json:"expiresInDays"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "Invalid JSON payload", http.StatusBadRequest)
+		return
+	}
+	if req.ExpiresInDays < 0 {
+		http.Error(w, "expiresInDays must not be negative", http.StatusBadRequest)
+		return
+	}
+
+	invitation := Invitation{OauthID: strings.TrimSpace(req.OauthID), Note: strings.TrimSpace(req.Note)}
+	if invitation.OauthID == "" {
+		random := make([]byte, 8)
+		if _, err := rand.Read(random); err != nil {
+			log.Printf("Error generating invitation code: %v\n", err)
+			http.Error(w, "Error adding invitation", http.StatusInternalServerError)
+			return
+		}
+		invitation.Code = hex.EncodeToString(random)
+	}
+	if req.ExpiresInDays > 0 {
+		expiresAt := time.Now().AddDate(0, 0, req.ExpiresInDays)
+		invitation.ExpiresAt = &expiresAt
+	}
+
+	err := pool.QueryRow(r.Context(), `
+		INSERT INTO invitations (code, oauth_id, note, created_by, expires_at) VALUES (NULLIF($1, ''), NULLIF($2, ''), $3, $4, $5)
+		RETURNING id, created_at`,
+		invitation.Code, invitation.OauthID, invitation.Note, userCtx.UserID, invitation.ExpiresAt).Scan(&invitation.ID, &invitation.CreatedAt)
+	if err != nil {
+		log.Printf("Error adding invitation: %v\n", err)
+		http.Error(w, "Error adding invitation", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+
+	err = json.NewEncoder(w).Encode(invitation)
+	if err != nil {
+		http.Error(w, "Error encoding JSON response", http.StatusInternalServerError)
+	}
+}
+
+func HandleListInvitations(w http.ResponseWriter, r *http.Request) {
+	rows, err := pool.Query(r.Context(), `
+		SELECT id, coalesce(code, ''), coalesce(oauth_id, ''), note, created_at, expires_at, redeemed_by, redeemed_at
+		FROM invitations ORDER BY id DESC`)
+	if err != nil {
+		log.Printf("Error listing invitations: %v\n", err)
+		http.Error(w, "Error listing invitations", http.StatusInternalServerError)
+		return
+	}
+	defer rows.Close()
+
+	invitations := []Invitation{}
+	for rows.Next() {
+		var invitation Invitation
+		err := rows.Scan(&invitation.ID, &invitation.Code, &invitation.OauthID, &invitation.Note, &invitation.CreatedAt,
+			&invitation.ExpiresAt, &invitation.RedeemedBy, &invitation.RedeemedAt)
+		if err != nil {
+			log.Printf("Error scanning invitation: %v\n", err)
+			http.Error(w, "Error listing invitations", http.StatusInternalServerError)
+			return
+		}
+		invitations = append(invitations, invitation)
+	}
+	if err := rows.Err(); err != nil {
+		log.Printf("Error listing invitations: %v\n", err)
+		http.Error(w, "Error listing invitations", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+
+	err = json.NewEncoder(w).Encode(invitations)
+	if err != nil {
+		http.Error(w, "Error encoding JSON response", http.StatusInternalServerError)
+	}
+}
+
+// HandleDeleteInvitation revokes an invitation that was not redeemed yet,
+// users who signed up with it keep their account.
+func HandleDeleteInvitation(w http.ResponseWriter, r *http.Request) {
+	id, err := strconv.ParseInt(r.PathValue("id"), 10, 64)
+	if err != nil {
+		http.Error(w, "Invalid invitation ID", http.StatusBadRequest)
+		return
+	}
+
+	tag, err := pool.Exec(r.Context(), "DELETE FROM invitations WHERE id = $1 AND redeemed_at IS NULL", id)
+	if err != nil {
+		log.Printf("Error deleting invitation %d: %v\n", id, err)
+		http.Error(w, "Error deleting invitation", http.StatusInternalServerError)
+		return
+	}
+	if tag.RowsAffected() == 0 {
+		http.Error(w, "Invitation not found or already redeemed", http.StatusNotFound)
+		return
+	}
+
+	w.WriteHeader(http.StatusOK)
+}
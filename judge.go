@@ -0,0 +1,350 @@
+package main
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"log"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+)
+
+// Modes name the input the judge looked at.
+const (
+	judgeModeDescription = "description"
+	judgeModeVoice       = "voice"
+	judgeModeText        = "text"
+	judgeModeImport      = "import"
+	judgeModeMCP         = "mcp"
+)
+
+// A rejection is either the judge saying no or the judge failing, both turn
+// the input away.
+const (
+	judgeVerdictRejected = "rejected"
+	judgeVerdictError    = "error"
+)
+
+// Appeals start pending. The second model can approve one, denying is left
+// to an admin.
+const (
+	appealPending  = "pending"
+	appealApproved = "approved"
+	appealDenied   = "denied"
+)
+
+// judgeRejectionHeader carries the ID of the rejection on the 400 response,
+// the frontend offers the appeal with it.
+const judgeRejectionHeader = "X-Judge-Rejection"
+
+// maxJudgeInputStored bounds the input kept for review, in runes.
+const maxJudgeInputStored = 8000
+
+type JudgeRejection struct {
+	ID            int64      `json:"id"`
+	UserID        int        `json:"userID,omitempty"`
+	Mode          string     `json:"mode"`
+	InputHash     string     `json:"inputHash"`
+	Input         string     `json:"input"`
+	Verdict       string     `json:"verdict"`
+	Model         string     `json:"model"`
+	Reason        string     `json:"reason"`
+	CreatedAt     time.Time  `json:"createdAt"`
+	AppealStatus  string     `json:"appealStatus,omitempty"`
+	AppealMessage string     `json:"appealMessage,omitempty"`
+	AppealedAt    *time.Time `json:"appealedAt,omitempty"`
+	ReviewedBy    string     `json:"reviewedBy,omitempty"`
+	ReviewedAt    *time.Time `json:"reviewedAt,omitempty"`
+}
+
+func judgeInputHash(input string) string {
+	sum := sha256.Sum256([]byte(strings.TrimSpace(input)))
+	return hex.EncodeToString(sum[:])
+}
+
+// recordJudgeRejection keeps the rejection for the user to appeal, it returns
+// 0 outside of a user's request or when it could not be stored.
+func recordJudgeRejection(ctx context.Context, mode string, input string, verdict string, model string, reason string) int64 {
+	userCtx, ok := ctx.Value("user").(UserContext)
+	if !ok || userCtx.UserID == 0 {
+		return 0
+	}
+
+	stored := []rune(strings.TrimSpace(input))
+	if len(stored) > maxJudgeInputStored {
+		stored = stored[:maxJudgeInputStored]
+	}
+
+	var id int64
+	err := pool.QueryRow(context.WithoutCancel(ctx), `
+		INSERT INTO judge_rejections (user_id, mode, input_hash, input, verdict, model, reason)
+		VALUES ($1, $2, $3, $4, $5, $6, $7) RETURNING id`,
+		userCtx.UserID, mode, judgeInputHash(input), string(stored), verdict, model, strings.TrimSpace(reason)).Scan(&id)
+	if err != nil {
+		log.Printf("Error recording judge rejection for user %d: %v\n", userCtx.UserID, err)
+		return 0
+	}
+	return id
+}
+
+// appealApprovedFor is true when the same input of the user was rejected
+// before and the appeal was approved, the judge is not asked again.
+func appealApprovedFor(ctx context.Context, input string) bool {
+	userCtx, ok := ctx.Value("user").(UserContext)
+	if !ok || userCtx.UserID == 0 {
+		return false
+	}
+
+	var approved bool
+	err := pool.QueryRow(ctx, `
+		SELECT EXISTS (SELECT 1 FROM judge_rejections WHERE user_id = $1 AND input_hash = $2 AND appeal_status = $3)`,
+		userCtx.UserID, judgeInputHash(input), appealApproved).Scan(&approved)
+	if err != nil {
+		log.Printf("Error checking judge appeals of user %d: %v\n", userCtx.UserID, err)
+		return false
+	}
+	return approved
+}
+
+func writeJudgeRejection(w http.ResponseWriter, rejectionID int64) {
+	log.Printf("Input rejected by LLM judge")
+	if rejectionID != 0 {
+		w.Header().Set(judgeRejectionHeader, strconv.FormatInt(rejectionID, 10))
+	}
+	http.Error(w, "Input rejected by LLM judge", http.StatusBadRequest)
+}
+
+const judgeRejectionColumns = `id, user_id, mode, input_hash, input, verdict, model, reason, created_at,
+	appeal_status, appeal_message, appealed_at, reviewed_by, reviewed_at`
+
+func scanJudgeRejection(row pgx.Row) (JudgeRejection, error) {
+	var rejection JudgeRejection
+	err := row.Scan(&rejection.ID, &rejection.UserID, &rejection.Mode, &rejection.InputHash, &rejection.Input, &rejection.Verdict,
+		&rejection.Model, &rejection.Reason, &rejection.CreatedAt, &rejection.AppealStatus, &rejection.AppealMessage,
+		&rejection.AppealedAt, &rejection.ReviewedBy, &rejection.ReviewedAt)
+	return rejection, err
+}
+
+func queryJudgeRejections(ctx context.Context, query string, args ...any) ([]JudgeRejection, error) {
+	rows, err := pool.Query(ctx, query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	rejections := []JudgeRejection{}
+	for rows.Next() {
+		rejection, err := scanJudgeRejection(rows)
+		if err != nil {
+			return nil, err
+		}
+		rejections = append(rejections, rejection)
+	}
+	return rejections, rows.Err()
+}
+
+// HandleGetJudgeRejections lists the user's rejected inputs of the last 30
+// days with the state of their appeals.
+func HandleGetJudgeRejections(w http.ResponseWriter, r *http.Request) {
+	userCtx, ok := r.Context().Value("user").(UserContext)
+	if !ok {
+		http.Error(w, "Unauthorized: user context missing", http.StatusUnauthorized)
+		return
+	}
+
+	rejections, err := queryJudgeRejections(r.Context(), `
+		SELECT `+judgeRejectionColumns+` FROM judge_rejections
+		WHERE user_id = $1 AND created_at > now() - interval '30 days' ORDER BY id DESC LIMIT 100`, userCtx.UserID)
+	if err != nil {
+		log.Printf("Error getting judge rejections: %v\n", err)
+		http.Error(w, "Error getting judge rejections", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+
+	err = json.NewEncoder(w).Encode(rejections)
+	if err != nil {
+		http.Error(w, "Error encoding JSON response", http.StatusInternalServerError)
+	}
+}
+
+// HandleAppealJudgeRejection asks for the rejection to be reviewed. With
+// JUDGE_APPEAL_MODEL set a second model looks at the input right away and
+// can approve it, otherwise or if it agrees with the judge the appeal waits
+// for an admin. Once approved the same input passes the judge.
+func HandleAppealJudgeRejection(w http.ResponseWriter, r *http.Request) {
+	userCtx, ok := r.Context().Value("user").(UserContext)
+	if !ok {
+		http.Error(w, "Unauthorized: user context missing", http.StatusUnauthorized)
+		return
+	}
+
+	id, err := strconv.ParseInt(r.PathValue("id"), 10, 64)
+	if err != nil {
+		http.Error(w, "Invalid rejection ID", http.StatusBadRequest)
+		return
+	}
+
+	var req struct {
+		Message string `json:"message"`
+	}
+	if r.ContentLength != 0 {
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			http.Error(w, "Invalid JSON payload", http.StatusBadRequest)
+			return
+		}
+	}
+	message := strings.TrimSpace(req.Message)
+	if len([]rune(message)) > 1000 {
+		http.Error(w, "message must be at most 1000 characters", http.StatusBadRequest)
+		return
+	}
+
+	rejection, err := scanJudgeRejection(pool.QueryRow(r.Context(), `
+		UPDATE judge_rejections SET appeal_status = $3, appeal_message = $4, appealed_at = now()
+		WHERE id = $1 AND user_id = $2 AND appeal_status = ''
+		RETURNING `+judgeRejectionColumns,
+		id, userCtx.UserID, appealPending, message))
+	if errors.Is(err, pgx.ErrNoRows) {
+		http.Error(w, "Rejection not found or already appealed", http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		log.Printf("Error appealing judge rejection %d: %v\n", id, err)
+		http.Error(w, "Error appealing judge rejection", http.StatusInternalServerError)
+		return
+	}
+
+	if model := os.Getenv("JUDGE_APPEAL_MODEL"); model != "" && secondJudgeApproves(r.Context(), model, rejection) {
+		rejection, err = reviewJudgeRejection(r.Context(), id, true, "model:"+model)
+		if err != nil {
+			log.Printf("Error approving judge rejection %d: %v\n", id, err)
+			http.Error(w, "Error appealing judge rejection", http.StatusInternalServerError)
+			return
+		}
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+
+	err = json.NewEncoder(w).Encode(rejection)
+	if err != nil {
+		http.Error(w, "Error encoding JSON response", http.StatusInternalServerError)
+	}
+}
+
+// secondJudgeApproves asks another model with the appeal as context, an error
+// counts as no and leaves the appeal to an admin.
+func secondJudgeApproves(ctx context.Context, model string, rejection JudgeRejection) bool {
+	prompt := "Another check rejected the following input as not being about cooking, the user disagrees."
+	if rejection.AppealMessage != "" {
+		prompt += " The user says: " + rejection.AppealMessage
+	}
+	prompt += "\nIs the input about cooking, food, drinks or a recipe? Only answer with 'yes' or 'no'.\n\n" + rejection.Input
+
+	result, err := llm.Complete(ctx, llmRequest{
+		Task:   llmTaskJudge,
+		System: judgeSystemMessage,
+		Prompt: prompt,
+		Model:  model,
+	})
+	if err != nil {
+		log.Printf("Error reviewing judge rejection %d: %v\n", rejection.ID, err)
+		return false
+	}
+	return strings.Contains(strings.ToLower(result), "yes")
+}
+
+func reviewJudgeRejection(ctx context.Context, id int64, approved bool, reviewer string) (JudgeRejection, error) {
+	status := appealDenied
+	if approved {
+		status = appealApproved
+	}
+	return scanJudgeRejection(pool.QueryRow(ctx, `
+		UPDATE judge_rejections SET appeal_status = $2, reviewed_by = $3, reviewed_at = now()
+		WHERE id = $1 AND appeal_status = $4
+		RETURNING `+judgeRejectionColumns,
+		id, status, reviewer, appealPending))
+}
+
+// HandleListJudgeAppeals lists the appeals of all users, pending ones unless
+// status says otherwise.
+func HandleListJudgeAppeals(w http.ResponseWriter, r *http.Request) {
+	status := r.URL.Query().Get("status")
+	switch status {
+	case "":
+		status = appealPending
+	case appealPending, appealApproved, appealDenied:
+	default:
+		http.Error(w, "status must be pending, approved or denied", http.StatusBadRequest)
+		return
+	}
+
+	rejections, err := queryJudgeRejections(r.Context(), `
+		SELECT `+judgeRejectionColumns+` FROM judge_rejections
+		WHERE appeal_status = $1 ORDER BY appealed_at LIMIT 200`, status)
+	if err != nil {
+		log.Printf("Error getting judge appeals: %v\n", err)
+		http.Error(w, "Error getting judge appeals", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+
+	err = json.NewEncoder(w).Encode(rejections)
+	if err != nil {
+		http.Error(w, "Error encoding JSON response", http.StatusInternalServerError)
+	}
+}
+
+// HandleReviewJudgeAppeal decides a pending appeal.
+func HandleReviewJudgeAppeal(w http.ResponseWriter, r *http.Request) {
+	userCtx, ok := r.Context().Value("user").(UserContext)
+	if !ok {
+		http.Error(w, "Unauthorized: user context missing", http.StatusUnauthorized)
+		return
+	}
+
+	id, err := strconv.ParseInt(r.PathValue("id"), 10, 64)
+	if err != nil {
+		http.Error(w, "Invalid rejection ID", http.StatusBadRequest)
+		return
+	}
+
+	var req struct {
+		Approved *bool `json:"approved"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil || req.Approved == nil {
+		http.Error(w, "Invalid JSON payload, approved is required", http.StatusBadRequest)
+		return
+	}
+
+	rejection, err := reviewJudgeRejection(r.Context(), id, *req.Approved, "admin:"+strconv.Itoa(userCtx.UserID))
+	if errors.Is(err, pgx.ErrNoRows) {
+		http.Error(w, "Appeal not found or already reviewed", http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		log.Printf("Error reviewing judge appeal %d: %v\n", id, err)
+		http.Error(w, "Error reviewing judge appeal", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+
+	err = json.NewEncoder(w).Encode(rejection)
+	if err != nil {
+		http.Error(w, "Error encoding JSON response", http.StatusInternalServerError)
+	}
+}
@@ -0,0 +1,154 @@
+package main
+
+import (
+	"os"
+	"strings"
+	"unicode"
+)
+
+// JUDGE_MODE picks how inputs are judged for the deployment: "llm" runs the
+// pre-filter and asks the model when it is unsure, "prefilter" only runs the
+// pre-filter and lets unsure inputs pass, "off" skips judging entirely.
+const (
+	judgeWithLLM       = "llm"
+	judgePrefilterOnly = "prefilter"
+	judgeOff           = "off"
+)
+
+// Verdicts of the pre-filter.
+const (
+	prefilterUnsure = iota
+	prefilterAccept
+	prefilterReject
+)
+
+// prefilterModel is recorded as the model of a rejection the pre-filter made.
+const prefilterModel = "prefilter"
+
+const (
+	// minJudgeLetters is the least an input needs to say anything.
+	minJudgeLetters = 3
+	// prefilterAcceptKeywords is how many different food words let an
+	// input pass without asking the model.
+	prefilterAcceptKeywords = 3
+)
+
+// foodKeywords are matched against the words of the input, keywords of five
+// letters and more also inside German compounds like Kartoffelsalat.
+var foodKeywords = map[string][]string{
+	languageGerman: {
+		"rezept", "kochen", "backen", "braten", "zutaten", "mehl", "zucker", "salz", "pfeffer", "butter",
+		"milch", "sahne", "eier", "zwiebel", "knoblauch", "kartoffel", "nudeln", "reis", "fleisch", "hähnchen",
+		"rind", "schwein", "fisch", "gemüse", "tomate", "karotte", "möhre", "paprika", "käse", "teig",
+		"kuchen", "suppe", "soße", "sauce", "salat", "ofen", "pfanne", "topf", "gramm", "esslöffel",
+		"teelöffel", "prise", "würzen", "schälen", "hacken", "vegan", "vegetarisch", "frühstück", "abendessen", "mittagessen",
+	},
+	languageEnglish: {
+		"recipe", "cook", "cooking", "bake", "baking", "roast", "fry", "ingredients", "flour", "sugar",
+		"salt", "pepper", "butter", "milk", "cream", "eggs", "onion", "garlic", "potato", "pasta",
+		"noodles", "rice", "meat", "chicken", "beef", "pork", "fish", "vegetables", "tomato", "carrot",
+		"cheese", "dough", "cake", "soup", "sauce", "salad", "oven", "pan", "pot", "grams",
+		"tablespoon", "teaspoon", "pinch", "season", "peel", "chop", "vegan", "vegetarian", "breakfast", "dinner",
+	},
+}
+
+// stopWords tell German from English, inputs in other languages are left to
+// the model.
+var stopWords = map[string][]string{
+	languageGerman:  {"und", "der", "die", "das", "mit", "ein", "eine", "ich", "für", "ist", "nicht", "auf", "von", "zu", "den"},
+	languageEnglish: {"and", "the", "with", "a", "an", "i", "for", "is", "not", "on", "of", "to", "in", "some", "my"},
+}
+
+// judgeBlocklist are phrases of inputs that try to steer the model instead
+// of describing a dish, they are rejected without asking it. JUDGE_BLOCKLIST
+// adds comma-separated phrases.
+var judgeBlocklist = []string{
+	"ignore previous instructions", "ignore all previous", "ignoriere alle", "ignoriere die vorherigen",
+	"system prompt", "systemprompt", "you are now", "du bist jetzt", "jailbreak",
+}
+
+func configuredJudge() string {
+	switch mode := os.Getenv("JUDGE_MODE"); mode {
+	case judgePrefilterOnly, judgeOff:
+		return mode
+	}
+	return judgeWithLLM
+}
+
+func blocklistedPhrases() []string {
+	phrases := judgeBlocklist
+	for _, phrase := range strings.Split(os.Getenv("JUDGE_BLOCKLIST"), ",") {
+		if phrase = strings.ToLower(strings.TrimSpace(phrase)); phrase != "" {
+			phrases = append(phrases, phrase)
+		}
+	}
+	return phrases
+}
+
+// prefilterInput judges the obvious cases without a completion: inputs with
+// hardly any letters and blocklisted phrases are rejected, inputs naming
+// enough food words pass. The reason explains a rejection.
+func prefilterInput(input string) (int, string) {
+	lower := strings.ToLower(input)
+
+	letters := 0
+	for _, char := range lower {
+		if unicode.IsLetter(char) {
+			letters++
+		}
+	}
+	if letters < minJudgeLetters {
+		return prefilterReject, "input too short"
+	}
+
+	for _, phrase := range blocklistedPhrases() {
+		if strings.Contains(lower, phrase) {
+			return prefilterReject, "blocklisted phrase: " + phrase
+		}
+	}
+
+	words := strings.FieldsFunc(lower, func(char rune) bool { return !unicode.IsLetter(char) })
+	languages := []string{languageGerman, languageEnglish}
+	if language := detectLanguage(words); language != "" {
+		languages = []string{language}
+	}
+
+	found := map[string]bool{}
+	for _, word := range words {
+		for _, language := range languages {
+			for _, keyword := range foodKeywords[language] {
+				if word == keyword || (len([]rune(keyword)) >= 5 && strings.Contains(word, keyword)) {
+					found[keyword] = true
+				}
+			}
+		}
+	}
+	if len(found) >= prefilterAcceptKeywords {
+		return prefilterAccept, ""
+	}
+	return prefilterUnsure, ""
+}
+
+// detectLanguage counts stop words, it is empty when neither language has a
+// clear lead.
+func detectLanguage(words []string) string {
+	counts := map[string]int{}
+	for _, word := range words {
+		for language, stops := range stopWords {
+			for _, stop := range stops {
+				if word == stop {
+					counts[language]++
+				}
+			}
+		}
+	}
+
+	german, english := counts[languageGerman], counts[languageEnglish]
+	switch {
+	case german > english*2 && german >= 2:
+		return languageGerman
+	case english > german*2 && english >= 2:
+		return languageEnglish
+	}
+	return ""
+}
@@ -0,0 +1,181 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"log"
+	"net/http"
+	"strconv"
+
+	"github.com/jackc/pgx/v5"
+)
+
+// maxLineageDepth bounds the walk up the parents, copies of copies rarely go
+// more than a few levels deep.
+const maxLineageDepth = 20
+
+// LineageRecipe is a recipe in the lineage of another one. Recipes of other
+// users are only listed while they are in the community feed, Author is set
+// for them.
+type LineageRecipe struct {
+	ID         int    `json:"id"`
+	Recipename string `json:"recipename"`
+	Author     string `json:"author,omitempty"`
+	Own        bool   `json:"own"`
+	URL        string `json:"url,omitempty"`
+}
+
+// RecipeLineage lists the recipes a recipe is based on, the nearest first,
+// and its direct forks. ForkCount also counts forks that are not shared.
+type RecipeLineage struct {
+	RecipeID  int             `json:"recipeID"`
+	Ancestors []LineageRecipe `json:"ancestors"`
+	Forks     []LineageRecipe `json:"forks"`
+	ForkCount int             `json:"forkCount"`
+}
+
+// lineageVisible is the condition a recipe r joined with its owner u has to
+// meet to be listed to user $1.
+const lineageVisible = "(r.user_id = $1 OR (r.community_shared_at IS NOT NULL AND NOT r.archived AND NOT r.draft))"
+
+// validParentRecipe reports whether the user may base a recipe on parentID,
+// one of their own recipes or one in the community feed.
+func validParentRecipe(ctx context.Context, userID int, parentID int) (bool, error) {
+	var valid bool
+	err := pool.QueryRow(ctx, "SELECT EXISTS (SELECT 1 FROM recipes r WHERE r.id = $2 AND "+lineageVisible+")",
+		userID, parentID).Scan(&valid)
+	return valid, err
+}
+
+// HandleGetRecipeLineage returns what a recipe is based on and the copies
+// based on it.
+func HandleGetRecipeLineage(w http.ResponseWriter, r *http.Request) {
+	userCtx, ok := r.Context().Value("user").(UserContext)
+	if !ok {
+		http.Error(w, "Unauthorized: user context missing", http.StatusUnauthorized)
+		return
+	}
+
+	recipeID, err := strconv.Atoi(r.PathValue("id"))
+	if err != nil {
+		http.Error(w, "Invalid recipe ID", http.StatusBadRequest)
+		return
+	}
+
+	lineage, err := recipeLineage(r.Context(), userCtx.UserID, recipeID)
+	if err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			http.Error(w, "Recipe not found", http.StatusNotFound)
+			return
+		}
+		log.Printf("Error getting lineage of recipe %d: %v\n", recipeID, err)
+		http.Error(w, "Error getting lineage", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+
+	err = json.NewEncoder(w).Encode(lineage)
+	if err != nil {
+		http.Error(w, "Error encoding JSON response", http.StatusInternalServerError)
+	}
+}
+
+// recipeLineage works for the user's own recipes and for community recipes.
+// Ancestors the user can't see any more are left out.
+func recipeLineage(ctx context.Context, userID int, recipeID int) (RecipeLineage, error) {
+	lineage := RecipeLineage{RecipeID: recipeID}
+	if valid, err := validParentRecipe(ctx, userID, recipeID); err != nil {
+		return lineage, err
+	} else if !valid {
+		return lineage, pgx.ErrNoRows
+	}
+
+	ancestors, err := lineageRecipes(ctx, userID, `
+		WITH RECURSIVE ancestors (id, depth) AS (
+			SELECT parent_recipe_id, 1 FROM recipes WHERE id = $2 AND parent_recipe_id IS NOT NULL
+			UNION ALL
+			SELECT p.parent_recipe_id, a.depth + 1 FROM ancestors a JOIN recipes p ON p.id = a.id
+			WHERE p.parent_recipe_id IS NOT NULL AND a.depth < $3
+		)
+		SELECT r.id, r.title, r.slug, r.draft, r.user_id = $1, `+communityAuthor+`, u.subdomain
+		FROM ancestors a JOIN recipes r ON r.id = a.id JOIN users u ON u.id = r.user_id
+		WHERE `+lineageVisible+` ORDER BY a.depth`,
+		recipeID, maxLineageDepth)
+	if err != nil {
+		return lineage, err
+	}
+	lineage.Ancestors = ancestors
+
+	forks, err := lineageRecipes(ctx, userID, `
+		SELECT r.id, r.title, r.slug, r.draft, r.user_id = $1, `+communityAuthor+`, u.subdomain
+		FROM recipes r JOIN users u ON u.id = r.user_id
+		WHERE r.parent_recipe_id = $2 AND `+lineageVisible+` ORDER BY r.id`,
+		recipeID)
+	if err != nil {
+		return lineage, err
+	}
+	lineage.Forks = forks
+
+	err = pool.QueryRow(ctx, "SELECT count(*) FROM recipes WHERE parent_recipe_id = $1", recipeID).Scan(&lineage.ForkCount)
+	return lineage, err
+}
+
+func lineageRecipes(ctx context.Context, userID int, query string, args ...any) ([]LineageRecipe, error) {
+	rows, err := pool.Query(ctx, query, append([]any{userID}, args...)...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	recipes := []LineageRecipe{}
+	for rows.Next() {
+		var recipe LineageRecipe
+		var slug, author, subdomain string
+		var draft bool
+		if err := rows.Scan(&recipe.ID, &recipe.Recipename, &slug, &draft, &recipe.Own, &author, &subdomain); err != nil {
+			return nil, err
+		}
+		if !recipe.Own {
+			recipe.Author = author
+		}
+		if !draft {
+			recipe.URL = recipeURL(subdomain, slug)
+		}
+		recipes = append(recipes, recipe)
+	}
+	return recipes, rows.Err()
+}
+
+// basedOnLine links the parent of a published recipe. A parent of another
+// user is only named while it is in the community feed, and the author's
+// name is given with it.
+func basedOnLine(ctx context.Context, userID int, parentID int) (string, error) {
+	if parentID == 0 {
+		return "", nil
+	}
+
+	var title, slug, author, subdomain string
+	var own bool
+	err := pool.QueryRow(ctx, `
+		SELECT r.title, r.slug, r.user_id = $1, `+communityAuthor+`, u.subdomain FROM recipes r JOIN users u ON u.id = r.user_id
+		WHERE r.id = $2 AND NOT r.archived AND NOT r.draft AND `+lineageVisible,
+		userID, parentID).Scan(&title, &slug, &own, &author, &subdomain)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return "", nil
+	}
+	if err != nil {
+		return "", err
+	}
+
+	if own {
+		return "🌱 Basiert auf [" + title + "](./?recipe=" + slug + ")", nil
+	}
+	line := "🌱 Basiert auf [" + title + "](" + recipeURL(subdomain, slug) + ")"
+	if author != "" {
+		line += " von " + author
+	}
+	return line, nil
+}
@@ -0,0 +1,470 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"os"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/openai/openai-go"
+	"github.com/openai/openai-go/option"
+	goopenai "github.com/sashabaranov/go-openai"
+)
+
+// Tasks name what a completion is used for, so providers can pick a model or
+// canned answer per use case.
+const (
+	llmTaskRecipe           = "recipe"
+	llmTaskRecipeName       = "recipe-name"
+	llmTaskRecipeLink       = "recipe-link"
+	llmTaskRecipeImage      = "recipe-image"
+	llmTaskCategory         = "category"
+	llmTaskUpdateRecipe     = "update-recipe"
+	llmTaskJudge            = "judge"
+	llmTaskRecipeQA         = "recipe-qa"
+	llmTaskPairing          = "pairing"
+	llmTaskSeasonTags       = "season-tags"
+	llmTaskTiming           = "timing"
+	llmTaskTranslate        = "translate"
+	llmTaskCuisine          = "cuisine"
+	llmTaskDishCaption      = "dish-caption"
+	llmTaskMultiRecipe      = "multi-recipe"
+	llmTaskMenu             = "menu"
+	llmTaskAppliance        = "appliance"
+	llmTaskMerge            = "merge"
+	llmTaskSchedule         = "schedule"
+	llmTaskDiet             = "diet"
+	llmTaskVeganize         = "veganize"
+	llmTaskRecipeText       = "recipe-text"
+	llmTaskIngredients      = "ingredients"
+	llmTaskParseIngredients = "parse-ingredients"
+)
+
+const defaultLLMModel = openai.ChatModelGPT4oMini
+
+type llmRequest struct {
+	Task   string
+	System string
+	// History holds earlier turns of a conversation, oldest first.
+	History []llmMessage
+	Prompt  string
+	// ImageBase64 is a JPEG or WebP sent along with the prompt, see
+	// prepareImage.
+	ImageBase64 string
+	Model       string
+	// JSON asks for a JSON object as the answer, see completeJSON.
+	JSON bool
+	// MaxTokens caps the answer, limitedLLM sets it from the task limit.
+	MaxTokens int
+}
+
+const (
+	llmRoleUser      = "user"
+	llmRoleAssistant = "assistant"
+)
+
+type llmMessage struct {
+	Role    string
+	Content string
+}
+
+type llmProvider interface {
+	Complete(ctx context.Context, req llmRequest) (string, error)
+	Transcribe(ctx context.Context, audio io.Reader) (string, error)
+	// Speak returns the text as MP3 audio.
+	Speak(ctx context.Context, text string) ([]byte, error)
+	// Embed returns one vector per text, in the order of the texts.
+	Embed(ctx context.Context, texts []string) ([][]float32, error)
+}
+
+// openAIProvider talks to OpenAI or a compatible API with the key resolved
+// from the request context.
+type openAIProvider struct{}
+
+var llm llmProvider = openAIProvider{}
+
+// LLM_PROVIDER picks the chat provider, LLM_FALLBACK lists the providers
+// tried in order when it fails or takes longer than LLM_LATENCY_SLO.
+const (
+	providerOpenAI    = "openai"
+	providerAnthropic = "anthropic"
+	providerGemini    = "gemini"
+)
+
+func initLLM() {
+	if testMode() {
+		llm = fakeLLM{}
+		log.Println("using fake LLM provider")
+	} else {
+		names := []string{providerOpenAI}
+		if primary := os.Getenv("LLM_PROVIDER"); primary != "" {
+			names[0] = primary
+		}
+		for _, name := range strings.Split(os.Getenv("LLM_FALLBACK"), ",") {
+			if name = strings.TrimSpace(name); name != "" && !slices.Contains(names, name) {
+				names = append(names, name)
+			}
+		}
+		if len(names) > 1 || names[0] != providerOpenAI {
+			fallback := fallbackLLM{names: names, slo: latencySLO()}
+			for _, name := range names {
+				fallback.providers = append(fallback.providers, newProvider(name))
+			}
+			llm = fallback
+			log.Printf("using LLM providers %s\n", strings.Join(names, ", "))
+		}
+		initWhisper()
+	}
+	llm = experimentLLM{limitedLLM{tracingLLM{llm}}}
+}
+
+func newProvider(name string) llmProvider {
+	switch name {
+	case providerOpenAI:
+		return openAIProvider{}
+	case providerAnthropic:
+		return newAnthropicProvider()
+	case providerGemini:
+		return newGeminiProvider()
+	}
+	log.Fatalf("Unknown LLM provider %q, must be openai, anthropic or gemini\n", name)
+	return nil
+}
+
+// latencySLO is LLM_LATENCY_SLO, like 20s. A provider that has not answered
+// by then is given up on when there is another one to ask, the last one
+// gets all the time the request has.
+func latencySLO() time.Duration {
+	value := os.Getenv("LLM_LATENCY_SLO")
+	if value == "" {
+		return 0
+	}
+	slo, err := time.ParseDuration(value)
+	if err != nil || slo < 0 {
+		log.Fatalf("Invalid LLM_LATENCY_SLO %q, must be a duration like 20s\n", value)
+	}
+	return slo
+}
+
+// servedBy is where the provider that answered a completion is noted, see
+// tracingLLM.
+type servedBy struct {
+	provider string
+}
+
+// fallbackLLM asks the providers in order until one answers. Users with
+// their own OpenAI key always get OpenAI, they pay for it. Audio and
+// embeddings are only done by OpenAI.
+type fallbackLLM struct {
+	openAIProvider
+	names     []string
+	providers []llmProvider
+	slo       time.Duration
+}
+
+func (f fallbackLLM) Complete(ctx context.Context, req llmRequest) (string, error) {
+	served, _ := ctx.Value("servedBy").(*servedBy)
+	if userCtx, ok := ctx.Value("user").(UserContext); ok {
+		ownKey, err := usesOwnOpenAIKey(ctx, userCtx.UserID)
+		if err != nil {
+			return "", fmt.Errorf("failed to look up the OpenAI key of user %d: %w", userCtx.UserID, err)
+		}
+		if ownKey {
+			if served != nil {
+				served.provider = providerOpenAI
+			}
+			return f.openAIProvider.Complete(ctx, req)
+		}
+	}
+
+	var err error
+	for i, provider := range f.providers {
+		attemptCtx, cancel := ctx, context.CancelFunc(func() {})
+		if f.slo > 0 && i < len(f.providers)-1 {
+			attemptCtx, cancel = context.WithTimeout(ctx, f.slo)
+		}
+		var answer string
+		answer, err = provider.Complete(attemptCtx, req)
+		cancel()
+		if err == nil {
+			if served != nil {
+				served.provider = f.names[i]
+			}
+			if i > 0 {
+				log.Printf("Completed %s with %s after failover\n", req.Task, f.names[i])
+			}
+			return answer, nil
+		}
+		if ctx.Err() != nil {
+			return "", err
+		}
+		if attemptCtx.Err() != nil {
+			log.Printf("Giving up on %s for %s after the latency SLO of %s\n", f.names[i], req.Task, f.slo)
+		} else {
+			log.Printf("Error completing %s with %s: %v\n", req.Task, f.names[i], err)
+		}
+	}
+	return "", err
+}
+
+// postLLMJSON posts the request body to a provider API and decodes the
+// answer into dest, errors carry the status and the start of the answer.
+func postLLMJSON(ctx context.Context, provider string, url string, headers map[string]string, body any, dest any) error {
+	payload, err := json.Marshal(body)
+	if err != nil {
+		return err
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, url, bytes.NewReader(payload))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	for name, value := range headers {
+		req.Header.Set(name, value)
+	}
+
+	res, err := llmHTTPClient.Do(req)
+	if err != nil {
+		return fmt.Errorf("%s request failed: %w", provider, err)
+	}
+	defer res.Body.Close()
+
+	data, err := io.ReadAll(io.LimitReader(res.Body, 8<<20))
+	if err != nil {
+		return fmt.Errorf("reading %s answer: %w", provider, err)
+	}
+	if res.StatusCode != http.StatusOK {
+		return fmt.Errorf("%s answered %s: %.300s", provider, res.Status, data)
+	}
+	if err := json.Unmarshal(data, dest); err != nil {
+		return fmt.Errorf("invalid %s answer: %w", provider, err)
+	}
+	return nil
+}
+
+var llmHTTPClient = &http.Client{Timeout: 3 * time.Minute}
+
+func openAIclient(ctx context.Context) (*openai.Client, error) {
+	creds, err := openAICredentialsFor(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	opts := []option.RequestOption{option.WithAPIKey(creds.APIKey)}
+	if creds.BaseURL != "" {
+		opts = append(opts, option.WithBaseURL(creds.BaseURL+"/"), option.WithHTTPClient(openAIKeyClient))
+	}
+
+	return openai.NewClient(opts...), nil
+}
+
+func goopenAIclient(ctx context.Context) (*goopenai.Client, error) {
+	creds, err := openAICredentialsFor(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	config := goopenai.DefaultConfig(creds.APIKey)
+	if creds.BaseURL != "" {
+		config.BaseURL = creds.BaseURL
+		config.HTTPClient = openAIKeyClient
+	}
+
+	return goopenai.NewClientWithConfig(config), nil
+}
+
+func (openAIProvider) Complete(ctx context.Context, req llmRequest) (string, error) {
+	model := req.Model
+	if model == "" {
+		model = modelFor(ctx)
+	}
+
+	if req.ImageBase64 != "" {
+		return openAICompleteWithImage(ctx, model, req)
+	}
+
+	client, err := openAIclient(ctx)
+	if err != nil {
+		return "", err
+	}
+
+	var messages []openai.ChatCompletionMessageParamUnion
+	if req.System != "" {
+		messages = append(messages, openai.SystemMessage(req.System))
+	}
+	for _, message := range req.History {
+		if message.Role == llmRoleAssistant {
+			messages = append(messages, openai.AssistantMessage(message.Content))
+		} else {
+			messages = append(messages, openai.UserMessage(message.Content))
+		}
+	}
+	messages = append(messages, openai.UserMessage(req.Prompt))
+
+	params := openai.ChatCompletionNewParams{
+		Messages: openai.F(messages),
+		Model:    openai.F(model),
+	}
+	if req.MaxTokens > 0 {
+		params.MaxTokens = openai.F(int64(req.MaxTokens))
+	}
+	if req.JSON {
+		params.ResponseFormat = openai.F[openai.ChatCompletionNewParamsResponseFormatUnion](openai.ResponseFormatJSONObjectParam{
+			Type: openai.F(openai.ResponseFormatJSONObjectTypeJSONObject),
+		})
+	}
+
+	completion, err := client.Chat.Completions.New(ctx, params)
+	if err != nil {
+		return "", fmt.Errorf("chat completion error: %w", err)
+	}
+
+	if len(completion.Choices) == 0 {
+		return "", errors.New("no completion choices returned")
+	}
+
+	return completion.Choices[0].Message.Content, nil
+}
+
+func openAICompleteWithImage(ctx context.Context, model string, req llmRequest) (string, error) {
+	client, err := goopenAIclient(ctx)
+	if err != nil {
+		return "", err
+	}
+
+	var messages []goopenai.ChatCompletionMessage
+	if req.System != "" {
+		messages = append(messages, goopenai.ChatCompletionMessage{Role: goopenai.ChatMessageRoleSystem, Content: req.System})
+	}
+	messages = append(messages, goopenai.ChatCompletionMessage{
+		Role: goopenai.ChatMessageRoleUser,
+		MultiContent: []goopenai.ChatMessagePart{
+			{
+				Type: goopenai.ChatMessagePartTypeText,
+				Text: req.Prompt,
+			},
+			{
+				Type: goopenai.ChatMessagePartTypeImageURL,
+				ImageURL: &goopenai.ChatMessageImageURL{
+					URL: "data:" + imageContentType(req.ImageBase64) + ";base64," + req.ImageBase64,
+				},
+			},
+		},
+	})
+
+	response, err := client.CreateChatCompletion(ctx, goopenai.ChatCompletionRequest{
+		Model:     model,
+		Messages:  messages,
+		MaxTokens: req.MaxTokens,
+	})
+	if err != nil {
+		return "", err
+	}
+
+	if len(response.Choices) == 0 {
+		return "", errors.New("no completion choices returned")
+	}
+
+	return response.Choices[0].Message.Content, nil
+}
+
+func (openAIProvider) Transcribe(ctx context.Context, audio io.Reader) (string, error) {
+	client, err := goopenAIclient(ctx)
+	if err != nil {
+		return "", err
+	}
+
+	req := goopenai.AudioRequest{
+		Model:    goopenai.Whisper1,
+		Reader:   audio,
+		FilePath: "voicemessage.mp3", // fake name necessary for the request
+	}
+
+	response, err := client.CreateTranscription(ctx, req)
+	if err != nil {
+		return "", err
+	}
+
+	return response.Text, nil
+}
+
+func (openAIProvider) Speak(ctx context.Context, text string) ([]byte, error) {
+	client, err := goopenAIclient(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	response, err := client.CreateSpeech(ctx, goopenai.CreateSpeechRequest{
+		Model:          goopenai.TTSModel1,
+		Input:          text,
+		Voice:          goopenai.VoiceAlloy,
+		ResponseFormat: goopenai.SpeechResponseFormatMp3,
+	})
+	if err != nil {
+		return nil, err
+	}
+	defer response.Close()
+
+	return io.ReadAll(response)
+}
+
+func (openAIProvider) Embed(ctx context.Context, texts []string) ([][]float32, error) {
+	client, err := goopenAIclient(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	response, err := client.CreateEmbeddings(ctx, goopenai.EmbeddingRequest{
+		Input: texts,
+		Model: goopenai.SmallEmbedding3,
+	})
+	if err != nil {
+		return nil, err
+	}
+	if len(response.Data) != len(texts) {
+		return nil, fmt.Errorf("got %d embeddings for %d texts", len(response.Data), len(texts))
+	}
+
+	vectors := make([][]float32, len(texts))
+	for _, embedding := range response.Data {
+		if embedding.Index < 0 || embedding.Index >= len(texts) {
+			return nil, fmt.Errorf("embedding index %d out of range", embedding.Index)
+		}
+		vectors[embedding.Index] = embedding.Embedding
+	}
+	return vectors, nil
+}
+
+// completeJSON decodes the answer into dest. The prompt has to describe the
+// expected object, models wrap it in a markdown code fence now and then.
+func completeJSON(ctx context.Context, req llmRequest, dest interface{}) error {
+	req.JSON = true
+
+	answer, err := llm.Complete(ctx, req)
+	if err != nil {
+		return err
+	}
+
+	answer = strings.TrimSpace(answer)
+	answer = strings.TrimPrefix(answer, "```json")
+	answer = strings.TrimPrefix(answer, "```")
+	answer = strings.TrimSuffix(answer, "```")
+
+	if err := json.Unmarshal([]byte(answer), dest); err != nil {
+		return fmt.Errorf("invalid JSON answer for %s: %w", req.Task, err)
+	}
+	return nil
+}
+
+func testMode() bool {
+	return os.Getenv("TEST_MODE") == "true"
+}
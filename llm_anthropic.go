@@ -0,0 +1,129 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"log"
+	"os"
+	"strings"
+)
+
+const (
+	anthropicMessagesURL = "https://api.anthropic.com/v1/messages"
+	anthropicVersion     = "2023-06-01"
+
+	defaultAnthropicModel        = "claude-3-5-haiku-latest"
+	defaultAnthropicPremiumModel = "claude-sonnet-4-0"
+)
+
+// anthropicProvider talks to the Claude messages API with ANTHROPIC_API_KEY.
+// Claude has no transcription, speech or embeddings, those stay with
+// OpenAI so stored embeddings remain comparable.
+type anthropicProvider struct {
+	openAIProvider
+	apiKey string
+}
+
+func newAnthropicProvider() anthropicProvider {
+	key, found := lookupSecret("ANTHROPIC_API_KEY")
+	if !found || key == "" {
+		log.Fatal("ANTHROPIC_API_KEY is required for the anthropic provider")
+	}
+	return anthropicProvider{apiKey: key}
+}
+
+type anthropicContent struct {
+	Type   string                `json:"type"`
+	Text   string                `json:"text,omitempty"`
+	Source *anthropicImageSource `json:"source,omitempty"`
+}
+
+type anthropicImageSource struct {
+	Type      string `json:"type"`
+	MediaType string `json:"media_type"`
+	Data      string `json:"data"`
+}
+
+type anthropicMessage struct {
+	Role    string             `json:"role"`
+	Content []anthropicContent `json:"content"`
+}
+
+func (p anthropicProvider) Complete(ctx context.Context, req llmRequest) (string, error) {
+	system := req.System
+	if req.JSON {
+		// there is no JSON mode, completeJSON copes with a code fence
+		system = strings.TrimSpace(system + "\n\nAnswer with the JSON object only.")
+	}
+
+	var messages []anthropicMessage
+	for _, message := range req.History {
+		role := "user"
+		if message.Role == llmRoleAssistant {
+			role = "assistant"
+		}
+		messages = append(messages, anthropicMessage{Role: role, Content: []anthropicContent{{Type: "text", Text: message.Content}}})
+	}
+	prompt := []anthropicContent{{Type: "text", Text: req.Prompt}}
+	if req.ImageBase64 != "" {
+		prompt = append([]anthropicContent{{Type: "image", Source: &anthropicImageSource{
+			Type:      "base64",
+			MediaType: imageContentType(req.ImageBase64),
+			Data:      req.ImageBase64,
+		}}}, prompt...)
+	}
+	messages = append(messages, anthropicMessage{Role: "user", Content: prompt})
+
+	maxTokens := req.MaxTokens
+	if maxTokens == 0 {
+		maxTokens = defaultTaskLimit.Output
+	}
+
+	var response struct {
+		Content []anthropicContent `json:"content"`
+	}
+	err := postLLMJSON(ctx, "anthropic", anthropicMessagesURL, map[string]string{
+		"x-api-key":         p.apiKey,
+		"anthropic-version": anthropicVersion,
+	}, map[string]any{
+		"model":      tierModel(ctx, req.Model, "claude", "ANTHROPIC_MODEL", defaultAnthropicModel, "ANTHROPIC_PREMIUM_MODEL", defaultAnthropicPremiumModel),
+		"max_tokens": maxTokens,
+		"system":     system,
+		"messages":   messages,
+	}, &response)
+	if err != nil {
+		return "", err
+	}
+
+	var answer strings.Builder
+	for _, content := range response.Content {
+		if content.Type == "text" {
+			answer.WriteString(content.Text)
+		}
+	}
+	if answer.Len() == 0 {
+		return "", errors.New("no text in the anthropic answer")
+	}
+	return answer.String(), nil
+}
+
+// tierModel maps the model asked for to the provider. Models of the
+// provider itself, named with prefix, are used as they are, OpenAI models
+// and the user's tier pick the standard or premium model of the provider.
+func tierModel(ctx context.Context, requested string, prefix string, standardEnv string, standardDefault string, premiumEnv string, premiumDefault string) string {
+	if strings.HasPrefix(requested, prefix) {
+		return requested
+	}
+
+	premium := requested == modelTiers[modelTierPremium] || (requested == "" && requestUserSettings(ctx).ModelTier == modelTierPremium)
+	if premium {
+		if model := os.Getenv(premiumEnv); model != "" {
+			return model
+		}
+		return premiumDefault
+	}
+	if model := os.Getenv(standardEnv); model != "" {
+		return model
+	}
+	return standardDefault
+}
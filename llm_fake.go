@@ -0,0 +1,123 @@
+package main
+
+import (
+	"context"
+	"hash/fnv"
+	"io"
+	"strconv"
+	"strings"
+	"unicode"
+)
+
+// fakeRecipe follows the markdown structure the system prompts ask for, so
+// ingredient extraction and publishing work on generated test recipes.
+const fakeRecipe = `# Pfannkuchen
+
+## Zutaten
+
+- 200 g Mehl
+- 2 Eier
+- 300 ml Milch
+
+## Zubereitung
+
+1. Alle Zutaten verrühren.
+2. In einer Pfanne ausbacken.
+`
+
+// fakeRejectMarker makes the fake judge reject an input.
+const fakeRejectMarker = "[reject]"
+
+// fakeLLM answers deterministically without any network access, it is used
+// when TEST_MODE=true.
+type fakeLLM struct{}
+
+func (fakeLLM) Complete(_ context.Context, req llmRequest) (string, error) {
+	switch req.Task {
+	case llmTaskJudge:
+		if strings.Contains(req.Prompt, fakeRejectMarker) {
+			return "no", nil
+		}
+		return "yes", nil
+	case llmTaskRecipeName:
+		return "Pfannkuchen", nil
+	case llmTaskCategory:
+		return "Hauptgericht", nil
+	case llmTaskRecipeQA:
+		return "Laut Rezept geht das.", nil
+	case llmTaskSeasonTags:
+		return `{"seasons": ["winter"], "holidays": []}`, nil
+	case llmTaskTiming:
+		return `{"prepTime": 10, "cookTime": 20, "totalTime": 30, "difficulty": "easy"}`, nil
+	case llmTaskTranslate:
+		return `{"title": "Pancakes", "recipe": "# Pancakes\n\n## Ingredients\n\n- 200 g flour\n- 2 eggs\n- 300 ml milk\n\n## Preparation\n\n1. Mix all ingredients.\n2. Fry in a pan.\n"}`, nil
+	case llmTaskCuisine:
+		return `{}`, nil
+	case llmTaskMultiRecipe:
+		return `{"recipes": [{"title": "Pfannkuchen", "recipe": ` + strconv.Quote(fakeRecipe) + `}, ` +
+			`{"title": "Apfelpfannkuchen", "recipe": ` + strconv.Quote(strings.Replace(fakeRecipe, "- 300 ml Milch", "- 300 ml Milch\n- 2 Äpfel", 1)) + `}]}`, nil
+	case llmTaskAppliance:
+		return `{"title": "Pfannkuchen aus dem Thermomix", "recipe": ` + strconv.Quote(strings.Replace(fakeRecipe, "Alle Zutaten verrühren.", "Alle Zutaten 20 Sek./Stufe 5 verrühren.", 1)) + `}`, nil
+	case llmTaskMenu:
+		var courses []string
+		for _, course := range []string{"starter", "soup", "main", "side", "dessert"} {
+			courses = append(courses, `{"course": "`+course+`", "name": "Pfannkuchen", "reason": "Passt immer.", "recipeID": 0}`)
+		}
+		return `{"title": "Pfannkuchenmenü", "courses": [` + strings.Join(courses, ", ") + `]}`, nil
+	case llmTaskDishCaption:
+		return "Pfannkuchen aus Mehl, Eiern und Milch, in der Pfanne ausgebacken.", nil
+	case llmTaskSchedule:
+		return `{"steps": [{"title": "Vorteig ansetzen", "offsetMinutes": 0, "durationMinutes": 10, "reminder": true}, ` +
+			`{"title": "Teig kneten", "offsetMinutes": 720, "durationMinutes": 20, "reminder": true}, ` +
+			`{"title": "Backen", "offsetMinutes": 1440, "durationMinutes": 50, "reminder": true}]}`, nil
+	case llmTaskDiet:
+		return `{"diet": "vegetarian"}`, nil
+	case llmTaskVeganize:
+		vegan := strings.NewReplacer("- 2 Eier", "- 2 EL Leinsamen, geschrotet", "300 ml Milch", "300 ml Hafermilch").Replace(fakeRecipe)
+		return `{"title": "Vegane Pfannkuchen", "recipe": ` + strconv.Quote(vegan) + `, "substitutions": [` +
+			`{"original": "2 Eier", "replacement": "2 EL Leinsamen, geschrotet"}, {"original": "300 ml Milch", "replacement": "300 ml Hafermilch"}]}`, nil
+	case llmTaskRecipeText:
+		return `{"title": "Pfannkuchen", "recipe": ` + strconv.Quote(fakeRecipe) + `, "guesses": [{"value": "300 ml Milch", "reason": "Die Menge fehlte."}]}`, nil
+	case llmTaskIngredients, llmTaskParseIngredients:
+		return `{"ingredients": []}`, nil
+	case llmTaskPairing:
+		return `{"drinks": [{"name": "Riesling", "reason": "Frische Säure."}], "sideDishes": [{"name": "Grüner Salat", "reason": "Leichter Ausgleich."}]}`, nil
+	default:
+		return fakeRecipe, nil
+	}
+}
+
+func (fakeLLM) Transcribe(_ context.Context, audio io.Reader) (string, error) {
+	if _, err := io.Copy(io.Discard, audio); err != nil {
+		return "", err
+	}
+	return "Ein Rezept für Pfannkuchen", nil
+}
+
+// fakeEmbeddingSize is enough for the word hashing of Embed to tell a few
+// test recipes apart.
+const fakeEmbeddingSize = 64
+
+// Embed hashes the lower cased words into a vector, texts sharing words
+// come out similar.
+func (fakeLLM) Embed(_ context.Context, texts []string) ([][]float32, error) {
+	vectors := make([][]float32, len(texts))
+	for i, text := range texts {
+		vector := make([]float32, fakeEmbeddingSize)
+		for _, word := range strings.FieldsFunc(strings.ToLower(text), func(r rune) bool {
+			return !unicode.IsLetter(r) && !unicode.IsDigit(r)
+		}) {
+			hash := fnv.New32a()
+			hash.Write([]byte(word))
+			vector[hash.Sum32()%fakeEmbeddingSize]++
+		}
+		vectors[i] = vector
+	}
+	return vectors, nil
+}
+
+// Speak returns the text itself instead of audio, so tests can check what
+// would have been said.
+func (fakeLLM) Speak(_ context.Context, text string) ([]byte, error) {
+	return []byte(text), nil
+}
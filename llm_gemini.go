@@ -0,0 +1,101 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"log"
+	"net/url"
+	"strings"
+)
+
+const (
+	geminiAPIURL = "https://generativelanguage.googleapis.com/v1beta/models/"
+
+	defaultGeminiModel        = "gemini-2.0-flash"
+	defaultGeminiPremiumModel = "gemini-2.5-pro"
+)
+
+// geminiProvider talks to the Gemini API with GEMINI_API_KEY. Like Claude
+// it leaves audio and embeddings to OpenAI.
+type geminiProvider struct {
+	openAIProvider
+	apiKey string
+}
+
+func newGeminiProvider() geminiProvider {
+	key, found := lookupSecret("GEMINI_API_KEY")
+	if !found || key == "" {
+		log.Fatal("GEMINI_API_KEY is required for the gemini provider")
+	}
+	return geminiProvider{apiKey: key}
+}
+
+type geminiPart struct {
+	Text       string            `json:"text,omitempty"`
+	InlineData *geminiInlineData `json:"inlineData,omitempty"`
+}
+
+type geminiInlineData struct {
+	MimeType string `json:"mimeType"`
+	Data     string `json:"data"`
+}
+
+type geminiContent struct {
+	Role  string       `json:"role,omitempty"`
+	Parts []geminiPart `json:"parts"`
+}
+
+func (p geminiProvider) Complete(ctx context.Context, req llmRequest) (string, error) {
+	var contents []geminiContent
+	for _, message := range req.History {
+		role := "user"
+		if message.Role == llmRoleAssistant {
+			role = "model"
+		}
+		contents = append(contents, geminiContent{Role: role, Parts: []geminiPart{{Text: message.Content}}})
+	}
+	prompt := []geminiPart{{Text: req.Prompt}}
+	if req.ImageBase64 != "" {
+		prompt = append(prompt, geminiPart{InlineData: &geminiInlineData{MimeType: imageContentType(req.ImageBase64), Data: req.ImageBase64}})
+	}
+	contents = append(contents, geminiContent{Role: "user", Parts: prompt})
+
+	body := map[string]any{"contents": contents}
+	if req.System != "" {
+		body["systemInstruction"] = geminiContent{Parts: []geminiPart{{Text: req.System}}}
+	}
+	config := map[string]any{}
+	if req.MaxTokens > 0 {
+		config["maxOutputTokens"] = req.MaxTokens
+	}
+	if req.JSON {
+		config["responseMimeType"] = "application/json"
+	}
+	if len(config) > 0 {
+		body["generationConfig"] = config
+	}
+
+	model := tierModel(ctx, req.Model, "gemini", "GEMINI_MODEL", defaultGeminiModel, "GEMINI_PREMIUM_MODEL", defaultGeminiPremiumModel)
+	var response struct {
+		Candidates []struct {
+			Content geminiContent `json:"content"`
+		} `json:"candidates"`
+	}
+	err := postLLMJSON(ctx, "gemini", geminiAPIURL+url.PathEscape(model)+":generateContent",
+		map[string]string{"x-goog-api-key": p.apiKey}, body, &response)
+	if err != nil {
+		return "", err
+	}
+	if len(response.Candidates) == 0 {
+		return "", errors.New("no candidates in the gemini answer")
+	}
+
+	var answer strings.Builder
+	for _, part := range response.Candidates[0].Content.Parts {
+		answer.WriteString(part.Text)
+	}
+	if answer.Len() == 0 {
+		return "", errors.New("no text in the gemini answer")
+	}
+	return answer.String(), nil
+}
@@ -0,0 +1,177 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"strings"
+	"sync"
+	"unicode/utf8"
+)
+
+// errInputTooLarge turns a generation request away before it costs a
+// completion, the endpoints answer 422 with the message.
+var errInputTooLarge = errors.New("input too large, please shorten")
+
+// taskLimit bounds one completion. Input is what is sent including the
+// system message, Output is passed as max_tokens. Both are in tokens and
+// estimated from the text, see approxTokens.
+type taskLimit struct {
+	Input  int
+	Output int
+}
+
+var defaultTaskLimit = taskLimit{Input: 8000, Output: 2000}
+
+var taskLimits = map[string]taskLimit{
+	llmTaskRecipe:           {Input: 5000, Output: 2000},
+	llmTaskRecipeName:       {Input: 6000, Output: 30},
+	llmTaskRecipeLink:       {Input: 14000, Output: 2500},
+	llmTaskRecipeImage:      {Input: 3000, Output: 2500},
+	llmTaskCategory:         {Input: 6000, Output: 30},
+	llmTaskJudge:            {Input: 6000, Output: 10},
+	llmTaskMultiRecipe:      {Input: 14000, Output: 8000},
+	llmTaskMerge:            {Input: 12000, Output: 3000},
+	llmTaskTranslate:        {Input: 8000, Output: 3000},
+	llmTaskUpdateRecipe:     {Input: 8000, Output: 2500},
+	llmTaskRecipeText:       {Input: 8000, Output: 2500},
+	llmTaskParseIngredients: {Input: 8000, Output: 4000},
+}
+
+// endpointBudgets cap the estimated tokens all completions of one request
+// may use, sent and at most received. Endpoints not listed get
+// defaultEndpointBudget.
+var endpointBudgets = map[string]int{
+	"/api/v1/generate/by-description":        14000,
+	"/api/v1/generate/by-link":               30000,
+	"POST /api/v1/generate/by-text":          20000,
+	"/api/v1/generate/by-image":              16000,
+	"POST /api/v1/generate/by-voice":         16000,
+	"POST /api/v1/recipes/reclassify":        200000,
+	"POST /api/v1/recipes/{id}/translate":    16000,
+	"POST /api/v1/recipes/convert-appliance": 16000,
+	"GET /api/v1/clip":                       30000,
+	"POST /api/v1/mcp":                       14000,
+}
+
+const defaultEndpointBudget = 24000
+
+// backgroundRecipeBudget caps the completions of one recipe imported outside
+// a request, from inbound mail, a WhatsApp export or the source re-check. It
+// is the budget of a link import.
+const backgroundRecipeBudget = 30000
+
+func limitFor(task string) taskLimit {
+	if limit, found := taskLimits[task]; found {
+		return limit
+	}
+	return defaultTaskLimit
+}
+
+// approxTokens assumes four characters per token, close enough for German
+// and English recipes to keep the cost in bounds.
+func approxTokens(text string) int {
+	return (utf8.RuneCountInString(text) + 3) / 4
+}
+
+// truncateSource cuts a source to about maxTokens at the last line break.
+func truncateSource(text string, maxTokens int) string {
+	if approxTokens(text) <= maxTokens {
+		return text
+	}
+	cut := []rune(text)[:maxTokens*4]
+	truncated := string(cut)
+	if i := strings.LastIndex(truncated, "\n"); i > len(truncated)/2 {
+		truncated = truncated[:i]
+	}
+	log.Printf("Truncated source from about %d to %d tokens\n", approxTokens(text), approxTokens(truncated))
+	return truncated
+}
+
+// llmBudget is what is left of the endpoint budget of a request. exceeded
+// is set once a completion was turned away, see generationWriter.
+type llmBudget struct {
+	mu        sync.Mutex
+	remaining int
+	exceeded  bool
+}
+
+func newLLMBudget(r *http.Request) *llmBudget {
+	budget, found := endpointBudgets[r.Pattern]
+	if !found {
+		budget = defaultEndpointBudget
+	}
+	return &llmBudget{remaining: budget}
+}
+
+// withLLMBudget puts a budget in place for completions that don't run under
+// GenerationLimitMiddleware. A budget already in the context is kept.
+func withLLMBudget(ctx context.Context, budget *llmBudget) context.Context {
+	if _, found := ctx.Value("budget").(*llmBudget); found {
+		return ctx
+	}
+	return context.WithValue(ctx, "budget", budget)
+}
+
+// rejectInput marks the budget of the request as exceeded, for sources
+// turned away before a completion is built from them.
+func rejectInput(ctx context.Context) {
+	if budget, found := ctx.Value("budget").(*llmBudget); found {
+		budget.reject()
+	}
+}
+
+func (b *llmBudget) spend(tokens int) bool {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if tokens > b.remaining {
+		b.exceeded = true
+		return false
+	}
+	b.remaining -= tokens
+	return true
+}
+
+func (b *llmBudget) tooLarge() bool {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.exceeded
+}
+
+func (b *llmBudget) reject() {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.exceeded = true
+}
+
+// limitedLLM checks a completion against the limit of its task and the
+// budget of the request before it is sent, and caps the answer.
+type limitedLLM struct {
+	llmProvider
+}
+
+func (l limitedLLM) Complete(ctx context.Context, req llmRequest) (string, error) {
+	limit := limitFor(req.Task)
+	budget, _ := ctx.Value("budget").(*llmBudget)
+
+	input := approxTokens(req.System) + approxTokens(req.Prompt)
+	for _, message := range req.History {
+		input += approxTokens(message.Content)
+	}
+	if input > limit.Input {
+		if budget != nil {
+			budget.reject()
+		}
+		return "", fmt.Errorf("%w: %s needs about %d tokens, at most %d", errInputTooLarge, req.Task, input, limit.Input)
+	}
+	if req.MaxTokens == 0 || req.MaxTokens > limit.Output {
+		req.MaxTokens = limit.Output
+	}
+	if budget != nil && !budget.spend(input+req.MaxTokens) {
+		return "", fmt.Errorf("%w: the request is over its cost ceiling at %s", errInputTooLarge, req.Task)
+	}
+
+	return l.llmProvider.Complete(ctx, req)
+}
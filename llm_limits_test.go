@@ -0,0 +1,44 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"testing"
+)
+
+func TestLinkSourceTooLarge(t *testing.T) {
+	saved := llm
+	llm = limitedLLM{fakeLLM{}}
+	t.Cleanup(func() { llm = saved })
+
+	page := "<h1>Pfannkuchen</h1><ul><li>200 g Mehl</li></ul>"
+	if _, err := openAIgenerateRecipeLink(context.Background(), page, true); err != nil {
+		t.Fatalf("short page: %v", err)
+	}
+
+	long := page + strings.Repeat("<p>Noch ein Absatz über Pfannkuchen.</p>\n", 2000)
+	if _, err := openAIgenerateRecipeLink(context.Background(), long, true); !errors.Is(err, errInputTooLarge) {
+		t.Errorf("long page: got %v, want errInputTooLarge", err)
+	}
+}
+
+func TestBackgroundBudget(t *testing.T) {
+	saved := llm
+	llm = limitedLLM{fakeLLM{}}
+	t.Cleanup(func() { llm = saved })
+
+	budget := &llmBudget{remaining: 2000}
+	ctx := withLLMBudget(context.Background(), budget)
+	if withLLMBudget(ctx, &llmBudget{remaining: backgroundRecipeBudget}) != ctx {
+		t.Fatal("withLLMBudget replaced the budget in place")
+	}
+
+	page := "<h1>Pfannkuchen</h1><ul><li>200 g Mehl</li></ul>"
+	if _, err := openAIgenerateRecipeLink(ctx, page, true); !errors.Is(err, errInputTooLarge) {
+		t.Errorf("over the budget: got %v, want errInputTooLarge", err)
+	}
+	if !budget.tooLarge() {
+		t.Error("budget not marked as exceeded")
+	}
+}
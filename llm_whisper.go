@@ -0,0 +1,100 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"mime/multipart"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+)
+
+// whisperLLM sends audio to a self-hosted whisper server at WHISPER_URL
+// instead of OpenAI, everything else goes to the wrapped provider. The
+// request is the OpenAI transcription form, which faster-whisper-server
+// (/v1/audio/transcriptions) and the whisper.cpp server (/inference) both
+// accept. There is no fallback to OpenAI, the audio stays on the server.
+type whisperLLM struct {
+	llmProvider
+	url    string
+	model  string
+	apiKey string
+}
+
+func newWhisperLLM(next llmProvider, endpoint string) whisperLLM {
+	apiKey, _ := lookupSecret("WHISPER_API_KEY")
+	return whisperLLM{llmProvider: next, url: endpoint, model: os.Getenv("WHISPER_MODEL"), apiKey: apiKey}
+}
+
+func (w whisperLLM) Transcribe(ctx context.Context, audio io.Reader) (string, error) {
+	var body bytes.Buffer
+	form := multipart.NewWriter(&body)
+	file, err := form.CreateFormFile("file", "voicemessage.mp3")
+	if err != nil {
+		return "", err
+	}
+	if _, err := io.Copy(file, audio); err != nil {
+		return "", err
+	}
+	if w.model != "" {
+		if err := form.WriteField("model", w.model); err != nil {
+			return "", err
+		}
+	}
+	if err := form.WriteField("response_format", "json"); err != nil {
+		return "", err
+	}
+	if err := form.Close(); err != nil {
+		return "", err
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, w.url, &body)
+	if err != nil {
+		return "", err
+	}
+	req.Header.Set("Content-Type", form.FormDataContentType())
+	if w.apiKey != "" {
+		req.Header.Set("Authorization", "Bearer "+w.apiKey)
+	}
+
+	res, err := llmHTTPClient.Do(req)
+	if err != nil {
+		return "", fmt.Errorf("whisper request failed: %w", err)
+	}
+	defer res.Body.Close()
+
+	data, err := io.ReadAll(io.LimitReader(res.Body, 1<<20))
+	if err != nil {
+		return "", fmt.Errorf("reading whisper answer: %w", err)
+	}
+	if res.StatusCode != http.StatusOK {
+		return "", fmt.Errorf("whisper answered %s: %.300s", res.Status, data)
+	}
+
+	var response struct {
+		Text string `json:"text"`
+	}
+	if err := json.Unmarshal(data, &response); err != nil {
+		return "", fmt.Errorf("invalid whisper answer: %w", err)
+	}
+	return strings.TrimSpace(response.Text), nil
+}
+
+// initWhisper switches transcription to WHISPER_URL when it is set.
+func initWhisper() {
+	endpoint := os.Getenv("WHISPER_URL")
+	if endpoint == "" {
+		return
+	}
+	parsed, err := url.Parse(endpoint)
+	if err != nil || (parsed.Scheme != "http" && parsed.Scheme != "https") || parsed.Host == "" {
+		log.Fatal("Invalid WHISPER_URL, must be an http(s) URL like http://whisper:8000/v1/audio/transcriptions")
+	}
+	llm = newWhisperLLM(llm, endpoint)
+	log.Printf("transcribing with the whisper server at %s\n", parsed.Host)
+}
@@ -0,0 +1,78 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"time"
+)
+
+// Namespaces for the two-key form of pg_advisory_lock, so locks taken for
+// different purposes on the same user never contend.
+const (
+	publishLockNamespace      int32 = 1
+	schedulerLockNamespace    int32 = 2
+	provisioningLockNamespace int32 = 3
+)
+
+// A waiter must not sit in pg_advisory_lock: it would hold a pool connection
+// while the holder's fn needs more of them, and as many waiters as the pool
+// has connections hang every request. Waiters poll with the try variant and
+// give the connection back in between.
+const (
+	lockWaitTimeout     = time.Minute
+	lockPollMinInterval = 20 * time.Millisecond
+	lockPollMaxInterval = time.Second
+)
+
+// withUserLock runs fn while holding a session-level advisory lock for the
+// user. The lock is held by Postgres, so it also serializes across instances.
+// It waits up to lockWaitTimeout for another holder to finish.
+func withUserLock(ctx context.Context, namespace int32, userID int, fn func() error) error {
+	waitCtx, cancel := context.WithTimeout(ctx, lockWaitTimeout)
+	defer cancel()
+
+	interval := lockPollMinInterval
+	for {
+		ran, err := tryWithUserLock(ctx, namespace, userID, fn)
+		if err != nil || ran {
+			return err
+		}
+
+		select {
+		case <-waitCtx.Done():
+			return fmt.Errorf("timed out waiting for advisory lock of user %d: %w", userID, waitCtx.Err())
+		case <-time.After(interval):
+		}
+		interval = min(2*interval, lockPollMaxInterval)
+	}
+}
+
+// tryWithUserLock runs fn only if no other session holds the lock and reports
+// whether it ran.
+func tryWithUserLock(ctx context.Context, namespace int32, userID int, fn func() error) (bool, error) {
+	conn, err := pool.Acquire(ctx)
+	if err != nil {
+		return false, fmt.Errorf("failed to acquire connection for lock: %w", err)
+	}
+	defer conn.Release()
+
+	var acquired bool
+	err = conn.QueryRow(ctx, "SELECT pg_try_advisory_lock($1, $2)", namespace, int32(userID)).Scan(&acquired)
+	if err != nil {
+		return false, fmt.Errorf("failed to take advisory lock: %w", err)
+	}
+	if !acquired {
+		return false, nil
+	}
+	defer func() {
+		_, err := conn.Exec(context.Background(), "SELECT pg_advisory_unlock($1, $2)", namespace, int32(userID))
+		if err != nil {
+			// closing the session is the only other way to drop the lock
+			log.Printf("Failed to release advisory lock for user %d: %v\n", userID, err)
+			_ = conn.Conn().Close(context.Background())
+		}
+	}()
+
+	return true, fn()
+}
@@ -0,0 +1,161 @@
+package main
+
+import (
+	"bytes"
+	"io"
+	"log"
+	"math/rand/v2"
+	"net/http"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+)
+
+// LOG_LEVEL=debug adds request body samples to the request log. Bodies are
+// never logged at the default level, they hold recipes, voice messages and
+// whole chat exports.
+var (
+	debugLogging bool
+
+	// logBodyLimit caps a body sample, LOG_BODY_LIMIT in bytes.
+	logBodyLimit = 2048
+
+	// logBodySampleRate is the share of requests that get a body sample,
+	// LOG_BODY_SAMPLE_RATE between 0 and 1.
+	logBodySampleRate = 1.0
+)
+
+// redactedHeaders carry credentials or session state and are logged as
+// [redacted], redactedParams are the query parameters that do.
+var (
+	redactedHeaders = []string{
+		"Authorization", "Cookie", "Set-Cookie", csrfHeader, inviteCodeHeader, sessionHeader, "X-Api-Key",
+		"X-Ms-Token-Aad-Id-Token", "X-Ms-Token-Aad-Access-Token", "X-Ms-Client-Principal",
+	}
+	redactedParams = []string{"key", "token", "code", "signature"}
+)
+
+// redactedPaths are the routes with a secret in the path, the path is logged
+// as given here. The calendar file name is the user's feed token.
+var redactedPaths = map[string]string{
+	"GET /calendar/{file}": "/calendar/[redacted]",
+}
+
+// unloggedBodies are the routes whose request body is never sampled: uploads
+// are large and binary, inbound mail is someone else's mail and link codes
+// take over accounts.
+var unloggedBodies = map[string]bool{
+	"/api/v1/generate/by-image":          true,
+	"POST /api/v1/generate/by-voice":     true,
+	"POST /api/v1/recipes/find-by-image": true,
+	"POST /api/v1/recipes/{id}/sessions": true,
+	"POST /api/v1/inbound/mailgun":       true,
+	"POST /api/v1/import/whatsapp":       true,
+	"POST /api/v1/account/link":          true,
+}
+
+func initLogging() {
+	debugLogging = strings.EqualFold(os.Getenv("LOG_LEVEL"), "debug")
+
+	if value := os.Getenv("LOG_BODY_LIMIT"); value != "" {
+		limit, err := strconv.Atoi(value)
+		if err != nil || limit < 0 {
+			log.Fatalf("Invalid LOG_BODY_LIMIT %q\n", value)
+		}
+		logBodyLimit = limit
+	}
+	if value := os.Getenv("LOG_BODY_SAMPLE_RATE"); value != "" {
+		rate, err := strconv.ParseFloat(value, 64)
+		if err != nil || rate < 0 || rate > 1 {
+			log.Fatalf("Invalid LOG_BODY_SAMPLE_RATE %q, must be between 0 and 1\n", value)
+		}
+		logBodySampleRate = rate
+	}
+}
+
+func debugf(format string, args ...any) {
+	if debugLogging {
+		log.Printf(format, args...)
+	}
+}
+
+// redactHeaders copies the headers with credentials replaced.
+func redactHeaders(header http.Header) http.Header {
+	redacted := header.Clone()
+	for _, name := range redactedHeaders {
+		if _, ok := redacted[http.CanonicalHeaderKey(name)]; ok {
+			redacted.Set(name, "[redacted]")
+		}
+	}
+	return redacted
+}
+
+// redactURL hides API keys and tokens passed as query parameters, and the
+// path of the routes in redactedPaths.
+func redactURL(u *url.URL, pattern string) string {
+	redacted := *u
+	if path, found := redactedPaths[pattern]; found {
+		redacted.Path, redacted.RawPath = path, path
+	}
+	if u.RawQuery == "" {
+		return redacted.String()
+	}
+	query := u.Query()
+	for _, name := range redactedParams {
+		if query.Has(name) {
+			query.Set(name, "[redacted]")
+		}
+	}
+	redacted.RawQuery = query.Encode()
+	return redacted.String()
+}
+
+// bodySample reads up to logBodyLimit bytes of the body and puts them back in
+// front of the rest, the handler still reads the whole body. Only text bodies
+// of routes that didn't opt out are sampled, and only at debug level.
+func bodySample(r *http.Request, pattern string) (string, bool) {
+	if !debugLogging || logBodyLimit == 0 || r.Body == nil || r.Body == http.NoBody || unloggedBodies[pattern] {
+		return "", false
+	}
+	if !textContent(r.Header.Get("Content-Type")) || rand.Float64() >= logBodySampleRate {
+		return "", false
+	}
+
+	sample, err := io.ReadAll(io.LimitReader(r.Body, int64(logBodyLimit)+1))
+	r.Body = readCloser{io.MultiReader(bytes.NewReader(sample), r.Body), r.Body}
+	if err != nil {
+		return "", false
+	}
+	if len(sample) > logBodyLimit {
+		return string(sample[:logBodyLimit]) + "…", true
+	}
+	return string(sample), true
+}
+
+func textContent(contentType string) bool {
+	contentType = strings.ToLower(contentType)
+	return contentType == "" || strings.HasPrefix(contentType, "text/") || strings.Contains(contentType, "json") ||
+		strings.HasPrefix(contentType, "application/x-www-form-urlencoded")
+}
+
+// readCloser reads the restored body and closes the original one.
+type readCloser struct {
+	io.Reader
+	io.Closer
+}
+
+// logRequests logs every request with redacted headers. routes resolves the
+// pattern the request is going to, for the per-route body opt-out.
+func logRequests(routes *http.ServeMux, next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, pattern := routes.Handler(r)
+		log.Printf("Received request: Method=%s, URL=%s, Headers=%v, RemoteAddr=%s, ClientIP=%s",
+			r.Method, redactURL(r.URL, pattern), redactHeaders(r.Header), r.RemoteAddr, clientIP(r.Context()))
+
+		if sample, ok := bodySample(r, pattern); ok {
+			log.Printf("Request body: Method=%s, Path=%s, Body=%q", r.Method, r.URL.Path, sample)
+		}
+		next.ServeHTTP(w, r)
+	})
+}
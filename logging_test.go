@@ -0,0 +1,27 @@
+package main
+
+import (
+	"net/url"
+	"testing"
+)
+
+func TestRedactURL(t *testing.T) {
+	tests := []struct {
+		raw     string
+		pattern string
+		want    string
+	}{
+		{"/api/v1/recipes", "GET /api/v1/recipes", "/api/v1/recipes"},
+		{"/api/v1/clip?url=https%3A%2F%2Fexample.com&key=rk_secret", "GET /api/v1/clip", "/api/v1/clip?key=%5Bredacted%5D&url=https%3A%2F%2Fexample.com"},
+		{"/calendar/0123abcd.ics", "GET /calendar/{file}", "/calendar/[redacted]"},
+	}
+	for _, tt := range tests {
+		u, err := url.Parse(tt.raw)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got := redactURL(u, tt.pattern); got != tt.want {
+			t.Errorf("redactURL(%q) = %q, want %q", tt.raw, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,74 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"mime"
+	"net/smtp"
+	"os"
+	"strings"
+)
+
+type mailer interface {
+	Send(to string, subject string, body string) error
+}
+
+// smtpMailer works with any provider offering SMTP submission
+// (SendGrid, Mailgun, SES, ...) configured via the SMTP_* variables.
+type smtpMailer struct {
+	host     string
+	port     string
+	username string
+	password string
+	from     string
+}
+
+// logMailer is used when no SMTP server is configured, so notifications can be
+// developed without sending real mail.
+type logMailer struct{}
+
+func newMailer() mailer {
+	host := os.Getenv("SMTP_HOST")
+	if host == "" {
+		return logMailer{}
+	}
+
+	port := os.Getenv("SMTP_PORT")
+	if port == "" {
+		port = "587"
+	}
+
+	return smtpMailer{
+		host:     host,
+		port:     port,
+		username: os.Getenv("SMTP_USERNAME"),
+		password: os.Getenv("SMTP_PASSWORD"),
+		from:     os.Getenv("SMTP_FROM"),
+	}
+}
+
+func (m smtpMailer) Send(to string, subject string, body string) error {
+	var auth smtp.Auth
+	if m.username != "" {
+		auth = smtp.PlainAuth("", m.username, m.password, m.host)
+	}
+
+	message := "From: " + m.from + "\r\n" +
+		"To: " + to + "\r\n" +
+		"Subject: " + mime.QEncoding.Encode("utf-8", subject) + "\r\n" +
+		"MIME-Version: 1.0\r\n" +
+		"Content-Type: text/plain; charset=utf-8\r\n" +
+		"\r\n" +
+		strings.ReplaceAll(body, "\n", "\r\n")
+
+	err := smtp.SendMail(m.host+":"+m.port, auth, m.from, []string{to}, []byte(message))
+	if err != nil {
+		return fmt.Errorf("failed to send mail to %s: %w", to, err)
+	}
+	return nil
+}
+
+func (logMailer) Send(to string, subject string, body string) error {
+	log.Printf("SMTP_HOST not set, not sending mail %q to %s", subject, to)
+	return nil
+}
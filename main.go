@@ -7,12 +7,14 @@ import (
 	"encoding/base64"
 	"encoding/json"
 	"errors"
+	"flag"
 	"fmt"
 	"io"
 	"log"
 	"mime/multipart"
 	"net/http"
 	"os"
+	"strconv"
 	"strings"
 	"time"
 
@@ -20,9 +22,6 @@ import (
 	"github.com/golang-jwt/jwt/v4"
 	"github.com/jackc/pgx/v5"
 	"github.com/jackc/pgx/v5/pgxpool"
-	"github.com/openai/openai-go"
-	"github.com/openai/openai-go/option"
-	goopenai "github.com/sashabaranov/go-openai"
 )
 
 const (
@@ -36,7 +35,7 @@ const (
 		"- <Schritte>\n" +
 		"### <Anweisung> z.B. Backen/Braten\n" +
 		"- <Scritte>\n" +
-		"Alle Zutaten müssen in metrischen Einheiten angegeben werden."
+		germanMetricUnits
 
 	englishSystemMessage = "You are an agent that changes the format recipes" +
 		"The recipe needs to be in markdown format: " +
@@ -45,7 +44,12 @@ const (
 		"- Steps\n" +
 		"### Instructionset 2" +
 		"- Steps\n" +
-		"All ingredients need to be in metric units."
+		englishMetricUnits
+
+	germanMetricUnits    = "Alle Zutaten müssen in metrischen Einheiten angegeben werden."
+	germanImperialUnits  = "Alle Zutaten müssen in amerikanischen Einheiten (cups, oz, lb, °F) angegeben werden."
+	englishMetricUnits   = "All ingredients need to be in metric units."
+	englishImperialUnits = "All ingredients need to be in US customary units (cups, oz, lb, °F)."
 
 	judgeSystemMessage = "You are a judge AI agent that decides whether input is related to cooking or not."
 )
@@ -61,34 +65,84 @@ type RecipeRequest struct {
 	Recipe         string `json:"recipe"`
 	IsGerman       bool   `json:"isGerman"`
 	RecipeCategory string `json:"recipecategory,omitempty"`
+	RecipeTiming
+	// SourceURL links the recipe to the page it was imported from, see
+	// archiveSource.
+	SourceURL string `json:"sourceURL,omitempty"`
+	// Draft saves the recipe without publishing it, left out it follows the
+	// publishOnSave setting.
+	Draft *bool `json:"draft,omitempty"`
+	// ParentRecipeID marks the recipe as a modified copy of one of the
+	// user's recipes or a community recipe.
+	ParentRecipeID int `json:"parentRecipeID,omitempty"`
 }
 
 type RecipeGenerateRequest struct {
 	RecipeDescription string `json:"recipedescription"`
 	IsGerman          bool   `json:"isGerman"`
+	OvenSettings
 }
 
 type RecipeLinkRequest struct {
 	URL      string `json:"url"`
 	IsGerman bool   `json:"isGerman"`
+	// Multiple returns all recipes of the page as RecipeCandidates.
+	Multiple bool `json:"multiple"`
+	OvenSettings
 }
 
 type RecipeImageRequest struct {
 	Recipename string `json:"recipename"`
 	IsGerman   bool   `json:"isGerman"`
+	OvenSettings
 }
 
 type RecipeChangeRequest struct {
 	Recipe       string `json:"recipe"`
 	ChangePrompt string `json:"changePrompt"`
+	// RecipeID edits the stored recipe, the result is kept as a pending
+	// revision instead of being returned for the client to save.
+	RecipeID int `json:"recipeID,omitempty"`
 }
 
 type Recipe struct {
-	Recipename string `json:"recipename"`
-	Recipe     string `json:"recipe"`
-	ID         int    `json:"id"`
-	Transcript string `json:"transcript,omitempty"`
-	Category   string `json:"category,omitempty"`
+	Recipename string     `json:"recipename"`
+	Recipe     string     `json:"recipe"`
+	ID         int        `json:"id"`
+	Transcript string     `json:"transcript,omitempty"`
+	Category   string     `json:"category,omitempty"`
+	Slug       string     `json:"slug,omitempty"`
+	Version    int        `json:"version,omitempty"`
+	UpdatedAt  *time.Time `json:"updatedAt,omitempty"`
+	CreatedAt  *time.Time `json:"createdAt,omitempty"`
+	SeasonTags []string   `json:"seasonTags,omitempty"`
+	Archived   bool       `json:"archived,omitempty"`
+	// Draft recipes are saved but not on the published site until
+	// HandlePublishRecipe.
+	Draft      bool       `json:"draft,omitempty"`
+	FeaturedAt *time.Time `json:"featuredAt,omitempty"`
+	Cuisine    string     `json:"cuisine,omitempty"`
+	// Diet is vegan, vegetarian or contains-meat, empty until the recipe is
+	// classified.
+	Diet string `json:"diet,omitempty"`
+	RecipeTiming
+	// Language and TranslationOf are set on translated copies only.
+	Language      string `json:"language,omitempty"`
+	TranslationOf int    `json:"translationOf,omitempty"`
+	// VariantOf and Appliance are set on appliance variants only.
+	VariantOf int    `json:"variantOf,omitempty"`
+	Appliance string `json:"appliance,omitempty"`
+	// ParentRecipeID is the recipe this one is based on, see
+	// HandleGetRecipeLineage.
+	ParentRecipeID int    `json:"parentRecipeID,omitempty"`
+	SourceURL      string `json:"sourceURL,omitempty"`
+	// PublishedAt is the last time the recipe's page was uploaded,
+	// PublishError why the last upload of the page or the index failed.
+	PublishedAt  *time.Time `json:"publishedAt,omitempty"`
+	PublishError string     `json:"publishError,omitempty"`
+	// URL is the published page, returned by the add, update and delete
+	// endpoints.
+	URL string `json:"url,omitempty"`
 }
 
 type AuthContext struct {
@@ -105,48 +159,328 @@ type UserContext struct {
 	FullName  string
 	Provider  string
 	Subdomain string
+	// via records how the request was authenticated, see audit.go.
+	via string
 }
 
 func main() {
+	mock := flag.Bool("mock", false, "serve the API with canned data and no external dependencies")
+	flag.Parse()
+
+	if *mock {
+		runMockServer()
+		return
+	}
+
 	mux := http.NewServeMux()
 
+	initTestMode()
+	initSecrets()
+	initCache()
+	initLLM()
+	initLogging()
+	initCSRF()
+	initSessionTokens()
+	initTrustedProxies()
+	initStorageLifecycle()
+
 	if !validateEnvVars() {
 		log.Fatal("Missing environment variables")
 		return
 	}
 
-	initJWKS()
+	if !testMode() {
+		initJWKS()
+	}
 	initDBPool()
+	migrateDB()
+	backfillRecipeSlugs()
 
+	registerRoutes(mux)
+
+	go runScheduler()
+	log.Println("Server is running on port 8080")
+	log.Fatal(http.ListenAndServe(":8080", withClientIP(withCORS(withCompression(logRequests(mux, withCSRF(mux)))))))
+}
+
+// registerRoutes adds the API to mux, handler tests register it on a mux
+// that records the patterns.
+func registerRoutes(mux routeMux) {
 	mux.HandleFunc("/health", HandleHealth)
 
-	mux.HandleFunc("/api/v1/generate/by-description", HandlerJudgeMiddleware(HandleGenerateByDescription))
+	mux.HandleFunc("GET /healthz", HandleHealth)
+
+	mux.HandleFunc("GET /readyz", HandleReady)
+
+	mux.HandleFunc("GET /api/v1/csrf-token", HandleGetCSRFToken)
+
+	mux.HandleFunc("/api/v1/generate/by-description", RequireAuth(LoginMiddleware(GenerationLimitMiddleware(HandlerJudgeMiddleware(HandleGenerateByDescription)))))
 
-	mux.HandleFunc("/api/v1/generate/by-link", HandleGenerateByLink)
+	mux.HandleFunc("/api/v1/generate/by-link", RequireAuth(LoginMiddleware(GenerationLimitMiddleware(HandleGenerateByLink))))
 
-	mux.HandleFunc("/api/v1/generate/by-image", HandleGenerateByImage)
+	mux.HandleFunc("POST /api/v1/generate/by-text", RequireAuth(LoginMiddleware(GenerationLimitMiddleware(HandleGenerateByText))))
 
-	mux.HandleFunc("POST /api/v1/generate/by-voice", HandleGenerateRecipeByVoice)
+	mux.HandleFunc("/api/v1/generate/by-image", RequireAuth(LoginMiddleware(RequireFeature(featureImageImport, GenerationLimitMiddleware(HandleGenerateByImage)))))
+
+	mux.HandleFunc("POST /api/v1/generate/by-voice", RequireAuth(LoginMiddleware(RequireFeature(featureVoiceImport, GenerationLimitMiddleware(HandleGenerateRecipeByVoice)))))
+
+	mux.HandleFunc("GET /api/v1/transcribe/stream", tokenFromQuery(RequireAuth(LoginMiddleware(RequireFeature(featureVoiceImport, HandleTranscriptionStream)))))
+
+	mux.HandleFunc("POST /api/v1/generate/menu", RequireAuth(LoginMiddleware(GenerationLimitMiddleware(HandleGenerateMenu))))
+
+	mux.HandleFunc("POST /api/v1/parse/ingredients", RequireAuth(LoginMiddleware(HandleParseIngredients)))
+
+	mux.HandleFunc("POST /api/v1/feedback", RequireAuth(LoginMiddleware(HandleAddFeedback)))
+
+	mux.HandleFunc("GET /api/v1/login", RequireAuth(LoginMiddleware(HandleLogin)))
 
 	mux.HandleFunc("GET /api/v1/user-info", RequireAuth(LoginMiddleware(HandleGetUserInfo)))
 
 	mux.HandleFunc("GET /api/v1/get-recipes", RequireAuth(LoginMiddleware(HandleGetRecipes)))
 
-	mux.HandleFunc("POST /api/v1/add-recipe", RequireAuth(LoginMiddleware(HandleAddRecipe)))
+	mux.HandleFunc("POST /api/v1/add-recipe", RequireAuth(LoginMiddleware(RecipeLimitMiddleware(HandleAddRecipe))))
 
 	mux.HandleFunc("DELETE /api/v1/delete-recipe", RequireAuth(LoginMiddleware(HandleDeleteRecipe)))
 
 	mux.HandleFunc("PATCH /api/v1/update-recipe", RequireAuth(LoginMiddleware(HandleUpdateRecipe)))
 
-	mux.HandleFunc("POST /api/v1/update-recipe", RequireAuth(LoginMiddleware(HandleReprompt)))
+	mux.HandleFunc("POST /api/v1/recipes/bulk/delete", RequireAuth(LoginMiddleware(HandleBulkDeleteRecipes)))
 
-	log.Println("Server is running on port 8080")
-	log.Fatal(http.ListenAndServe(":8080", withCORS(logRequests(mux))))
+	mux.HandleFunc("GET /api/v1/categories", RequireAuth(LoginMiddleware(HandleGetCategories)))
+
+	mux.HandleFunc("PUT /api/v1/categories", RequireAuth(LoginMiddleware(HandleUpdateCategories)))
+
+	mux.HandleFunc("POST /api/v1/recipes/bulk/category", RequireAuth(LoginMiddleware(HandleBulkMoveRecipes)))
+
+	mux.HandleFunc("POST /api/v1/recipes/bulk/collection", RequireAuth(LoginMiddleware(HandleBulkCollectRecipes)))
+
+	mux.HandleFunc("POST /api/v1/recipes/merge", RequireAuth(LoginMiddleware(HandleMergeRecipes)))
+
+	mux.HandleFunc("GET /api/v1/recipes/duplicates", RequireAuth(LoginMiddleware(HandleFindDuplicates)))
+
+	mux.HandleFunc("POST /api/v1/update-recipe", RequireAuth(LoginMiddleware(GenerationLimitMiddleware(HandleReprompt))))
+
+	mux.HandleFunc("POST /api/v1/recipes/reclassify", RequireAuth(LoginMiddleware(GenerationLimitMiddleware(HandleReclassifyRecipes))))
+
+	mux.HandleFunc("POST /api/v1/recipes/find-by-image", RequireAuth(LoginMiddleware(RequireFeature(featureImageImport, GenerationLimitMiddleware(HandleFindRecipeByImage)))))
+
+	mux.HandleFunc("POST /api/v1/recipes/convert-appliance", RequireAuth(LoginMiddleware(RecipeLimitMiddleware(GenerationLimitMiddleware(HandleConvertAppliance)))))
+
+	mux.HandleFunc("POST /api/v1/recipes/{id}/veganize", RequireAuth(LoginMiddleware(RecipeLimitMiddleware(GenerationLimitMiddleware(HandleVeganizeRecipe)))))
+
+	mux.HandleFunc("GET /api/v1/recipes/{id}", RequireAuth(LoginMiddleware(HandleGetRecipe)))
+
+	mux.HandleFunc("POST /api/v1/recipes/{id}/republish", RequireAuth(LoginMiddleware(HandleRepublishRecipe)))
+
+	mux.HandleFunc("GET /api/v1/recipes/{id}/schedule", RequireAuth(LoginMiddleware(HandleGetRecipeSchedule)))
+
+	mux.HandleFunc("GET /api/v1/recipes/{id}/revisions", RequireAuth(LoginMiddleware(HandleListRevisions)))
+
+	mux.HandleFunc("POST /api/v1/recipes/{id}/revisions/{revisionID}/accept", RequireAuth(LoginMiddleware(HandleAcceptRevision)))
+
+	mux.HandleFunc("POST /api/v1/recipes/{id}/revisions/{revisionID}/reject", RequireAuth(LoginMiddleware(HandleRejectRevision)))
+
+	mux.HandleFunc("POST /api/v1/recipes/{id}/presets/{preset}", RequireAuth(LoginMiddleware(GenerationLimitMiddleware(HandleApplyRecipePreset))))
+
+	mux.HandleFunc("POST /api/v1/recipes/{id}/ask", RequireAuth(LoginMiddleware(GenerationLimitMiddleware(HandleAskRecipe))))
+
+	mux.HandleFunc("GET /api/v1/recipes/{id}/ask/{sessionID}", RequireAuth(LoginMiddleware(HandleGetAskSession)))
+
+	mux.HandleFunc("POST /api/v1/recipes/{id}/archive", RequireAuth(LoginMiddleware(HandleArchiveRecipe)))
+
+	mux.HandleFunc("POST /api/v1/recipes/{id}/unarchive", RequireAuth(LoginMiddleware(HandleUnarchiveRecipe)))
+
+	mux.HandleFunc("POST /api/v1/recipes/{id}/publish", RequireAuth(LoginMiddleware(HandlePublishRecipe)))
+
+	mux.HandleFunc("POST /api/v1/recipes/{id}/feature", RequireAuth(LoginMiddleware(HandleFeatureRecipe)))
+
+	mux.HandleFunc("POST /api/v1/recipes/{id}/unfeature", RequireAuth(LoginMiddleware(HandleUnfeatureRecipe)))
+
+	mux.HandleFunc("POST /api/v1/recipes/{id}/share", RequireAuth(LoginMiddleware(HandleShareRecipe)))
+
+	mux.HandleFunc("POST /api/v1/recipes/{id}/unshare", RequireAuth(LoginMiddleware(HandleUnshareRecipe)))
+
+	mux.HandleFunc("GET /api/v1/recipes/{id}/lineage", RequireAuth(LoginMiddleware(HandleGetRecipeLineage)))
+
+	mux.HandleFunc("GET /api/v1/community/recipes", RequireAuth(LoginMiddleware(HandleGetCommunityRecipes)))
+
+	mux.HandleFunc("GET /api/v1/community/recipes/{id}", RequireAuth(LoginMiddleware(HandleGetCommunityRecipe)))
+
+	mux.HandleFunc("POST /api/v1/community/recipes/{id}/fork", RequireAuth(LoginMiddleware(RecipeLimitMiddleware(HandleForkCommunityRecipe))))
+
+	mux.HandleFunc("GET /api/v1/recipes/{id}/qr", RequireAuth(LoginMiddleware(HandleGetRecipeQR)))
+
+	mux.HandleFunc("GET /api/v1/recipes/{id}/sessions", RequireAuth(LoginMiddleware(HandleListCookingSessions)))
+
+	mux.HandleFunc("POST /api/v1/recipes/{id}/sessions", RequireAuth(LoginMiddleware(HandleAddCookingSession)))
+
+	mux.HandleFunc("DELETE /api/v1/recipes/{id}/sessions/{sessionID}", RequireAuth(LoginMiddleware(HandleDeleteCookingSession)))
+
+	mux.HandleFunc("GET /api/v1/recipes/{id}/source", RequireAuth(LoginMiddleware(HandleGetRecipeSource)))
+
+	mux.HandleFunc("POST /api/v1/recipes/{id}/translate", RequireAuth(LoginMiddleware(RecipeLimitMiddleware(GenerationLimitMiddleware(HandleTranslateRecipe)))))
+
+	mux.HandleFunc("GET /api/v1/recipes/{id}/pairings", RequireAuth(LoginMiddleware(GenerationLimitMiddleware(HandleGetPairings))))
+
+	mux.HandleFunc("GET /api/v1/collections", RequireAuth(LoginMiddleware(HandleListCollections)))
+
+	mux.HandleFunc("POST /api/v1/collections", RequireAuth(LoginMiddleware(HandleAddCollection)))
+
+	mux.HandleFunc("GET /api/v1/collections/{id}", RequireAuth(LoginMiddleware(HandleGetCollection)))
+
+	mux.HandleFunc("PATCH /api/v1/collections/{id}", RequireAuth(LoginMiddleware(HandleUpdateCollection)))
+
+	mux.HandleFunc("DELETE /api/v1/collections/{id}", RequireAuth(LoginMiddleware(HandleDeleteCollection)))
+
+	mux.HandleFunc("PUT /api/v1/collections/{id}/recipes/{recipeID}", RequireAuth(LoginMiddleware(HandleAddCollectionRecipe)))
+
+	mux.HandleFunc("DELETE /api/v1/collections/{id}/recipes/{recipeID}", RequireAuth(LoginMiddleware(HandleRemoveCollectionRecipe)))
+
+	mux.HandleFunc("GET /api/v1/collections/{id}/pdf", RequireAuth(LoginMiddleware(HandleGetCollectionPDF)))
+
+	mux.HandleFunc("GET /api/v1/seasonal", RequireAuth(LoginMiddleware(HandleGetSeasonal)))
+
+	mux.HandleFunc("GET /api/v1/stats", RequireAuth(LoginMiddleware(HandleGetStats)))
+
+	mux.HandleFunc("GET /api/v1/meal-plan", RequireAuth(LoginMiddleware(HandleGetMealPlan)))
+
+	mux.HandleFunc("POST /api/v1/meal-plan", RequireAuth(LoginMiddleware(HandleAddMealPlanEntry)))
+
+	mux.HandleFunc("DELETE /api/v1/meal-plan/{id}", RequireAuth(LoginMiddleware(HandleDeleteMealPlanEntry)))
+
+	mux.HandleFunc("GET /api/v1/meal-plan/calendar", RequireAuth(LoginMiddleware(HandleGetCalendarURL)))
+
+	mux.HandleFunc("GET /calendar/{file}", HandleCalendarFeed)
+
+	mux.HandleFunc("GET /api/v1/public/{subdomain}/recipes/{slug}", HandleGetPublicRecipe)
+
+	mux.HandleFunc("POST /api/v1/mcp", RequireAuth(LoginMiddleware(HandleMCP)))
+
+	mux.HandleFunc("POST /api/v1/assistant/alexa", HandleAlexa)
+
+	mux.HandleFunc("POST /api/v1/assistant/google", HandleGoogleAssistant)
+
+	mux.HandleFunc("DELETE /api/v1/account", RequireAuth(LoginMiddleware(HandleDeleteAccount)))
+
+	mux.HandleFunc("GET /api/v1/storage/sas", RequireAuth(LoginMiddleware(HandleGetStorageSAS)))
+
+	mux.HandleFunc("GET /u/{subdomain}/{path...}", HandleServeSite)
+
+	mux.HandleFunc("GET /api/v1/plan", RequireAuth(LoginMiddleware(HandleGetPlan)))
+
+	mux.HandleFunc("GET /api/v1/storage/usage", RequireAuth(LoginMiddleware(HandleGetStorageUsage)))
+
+	mux.HandleFunc("GET /api/v1/openai-key", RequireAuth(LoginMiddleware(HandleGetOpenAIKey)))
+
+	mux.HandleFunc("PUT /api/v1/openai-key", RequireAuth(LoginMiddleware(HandleSetOpenAIKey)))
+
+	mux.HandleFunc("PATCH /api/v1/openai-key", RequireAuth(LoginMiddleware(HandleToggleOpenAIKey)))
+
+	mux.HandleFunc("DELETE /api/v1/openai-key", RequireAuth(LoginMiddleware(HandleDeleteOpenAIKey)))
+
+	mux.HandleFunc("GET /api/v1/webhooks", RequireAuth(LoginMiddleware(HandleListWebhooks)))
+
+	mux.HandleFunc("POST /api/v1/webhooks", RequireAuth(LoginMiddleware(HandleAddWebhook)))
+
+	mux.HandleFunc("DELETE /api/v1/webhooks/{id}", RequireAuth(LoginMiddleware(HandleDeleteWebhook)))
+
+	mux.HandleFunc("GET /api/v1/audit", RequireAuth(LoginMiddleware(HandleGetAudit)))
+
+	mux.HandleFunc("GET /api/v1/api-keys", RequireAuth(LoginMiddleware(HandleListAPIKeys)))
+
+	mux.HandleFunc("POST /api/v1/api-keys", RequireAuth(LoginMiddleware(HandleAddAPIKey)))
+
+	mux.HandleFunc("DELETE /api/v1/api-keys/{id}", RequireAuth(LoginMiddleware(HandleDeleteAPIKey)))
+
+	mux.HandleFunc("GET /api/v1/clip", RequireAPIKey(HandleClip))
+
+	mux.HandleFunc("GET /api/v1/account/identities", RequireAuth(LoginMiddleware(HandleListIdentities)))
+
+	mux.HandleFunc("POST /api/v1/account/link-code", RequireAuth(LoginMiddleware(HandleCreateLinkCode)))
+
+	mux.HandleFunc("POST /api/v1/account/link", RequireAuth(LoginMiddleware(HandleLinkAccount)))
+
+	mux.HandleFunc("GET /api/v1/inbound-email", RequireAuth(LoginMiddleware(HandleGetInboundAddress)))
+
+	mux.HandleFunc("POST /api/v1/inbound/mailgun", HandleMailgunInbound)
+
+	mux.HandleFunc("POST /api/v1/import/whatsapp", RequireAuth(LoginMiddleware(HandleImportWhatsApp)))
+
+	mux.HandleFunc("GET /api/v1/judge/rejections", RequireAuth(LoginMiddleware(HandleGetJudgeRejections)))
+
+	mux.HandleFunc("POST /api/v1/judge/rejections/{id}/appeal", RequireAuth(LoginMiddleware(HandleAppealJudgeRejection)))
+
+	mux.HandleFunc("GET /api/v1/index-settings", RequireAuth(LoginMiddleware(HandleGetIndexSettings)))
+
+	mux.HandleFunc("PUT /api/v1/index-settings", RequireAuth(LoginMiddleware(HandleUpdateIndexSettings)))
+
+	mux.HandleFunc("GET /api/v1/site/dead-links", RequireAuth(LoginMiddleware(HandleCheckDeadLinks)))
+
+	mux.HandleFunc("POST /api/v1/site/dead-links/repair", RequireAuth(LoginMiddleware(HandleCheckDeadLinks)))
+
+	mux.HandleFunc("GET /api/v1/site-indexing", RequireAuth(LoginMiddleware(HandleGetSiteIndexing)))
+
+	mux.HandleFunc("PUT /api/v1/site-indexing", RequireAuth(LoginMiddleware(HandleUpdateSiteIndexing)))
+
+	mux.HandleFunc("GET /api/v1/notifications", RequireAuth(LoginMiddleware(HandleGetNotificationSettings)))
+
+	mux.HandleFunc("PUT /api/v1/notifications", RequireAuth(LoginMiddleware(HandleUpdateNotificationSettings)))
+
+	mux.HandleFunc("GET /api/v1/settings", RequireAuth(LoginMiddleware(HandleGetSettings)))
+
+	mux.HandleFunc("PUT /api/v1/settings", RequireAuth(LoginMiddleware(HandleUpdateSettings)))
+
+	mux.HandleFunc("POST /api/v1/email/shopping-list", RequireAuth(LoginMiddleware(HandleEmailShoppingList)))
+
+	mux.HandleFunc("GET /api/v1/admin/db-stats", RequireAuth(LoginMiddleware(RequireAdmin(HandleDBStats))))
+
+	mux.HandleFunc("GET /api/v1/admin/jobs", RequireAuth(LoginMiddleware(RequireAdmin(HandleListJobs))))
+
+	mux.HandleFunc("GET /api/v1/admin/storage-usage", RequireAuth(LoginMiddleware(RequireAdmin(HandleListStorageUsage))))
+
+	mux.HandleFunc("PUT /api/v1/admin/users/{id}/plan", RequireAuth(LoginMiddleware(RequireAdmin(HandleSetUserPlan))))
+
+	mux.HandleFunc("GET /api/v1/admin/invitations", RequireAuth(LoginMiddleware(RequireAdmin(HandleListInvitations))))
+
+	mux.HandleFunc("POST /api/v1/admin/invitations", RequireAuth(LoginMiddleware(RequireAdmin(HandleAddInvitation))))
+
+	mux.HandleFunc("DELETE /api/v1/admin/invitations/{id}", RequireAuth(LoginMiddleware(RequireAdmin(HandleDeleteInvitation))))
+
+	mux.HandleFunc("GET /api/v1/admin/judge/appeals", RequireAuth(LoginMiddleware(RequireAdmin(HandleListJudgeAppeals))))
+
+	mux.HandleFunc("POST /api/v1/admin/judge/appeals/{id}/review", RequireAuth(LoginMiddleware(RequireAdmin(HandleReviewJudgeAppeal))))
+
+	mux.HandleFunc("GET /api/v1/admin/feedback", RequireAuth(LoginMiddleware(RequireAdmin(HandleGetFeedbackReport))))
+
+	mux.HandleFunc("GET /api/v1/admin/generations/{id}", RequireAuth(LoginMiddleware(RequireAdmin(HandleGetGeneration))))
+
+	mux.HandleFunc("GET /api/v1/admin/experiments", RequireAuth(LoginMiddleware(RequireAdmin(HandleListExperiments))))
+
+	mux.HandleFunc("POST /api/v1/admin/experiments", RequireAuth(LoginMiddleware(RequireAdmin(HandleAddExperiment))))
+
+	mux.HandleFunc("POST /api/v1/admin/experiments/{id}/end", RequireAuth(LoginMiddleware(RequireAdmin(HandleEndExperiment))))
+
+	mux.HandleFunc("GET /api/v1/admin/experiments/{id}/report", RequireAuth(LoginMiddleware(RequireAdmin(HandleGetExperimentReport))))
+
+	mux.HandleFunc("GET /api/v1/admin/ingredients", RequireAuth(LoginMiddleware(RequireAdmin(HandleListIngredients))))
+
+	mux.HandleFunc("PUT /api/v1/admin/ingredients/{id}", RequireAuth(LoginMiddleware(RequireAdmin(HandleUpdateIngredient))))
+}
+
+// routeMux is the part of *http.ServeMux registerRoutes needs.
+type routeMux interface {
+	HandleFunc(pattern string, handler func(http.ResponseWriter, *http.Request))
 }
 
 func initDBPool() {
-	var err error
-	pool, err = pgxpool.New(context.Background(), os.Getenv("DB_URL"))
+	dbURL, _ := lookupSecret("DB_URL")
+
+	config, err := dbPoolConfig(dbURL)
+	if err != nil {
+		log.Fatalf("Invalid DB pool configuration: %v\n", err)
+	}
+
+	pool, err = pgxpool.NewWithConfig(context.Background(), config)
 	if err != nil {
 		log.Fatalf("Unable to initialize DB pool connection: %v\n", err)
 	}
@@ -155,8 +489,9 @@ func initDBPool() {
 func withCORS(next http.Handler) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		w.Header().Set("Access-Control-Allow-Origin", os.Getenv("CORS_ORIGIN"))
-		w.Header().Set("Access-Control-Allow-Methods", "GET, POST, PATCH, DELETE")
-		w.Header().Set("Access-Control-Allow-Headers", "Content-Type, Authorization")
+		w.Header().Set("Access-Control-Allow-Methods", "GET, POST, PUT, PATCH, DELETE")
+		w.Header().Set("Access-Control-Allow-Headers", "Content-Type, Authorization, "+inviteCodeHeader+", "+csrfHeader+", "+sessionHeader)
+		w.Header().Set("Access-Control-Expose-Headers", judgeRejectionHeader+", "+generationHeader)
 
 		if r.Method == http.MethodOptions {
 			w.WriteHeader(http.StatusNoContent)
@@ -167,14 +502,6 @@ func withCORS(next http.Handler) http.Handler {
 	})
 }
 
-func logRequests(next http.Handler) http.Handler {
-	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-		log.Printf("Received request: Method=%s, URL=%s, Headers=%v, RemoteAddr=%s",
-			r.Method, r.URL.String(), r.Header, r.RemoteAddr)
-		next.ServeHTTP(w, r)
-	})
-}
-
 func RequireAuth(next http.HandlerFunc) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
 		auth := r.Header.Get("Authorization")
@@ -186,7 +513,7 @@ func RequireAuth(next http.HandlerFunc) http.HandlerFunc {
 
 		tokenStr := strings.TrimPrefix(auth, "Bearer ")
 
-		token, err := jwt.Parse(tokenStr, jwks.Keyfunc)
+		token, err := jwt.Parse(tokenStr, authKeyfunc)
 		if err != nil || !token.Valid {
 			log.Printf("Invalid token: %v", err)
 			http.Error(w, "Invalid token", http.StatusUnauthorized)
@@ -213,11 +540,14 @@ func RequireAuth(next http.HandlerFunc) http.HandlerFunc {
 
 		oauthID := fmt.Sprintf("%v", claims["sub"])
 		userName := fmt.Sprintf("%v", claims["name"])
+		// set by Keycloak for brokered logins, it tells linked identities apart
+		provider, _ := claims["identity_provider"].(string)
 
 		ctx := context.WithValue(r.Context(), "auth", AuthContext{
-			OauthID: oauthID,
-			Email:   fmt.Sprintf("%v", claims["email"]),
-			Name:    userName,
+			OauthID:  oauthID,
+			Email:    fmt.Sprintf("%v", claims["email"]),
+			Name:     userName,
+			Provider: provider,
 		})
 
 		next(w, r.WithContext(ctx))
@@ -232,10 +562,20 @@ func LoginMiddleware(next http.HandlerFunc) http.HandlerFunc {
 			return
 		}
 
-		userID, subdomain, err := Login(r.Context(), authCtx.OauthID, authCtx.Name, authCtx.Email, authCtx.Provider)
-		if err != nil {
-			http.Error(w, "Failed to initialize user: "+err.Error(), http.StatusInternalServerError)
-			return
+		var userID int
+		var subdomain string
+		if session, err := parseSessionToken(r.Context(), r.Header.Get(sessionHeader), authCtx.OauthID); err == nil {
+			userID, subdomain = session.UserID, session.Subdomain
+		} else {
+			userID, subdomain, err = Login(r.Context(), authCtx.OauthID, authCtx.Name, authCtx.Email, authCtx.Provider, r.Header.Get(inviteCodeHeader))
+			if errors.Is(err, errNotInvited) {
+				http.Error(w, "An invitation is required to sign up", http.StatusForbidden)
+				return
+			}
+			if err != nil {
+				http.Error(w, "Failed to initialize user: "+err.Error(), http.StatusInternalServerError)
+				return
+			}
 		}
 
 		userCtx := UserContext{
@@ -253,6 +593,10 @@ func LoginMiddleware(next http.HandlerFunc) http.HandlerFunc {
 }
 
 func introspectToken(token string) (bool, error) {
+	if testMode() {
+		return true, nil
+	}
+
 	client := &http.Client{Timeout: 5 * time.Second}
 
 	introspectURL := keycloakURL + "/protocol/openid-connect/token/introspect"
@@ -315,18 +659,23 @@ func HandleGetUserInfo(w http.ResponseWriter, r *http.Request) {
 }
 
 func validateEnvVars() bool {
-	_, found := os.LookupEnv("OPENAI_KEY")
-	if !found {
+	_, found := lookupSecret("OPENAI_KEY")
+	if !found && !testMode() {
 		log.Println("OPENAI_KEY environment variable  not found")
 		return false
 	}
 
-	_, found = os.LookupEnv("DB_URL")
+	_, found = lookupSecret("DB_URL")
 	if !found {
 		log.Println("DB_URL environment variable missing")
 		return false
 	}
 
+	if configuredStorageMode() == storageModeShared && sharedStorageAccount() == "" {
+		log.Println("SHARED_STORAGE_ACCOUNT environment variable missing for shared storage mode")
+		return false
+	}
+
 	return true
 }
 
@@ -369,6 +718,10 @@ func HandleGetRecipes(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	if recipesNotModified(r.Context(), w, r, userID) {
+		return
+	}
+
 	recipes, err := GetRecipes(userID)
 	if err != nil {
 		log.Printf("Error getting recipes: %v", err)
@@ -376,10 +729,58 @@ func HandleGetRecipes(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	if maxTime := r.URL.Query().Get("maxTime"); maxTime != "" {
+		minutes, err := strconv.Atoi(maxTime)
+		if err != nil || minutes <= 0 {
+			http.Error(w, "maxTime must be a positive number of minutes", http.StatusBadRequest)
+			return
+		}
+		recipes = filterRecipesByMaxTime(recipes, minutes)
+	}
+	// ?diet=vegetarian includes the vegan recipes
+	if diet := r.URL.Query().Get("diet"); diet != "" {
+		if diet != dietVegan && diet != dietVegetarian {
+			http.Error(w, "diet must be vegan or vegetarian", http.StatusBadRequest)
+			return
+		}
+		recipes = filterRecipesByDiet(recipes, diet)
+	}
+
+	includeArchived := r.URL.Query().Get("include_archived") == "true"
+	if !includeArchived {
+		recipes = withoutArchived(recipes)
+	}
+
+	// ?counts=true wraps the list with the category and tag counts of the
+	// whole collection, ?counts=only leaves the recipes out.
+	var response interface{} = recipes
+	if counts := r.URL.Query().Get("counts"); counts != "" {
+		if counts != "true" && counts != "only" {
+			http.Error(w, "counts must be true or only", http.StatusBadRequest)
+			return
+		}
+		list := RecipeList{Recipes: recipes}
+		list.Counts, err = recipeCounts(r.Context(), userID, includeArchived)
+		if err != nil {
+			log.Printf("Error counting recipes: %v", err)
+			http.Error(w, "Error counting recipes", http.StatusInternalServerError)
+			return
+		}
+		if counts == "only" {
+			list.Recipes = nil
+		}
+		response = list
+	}
+
 	w.Header().Set("Content-Type", "application/json")
 	w.WriteHeader(http.StatusOK)
 
-	err = json.NewEncoder(w).Encode(recipes)
+	// the plain list is the large one, it is streamed
+	if list, ok := response.([]Recipe); ok {
+		err = writeJSONArray(w, list)
+	} else {
+		err = json.NewEncoder(w).Encode(response)
+	}
 	if err != nil {
 		log.Printf("Error encoding JSON response: %v", err)
 		http.Error(w, "Error encoding JSON response", http.StatusInternalServerError)
@@ -404,38 +805,116 @@ func HandleAddRecipe(w http.ResponseWriter, r *http.Request) {
 		http.Error(w, "Missing recipename or recipe", http.StatusBadRequest)
 		return
 	}
-
-	if req.RecipeCategory == "" {
-		req.RecipeCategory = goopenAIgenerateRecipeCategory(req.Recipe)
+	if req.ParentRecipeID != 0 {
+		valid, err := validParentRecipe(r.Context(), userCtx.UserID, req.ParentRecipeID)
+		if err != nil {
+			log.Printf("Error checking parent recipe: %v\n", err)
+			http.Error(w, "Error adding recipe", http.StatusInternalServerError)
+			return
+		}
+		if !valid {
+			http.Error(w, "parentRecipeID must be one of your recipes or a community recipe", http.StatusBadRequest)
+			return
+		}
 	}
 
-	userID, storageaccount, err := GetUserInformation(userCtx.oauthID)
-	if err != nil {
-		http.Error(w, "Error getting user ID", http.StatusInternalServerError)
-		return
-	}
-	err = AddRecipeToDB(userID, req.Recipename, req.Recipe, req.RecipeCategory)
+	recipe, err := addRecipe(r.Context(), userCtx, req)
 	if err != nil {
+		log.Printf("Error adding recipe: %v\n", err)
 		http.Error(w, "Error adding recipe", http.StatusInternalServerError)
 		return
 	}
 
-	recipename := strings.ReplaceAll(req.Recipename, " ", "-")
-	recipePath := "recipes/" + recipename + ".md"
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("ETag", recipeETag(recipe.Version))
+	w.WriteHeader(http.StatusCreated)
 
-	err = addBlob(storageaccount, recipePath, req.Recipe)
+	err = json.NewEncoder(w).Encode(recipe)
 	if err != nil {
-		http.Error(w, "Failed to update recipe", http.StatusInternalServerError)
-		return
+		http.Error(w, "Error encoding JSON response", http.StatusInternalServerError)
+	}
+}
+
+// addRecipe fills in the category and times when they are missing, stores
+// the recipe and publishes it unless it is a draft. It returns the stored
+// recipe with its published URL.
+func addRecipe(ctx context.Context, userCtx UserContext, req RecipeRequest) (Recipe, error) {
+	if req.RecipeCategory == "" {
+		req.RecipeCategory = goopenAIgenerateRecipeCategory(ctx, req.Recipe)
 	}
-	err = templateRecipesBlob(storageaccount, userID)
+
+	if req.RecipeTiming.isEmpty() {
+		var err error
+		req.RecipeTiming, err = estimateRecipeTiming(ctx, req.Recipe)
+		if err != nil {
+			log.Printf("Error estimating recipe timing: %v\n", err)
+		}
+	}
+	req.RecipeTiming = req.RecipeTiming.normalized()
+
+	draft := false
+	if req.Draft != nil {
+		draft = *req.Draft
+	} else if settings, err := loadUserSettings(ctx, userCtx.UserID); err != nil {
+		log.Printf("Error getting settings of user %d, publishing the recipe: %v\n", userCtx.UserID, err)
+	} else {
+		draft = !settings.PublishOnSave
+	}
+
+	recipe, err := AddRecipeToDB(userCtx.UserID, req.Recipename, req.Recipe, req.RecipeCategory, req.RecipeTiming, draft)
 	if err != nil {
-		log.Printf("Error updating recipe template: %v\n", err)
-		http.Error(w, "Failed to template recipes", http.StatusInternalServerError)
-		return
+		return Recipe{}, err
+	}
+	slug := recipe.Slug
+
+	action := auditRecipeCreated
+	if req.SourceURL != "" || userCtx.via == viaEmail {
+		action = auditRecipeImported
+	}
+	after := recipeAuditSummary(req.Recipename, req.RecipeCategory, 1, req.Recipe)
+	after.Detail = req.SourceURL
+	recordAudit(ctx, userCtx, AuditEntry{Action: action, RecipeID: recipe.ID, Slug: slug, After: after})
+
+	if source, err := normalizeSourceURL(req.SourceURL); err == nil {
+		_, err = pool.Exec(ctx, "UPDATE recipes SET source_url = $1 WHERE user_id = $2 AND slug = $3", source, userCtx.UserID, slug)
+		if err != nil {
+			log.Printf("Error saving recipe source: %v\n", err)
+		} else {
+			recipe.SourceURL = source
+		}
+	}
+
+	// The parent is set before publishing, the page says what it is based on.
+	if req.ParentRecipeID != 0 {
+		_, err = pool.Exec(ctx, "UPDATE recipes SET parent_recipe_id = $1 WHERE id = $2", req.ParentRecipeID, recipe.ID)
+		if err != nil {
+			log.Printf("Error saving parent recipe: %v\n", err)
+		} else {
+			recipe.ParentRecipeID = req.ParentRecipeID
+		}
 	}
 
-	_, _ = fmt.Fprint(w, "Recipe added successfully!")
+	if !draft {
+		if err := publishRecipeBlob(userCtx.Subdomain, userCtx.UserID, slug); err != nil {
+			// the index rows would keep missing the recipe
+			invalidateRecipes(userCtx.UserID)
+			return Recipe{}, fmt.Errorf("failed to publish recipe: %w", err)
+		}
+		if err := templateRecipesBlob(userCtx.Subdomain, userCtx.UserID, recipe.ID); err != nil {
+			return Recipe{}, fmt.Errorf("failed to template recipes: %w", err)
+		}
+		recipe.URL = recipeURL(userCtx.Subdomain, slug)
+	}
+
+	emitEvent(userCtx.UserID, eventRecipeCreated, Recipe{
+		ID:           recipe.ID,
+		Recipename:   req.Recipename,
+		Category:     req.RecipeCategory,
+		Slug:         slug,
+		RecipeTiming: req.RecipeTiming,
+	})
+
+	return recipe, nil
 }
 
 func HandleDeleteRecipe(w http.ResponseWriter, r *http.Request) {
@@ -460,21 +939,52 @@ func HandleDeleteRecipe(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	err = RemoveRecipeFromDB(userCtx.UserID, recipeID)
+	// the sessions go with the recipe, their photos are looked up first
+	photos, err := sessionPhotos(r.Context(), userCtx.UserID, recipeID)
+	if err != nil {
+		log.Printf("Error getting cooking session photos: %v\n", err)
+		http.Error(w, "Error removing recipe", http.StatusInternalServerError)
+		return
+	}
+
+	// An unknown recipe is a 404, nothing is republished or announced for
+	// a recipe that was never there.
+	deleted, err := RemoveRecipeFromDB(userCtx.UserID, recipeID)
+	if errors.Is(err, pgx.ErrNoRows) {
+		http.Error(w, "Recipe not found", http.StatusNotFound)
+		return
+	}
 	if err != nil {
 		log.Printf("Error removing recipe: %v\n", err)
 		http.Error(w, "Error removing recipe", http.StatusInternalServerError)
 		return
 	}
+	recordAudit(r.Context(), userCtx, AuditEntry{
+		Action:   auditRecipeDeleted,
+		RecipeID: recipeID,
+		Slug:     deleted.Slug,
+		Before:   recipeAuditSummary(deleted.Recipename, deleted.Category, deleted.Version, deleted.Recipe),
+	})
 
-	err = templateRecipesBlob(userCtx.Subdomain, userCtx.UserID)
+	err = templateRecipesBlob(userCtx.Subdomain, userCtx.UserID, recipeID)
 	if err != nil {
 		log.Printf("Error updating recipe template: %v\n", err)
 		http.Error(w, "Error updating recipe template", http.StatusInternalServerError)
 		return
 	}
+	removeSessionPhotos(userCtx.Subdomain, userCtx.UserID, photos)
+
+	emitEvent(userCtx.UserID, eventRecipeDeleted, map[string]int{"id": recipeID})
 
+	// The content stays in the response, which lets a client undo the
+	// delete by adding it again.
+	w.Header().Set("Content-Type", "application/json")
 	w.WriteHeader(http.StatusOK)
+
+	err = json.NewEncoder(w).Encode(deleted)
+	if err != nil {
+		http.Error(w, "Error encoding JSON response", http.StatusInternalServerError)
+	}
 }
 
 func HandleUpdateRecipe(w http.ResponseWriter, r *http.Request) {
@@ -493,6 +1003,8 @@ func HandleUpdateRecipe(w http.ResponseWriter, r *http.Request) {
 		Recipename     string `json:"recipename"`
 		Recipe         string `json:"recipe"`
 		RecipeCategory string `json:"recipecategory"`
+		Version        int    `json:"version"`
+		RecipeTiming
 	}
 
 	if err := json.NewDecoder(r.Body).Decode(&updateReq); err != nil {
@@ -506,62 +1018,164 @@ func HandleUpdateRecipe(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	expectedVersion, ok := parseIfMatch(r.Header.Get("If-Match"))
+	if !ok {
+		expectedVersion = updateReq.Version
+	}
+	if expectedVersion == 0 {
+		http.Error(w, "Missing If-Match header or version", http.StatusPreconditionRequired)
+		return
+	}
+
+	var currentTitle, currentContent, currentCategory, slug string
+	var currentVersion int
+	var currentTiming RecipeTiming
+	err := pool.QueryRow(context.Background(), "SELECT title, content, category, slug, version, prep_minutes, cook_minutes, total_minutes, difficulty FROM recipes WHERE id = $1 AND user_id = $2",
+		updateReq.ID, userCtx.UserID).Scan(&currentTitle, &currentContent, &currentCategory, &slug, &currentVersion,
+		&currentTiming.PrepTime, &currentTiming.CookTime, &currentTiming.TotalTime, &currentTiming.Difficulty)
+	if err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			http.Error(w, "Recipe not found or unauthorized", http.StatusNotFound)
+			return
+		}
+		log.Printf("Error updating recipe: %v\n", err)
+		http.Error(w, "Error updating recipe", http.StatusInternalServerError)
+		return
+	}
+
+	if currentVersion != expectedVersion {
+		writeVersionConflict(w, currentVersion)
+		return
+	}
+
+	// Times the client doesn't send are kept.
+	timing := currentTiming
+	if !updateReq.RecipeTiming.isEmpty() {
+		timing = updateReq.RecipeTiming.normalized()
+	}
+
+	previousSlug := slug
+	if currentTitle != updateReq.Recipename {
+		slug, err = uniqueRecipeSlug(context.Background(), userCtx.UserID, updateReq.Recipename, updateReq.ID)
+		if err != nil {
+			log.Printf("Error generating slug: %v\n", err)
+			http.Error(w, "Error updating recipe", http.StatusInternalServerError)
+			return
+		}
+	}
+
 	query := `
         UPDATE recipes 
-        SET title = $1, content = $2, category = $3
-        WHERE id = $4 AND user_id = $5
-        RETURNING id`
-
-	var recipeID int
-	err := pool.QueryRow(context.Background(), query,
+        SET title = $1, content = $2, category = $3, slug = $4, updated_at = now(), version = version + 1, season_tagged_at = NULL, season_tag_failures = 0, diet = '',
+            prep_minutes = $8, cook_minutes = $9, total_minutes = $10, difficulty = $11
+        WHERE id = $5 AND user_id = $6 AND version = $7
+        RETURNING version, updated_at, created_at`
+
+	var newVersion int
+	var updatedAt, createdAt time.Time
+	err = pool.QueryRow(context.Background(), query,
 		updateReq.Recipename,
 		updateReq.Recipe,
 		updateReq.RecipeCategory,
+		slug,
 		updateReq.ID,
 		userCtx.UserID,
-	).Scan(&recipeID)
+		expectedVersion,
+		timing.PrepTime,
+		timing.CookTime,
+		timing.TotalTime,
+		timing.Difficulty,
+	).Scan(&newVersion, &updatedAt, &createdAt)
 
 	if err != nil {
 		if errors.Is(err, pgx.ErrNoRows) {
-			http.Error(w, "Recipe not found or unauthorized", http.StatusNotFound)
+			// changed between the version check and the update
+			writeVersionConflict(w, 0)
 			return
 		}
 		log.Printf("Error updating recipe: %v\n", err)
 		http.Error(w, "Error updating recipe", http.StatusInternalServerError)
 		return
 	}
+	// the index is patched by the publish below
+	invalidateRecipeList(userCtx.UserID)
 
-	recipename := strings.ReplaceAll(updateReq.Recipename, " ", "-")
-	recipePath := "recipes/" + recipename + ".md"
+	recordAudit(r.Context(), userCtx, AuditEntry{
+		Action:   auditRecipeUpdated,
+		RecipeID: updateReq.ID,
+		Slug:     slug,
+		Before:   recipeAuditSummary(currentTitle, currentCategory, currentVersion, currentContent),
+		After:    recipeAuditSummary(updateReq.Recipename, updateReq.RecipeCategory, newVersion, updateReq.Recipe),
+	})
 
-	if err := addBlob(userCtx.Subdomain, recipePath, updateReq.Recipe); err != nil {
+	if err := publishRecipeBlob(userCtx.Subdomain, userCtx.UserID, slug); err != nil {
+		// the index rows would keep the old state without the change
+		invalidateRecipes(userCtx.UserID)
 		log.Printf("Error updating recipe in blob storage: %v\n", err)
 		http.Error(w, "Failed to update recipe in storage", http.StatusInternalServerError)
 		return
 	}
 
-	if err := templateRecipesBlob(userCtx.Subdomain, userCtx.UserID); err != nil {
+	if slug != previousSlug {
+		if err := publishSlugRename(r.Context(), userCtx.Subdomain, userCtx.UserID, updateReq.ID, previousSlug); err != nil {
+			invalidateRecipes(userCtx.UserID)
+			log.Printf("Error redirecting renamed recipe %d: %v\n", updateReq.ID, err)
+			http.Error(w, "Failed to update recipe in storage", http.StatusInternalServerError)
+			return
+		}
+	}
+
+	if err := templateRecipesBlob(userCtx.Subdomain, userCtx.UserID, updateReq.ID); err != nil {
 		log.Printf("Error updating recipe template: %v\n", err)
 		http.Error(w, "Failed to update recipe template", http.StatusInternalServerError)
 		return
 	}
 
+	updated := Recipe{
+		ID:           updateReq.ID,
+		Recipename:   updateReq.Recipename,
+		Recipe:       updateReq.Recipe,
+		Category:     updateReq.RecipeCategory,
+		Slug:         slug,
+		Version:      newVersion,
+		UpdatedAt:    &updatedAt,
+		CreatedAt:    &createdAt,
+		RecipeTiming: timing,
+		URL:          recipeURL(userCtx.Subdomain, slug),
+	}
+	emitEvent(userCtx.UserID, eventRecipeUpdated, Recipe{
+		ID:           updated.ID,
+		Recipename:   updated.Recipename,
+		Category:     updated.Category,
+		Slug:         updated.Slug,
+		Version:      updated.Version,
+		UpdatedAt:    updated.UpdatedAt,
+		RecipeTiming: updated.RecipeTiming,
+	})
+
 	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("ETag", recipeETag(newVersion))
 	w.WriteHeader(http.StatusOK)
-	err = json.NewEncoder(w).Encode(map[string]string{
-		"message": "Recipe updated successfully",
-	})
+	err = json.NewEncoder(w).Encode(updatedRecipeResponse{Message: "Recipe updated successfully", Recipe: updated})
 	if err != nil {
 		return
 	}
 }
 
+// updatedRecipeResponse keeps the message of the earlier response next to
+// the recipe fields.
+type updatedRecipeResponse struct {
+	Message string `json:"message"`
+	Recipe
+}
+
 func HandleGenerateByDescription(w http.ResponseWriter, r *http.Request) {
 	if r.Method != http.MethodPost {
 		http.Error(w, "Invalid request method", http.StatusMethodNotAllowed)
 		return
 	}
-	var req RecipeGenerateRequest
+	// isGerman falls back to the user's language when left out
+	req := RecipeGenerateRequest{IsGerman: requestUserSettings(r.Context()).german()}
 	err := json.NewDecoder(r.Body).Decode(&req)
 	if err != nil {
 		http.Error(w, "Invalid JSON payload", http.StatusBadRequest)
@@ -573,14 +1187,19 @@ func HandleGenerateByDescription(w http.ResponseWriter, r *http.Request) {
 		http.Error(w, "Missing recipename", http.StatusBadRequest)
 		return
 	}
+	if reason := req.OvenSettings.validate(); reason != "" {
+		http.Error(w, reason, http.StatusBadRequest)
+		return
+	}
 
-	recipe, err := GenerateRecipeByName(req.RecipeDescription, req.IsGerman)
+	recipe, err := GenerateRecipeByName(r.Context(), req.RecipeDescription, req.IsGerman)
 	if err != nil {
 		http.Error(w, "Error generating recipe", http.StatusInternalServerError)
 		return
 	}
+	recipe = addFoodSafetyNote(r.Context(), adjustForOven(recipe, req.OvenSettings))
 
-	recipename, err := openAIgenerateRecipeName(recipe, req.IsGerman)
+	recipename, err := openAIgenerateRecipeName(r.Context(), recipe, req.IsGerman)
 	if err != nil {
 		log.Printf("Error generating recipe name: %v\n", err)
 		http.Error(w, "Error generating recipe name", http.StatusInternalServerError)
@@ -591,6 +1210,7 @@ func HandleGenerateByDescription(w http.ResponseWriter, r *http.Request) {
 		Recipename: recipename,
 		Recipe:     recipe,
 	}
+	addTimingEstimate(r.Context(), &resp)
 
 	w.Header().Set("Content-Type", "application/json")
 	w.WriteHeader(http.StatusOK)
@@ -607,7 +1227,7 @@ func HandleGenerateByLink(w http.ResponseWriter, r *http.Request) {
 		http.Error(w, "Invalid request method", http.StatusMethodNotAllowed)
 		return
 	}
-	var req RecipeLinkRequest
+	req := RecipeLinkRequest{IsGerman: requestUserSettings(r.Context()).german()}
 	err := json.NewDecoder(r.Body).Decode(&req)
 	if err != nil {
 		http.Error(w, "Invalid JSON payload", http.StatusBadRequest)
@@ -618,8 +1238,22 @@ func HandleGenerateByLink(w http.ResponseWriter, r *http.Request) {
 		http.Error(w, "Missing link", http.StatusBadRequest)
 		return
 	}
+	if reason := req.OvenSettings.validate(); reason != "" {
+		http.Error(w, reason, http.StatusBadRequest)
+		return
+	}
 
-	recipename, recipe, err := GenerateRecipeByLink(req.URL, req.IsGerman)
+	if req.Multiple {
+		recipes, err := GenerateRecipesByLink(r.Context(), req.URL, req.IsGerman, req.OvenSettings)
+		writeRecipeCandidates(w, recipes, err)
+		return
+	}
+
+	recipename, recipe, err := GenerateRecipeByLink(r.Context(), req.URL, req.IsGerman)
+	if errors.Is(err, errNoRecipes) {
+		http.Error(w, "No recipe found in the source", http.StatusUnprocessableEntity)
+		return
+	}
 	if err != nil {
 		http.Error(w, "Error generating recipe", http.StatusInternalServerError)
 		return
@@ -627,8 +1261,10 @@ func HandleGenerateByLink(w http.ResponseWriter, r *http.Request) {
 
 	resp := Recipe{
 		Recipename: recipename,
-		Recipe:     recipe,
+		Recipe:     addFoodSafetyNote(r.Context(), adjustForOven(recipe, req.OvenSettings)),
 	}
+	resp.SourceURL, _ = normalizeSourceURL(req.URL)
+	addTimingEstimate(r.Context(), &resp)
 
 	w.Header().Set("Content-Type", "application/json")
 	w.WriteHeader(http.StatusOK)
@@ -669,38 +1305,58 @@ func HandleGenerateByImage(w http.ResponseWriter, r *http.Request) {
 	if recipeName := r.FormValue("recipename"); recipeName != "" {
 		recipeRequest.Recipename = recipeName
 	}
-	if isGerman := r.FormValue("isGerman"); isGerman != "" {
-		if isGerman == "true" {
-			recipeRequest.IsGerman = true
-		} else if isGerman == "false" {
-			recipeRequest.IsGerman = false
-		} else {
-			http.Error(w, "isGerman must be 'true' or 'false'", http.StatusBadRequest)
-			return
-		}
-	} else {
-		http.Error(w, "isGerman cannot be empty", http.StatusBadRequest)
+	isGerman, reason := parseIsGerman(r.Context(), r.FormValue("isGerman"))
+	if reason != "" {
+		http.Error(w, reason, http.StatusBadRequest)
+		return
+	}
+	recipeRequest.IsGerman = isGerman
+	oven, reason := parseOvenSettings(r.FormValue("oven"), r.FormValue("altitude"))
+	if reason != "" {
+		http.Error(w, reason, http.StatusBadRequest)
 		return
 	}
+	recipeRequest.OvenSettings = oven
 
 	base64Data, err := EncodeImageToBase64(file)
 	if err != nil {
+		if errors.Is(err, errUnsupportedImage) {
+			http.Error(w, "Unsupported image format, please upload a JPEG, PNG, HEIC or WebP", http.StatusUnsupportedMediaType)
+			return
+		}
+		if errors.Is(err, errImageTooLarge) {
+			http.Error(w, "Image too large, please upload a photo of at most 50 megapixels", http.StatusRequestEntityTooLarge)
+			return
+		}
+		log.Printf("Error preparing image: %v\n", err)
 		http.Error(w, "Failed to encode image to base64", http.StatusInternalServerError)
 		return
 	}
 
-	recipe, err := GenerateRecipeByImage(base64Data, recipeRequest.IsGerman)
+	// the recipename override only applies to a single recipe
+	if r.FormValue("multiple") == "true" {
+		recipes, err := GenerateRecipesByImage(r.Context(), base64Data, recipeRequest.IsGerman, recipeRequest.OvenSettings)
+		writeRecipeCandidates(w, recipes, err)
+		return
+	}
+
+	recipe, err := GenerateRecipeByImage(r.Context(), base64Data, recipeRequest.IsGerman)
+	if errors.Is(err, errNoRecipes) {
+		http.Error(w, "No recipe found in the source", http.StatusUnprocessableEntity)
+		return
+	}
 	if err != nil {
 		http.Error(w, "Failed to generate recipe", http.StatusInternalServerError)
 		return
 	}
+	recipe = addFoodSafetyNote(r.Context(), adjustForOven(recipe, recipeRequest.OvenSettings))
 
 	var recipename string
 
 	if recipeRequest.Recipename != "" {
 		recipename = recipeRequest.Recipename
 	} else {
-		recipename, err = openAIgenerateRecipeName(recipe, recipeRequest.IsGerman)
+		recipename, err = openAIgenerateRecipeName(r.Context(), recipe, recipeRequest.IsGerman)
 		if err != nil {
 			http.Error(w, "Error generating recipe", http.StatusInternalServerError)
 			log.Println("Error generating recipe name:", err)
@@ -712,6 +1368,7 @@ func HandleGenerateByImage(w http.ResponseWriter, r *http.Request) {
 		Recipename: recipename,
 		Recipe:     recipe,
 	}
+	addTimingEstimate(r.Context(), &resp)
 
 	w.Header().Set("Content-Type", "application/json")
 	w.WriteHeader(http.StatusOK)
@@ -742,22 +1399,15 @@ func HandleGenerateRecipeByVoice(w http.ResponseWriter, r *http.Request) {
 		}
 	}(file)
 
-	var isGerman bool
-	if risGerman := r.FormValue("isGerman"); risGerman != "" {
-		if risGerman == "true" {
-			isGerman = true
-		} else if risGerman == "false" {
-			isGerman = false
-		} else {
-			http.Error(w, "isGerman must be 'true' or 'false'", http.StatusBadRequest)
-			return
-		}
-	} else {
-		http.Error(w, "isGerman cannot be empty", http.StatusBadRequest)
+	isGerman, reason := parseIsGerman(r.Context(), r.FormValue("isGerman"))
+	if reason != "" {
+		http.Error(w, reason, http.StatusBadRequest)
 		return
 	}
 
-	transcript, err := goopenAIgenerateTranscript(file)
+	withVoiceAnswer := r.FormValue("voiceAnswer") == "true"
+
+	transcript, err := goopenAIgenerateTranscript(r.Context(), file)
 	if err != nil {
 		http.Error(w, "Failed to generate recipe", http.StatusInternalServerError)
 		log.Println("Error transcribing recipe:", err)
@@ -765,30 +1415,43 @@ func HandleGenerateRecipeByVoice(w http.ResponseWriter, r *http.Request) {
 	}
 	log.Printf("Transcript: %s\n", transcript)
 
-	if !isRecipeRelated(transcript) {
-		log.Printf("Input rejected by LLM judge")
-		http.Error(w, "Input rejected by LLM judge", http.StatusBadRequest)
+	if related, rejectionID := isRecipeRelated(r.Context(), judgeModeVoice, transcript); !related {
+		writeJudgeRejection(w, rejectionID)
 		return
 	}
 
-	recipe, err := openAIgenerateRecipe(transcript, isGerman)
+	recipe, err := openAIgenerateRecipe(r.Context(), transcript, isGerman)
 	if err != nil {
 		http.Error(w, "Error generating recipe", http.StatusInternalServerError)
 		log.Println("Error generating recipe via voice:", err)
 		return
 	}
 
-	recipename, err := openAIgenerateRecipeName(recipe, isGerman)
+	recipename, err := openAIgenerateRecipeName(r.Context(), recipe, isGerman)
 	if err != nil {
 		http.Error(w, "Error generating recipe", http.StatusInternalServerError)
 		log.Println("Error generating recipe name:", err)
 		return
 	}
 
-	resp := Recipe{
-		Recipename: recipename,
-		Recipe:     recipe,
-		Transcript: transcript,
+	resp := struct {
+		Recipe
+		VoiceAnswer *VoiceAnswer `json:"voiceAnswer,omitempty"`
+	}{
+		Recipe: Recipe{
+			Recipename: recipename,
+			Recipe:     recipe,
+			Transcript: transcript,
+		},
+	}
+	addTimingEstimate(r.Context(), &resp.Recipe)
+
+	// The recipe is still returned when speech synthesis fails.
+	if withVoiceAnswer {
+		resp.VoiceAnswer, err = generateVoiceAnswer(r.Context(), recipename, recipe, isGerman)
+		if err != nil {
+			log.Println("Error generating voice answer:", err)
+		}
 	}
 
 	w.Header().Set("Content-Type", "application/json")
@@ -813,14 +1476,46 @@ func HandleReprompt(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	updatedRecipe, err := goopenaiUpdateRecipe(req.Recipe, req.ChangePrompt)
+	if req.RecipeID != 0 {
+		userCtx, ok := r.Context().Value("user").(UserContext)
+		if !ok {
+			http.Error(w, "Unauthorized: user context missing", http.StatusUnauthorized)
+			return
+		}
+
+		revision, err := createPendingRevision(r.Context(), userCtx.UserID, req.RecipeID, req.ChangePrompt)
+		if err != nil {
+			if errors.Is(err, pgx.ErrNoRows) {
+				http.Error(w, "Recipe not found", http.StatusNotFound)
+				return
+			}
+			log.Printf("Error creating revision: %v\n", err)
+			http.Error(w, "Error generating recipe", http.StatusInternalServerError)
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusOK)
+
+		err = json.NewEncoder(w).Encode(revision)
+		if err != nil {
+			http.Error(w, "Error encoding JSON response", http.StatusInternalServerError)
+		}
+		return
+	}
+
+	updatedRecipe, err := goopenaiUpdateRecipe(r.Context(), req.Recipe, req.ChangePrompt)
 	if err != nil {
 		http.Error(w, "Error generating recipe", http.StatusInternalServerError)
 		return
 	}
 
-	resp := Recipe{
-		Recipe: updatedRecipe,
+	resp := struct {
+		Recipe
+		Diff RecipeDiff `json:"diff"`
+	}{
+		Recipe: Recipe{Recipe: updatedRecipe},
+		Diff:   diffRecipes(req.Recipe, updatedRecipe),
 	}
 
 	w.Header().Set("Content-Type", "application/json")
@@ -833,20 +1528,21 @@ func HandleReprompt(w http.ResponseWriter, r *http.Request) {
 	}
 }
 
-func GenerateRecipeByLink(URL string, isGerman bool) (string, string, error) {
-	websitecontent, err := GetWebsite(URL)
+func GenerateRecipeByLink(ctx context.Context, URL string, isGerman bool) (string, string, error) {
+	websitecontent, err := GetWebsite(ctx, URL)
 	if err != nil {
 		fmt.Println("Error fetching website content:", err)
 		return "", "", err
 	}
+	archiveSource(ctx, URL, websitecontent)
 
-	recipe, err := openAIgenerateRecipeLink(websitecontent, isGerman)
+	recipe, err := openAIgenerateRecipeLink(ctx, websitecontent, isGerman)
 	if err != nil {
 		fmt.Println("Error generating recipe:", err)
 		return "", "", err
 	}
 
-	recipename, err := openAIgenerateRecipeName(recipe, isGerman)
+	recipename, err := openAIgenerateRecipeName(ctx, recipe, isGerman)
 	if err != nil {
 		fmt.Println("Error generating recipe name:", err)
 		return "", "", err
@@ -855,8 +1551,8 @@ func GenerateRecipeByLink(URL string, isGerman bool) (string, string, error) {
 	return recipename, recipe, nil
 }
 
-func GenerateRecipeByName(RecipeName string, isGerman bool) (string, error) {
-	recipe, err := openAIgenerateRecipe(RecipeName, isGerman)
+func GenerateRecipeByName(ctx context.Context, RecipeName string, isGerman bool) (string, error) {
+	recipe, err := openAIgenerateRecipe(ctx, RecipeName, isGerman)
 	if err != nil {
 		fmt.Println("Error generating recipe:", err)
 		return "", err
@@ -867,8 +1563,8 @@ func GenerateRecipeByName(RecipeName string, isGerman bool) (string, error) {
 	return recipe, nil
 }
 
-func GenerateRecipeByImage(Image string, isGerman bool) (string, error) {
-	recipe, err := goopenAIgenerateRecipeImage(Image, isGerman)
+func GenerateRecipeByImage(ctx context.Context, Image string, isGerman bool) (string, error) {
+	recipe, err := goopenAIgenerateRecipeImage(ctx, Image, isGerman)
 	if err != nil {
 		fmt.Println("Error generating recipe:", err)
 		return "", err
@@ -877,212 +1573,123 @@ func GenerateRecipeByImage(Image string, isGerman bool) (string, error) {
 	return recipe, nil
 }
 
-func AddRecipeToDB(userID int, RecipeName string, Recipe string, RecipeCategory string) error {
-	_, err := pool.Exec(context.Background(), "insert into recipes(user_id, title, content, category) values($1, $2, $3, $4)", userID, RecipeName, Recipe, RecipeCategory)
+// AddRecipeToDB stores the recipe and returns it with the ID, slug and
+// timestamps the database assigned. The slug names the recipe's blob.
+func AddRecipeToDB(userID int, title string, content string, category string, timing RecipeTiming, draft bool) (Recipe, error) {
+	slug, err := uniqueRecipeSlug(context.Background(), userID, title, 0)
+	if err != nil {
+		log.Printf("Generating slug failed: %v\n\n", err)
+		return Recipe{}, err
+	}
+
+	recipe := Recipe{Recipename: title, Recipe: content, Category: category, Slug: slug, RecipeTiming: timing, Draft: draft}
+	var createdAt, updatedAt time.Time
+	err = pool.QueryRow(context.Background(), "insert into recipes(user_id, title, content, category, slug, prep_minutes, cook_minutes, total_minutes, difficulty, draft) values($1, $2, $3, $4, $5, $6, $7, $8, $9, $10) returning id, version, created_at, updated_at",
+		userID, title, content, category, slug, timing.PrepTime, timing.CookTime, timing.TotalTime, timing.Difficulty, draft).Scan(&recipe.ID, &recipe.Version, &createdAt, &updatedAt)
 	if err != nil {
 		log.Printf("Inserting Recipe failed: %v\n\n", err)
-		return err
+		return Recipe{}, err
 	}
+	recipe.CreatedAt, recipe.UpdatedAt = &createdAt, &updatedAt
+	// the index is patched by the publish in addRecipe
+	invalidateRecipeList(userID)
 
-	log.Printf("added recipe %s to database", RecipeName)
-	return nil
+	log.Printf("added recipe %s to database", title)
+	return recipe, nil
 }
 
-func RemoveRecipeFromDB(userID int, recipeID int) error {
-	_, err := pool.Exec(context.Background(), "delete from recipes where user_id = $1 and id = $2", userID, recipeID)
+// RemoveRecipeFromDB returns the deleted recipe, pgx.ErrNoRows when there
+// was none.
+func RemoveRecipeFromDB(userID int, recipeID int) (Recipe, error) {
+	recipe := Recipe{ID: recipeID}
+	err := pool.QueryRow(context.Background(), "delete from recipes where user_id = $1 and id = $2 returning title, content, category, slug, version",
+		userID, recipeID).Scan(&recipe.Recipename, &recipe.Recipe, &recipe.Category, &recipe.Slug, &recipe.Version)
 	if err != nil {
-		log.Printf("Deleting recipe failed: %v\n\n", err)
-		return err
+		if !errors.Is(err, pgx.ErrNoRows) {
+			log.Printf("Deleting recipe failed: %v\n\n", err)
+		}
+		return Recipe{}, err
 	}
+	// the index is patched by the publish in HandleDeleteRecipe
+	invalidateRecipeList(userID)
 
 	log.Printf("deleted recipe with id %v from database", recipeID)
-	return nil
+	return recipe, nil
 }
 
-func openAIclient() *openai.Client {
-	OpenAIKey, found := os.LookupEnv("OPENAI_KEY")
-	if !found {
-		log.Println("OPENAI_KEY not found")
-		return nil
+func openAIgenerateRecipe(ctx context.Context, recipeDescription string, isGerman bool) (string, error) {
+	req := llmRequest{Task: llmTaskRecipe, System: recipeSystemMessage(ctx, isGerman)}
+	if examples := cookbookContext(ctx, recipeDescription, isGerman); examples != "" {
+		req.System += "\n\n" + examples
 	}
-
-	client := openai.NewClient(
-		option.WithAPIKey(OpenAIKey),
-	)
-
-	return client
-}
-
-func openAIgenerateRecipe(recipeDescription string, isGerman bool) (string, error) {
-	client := openAIclient()
-
-	var systemmessage openai.ChatCompletionMessageParamUnion
-	var usermessage openai.ChatCompletionMessageParamUnion
-
 	if isGerman {
-		systemmessage = openai.SystemMessage(germanSystemMessage)
-		usermessage = openai.UserMessage("Erstelle ein Rezept für folgende Beschreibung: " + recipeDescription)
+		req.Prompt = "Erstelle ein Rezept für folgende Beschreibung: " + recipeDescription
 	} else {
-		systemmessage = openai.SystemMessage(englishSystemMessage)
-		usermessage = openai.UserMessage("Generate a recipe for the following description: " + recipeDescription)
-	}
-
-	completion, err := client.Chat.Completions.New(context.TODO(), openai.ChatCompletionNewParams{
-		Messages: openai.F([]openai.ChatCompletionMessageParamUnion{
-			systemmessage,
-			usermessage,
-		}),
-		Model: openai.F(openai.ChatModelGPT4oMini),
-	})
-	if err != nil {
-		return "", err
+		req.Prompt = "Generate a recipe for the following description: " + recipeDescription
 	}
 
-	return completion.Choices[0].Message.Content, nil
+	return llm.Complete(ctx, req)
 }
 
-func openAIgenerateRecipeName(Recipe string, isGerman bool) (string, error) {
-	client := openAIclient()
-
-	var systemmessage openai.ChatCompletionMessageParamUnion
-	var usermessage openai.ChatCompletionMessageParamUnion
-
+func openAIgenerateRecipeName(ctx context.Context, Recipe string, isGerman bool) (string, error) {
+	req := llmRequest{Task: llmTaskRecipeName}
 	if isGerman {
-		systemmessage = openai.SystemMessage("Du bist ein Agent, der nur mit dem Rezeptnamen antwortet. Maximal 2 Wörter.")
-		usermessage = openai.UserMessage("Generiere einen Rezeptnamen für: " + Recipe)
+		req.System = "Du bist ein Agent, der nur mit dem Rezeptnamen antwortet. Maximal 2 Wörter."
+		req.Prompt = "Generiere einen Rezeptnamen für: " + Recipe
 	} else {
-		systemmessage = openai.SystemMessage("You only respond with the recipe name. 2 words max.")
-		usermessage = openai.UserMessage("Generate a recipe name for: " + Recipe)
+		req.System = "You only respond with the recipe name. 2 words max."
+		req.Prompt = "Generate a recipe name for: " + Recipe
 	}
 
-	recipename, err := client.Chat.Completions.New(context.TODO(), openai.ChatCompletionNewParams{
-		Messages: openai.F([]openai.ChatCompletionMessageParamUnion{
-			systemmessage,
-			usermessage,
-		}),
-		Model: openai.F(openai.ChatModelGPT4oMini),
-	})
-	if err != nil {
-		return "", err
-	}
-
-	return recipename.Choices[0].Message.Content, nil
+	return llm.Complete(ctx, req)
 }
 
-func openAIgenerateRecipeLink(Recipe string, isGerman bool) (string, error) {
-	client := openAIclient()
-
-	var systemmessage openai.ChatCompletionMessageParamUnion
-
-	if isGerman {
-		systemmessage = openai.SystemMessage(germanSystemMessage)
-	} else {
-		systemmessage = openai.SystemMessage(englishSystemMessage)
-	}
-
-	var usermessage openai.ChatCompletionMessageParamUnion
+// openAIgenerateRecipeLink takes the recipe from a fetched page, see
+// sanitizeSource for what of the page the model gets to see. A page that is
+// still too long is not cut, limitedLLM turns it away with errInputTooLarge.
+func openAIgenerateRecipeLink(ctx context.Context, Recipe string, isGerman bool) (string, error) {
+	req := llmRequest{Task: llmTaskRecipeLink, System: recipeSystemMessage(ctx, isGerman) + "\n\n" + untrustedSourceRule}
 	if isGerman {
-		usermessage = openai.UserMessage("Ändere das Rezept in Markdown-Format: " + Recipe)
+		req.Prompt = "Ändere das Rezept in Markdown-Format:\n\n" + wrapSource(sanitizeSource(Recipe))
 	} else {
-		usermessage = openai.UserMessage("Change to markdown format: " + Recipe)
+		req.Prompt = "Change to markdown format:\n\n" + wrapSource(sanitizeSource(Recipe))
 	}
 
-	completion, err := client.Chat.Completions.New(context.TODO(), openai.ChatCompletionNewParams{
-		Messages: openai.F([]openai.ChatCompletionMessageParamUnion{
-			systemmessage,
-			usermessage,
-		}),
-		Model: openai.F(openai.ChatModelGPT4oMini),
-	})
+	recipe, err := llm.Complete(ctx, req)
 	if err != nil {
 		return "", err
 	}
-
-	return completion.Choices[0].Message.Content, nil
+	return recipe, checkRecipeOutput(recipe)
 }
 
-func goopenAIgenerateRecipeImage(RecipeBase64 string, isGerman bool) (string, error) {
-	client := goopenai.NewClient(os.Getenv("OPENAI_KEY"))
-
-	var SystemMessage string
-	if isGerman {
-		SystemMessage = germanSystemMessage
-	} else {
-		SystemMessage = englishSystemMessage
-	}
-
-	response, err := client.CreateChatCompletion(context.Background(), goopenai.ChatCompletionRequest{
-		Model: goopenai.GPT4oMini,
-		Messages: []goopenai.ChatCompletionMessage{
-			{
-				Role: goopenai.ChatMessageRoleUser,
-				MultiContent: []goopenai.ChatMessagePart{
-					{
-						Type: goopenai.ChatMessagePartTypeText,
-						Text: SystemMessage,
-					},
-					{
-						Type: goopenai.ChatMessagePartTypeImageURL,
-						ImageURL: &goopenai.ChatMessageImageURL{
-							URL: "data:image/jpeg;base64," + RecipeBase64,
-						},
-					},
-				},
-			},
-		},
+func goopenAIgenerateRecipeImage(ctx context.Context, RecipeBase64 string, isGerman bool) (string, error) {
+	recipe, err := llm.Complete(ctx, llmRequest{
+		Task:        llmTaskRecipeImage,
+		Prompt:      recipeSystemMessage(ctx, isGerman) + "\n\n" + untrustedImageRule,
+		ImageBase64: RecipeBase64,
 	})
 	if err != nil {
 		return "", err
 	}
-
-	return response.Choices[0].Message.Content, nil
+	return recipe, checkRecipeOutput(recipe)
 }
 
-func goopenAIgenerateTranscript(voicemessage multipart.File) (string, error) {
-	client := goopenai.NewClient(os.Getenv("OPENAI_KEY"))
-
-	req := goopenai.AudioRequest{
-		Model:    goopenai.Whisper1,
-		Reader:   voicemessage,
-		FilePath: "voicemessage.mp3", // fake name necessary for the request
-	}
-
-	response, err := client.CreateTranscription(context.Background(), req)
-	if err != nil {
-		return "", err
-	}
-
-	return response.Text, nil
+func goopenAIgenerateTranscript(ctx context.Context, voicemessage multipart.File) (string, error) {
+	return llm.Transcribe(ctx, voicemessage)
 }
 
-func goopenAIgenerateRecipeCategory(Recipe string) string {
-	client := goopenai.NewClient(os.Getenv("OPENAI_KEY"))
-
-	response, err := client.CreateChatCompletion(context.Background(), goopenai.ChatCompletionRequest{
-		Model: goopenai.GPT4oMini,
-		Messages: []goopenai.ChatCompletionMessage{
-			{
-				Role: goopenai.ChatMessageRoleUser,
-				MultiContent: []goopenai.ChatMessagePart{
-					{
-						Type: goopenai.ChatMessagePartTypeText,
-						Text: "What is the category of this recipe? Currently only Hauptgericht, Vorspeise, Brot, Dessert are supported. Answer with a single word nothing else",
-					},
-					{
-						Type: goopenai.ChatMessagePartTypeText,
-						Text: Recipe,
-					},
-				},
-			},
-		},
+func goopenAIgenerateRecipeCategory(ctx context.Context, Recipe string) string {
+	categories := requestUserSettings(ctx).Categories
+	category, err := llm.Complete(ctx, llmRequest{
+		Task: llmTaskCategory,
+		Prompt: "What is the category of this recipe? Currently only " + strings.Join(categories, ", ") +
+			" are supported. Answer with the category nothing else\n\n" + Recipe,
 	})
 	if err != nil {
 		log.Println("Error generating recipe category:", err)
 		return ""
 	}
 
-	categories := []string{"Hauptgericht", "Vorspeise", "Brot", "Dessert"}
-	category := response.Choices[0].Message.Content
 	for _, c := range categories {
 		if strings.Contains(category, c) {
 			return c
@@ -1092,139 +1699,131 @@ func goopenAIgenerateRecipeCategory(Recipe string) string {
 	return "Sonstiges"
 }
 
-func goopenAIChatCompletion(ctx context.Context, systemPrompt, userPrompt string, model string) (string, error) {
-	client := openAIclient()
-
-	messages := []openai.ChatCompletionMessageParamUnion{
-		openai.SystemMessage(systemPrompt),
-		openai.UserMessage(userPrompt),
-	}
-
-	completion, err := client.Chat.Completions.New(ctx, openai.ChatCompletionNewParams{
-		Messages: openai.F(messages),
-		Model:    openai.F(model),
-	})
-
-	if err != nil {
-		return "", fmt.Errorf("chat completion error: %w", err)
-	}
-
-	if len(completion.Choices) == 0 {
-		return "", errors.New("no completion choices returned")
-	}
-
-	return completion.Choices[0].Message.Content, nil
-}
-
-func goopenaiUpdateRecipe(Recipe string, Prompt string) (string, error) {
-	client := goopenai.NewClient(os.Getenv("OPENAI_KEY"))
-
-	response, err := client.CreateChatCompletion(context.Background(), goopenai.ChatCompletionRequest{
-		Model: goopenai.GPT4oMini,
-		Messages: []goopenai.ChatCompletionMessage{
-			{
-				Role: goopenai.ChatMessageRoleUser,
-				MultiContent: []goopenai.ChatMessagePart{
-					{
-						Type: goopenai.ChatMessagePartTypeText,
-						Text: "The user requested that you change the following recipe: " +
-							Recipe + " according to the following prompt: " + Prompt +
-							" keep the recipe in the same format and language" +
-							" only respond with the recipe and nothing else",
-					},
-				},
-			},
-		},
+func goopenaiUpdateRecipe(ctx context.Context, Recipe string, Prompt string) (string, error) {
+	updated, err := llm.Complete(ctx, llmRequest{
+		Task: llmTaskUpdateRecipe,
+		Prompt: "The user requested that you change the following recipe: " +
+			Recipe + " according to the following prompt: " + Prompt +
+			" keep the recipe in the same format and language" +
+			" only respond with the recipe and nothing else",
 	})
 	if err != nil {
 		log.Printf("Error updating recipe: %v\n", err)
 		return "Error while updating Recipe", err
 	}
-	return response.Choices[0].Message.Content, nil
+	return updated, nil
 }
 
 func HandlerJudgeMiddleware(next http.HandlerFunc) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
-		if !HandlerIsRecipeRelated(r) {
-			log.Printf("Input rejected by LLM judge")
-			http.Error(w, "Input rejected by LLM judge", http.StatusBadRequest)
+		if related, rejectionID := HandlerIsRecipeRelated(r); !related {
+			writeJudgeRejection(w, rejectionID)
 			return
 		}
 		next(w, r)
 	}
 }
 
-func HandlerIsRecipeRelated(r *http.Request) bool {
-	client := openAIclient()
-
+func HandlerIsRecipeRelated(r *http.Request) (bool, int64) {
 	bodyBytes, err := io.ReadAll(r.Body)
 	if err != nil {
 		log.Printf("Error reading request body: %v\n", err)
-		return false
+		return false, 0
 	}
 
-	log.Printf("Raw body: %s\n", string(bodyBytes))
-
 	r.Body = io.NopCloser(bytes.NewReader(bodyBytes))
 
 	var req RecipeGenerateRequest
 	err = json.Unmarshal(bodyBytes, &req)
 	if err != nil {
 		log.Printf("Error decoding request: %v\n", err)
-		return false
+		return false, 0
 	}
+	debugf("Judging input: %.*s\n", logBodyLimit, req.RecipeDescription)
 
-	var systemmessage openai.ChatCompletionMessageParamUnion
-	systemmessage = openai.SystemMessage(judgeSystemMessage)
-
-	var usermessage openai.ChatCompletionMessageParamUnion
-	usermessage = openai.UserMessage("Is this input related to a recipe? Only answer with 'yes' or 'no'" + req.RecipeDescription)
+	return isRecipeRelated(r.Context(), judgeModeDescription, req.RecipeDescription)
+}
 
-	completion, err := client.Chat.Completions.New(context.TODO(), openai.ChatCompletionNewParams{
-		Messages: openai.F([]openai.ChatCompletionMessageParamUnion{
-			systemmessage,
-			usermessage,
-		}),
-		Model: openai.F(openai.ChatModelGPT4oMini),
-	})
-	if err != nil {
-		log.Println("Error judging input:", err)
-		return false
+// isRecipeRelated judges the input in two stages, the pre-filter settles
+// the obvious cases and the model is only asked when it is unsure, see
+// JUDGE_MODE. A rejection is recorded for the user to appeal and its ID
+// returned along, see recordJudgeRejection.
+func isRecipeRelated(ctx context.Context, mode string, recipe string) (bool, int64) {
+	judge := configuredJudge()
+	if judge == judgeOff {
+		return true, 0
 	}
 
-	log.Printf("Completion response: %s\n", strings.ToLower(completion.Choices[0].Message.Content))
-	return strings.Contains(strings.ToLower(completion.Choices[0].Message.Content), "yes")
-}
-
-func isRecipeRelated(recipe string) bool {
-	result, err := goopenAIChatCompletion(
-		context.TODO(),
-		judgeSystemMessage,
-		"Is this input related to a recipe? Only answer with 'yes' or 'no'"+recipe,
-		openai.ChatModelGPT4oMini,
-	)
+	verdict, reason := prefilterInput(recipe)
+	if verdict == prefilterAccept {
+		debugf("Input accepted by the judge pre-filter\n")
+		return true, 0
+	}
+	if appealApprovedFor(ctx, recipe) {
+		return true, 0
+	}
+	if verdict == prefilterReject {
+		log.Printf("Input rejected by the judge pre-filter: %s\n", reason)
+		return false, recordJudgeRejection(ctx, mode, recipe, judgeVerdictRejected, prefilterModel, reason)
+	}
+	if judge == judgePrefilterOnly {
+		return true, 0
+	}
 
+	model := modelFor(ctx)
+	result, err := llm.Complete(ctx, llmRequest{
+		Task:   llmTaskJudge,
+		System: judgeSystemMessage,
+		Prompt: "Is this input related to a recipe? Only answer with 'yes' or 'no'" + recipe,
+		Model:  model,
+	})
+	if errors.Is(err, errInputTooLarge) {
+		return false, 0
+	}
 	if err != nil {
 		log.Println("Error judging input:", err)
-		return false
+		return false, recordJudgeRejection(ctx, mode, recipe, judgeVerdictError, model, err.Error())
 	}
 
-	return strings.Contains(strings.ToLower(result), "yes")
+	log.Printf("Completion response: %s\n", strings.ToLower(result))
+	if strings.Contains(strings.ToLower(result), "yes") {
+		return true, 0
+	}
+	return false, recordJudgeRejection(ctx, mode, recipe, judgeVerdictRejected, model, result)
 }
 
+// EncodeImageToBase64 returns the photo prepared for the vision model, see
+// prepareImage.
 func EncodeImageToBase64(imageData io.Reader) (string, error) {
 	data, err := io.ReadAll(imageData)
 	if err != nil {
 		return "", fmt.Errorf("failed to read image data: %w", err)
 	}
 
+	data, err = prepareImage(data)
+	if err != nil {
+		return "", err
+	}
+
 	base64Data := base64.StdEncoding.EncodeToString(data)
 	return base64Data, nil
 }
 
-func GetWebsite(link string) (string, error) {
-	client := &http.Client{}
-	req, err := http.NewRequest("GET", link, nil)
+// websiteClient fetches recipe pages. Redirects are followed, every hop is
+// dialed through the public address check again.
+var websiteClient = func() *http.Client {
+	client := publicHTTPClient(20 * time.Second)
+	client.CheckRedirect = nil
+	return client
+}()
+
+// maxWebsiteSize caps a fetched page. Markup, scripts and styles are dropped
+// before the page is sent, the rest has to fit the input of a link import,
+// so a page many times that size is refused while it is read.
+var maxWebsiteSize = int64(limitFor(llmTaskRecipeLink).Input*4) * 32
+
+func GetWebsite(ctx context.Context, link string) (string, error) {
+	req, err := http.NewRequestWithContext(ctx, "GET", link, nil)
 	if err != nil {
 		return "", err
 	}
@@ -1232,7 +1831,7 @@ func GetWebsite(link string) (string, error) {
 	// Set headers to mimic a browser
 	req.Header.Set("User-Agent", "Mozilla/5.0 (Windows NT 10.0; Win64; x64) AppleWebKit/537.36 (KHTML, like Gecko) Chrome/91.0.4472.124 Safari/537.36")
 
-	res, err := client.Do(req)
+	res, err := websiteClient.Do(req)
 	if err != nil {
 		return "", err
 	}
@@ -1243,19 +1842,33 @@ func GetWebsite(link string) (string, error) {
 		}
 	}(res.Body)
 
-	content, err := io.ReadAll(res.Body)
+	content, err := io.ReadAll(io.LimitReader(res.Body, maxWebsiteSize+1))
 	if err != nil {
 		return "", err
 	}
+	if int64(len(content)) > maxWebsiteSize {
+		rejectInput(ctx)
+		return "", fmt.Errorf("%w: the page is larger than %d bytes", errInputTooLarge, maxWebsiteSize)
+	}
 
 	return string(content), nil
 }
 
-func Login(ctx context.Context, oauthID, userName, email, provider string) (int, string, error) {
+// Login looks up or registers the user. Storage provisioning runs in the
+// background, its progress is reported by GET /api/v1/login. In invite mode
+// a new user is only registered with an invitation, see redeemInvitation.
+func Login(ctx context.Context, oauthID, userName, email, provider, inviteCode string) (int, string, error) {
 	var storageAccountName string
 	var userID int
+	var provisioningStatus string
+	var storageMode string
+
+	var cached cachedUser
+	if appCache.Get(ctx, userCacheKey(oauthID), &cached) {
+		return cached.ID, cached.Subdomain, nil
+	}
 
-	err := pool.QueryRow(ctx, "SELECT subdomain, id FROM users WHERE oauth_id = $1", oauthID).Scan(&storageAccountName, &userID)
+	err := pool.QueryRow(ctx, queryUserByOauthID, oauthID).Scan(&storageAccountName, &userID, &provisioningStatus, &storageMode)
 	if err != nil {
 		if errors.Is(err, pgx.ErrNoRows) {
 			storageAccountName, err = randomString()
@@ -1263,24 +1876,35 @@ func Login(ctx context.Context, oauthID, userName, email, provider string) (int,
 				return 0, "", fmt.Errorf("failed to generate random string: %w", err)
 			}
 
-			err = pool.QueryRow(ctx, "INSERT INTO users (oauth_id, name, email, oauth_provider, subdomain) VALUES ($1, $2, $3, $4, $5) RETURNING id",
-				oauthID, userName, email, provider, storageAccountName).Scan(&userID)
+			provisioningStatus = provisioningPending
+			storageMode = configuredStorageMode()
+			err = inTransaction(ctx, func(tx pgx.Tx) error {
+				err := tx.QueryRow(ctx, "INSERT INTO users (oauth_id, name, email, oauth_provider, subdomain, provisioning_status, storage_mode) VALUES ($1, $2, $3, $4, $5, $6, $7) RETURNING id",
+					oauthID, userName, email, provider, storageAccountName, provisioningStatus, storageMode).Scan(&userID)
+				if err != nil {
+					return fmt.Errorf("failed to create user: %w", err)
+				}
+				return redeemInvitation(ctx, tx, userID, oauthID, inviteCode)
+			})
 			if err != nil {
-				return 0, "", fmt.Errorf("failed to create user: %w", err)
-			}
-
-			if err = bootstrapStorageAccount(storageAccountName, oauthID); err != nil {
-				return 0, "", fmt.Errorf("failed to bootstrap storage account: %w", err)
-			}
-
-			if err = templateRecipesBlob(storageAccountName, userID); err != nil {
-				return 0, "", fmt.Errorf("failed to template recipes: %w", err)
+				return 0, "", err
 			}
 		} else {
 			return 0, "", fmt.Errorf("database error: %w", err)
 		}
 	}
 
+	if provisioningStatus != provisioningReady {
+		startProvisioning(provisioningUser{
+			UserID:      userID,
+			OauthID:     oauthID,
+			Subdomain:   storageAccountName,
+			StorageMode: storageMode,
+		})
+	} else {
+		appCache.Set(ctx, userCacheKey(oauthID), cachedUser{ID: userID, Subdomain: storageAccountName, StorageMode: storageMode}, userCacheTTL)
+	}
+
 	return userID, storageAccountName, nil
 }
 
@@ -1301,9 +1925,14 @@ func randomString() (string, error) {
 }
 
 func GetUserInformation(oauthid string) (int, string, error) {
+	var cached cachedUser
+	if appCache.Get(context.Background(), userCacheKey(oauthid), &cached) {
+		return cached.ID, cached.Subdomain, nil
+	}
+
 	var userID int
 	var subdomain string
-	err := pool.QueryRow(context.Background(), "SELECT id, subdomain FROM users WHERE oauth_id = $1", oauthid).Scan(&userID, &subdomain)
+	err := pool.QueryRow(context.Background(), "SELECT id, subdomain FROM users WHERE "+userByOauthID, oauthid).Scan(&userID, &subdomain)
 	if err != nil {
 		return 0, "", err
 	}
@@ -1311,7 +1940,13 @@ func GetUserInformation(oauthid string) (int, string, error) {
 }
 
 func GetRecipes(userid int) ([]Recipe, error) {
-	rows, err := pool.Query(context.Background(), "SELECT id, title, content, category FROM recipes WHERE user_id = $1", userid)
+	generation := recipesGeneration(context.Background(), userid)
+	var cached []Recipe
+	if appCache.Get(context.Background(), recipesCacheKey(userid, generation), &cached) {
+		return cached, nil
+	}
+
+	rows, err := pool.Query(context.Background(), queryRecipesByUser, userid)
 	if err != nil {
 		log.Printf("Failed to query recipes: %v", err)
 		return nil, err
@@ -1321,58 +1956,136 @@ func GetRecipes(userid int) ([]Recipe, error) {
 	var recipes []Recipe
 	for rows.Next() {
 		var recipe Recipe
-		err := rows.Scan(&recipe.ID, &recipe.Recipename, &recipe.Recipe, &recipe.Category)
+		err := rows.Scan(&recipe.ID, &recipe.Recipename, &recipe.Recipe, &recipe.Category, &recipe.Slug, &recipe.Version, &recipe.UpdatedAt, &recipe.SeasonTags,
+			&recipe.PrepTime, &recipe.CookTime, &recipe.TotalTime, &recipe.Difficulty, &recipe.Language, &recipe.TranslationOf, &recipe.Archived, &recipe.FeaturedAt, &recipe.Cuisine,
+			&recipe.VariantOf, &recipe.Appliance, &recipe.Draft, &recipe.Diet, &recipe.ParentRecipeID, &recipe.CreatedAt, &recipe.PublishedAt, &recipe.PublishError)
 		if err != nil {
 			log.Printf("Failed to scan recipe: %v", err)
 			return nil, err
 		}
 		recipes = append(recipes, recipe)
 	}
+
+	appCache.Set(context.Background(), recipesCacheKey(userid, generation), recipes, recipesCacheTTL)
 	return recipes, nil
 }
 
-func templateRecipesBlob(storageAccountName string, userid int) error {
-	var title = "# Rezepte\n\n"
-	var recipes []Recipe
-	recipes, err := GetRecipes(userid)
+// templateRecipesBlob renders and uploads the index under the user's publish
+// lock, so concurrent mutations can't upload an index missing a recipe. The
+// rows are read from the database under the lock. Given the changed recipes
+// only their rows are read and patched into the cached index, which only
+// publishes keep, see templateRecipeChange. When the publish fails the index
+// is invalidated, the change may not be in it.
+func templateRecipesBlob(storageAccountName string, userid int, recipeIDs ...int) error {
+	err := withUserLock(context.Background(), publishLockNamespace, userid, func() error {
+		ctx := context.Background()
+		generation := indexGeneration(ctx, userid)
+		if len(recipeIDs) > 0 {
+			if previous, found := cachedIndexOf(ctx, userid, generation); found {
+				return templateRecipeChange(storageAccountName, userid, generation, previous, recipeIDs)
+			}
+		}
+
+		recipes, err := loadIndexRecipes(ctx, userid)
+		if err != nil {
+			log.Printf("Failed to get recipes from database, error: %s", err)
+			return err
+		}
+		return publishIndex(storageAccountName, userid, generation, recipes, nil, nil)
+	})
 	if err != nil {
-		log.Printf("Failed to get recipes from database, error: %s", err)
-		return err
+		invalidateRecipes(userid)
 	}
+	return err
+}
+
+// publishRecipeBlob uploads the stored version of the recipe under the
+// publish lock. Reading it under the lock means the last upload always holds
+// the latest edit, whichever instance handled it. The outcome is kept as the
+// recipe's publish status.
+func publishRecipeBlob(storageAccountName string, userid int, slug string) error {
+	return withUserLock(context.Background(), publishLockNamespace, userid, func() error {
+		err := uploadRecipePages(storageAccountName, userid, slug)
+		recordRecipePublish(context.Background(), userid, slug, err)
+		return err
+	})
+}
 
-	var recipesTemplateMain string
-	var recipesTemplateBread string
-	var recipesTemplateStarter string
-	var recipesTemplateDessert string
-	var recipesTemplateMisc string
-
-	for _, recipe := range recipes {
-		linkFormat := "- [" + recipe.Recipename + "](/?recipe=" + strings.ReplaceAll(recipe.Recipename, " ", "-") + ")\n"
-		switch recipe.Category {
-		case "Hauptgericht":
-			recipesTemplateMain += linkFormat
-		case "Brot":
-			recipesTemplateBread += linkFormat
-		case "Vorspeise":
-			recipesTemplateStarter += linkFormat
-		case "Dessert":
-			recipesTemplateDessert += linkFormat
-		default:
-			recipesTemplateMisc += linkFormat
+func uploadRecipePages(storageAccountName string, userid int, slug string) error {
+	content, _, err := getPublishedRecipe(context.Background(), userid, slug)
+	if errors.Is(err, pgx.ErrNoRows) {
+		// archived, the pages come down until the recipe is back
+		if err := removeBlob(storageAccountName, "recipes/"+slug+".md"); err != nil {
+			return err
 		}
+		return removeBlob(storageAccountName, printableRecipePath(slug))
+	}
+	if err != nil {
+		return err
 	}
 
-	combinedTemplate := title + "🍝 Hauptgerichte\n" + recipesTemplateMain +
-		"\n🥗 Vorspeisen\n" + recipesTemplateStarter +
-		"\n🧁 Desserts\n" + recipesTemplateDessert +
-		"\n🍞 Brot\n" + recipesTemplateBread +
-		"\n🍴 Sonstiges\n" + recipesTemplateMisc
+	if err := addBlob(storageAccountName, "recipes/"+slug+".md", content); err != nil {
+		return err
+	}
 
-	err = addBlob(storageAccountName, "recipes.md", combinedTemplate)
+	printable, err := renderPrintableRecipe(context.Background(), storageAccountName, userid, slug)
 	if err != nil {
-		log.Printf("Failed to add recipes to $web container of storage account  %s, error: %s", storageAccountName, err)
 		return err
 	}
+	if err := addBlob(storageAccountName, "print/print.css", printStylesheet); err != nil {
+		return err
+	}
+	return addBlob(storageAccountName, printableRecipePath(slug), printable)
+}
+
+func renderRecipesIndex(userid int, recipes []Recipe) (string, error) {
+	index, _, err := renderRecipesIndexChange(userid, recipes, nil, nil)
+	return index, err
+}
+
+// renderRecipesIndexChange renders recipes.md and returns it with what is
+// cached of it. With the cached last render of the same layout only the
+// sections the changed recipes touch are rendered again.
+func renderRecipesIndexChange(userid int, recipes []Recipe, previous *cachedIndex, changed []int) (string, cachedIndex, error) {
+	var title = "# Rezepte\n\n"
+	settings, err := GetIndexSettings(context.Background(), userid)
+	if err != nil {
+		log.Printf("Failed to get index settings, error: %s", err)
+		return "", cachedIndex{}, err
+	}
+
+	categories, err := GetCategories(context.Background(), userid)
+	if err != nil {
+		log.Printf("Failed to get categories, error: %s", err)
+		return "", cachedIndex{}, err
+	}
+
+	userSettings, err := loadUserSettings(context.Background(), userid)
+	if err != nil {
+		log.Printf("Failed to get user settings, error: %s", err)
+		return "", cachedIndex{}, err
+	}
+
+	collections, err := listCollections(context.Background(), userid)
+	if err != nil {
+		log.Printf("Failed to get collections from database, error: %s", err)
+		return "", cachedIndex{}, err
+	}
+
+	layout, err := indexLayoutKey(settings, categories, userSettings.Language)
+	if err != nil {
+		return "", cachedIndex{}, err
+	}
+	var change *indexChange
+	if previous != nil && previous.Layout == layout {
+		change = &indexChange{recipes: previous.Recipes, links: previous.Links, changed: changed}
+	}
+	sections, links := renderIndexSections(recipes, settings, categories, userSettings.Language, change)
+
+	combinedTemplate := title + "[Saisonal](./?page=saison) · [Essensplan](./?page=plan) · [Kochjahr](./?page=stats)\n\n" + sections
+	if section := renderCollectionsSection(collections); section != "" {
+		combinedTemplate += "\n" + section
+	}
 
-	return nil
+	return combinedTemplate, cachedIndex{Layout: layout, Recipes: recipes, Links: links}, nil
 }
@@ -0,0 +1,374 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"slices"
+	"strings"
+
+	"github.com/jackc/pgx/v5"
+)
+
+// The MCP endpoint speaks JSON-RPC over the streamable HTTP transport of the
+// Model Context Protocol, without server initiated messages: every POST gets
+// one JSON answer. Clients authenticate with the same bearer token as the
+// frontend.
+
+const mcpLatestProtocolVersion = "2025-06-18"
+
+var mcpProtocolVersions = []string{mcpLatestProtocolVersion, "2025-03-26", "2024-11-05"}
+
+const (
+	jsonRPCParseError     = -32700
+	jsonRPCInvalidRequest = -32600
+	jsonRPCMethodNotFound = -32601
+	jsonRPCInvalidParams  = -32602
+	jsonRPCInternalError  = -32603
+)
+
+// maxMCPSearchResults bounds search_recipes answers, assistants page with a
+// narrower query instead.
+const maxMCPSearchResults = 20
+
+// likeEscaper quotes the wildcards of a search for ILIKE.
+var likeEscaper = strings.NewReplacer(`\`, `\\`, "%", `\%`, "_", `\_`)
+
+type jsonRPCRequest struct {
+	JSONRPC string          `json:"jsonrpc"`
+	ID      json.RawMessage `json:"id,omitempty"`
+	Method  string          `json:"method"`
+	Params  json.RawMessage `json:"params,omitempty"`
+}
+
+type jsonRPCResponse struct {
+	JSONRPC string          `json:"jsonrpc"`
+	ID      json.RawMessage `json:"id"`
+	Result  interface{}     `json:"result,omitempty"`
+	Error   *jsonRPCError   `json:"error,omitempty"`
+}
+
+type jsonRPCError struct {
+	Code    int    `json:"code"`
+	Message string `json:"message"`
+}
+
+type mcpTool struct {
+	Name        string                 `json:"name"`
+	Description string                 `json:"description"`
+	InputSchema map[string]interface{} `json:"inputSchema"`
+	call        func(ctx context.Context, userCtx UserContext, args json.RawMessage) (string, error)
+}
+
+type mcpContent struct {
+	Type string `json:"type"`
+	Text string `json:"text"`
+}
+
+type mcpToolResult struct {
+	Content []mcpContent `json:"content"`
+	IsError bool         `json:"isError"`
+}
+
+// mcpToolError is shown to the model as a failed tool call instead of a
+// protocol error, so it can react to it.
+type mcpToolError string
+
+func (e mcpToolError) Error() string {
+	return string(e)
+}
+
+func schemaObject(required []string, properties map[string]interface{}) map[string]interface{} {
+	return map[string]interface{}{
+		"type":       "object",
+		"properties": properties,
+		"required":   required,
+	}
+}
+
+func schemaProperty(kind string, description string) map[string]interface{} {
+	return map[string]interface{}{"type": kind, "description": description}
+}
+
+var mcpTools = []mcpTool{
+	{
+		Name:        "search_recipes",
+		Description: "Search the user's cookbook by title and content. Returns id, title, category and total time of matching recipes.",
+		InputSchema: schemaObject([]string{}, map[string]interface{}{
+			"query":           schemaProperty("string", "Words to look for, empty lists all recipes"),
+			"category":        schemaProperty("string", "Only recipes of this category, e.g. Hauptgericht, Vorspeise, Brot, Dessert"),
+			"maxTime":         schemaProperty("integer", "Only recipes that take at most this many minutes in total, recipes without a known time are included"),
+			"diet":            schemaProperty("string", "Only vegan or vegetarian recipes, vegetarian includes vegan"),
+			"includeArchived": schemaProperty("boolean", "Also search recipes the user archived"),
+		}),
+		call: mcpSearchRecipes,
+	},
+	{
+		Name:        "get_recipe",
+		Description: "Get a recipe of the user's cookbook as markdown with ingredients and steps.",
+		InputSchema: schemaObject([]string{"id"}, map[string]interface{}{
+			"id": schemaProperty("integer", "Recipe id from search_recipes"),
+		}),
+		call: mcpGetRecipe,
+	},
+	{
+		Name:        "generate_recipe",
+		Description: "Generate a new recipe from a description. The recipe is not saved, call add_recipe to keep it.",
+		InputSchema: schemaObject([]string{"description"}, map[string]interface{}{
+			"description": schemaProperty("string", "What to cook, e.g. a dish name or the ingredients at hand"),
+			"isGerman":    schemaProperty("boolean", "Write the recipe in German (default) or English"),
+		}),
+		call: mcpGenerateRecipe,
+	},
+	{
+		Name:        "add_recipe",
+		Description: "Save a markdown recipe to the user's cookbook and publish it on their recipe site.",
+		InputSchema: schemaObject([]string{"recipename", "recipe"}, map[string]interface{}{
+			"recipename":     schemaProperty("string", "Title of the recipe"),
+			"recipe":         schemaProperty("string", "The recipe as markdown with ## Zutaten / ## Ingredients and steps"),
+			"recipecategory": schemaProperty("string", "Hauptgericht, Vorspeise, Brot or Dessert, detected when empty"),
+		}),
+		call: mcpAddRecipe,
+	},
+}
+
+func HandleMCP(w http.ResponseWriter, r *http.Request) {
+	userCtx, ok := r.Context().Value("user").(UserContext)
+	if !ok {
+		http.Error(w, "Unauthorized: user context missing", http.StatusUnauthorized)
+		return
+	}
+	userCtx.via = viaMCP
+
+	var req jsonRPCRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeJSONRPC(w, jsonRPCResponse{ID: json.RawMessage("null"), Error: &jsonRPCError{Code: jsonRPCParseError, Message: "Parse error"}})
+		return
+	}
+	if req.JSONRPC != "2.0" || req.Method == "" {
+		writeJSONRPC(w, jsonRPCResponse{ID: idOrNull(req.ID), Error: &jsonRPCError{Code: jsonRPCInvalidRequest, Message: "Invalid request"}})
+		return
+	}
+
+	// Notifications get no answer.
+	if len(req.ID) == 0 {
+		w.WriteHeader(http.StatusAccepted)
+		return
+	}
+
+	resp := jsonRPCResponse{ID: req.ID}
+	switch req.Method {
+	case "initialize":
+		var params struct {
+			ProtocolVersion string `json:"protocolVersion"`
+		}
+		_ = json.Unmarshal(req.Params, &params)
+
+		version := mcpLatestProtocolVersion
+		if slices.Contains(mcpProtocolVersions, params.ProtocolVersion) {
+			version = params.ProtocolVersion
+		}
+		resp.Result = map[string]interface{}{
+			"protocolVersion": version,
+			"capabilities":    map[string]interface{}{"tools": map[string]interface{}{}},
+			"serverInfo":      map[string]string{"name": "recipe-generator", "version": "1.0.0"},
+			"instructions":    "Tools for the user's personal cookbook. Recipes are markdown, mostly in German.",
+		}
+	case "ping":
+		resp.Result = map[string]interface{}{}
+	case "tools/list":
+		resp.Result = map[string]interface{}{"tools": mcpTools}
+	case "tools/call":
+		resp.Result, resp.Error = callMCPTool(withLLMBudget(r.Context(), newLLMBudget(r)), userCtx, req.Params)
+	default:
+		resp.Error = &jsonRPCError{Code: jsonRPCMethodNotFound, Message: "Method not found: " + req.Method}
+	}
+
+	writeJSONRPC(w, resp)
+}
+
+func callMCPTool(ctx context.Context, userCtx UserContext, raw json.RawMessage) (interface{}, *jsonRPCError) {
+	var params struct {
+		Name      string          `json:"name"`
+		Arguments json.RawMessage `json:"arguments"`
+	}
+	if err := json.Unmarshal(raw, &params); err != nil {
+		return nil, &jsonRPCError{Code: jsonRPCInvalidParams, Message: "Invalid params"}
+	}
+	if len(params.Arguments) == 0 {
+		params.Arguments = json.RawMessage("{}")
+	}
+
+	for _, tool := range mcpTools {
+		if tool.Name != params.Name {
+			continue
+		}
+
+		text, err := tool.call(ctx, userCtx, params.Arguments)
+		if err != nil {
+			var toolErr mcpToolError
+			if !errors.As(err, &toolErr) {
+				log.Printf("Error running MCP tool %s: %v\n", tool.Name, err)
+				return nil, &jsonRPCError{Code: jsonRPCInternalError, Message: "Error running " + tool.Name}
+			}
+			return mcpToolResult{Content: []mcpContent{{Type: "text", Text: toolErr.Error()}}, IsError: true}, nil
+		}
+		return mcpToolResult{Content: []mcpContent{{Type: "text", Text: text}}}, nil
+	}
+
+	return nil, &jsonRPCError{Code: jsonRPCInvalidParams, Message: "Unknown tool: " + params.Name}
+}
+
+func writeJSONRPC(w http.ResponseWriter, resp jsonRPCResponse) {
+	resp.JSONRPC = "2.0"
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+
+	err := json.NewEncoder(w).Encode(resp)
+	if err != nil {
+		log.Println("Error writing response:", err)
+	}
+}
+
+func idOrNull(id json.RawMessage) json.RawMessage {
+	if len(id) == 0 {
+		return json.RawMessage("null")
+	}
+	return id
+}
+
+func mcpSearchRecipes(ctx context.Context, userCtx UserContext, raw json.RawMessage) (string, error) {
+	var args struct {
+		Query    string `json:"query"`
+		Category string `json:"category"`
+		MaxTime  int    `json:"maxTime"`
+		Diet     string `json:"diet"`
+		// IncludeArchived also finds recipes kept for reference only.
+		IncludeArchived bool `json:"includeArchived"`
+	}
+	if err := json.Unmarshal(raw, &args); err != nil {
+		return "", mcpToolError("Invalid arguments: " + err.Error())
+	}
+
+	pattern := "%" + likeEscaper.Replace(strings.TrimSpace(args.Query)) + "%"
+	rows, err := pool.Query(ctx, `
+		SELECT id, title, category, total_minutes FROM recipes
+		WHERE user_id = $1 AND (title ILIKE $2 OR content ILIKE $2)
+		  AND ($3 = '' OR category = $3) AND ($4 = 0 OR total_minutes <= $4)
+		  AND ($6 OR NOT archived)
+		  AND ($7 = '' OR diet = $7 OR ($7 = 'vegetarian' AND diet = 'vegan'))
+		ORDER BY title LIMIT $5`,
+		userCtx.UserID, pattern, args.Category, args.MaxTime, maxMCPSearchResults, args.IncludeArchived, args.Diet)
+	if err != nil {
+		return "", err
+	}
+	defer rows.Close()
+
+	type result struct {
+		ID        int    `json:"id"`
+		Title     string `json:"title"`
+		Category  string `json:"category"`
+		TotalTime int    `json:"totalTime,omitempty"`
+	}
+	results := []result{}
+	for rows.Next() {
+		var res result
+		if err := rows.Scan(&res.ID, &res.Title, &res.Category, &res.TotalTime); err != nil {
+			return "", err
+		}
+		results = append(results, res)
+	}
+	if err := rows.Err(); err != nil {
+		return "", err
+	}
+
+	if len(results) == 0 {
+		return "No recipes found.", nil
+	}
+	text, err := json.Marshal(results)
+	return string(text), err
+}
+
+func mcpGetRecipe(ctx context.Context, userCtx UserContext, raw json.RawMessage) (string, error) {
+	var args struct {
+		ID int `json:"id"`
+	}
+	if err := json.Unmarshal(raw, &args); err != nil || args.ID == 0 {
+		return "", mcpToolError("Missing or invalid recipe id")
+	}
+
+	var slug string
+	err := pool.QueryRow(ctx, "SELECT slug FROM recipes WHERE id = $1 AND user_id = $2", args.ID, userCtx.UserID).Scan(&slug)
+	if err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			return "", mcpToolError(fmt.Sprintf("Recipe %d not found", args.ID))
+		}
+		return "", err
+	}
+
+	content, _, err := getPublishedRecipe(ctx, userCtx.UserID, slug)
+	if err != nil {
+		return "", err
+	}
+
+	return content + "\n\n" + recipeURL(userCtx.Subdomain, slug), nil
+}
+
+func mcpGenerateRecipe(ctx context.Context, userCtx UserContext, raw json.RawMessage) (string, error) {
+	args := struct {
+		Description string `json:"description"`
+		IsGerman    bool   `json:"isGerman"`
+	}{IsGerman: requestUserSettings(ctx).german()}
+	if err := json.Unmarshal(raw, &args); err != nil || strings.TrimSpace(args.Description) == "" {
+		return "", mcpToolError("Missing description")
+	}
+
+	if err := countGeneration(ctx, userCtx.UserID); err != nil {
+		if errors.Is(err, errGenerationLimit) {
+			return "", mcpToolError("Daily generation limit reached")
+		}
+		return "", err
+	}
+
+	if related, _ := isRecipeRelated(ctx, judgeModeMCP, args.Description); !related {
+		return "", mcpToolError("Input rejected by LLM judge, the description has to be about cooking")
+	}
+
+	recipe, err := GenerateRecipeByName(ctx, args.Description, args.IsGerman)
+	if errors.Is(err, errInputTooLarge) {
+		return "", mcpToolError(errInputTooLarge.Error())
+	}
+	if err != nil {
+		return "", err
+	}
+
+	return recipe, nil
+}
+
+func mcpAddRecipe(ctx context.Context, userCtx UserContext, raw json.RawMessage) (string, error) {
+	var req RecipeRequest
+	if err := json.Unmarshal(raw, &req); err != nil {
+		return "", mcpToolError("Invalid arguments: " + err.Error())
+	}
+	if req.Recipename == "" || req.Recipe == "" {
+		return "", mcpToolError("Missing recipename or recipe")
+	}
+
+	if err := checkRecipeLimit(ctx, userCtx.UserID); err != nil {
+		if errors.Is(err, errRecipeLimit) {
+			return "", mcpToolError("Recipe limit of your plan reached")
+		}
+		return "", err
+	}
+
+	recipe, err := addRecipe(ctx, userCtx, req)
+	if err != nil {
+		return "", err
+	}
+
+	return "Recipe saved: " + recipe.URL, nil
+}
@@ -0,0 +1,468 @@
+package main
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+)
+
+const (
+	mealBreakfast = "breakfast"
+	mealLunch     = "lunch"
+	mealDinner    = "dinner"
+)
+
+var mealLabels = map[string]string{
+	mealBreakfast: "Frühstück",
+	mealLunch:     "Mittagessen",
+	mealDinner:    "Abendessen",
+}
+
+// mealPlanHorizon is how far back and ahead the calendar feed and plan.md
+// reach.
+const mealPlanHorizon = 60 * 24 * time.Hour
+
+type MealPlanEntry struct {
+	ID         int64  `json:"id"`
+	RecipeID   int    `json:"recipeID"`
+	Recipename string `json:"recipename"`
+	Slug       string `json:"slug"`
+	Draft      bool   `json:"draft,omitempty"`
+	// Day is YYYY-MM-DD.
+	Day  string `json:"day"`
+	Meal string `json:"meal"`
+	Note string `json:"note,omitempty"`
+	// Schedule puts the steps of a multi-day recipe into the calendar feed,
+	// timed so the meal is ready on the day.
+	Schedule bool `json:"schedule,omitempty"`
+}
+
+func mealPlanEntries(ctx context.Context, userID int, from time.Time, to time.Time) ([]MealPlanEntry, error) {
+	rows, err := pool.Query(ctx, `
+		SELECT m.id, m.recipe_id, r.title, r.slug, r.draft, m.day, m.meal, m.note, m.schedule
+		FROM meal_plan_entries m JOIN recipes r ON r.id = m.recipe_id
+		WHERE m.user_id = $1 AND m.day BETWEEN $2 AND $3
+		ORDER BY m.day, array_position(ARRAY['breakfast', 'lunch', 'dinner'], m.meal), m.id`,
+		userID, from, to)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	entries := []MealPlanEntry{}
+	for rows.Next() {
+		var entry MealPlanEntry
+		var day time.Time
+		if err := rows.Scan(&entry.ID, &entry.RecipeID, &entry.Recipename, &entry.Slug, &entry.Draft, &day, &entry.Meal, &entry.Note, &entry.Schedule); err != nil {
+			return nil, err
+		}
+		entry.Day = day.Format(time.DateOnly)
+		entries = append(entries, entry)
+	}
+	return entries, rows.Err()
+}
+
+func HandleGetMealPlan(w http.ResponseWriter, r *http.Request) {
+	userCtx, ok := r.Context().Value("user").(UserContext)
+	if !ok {
+		http.Error(w, "Unauthorized: user context missing", http.StatusUnauthorized)
+		return
+	}
+
+	from, to := time.Now().AddDate(0, 0, -7), time.Now().AddDate(0, 0, 28)
+	if value := r.URL.Query().Get("from"); value != "" {
+		parsed, err := time.Parse(time.DateOnly, value)
+		if err != nil {
+			http.Error(w, "from must be YYYY-MM-DD", http.StatusBadRequest)
+			return
+		}
+		from = parsed
+	}
+	if value := r.URL.Query().Get("to"); value != "" {
+		parsed, err := time.Parse(time.DateOnly, value)
+		if err != nil {
+			http.Error(w, "to must be YYYY-MM-DD", http.StatusBadRequest)
+			return
+		}
+		to = parsed
+	}
+
+	entries, err := mealPlanEntries(r.Context(), userCtx.UserID, from, to)
+	if err != nil {
+		log.Printf("Error getting meal plan: %v\n", err)
+		http.Error(w, "Error getting meal plan", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+
+	err = json.NewEncoder(w).Encode(entries)
+	if err != nil {
+		http.Error(w, "Error encoding JSON response", http.StatusInternalServerError)
+	}
+}
+
+func HandleAddMealPlanEntry(w http.ResponseWriter, r *http.Request) {
+	userCtx, ok := r.Context().Value("user").(UserContext)
+	if !ok {
+		http.Error(w, "Unauthorized: user context missing", http.StatusUnauthorized)
+		return
+	}
+
+	var entry MealPlanEntry
+	if err := json.NewDecoder(r.Body).Decode(&entry); err != nil {
+		http.Error(w, "Invalid JSON payload", http.StatusBadRequest)
+		return
+	}
+
+	day, err := time.Parse(time.DateOnly, entry.Day)
+	if err != nil {
+		http.Error(w, "day must be YYYY-MM-DD", http.StatusBadRequest)
+		return
+	}
+	if entry.Meal == "" {
+		entry.Meal = mealDinner
+	}
+	if _, known := mealLabels[entry.Meal]; !known {
+		http.Error(w, "meal must be breakfast, lunch or dinner", http.StatusBadRequest)
+		return
+	}
+
+	err = pool.QueryRow(r.Context(), `
+		INSERT INTO meal_plan_entries (user_id, recipe_id, day, meal, note, schedule)
+		SELECT $1, id, $3::date, $4::text, $5::text, $6::boolean FROM recipes WHERE id = $2 AND user_id = $1
+		RETURNING id`,
+		userCtx.UserID, entry.RecipeID, day, entry.Meal, entry.Note, entry.Schedule).Scan(&entry.ID)
+	if err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			http.Error(w, "Recipe not found", http.StatusNotFound)
+			return
+		}
+		log.Printf("Error adding meal plan entry: %v\n", err)
+		http.Error(w, "Error adding meal plan entry", http.StatusInternalServerError)
+		return
+	}
+
+	publishMealPlan(r.Context(), userCtx)
+
+	// extracted now, so the calendar feed finds the schedule ready
+	if entry.Schedule {
+		if _, err := recipeScheduleSteps(r.Context(), userCtx.UserID, entry.RecipeID); err != nil {
+			log.Printf("Error getting schedule of recipe %d: %v\n", entry.RecipeID, err)
+		}
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+
+	err = json.NewEncoder(w).Encode(map[string]int64{"id": entry.ID})
+	if err != nil {
+		http.Error(w, "Error encoding JSON response", http.StatusInternalServerError)
+	}
+}
+
+func HandleDeleteMealPlanEntry(w http.ResponseWriter, r *http.Request) {
+	userCtx, ok := r.Context().Value("user").(UserContext)
+	if !ok {
+		http.Error(w, "Unauthorized: user context missing", http.StatusUnauthorized)
+		return
+	}
+
+	id, err := strconv.ParseInt(r.PathValue("id"), 10, 64)
+	if err != nil {
+		http.Error(w, "Invalid meal plan entry ID", http.StatusBadRequest)
+		return
+	}
+
+	tag, err := pool.Exec(r.Context(), "DELETE FROM meal_plan_entries WHERE id = $1 AND user_id = $2", id, userCtx.UserID)
+	if err != nil {
+		log.Printf("Error deleting meal plan entry: %v\n", err)
+		http.Error(w, "Error deleting meal plan entry", http.StatusInternalServerError)
+		return
+	}
+	if tag.RowsAffected() == 0 {
+		http.Error(w, "Meal plan entry not found", http.StatusNotFound)
+		return
+	}
+
+	publishMealPlan(r.Context(), userCtx)
+
+	w.WriteHeader(http.StatusOK)
+}
+
+// publishMealPlan uploads plan.md and tells the plan.published webhooks, a
+// failed upload only delays the page until the next change or the next
+// morning.
+func publishMealPlan(ctx context.Context, userCtx UserContext) {
+	if err := uploadMealPlan(ctx, userCtx.UserID, userCtx.Subdomain); err != nil {
+		log.Printf("Error publishing meal plan for user %d: %v\n", userCtx.UserID, err)
+		return
+	}
+	emitEvent(userCtx.UserID, eventPlanPublished, map[string]string{"url": siteURL(userCtx.Subdomain) + "?page=plan"})
+}
+
+// uploadMealPlan renders plan.md for today and notes the day it was
+// published for.
+func uploadMealPlan(ctx context.Context, userID int, subdomain string) error {
+	today := time.Now().Format(time.DateOnly)
+	page, err := renderMealPlanPage(ctx, userID)
+	if err != nil {
+		return err
+	}
+	err = withUserLock(ctx, publishLockNamespace, userID, func() error {
+		return addBlob(subdomain, "plan.md", page)
+	})
+	if err != nil {
+		return err
+	}
+	_, err = pool.Exec(ctx, "UPDATE users SET plan_published_for = $1 WHERE id = $2", today, userID)
+	return err
+}
+
+// refreshMealPlanPages republishes plan.md once a day for users with
+// entries from the day it was last published for on, the page starts at
+// today and would keep showing past days until the plan changes. It runs as
+// a scheduled job. The webhooks are only told about changes.
+func refreshMealPlanPages(ctx context.Context) error {
+	rows, err := pool.Query(ctx, `
+		SELECT id, subdomain FROM users u
+		WHERE provisioning_status = $1 AND plan_published_for <> $2
+		  AND EXISTS (SELECT 1 FROM meal_plan_entries m WHERE m.user_id = u.id
+		              AND m.day >= coalesce(nullif(u.plan_published_for, '')::date, '-infinity'::date))`,
+		provisioningReady, time.Now().Format(time.DateOnly))
+	if err != nil {
+		return err
+	}
+
+	type dueUser struct {
+		id        int
+		subdomain string
+	}
+	var due []dueUser
+	for rows.Next() {
+		var u dueUser
+		if err := rows.Scan(&u.id, &u.subdomain); err != nil {
+			rows.Close()
+			return err
+		}
+		due = append(due, u)
+	}
+	rows.Close()
+
+	for _, u := range due {
+		if err := uploadMealPlan(ctx, u.id, u.subdomain); err != nil {
+			log.Printf("Error refreshing meal plan for user %d: %v\n", u.id, err)
+		}
+	}
+	return nil
+}
+
+// renderMealPlanPage lists today and the coming weeks.
+func renderMealPlanPage(ctx context.Context, userID int) (string, error) {
+	from := time.Now()
+	entries, err := mealPlanEntries(ctx, userID, from, from.Add(mealPlanHorizon))
+	if err != nil {
+		return "", err
+	}
+
+	var page strings.Builder
+	page.WriteString("# Essensplan\n\n[Alle Rezepte](./)\n")
+	if len(entries) == 0 {
+		page.WriteString("\nNoch nichts geplant.\n")
+	}
+
+	lastDay := ""
+	for _, entry := range entries {
+		if entry.Day != lastDay {
+			day, _ := time.Parse(time.DateOnly, entry.Day)
+			page.WriteString("\n## " + germanWeekdays[day.Weekday()] + ", " + day.Format("02.01.2006") + "\n")
+			lastDay = entry.Day
+		}
+		// drafts have no page to link to
+		if entry.Draft {
+			page.WriteString("- " + mealLabels[entry.Meal] + ": " + entry.Recipename)
+		} else {
+			page.WriteString("- " + mealLabels[entry.Meal] + ": [" + entry.Recipename + "](./?recipe=" + entry.Slug + ")")
+		}
+		if entry.Note != "" {
+			page.WriteString(" (" + entry.Note + ")")
+		}
+		page.WriteString("\n")
+	}
+
+	return page.String(), nil
+}
+
+var germanWeekdays = []string{"Sonntag", "Montag", "Dienstag", "Mittwoch", "Donnerstag", "Freitag", "Samstag"}
+
+// HandleGetCalendarURL returns the user's secret feed URL, calendar apps
+// can't send a bearer token. rotate=true replaces a leaked URL.
+func HandleGetCalendarURL(w http.ResponseWriter, r *http.Request) {
+	userCtx, ok := r.Context().Value("user").(UserContext)
+	if !ok {
+		http.Error(w, "Unauthorized: user context missing", http.StatusUnauthorized)
+		return
+	}
+
+	var token string
+	err := pool.QueryRow(r.Context(), "SELECT calendar_token FROM users WHERE id = $1", userCtx.UserID).Scan(&token)
+	if err != nil {
+		log.Printf("Error getting calendar token: %v\n", err)
+		http.Error(w, "Error getting calendar URL", http.StatusInternalServerError)
+		return
+	}
+
+	if token == "" || r.URL.Query().Get("rotate") == "true" {
+		random := make([]byte, 24)
+		if _, err := rand.Read(random); err != nil {
+			log.Printf("Error generating calendar token: %v\n", err)
+			http.Error(w, "Error getting calendar URL", http.StatusInternalServerError)
+			return
+		}
+		token = hex.EncodeToString(random)
+
+		_, err = pool.Exec(r.Context(), "UPDATE users SET calendar_token = $1 WHERE id = $2", token, userCtx.UserID)
+		if err != nil {
+			log.Printf("Error saving calendar token: %v\n", err)
+			http.Error(w, "Error getting calendar URL", http.StatusInternalServerError)
+			return
+		}
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+
+	err = json.NewEncoder(w).Encode(map[string]string{
+		"url": strings.TrimSuffix(os.Getenv("PUBLIC_URL"), "/") + "/calendar/" + token + ".ics",
+	})
+	if err != nil {
+		http.Error(w, "Error encoding JSON response", http.StatusInternalServerError)
+	}
+}
+
+func HandleCalendarFeed(w http.ResponseWriter, r *http.Request) {
+	token := strings.TrimSuffix(r.PathValue("file"), ".ics")
+	if token == "" {
+		http.NotFound(w, r)
+		return
+	}
+
+	var userID int
+	var subdomain string
+	err := pool.QueryRow(r.Context(), "SELECT id, subdomain FROM users WHERE calendar_token = $1", token).Scan(&userID, &subdomain)
+	if err != nil {
+		if !errors.Is(err, pgx.ErrNoRows) {
+			log.Printf("Error getting calendar user: %v\n", err)
+		}
+		http.NotFound(w, r)
+		return
+	}
+
+	now := time.Now()
+	entries, err := mealPlanEntries(r.Context(), userID, now.Add(-mealPlanHorizon), now.Add(mealPlanHorizon))
+	if err != nil {
+		log.Printf("Error getting meal plan: %v\n", err)
+		http.Error(w, "Error getting meal plan", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "text/calendar; charset=utf-8")
+	w.WriteHeader(http.StatusOK)
+	_, _ = w.Write([]byte(renderCalendar(entries, mealPlanSchedules(r.Context(), userID, entries), subdomain, now)))
+}
+
+// renderCalendar writes all-day events, the plan has no times. The steps of
+// scheduled entries are timed events in floating local time, with an alarm
+// for the ones that need a reminder.
+func renderCalendar(entries []MealPlanEntry, schedules map[int64]RecipeSchedule, subdomain string, now time.Time) string {
+	var cal strings.Builder
+	line := func(content string) {
+		cal.WriteString(foldICalLine(content) + "\r\n")
+	}
+
+	line("BEGIN:VCALENDAR")
+	line("VERSION:2.0")
+	line("PRODID:-//recipe-generator//Essensplan//DE")
+	line("CALSCALE:GREGORIAN")
+	line("X-WR-CALNAME:Essensplan")
+	stamp := now.UTC().Format("20060102T150405Z")
+	for _, entry := range entries {
+		day, _ := time.Parse(time.DateOnly, entry.Day)
+		url := ""
+		if !entry.Draft {
+			url = recipeURL(subdomain, entry.Slug)
+		}
+		description := strings.TrimSpace(entry.Note + "\n" + url)
+
+		line("BEGIN:VEVENT")
+		line(fmt.Sprintf("UID:meal-%d@recipe-generator", entry.ID))
+		line("DTSTAMP:" + stamp)
+		line("DTSTART;VALUE=DATE:" + day.Format("20060102"))
+		line("DTEND;VALUE=DATE:" + day.AddDate(0, 0, 1).Format("20060102"))
+		line("SUMMARY:" + escapeICalText(mealLabels[entry.Meal]+": "+entry.Recipename))
+		line("DESCRIPTION:" + escapeICalText(description))
+		if url != "" {
+			line("URL:" + url)
+		}
+		line("TRANSP:TRANSPARENT")
+		line("END:VEVENT")
+
+		for i, step := range schedules[entry.ID].Steps {
+			end := step.At.Add(time.Duration(max(step.DurationMinutes, minScheduleMinutes)) * time.Minute)
+
+			line("BEGIN:VEVENT")
+			line(fmt.Sprintf("UID:meal-%d-step-%d@recipe-generator", entry.ID, i))
+			line("DTSTAMP:" + stamp)
+			line("DTSTART:" + step.At.Format("20060102T150405"))
+			line("DTEND:" + end.Format("20060102T150405"))
+			line("SUMMARY:" + escapeICalText(entry.Recipename+": "+step.Title))
+			if url != "" {
+				line("URL:" + url)
+			}
+			if step.Reminder {
+				line("BEGIN:VALARM")
+				line("ACTION:DISPLAY")
+				line("DESCRIPTION:" + escapeICalText(step.Title))
+				line("TRIGGER:-PT10M")
+				line("END:VALARM")
+			}
+			line("END:VEVENT")
+		}
+	}
+	line("END:VCALENDAR")
+
+	return cal.String()
+}
+
+func escapeICalText(text string) string {
+	return strings.NewReplacer(`\`, `\\`, ";", `\;`, ",", `\,`, "\n", `\n`).Replace(text)
+}
+
+// foldICalLine breaks lines longer than 75 octets as RFC 5545 requires,
+// without splitting UTF-8 sequences.
+func foldICalLine(content string) string {
+	var folded strings.Builder
+	length := 0
+	for _, c := range content {
+		size := len(string(c))
+		if length+size > 75 {
+			folded.WriteString("\r\n ")
+			length = 1
+		}
+		folded.WriteRune(c)
+		length += size
+	}
+	return folded.String()
+}
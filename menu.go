@@ -0,0 +1,265 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"slices"
+	"strconv"
+	"strings"
+)
+
+// maxMenuCandidates bounds how many of the user's own recipes are offered
+// to the model for the courses.
+const maxMenuCandidates = 150
+
+const (
+	maxMenuGuests           = 100
+	maxMenuConstraintLength = 500
+)
+
+// menuCourses are the courses a menu can have, in the order they are served.
+var menuCourses = []string{"starter", "soup", "main", "side", "dessert"}
+
+var defaultMenuCourses = []string{"starter", "main", "dessert"}
+
+const menuSystemMessage = `You plan menus for events. Pick one dish per course so the courses go well together
+and fit the occasion, the number of guests and the constraints.
+Answer with a JSON object of the form
+{"title": "...", "courses": [{"course": "starter", "name": "...", "reason": "...", "recipeID": 0}]}
+with the courses in the order they were asked for. Keep each reason to one sentence.`
+
+type MenuRequest struct {
+	Occasion    string   `json:"occasion"`
+	Guests      int      `json:"guests"`
+	Constraints string   `json:"constraints"`
+	Courses     []string `json:"courses"`
+	IsGerman    bool     `json:"isGerman"`
+}
+
+// plannedCourse is a course as the model answers it.
+type plannedCourse struct {
+	Course   string `json:"course"`
+	Name     string `json:"name"`
+	Reason   string `json:"reason"`
+	RecipeID int    `json:"recipeID"`
+}
+
+type MenuCourse struct {
+	Course string `json:"course"`
+	Reason string `json:"reason,omitempty"`
+	// Owned is set for recipes from the user's collection, the others are
+	// generated and not saved.
+	Owned  bool   `json:"owned"`
+	Recipe Recipe `json:"recipe"`
+}
+
+type ShoppingListItem struct {
+	Name    string   `json:"name"`
+	Amounts []string `json:"amounts,omitempty"`
+	Courses []string `json:"courses"`
+}
+
+type Menu struct {
+	Title        string             `json:"title"`
+	Occasion     string             `json:"occasion"`
+	Guests       int                `json:"guests"`
+	Courses      []MenuCourse       `json:"courses"`
+	ShoppingList []ShoppingListItem `json:"shoppingList"`
+}
+
+// HandleGenerateMenu plans a menu for an event. Courses are taken from the
+// user's recipes where one fits and generated otherwise, the shopping list
+// combines the ingredients of all courses.
+func HandleGenerateMenu(w http.ResponseWriter, r *http.Request) {
+	userCtx, ok := r.Context().Value("user").(UserContext)
+	if !ok {
+		http.Error(w, "Unauthorized: user context missing", http.StatusUnauthorized)
+		return
+	}
+
+	req := MenuRequest{IsGerman: requestUserSettings(r.Context()).german()}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "Invalid JSON payload", http.StatusBadRequest)
+		return
+	}
+	req.Occasion = strings.Join(strings.Fields(req.Occasion), " ")
+	req.Constraints = strings.Join(strings.Fields(req.Constraints), " ")
+	if req.Occasion == "" {
+		http.Error(w, "Missing occasion", http.StatusBadRequest)
+		return
+	}
+	if req.Guests < 1 || req.Guests > maxMenuGuests {
+		http.Error(w, "guests must be between 1 and "+strconv.Itoa(maxMenuGuests), http.StatusBadRequest)
+		return
+	}
+	if len([]rune(req.Occasion)) > maxMenuConstraintLength || len([]rune(req.Constraints)) > maxMenuConstraintLength {
+		http.Error(w, "occasion and constraints must be at most "+strconv.Itoa(maxMenuConstraintLength)+" characters", http.StatusBadRequest)
+		return
+	}
+	if len(req.Courses) == 0 {
+		req.Courses = slices.Clone(defaultMenuCourses)
+	}
+	for _, course := range req.Courses {
+		if !slices.Contains(menuCourses, course) {
+			http.Error(w, "courses must be one of "+strings.Join(menuCourses, ", "), http.StatusBadRequest)
+			return
+		}
+	}
+	slices.SortStableFunc(req.Courses, func(a, b string) int {
+		return slices.Index(menuCourses, a) - slices.Index(menuCourses, b)
+	})
+	req.Courses = slices.Compact(req.Courses)
+
+	menu, err := generateMenu(r.Context(), userCtx.UserID, req)
+	if err != nil {
+		log.Printf("Error generating menu: %v\n", err)
+		http.Error(w, "Error generating menu", http.StatusInternalServerError)
+		return
+	}
+	for i, course := range menu.Courses {
+		if course.Owned {
+			menu.Courses[i].Recipe.URL = recipeURL(userCtx.Subdomain, course.Recipe.Slug)
+		}
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+
+	err = json.NewEncoder(w).Encode(menu)
+	if err != nil {
+		http.Error(w, "Error encoding JSON response", http.StatusInternalServerError)
+	}
+}
+
+func generateMenu(ctx context.Context, userID int, req MenuRequest) (Menu, error) {
+	rows, err := pool.Query(ctx, `
+		SELECT id, title, category FROM recipes WHERE user_id = $1 AND NOT archived AND translation_of IS NULL
+		ORDER BY updated_at DESC LIMIT $2`, userID, maxMenuCandidates)
+	if err != nil {
+		return Menu{}, err
+	}
+	candidates := map[int]bool{}
+	var list strings.Builder
+	for rows.Next() {
+		var id int
+		var title, category string
+		if err := rows.Scan(&id, &title, &category); err != nil {
+			rows.Close()
+			return Menu{}, err
+		}
+		candidates[id] = true
+		fmt.Fprintf(&list, "%d: %s (%s)\n", id, title, category)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return Menu{}, err
+	}
+
+	system := menuSystemMessage
+	if req.IsGerman {
+		system += "\nAnswer in German."
+	} else {
+		system += "\nAnswer in English."
+	}
+	if len(candidates) > 0 {
+		system += "\nPrefer dishes from the user's own recipes listed below as id: title (category), and set recipeID for those. " +
+			"Use recipeID 0 for dishes that are not in the list.\n" + list.String()
+	}
+
+	prompt := fmt.Sprintf("Occasion: %s\nGuests: %d\nCourses: %s", req.Occasion, req.Guests, strings.Join(req.Courses, ", "))
+	if req.Constraints != "" {
+		prompt += "\nConstraints: " + req.Constraints
+	}
+
+	var answer struct {
+		Title   string          `json:"title"`
+		Courses []plannedCourse `json:"courses"`
+	}
+	err = completeJSON(ctx, llmRequest{Task: llmTaskMenu, System: system, Prompt: prompt}, &answer)
+	if err != nil {
+		return Menu{}, err
+	}
+
+	menu := Menu{Title: answer.Title, Occasion: req.Occasion, Guests: req.Guests, Courses: []MenuCourse{}}
+	for _, course := range req.Courses {
+		index := slices.IndexFunc(answer.Courses, func(planned plannedCourse) bool {
+			return planned.Course == course
+		})
+		if index < 0 || strings.TrimSpace(answer.Courses[index].Name) == "" {
+			return Menu{}, fmt.Errorf("no dish planned for %s", course)
+		}
+		planned := answer.Courses[index]
+		entry := MenuCourse{Course: course, Reason: planned.Reason}
+
+		// Only use references the model was actually offered.
+		if candidates[planned.RecipeID] {
+			entry.Owned = true
+			err = pool.QueryRow(ctx, `
+				SELECT id, title, content, category, slug, prep_minutes, cook_minutes, total_minutes, difficulty
+				FROM recipes WHERE id = $1 AND user_id = $2`, planned.RecipeID, userID).Scan(&entry.Recipe.ID, &entry.Recipe.Recipename,
+				&entry.Recipe.Recipe, &entry.Recipe.Category, &entry.Recipe.Slug, &entry.Recipe.PrepTime, &entry.Recipe.CookTime,
+				&entry.Recipe.TotalTime, &entry.Recipe.Difficulty)
+			if err != nil {
+				return Menu{}, err
+			}
+		} else {
+			description := fmt.Sprintf("%s, for %d people", planned.Name, req.Guests)
+			if req.IsGerman {
+				description = fmt.Sprintf("%s, für %d Personen", planned.Name, req.Guests)
+			}
+			if req.Constraints != "" {
+				description += ". " + req.Constraints
+			}
+			content, err := GenerateRecipeByName(ctx, description, req.IsGerman)
+			if err != nil {
+				return Menu{}, err
+			}
+			entry.Recipe = Recipe{Recipename: strings.TrimSpace(planned.Name), Recipe: content}
+			addTimingEstimate(ctx, &entry.Recipe)
+		}
+		menu.Courses = append(menu.Courses, entry)
+	}
+	if menu.Title == "" {
+		menu.Title = req.Occasion
+	}
+	menu.ShoppingList = combinedShoppingList(loadIngredientDictionary(ctx), menu.Courses, req.IsGerman)
+
+	return menu, nil
+}
+
+// combinedShoppingList lists every ingredient once with the amounts of all
+// courses that need it, names the dictionary knows are combined under their
+// canonical name. Amounts are kept as written, units rarely match up across
+// recipes.
+func combinedShoppingList(dictionary ingredientDictionary, courses []MenuCourse, isGerman bool) []ShoppingListItem {
+	items := []ShoppingListItem{}
+	byKey := map[string]int{}
+	for _, course := range courses {
+		for _, ingredient := range parseRecipeMarkdown(course.Recipe.Recipe).Ingredients {
+			name := canonicalIngredientName(dictionary, ingredient.Name, isGerman)
+			if name == "" {
+				continue
+			}
+			key := strings.ToLower(name)
+			index, found := byKey[key]
+			if !found {
+				index = len(items)
+				byKey[key] = index
+				items = append(items, ShoppingListItem{Name: name, Courses: []string{}})
+			}
+			if ingredient.Amount != "" {
+				items[index].Amounts = append(items[index].Amounts, ingredient.Amount)
+			}
+			if !slices.Contains(items[index].Courses, course.Course) {
+				items[index].Courses = append(items[index].Courses, course.Course)
+			}
+		}
+	}
+	slices.SortStableFunc(items, func(a, b ShoppingListItem) int {
+		return strings.Compare(strings.ToLower(a.Name), strings.ToLower(b.Name))
+	})
+	return items
+}
@@ -0,0 +1,238 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/jackc/pgx/v5"
+)
+
+const revisionMerged = "merged"
+
+const mergeSystemMessage = "You merge two versions of the same cooking recipe written in markdown into one. " +
+	"Keep every ingredient and step either version has, and where the quantities, times or temperatures differ pick the ones " +
+	"that fit together best. Keep the markdown structure and the language of the first recipe. Answer only with the merged recipe in markdown."
+
+type mergeRequest struct {
+	// RecipeID is kept, DuplicateID is merged into it and deleted.
+	RecipeID    int `json:"recipeID"`
+	DuplicateID int `json:"duplicateID"`
+}
+
+// HandleMergeRecipes combines two near-duplicate recipes into the first one.
+// The duplicate's revisions move over and its last content is kept as a
+// merged revision, so the history of both stays. Collections, meal plans and
+// cooking sessions point to the kept recipe afterwards, the duplicate's
+// pages are taken down.
+func HandleMergeRecipes(w http.ResponseWriter, r *http.Request) {
+	userCtx, ok := r.Context().Value("user").(UserContext)
+	if !ok {
+		http.Error(w, "Unauthorized: user context missing", http.StatusUnauthorized)
+		return
+	}
+
+	var req mergeRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "Invalid JSON payload", http.StatusBadRequest)
+		return
+	}
+	if req.RecipeID == 0 || req.DuplicateID == 0 {
+		http.Error(w, "Missing recipeID or duplicateID", http.StatusBadRequest)
+		return
+	}
+	if req.RecipeID == req.DuplicateID {
+		http.Error(w, "A recipe can't be merged into itself", http.StatusBadRequest)
+		return
+	}
+
+	var duplicate Recipe
+	kept, err := getMergeRecipe(r.Context(), userCtx.UserID, req.RecipeID)
+	if err == nil {
+		duplicate, err = getMergeRecipe(r.Context(), userCtx.UserID, req.DuplicateID)
+	}
+	if err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			http.Error(w, "Recipe not found", http.StatusNotFound)
+			return
+		}
+		log.Printf("Error getting recipe: %v\n", err)
+		http.Error(w, "Error getting recipe", http.StatusInternalServerError)
+		return
+	}
+	if kept.TranslationOf != 0 || duplicate.TranslationOf != 0 {
+		http.Error(w, "Merge the original recipes instead of translations", http.StatusBadRequest)
+		return
+	}
+
+	merged, err := llm.Complete(r.Context(), llmRequest{
+		Task:   llmTaskMerge,
+		System: mergeSystemMessage,
+		Prompt: "First recipe:\n\nTitle: " + kept.Recipename + "\n\n" + kept.Recipe +
+			"\n\nSecond recipe:\n\nTitle: " + duplicate.Recipename + "\n\n" + duplicate.Recipe,
+	})
+	merged = strings.TrimSpace(merged)
+	if err != nil || merged == "" {
+		log.Printf("Error merging recipe %d into %d: %v\n", duplicate.ID, kept.ID, err)
+		http.Error(w, "Error merging recipes", http.StatusInternalServerError)
+		return
+	}
+
+	recipe := Recipe{ID: kept.ID, Recipename: kept.Recipename, Recipe: merged, Category: kept.Category, Slug: kept.Slug, SourceURL: kept.SourceURL}
+	if recipe.SourceURL == "" {
+		recipe.SourceURL = duplicate.SourceURL
+	}
+	addTimingEstimate(r.Context(), &recipe)
+
+	// translations go with the duplicate, their pages come down with its own
+	removed := []string{duplicate.Slug}
+	var variants []string
+	err = inTransaction(r.Context(), func(tx pgx.Tx) error {
+		ctx := r.Context()
+		translations, err := querySlugs(ctx, tx, "SELECT slug FROM recipes WHERE translation_of = $1", duplicate.ID)
+		if err != nil {
+			return err
+		}
+		removed = append(removed, translations...)
+
+		// variants for an appliance the kept recipe has no variant for move
+		// over, the others are left standalone. All of them are published
+		// again for their links.
+		_, err = tx.Exec(ctx, `
+			UPDATE recipes SET variant_of = $1 WHERE variant_of = $2
+			AND appliance NOT IN (SELECT appliance FROM recipes WHERE variant_of = $1)`,
+			kept.ID, duplicate.ID)
+		if err != nil {
+			return err
+		}
+		variants, err = querySlugs(ctx, tx, "SELECT slug FROM recipes WHERE variant_of = $1 OR variant_of = $2", kept.ID, duplicate.ID)
+		if err != nil {
+			return err
+		}
+
+		if _, err := tx.Exec(ctx, "UPDATE recipe_revisions SET recipe_id = $1 WHERE recipe_id = $2", kept.ID, duplicate.ID); err != nil {
+			return err
+		}
+		_, err = tx.Exec(ctx, `
+			INSERT INTO recipe_revisions (recipe_id, user_id, base_version, change_prompt, content, status, decided_at)
+			VALUES ($1, $2, $3, $4, $5, $6, now()), ($1, $2, $7, $8, $9, $6, now())`,
+			kept.ID, userCtx.UserID,
+			duplicate.Version, "Merged from "+duplicate.Recipename+" (recipe "+strconv.Itoa(duplicate.ID)+")", duplicate.Recipe, revisionMerged,
+			kept.Version, "Before merging "+duplicate.Recipename+" (recipe "+strconv.Itoa(duplicate.ID)+")", kept.Recipe)
+		if err != nil {
+			return err
+		}
+
+		_, err = tx.Exec(ctx, `
+			INSERT INTO collection_recipes (collection_id, recipe_id, added_at)
+			SELECT collection_id, $1, added_at FROM collection_recipes WHERE recipe_id = $2
+			ON CONFLICT DO NOTHING`, kept.ID, duplicate.ID)
+		if err != nil {
+			return err
+		}
+		// Copies of the duplicate are based on the kept recipe now, a kept
+		// recipe based on the duplicate loses its parent.
+		if _, err := tx.Exec(ctx, "UPDATE recipes SET parent_recipe_id = NULLIF($1, id) WHERE parent_recipe_id = $2", kept.ID, duplicate.ID); err != nil {
+			return err
+		}
+		for _, table := range []string{"meal_plan_entries", "cooking_sessions", "recipe_ask_sessions"} {
+			if _, err := tx.Exec(ctx, "UPDATE "+table+" SET recipe_id = $1 WHERE recipe_id = $2", kept.ID, duplicate.ID); err != nil {
+				return err
+			}
+		}
+
+		err = tx.QueryRow(ctx, `
+			UPDATE recipes SET content = $1, source_url = $2, prep_minutes = $3, cook_minutes = $4, total_minutes = $5, difficulty = $6,
+			updated_at = now(), version = version + 1, season_tagged_at = NULL, season_tag_failures = 0, diet = ''
+			WHERE id = $7 RETURNING version, updated_at`,
+			recipe.Recipe, recipe.SourceURL, recipe.PrepTime, recipe.CookTime, recipe.TotalTime, recipe.Difficulty,
+			kept.ID).Scan(&recipe.Version, &recipe.UpdatedAt)
+		if err != nil {
+			return err
+		}
+
+		_, err = tx.Exec(ctx, "DELETE FROM recipes WHERE id = $1", duplicate.ID)
+		return err
+	})
+	if err != nil {
+		log.Printf("Error merging recipe %d into %d: %v\n", duplicate.ID, kept.ID, err)
+		http.Error(w, "Error merging recipes", http.StatusInternalServerError)
+		return
+	}
+	invalidateRecipes(userCtx.UserID)
+
+	after := recipeAuditSummary(recipe.Recipename, recipe.Category, recipe.Version, recipe.Recipe)
+	after.Detail = "merged recipe " + strconv.Itoa(duplicate.ID)
+	recordAudit(r.Context(), userCtx, AuditEntry{
+		Action:   auditRecipeMerged,
+		RecipeID: kept.ID,
+		Slug:     kept.Slug,
+		Before:   recipeAuditSummary(kept.Recipename, kept.Category, kept.Version, kept.Recipe),
+		After:    after,
+	})
+	recordAudit(r.Context(), userCtx, AuditEntry{
+		Action:   auditRecipeDeleted,
+		RecipeID: duplicate.ID,
+		Slug:     duplicate.Slug,
+		Before:   recipeAuditSummary(duplicate.Recipename, duplicate.Category, duplicate.Version, duplicate.Recipe),
+	})
+
+	// the removed recipes are no longer found, publishing takes their pages
+	// down
+	for _, slug := range append(append([]string{kept.Slug}, removed...), variants...) {
+		if err := publishRecipeBlob(userCtx.Subdomain, userCtx.UserID, slug); err != nil {
+			log.Printf("Error publishing recipe: %v\n", err)
+			http.Error(w, "Failed to publish recipe", http.StatusInternalServerError)
+			return
+		}
+	}
+	if err := templateRecipesBlob(userCtx.Subdomain, userCtx.UserID); err != nil {
+		log.Printf("Error updating recipe template: %v\n", err)
+		http.Error(w, "Failed to update recipe template", http.StatusInternalServerError)
+		return
+	}
+
+	emitEvent(userCtx.UserID, eventRecipeDeleted, map[string]int{"id": duplicate.ID})
+	emitEvent(userCtx.UserID, eventRecipeUpdated, recipe)
+	recipe.URL = recipeURL(userCtx.Subdomain, recipe.Slug)
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+
+	err = json.NewEncoder(w).Encode(recipe)
+	if err != nil {
+		http.Error(w, "Error encoding JSON response", http.StatusInternalServerError)
+	}
+}
+
+func getMergeRecipe(ctx context.Context, userID int, recipeID int) (Recipe, error) {
+	var recipe Recipe
+	err := pool.QueryRow(ctx, `
+		SELECT id, title, content, category, slug, version, coalesce(translation_of, 0), source_url
+		FROM recipes WHERE id = $1 AND user_id = $2`,
+		recipeID, userID).Scan(&recipe.ID, &recipe.Recipename, &recipe.Recipe, &recipe.Category, &recipe.Slug,
+		&recipe.Version, &recipe.TranslationOf, &recipe.SourceURL)
+	return recipe, err
+}
+
+func querySlugs(ctx context.Context, tx pgx.Tx, query string, args ...any) ([]string, error) {
+	rows, err := tx.Query(ctx, query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var slugs []string
+	for rows.Next() {
+		var slug string
+		if err := rows.Scan(&slug); err != nil {
+			return nil, err
+		}
+		slugs = append(slugs, slug)
+	}
+	return slugs, rows.Err()
+}
@@ -14,7 +14,7 @@ import (
 func s3Client() (*minio.Client, error) {
 	endpoint := os.Getenv("S3_ENDPOINT")
 	accessKeyID := os.Getenv("S3_ACCESS")
-	secretAccessKey := os.Getenv("S3_SECRET")
+	secretAccessKey, _ := lookupSecret("S3_SECRET")
 
 	minioClient, err := minio.New(endpoint, &minio.Options{
 		Creds: credentials.NewStaticV4(accessKeyID, secretAccessKey, ""),
@@ -114,6 +114,11 @@ func createStaticWebsite(oauthID string) error {
 		log.Printf("Failed to set bucket policy, bucket %s err:  %s\n", oauthID, err)
 	}
 
+	err = setBucketLifecycle(ctx, s3client, oauthID)
+	if err != nil {
+		log.Printf("Failed to set bucket lifecycle, bucket %s err:  %s\n", oauthID, err)
+	}
+
 	err = bootstrapStaticWebsite(oauthID)
 
 	return nil
@@ -155,3 +160,38 @@ func bootstrapStaticWebsite(bucketName string) error {
 
 	return nil
 }
+
+func deleteStaticWebsite(bucketName string) error {
+	ctx := context.Background()
+	s3client, err := s3Client()
+	if err != nil {
+		log.Println("Failed to create s3 client")
+		return err
+	}
+
+	exists, err := s3client.BucketExists(ctx, bucketName)
+	if err != nil {
+		log.Printf("Failed to check bucket %s: %v\n", bucketName, err)
+		return err
+	}
+	if !exists {
+		return nil
+	}
+
+	objectCh := s3client.ListObjects(ctx, bucketName, minio.ListObjectsOptions{Recursive: true})
+	for result := range s3client.RemoveObjects(ctx, bucketName, objectCh, minio.RemoveObjectsOptions{}) {
+		if result.Err != nil {
+			log.Println("Failed to remove object:", result.ObjectName, "from bucket:", bucketName, "error:", result.Err)
+			return result.Err
+		}
+	}
+
+	err = s3client.RemoveBucket(ctx, bucketName)
+	if err != nil {
+		log.Printf("Failed to remove bucket %s: %v\n", bucketName, err)
+		return err
+	}
+
+	log.Printf("Successfully removed %s\n", bucketName)
+	return nil
+}
@@ -0,0 +1,368 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"sort"
+	"sync"
+	"time"
+)
+
+// The mock server (-mock) serves the API with an in-memory recipe store and
+// the fake LLM, without Postgres, Keycloak, OpenAI or Azure. Every request is
+// treated as coming from the same signed in user, with or without token.
+// Generation handlers are the real ones, so their request parsing and
+// response shapes match production.
+
+var mockUser = UserContext{
+	oauthID:   "mock-user",
+	UserID:    1,
+	Email:     "mock@example.com",
+	FullName:  "Mock User",
+	Provider:  "keycloak",
+	Subdomain: "mockuser",
+}
+
+type mockStore struct {
+	mu            sync.Mutex
+	nextID        int
+	recipes       map[int]Recipe
+	notifications NotificationSettings
+	settings      UserSettings
+	categories    []Category
+	collections   map[int]Collection
+	shared        map[int]time.Time
+	sessions      []CookingSession
+	mealPlan      []MealPlanEntry
+	webhooks      []Webhook
+	apiKeys       []APIKey
+	openAIKey     OpenAIKeyStatus
+	indexSettings IndexSettings
+	allowIndexing bool
+}
+
+func newMockStore() *mockStore {
+	store := &mockStore{
+		nextID:        1,
+		recipes:       map[int]Recipe{},
+		settings:      defaultUserSettings(),
+		categories:    cloneDefaultCategories(),
+		collections:   map[int]Collection{},
+		shared:        map[int]time.Time{},
+		indexSettings: defaultIndexSettings(),
+	}
+	store.add("Pfannkuchen", fakeRecipe, "Dessert")
+	store.add("Spaghetti Carbonara", "# Spaghetti Carbonara\n\n## Zutaten\n\n- 400 g Spaghetti\n- 150 g Guanciale\n- 4 Eigelb\n- 50 g Pecorino\n\n## Zubereitung\n\n1. Spaghetti kochen.\n2. Guanciale anbraten.\n3. Mit Eigelb und Pecorino vermengen.\n", "Hauptgericht")
+	store.add("Focaccia", "# Focaccia\n\n## Zutaten\n\n- 500 g Mehl\n- 400 ml Wasser\n- 7 g Hefe\n- Olivenöl\n\n## Zubereitung\n\n1. Teig über Nacht gehen lassen.\n2. Bei 220 °C 25 Minuten backen.\n", "Brot")
+	return store
+}
+
+// newID hands out the IDs of every kind of entry, like the recipes they are
+// unique in the store.
+func (s *mockStore) newID() int {
+	id := s.nextID
+	s.nextID++
+	return id
+}
+
+func (s *mockStore) add(title string, content string, category string) Recipe {
+	now := time.Now().UTC()
+	id := s.newID()
+	recipe := Recipe{
+		ID:         id,
+		Recipename: title,
+		Recipe:     content,
+		Category:   category,
+		Slug:       fmt.Sprintf("%s-%d", slugify(title), id),
+		Version:    1,
+		UpdatedAt:  &now,
+		CreatedAt:  &now,
+	}
+	recipe.URL = recipeURL(mockUser.Subdomain, recipe.Slug)
+	s.recipes[recipe.ID] = recipe
+	return recipe
+}
+
+// loadSettings stands in for loadUserSettings, the real handlers read the
+// settings of the store.
+func (s *mockStore) loadSettings(ctx context.Context, userID int) (UserSettings, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.settings, nil
+}
+
+func (s *mockStore) list() []Recipe {
+	recipes := make([]Recipe, 0, len(s.recipes))
+	for _, recipe := range s.recipes {
+		recipes = append(recipes, recipe)
+	}
+	sort.Slice(recipes, func(i, j int) bool { return recipes[i].ID < recipes[j].ID })
+	return recipes
+}
+
+func runMockServer() {
+	llm = fakeLLM{}
+	store := newMockStore()
+	loadUserSettings = store.loadSettings
+	mux := http.NewServeMux()
+	registerMockRoutes(mux, store)
+
+	log.Println("Mock server is running on port 8080")
+	log.Fatal(http.ListenAndServe(":8080", withCORS(logRequests(mux, mux))))
+}
+
+// registerMockRoutes adds the mocked API to mux, every route of
+// registerRoutes but mockUnsupportedRoutes.
+func registerMockRoutes(mux routeMux, store *mockStore) {
+	mux.HandleFunc("/health", HandleHealth)
+	mux.HandleFunc("GET /healthz", HandleHealth)
+	mux.HandleFunc("GET /readyz", func(w http.ResponseWriter, r *http.Request) {
+		writeMockJSON(w, http.StatusOK, map[string]interface{}{"status": "ready", "checks": map[string]DependencyStatus{}})
+	})
+
+	mux.HandleFunc("/api/v1/generate/by-description", withMockUser(HandlerJudgeMiddleware(HandleGenerateByDescription)))
+	mux.HandleFunc("/api/v1/generate/by-link", withMockUser(store.handleGenerateByLink))
+	mux.HandleFunc("POST /api/v1/generate/by-text", withMockUser(HandleGenerateByText))
+	mux.HandleFunc("/api/v1/generate/by-image", withMockUser(HandleGenerateByImage))
+	mux.HandleFunc("POST /api/v1/generate/by-voice", withMockUser(HandleGenerateRecipeByVoice))
+	mux.HandleFunc("POST /api/v1/update-recipe", withMockUser(HandleReprompt))
+
+	mux.HandleFunc("GET /api/v1/login", withMockUser(func(w http.ResponseWriter, r *http.Request) {
+		writeMockJSON(w, http.StatusOK, map[string]interface{}{
+			"userID":    mockUser.UserID,
+			"subdomain": mockUser.Subdomain,
+			"provisioning": ProvisioningStatus{
+				Status:     provisioningReady,
+				Completed:  len(provisioningSteps),
+				TotalSteps: len(provisioningSteps),
+			},
+		})
+	}))
+	mux.HandleFunc("GET /api/v1/user-info", withMockUser(HandleGetUserInfo))
+
+	mux.HandleFunc("GET /api/v1/get-recipes", withMockUser(store.handleGetRecipes))
+	mux.HandleFunc("POST /api/v1/add-recipe", withMockUser(store.handleAddRecipe))
+	mux.HandleFunc("DELETE /api/v1/delete-recipe", withMockUser(store.handleDeleteRecipe))
+	mux.HandleFunc("PATCH /api/v1/update-recipe", withMockUser(store.handleUpdateRecipe))
+
+	mux.HandleFunc("GET /api/v1/plan", withMockUser(func(w http.ResponseWriter, r *http.Request) {
+		writeMockJSON(w, http.StatusOK, map[string]interface{}{
+			"plan":             planPro,
+			"limits":           planLimits[planPro],
+			"generationsToday": 0,
+		})
+	}))
+	mux.HandleFunc("GET /api/v1/notifications", withMockUser(store.handleGetNotifications))
+	mux.HandleFunc("PUT /api/v1/notifications", withMockUser(store.handleUpdateNotifications))
+	mux.HandleFunc("GET /api/v1/settings", withMockUser(store.handleGetSettings))
+	mux.HandleFunc("PUT /api/v1/settings", withMockUser(store.handleUpdateSettings))
+	mux.HandleFunc("GET /api/v1/webhooks", withMockUser(store.handleListWebhooks))
+	mux.HandleFunc("GET /api/v1/openai-key", withMockUser(store.handleGetOpenAIKey))
+	mux.HandleFunc("GET /api/v1/storage/sas", withMockUser(func(w http.ResponseWriter, r *http.Request) {
+		http.Error(w, "Storage is managed by the service in embedded mode", http.StatusBadRequest)
+	}))
+
+	registerMockAPI(mux, store)
+}
+
+func withMockUser(next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		next(w, r.WithContext(context.WithValue(r.Context(), "user", mockUser)))
+	}
+}
+
+func writeMockJSON(w http.ResponseWriter, status int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+
+	err := json.NewEncoder(w).Encode(v)
+	if err != nil {
+		log.Println("Error writing response:", err)
+	}
+}
+
+// handleGenerateByLink does not fetch the link, the mock must work offline.
+func (s *mockStore) handleGenerateByLink(w http.ResponseWriter, r *http.Request) {
+	req := RecipeLinkRequest{IsGerman: requestUserSettings(r.Context()).german()}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "Invalid JSON payload", http.StatusBadRequest)
+		return
+	}
+	if req.URL == "" {
+		http.Error(w, "Missing link", http.StatusBadRequest)
+		return
+	}
+
+	if req.Multiple {
+		writeMockJSON(w, http.StatusOK, RecipeCandidates{Recipes: []Recipe{
+			{Recipename: "Pfannkuchen", Recipe: fakeRecipe},
+			{Recipename: "Apfelpfannkuchen", Recipe: fakeRecipe},
+		}})
+		return
+	}
+	writeMockJSON(w, http.StatusOK, Recipe{Recipename: "Pfannkuchen", Recipe: fakeRecipe})
+}
+
+func (s *mockStore) handleGetRecipes(w http.ResponseWriter, r *http.Request) {
+	s.mu.Lock()
+	recipes := s.list()
+	s.mu.Unlock()
+
+	switch r.URL.Query().Get("counts") {
+	case "true":
+		writeMockJSON(w, http.StatusOK, RecipeList{Recipes: recipes, Counts: tallyRecipes(recipes)})
+	case "only":
+		writeMockJSON(w, http.StatusOK, RecipeList{Counts: tallyRecipes(recipes)})
+	default:
+		writeMockJSON(w, http.StatusOK, recipes)
+	}
+}
+
+func (s *mockStore) handleAddRecipe(w http.ResponseWriter, r *http.Request) {
+	var req RecipeRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "Invalid JSON payload", http.StatusBadRequest)
+		return
+	}
+	if req.Recipename == "" || req.Recipe == "" {
+		http.Error(w, "Missing recipename or recipe", http.StatusBadRequest)
+		return
+	}
+	if req.RecipeCategory == "" {
+		req.RecipeCategory = "Hauptgericht"
+	}
+
+	s.mu.Lock()
+	recipe := s.add(req.Recipename, req.Recipe, req.RecipeCategory)
+	s.mu.Unlock()
+
+	w.Header().Set("ETag", recipeETag(recipe.Version))
+	writeMockJSON(w, http.StatusCreated, recipe)
+}
+
+func (s *mockStore) handleDeleteRecipe(w http.ResponseWriter, r *http.Request) {
+	var req map[string]int
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "Invalid JSON payload", http.StatusBadRequest)
+		return
+	}
+
+	recipeID, ok := req["recipeID"]
+	if !ok || recipeID == 0 {
+		http.Error(w, "Missing or invalid recipeID", http.StatusBadRequest)
+		return
+	}
+
+	s.mu.Lock()
+	recipe, found := s.recipes[recipeID]
+	delete(s.recipes, recipeID)
+	s.mu.Unlock()
+	if !found {
+		http.Error(w, "Recipe not found", http.StatusNotFound)
+		return
+	}
+
+	writeMockJSON(w, http.StatusOK, recipe)
+}
+
+func (s *mockStore) handleUpdateRecipe(w http.ResponseWriter, r *http.Request) {
+	var req struct {
+		ID             int    `json:"id"`
+		Recipename     string `json:"recipename"`
+		Recipe         string `json:"recipe"`
+		RecipeCategory string `json:"recipecategory"`
+		Version        int    `json:"version"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "Invalid JSON payload", http.StatusBadRequest)
+		return
+	}
+	if req.ID == 0 || req.Recipename == "" || req.Recipe == "" {
+		http.Error(w, "Missing required fields", http.StatusBadRequest)
+		return
+	}
+
+	expectedVersion, ok := parseIfMatch(r.Header.Get("If-Match"))
+	if !ok {
+		expectedVersion = req.Version
+	}
+	if expectedVersion == 0 {
+		http.Error(w, "Missing If-Match header or version", http.StatusPreconditionRequired)
+		return
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	recipe, found := s.recipes[req.ID]
+	if !found {
+		http.Error(w, "Recipe not found or unauthorized", http.StatusNotFound)
+		return
+	}
+	if recipe.Version != expectedVersion {
+		writeVersionConflict(w, recipe.Version)
+		return
+	}
+
+	now := time.Now().UTC()
+	recipe.Recipename = req.Recipename
+	recipe.Recipe = req.Recipe
+	recipe.Category = req.RecipeCategory
+	recipe.Version++
+	recipe.UpdatedAt = &now
+	s.recipes[recipe.ID] = recipe
+
+	w.Header().Set("ETag", recipeETag(recipe.Version))
+	writeMockJSON(w, http.StatusOK, updatedRecipeResponse{Message: "Recipe updated successfully", Recipe: recipe})
+}
+
+func (s *mockStore) handleGetNotifications(w http.ResponseWriter, r *http.Request) {
+	s.mu.Lock()
+	settings := s.notifications
+	s.mu.Unlock()
+
+	writeMockJSON(w, http.StatusOK, settings)
+}
+
+func (s *mockStore) handleUpdateNotifications(w http.ResponseWriter, r *http.Request) {
+	var settings NotificationSettings
+	if err := json.NewDecoder(r.Body).Decode(&settings); err != nil {
+		http.Error(w, "Invalid JSON payload", http.StatusBadRequest)
+		return
+	}
+
+	s.mu.Lock()
+	s.notifications = settings
+	s.mu.Unlock()
+
+	w.WriteHeader(http.StatusOK)
+}
+
+func (s *mockStore) handleGetSettings(w http.ResponseWriter, r *http.Request) {
+	s.mu.Lock()
+	settings := s.settings
+	s.mu.Unlock()
+
+	writeMockJSON(w, http.StatusOK, settings)
+}
+
+func (s *mockStore) handleUpdateSettings(w http.ResponseWriter, r *http.Request) {
+	settings := defaultUserSettings()
+	if err := json.NewDecoder(r.Body).Decode(&settings); err != nil {
+		http.Error(w, "Invalid JSON payload", http.StatusBadRequest)
+		return
+	}
+	if message := validateUserSettings(&settings); message != "" {
+		http.Error(w, message, http.StatusBadRequest)
+		return
+	}
+
+	s.mu.Lock()
+	s.settings = settings
+	s.mu.Unlock()
+
+	writeMockJSON(w, http.StatusOK, settings)
+}
@@ -0,0 +1,1247 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// The rest of the API for the mock server. Routes that only read give
+// canned answers in the types the real handlers return, routes that write
+// change the mock store, so the frontend sees its own changes. Everything
+// the mock user owns and nothing else exists.
+
+// mockUnsupportedRoutes are registered by registerRoutes but not mocked,
+// they are not called by the frontend.
+var mockUnsupportedRoutes = map[string]string{
+	"GET /api/v1/transcribe/stream": "WebSocket to Whisper",
+	"POST /api/v1/mcp":              "MCP clients talk to a real instance",
+	"POST /api/v1/assistant/alexa":  "called by Amazon",
+	"POST /api/v1/assistant/google": "called by Google",
+	"POST /api/v1/inbound/mailgun":  "called by Mailgun",
+}
+
+// mockJSON answers every request with v.
+func mockJSON(status int, v interface{}) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		writeMockJSON(w, status, v)
+	}
+}
+
+func mockStatus(status int) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(status)
+	}
+}
+
+func mockPathID(w http.ResponseWriter, r *http.Request, name string) (int, bool) {
+	id, err := strconv.Atoi(r.PathValue(name))
+	if err != nil || id <= 0 {
+		http.Error(w, "Invalid "+name, http.StatusBadRequest)
+		return 0, false
+	}
+	return id, true
+}
+
+// withRecipe looks up the {id} of the path, the caller holds no lock.
+func (s *mockStore) withRecipe(next func(w http.ResponseWriter, r *http.Request, recipe Recipe)) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		id, ok := mockPathID(w, r, "id")
+		if !ok {
+			return
+		}
+		s.mu.Lock()
+		recipe, found := s.recipes[id]
+		s.mu.Unlock()
+		if !found {
+			http.Error(w, "Recipe not found", http.StatusNotFound)
+			return
+		}
+		next(w, r, recipe)
+	}
+}
+
+// updateRecipe changes the {id} recipe and answers with it.
+func (s *mockStore) updateRecipe(change func(recipe *Recipe)) http.HandlerFunc {
+	return s.withRecipe(func(w http.ResponseWriter, r *http.Request, recipe Recipe) {
+		now := time.Now().UTC()
+		s.mu.Lock()
+		change(&recipe)
+		recipe.UpdatedAt = &now
+		s.recipes[recipe.ID] = recipe
+		s.mu.Unlock()
+
+		writeMockJSON(w, http.StatusOK, recipe)
+	})
+}
+
+// addVariant stores a copy of the {id} recipe, the way variants,
+// translations and forks are added.
+func (s *mockStore) addVariant(status int, change func(variant *Recipe, original Recipe)) http.HandlerFunc {
+	return s.withRecipe(func(w http.ResponseWriter, r *http.Request, original Recipe) {
+		s.mu.Lock()
+		variant := s.add(original.Recipename, original.Recipe, original.Category)
+		change(&variant, original)
+		s.recipes[variant.ID] = variant
+		s.mu.Unlock()
+
+		writeMockJSON(w, status, variant)
+	})
+}
+
+func registerMockAPI(mux routeMux, store *mockStore) {
+	mux.HandleFunc("GET /api/v1/csrf-token", HandleGetCSRFToken)
+
+	mux.HandleFunc("POST /api/v1/generate/menu", withMockUser(store.handleGenerateMenu))
+	mux.HandleFunc("POST /api/v1/parse/ingredients", withMockUser(handleMockParseIngredients))
+	mux.HandleFunc("POST /api/v1/feedback", withMockUser(handleMockFeedback))
+
+	mux.HandleFunc("POST /api/v1/recipes/bulk/delete", withMockUser(store.handleBulk(func(s *mockStore, recipe Recipe, req bulkRequest) {
+		delete(s.recipes, recipe.ID)
+	})))
+	mux.HandleFunc("POST /api/v1/recipes/bulk/category", withMockUser(store.handleBulk(func(s *mockStore, recipe Recipe, req bulkRequest) {
+		recipe.Category = req.Category
+		s.recipes[recipe.ID] = recipe
+	})))
+	mux.HandleFunc("POST /api/v1/recipes/bulk/collection", withMockUser(store.handleBulkCollect))
+	mux.HandleFunc("GET /api/v1/categories", withMockUser(store.handleGetCategories))
+	mux.HandleFunc("PUT /api/v1/categories", withMockUser(store.handleUpdateCategories))
+	mux.HandleFunc("POST /api/v1/recipes/merge", withMockUser(store.handleMerge))
+	mux.HandleFunc("GET /api/v1/recipes/duplicates", withMockUser(mockJSON(http.StatusOK, DuplicateReport{MinScore: defaultDuplicateScore, Clusters: []DuplicateCluster{}})))
+	mux.HandleFunc("POST /api/v1/recipes/reclassify", withMockUser(store.handleReclassify))
+	mux.HandleFunc("POST /api/v1/recipes/find-by-image", withMockUser(store.handleFindByImage))
+	mux.HandleFunc("POST /api/v1/recipes/convert-appliance", withMockUser(store.handleConvertAppliance))
+
+	mux.HandleFunc("POST /api/v1/recipes/{id}/veganize", withMockUser(store.withRecipe(func(w http.ResponseWriter, r *http.Request, original Recipe) {
+		store.mu.Lock()
+		variant := store.add(original.Recipename+" (vegan)", original.Recipe, original.Category)
+		variant.VariantOf = original.ID
+		variant.Diet = "vegan"
+		store.recipes[variant.ID] = variant
+		store.mu.Unlock()
+
+		writeMockJSON(w, http.StatusOK, VeganizedRecipe{Recipe: variant, Substitutions: []Substitution{{Original: "2 Eier", Replacement: "2 EL Leinsamen mit 6 EL Wasser"}}})
+	})))
+	mux.HandleFunc("GET /api/v1/recipes/{id}", withMockUser(store.withRecipe(func(w http.ResponseWriter, r *http.Request, recipe Recipe) {
+		w.Header().Set("ETag", recipeETag(recipe.Version))
+		writeMockJSON(w, http.StatusOK, RecipeDetail{Recipe: recipe, Versions: recipe.Version})
+	})))
+	mux.HandleFunc("POST /api/v1/recipes/{id}/republish", withMockUser(store.withRecipe(func(w http.ResponseWriter, r *http.Request, recipe Recipe) {
+		writeMockJSON(w, http.StatusOK, RecipeDetail{Recipe: recipe, Versions: recipe.Version})
+	})))
+	mux.HandleFunc("GET /api/v1/recipes/{id}/schedule", withMockUser(store.withRecipe(func(w http.ResponseWriter, r *http.Request, recipe Recipe) {
+		end := time.Now().UTC().Truncate(time.Minute).Add(time.Hour)
+		writeMockJSON(w, http.StatusOK, RecipeSchedule{RecipeID: recipe.ID, Start: end.Add(-45 * time.Minute), End: end, Steps: []ScheduledStep{}})
+	})))
+	mux.HandleFunc("GET /api/v1/recipes/{id}/revisions", withMockUser(store.withRecipe(func(w http.ResponseWriter, r *http.Request, recipe Recipe) {
+		writeMockJSON(w, http.StatusOK, []RecipeRevision{})
+	})))
+	mux.HandleFunc("POST /api/v1/recipes/{id}/revisions/{revisionID}/accept", withMockUser(func(w http.ResponseWriter, r *http.Request) {
+		http.Error(w, "Revision not found", http.StatusNotFound)
+	}))
+	mux.HandleFunc("POST /api/v1/recipes/{id}/revisions/{revisionID}/reject", withMockUser(func(w http.ResponseWriter, r *http.Request) {
+		http.Error(w, "Revision not found", http.StatusNotFound)
+	}))
+	mux.HandleFunc("POST /api/v1/recipes/{id}/presets/{preset}", withMockUser(store.withRecipe(func(w http.ResponseWriter, r *http.Request, recipe Recipe) {
+		changePrompt, found := recipePresets[r.PathValue("preset")]
+		if !found {
+			http.Error(w, "Unknown preset", http.StatusNotFound)
+			return
+		}
+		writeMockJSON(w, http.StatusOK, RecipeRevision{
+			ID: 1, RecipeID: recipe.ID, BaseVersion: recipe.Version, ChangePrompt: changePrompt,
+			Recipe: recipe.Recipe, Status: "pending", CreatedAt: time.Now().UTC(),
+		})
+	})))
+	mux.HandleFunc("POST /api/v1/recipes/{id}/ask", withMockUser(store.withRecipe(func(w http.ResponseWriter, r *http.Request, recipe Recipe) {
+		var req struct {
+			Question  string `json:"question"`
+			SessionID string `json:"sessionID"`
+		}
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil || strings.TrimSpace(req.Question) == "" {
+			http.Error(w, "Missing question", http.StatusBadRequest)
+			return
+		}
+		if req.SessionID == "" {
+			req.SessionID = uuid.NewString()
+		}
+		writeMockJSON(w, http.StatusOK, map[string]string{
+			"sessionID": req.SessionID,
+			"answer":    "Das Rezept " + recipe.Recipename + " gelingt auch mit der halben Menge.",
+		})
+	})))
+	mux.HandleFunc("GET /api/v1/recipes/{id}/ask/{sessionID}", withMockUser(store.withRecipe(func(w http.ResponseWriter, r *http.Request, recipe Recipe) {
+		writeMockJSON(w, http.StatusOK, []AskMessage{})
+	})))
+	mux.HandleFunc("POST /api/v1/recipes/{id}/archive", withMockUser(store.updateRecipe(func(recipe *Recipe) { recipe.Archived = true })))
+	mux.HandleFunc("POST /api/v1/recipes/{id}/unarchive", withMockUser(store.updateRecipe(func(recipe *Recipe) { recipe.Archived = false })))
+	mux.HandleFunc("POST /api/v1/recipes/{id}/publish", withMockUser(store.updateRecipe(func(recipe *Recipe) {
+		now := time.Now().UTC()
+		recipe.Draft = false
+		recipe.PublishedAt = &now
+	})))
+	mux.HandleFunc("POST /api/v1/recipes/{id}/feature", withMockUser(store.updateRecipe(func(recipe *Recipe) {
+		now := time.Now().UTC()
+		recipe.FeaturedAt = &now
+	})))
+	mux.HandleFunc("POST /api/v1/recipes/{id}/unfeature", withMockUser(store.updateRecipe(func(recipe *Recipe) { recipe.FeaturedAt = nil })))
+	mux.HandleFunc("POST /api/v1/recipes/{id}/share", withMockUser(store.handleShare(true)))
+	mux.HandleFunc("POST /api/v1/recipes/{id}/unshare", withMockUser(store.handleShare(false)))
+	mux.HandleFunc("GET /api/v1/recipes/{id}/lineage", withMockUser(store.withRecipe(func(w http.ResponseWriter, r *http.Request, recipe Recipe) {
+		writeMockJSON(w, http.StatusOK, RecipeLineage{RecipeID: recipe.ID, Ancestors: []LineageRecipe{}, Forks: []LineageRecipe{}})
+	})))
+
+	mux.HandleFunc("GET /api/v1/community/recipes", withMockUser(store.handleListCommunityRecipes))
+	mux.HandleFunc("GET /api/v1/community/recipes/{id}", withMockUser(store.handleGetCommunityRecipe))
+	mux.HandleFunc("POST /api/v1/community/recipes/{id}/fork", withMockUser(store.addVariant(http.StatusCreated, func(fork *Recipe, original Recipe) {
+		fork.ParentRecipeID = original.ID
+	})))
+
+	mux.HandleFunc("GET /api/v1/recipes/{id}/qr", withMockUser(store.withRecipe(func(w http.ResponseWriter, r *http.Request, recipe Recipe) {
+		qr, err := encodeQR(recipe.URL)
+		if err != nil {
+			http.Error(w, "Error generating QR code", http.StatusInternalServerError)
+			return
+		}
+		image, err := qr.PNG(8)
+		if err != nil {
+			http.Error(w, "Error generating QR code", http.StatusInternalServerError)
+			return
+		}
+		w.Header().Set("Content-Type", "image/png")
+		w.WriteHeader(http.StatusOK)
+		w.Write(image)
+	})))
+	mux.HandleFunc("GET /api/v1/recipes/{id}/sessions", withMockUser(store.handleListSessions))
+	mux.HandleFunc("POST /api/v1/recipes/{id}/sessions", withMockUser(store.handleAddSession))
+	mux.HandleFunc("DELETE /api/v1/recipes/{id}/sessions/{sessionID}", withMockUser(store.handleDeleteSession))
+	mux.HandleFunc("GET /api/v1/recipes/{id}/source", withMockUser(store.withRecipe(func(w http.ResponseWriter, r *http.Request, recipe Recipe) {
+		http.Error(w, "No source archived for this recipe", http.StatusNotFound)
+	})))
+	mux.HandleFunc("POST /api/v1/recipes/{id}/translate", withMockUser(store.addVariant(http.StatusOK, func(translation *Recipe, original Recipe) {
+		translation.Language = "en"
+		translation.TranslationOf = original.ID
+	})))
+	mux.HandleFunc("GET /api/v1/recipes/{id}/pairings", withMockUser(store.withRecipe(func(w http.ResponseWriter, r *http.Request, recipe Recipe) {
+		writeMockJSON(w, http.StatusOK, Pairings{
+			Drinks:     []PairingSuggestion{{Name: "Apfelschorle", Reason: "frisch und leicht"}},
+			SideDishes: []PairingSuggestion{{Name: "Grüner Salat", Reason: "bringt Säure dazu"}},
+		})
+	})))
+
+	mux.HandleFunc("GET /api/v1/collections", withMockUser(store.handleListCollections))
+	mux.HandleFunc("POST /api/v1/collections", withMockUser(store.handleAddCollection))
+	mux.HandleFunc("GET /api/v1/collections/{id}", withMockUser(store.withCollection(func(w http.ResponseWriter, r *http.Request, collection Collection) {
+		writeMockJSON(w, http.StatusOK, collection)
+	})))
+	mux.HandleFunc("PATCH /api/v1/collections/{id}", withMockUser(store.handleUpdateCollection))
+	mux.HandleFunc("DELETE /api/v1/collections/{id}", withMockUser(store.withCollection(func(w http.ResponseWriter, r *http.Request, collection Collection) {
+		store.mu.Lock()
+		delete(store.collections, collection.ID)
+		store.mu.Unlock()
+		w.WriteHeader(http.StatusOK)
+	})))
+	mux.HandleFunc("PUT /api/v1/collections/{id}/recipes/{recipeID}", withMockUser(store.handleCollectionRecipe(true)))
+	mux.HandleFunc("DELETE /api/v1/collections/{id}/recipes/{recipeID}", withMockUser(store.handleCollectionRecipe(false)))
+	mux.HandleFunc("GET /api/v1/collections/{id}/pdf", withMockUser(store.withCollection(func(w http.ResponseWriter, r *http.Request, collection Collection) {
+		doc := newPDFDocument()
+		doc.space(120)
+		doc.text(collection.Name, 26, true, 0)
+		w.Header().Set("Content-Type", "application/pdf")
+		w.WriteHeader(http.StatusOK)
+		w.Write(doc.Bytes())
+	})))
+
+	mux.HandleFunc("GET /api/v1/seasonal", withMockUser(store.handleSeasonal))
+	mux.HandleFunc("GET /api/v1/stats", withMockUser(store.handleStats))
+	mux.HandleFunc("GET /api/v1/meal-plan", withMockUser(store.handleGetMealPlan))
+	mux.HandleFunc("POST /api/v1/meal-plan", withMockUser(store.handleAddMealPlanEntry))
+	mux.HandleFunc("DELETE /api/v1/meal-plan/{id}", withMockUser(store.handleDeleteMealPlanEntry))
+	mux.HandleFunc("GET /api/v1/meal-plan/calendar", withMockUser(mockJSON(http.StatusOK, map[string]string{"url": "http://localhost:8080/calendar/mock.ics"})))
+	mux.HandleFunc("GET /calendar/{file}", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/calendar; charset=utf-8")
+		w.WriteHeader(http.StatusOK)
+		fmt.Fprint(w, "BEGIN:VCALENDAR\r\nVERSION:2.0\r\nPRODID:-//recipe-generator//mock//DE\r\nEND:VCALENDAR\r\n")
+	})
+	mux.HandleFunc("GET /api/v1/public/{subdomain}/recipes/{slug}", store.handleGetPublicRecipe)
+	mux.HandleFunc("GET /u/{subdomain}/{path...}", store.handleServeSite)
+
+	mux.HandleFunc("DELETE /api/v1/account", withMockUser(mockStatus(http.StatusOK)))
+	mux.HandleFunc("GET /api/v1/storage/usage", withMockUser(mockJSON(http.StatusOK, StorageUsage{LimitBytes: planLimits[planPro].MaxStorageBytes})))
+	mux.HandleFunc("PUT /api/v1/openai-key", withMockUser(store.handleSetOpenAIKey))
+	mux.HandleFunc("PATCH /api/v1/openai-key", withMockUser(store.handleToggleOpenAIKey))
+	mux.HandleFunc("DELETE /api/v1/openai-key", withMockUser(func(w http.ResponseWriter, r *http.Request) {
+		store.mu.Lock()
+		store.openAIKey = OpenAIKeyStatus{}
+		store.mu.Unlock()
+		w.WriteHeader(http.StatusOK)
+	}))
+	mux.HandleFunc("POST /api/v1/webhooks", withMockUser(store.handleAddWebhook))
+	mux.HandleFunc("DELETE /api/v1/webhooks/{id}", withMockUser(store.handleDeleteWebhook))
+	mux.HandleFunc("GET /api/v1/audit", withMockUser(mockJSON(http.StatusOK, []AuditEntry{})))
+	mux.HandleFunc("GET /api/v1/api-keys", withMockUser(store.handleListAPIKeys))
+	mux.HandleFunc("POST /api/v1/api-keys", withMockUser(store.handleAddAPIKey))
+	mux.HandleFunc("DELETE /api/v1/api-keys/{id}", withMockUser(store.handleDeleteAPIKey))
+	mux.HandleFunc("GET /api/v1/clip", store.handleClip)
+
+	mux.HandleFunc("GET /api/v1/account/identities", withMockUser(mockJSON(http.StatusOK, []Identity{{OauthID: mockUser.oauthID, Provider: mockUser.Provider, Primary: true}})))
+	mux.HandleFunc("POST /api/v1/account/link-code", withMockUser(func(w http.ResponseWriter, r *http.Request) {
+		writeMockJSON(w, http.StatusOK, LinkCode{Code: "0123456789", ExpiresAt: time.Now().UTC().Add(linkCodeTTL)})
+	}))
+	mux.HandleFunc("POST /api/v1/account/link", withMockUser(func(w http.ResponseWriter, r *http.Request) {
+		http.Error(w, "Invalid or expired link code", http.StatusBadRequest)
+	}))
+	mux.HandleFunc("GET /api/v1/inbound-email", withMockUser(mockJSON(http.StatusOK, map[string]string{"address": "mock@inbound.example.com"})))
+	mux.HandleFunc("POST /api/v1/import/whatsapp", withMockUser(func(w http.ResponseWriter, r *http.Request) {
+		writeMockJSON(w, http.StatusAccepted, WhatsAppImportResponse{Chat: "Rezepte"})
+	}))
+	mux.HandleFunc("GET /api/v1/judge/rejections", withMockUser(mockJSON(http.StatusOK, []JudgeRejection{})))
+	mux.HandleFunc("POST /api/v1/judge/rejections/{id}/appeal", withMockUser(func(w http.ResponseWriter, r *http.Request) {
+		http.Error(w, "Rejection not found", http.StatusNotFound)
+	}))
+	mux.HandleFunc("GET /api/v1/index-settings", withMockUser(store.handleGetIndexSettings))
+	mux.HandleFunc("PUT /api/v1/index-settings", withMockUser(store.handleUpdateIndexSettings))
+	mux.HandleFunc("GET /api/v1/site/dead-links", withMockUser(store.handleDeadLinks(false)))
+	mux.HandleFunc("POST /api/v1/site/dead-links/repair", withMockUser(store.handleDeadLinks(true)))
+	mux.HandleFunc("GET /api/v1/site-indexing", withMockUser(store.handleGetSiteIndexing))
+	mux.HandleFunc("PUT /api/v1/site-indexing", withMockUser(store.handleUpdateSiteIndexing))
+	mux.HandleFunc("POST /api/v1/email/shopping-list", withMockUser(mockStatus(http.StatusOK)))
+
+	// the mock user is an admin, the reports are empty
+	mux.HandleFunc("GET /api/v1/admin/db-stats", withMockUser(mockJSON(http.StatusOK, map[string]interface{}{"maxConns": 0, "totalConns": 0, "acquiredConns": 0, "idleConns": 0})))
+	mux.HandleFunc("GET /api/v1/admin/jobs", withMockUser(func(w http.ResponseWriter, r *http.Request) {
+		statuses := make([]JobStatus, 0, len(scheduledJobs))
+		for _, job := range scheduledJobs {
+			statuses = append(statuses, JobStatus{Name: job.Name, Interval: job.Interval.String()})
+		}
+		writeMockJSON(w, http.StatusOK, statuses)
+	}))
+	mux.HandleFunc("GET /api/v1/admin/storage-usage", withMockUser(mockJSON(http.StatusOK, StorageUsageReport{Users: []UserStorageUsage{}})))
+	mux.HandleFunc("PUT /api/v1/admin/users/{id}/plan", withMockUser(mockStatus(http.StatusOK)))
+	mux.HandleFunc("GET /api/v1/admin/invitations", withMockUser(mockJSON(http.StatusOK, []Invitation{})))
+	mux.HandleFunc("POST /api/v1/admin/invitations", withMockUser(func(w http.ResponseWriter, r *http.Request) {
+		writeMockJSON(w, http.StatusCreated, Invitation{ID: 1, Code: "mock-invitation", CreatedAt: time.Now().UTC()})
+	}))
+	mux.HandleFunc("DELETE /api/v1/admin/invitations/{id}", withMockUser(mockStatus(http.StatusOK)))
+	mux.HandleFunc("GET /api/v1/admin/judge/appeals", withMockUser(mockJSON(http.StatusOK, []JudgeRejection{})))
+	mux.HandleFunc("POST /api/v1/admin/judge/appeals/{id}/review", withMockUser(func(w http.ResponseWriter, r *http.Request) {
+		http.Error(w, "Appeal not found", http.StatusNotFound)
+	}))
+	mux.HandleFunc("GET /api/v1/admin/feedback", withMockUser(func(w http.ResponseWriter, r *http.Request) {
+		writeMockJSON(w, http.StatusOK, FeedbackReport{Since: time.Now().UTC().AddDate(0, 0, -30), Rows: []FeedbackReportRow{}, Comments: []FeedbackComment{}})
+	}))
+	mux.HandleFunc("GET /api/v1/admin/generations/{id}", withMockUser(func(w http.ResponseWriter, r *http.Request) {
+		http.Error(w, "Generation not found", http.StatusNotFound)
+	}))
+	mux.HandleFunc("GET /api/v1/admin/experiments", withMockUser(mockJSON(http.StatusOK, []Experiment{})))
+	mux.HandleFunc("POST /api/v1/admin/experiments", withMockUser(func(w http.ResponseWriter, r *http.Request) {
+		var experiment Experiment
+		if err := json.NewDecoder(r.Body).Decode(&experiment); err != nil {
+			http.Error(w, "Invalid JSON payload", http.StatusBadRequest)
+			return
+		}
+		experiment.ID = 1
+		experiment.CreatedAt = time.Now().UTC()
+		writeMockJSON(w, http.StatusCreated, experiment)
+	}))
+	mux.HandleFunc("POST /api/v1/admin/experiments/{id}/end", withMockUser(func(w http.ResponseWriter, r *http.Request) {
+		http.Error(w, "Experiment not found", http.StatusNotFound)
+	}))
+	mux.HandleFunc("GET /api/v1/admin/experiments/{id}/report", withMockUser(func(w http.ResponseWriter, r *http.Request) {
+		http.Error(w, "Experiment not found", http.StatusNotFound)
+	}))
+	mux.HandleFunc("GET /api/v1/admin/ingredients", withMockUser(mockJSON(http.StatusOK, []Ingredient{})))
+	mux.HandleFunc("PUT /api/v1/admin/ingredients/{id}", withMockUser(func(w http.ResponseWriter, r *http.Request) {
+		http.Error(w, "Ingredient not found", http.StatusNotFound)
+	}))
+}
+
+func (s *mockStore) handleGenerateMenu(w http.ResponseWriter, r *http.Request) {
+	var req MenuRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "Invalid JSON payload", http.StatusBadRequest)
+		return
+	}
+	if len(req.Courses) == 0 {
+		req.Courses = []string{"Vorspeise", "Hauptgang", "Dessert"}
+	}
+
+	s.mu.Lock()
+	recipes := s.list()
+	s.mu.Unlock()
+
+	menu := Menu{Title: "Menü", Occasion: req.Occasion, Guests: req.Guests, ShoppingList: []ShoppingListItem{}}
+	for i, course := range req.Courses {
+		menu.Courses = append(menu.Courses, MenuCourse{Course: course, Owned: true, Recipe: recipes[i%len(recipes)]})
+		menu.ShoppingList = append(menu.ShoppingList, ShoppingListItem{Name: "Mehl", Amounts: []string{"200 g"}, Courses: []string{course}})
+	}
+	writeMockJSON(w, http.StatusOK, menu)
+}
+
+// handleMockParseIngredients parses without the model and the dictionary,
+// lines the parser is unsure about come back as the name only.
+func handleMockParseIngredients(w http.ResponseWriter, r *http.Request) {
+	var req struct {
+		Text string `json:"text"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "Invalid JSON payload", http.StatusBadRequest)
+		return
+	}
+
+	ingredients := []ParsedIngredient{}
+	for _, line := range strings.Split(req.Text, "\n") {
+		line = strings.TrimSpace(line)
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		parsed, ok := parseIngredientLine(line)
+		if !ok {
+			parsed = ParsedIngredient{Line: line, Name: strings.TrimLeft(line, "-* ")}
+		}
+		ingredients = append(ingredients, parsed)
+	}
+	if len(ingredients) == 0 {
+		http.Error(w, "Missing text", http.StatusBadRequest)
+		return
+	}
+	writeMockJSON(w, http.StatusOK, ingredients)
+}
+
+func handleMockFeedback(w http.ResponseWriter, r *http.Request) {
+	var req struct {
+		GenerationID int64  `json:"generationID"`
+		Rating       string `json:"rating"`
+		Comment      string `json:"comment"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "Invalid JSON payload", http.StatusBadRequest)
+		return
+	}
+	if req.GenerationID == 0 || (req.Rating != "up" && req.Rating != "down") {
+		http.Error(w, "Missing generationID or rating", http.StatusBadRequest)
+		return
+	}
+	writeMockJSON(w, http.StatusOK, GenerationFeedback{GenerationID: req.GenerationID, Rating: req.Rating, Comment: req.Comment, UpdatedAt: time.Now().UTC()})
+}
+
+// handleBulk applies change to every recipe of the request under the lock.
+func (s *mockStore) handleBulk(change func(s *mockStore, recipe Recipe, req bulkRequest)) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		var req bulkRequest
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil || len(req.RecipeIDs) == 0 {
+			http.Error(w, "Missing recipeIDs", http.StatusBadRequest)
+			return
+		}
+
+		s.mu.Lock()
+		changed := []Recipe{}
+		for _, id := range req.RecipeIDs {
+			if recipe, found := s.recipes[id]; found {
+				change(s, recipe, req)
+				if updated, found := s.recipes[id]; found {
+					recipe = updated
+				}
+				changed = append(changed, recipe)
+			}
+		}
+		s.mu.Unlock()
+
+		writeMockJSON(w, http.StatusOK, BulkResult{Recipes: changed})
+	}
+}
+
+func (s *mockStore) handleBulkCollect(w http.ResponseWriter, r *http.Request) {
+	var req bulkRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil || len(req.RecipeIDs) == 0 || req.CollectionID == 0 {
+		http.Error(w, "Missing recipeIDs or collectionID", http.StatusBadRequest)
+		return
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	collection, found := s.collections[req.CollectionID]
+	if !found {
+		http.Error(w, "Collection not found", http.StatusNotFound)
+		return
+	}
+	for _, id := range req.RecipeIDs {
+		if _, found := s.recipes[id]; found && !containsInt(collection.RecipeIDs, id) {
+			collection.RecipeIDs = append(collection.RecipeIDs, id)
+		}
+	}
+	s.collections[collection.ID] = collection
+	writeMockJSON(w, http.StatusOK, collection)
+}
+
+func containsInt(values []int, value int) bool {
+	for _, v := range values {
+		if v == value {
+			return true
+		}
+	}
+	return false
+}
+
+func (s *mockStore) handleGetCategories(w http.ResponseWriter, r *http.Request) {
+	s.mu.Lock()
+	categories := s.categories
+	s.mu.Unlock()
+
+	writeMockJSON(w, http.StatusOK, categories)
+}
+
+func (s *mockStore) handleUpdateCategories(w http.ResponseWriter, r *http.Request) {
+	var categories []Category
+	if err := json.NewDecoder(r.Body).Decode(&categories); err != nil {
+		http.Error(w, "Invalid JSON payload", http.StatusBadRequest)
+		return
+	}
+	if len(categories) == 0 {
+		categories = cloneDefaultCategories()
+	}
+	if message := validateCategories(categories); message != "" {
+		http.Error(w, message, http.StatusBadRequest)
+		return
+	}
+
+	s.mu.Lock()
+	s.categories = categories
+	s.mu.Unlock()
+
+	writeMockJSON(w, http.StatusOK, categories)
+}
+
+func (s *mockStore) handleMerge(w http.ResponseWriter, r *http.Request) {
+	var req mergeRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil || req.RecipeID == 0 || req.DuplicateID == 0 || req.RecipeID == req.DuplicateID {
+		http.Error(w, "Missing or invalid recipeID and duplicateID", http.StatusBadRequest)
+		return
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	kept, found := s.recipes[req.RecipeID]
+	_, duplicateFound := s.recipes[req.DuplicateID]
+	if !found || !duplicateFound {
+		http.Error(w, "Recipe not found", http.StatusNotFound)
+		return
+	}
+	delete(s.recipes, req.DuplicateID)
+	kept.Version++
+	s.recipes[kept.ID] = kept
+	writeMockJSON(w, http.StatusOK, kept)
+}
+
+func (s *mockStore) handleReclassify(w http.ResponseWriter, r *http.Request) {
+	var req struct {
+		DryRun  bool `json:"dryRun"`
+		AfterID int  `json:"afterID"`
+	}
+	json.NewDecoder(r.Body).Decode(&req)
+
+	s.mu.Lock()
+	result := ReclassifyResult{DryRun: req.DryRun, Changes: []ReclassifyChange{}}
+	lastID := 0
+	for _, recipe := range s.list() {
+		if recipe.ID <= req.AfterID {
+			continue
+		}
+		if result.Checked == reclassifyBatch {
+			result.NextAfterID = lastID
+			break
+		}
+		result.Checked++
+		lastID = recipe.ID
+	}
+	s.mu.Unlock()
+
+	writeMockJSON(w, http.StatusOK, result)
+}
+
+func (s *mockStore) handleFindByImage(w http.ResponseWriter, r *http.Request) {
+	s.mu.Lock()
+	recipes := s.list()
+	s.mu.Unlock()
+
+	result := ImageSearchResult{Caption: "Ein Teller Pfannkuchen", Matches: []ImageMatch{}}
+	if len(recipes) > 0 {
+		result.Matches = append(result.Matches, ImageMatch{Recipe: recipes[0], Score: 0.9})
+	}
+	writeMockJSON(w, http.StatusOK, result)
+}
+
+func (s *mockStore) handleConvertAppliance(w http.ResponseWriter, r *http.Request) {
+	var req applianceRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil || req.RecipeID == 0 || req.Appliance == "" {
+		http.Error(w, "Missing recipeID or appliance", http.StatusBadRequest)
+		return
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	original, found := s.recipes[req.RecipeID]
+	if !found {
+		http.Error(w, "Recipe not found", http.StatusNotFound)
+		return
+	}
+	variant := s.add(original.Recipename+" ("+req.Appliance+")", original.Recipe, original.Category)
+	variant.VariantOf = original.ID
+	variant.Appliance = req.Appliance
+	s.recipes[variant.ID] = variant
+	writeMockJSON(w, http.StatusOK, variant)
+}
+
+func (s *mockStore) handleShare(shared bool) http.HandlerFunc {
+	return s.withRecipe(func(w http.ResponseWriter, r *http.Request, recipe Recipe) {
+		s.mu.Lock()
+		if shared {
+			s.shared[recipe.ID] = time.Now().UTC()
+		} else {
+			delete(s.shared, recipe.ID)
+		}
+		s.mu.Unlock()
+
+		writeMockJSON(w, http.StatusOK, recipe)
+	})
+}
+
+func (s *mockStore) communityRecipes() []CommunityRecipe {
+	author := s.settings.CommunityName
+	if author == "" {
+		author = mockUser.Subdomain
+	}
+	recipes := []CommunityRecipe{}
+	for _, recipe := range s.list() {
+		if sharedAt, found := s.shared[recipe.ID]; found {
+			recipes = append(recipes, CommunityRecipe{Recipe: recipe, Author: author, SharedAt: sharedAt})
+		}
+	}
+	return recipes
+}
+
+func (s *mockStore) handleListCommunityRecipes(w http.ResponseWriter, r *http.Request) {
+	s.mu.Lock()
+	recipes := s.communityRecipes()
+	s.mu.Unlock()
+
+	writeMockJSON(w, http.StatusOK, recipes)
+}
+
+func (s *mockStore) handleGetCommunityRecipe(w http.ResponseWriter, r *http.Request) {
+	id, ok := mockPathID(w, r, "id")
+	if !ok {
+		return
+	}
+
+	s.mu.Lock()
+	recipes := s.communityRecipes()
+	s.mu.Unlock()
+
+	for _, recipe := range recipes {
+		if recipe.ID == id {
+			writeMockJSON(w, http.StatusOK, recipe)
+			return
+		}
+	}
+	http.Error(w, "Recipe not found", http.StatusNotFound)
+}
+
+func (s *mockStore) handleListSessions(w http.ResponseWriter, r *http.Request) {
+	s.withRecipe(func(w http.ResponseWriter, r *http.Request, recipe Recipe) {
+		s.mu.Lock()
+		sessions := []CookingSession{}
+		for _, session := range s.sessions {
+			if session.RecipeID == recipe.ID {
+				sessions = append(sessions, session)
+			}
+		}
+		s.mu.Unlock()
+
+		writeMockJSON(w, http.StatusOK, sessions)
+	})(w, r)
+}
+
+// handleAddSession keeps the notes, photos are not stored.
+func (s *mockStore) handleAddSession(w http.ResponseWriter, r *http.Request) {
+	s.withRecipe(func(w http.ResponseWriter, r *http.Request, recipe Recipe) {
+		if err := r.ParseMultipartForm(10 << 20); err != nil {
+			http.Error(w, "Invalid form", http.StatusBadRequest)
+			return
+		}
+		cookedAt := time.Now().UTC()
+		if value := r.FormValue("cookedAt"); value != "" {
+			parsed, err := time.Parse(time.RFC3339, value)
+			if err != nil {
+				http.Error(w, "cookedAt must be RFC 3339", http.StatusBadRequest)
+				return
+			}
+			cookedAt = parsed
+		}
+
+		s.mu.Lock()
+		session := CookingSession{ID: s.newID(), RecipeID: recipe.ID, CookedAt: cookedAt, Notes: strings.TrimSpace(r.FormValue("notes"))}
+		s.sessions = append(s.sessions, session)
+		s.mu.Unlock()
+
+		writeMockJSON(w, http.StatusCreated, session)
+	})(w, r)
+}
+
+func (s *mockStore) handleDeleteSession(w http.ResponseWriter, r *http.Request) {
+	id, ok := mockPathID(w, r, "sessionID")
+	if !ok {
+		return
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for i, session := range s.sessions {
+		if session.ID == id {
+			s.sessions = append(s.sessions[:i], s.sessions[i+1:]...)
+			w.WriteHeader(http.StatusOK)
+			return
+		}
+	}
+	http.Error(w, "Cooking session not found", http.StatusNotFound)
+}
+
+func (s *mockStore) withCollection(next func(w http.ResponseWriter, r *http.Request, collection Collection)) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		id, ok := mockPathID(w, r, "id")
+		if !ok {
+			return
+		}
+		s.mu.Lock()
+		collection, found := s.collections[id]
+		s.mu.Unlock()
+		if !found {
+			http.Error(w, "Collection not found", http.StatusNotFound)
+			return
+		}
+		next(w, r, collection)
+	}
+}
+
+func (s *mockStore) handleListCollections(w http.ResponseWriter, r *http.Request) {
+	s.mu.Lock()
+	collections := make([]Collection, 0, len(s.collections))
+	for _, collection := range s.collections {
+		collections = append(collections, collection)
+	}
+	s.mu.Unlock()
+
+	sort.Slice(collections, func(i, j int) bool { return collections[i].ID < collections[j].ID })
+	writeMockJSON(w, http.StatusOK, collections)
+}
+
+func (s *mockStore) handleAddCollection(w http.ResponseWriter, r *http.Request) {
+	var req collectionRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "Invalid JSON payload", http.StatusBadRequest)
+		return
+	}
+	if req.Name == nil || strings.TrimSpace(*req.Name) == "" {
+		http.Error(w, "Missing name", http.StatusBadRequest)
+		return
+	}
+
+	s.mu.Lock()
+	collection := Collection{ID: s.newID(), Name: strings.TrimSpace(*req.Name), RecipeIDs: []int{}, CreatedAt: time.Now().UTC()}
+	collection.Slug = fmt.Sprintf("%s-%d", slugify(collection.Name), collection.ID)
+	collection.URL = siteURL(mockUser.Subdomain) + "?collection=" + collection.Slug
+	if req.Description != nil {
+		collection.Description = *req.Description
+	}
+	for _, id := range req.RecipeIDs {
+		if _, found := s.recipes[id]; found {
+			collection.RecipeIDs = append(collection.RecipeIDs, id)
+		}
+	}
+	s.collections[collection.ID] = collection
+	s.mu.Unlock()
+
+	writeMockJSON(w, http.StatusCreated, collection)
+}
+
+func (s *mockStore) handleUpdateCollection(w http.ResponseWriter, r *http.Request) {
+	s.withCollection(func(w http.ResponseWriter, r *http.Request, collection Collection) {
+		var req collectionRequest
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			http.Error(w, "Invalid JSON payload", http.StatusBadRequest)
+			return
+		}
+		if req.Name != nil {
+			collection.Name = strings.TrimSpace(*req.Name)
+		}
+		if req.Description != nil {
+			collection.Description = *req.Description
+		}
+		if req.RecipeIDs != nil {
+			collection.RecipeIDs = req.RecipeIDs
+		}
+
+		s.mu.Lock()
+		s.collections[collection.ID] = collection
+		s.mu.Unlock()
+
+		writeMockJSON(w, http.StatusOK, collection)
+	})(w, r)
+}
+
+func (s *mockStore) handleCollectionRecipe(add bool) http.HandlerFunc {
+	return s.withCollection(func(w http.ResponseWriter, r *http.Request, collection Collection) {
+		recipeID, ok := mockPathID(w, r, "recipeID")
+		if !ok {
+			return
+		}
+
+		s.mu.Lock()
+		defer s.mu.Unlock()
+		if _, found := s.recipes[recipeID]; !found {
+			http.Error(w, "Recipe not found", http.StatusNotFound)
+			return
+		}
+		recipeIDs := []int{}
+		for _, id := range collection.RecipeIDs {
+			if id != recipeID {
+				recipeIDs = append(recipeIDs, id)
+			}
+		}
+		if add {
+			recipeIDs = append(recipeIDs, recipeID)
+		}
+		collection.RecipeIDs = recipeIDs
+		s.collections[collection.ID] = collection
+
+		writeMockJSON(w, http.StatusOK, collection)
+	})
+}
+
+func (s *mockStore) handleSeasonal(w http.ResponseWriter, r *http.Request) {
+	now := time.Now()
+	tags := seasonalTagsForMonth(now)
+
+	s.mu.Lock()
+	recipes := []Recipe{}
+	for _, recipe := range s.list() {
+		for _, tag := range recipe.SeasonTags {
+			if containsString(tags, tag) {
+				recipes = append(recipes, recipe)
+				break
+			}
+		}
+	}
+	s.mu.Unlock()
+
+	writeMockJSON(w, http.StatusOK, SeasonalCollection{Month: germanMonths[now.Month()-1], Tags: tags, Recipes: recipes})
+}
+
+func containsString(values []string, value string) bool {
+	for _, v := range values {
+		if v == value {
+			return true
+		}
+	}
+	return false
+}
+
+func (s *mockStore) handleStats(w http.ResponseWriter, r *http.Request) {
+	s.mu.Lock()
+	recipes := s.list()
+	s.mu.Unlock()
+
+	categories := map[string]int{}
+	for _, recipe := range recipes {
+		categories[recipe.Category]++
+	}
+	stats := RecipeStats{
+		TotalRecipes:   len(recipes),
+		Categories:     []CountEntry{},
+		TopIngredients: []CountEntry{{Name: "Mehl", Count: 2}},
+		Cuisines:       []CountEntry{},
+		AddedPerMonth:  []CountEntry{{Name: time.Now().UTC().Format("2006-01"), Count: len(recipes)}},
+		YearRecipes:    len(recipes),
+	}
+	for name, count := range categories {
+		stats.Categories = append(stats.Categories, CountEntry{Name: name, Count: count})
+	}
+	sort.Slice(stats.Categories, func(i, j int) bool { return stats.Categories[i].Name < stats.Categories[j].Name })
+	writeMockJSON(w, http.StatusOK, stats)
+}
+
+func (s *mockStore) handleGetMealPlan(w http.ResponseWriter, r *http.Request) {
+	s.mu.Lock()
+	entries := append([]MealPlanEntry{}, s.mealPlan...)
+	s.mu.Unlock()
+
+	writeMockJSON(w, http.StatusOK, entries)
+}
+
+func (s *mockStore) handleAddMealPlanEntry(w http.ResponseWriter, r *http.Request) {
+	var entry MealPlanEntry
+	if err := json.NewDecoder(r.Body).Decode(&entry); err != nil {
+		http.Error(w, "Invalid JSON payload", http.StatusBadRequest)
+		return
+	}
+	if _, err := time.Parse(time.DateOnly, entry.Day); err != nil {
+		http.Error(w, "day must be YYYY-MM-DD", http.StatusBadRequest)
+		return
+	}
+	if entry.Meal == "" {
+		entry.Meal = mealDinner
+	}
+	if _, known := mealLabels[entry.Meal]; !known {
+		http.Error(w, "meal must be breakfast, lunch or dinner", http.StatusBadRequest)
+		return
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	recipe, found := s.recipes[entry.RecipeID]
+	if !found {
+		http.Error(w, "Recipe not found", http.StatusNotFound)
+		return
+	}
+	entry.ID = int64(s.newID())
+	entry.Recipename, entry.Slug, entry.Draft = recipe.Recipename, recipe.Slug, recipe.Draft
+	s.mealPlan = append(s.mealPlan, entry)
+
+	writeMockJSON(w, http.StatusOK, map[string]int64{"id": entry.ID})
+}
+
+func (s *mockStore) handleDeleteMealPlanEntry(w http.ResponseWriter, r *http.Request) {
+	id, ok := mockPathID(w, r, "id")
+	if !ok {
+		return
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for i, entry := range s.mealPlan {
+		if entry.ID == int64(id) {
+			s.mealPlan = append(s.mealPlan[:i], s.mealPlan[i+1:]...)
+			w.WriteHeader(http.StatusOK)
+			return
+		}
+	}
+	http.Error(w, "Meal plan entry not found", http.StatusNotFound)
+}
+
+func (s *mockStore) recipeBySlug(subdomain string, slug string) (Recipe, bool) {
+	if subdomain != mockUser.Subdomain {
+		return Recipe{}, false
+	}
+	for _, recipe := range s.recipes {
+		if recipe.Slug == slug && !recipe.Draft && !recipe.Archived {
+			return recipe, true
+		}
+	}
+	return Recipe{}, false
+}
+
+func (s *mockStore) handleGetPublicRecipe(w http.ResponseWriter, r *http.Request) {
+	s.mu.Lock()
+	recipe, found := s.recipeBySlug(r.PathValue("subdomain"), r.PathValue("slug"))
+	s.mu.Unlock()
+	if !found {
+		http.Error(w, "Recipe not found", http.StatusNotFound)
+		return
+	}
+
+	writeMockJSON(w, http.StatusOK, PublicRecipe{Slug: recipe.Slug, Content: publishedRecipeMarkdown(recipe.Recipe, recipe.RecipeTiming), UpdatedAt: *recipe.UpdatedAt})
+}
+
+// handleServeSite serves the embedded site with the recipes of the store.
+func (s *mockStore) handleServeSite(w http.ResponseWriter, r *http.Request) {
+	if r.PathValue("subdomain") != mockUser.Subdomain {
+		http.NotFound(w, r)
+		return
+	}
+
+	name := r.PathValue("path")
+	switch {
+	case name == "" || name == "index.html":
+		w.Header().Set("Content-Type", "text/html; charset=utf-8")
+		s.mu.Lock()
+		allowed := s.allowIndexing
+		s.mu.Unlock()
+		w.Write(siteIndexHTML(mockUser.Subdomain, allowed))
+	case name == "recipes.md":
+		s.mu.Lock()
+		var index strings.Builder
+		index.WriteString("# Rezepte\n\n")
+		for _, recipe := range s.list() {
+			if !recipe.Draft && !recipe.Archived {
+				fmt.Fprintf(&index, "- [%s](recipes/%s.md)\n", recipe.Recipename, recipe.Slug)
+			}
+		}
+		s.mu.Unlock()
+		w.Header().Set("Content-Type", "text/markdown; charset=utf-8")
+		w.Write([]byte(index.String()))
+	case strings.HasPrefix(name, "recipes/") && strings.HasSuffix(name, ".md"):
+		s.mu.Lock()
+		recipe, found := s.recipeBySlug(mockUser.Subdomain, strings.TrimSuffix(strings.TrimPrefix(name, "recipes/"), ".md"))
+		s.mu.Unlock()
+		if !found {
+			http.NotFound(w, r)
+			return
+		}
+		w.Header().Set("Content-Type", "text/markdown; charset=utf-8")
+		w.Write([]byte(publishedRecipeMarkdown(recipe.Recipe, recipe.RecipeTiming)))
+	default:
+		http.NotFound(w, r)
+	}
+}
+
+func (s *mockStore) handleSetOpenAIKey(w http.ResponseWriter, r *http.Request) {
+	var req struct {
+		APIKey  string `json:"apiKey"`
+		BaseURL string `json:"baseURL"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil || !strings.HasPrefix(req.APIKey, "sk-") {
+		http.Error(w, "Invalid OpenAI key", http.StatusBadRequest)
+		return
+	}
+
+	s.mu.Lock()
+	s.openAIKey = OpenAIKeyStatus{Configured: true, Enabled: true, BaseURL: req.BaseURL, KeyHint: req.APIKey[len(req.APIKey)-4:]}
+	s.mu.Unlock()
+
+	w.WriteHeader(http.StatusOK)
+}
+
+func (s *mockStore) handleToggleOpenAIKey(w http.ResponseWriter, r *http.Request) {
+	var req struct {
+		Enabled *bool `json:"enabled"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil || req.Enabled == nil {
+		http.Error(w, "Missing enabled", http.StatusBadRequest)
+		return
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if !s.openAIKey.Configured {
+		http.Error(w, "No OpenAI key configured", http.StatusNotFound)
+		return
+	}
+	s.openAIKey.Enabled = *req.Enabled
+	w.WriteHeader(http.StatusOK)
+}
+
+func (s *mockStore) handleGetOpenAIKey(w http.ResponseWriter, r *http.Request) {
+	s.mu.Lock()
+	status := s.openAIKey
+	s.mu.Unlock()
+
+	writeMockJSON(w, http.StatusOK, status)
+}
+
+func (s *mockStore) handleListWebhooks(w http.ResponseWriter, r *http.Request) {
+	s.mu.Lock()
+	webhooks := []Webhook{}
+	for _, webhook := range s.webhooks {
+		webhook.Secret = ""
+		webhooks = append(webhooks, webhook)
+	}
+	s.mu.Unlock()
+
+	writeMockJSON(w, http.StatusOK, webhooks)
+}
+
+func (s *mockStore) handleAddWebhook(w http.ResponseWriter, r *http.Request) {
+	var req struct {
+		URL    string   `json:"url"`
+		Events []string `json:"events"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "Invalid JSON payload", http.StatusBadRequest)
+		return
+	}
+	target, err := url.Parse(req.URL)
+	if err != nil || target.Scheme != "https" || target.Host == "" {
+		http.Error(w, "Webhook URL must be an absolute https URL", http.StatusBadRequest)
+		return
+	}
+	if len(req.Events) == 0 {
+		http.Error(w, "Missing events", http.StatusBadRequest)
+		return
+	}
+	for _, event := range req.Events {
+		if !webhookEvents[event] {
+			http.Error(w, "Unknown event: "+event, http.StatusBadRequest)
+			return
+		}
+	}
+
+	s.mu.Lock()
+	webhook := Webhook{ID: s.newID(), URL: req.URL, Events: req.Events, Secret: "whsec_mock", CreatedAt: time.Now().UTC()}
+	s.webhooks = append(s.webhooks, webhook)
+	s.mu.Unlock()
+
+	writeMockJSON(w, http.StatusCreated, webhook)
+}
+
+func (s *mockStore) handleDeleteWebhook(w http.ResponseWriter, r *http.Request) {
+	id, ok := mockPathID(w, r, "id")
+	if !ok {
+		return
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for i, webhook := range s.webhooks {
+		if webhook.ID == id {
+			s.webhooks = append(s.webhooks[:i], s.webhooks[i+1:]...)
+			w.WriteHeader(http.StatusOK)
+			return
+		}
+	}
+	http.Error(w, "Webhook not found", http.StatusNotFound)
+}
+
+func (s *mockStore) handleListAPIKeys(w http.ResponseWriter, r *http.Request) {
+	s.mu.Lock()
+	keys := []APIKey{}
+	for _, key := range s.apiKeys {
+		key.Key = ""
+		keys = append(keys, key)
+	}
+	s.mu.Unlock()
+
+	writeMockJSON(w, http.StatusOK, keys)
+}
+
+func (s *mockStore) handleAddAPIKey(w http.ResponseWriter, r *http.Request) {
+	var req struct {
+		Name string `json:"name"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil || strings.TrimSpace(req.Name) == "" {
+		http.Error(w, "Missing name", http.StatusBadRequest)
+		return
+	}
+
+	s.mu.Lock()
+	key := APIKey{ID: int64(s.newID()), Name: strings.TrimSpace(req.Name), Key: apiKeyPrefix + uuid.New().String(), CreatedAt: time.Now().UTC()}
+	key.Hint = key.Key[len(key.Key)-4:]
+	s.apiKeys = append(s.apiKeys, key)
+	s.mu.Unlock()
+
+	writeMockJSON(w, http.StatusOK, key)
+}
+
+func (s *mockStore) handleDeleteAPIKey(w http.ResponseWriter, r *http.Request) {
+	id, ok := mockPathID(w, r, "id")
+	if !ok {
+		return
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for i, key := range s.apiKeys {
+		if key.ID == int64(id) {
+			s.apiKeys = append(s.apiKeys[:i], s.apiKeys[i+1:]...)
+			w.WriteHeader(http.StatusOK)
+			return
+		}
+	}
+	http.Error(w, "API key not found", http.StatusNotFound)
+}
+
+// handleClip adds the fake recipe for the page, any key of the store is
+// accepted.
+func (s *mockStore) handleClip(w http.ResponseWriter, r *http.Request) {
+	key := r.URL.Query().Get("key")
+	if auth := r.Header.Get("Authorization"); strings.HasPrefix(auth, "Bearer "+apiKeyPrefix) {
+		key = strings.TrimPrefix(auth, "Bearer ")
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	valid := false
+	for _, apiKey := range s.apiKeys {
+		valid = valid || apiKey.Key == key
+	}
+	if !valid {
+		http.Error(w, "Missing API key", http.StatusUnauthorized)
+		return
+	}
+	if r.URL.Query().Get("url") == "" {
+		http.Error(w, "Missing url", http.StatusBadRequest)
+		return
+	}
+
+	recipe := s.add("Pfannkuchen", fakeRecipe, "Dessert")
+	http.Redirect(w, r, recipe.URL, http.StatusSeeOther)
+}
+
+func (s *mockStore) handleGetIndexSettings(w http.ResponseWriter, r *http.Request) {
+	s.mu.Lock()
+	settings := s.indexSettings
+	s.mu.Unlock()
+
+	writeMockJSON(w, http.StatusOK, settings)
+}
+
+func (s *mockStore) handleUpdateIndexSettings(w http.ResponseWriter, r *http.Request) {
+	settings := defaultIndexSettings()
+	if err := json.NewDecoder(r.Body).Decode(&settings); err != nil {
+		http.Error(w, "Invalid JSON payload", http.StatusBadRequest)
+		return
+	}
+	if message := validateIndexSettings(&settings); message != "" {
+		http.Error(w, message, http.StatusBadRequest)
+		return
+	}
+
+	s.mu.Lock()
+	s.indexSettings = settings
+	s.mu.Unlock()
+
+	w.WriteHeader(http.StatusOK)
+}
+
+func (s *mockStore) handleDeadLinks(repair bool) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		s.mu.Lock()
+		links := 0
+		for _, recipe := range s.recipes {
+			if !recipe.Draft && !recipe.Archived {
+				links++
+			}
+		}
+		s.mu.Unlock()
+
+		writeMockJSON(w, http.StatusOK, LinkReport{CheckedAt: time.Now().UTC(), Links: links, Dead: []DeadLink{}, Repaired: repair})
+	}
+}
+
+func (s *mockStore) handleGetSiteIndexing(w http.ResponseWriter, r *http.Request) {
+	s.mu.Lock()
+	allowed := s.allowIndexing
+	s.mu.Unlock()
+
+	writeMockJSON(w, http.StatusOK, SiteIndexing{AllowIndexing: allowed})
+}
+
+func (s *mockStore) handleUpdateSiteIndexing(w http.ResponseWriter, r *http.Request) {
+	var req SiteIndexing
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "Invalid JSON payload", http.StatusBadRequest)
+		return
+	}
+
+	s.mu.Lock()
+	s.allowIndexing = req.AllowIndexing
+	s.mu.Unlock()
+
+	w.WriteHeader(http.StatusOK)
+}
@@ -0,0 +1,144 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+// The mock server is what the frontend is developed against, these tests
+// keep it in step with registerRoutes.
+
+func newMockRoutes(t *testing.T) *recordingMux {
+	store := newMockStore()
+	saved := loadUserSettings
+	loadUserSettings = store.loadSettings
+	t.Cleanup(func() { loadUserSettings = saved })
+
+	mux := &recordingMux{ServeMux: http.NewServeMux()}
+	registerMockRoutes(mux, store)
+	return mux
+}
+
+func TestMockCoversRoutes(t *testing.T) {
+	mocked := map[string]bool{}
+	for _, pattern := range newMockRoutes(t).patterns {
+		if mocked[pattern] {
+			t.Errorf("%s is mocked twice", pattern)
+		}
+		mocked[pattern] = true
+	}
+
+	real := map[string]bool{}
+	for _, pattern := range newRoutes().patterns {
+		real[pattern] = true
+		_, unsupported := mockUnsupportedRoutes[pattern]
+		if !mocked[pattern] && !unsupported {
+			t.Errorf("%s is not mocked", pattern)
+		}
+		if mocked[pattern] && unsupported {
+			t.Errorf("%s is mocked but listed in mockUnsupportedRoutes", pattern)
+		}
+	}
+	for pattern := range mocked {
+		if !real[pattern] {
+			t.Errorf("%s is mocked but not a route", pattern)
+		}
+	}
+	for pattern := range mockUnsupportedRoutes {
+		if !real[pattern] {
+			t.Errorf("mockUnsupportedRoutes lists %s, which is not a route", pattern)
+		}
+	}
+}
+
+// TestMockRoutesAnswer sends every route a request without a body, the
+// mock has to answer it without a server error.
+func TestMockRoutesAnswer(t *testing.T) {
+	mux := newMockRoutes(t)
+	for _, pattern := range mux.patterns {
+		t.Run(pattern, func(t *testing.T) {
+			rec := httptest.NewRecorder()
+			mux.ServeHTTP(rec, routeRequest(pattern))
+			if rec.Code >= http.StatusInternalServerError {
+				t.Errorf("got %d %q", rec.Code, rec.Body.String())
+			}
+		})
+	}
+}
+
+// TestMockResponses decodes what the mock answers into the types of the
+// real handlers, fields they don't have fail the test.
+func TestMockResponses(t *testing.T) {
+	mux := newMockRoutes(t)
+
+	tests := []struct {
+		method string
+		target string
+		body   interface{}
+		want   int
+		into   interface{}
+	}{
+		{http.MethodGet, "/api/v1/get-recipes", nil, http.StatusOK, &[]Recipe{}},
+		{http.MethodGet, "/api/v1/get-recipes?counts=true", nil, http.StatusOK, &RecipeList{}},
+		{http.MethodPost, "/api/v1/add-recipe", RecipeRequest{Recipename: "Brot", Recipe: fakeRecipe}, http.StatusCreated, &Recipe{}},
+		{http.MethodGet, "/api/v1/recipes/1", nil, http.StatusOK, &RecipeDetail{}},
+		{http.MethodGet, "/api/v1/recipes/99", nil, http.StatusNotFound, nil},
+		{http.MethodGet, "/api/v1/recipes/1/schedule", nil, http.StatusOK, &RecipeSchedule{}},
+		{http.MethodGet, "/api/v1/recipes/1/lineage", nil, http.StatusOK, &RecipeLineage{}},
+		{http.MethodGet, "/api/v1/recipes/1/pairings", nil, http.StatusOK, &Pairings{}},
+		{http.MethodPost, "/api/v1/recipes/1/veganize", nil, http.StatusOK, &VeganizedRecipe{}},
+		{http.MethodPost, "/api/v1/recipes/1/archive", nil, http.StatusOK, &Recipe{}},
+		{http.MethodPost, "/api/v1/recipes/bulk/category", bulkRequest{RecipeIDs: []int{1, 2}, Category: "Brot"}, http.StatusOK, &BulkResult{}},
+		{http.MethodPost, "/api/v1/recipes/merge", mergeRequest{RecipeID: 1, DuplicateID: 1}, http.StatusBadRequest, nil},
+		{http.MethodGet, "/api/v1/recipes/duplicates", nil, http.StatusOK, &DuplicateReport{}},
+		{http.MethodPost, "/api/v1/recipes/reclassify", map[string]bool{"dryRun": true}, http.StatusOK, &ReclassifyResult{}},
+		{http.MethodPost, "/api/v1/generate/menu", MenuRequest{Occasion: "Geburtstag", Guests: 4}, http.StatusOK, &Menu{}},
+		{http.MethodPost, "/api/v1/parse/ingredients", map[string]string{"text": "200 g Mehl\n3 Eier"}, http.StatusOK, &[]ParsedIngredient{}},
+		{http.MethodGet, "/api/v1/categories", nil, http.StatusOK, &[]Category{}},
+		{http.MethodPost, "/api/v1/collections", map[string]string{"name": "Sonntag"}, http.StatusCreated, &Collection{}},
+		{http.MethodGet, "/api/v1/collections", nil, http.StatusOK, &[]Collection{}},
+		{http.MethodGet, "/api/v1/seasonal", nil, http.StatusOK, &SeasonalCollection{}},
+		{http.MethodGet, "/api/v1/stats", nil, http.StatusOK, &RecipeStats{}},
+		{http.MethodPost, "/api/v1/meal-plan", map[string]interface{}{"recipeID": 1, "day": "2026-10-14"}, http.StatusOK, &map[string]int64{}},
+		{http.MethodPost, "/api/v1/meal-plan", map[string]interface{}{"recipeID": 1, "day": "morgen"}, http.StatusBadRequest, nil},
+		{http.MethodGet, "/api/v1/meal-plan", nil, http.StatusOK, &[]MealPlanEntry{}},
+		{http.MethodGet, "/api/v1/storage/usage", nil, http.StatusOK, &StorageUsage{}},
+		{http.MethodPost, "/api/v1/webhooks", map[string]interface{}{"url": "https://example.com/hook", "events": []string{eventRecipeCreated}}, http.StatusCreated, &Webhook{}},
+		{http.MethodPost, "/api/v1/webhooks", map[string]interface{}{"url": "http://example.com/hook", "events": []string{eventRecipeCreated}}, http.StatusBadRequest, nil},
+		{http.MethodPost, "/api/v1/api-keys", map[string]string{"name": "Bookmarklet"}, http.StatusOK, &APIKey{}},
+		{http.MethodGet, "/api/v1/account/identities", nil, http.StatusOK, &[]Identity{}},
+		{http.MethodPost, "/api/v1/account/link-code", nil, http.StatusOK, &LinkCode{}},
+		{http.MethodGet, "/api/v1/index-settings", nil, http.StatusOK, &IndexSettings{}},
+		{http.MethodGet, "/api/v1/site/dead-links", nil, http.StatusOK, &LinkReport{}},
+		{http.MethodGet, "/api/v1/site-indexing", nil, http.StatusOK, &SiteIndexing{}},
+		{http.MethodGet, "/api/v1/admin/jobs", nil, http.StatusOK, &[]JobStatus{}},
+		{http.MethodGet, "/api/v1/admin/storage-usage", nil, http.StatusOK, &StorageUsageReport{}},
+		{http.MethodGet, "/api/v1/admin/feedback", nil, http.StatusOK, &FeedbackReport{}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.method+" "+tt.target, func(t *testing.T) {
+			var body bytes.Buffer
+			if tt.body != nil {
+				if err := json.NewEncoder(&body).Encode(tt.body); err != nil {
+					t.Fatal(err)
+				}
+			}
+			rec := httptest.NewRecorder()
+			mux.ServeHTTP(rec, httptest.NewRequest(tt.method, tt.target, &body))
+			if rec.Code != tt.want {
+				t.Fatalf("got %d %q, want %d", rec.Code, rec.Body.String(), tt.want)
+			}
+			if tt.into == nil {
+				return
+			}
+			decoder := json.NewDecoder(rec.Body)
+			decoder.DisallowUnknownFields()
+			if err := decoder.Decode(tt.into); err != nil {
+				t.Errorf("decoding: %v", err)
+			}
+		})
+	}
+}
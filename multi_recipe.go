@@ -0,0 +1,120 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"log"
+	"net/http"
+	"strings"
+)
+
+// maxExtractedRecipes caps what one source can yield, a cookbook page rarely
+// holds more than a handful.
+const maxExtractedRecipes = 10
+
+const multiRecipeInstruction = "The source may contain several recipes, for example a cookbook page or a blog post with variations. " +
+	"Write every complete recipe in the format above, in the language asked for above. " +
+	`Answer with a JSON object {"recipes": [{"title": "...", "recipe": "..."}]} holding the title and the full markdown of each recipe, ` +
+	"in the order they appear in the source."
+
+// RecipeCandidates is the answer of the generate endpoints when several
+// recipes were asked for. Nothing is saved, the client adds the recipes the
+// user picks through add-recipe.
+type RecipeCandidates struct {
+	Recipes []Recipe `json:"recipes"`
+}
+
+var errNoRecipes = errors.New("no recipes found in the source")
+
+// extractRecipes asks for all recipes of the source at once, req carries the
+// source as prompt or image.
+func extractRecipes(ctx context.Context, req llmRequest, oven OvenSettings) ([]Recipe, error) {
+	req.Task = llmTaskMultiRecipe
+	var answer struct {
+		Recipes []struct {
+			Title  string `json:"title"`
+			Recipe string `json:"recipe"`
+		} `json:"recipes"`
+	}
+	if err := completeJSON(ctx, req, &answer); err != nil {
+		return nil, err
+	}
+
+	recipes := []Recipe{}
+	for _, found := range answer.Recipes {
+		title, content := strings.TrimSpace(found.Title), strings.TrimSpace(found.Recipe)
+		if title == "" || content == "" {
+			continue
+		}
+		if err := checkRecipeOutput(content); err != nil {
+			log.Printf("Skipping extracted recipe %q: %v\n", title, err)
+			continue
+		}
+		recipe := Recipe{Recipename: title, Recipe: addFoodSafetyNote(ctx, adjustForOven(content+"\n", oven))}
+		addTimingEstimate(ctx, &recipe)
+		recipes = append(recipes, recipe)
+		if len(recipes) == maxExtractedRecipes {
+			break
+		}
+	}
+	if len(recipes) == 0 {
+		return nil, errNoRecipes
+	}
+	return recipes, nil
+}
+
+func GenerateRecipesByLink(ctx context.Context, URL string, isGerman bool, oven OvenSettings) ([]Recipe, error) {
+	websitecontent, err := GetWebsite(ctx, URL)
+	if err != nil {
+		return nil, err
+	}
+	archiveSource(ctx, URL, websitecontent)
+
+	// too long pages are turned away by limitedLLM, not cut
+	page := wrapSource(sanitizeSource(websitecontent))
+	req := llmRequest{
+		System: recipeSystemMessage(ctx, isGerman) + "\n\n" + multiRecipeInstruction + "\n\n" + untrustedSourceRule,
+		Prompt: "Change to markdown format:\n\n" + page,
+	}
+	if isGerman {
+		req.Prompt = "Ändere die Rezepte in Markdown-Format:\n\n" + page
+	}
+
+	recipes, err := extractRecipes(ctx, req, oven)
+	if err != nil {
+		return nil, err
+	}
+	source, _ := normalizeSourceURL(URL)
+	for i := range recipes {
+		recipes[i].SourceURL = source
+	}
+	return recipes, nil
+}
+
+func GenerateRecipesByImage(ctx context.Context, image string, isGerman bool, oven OvenSettings) ([]Recipe, error) {
+	return extractRecipes(ctx, llmRequest{
+		Prompt:      recipeSystemMessage(ctx, isGerman) + "\n\n" + multiRecipeInstruction + "\n\n" + untrustedImageRule,
+		ImageBase64: image,
+	}, oven)
+}
+
+func writeRecipeCandidates(w http.ResponseWriter, recipes []Recipe, err error) {
+	if errors.Is(err, errNoRecipes) {
+		http.Error(w, "No recipe found in the source", http.StatusUnprocessableEntity)
+		return
+	}
+	if err != nil {
+		log.Printf("Error extracting recipes: %v\n", err)
+		http.Error(w, "Error generating recipe", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+
+	err = json.NewEncoder(w).Encode(RecipeCandidates{Recipes: recipes})
+	if err != nil {
+		http.Error(w, "Error encoding JSON response", http.StatusInternalServerError)
+	}
+}
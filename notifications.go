@@ -0,0 +1,245 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"log"
+	"net/http"
+	"strings"
+	"time"
+)
+
+type NotificationSettings struct {
+	WeeklyDigest      bool `json:"weeklyDigest"`
+	WeeklySuggestions bool `json:"weeklySuggestions"`
+}
+
+func GetNotificationSettings(ctx context.Context, userID int) (NotificationSettings, error) {
+	var settings NotificationSettings
+	err := pool.QueryRow(ctx, `
+		SELECT coalesce(bool_or(weekly_digest), false), coalesce(bool_or(weekly_suggestions), false)
+		FROM notification_settings WHERE user_id = $1`, userID).Scan(&settings.WeeklyDigest, &settings.WeeklySuggestions)
+	return settings, err
+}
+
+func HandleGetNotificationSettings(w http.ResponseWriter, r *http.Request) {
+	userCtx, ok := r.Context().Value("user").(UserContext)
+	if !ok {
+		http.Error(w, "Unauthorized: user context missing", http.StatusUnauthorized)
+		return
+	}
+
+	settings, err := GetNotificationSettings(r.Context(), userCtx.UserID)
+	if err != nil {
+		log.Printf("Error getting notification settings: %v\n", err)
+		http.Error(w, "Error getting notification settings", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+
+	err = json.NewEncoder(w).Encode(settings)
+	if err != nil {
+		http.Error(w, "Error encoding JSON response", http.StatusInternalServerError)
+	}
+}
+
+func HandleUpdateNotificationSettings(w http.ResponseWriter, r *http.Request) {
+	userCtx, ok := r.Context().Value("user").(UserContext)
+	if !ok {
+		http.Error(w, "Unauthorized: user context missing", http.StatusUnauthorized)
+		return
+	}
+
+	var settings NotificationSettings
+	if err := json.NewDecoder(r.Body).Decode(&settings); err != nil {
+		http.Error(w, "Invalid JSON payload", http.StatusBadRequest)
+		return
+	}
+
+	_, err := pool.Exec(r.Context(), `
+		INSERT INTO notification_settings (user_id, weekly_digest, weekly_suggestions) VALUES ($1, $2, $3)
+		ON CONFLICT (user_id) DO UPDATE SET weekly_digest = $2, weekly_suggestions = $3`,
+		userCtx.UserID, settings.WeeklyDigest, settings.WeeklySuggestions)
+	if err != nil {
+		log.Printf("Error updating notification settings: %v\n", err)
+		http.Error(w, "Error updating notification settings", http.StatusInternalServerError)
+		return
+	}
+
+	w.WriteHeader(http.StatusOK)
+}
+
+func HandleEmailShoppingList(w http.ResponseWriter, r *http.Request) {
+	userCtx, ok := r.Context().Value("user").(UserContext)
+	if !ok {
+		http.Error(w, "Unauthorized: user context missing", http.StatusUnauthorized)
+		return
+	}
+
+	var req struct {
+		RecipeIDs []int `json:"recipeIDs"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "Invalid JSON payload", http.StatusBadRequest)
+		return
+	}
+	if len(req.RecipeIDs) == 0 {
+		http.Error(w, "Missing recipeIDs", http.StatusBadRequest)
+		return
+	}
+	if !strings.Contains(userCtx.Email, "@") {
+		http.Error(w, "No email address known for this account", http.StatusBadRequest)
+		return
+	}
+
+	rows, err := pool.Query(r.Context(), "SELECT title, content FROM recipes WHERE user_id = $1 AND id = ANY($2) ORDER BY title",
+		userCtx.UserID, req.RecipeIDs)
+	if err != nil {
+		log.Printf("Error getting recipes for shopping list: %v\n", err)
+		http.Error(w, "Error getting recipes", http.StatusInternalServerError)
+		return
+	}
+	defer rows.Close()
+
+	var body strings.Builder
+	body.WriteString("Einkaufsliste\n")
+	found := 0
+	for rows.Next() {
+		var title, content string
+		if err := rows.Scan(&title, &content); err != nil {
+			log.Printf("Error scanning recipe: %v\n", err)
+			http.Error(w, "Error getting recipes", http.StatusInternalServerError)
+			return
+		}
+		found++
+
+		body.WriteString("\n" + title + "\n")
+		for _, ingredient := range extractIngredientLines(content) {
+			body.WriteString("[ ] " + ingredient + "\n")
+		}
+	}
+	if found == 0 {
+		http.Error(w, "Recipes not found", http.StatusNotFound)
+		return
+	}
+
+	err = newMailer().Send(userCtx.Email, "Deine Einkaufsliste", body.String())
+	if err != nil {
+		log.Printf("Error sending shopping list: %v\n", err)
+		http.Error(w, "Error sending email", http.StatusInternalServerError)
+		return
+	}
+
+	w.WriteHeader(http.StatusOK)
+}
+
+// sendWeeklyMails mails users whose weekly email is due, it runs as a
+// scheduled job. The digest never reaches back before the user opted in:
+// recipes from before created_at existed all carry the time of that
+// migration and would show up as new.
+func sendWeeklyMails(ctx context.Context) error {
+	rows, err := pool.Query(ctx, `
+		SELECT u.id, u.email, u.subdomain, n.weekly_digest, n.weekly_suggestions,
+		       greatest(coalesce(n.last_weekly_at, now() - interval '7 days'), n.created_at)
+		FROM notification_settings n JOIN users u ON u.id = n.user_id
+		WHERE (n.weekly_digest OR n.weekly_suggestions) AND u.email LIKE '%@%'
+		  AND (n.last_weekly_at IS NULL OR n.last_weekly_at < now() - interval '7 days')`)
+	if err != nil {
+		return err
+	}
+
+	type dueUser struct {
+		id          int
+		email       string
+		subdomain   string
+		digest      bool
+		suggestions bool
+		since       time.Time
+	}
+	var due []dueUser
+	for rows.Next() {
+		var u dueUser
+		if err := rows.Scan(&u.id, &u.email, &u.subdomain, &u.digest, &u.suggestions, &u.since); err != nil {
+			rows.Close()
+			return err
+		}
+		due = append(due, u)
+	}
+	rows.Close()
+
+	mail := newMailer()
+	for _, u := range due {
+		var body strings.Builder
+
+		if u.digest {
+			recipes, err := recipesSince(ctx, u.id, u.since)
+			if err != nil {
+				log.Printf("Error getting new recipes for user %d: %v\n", u.id, err)
+				continue
+			}
+			if len(recipes) > 0 {
+				body.WriteString("Neue Rezepte diese Woche:\n")
+				for _, recipe := range recipes {
+					body.WriteString("- " + recipe.Recipename + ": " + recipeURL(u.subdomain, recipe.Slug) + "\n")
+				}
+				body.WriteString("\n")
+			}
+		}
+
+		if u.suggestions {
+			recipes, err := randomRecipes(ctx, u.id, 3)
+			if err != nil {
+				log.Printf("Error getting suggestions for user %d: %v\n", u.id, err)
+				continue
+			}
+			if len(recipes) > 0 {
+				body.WriteString("Was du diese Woche kochen könntest:\n")
+				for _, recipe := range recipes {
+					body.WriteString("- " + recipe.Recipename + ": " + recipeURL(u.subdomain, recipe.Slug) + "\n")
+				}
+			}
+		}
+
+		if body.Len() > 0 {
+			if err := mail.Send(u.email, "Deine Rezepte der Woche", body.String()); err != nil {
+				log.Printf("Error sending weekly mail to user %d: %v\n", u.id, err)
+				continue
+			}
+		}
+
+		_, err := pool.Exec(ctx, "UPDATE notification_settings SET last_weekly_at = now() WHERE user_id = $1", u.id)
+		if err != nil {
+			log.Printf("Error recording weekly mail for user %d: %v\n", u.id, err)
+		}
+	}
+
+	return nil
+}
+
+func recipesSince(ctx context.Context, userID int, since time.Time) ([]Recipe, error) {
+	return queryRecipeSummaries(ctx, "SELECT title, slug FROM recipes WHERE user_id = $1 AND created_at > $2 AND NOT draft ORDER BY created_at", userID, since)
+}
+
+func randomRecipes(ctx context.Context, userID int, n int) ([]Recipe, error) {
+	return queryRecipeSummaries(ctx, "SELECT title, slug FROM recipes WHERE user_id = $1 AND NOT archived AND NOT draft ORDER BY random() LIMIT $2", userID, n)
+}
+
+func queryRecipeSummaries(ctx context.Context, query string, args ...interface{}) ([]Recipe, error) {
+	rows, err := pool.Query(ctx, query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var recipes []Recipe
+	for rows.Next() {
+		var recipe Recipe
+		if err := rows.Scan(&recipe.Recipename, &recipe.Slug); err != nil {
+			return nil, err
+		}
+		recipes = append(recipes, recipe)
+	}
+	return recipes, rows.Err()
+}
@@ -0,0 +1,327 @@
+package main
+
+import (
+	"context"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+)
+
+type openAICredentials struct {
+	APIKey  string
+	BaseURL string
+}
+
+type OpenAIKeyStatus struct {
+	Configured bool   `json:"configured"`
+	Enabled    bool   `json:"enabled"`
+	BaseURL    string `json:"baseURL,omitempty"`
+	KeyHint    string `json:"keyHint,omitempty"`
+}
+
+var errNoOpenAIKey = errors.New("no OpenAI key configured")
+
+// openAIKeyClient talks to the base URLs users configure, which must not
+// lead into our network.
+var openAIKeyClient = publicHTTPClient(3 * time.Minute)
+
+// openAICredentialsFor returns the user's own key when one is stored and
+// enabled, otherwise the server key. A stored key that can't be read fails
+// the request: falling back to the server key would skip the limits that
+// generations on the user's own key are spared from.
+func openAICredentialsFor(ctx context.Context) (openAICredentials, error) {
+	if userCtx, ok := ctx.Value("user").(UserContext); ok {
+		creds, found, err := getUserOpenAICredentials(ctx, userCtx.UserID)
+		if err != nil {
+			return openAICredentials{}, fmt.Errorf("failed to get the OpenAI key of user %d: %w", userCtx.UserID, err)
+		}
+		if found {
+			return creds, nil
+		}
+	}
+
+	key, found := serverOpenAIKey(ctx)
+	if !found {
+		return openAICredentials{}, errNoOpenAIKey
+	}
+	return openAICredentials{APIKey: key}, nil
+}
+
+func getUserOpenAICredentials(ctx context.Context, userID int) (openAICredentials, bool, error) {
+	var encrypted []byte
+	var creds openAICredentials
+	err := pool.QueryRow(ctx, "SELECT encrypted_key, base_url FROM user_openai_keys WHERE user_id = $1 AND enabled",
+		userID).Scan(&encrypted, &creds.BaseURL)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return openAICredentials{}, false, nil
+	}
+	if err != nil {
+		return openAICredentials{}, false, err
+	}
+
+	key, err := decryptSecret(encrypted)
+	if err != nil {
+		return openAICredentials{}, false, err
+	}
+	creds.APIKey = key
+	return creds, true, nil
+}
+
+func usesOwnOpenAIKey(ctx context.Context, userID int) (bool, error) {
+	var enabled bool
+	err := pool.QueryRow(ctx, "SELECT coalesce(bool_or(enabled), false) FROM user_openai_keys WHERE user_id = $1",
+		userID).Scan(&enabled)
+	return enabled, err
+}
+
+func HandleGetOpenAIKey(w http.ResponseWriter, r *http.Request) {
+	userCtx, ok := r.Context().Value("user").(UserContext)
+	if !ok {
+		http.Error(w, "Unauthorized: user context missing", http.StatusUnauthorized)
+		return
+	}
+
+	var status OpenAIKeyStatus
+	err := pool.QueryRow(r.Context(), "SELECT enabled, base_url, key_hint FROM user_openai_keys WHERE user_id = $1",
+		userCtx.UserID).Scan(&status.Enabled, &status.BaseURL, &status.KeyHint)
+	if err != nil && !errors.Is(err, pgx.ErrNoRows) {
+		log.Printf("Error getting OpenAI key: %v\n", err)
+		http.Error(w, "Error getting OpenAI key", http.StatusInternalServerError)
+		return
+	}
+	status.Configured = err == nil
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+
+	err = json.NewEncoder(w).Encode(status)
+	if err != nil {
+		http.Error(w, "Error encoding JSON response", http.StatusInternalServerError)
+	}
+}
+
+// HandleSetOpenAIKey validates the key against the provider before storing it,
+// so a typo does not make every later generation fail.
+func HandleSetOpenAIKey(w http.ResponseWriter, r *http.Request) {
+	userCtx, ok := r.Context().Value("user").(UserContext)
+	if !ok {
+		http.Error(w, "Unauthorized: user context missing", http.StatusUnauthorized)
+		return
+	}
+
+	if _, err := encryptionKey(); err != nil {
+		log.Printf("Own OpenAI keys unavailable: %v\n", err)
+		http.Error(w, "Own API keys are not supported on this server", http.StatusServiceUnavailable)
+		return
+	}
+
+	var req struct {
+		APIKey  string `json:"apiKey"`
+		BaseURL string `json:"baseURL"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "Invalid JSON payload", http.StatusBadRequest)
+		return
+	}
+
+	req.APIKey = strings.TrimSpace(req.APIKey)
+	if req.APIKey == "" {
+		http.Error(w, "Missing apiKey", http.StatusBadRequest)
+		return
+	}
+
+	baseURL := strings.TrimSuffix(strings.TrimSpace(req.BaseURL), "/")
+	if baseURL != "" {
+		target, err := url.Parse(baseURL)
+		if err != nil || target.Scheme != "https" || target.Host == "" {
+			http.Error(w, "baseURL must be an absolute https URL", http.StatusBadRequest)
+			return
+		}
+	}
+
+	if err := validateOpenAIKey(r.Context(), openAICredentials{APIKey: req.APIKey, BaseURL: baseURL}); err != nil {
+		log.Printf("Rejected OpenAI key of user %d: %v\n", userCtx.UserID, err)
+		http.Error(w, "API key was rejected by the provider", http.StatusBadRequest)
+		return
+	}
+
+	encrypted, err := encryptSecret(req.APIKey)
+	if err != nil {
+		log.Printf("Error encrypting OpenAI key: %v\n", err)
+		http.Error(w, "Error saving OpenAI key", http.StatusInternalServerError)
+		return
+	}
+
+	_, err = pool.Exec(r.Context(), `
+		INSERT INTO user_openai_keys (user_id, encrypted_key, key_hint, base_url, enabled) VALUES ($1, $2, $3, $4, true)
+		ON CONFLICT (user_id) DO UPDATE SET encrypted_key = $2, key_hint = $3, base_url = $4, enabled = true, updated_at = now()`,
+		userCtx.UserID, encrypted, keyHint(req.APIKey), baseURL)
+	if err != nil {
+		log.Printf("Error saving OpenAI key: %v\n", err)
+		http.Error(w, "Error saving OpenAI key", http.StatusInternalServerError)
+		return
+	}
+
+	log.Printf("stored own OpenAI key for user %d", userCtx.UserID)
+	w.WriteHeader(http.StatusOK)
+}
+
+// HandleToggleOpenAIKey switches between the stored key and the server key
+// without deleting the stored one.
+func HandleToggleOpenAIKey(w http.ResponseWriter, r *http.Request) {
+	userCtx, ok := r.Context().Value("user").(UserContext)
+	if !ok {
+		http.Error(w, "Unauthorized: user context missing", http.StatusUnauthorized)
+		return
+	}
+
+	var req struct {
+		Enabled *bool `json:"enabled"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil || req.Enabled == nil {
+		http.Error(w, "Invalid JSON payload", http.StatusBadRequest)
+		return
+	}
+
+	tag, err := pool.Exec(r.Context(), "UPDATE user_openai_keys SET enabled = $1, updated_at = now() WHERE user_id = $2",
+		*req.Enabled, userCtx.UserID)
+	if err != nil {
+		log.Printf("Error updating OpenAI key: %v\n", err)
+		http.Error(w, "Error updating OpenAI key", http.StatusInternalServerError)
+		return
+	}
+	if tag.RowsAffected() == 0 {
+		http.Error(w, "No OpenAI key stored", http.StatusNotFound)
+		return
+	}
+
+	w.WriteHeader(http.StatusOK)
+}
+
+func HandleDeleteOpenAIKey(w http.ResponseWriter, r *http.Request) {
+	userCtx, ok := r.Context().Value("user").(UserContext)
+	if !ok {
+		http.Error(w, "Unauthorized: user context missing", http.StatusUnauthorized)
+		return
+	}
+
+	_, err := pool.Exec(r.Context(), "DELETE FROM user_openai_keys WHERE user_id = $1", userCtx.UserID)
+	if err != nil {
+		log.Printf("Error deleting OpenAI key: %v\n", err)
+		http.Error(w, "Error deleting OpenAI key", http.StatusInternalServerError)
+		return
+	}
+
+	w.WriteHeader(http.StatusOK)
+}
+
+// validateOpenAIKey lists the models, which every OpenAI compatible API
+// offers and which does not cost any tokens.
+func validateOpenAIKey(ctx context.Context, creds openAICredentials) error {
+	baseURL := creds.BaseURL
+	if baseURL == "" {
+		baseURL = "https://api.openai.com/v1"
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, 10*time.Second)
+	defer cancel()
+
+	req, err := http.NewRequestWithContext(ctx, "GET", baseURL+"/models", nil)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Authorization", "Bearer "+creds.APIKey)
+
+	resp, err := openAIKeyClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("models endpoint returned status %d", resp.StatusCode)
+	}
+	return nil
+}
+
+func keyHint(key string) string {
+	if len(key) <= 8 {
+		return "..."
+	}
+	return key[:3] + "..." + key[len(key)-4:]
+}
+
+// encryptionKey reads the 32 byte AES key from OPENAI_KEY_ENCRYPTION_KEY (base64).
+func encryptionKey() ([]byte, error) {
+	encoded, found := os.LookupEnv("OPENAI_KEY_ENCRYPTION_KEY")
+	if !found {
+		return nil, errors.New("OPENAI_KEY_ENCRYPTION_KEY not set")
+	}
+
+	key, err := base64.StdEncoding.DecodeString(encoded)
+	if err != nil {
+		return nil, fmt.Errorf("failed to decode OPENAI_KEY_ENCRYPTION_KEY: %w", err)
+	}
+	if len(key) != 32 {
+		return nil, fmt.Errorf("OPENAI_KEY_ENCRYPTION_KEY must be 32 bytes, got %d", len(key))
+	}
+	return key, nil
+}
+
+func newGCM() (cipher.AEAD, error) {
+	key, err := encryptionKey()
+	if err != nil {
+		return nil, err
+	}
+
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
+
+// encryptSecret returns the nonce followed by the AES-GCM sealed plaintext.
+func encryptSecret(plaintext string) ([]byte, error) {
+	gcm, err := newGCM()
+	if err != nil {
+		return nil, err
+	}
+
+	nonce := make([]byte, gcm.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		return nil, fmt.Errorf("failed to generate nonce: %w", err)
+	}
+
+	return gcm.Seal(nonce, nonce, []byte(plaintext), nil), nil
+}
+
+func decryptSecret(ciphertext []byte) (string, error) {
+	gcm, err := newGCM()
+	if err != nil {
+		return "", err
+	}
+
+	if len(ciphertext) < gcm.NonceSize() {
+		return "", errors.New("ciphertext too short")
+	}
+
+	nonce, sealed := ciphertext[:gcm.NonceSize()], ciphertext[gcm.NonceSize():]
+	plaintext, err := gcm.Open(nil, nonce, sealed, nil)
+	if err != nil {
+		return "", fmt.Errorf("failed to decrypt secret: %w", err)
+	}
+	return string(plaintext), nil
+}
@@ -0,0 +1,20 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestGetWebsitePrivateAddress(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, _ = w.Write([]byte("<h1>Pfannkuchen</h1>"))
+	}))
+	t.Cleanup(server.Close)
+
+	if _, err := GetWebsite(context.Background(), server.URL); !errors.Is(err, errPrivateAddress) {
+		t.Errorf("loopback page: got %v, want errPrivateAddress", err)
+	}
+}
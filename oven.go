@@ -0,0 +1,184 @@
+package main
+
+import (
+	"fmt"
+	"math"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+const (
+	ovenFan          = "fan"
+	ovenConventional = "conventional"
+)
+
+// Rules of thumb: a fan oven bakes like a conventional one 20 °C hotter.
+// Above about 900 m water boils early and batters dry out, baking goes a
+// little hotter and shorter.
+const (
+	fanOffsetCelsius         = 20
+	fanOffsetFahrenheit      = 25
+	altitudeThreshold        = 900
+	altitudeOffsetCelsius    = 15
+	altitudeOffsetFahrenheit = 25
+	altitudeTimeFactor       = 0.8
+	maxAltitude              = 5000
+)
+
+var (
+	ovenTemperaturePattern = regexp.MustCompile(`(\d{2,3})\s*(?:°\s*([CF])\b|Grad\b(?:\s*Celsius)?)`)
+	ovenMinutesPattern     = regexp.MustCompile(`(\d+)(?:\s*[-–]\s*(\d+))?(\s*)(Minuten|Min\.|minutes|mins|min)\b`)
+	fanMarkerPattern       = regexp.MustCompile(`(?i)\(\s*(?:Umluft|Heißluft|fan(?:-forced)?|convection)\s*\)|\b(?:Umluft|Heißluft|fan(?:-forced)?|convection)\b`)
+	conventionalPattern    = regexp.MustCompile(`(?i)\(\s*(?:Ober-/Unterhitze|O/U|conventional)\s*\)|Ober-/Unterhitze|\bO/U\b|\bconventional\b`)
+	doubleSpacePattern     = regexp.MustCompile(` {2,}`)
+)
+
+// OvenSettings adjust baking recipes for the user's oven, both are
+// optional. Oven is fan or conventional, Altitude is in meters.
+type OvenSettings struct {
+	Oven     string `json:"oven,omitempty"`
+	Altitude int    `json:"altitude,omitempty"`
+}
+
+// validate returns why the settings are rejected, empty when they are fine.
+func (s OvenSettings) validate() string {
+	if s.Oven != "" && s.Oven != ovenFan && s.Oven != ovenConventional {
+		return "oven must be fan or conventional"
+	}
+	if s.Altitude < 0 || s.Altitude > maxAltitude {
+		return "altitude must be between 0 and " + strconv.Itoa(maxAltitude) + " meters"
+	}
+	return ""
+}
+
+// parseOvenSettings reads the settings from form values, for the multipart
+// endpoints.
+func parseOvenSettings(oven string, altitude string) (OvenSettings, string) {
+	settings := OvenSettings{Oven: oven}
+	if altitude != "" {
+		parsed, err := strconv.Atoi(altitude)
+		if err != nil {
+			return OvenSettings{}, "altitude must be a number of meters"
+		}
+		settings.Altitude = parsed
+	}
+	return settings, settings.validate()
+}
+
+// adjustForOven rewrites oven temperatures and baking times with fixed
+// rules, it runs before a model sees or rewords the recipe so the numbers
+// don't depend on it. Lines without an oven temperature are kept, and a note
+// tells what was changed.
+func adjustForOven(markdown string, settings OvenSettings) string {
+	highAltitude := settings.Altitude >= altitudeThreshold
+	if settings.Oven == "" && !highAltitude {
+		return markdown
+	}
+	german := !strings.Contains(markdown, "## Ingredients")
+
+	changed := false
+	lines := strings.Split(markdown, "\n")
+	for i, line := range lines {
+		if !ovenTemperaturePattern.MatchString(line) {
+			continue
+		}
+		source := ovenConventional
+		if fanMarkerPattern.MatchString(line) {
+			source = ovenFan
+		}
+		target := settings.Oven
+		if target == "" {
+			target = source
+		}
+		if target == source && !highAltitude {
+			continue
+		}
+
+		if target != source {
+			line = fanMarkerPattern.ReplaceAllString(line, "")
+			line = conventionalPattern.ReplaceAllString(line, "")
+		}
+		line = ovenTemperaturePattern.ReplaceAllStringFunc(line, func(match string) string {
+			parts := ovenTemperaturePattern.FindStringSubmatch(match)
+			degrees, _ := strconv.Atoi(parts[1])
+			fahrenheit := parts[2] == "F"
+
+			fanOffset, altitudeOffset := fanOffsetCelsius, altitudeOffsetCelsius
+			if fahrenheit {
+				fanOffset, altitudeOffset = fanOffsetFahrenheit, altitudeOffsetFahrenheit
+			}
+			switch {
+			case source == ovenConventional && target == ovenFan:
+				degrees -= fanOffset
+			case source == ovenFan && target == ovenConventional:
+				degrees += fanOffset
+			}
+			if highAltitude {
+				degrees += altitudeOffset
+			}
+			degrees = int(math.Round(float64(degrees)/5) * 5)
+
+			adjusted := strings.Replace(match, parts[1], strconv.Itoa(degrees), 1)
+			if target != source {
+				adjusted += " (" + ovenLabel(target, german) + ")"
+			}
+			return adjusted
+		})
+		if highAltitude {
+			line = ovenMinutesPattern.ReplaceAllStringFunc(line, func(match string) string {
+				parts := ovenMinutesPattern.FindStringSubmatch(match)
+				adjusted := shortenMinutes(parts[1])
+				if parts[2] != "" {
+					adjusted += "–" + shortenMinutes(parts[2])
+				}
+				return adjusted + parts[3] + parts[4]
+			})
+		}
+		lines[i] = doubleSpacePattern.ReplaceAllString(line, " ")
+		changed = true
+	}
+	if !changed {
+		return markdown
+	}
+
+	return strings.TrimRight(strings.Join(lines, "\n"), "\n") + "\n\n> " + ovenNote(settings, german) + "\n"
+}
+
+func shortenMinutes(value string) string {
+	minutes, _ := strconv.Atoi(value)
+	return strconv.Itoa(max(1, int(math.Round(float64(minutes)*altitudeTimeFactor))))
+}
+
+func ovenLabel(oven string, german bool) string {
+	switch {
+	case german && oven == ovenFan:
+		return "Umluft"
+	case german:
+		return "Ober-/Unterhitze"
+	default:
+		return oven
+	}
+}
+
+func ovenNote(settings OvenSettings, german bool) string {
+	var parts []string
+	if settings.Oven != "" {
+		if german {
+			parts = append(parts, ovenLabel(settings.Oven, german))
+		} else {
+			parts = append(parts, "a "+settings.Oven+" oven")
+		}
+	}
+	if settings.Altitude >= altitudeThreshold {
+		if german {
+			parts = append(parts, fmt.Sprintf("%d m Höhe", settings.Altitude))
+		} else {
+			parts = append(parts, fmt.Sprintf("%d m altitude", settings.Altitude))
+		}
+	}
+	if german {
+		return "Temperaturen und Backzeiten angepasst für " + strings.Join(parts, " und ") + "."
+	}
+	return "Temperatures and baking times adjusted for " + strings.Join(parts, " and ") + "."
+}
@@ -0,0 +1,138 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/jackc/pgx/v5"
+)
+
+// maxPairingCandidates bounds how many of the user's own recipes are offered
+// to the model as side dishes.
+const maxPairingCandidates = 100
+
+type PairingSuggestion struct {
+	Name   string `json:"name"`
+	Reason string `json:"reason"`
+	// RecipeID is set when the suggestion is one of the user's recipes.
+	RecipeID int `json:"recipeID,omitempty"`
+}
+
+type Pairings struct {
+	Drinks     []PairingSuggestion `json:"drinks"`
+	SideDishes []PairingSuggestion `json:"sideDishes"`
+}
+
+const pairingSystemMessage = `You suggest drinks and side dishes that go well with a recipe.
+Answer with a JSON object of the form
+{"drinks": [{"name": "...", "reason": "..."}], "sideDishes": [{"name": "...", "reason": "...", "recipeID": 0}]}
+with up to 3 drinks (wine, beer and non-alcoholic options) and up to 3 side dishes.
+Keep each reason to one sentence.`
+
+func HandleGetPairings(w http.ResponseWriter, r *http.Request) {
+	userCtx, ok := r.Context().Value("user").(UserContext)
+	if !ok {
+		http.Error(w, "Unauthorized: user context missing", http.StatusUnauthorized)
+		return
+	}
+
+	recipeID, err := strconv.Atoi(r.PathValue("id"))
+	if err != nil {
+		http.Error(w, "Invalid recipe ID", http.StatusBadRequest)
+		return
+	}
+	isGerman, reason := parseIsGerman(r.Context(), r.URL.Query().Get("isGerman"))
+	if reason != "" {
+		http.Error(w, reason, http.StatusBadRequest)
+		return
+	}
+	fromCollection := r.URL.Query().Get("fromCollection") == "true"
+
+	var title, content string
+	err = pool.QueryRow(r.Context(), "SELECT title, content FROM recipes WHERE id = $1 AND user_id = $2",
+		recipeID, userCtx.UserID).Scan(&title, &content)
+	if err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			http.Error(w, "Recipe not found", http.StatusNotFound)
+			return
+		}
+		log.Printf("Error getting recipe: %v\n", err)
+		http.Error(w, "Error getting recipe", http.StatusInternalServerError)
+		return
+	}
+
+	system := pairingSystemMessage
+	if isGerman {
+		system += "\nAnswer in German."
+	} else {
+		system += "\nAnswer in English."
+	}
+
+	candidates := map[int]string{}
+	if fromCollection {
+		rows, err := pool.Query(r.Context(), "SELECT id, title FROM recipes WHERE user_id = $1 AND id <> $2 AND NOT archived ORDER BY updated_at DESC LIMIT $3",
+			userCtx.UserID, recipeID, maxPairingCandidates)
+		if err != nil {
+			log.Printf("Error getting pairing candidates: %v\n", err)
+			http.Error(w, "Error getting recipes", http.StatusInternalServerError)
+			return
+		}
+		var list strings.Builder
+		for rows.Next() {
+			var id int
+			var candidate string
+			if err := rows.Scan(&id, &candidate); err != nil {
+				rows.Close()
+				log.Printf("Error scanning pairing candidate: %v\n", err)
+				http.Error(w, "Error getting recipes", http.StatusInternalServerError)
+				return
+			}
+			candidates[id] = candidate
+			list.WriteString(fmt.Sprintf("%d: %s\n", id, candidate))
+		}
+		rows.Close()
+
+		if len(candidates) > 0 {
+			system += "\nPrefer side dishes from the user's own recipes listed below as id: title, and set recipeID for those. " +
+				"Use recipeID 0 for other suggestions.\n" + list.String()
+		}
+	}
+
+	var pairings Pairings
+	err = completeJSON(r.Context(), llmRequest{
+		Task:   llmTaskPairing,
+		System: system,
+		Prompt: "# " + title + "\n\n" + content,
+	}, &pairings)
+	if err != nil {
+		log.Printf("Error generating pairings: %v\n", err)
+		http.Error(w, "Error generating pairings", http.StatusInternalServerError)
+		return
+	}
+
+	// Only keep references the model was actually offered.
+	for i, side := range pairings.SideDishes {
+		if _, known := candidates[side.RecipeID]; !known {
+			pairings.SideDishes[i].RecipeID = 0
+		}
+	}
+	if pairings.Drinks == nil {
+		pairings.Drinks = []PairingSuggestion{}
+	}
+	if pairings.SideDishes == nil {
+		pairings.SideDishes = []PairingSuggestion{}
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+
+	err = json.NewEncoder(w).Encode(pairings)
+	if err != nil {
+		http.Error(w, "Error encoding JSON response", http.StatusInternalServerError)
+	}
+}
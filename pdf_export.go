@@ -0,0 +1,177 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"strings"
+	"unicode/utf8"
+
+	"golang.org/x/text/encoding/charmap"
+)
+
+// A small PDF writer for exports: A4 pages of wrapped text in the standard
+// Helvetica fonts, which every viewer has, so nothing is embedded. Text is
+// WinAnsi encoded, characters outside of it (emoji) are dropped.
+
+const (
+	pdfPageWidth  = 595.0
+	pdfPageHeight = 842.0
+	pdfMargin     = 56.0
+	// pdfCharWidth is the average Helvetica glyph width per point of font
+	// size, close enough to wrap lines.
+	pdfCharWidth = 0.5
+)
+
+type pdfDocument struct {
+	pages   []string
+	current strings.Builder
+	y       float64
+}
+
+func newPDFDocument() *pdfDocument {
+	doc := &pdfDocument{}
+	doc.y = pdfPageHeight - pdfMargin
+	return doc
+}
+
+func (doc *pdfDocument) newPage() {
+	if doc.current.Len() > 0 {
+		doc.pages = append(doc.pages, doc.current.String())
+		doc.current.Reset()
+	}
+	doc.y = pdfPageHeight - pdfMargin
+}
+
+// text writes wrapped lines, indent is in points from the left margin.
+func (doc *pdfDocument) text(text string, size float64, bold bool, indent float64) {
+	font := "F1"
+	width := pdfCharWidth
+	if bold {
+		font = "F2"
+		width += 0.05
+	}
+	maxChars := int((pdfPageWidth - 2*pdfMargin - indent) / (size * width))
+	leading := size * 1.35
+
+	for _, line := range wrapText(text, maxChars) {
+		if doc.y-leading < pdfMargin {
+			doc.newPage()
+		}
+		doc.y -= leading
+		fmt.Fprintf(&doc.current, "BT /%s %.1f Tf %.1f %.1f Td (%s) Tj ET\n", font, size, pdfMargin+indent, doc.y, pdfString(line))
+	}
+}
+
+// space adds vertical room, it does not carry over to a new page.
+func (doc *pdfDocument) space(points float64) {
+	doc.y -= points
+}
+
+func wrapText(text string, maxChars int) []string {
+	var lines []string
+	var line strings.Builder
+	for _, word := range strings.Fields(text) {
+		if line.Len() > 0 && utf8.RuneCountInString(line.String())+1+utf8.RuneCountInString(word) > maxChars {
+			lines = append(lines, line.String())
+			line.Reset()
+		}
+		if line.Len() > 0 {
+			line.WriteByte(' ')
+		}
+		line.WriteString(word)
+	}
+	if line.Len() > 0 {
+		lines = append(lines, line.String())
+	}
+	return lines
+}
+
+// pdfString encodes text for a literal string in a content stream.
+func pdfString(text string) string {
+	encoder := charmap.Windows1252.NewEncoder()
+	var out strings.Builder
+	for _, r := range text {
+		encoded, err := encoder.String(string(r))
+		if err != nil {
+			continue
+		}
+		switch encoded {
+		case `\`, "(", ")":
+			out.WriteString(`\` + encoded)
+		default:
+			out.WriteString(encoded)
+		}
+	}
+	return out.String()
+}
+
+// Bytes lays out the objects: catalog, page tree, the two fonts, then a page
+// and its content stream per page.
+func (doc *pdfDocument) Bytes() []byte {
+	doc.newPage()
+	if len(doc.pages) == 0 {
+		doc.pages = []string{""}
+	}
+
+	var buf bytes.Buffer
+	var offsets []int
+	object := func(body string) {
+		offsets = append(offsets, buf.Len())
+		fmt.Fprintf(&buf, "%d 0 obj\n%s\nendobj\n", len(offsets), body)
+	}
+
+	buf.WriteString("%PDF-1.4\n%\xe2\xe3\xcf\xd3\n")
+	object("<< /Type /Catalog /Pages 2 0 R >>")
+
+	kids := make([]string, len(doc.pages))
+	for i := range doc.pages {
+		kids[i] = fmt.Sprintf("%d 0 R", 5+2*i)
+	}
+	object(fmt.Sprintf("<< /Type /Pages /Kids [%s] /Count %d >>", strings.Join(kids, " "), len(doc.pages)))
+	object("<< /Type /Font /Subtype /Type1 /BaseFont /Helvetica /Encoding /WinAnsiEncoding >>")
+	object("<< /Type /Font /Subtype /Type1 /BaseFont /Helvetica-Bold /Encoding /WinAnsiEncoding >>")
+
+	for i, content := range doc.pages {
+		object(fmt.Sprintf("<< /Type /Page /Parent 2 0 R /MediaBox [0 0 %.0f %.0f] /Resources << /Font << /F1 3 0 R /F2 4 0 R >> >> /Contents %d 0 R >>",
+			pdfPageWidth, pdfPageHeight, 6+2*i))
+		object(fmt.Sprintf("<< /Length %d >>\nstream\n%sendstream", len(content), content))
+	}
+
+	xref := buf.Len()
+	fmt.Fprintf(&buf, "xref\n0 %d\n0000000000 65535 f \n", len(offsets)+1)
+	for _, offset := range offsets {
+		fmt.Fprintf(&buf, "%010d 00000 n \n", offset)
+	}
+	fmt.Fprintf(&buf, "trailer\n<< /Size %d /Root 1 0 R >>\nstartxref\n%d\n%%%%EOF\n", len(offsets)+1, xref)
+
+	return buf.Bytes()
+}
+
+// recipeToPDF writes the recipe markdown with headings, bullets and numbered
+// steps, emphasis and link targets are dropped.
+func recipeToPDF(doc *pdfDocument, markdown string) {
+	for _, line := range strings.Split(markdown, "\n") {
+		trimmed := plainMarkdown(strings.TrimSpace(line))
+		switch {
+		case trimmed == "":
+			doc.space(4)
+		case strings.HasPrefix(trimmed, "## "):
+			doc.space(6)
+			doc.text(strings.TrimLeft(trimmed, "# "), 13, true, 0)
+		case strings.HasPrefix(trimmed, "#"):
+			doc.text(strings.TrimLeft(trimmed, "# "), 18, true, 0)
+		case strings.HasPrefix(trimmed, "- ") || strings.HasPrefix(trimmed, "* "):
+			doc.text("• "+strings.TrimSpace(trimmed[2:]), 11, false, 10)
+		case orderedItemPattern.MatchString(trimmed):
+			doc.text(trimmed, 11, false, 10)
+		default:
+			doc.text(trimmed, 11, false, 0)
+		}
+	}
+}
+
+func plainMarkdown(text string) string {
+	text = markdownLinkPattern.ReplaceAllString(text, "$1")
+	text = markdownBoldPattern.ReplaceAllString(text, "$1")
+	return markdownItalicPattern.ReplaceAllString(text, "$1$2")
+}
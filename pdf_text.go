@@ -0,0 +1,172 @@
+package main
+
+import (
+	"bytes"
+	"compress/zlib"
+	"errors"
+	"io"
+	"regexp"
+	"strings"
+)
+
+var pdfStreamPattern = regexp.MustCompile(`(?s)<<(.*?)>>\s*stream\r?\n`)
+
+// maxPDFInflatedBytes is how much all streams of a document may inflate to.
+// Flate compresses runs of zeros about a thousandfold, without a cap a small
+// upload would fill the memory.
+const maxPDFInflatedBytes = 16 << 20
+
+// pdfText pulls the text out of the content streams of a PDF. It only
+// understands plain and Flate compressed streams with literal strings, which
+// covers recipes exported from word processors and browsers. Scanned PDFs
+// yield no text.
+func pdfText(data []byte) string {
+	var text strings.Builder
+	inflateBudget := maxPDFInflatedBytes
+	for _, match := range pdfStreamPattern.FindAllSubmatchIndex(data, -1) {
+		dict := data[match[2]:match[3]]
+		start := match[1]
+		end := bytes.Index(data[start:], []byte("endstream"))
+		if end < 0 {
+			break
+		}
+		stream := data[start : start+end]
+
+		if bytes.Contains(dict, []byte("/FlateDecode")) {
+			reader, err := zlib.NewReader(bytes.NewReader(stream))
+			if err != nil {
+				continue
+			}
+			inflated, err := io.ReadAll(io.LimitReader(reader, int64(inflateBudget)+1))
+			if len(inflated) > inflateBudget {
+				// the text read so far is all there is
+				break
+			}
+			inflateBudget -= len(inflated)
+			// Truncated streams still inflate up to the damage.
+			if err != nil && !errors.Is(err, io.ErrUnexpectedEOF) {
+				continue
+			}
+			stream = inflated
+		} else if bytes.Contains(dict, []byte("/Filter")) {
+			continue
+		}
+
+		text.WriteString(pdfContentText(stream))
+	}
+	return strings.TrimSpace(text.String())
+}
+
+// pdfContentText collects the strings shown by Tj, TJ, ' and " and starts a
+// new line on text positioning operators.
+func pdfContentText(content []byte) string {
+	var text strings.Builder
+	var pending []string
+	for i := 0; i < len(content); i++ {
+		switch c := content[i]; {
+		case c == '(':
+			value, next := pdfLiteralString(content, i)
+			pending = append(pending, value)
+			i = next
+		case c == '%':
+			for i < len(content) && content[i] != '\n' && content[i] != '\r' {
+				i++
+			}
+		case isPDFLetter(c) || c == '\'' || c == '"':
+			start := i
+			for i+1 < len(content) && (isPDFLetter(content[i+1]) || content[i+1] == '*') {
+				i++
+			}
+			switch string(content[start : i+1]) {
+			case "Tj", "TJ":
+				text.WriteString(strings.Join(pending, ""))
+			case "'", "\"":
+				text.WriteString("\n" + strings.Join(pending, ""))
+			case "Td", "TD", "T*", "ET":
+				text.WriteString("\n")
+			}
+			pending = pending[:0]
+		}
+	}
+
+	lines := strings.Split(text.String(), "\n")
+	kept := lines[:0]
+	for _, line := range lines {
+		if line = strings.TrimSpace(line); line != "" {
+			kept = append(kept, line)
+		}
+	}
+	if len(kept) == 0 {
+		return ""
+	}
+	return strings.Join(kept, "\n") + "\n"
+}
+
+func isPDFLetter(c byte) bool {
+	return c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z'
+}
+
+// pdfLiteralString decodes the string starting at content[start] == '(' and
+// returns it with the index of the closing parenthesis.
+func pdfLiteralString(content []byte, start int) (string, int) {
+	var value []byte
+	depth := 0
+	for i := start; i < len(content); i++ {
+		c := content[i]
+		switch {
+		case c == '\\' && i+1 < len(content):
+			i++
+			switch escaped := content[i]; escaped {
+			case 'n':
+				value = append(value, '\n')
+			case 'r', 't', 'b', 'f':
+				value = append(value, ' ')
+			case '\r', '\n':
+			default:
+				if escaped >= '0' && escaped <= '7' {
+					code := 0
+					for j := 0; j < 3 && i < len(content) && content[i] >= '0' && content[i] <= '7'; j++ {
+						code = code*8 + int(content[i]-'0')
+						i++
+					}
+					i--
+					value = append(value, byte(code))
+				} else {
+					value = append(value, escaped)
+				}
+			}
+		case c == '(':
+			depth++
+			if depth > 1 {
+				value = append(value, c)
+			}
+		case c == ')':
+			depth--
+			if depth == 0 {
+				return pdfDecodeString(value), i
+			}
+			value = append(value, c)
+		default:
+			value = append(value, c)
+		}
+	}
+	return pdfDecodeString(value), len(content)
+}
+
+// pdfDecodeString treats strings without a UTF-16 byte order mark as
+// Latin-1, close enough to WinAnsi for German recipes.
+func pdfDecodeString(value []byte) string {
+	if len(value) >= 2 && value[0] == 0xFE && value[1] == 0xFF {
+		runes := make([]rune, 0, len(value)/2)
+		for i := 2; i+1 < len(value); i += 2 {
+			runes = append(runes, rune(value[i])<<8|rune(value[i+1]))
+		}
+		return string(runes)
+	}
+
+	runes := make([]rune, len(value))
+	for i, b := range value {
+		runes[i] = rune(b)
+	}
+	return string(runes)
+}
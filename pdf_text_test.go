@@ -0,0 +1,38 @@
+package main
+
+import (
+	"bytes"
+	"compress/zlib"
+	"strings"
+	"testing"
+)
+
+func flatePDF(t *testing.T, content []byte) []byte {
+	t.Helper()
+	var stream bytes.Buffer
+	writer := zlib.NewWriter(&stream)
+	if _, err := writer.Write(content); err != nil {
+		t.Fatal(err)
+	}
+	writer.Close()
+	return []byte("%PDF-1.4\n1 0 obj\n<< /Filter /FlateDecode /Length 0 >>\nstream\n" + stream.String() + "\nendstream\nendobj\n")
+}
+
+func TestPDFText(t *testing.T) {
+	text := pdfText(flatePDF(t, []byte("BT (Pfannkuchen) Tj T* (200 g Mehl) Tj ET")))
+	if text != "Pfannkuchen\n200 g Mehl" {
+		t.Errorf("got %q", text)
+	}
+}
+
+func TestPDFTextStopsAtInflateLimit(t *testing.T) {
+	// a few kilobytes that inflate past the limit
+	bomb := append([]byte("BT (Pfannkuchen) Tj ET "), bytes.Repeat([]byte(" "), maxPDFInflatedBytes+1)...)
+	data := flatePDF(t, bomb)
+	if len(data) > maxPDFInflatedBytes/100 {
+		t.Fatalf("test PDF is %d bytes, not a bomb", len(data))
+	}
+	if text := pdfText(data); strings.Contains(text, "Pfannkuchen") {
+		t.Errorf("got %q from a stream over the limit", text)
+	}
+}
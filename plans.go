@@ -0,0 +1,289 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"log"
+	"net/http"
+	"strconv"
+
+	"github.com/jackc/pgx/v5"
+)
+
+const (
+	planFree  = "free"
+	planPro   = "pro"
+	planAdmin = "admin"
+	// planLegacy is the plan of users registered before there were plans,
+	// without limits and with every feature they had.
+	planLegacy = "legacy"
+)
+
+const (
+	featureImageImport = "image-import"
+	featureVoiceImport = "voice-import"
+	// featurePremiumModel allows the premium model tier, see modelTiers.
+	featurePremiumModel = "premium-model"
+)
+
+// PlanLimits of 0 mean unlimited. MaxStorageBytes isn't enforced, users
+// near it are told so, see StorageUsage.
+type PlanLimits struct {
+	MaxRecipes        int             `json:"maxRecipes"`
+	GenerationsPerDay int             `json:"generationsPerDay"`
+	MaxStorageBytes   int64           `json:"maxStorageBytes"`
+	Features          map[string]bool `json:"features"`
+}
+
+var planLimits = map[string]PlanLimits{
+	planFree: {
+		MaxRecipes:        50,
+		GenerationsPerDay: 10,
+		MaxStorageBytes:   250 << 20,
+		Features:          map[string]bool{featureImageImport: true},
+	},
+	planPro: {
+		MaxRecipes:        1000,
+		GenerationsPerDay: 100,
+		MaxStorageBytes:   5 << 30,
+		Features:          map[string]bool{featureImageImport: true, featureVoiceImport: true, featurePremiumModel: true},
+	},
+	planAdmin: {
+		Features: map[string]bool{featureImageImport: true, featureVoiceImport: true, featurePremiumModel: true},
+	},
+	planLegacy: {
+		Features: map[string]bool{featureImageImport: true, featureVoiceImport: true},
+	},
+}
+
+func GetUserPlan(ctx context.Context, userID int) (string, PlanLimits, error) {
+	var plan string
+	err := pool.QueryRow(ctx, queryUserPlan, userID).Scan(&plan)
+	if err != nil {
+		return "", PlanLimits{}, err
+	}
+
+	limits, ok := planLimits[plan]
+	if !ok {
+		log.Printf("Unknown plan %q for user %d, applying free plan", plan, userID)
+		return planFree, planLimits[planFree], nil
+	}
+	return plan, limits, nil
+}
+
+func RequireFeature(feature string, next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		userCtx, ok := r.Context().Value("user").(UserContext)
+		if !ok {
+			http.Error(w, "Unauthorized: user context missing", http.StatusUnauthorized)
+			return
+		}
+
+		_, limits, err := GetUserPlan(r.Context(), userCtx.UserID)
+		if err != nil {
+			log.Printf("Error getting plan for user %d: %v\n", userCtx.UserID, err)
+			http.Error(w, "Error getting plan", http.StatusInternalServerError)
+			return
+		}
+
+		if !limits.Features[feature] {
+			http.Error(w, "Feature not available on your plan", http.StatusForbidden)
+			return
+		}
+		next(w, r)
+	}
+}
+
+var (
+	errGenerationLimit = errors.New("daily generation limit reached")
+	errRecipeLimit     = errors.New("recipe limit of your plan reached")
+)
+
+// GenerationLimitMiddleware counts the generation before it runs, failed
+// generations still cost tokens.
+func GenerationLimitMiddleware(next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		userCtx, ok := r.Context().Value("user").(UserContext)
+		if !ok {
+			http.Error(w, "Unauthorized: user context missing", http.StatusUnauthorized)
+			return
+		}
+
+		if err := countGeneration(r.Context(), userCtx.UserID); err != nil {
+			if errors.Is(err, errGenerationLimit) {
+				http.Error(w, "Daily generation limit reached", http.StatusTooManyRequests)
+				return
+			}
+			log.Printf("Error counting generation for user %d: %v\n", userCtx.UserID, err)
+			http.Error(w, "Error counting generation", http.StatusInternalServerError)
+			return
+		}
+		next(traceGenerations(w, r, userCtx.UserID))
+	}
+}
+
+// countGeneration records a generation and returns errGenerationLimit when
+// it is over the plan's daily limit.
+func countGeneration(ctx context.Context, userID int) error {
+	_, limits, err := GetUserPlan(ctx, userID)
+	if err != nil {
+		return err
+	}
+
+	// Generations on the user's own key do not cost us anything.
+	ownKey, err := usesOwnOpenAIKey(ctx, userID)
+	if err != nil || ownKey {
+		return err
+	}
+
+	var count int
+	err = pool.QueryRow(ctx, `
+		INSERT INTO generation_usage (user_id, day, count) VALUES ($1, current_date, 1)
+		ON CONFLICT (user_id, day) DO UPDATE SET count = generation_usage.count + 1
+		RETURNING count`, userID).Scan(&count)
+	if err != nil {
+		return err
+	}
+
+	if limits.GenerationsPerDay != 0 && count > limits.GenerationsPerDay {
+		return errGenerationLimit
+	}
+	return nil
+}
+
+func RecipeLimitMiddleware(next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		userCtx, ok := r.Context().Value("user").(UserContext)
+		if !ok {
+			http.Error(w, "Unauthorized: user context missing", http.StatusUnauthorized)
+			return
+		}
+
+		if err := checkRecipeLimit(r.Context(), userCtx.UserID); err != nil {
+			if errors.Is(err, errRecipeLimit) {
+				http.Error(w, "Recipe limit of your plan reached", http.StatusForbidden)
+				return
+			}
+			log.Printf("Error counting recipes for user %d: %v\n", userCtx.UserID, err)
+			http.Error(w, "Error counting recipes", http.StatusInternalServerError)
+			return
+		}
+		next(w, r)
+	}
+}
+
+// checkRecipeLimit returns errRecipeLimit when the user can't add another
+// recipe on their plan.
+func checkRecipeLimit(ctx context.Context, userID int) error {
+	_, limits, err := GetUserPlan(ctx, userID)
+	if err != nil || limits.MaxRecipes == 0 {
+		return err
+	}
+
+	var count int
+	err = pool.QueryRow(ctx, "SELECT count(*) FROM recipes WHERE user_id = $1", userID).Scan(&count)
+	if err != nil {
+		return err
+	}
+
+	if count >= limits.MaxRecipes {
+		return errRecipeLimit
+	}
+	return nil
+}
+
+func RequireAdmin(next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		userCtx, ok := r.Context().Value("user").(UserContext)
+		if !ok {
+			http.Error(w, "Unauthorized: user context missing", http.StatusUnauthorized)
+			return
+		}
+
+		plan, _, err := GetUserPlan(r.Context(), userCtx.UserID)
+		if err != nil {
+			log.Printf("Error getting plan for user %d: %v\n", userCtx.UserID, err)
+			http.Error(w, "Error getting plan", http.StatusInternalServerError)
+			return
+		}
+
+		if plan != planAdmin {
+			http.Error(w, "Admin access required", http.StatusForbidden)
+			return
+		}
+		next(w, r)
+	}
+}
+
+func HandleGetPlan(w http.ResponseWriter, r *http.Request) {
+	userCtx, ok := r.Context().Value("user").(UserContext)
+	if !ok {
+		http.Error(w, "Unauthorized: user context missing", http.StatusUnauthorized)
+		return
+	}
+
+	plan, limits, err := GetUserPlan(r.Context(), userCtx.UserID)
+	if err != nil {
+		log.Printf("Error getting plan for user %d: %v\n", userCtx.UserID, err)
+		http.Error(w, "Error getting plan", http.StatusInternalServerError)
+		return
+	}
+
+	var generationsToday int
+	err = pool.QueryRow(r.Context(), "SELECT coalesce(max(count), 0) FROM generation_usage WHERE user_id = $1 AND day = current_date",
+		userCtx.UserID).Scan(&generationsToday)
+	if err != nil {
+		log.Printf("Error getting generation usage for user %d: %v\n", userCtx.UserID, err)
+		http.Error(w, "Error getting plan", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+
+	err = json.NewEncoder(w).Encode(map[string]interface{}{
+		"plan":             plan,
+		"limits":           limits,
+		"generationsToday": generationsToday,
+	})
+	if err != nil {
+		http.Error(w, "Error encoding JSON response", http.StatusInternalServerError)
+	}
+}
+
+func HandleSetUserPlan(w http.ResponseWriter, r *http.Request) {
+	userID, err := strconv.Atoi(r.PathValue("id"))
+	if err != nil {
+		http.Error(w, "Invalid user ID", http.StatusBadRequest)
+		return
+	}
+
+	var req struct {
+		Plan string `json:"plan"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "Invalid JSON payload", http.StatusBadRequest)
+		return
+	}
+
+	if _, ok := planLimits[req.Plan]; !ok {
+		http.Error(w, "Unknown plan", http.StatusBadRequest)
+		return
+	}
+
+	var id int
+	err = pool.QueryRow(r.Context(), "UPDATE users SET plan = $1 WHERE id = $2 RETURNING id", req.Plan, userID).Scan(&id)
+	if err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			http.Error(w, "User not found", http.StatusNotFound)
+			return
+		}
+		log.Printf("Error setting plan for user %d: %v\n", userID, err)
+		http.Error(w, "Error setting plan", http.StatusInternalServerError)
+		return
+	}
+
+	log.Printf("set plan of user %d to %s", userID, req.Plan)
+	w.WriteHeader(http.StatusOK)
+}
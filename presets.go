@@ -0,0 +1,82 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"log"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/jackc/pgx/v5"
+)
+
+const maxPresetPortions = 20
+
+// recipePresets are named change prompts for stored recipes. They run
+// through the same pending revision as a free-form change, so the user
+// still accepts or rejects the result.
+var recipePresets = map[string]string{
+	"kids": "Adapt the recipe for children: make the seasoning milder and leave out hot spices, alcohol and whole nuts, " +
+		"cut pieces to child-friendly sizes and use smaller portions. Add a short note on which steps children can help with.",
+	"lunchbox": "Adapt the recipe for meal-prep lunchboxes: portion it into single lunchboxes, prefer components that keep well, " +
+		"pack sauces and crisp parts separately and add a section on storage (how many days in the fridge, whether it can be frozen) " +
+		"and on reheating or eating it cold.",
+}
+
+// HandleApplyRecipePreset creates a pending revision from a preset,
+// ?portions= sets how many portions the result is for.
+func HandleApplyRecipePreset(w http.ResponseWriter, r *http.Request) {
+	userCtx, ok := r.Context().Value("user").(UserContext)
+	if !ok {
+		http.Error(w, "Unauthorized: user context missing", http.StatusUnauthorized)
+		return
+	}
+
+	recipeID, err := strconv.Atoi(r.PathValue("id"))
+	if err != nil {
+		http.Error(w, "Invalid recipe ID", http.StatusBadRequest)
+		return
+	}
+
+	preset := r.PathValue("preset")
+	changePrompt, found := recipePresets[preset]
+	if !found {
+		names := make([]string, 0, len(recipePresets))
+		for name := range recipePresets {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+		http.Error(w, "preset must be one of "+strings.Join(names, ", "), http.StatusBadRequest)
+		return
+	}
+
+	if value := r.URL.Query().Get("portions"); value != "" {
+		portions, err := strconv.Atoi(value)
+		if err != nil || portions < 1 || portions > maxPresetPortions {
+			http.Error(w, "portions must be between 1 and "+strconv.Itoa(maxPresetPortions), http.StatusBadRequest)
+			return
+		}
+		changePrompt += " Scale the amounts to " + strconv.Itoa(portions) + " portions."
+	}
+
+	revision, err := createPendingRevision(r.Context(), userCtx.UserID, recipeID, changePrompt)
+	if err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			http.Error(w, "Recipe not found", http.StatusNotFound)
+			return
+		}
+		log.Printf("Error creating revision: %v\n", err)
+		http.Error(w, "Error generating recipe", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+
+	err = json.NewEncoder(w).Encode(revision)
+	if err != nil {
+		http.Error(w, "Error encoding JSON response", http.StatusInternalServerError)
+	}
+}
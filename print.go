@@ -0,0 +1,238 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"html"
+	"log"
+	"net/http"
+	"net/url"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"github.com/jackc/pgx/v5"
+)
+
+// printStylesheet is published once per site as print/print.css.
+const printStylesheet = `@page { size: A4; margin: 18mm 16mm; }
+body { font-family: Georgia, "Times New Roman", serif; font-size: 11pt; line-height: 1.45; color: #000; max-width: 44rem; margin: 2rem auto; padding: 0 1rem; }
+h1 { font-size: 20pt; margin: 0 0 .4em; }
+h2 { font-size: 13pt; margin: 1.2em 0 .4em; border-bottom: 1px solid #999; }
+li { margin: .15em 0; break-inside: avoid; }
+a { color: #000; }
+footer { display: flex; align-items: center; gap: 1rem; margin-top: 2rem; padding-top: .6rem; border-top: 1px solid #999; font-size: 9pt; break-inside: avoid; }
+footer svg { width: 28mm; height: 28mm; flex: none; }
+.print-button { float: right; font: inherit; padding: .3em .9em; cursor: pointer; }
+@media print {
+  body { margin: 0; max-width: none; padding: 0; }
+  .print-button { display: none; }
+}
+`
+
+var (
+	markdownLinkPattern   = regexp.MustCompile(`\[([^\]]+)\]\(([^)\s]+)\)`)
+	markdownBoldPattern   = regexp.MustCompile(`\*\*([^*]+)\*\*`)
+	markdownItalicPattern = regexp.MustCompile(`(^|[^*])\*([^*\s][^*]*)\*`)
+	orderedItemPattern    = regexp.MustCompile(`^\d+[.)]\s+`)
+)
+
+const (
+	defaultQRScale = 8
+	maxQRScale     = 20
+)
+
+// HandleGetRecipeQR returns a PNG QR code of the published recipe's URL,
+// ?scale= sets the pixels per module.
+func HandleGetRecipeQR(w http.ResponseWriter, r *http.Request) {
+	userCtx, ok := r.Context().Value("user").(UserContext)
+	if !ok {
+		http.Error(w, "Unauthorized: user context missing", http.StatusUnauthorized)
+		return
+	}
+
+	recipeID, err := strconv.Atoi(r.PathValue("id"))
+	if err != nil {
+		http.Error(w, "Invalid recipe ID", http.StatusBadRequest)
+		return
+	}
+
+	scale := defaultQRScale
+	if value := r.URL.Query().Get("scale"); value != "" {
+		scale, err = strconv.Atoi(value)
+		if err != nil || scale < 1 || scale > maxQRScale {
+			http.Error(w, "scale must be between 1 and 20", http.StatusBadRequest)
+			return
+		}
+	}
+
+	var slug string
+	var archived, draft bool
+	err = pool.QueryRow(r.Context(), "SELECT slug, archived, draft FROM recipes WHERE id = $1 AND user_id = $2", recipeID, userCtx.UserID).
+		Scan(&slug, &archived, &draft)
+	if err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			http.Error(w, "Recipe not found", http.StatusNotFound)
+			return
+		}
+		log.Printf("Error getting recipe: %v\n", err)
+		http.Error(w, "Error getting recipe", http.StatusInternalServerError)
+		return
+	}
+	if archived {
+		http.Error(w, "Archived recipes are not published", http.StatusConflict)
+		return
+	}
+	if draft {
+		http.Error(w, "Drafts are not published", http.StatusConflict)
+		return
+	}
+
+	qr, err := encodeQR(recipeURL(userCtx.Subdomain, slug))
+	if err != nil {
+		log.Printf("Error encoding QR code for recipe %d: %v\n", recipeID, err)
+		http.Error(w, "Recipe URL too long for a QR code", http.StatusInternalServerError)
+		return
+	}
+	image, err := qr.PNG(scale)
+	if err != nil {
+		log.Printf("Error rendering QR code: %v\n", err)
+		http.Error(w, "Error rendering QR code", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "image/png")
+	w.Header().Set("Content-Disposition", `inline; filename="`+slug+`-qr.png"`)
+	w.WriteHeader(http.StatusOK)
+	_, _ = w.Write(image)
+}
+
+// printableRecipePath is where the print page of a recipe is published.
+func printableRecipePath(slug string) string {
+	return "print/" + slug + ".html"
+}
+
+// renderPrintableRecipe is a standalone page of the recipe without the site
+// around it. The QR code leads back to the online version.
+func renderPrintableRecipe(ctx context.Context, subdomain string, userID int, slug string) (string, error) {
+	var title, content string
+	var timing RecipeTiming
+	err := pool.QueryRow(ctx, `
+		SELECT title, content, prep_minutes, cook_minutes, total_minutes, difficulty
+		FROM recipes WHERE user_id = $1 AND slug = $2 AND NOT archived AND NOT draft`,
+		userID, slug).Scan(&title, &content, &timing.PrepTime, &timing.CookTime, &timing.TotalTime, &timing.Difficulty)
+	if err != nil {
+		return "", err
+	}
+
+	online := recipeURL(subdomain, slug)
+	// a URL too long for the code is still printed as text
+	var code string
+	if qr, err := encodeQR(online); err == nil {
+		code = qr.SVG() + "\n"
+	}
+
+	var page strings.Builder
+	page.WriteString("<!DOCTYPE html>\n<html lang=\"de\">\n<head>\n<meta charset=\"utf-8\">\n")
+	page.WriteString("<meta name=\"viewport\" content=\"width=device-width, initial-scale=1\">\n")
+	fmt.Fprintf(&page, "<title>%s</title>\n", html.EscapeString(title))
+	page.WriteString("<link rel=\"stylesheet\" href=\"print.css\">\n</head>\n<body>\n")
+	page.WriteString("<button class=\"print-button\" onclick=\"window.print()\">Drucken</button>\n<main>\n")
+	page.WriteString(markdownToHTML(publishedRecipeMarkdown(content, timing)))
+	page.WriteString("</main>\n<footer>\n" + code)
+	fmt.Fprintf(&page, "<p>Online ansehen:<br><a href=\"%s\">%s</a></p>\n", html.EscapeString(online), html.EscapeString(online))
+	page.WriteString("</footer>\n</body>\n</html>\n")
+
+	return page.String(), nil
+}
+
+// markdownToHTML covers what generated recipes use: headings, lists,
+// paragraphs, emphasis and links. The site itself renders markdown in the
+// browser, print pages have to work without scripts.
+func markdownToHTML(markdown string) string {
+	var out strings.Builder
+	var paragraph []string
+	list := ""
+
+	flushParagraph := func() {
+		if len(paragraph) > 0 {
+			out.WriteString("<p>" + inlineMarkdown(strings.Join(paragraph, " ")) + "</p>\n")
+			paragraph = nil
+		}
+	}
+	closeList := func() {
+		if list != "" {
+			out.WriteString("</" + list + ">\n")
+			list = ""
+		}
+	}
+	openList := func(kind string) {
+		if list != kind {
+			closeList()
+			out.WriteString("<" + kind + ">\n")
+			list = kind
+		}
+	}
+
+	for _, line := range strings.Split(markdown, "\n") {
+		trimmed := strings.TrimSpace(line)
+		switch {
+		case trimmed == "":
+			flushParagraph()
+			closeList()
+		case strings.HasPrefix(trimmed, "#"):
+			flushParagraph()
+			closeList()
+			level := len(trimmed) - len(strings.TrimLeft(trimmed, "#"))
+			level = min(level, 6)
+			text := strings.TrimSpace(strings.TrimLeft(trimmed, "#"))
+			fmt.Fprintf(&out, "<h%d>%s</h%d>\n", level, inlineMarkdown(text), level)
+		case strings.HasPrefix(trimmed, "- ") || strings.HasPrefix(trimmed, "* "):
+			flushParagraph()
+			openList("ul")
+			out.WriteString("<li>" + inlineMarkdown(strings.TrimSpace(trimmed[2:])) + "</li>\n")
+		case orderedItemPattern.MatchString(trimmed):
+			flushParagraph()
+			openList("ol")
+			out.WriteString("<li>" + inlineMarkdown(orderedItemPattern.ReplaceAllString(trimmed, "")) + "</li>\n")
+		default:
+			closeList()
+			paragraph = append(paragraph, trimmed)
+		}
+	}
+	flushParagraph()
+	closeList()
+
+	return out.String()
+}
+
+// safeLinkTarget allows http, https and relative links, anything else such as
+// javascript: or data: is printed as plain text.
+func safeLinkTarget(target string) bool {
+	link, err := url.Parse(strings.TrimSpace(target))
+	if err != nil {
+		return false
+	}
+	switch strings.ToLower(link.Scheme) {
+	case "", "http", "https":
+		return true
+	default:
+		return false
+	}
+}
+
+func inlineMarkdown(text string) string {
+	text = html.EscapeString(text)
+	text = markdownLinkPattern.ReplaceAllStringFunc(text, func(link string) string {
+		parts := markdownLinkPattern.FindStringSubmatch(link)
+		target := html.UnescapeString(parts[2])
+		if !safeLinkTarget(target) {
+			return parts[1]
+		}
+		return `<a href="` + html.EscapeString(target) + `">` + parts[1] + `</a>`
+	})
+	text = markdownBoldPattern.ReplaceAllString(text, "<strong>$1</strong>")
+	text = markdownItalicPattern.ReplaceAllString(text, "$1<em>$2</em>")
+	return text
+}
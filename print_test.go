@@ -0,0 +1,29 @@
+package main
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestInlineMarkdownLinks(t *testing.T) {
+	tests := []struct {
+		target string
+		linked bool
+	}{
+		{"https://example.com/rezept", true},
+		{"http://example.com", true},
+		{"?recipe=pfannkuchen", true},
+		{"../recipes/pfannkuchen.md", true},
+		{"javascript:alert(1)", false},
+		{" JavaScript:alert(1)", false},
+		{"data:text/html;base64,PHNjcmlwdD4=", false},
+		{"vbscript:msgbox(1)", false},
+		{"java\tscript:alert(1)", false},
+	}
+	for _, tt := range tests {
+		got := inlineMarkdown("[Link](" + tt.target + ")")
+		if linked := strings.Contains(got, "<a href="); linked != tt.linked {
+			t.Errorf("link to %q: got %q", tt.target, got)
+		}
+	}
+}
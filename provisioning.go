@@ -0,0 +1,211 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"log"
+	"net/http"
+	"sync"
+)
+
+const (
+	provisioningPending    = "pending"
+	provisioningInProgress = "provisioning"
+	provisioningReady      = "ready"
+	provisioningFailed     = "failed"
+)
+
+type provisioningUser struct {
+	UserID      int
+	OauthID     string
+	Subdomain   string
+	StorageMode string
+}
+
+type provisioningStep struct {
+	Name string
+	Run  func(user provisioningUser) error
+}
+
+// provisioningSteps must stay idempotent: a run resumes at the first step that
+// has not completed, which may have been partially applied before a crash.
+// Users in shared storage mode only get their prefix populated, embedded mode
+// users have nothing to provision besides their index.
+var provisioningSteps = []provisioningStep{
+	{"storage_account", func(user provisioningUser) error {
+		if user.StorageMode != storageModeDedicated {
+			return nil
+		}
+		return ensureStorageAccount(user.Subdomain)
+	}},
+	{"role_assignment", func(user provisioningUser) error {
+		if user.StorageMode != storageModeDedicated {
+			return nil
+		}
+		return assignBlobDataContributorRole(user.Subdomain)
+	}},
+	{"account_tags", func(user provisioningUser) error {
+		if user.StorageMode != storageModeDedicated {
+			return nil
+		}
+		return tagStorageAccount(user.Subdomain, user.OauthID)
+	}},
+	{"static_website", func(user provisioningUser) error {
+		if user.StorageMode == storageModeEmbedded {
+			return nil
+		}
+		return enableStaticWebsite(storageLocationFor(user.Subdomain, user.StorageMode).Account)
+	}},
+	{"default_files", func(user provisioningUser) error {
+		if user.StorageMode == storageModeEmbedded {
+			return nil
+		}
+		return copyDefaultWebsiteFiles(user.Subdomain)
+	}},
+	{"index", func(user provisioningUser) error {
+		return templateRecipesBlob(user.Subdomain, user.UserID)
+	}},
+	// the shared account gets its policy from the storage-lifecycle job
+	{"lifecycle_policy", func(user provisioningUser) error {
+		if user.StorageMode != storageModeDedicated {
+			return nil
+		}
+		return applyStorageLifecycle(user.Subdomain, storageModeDedicated)
+	}},
+}
+
+type ProvisioningStatus struct {
+	Status     string `json:"status"`
+	Step       string `json:"step,omitempty"`
+	Completed  int    `json:"completedSteps"`
+	TotalSteps int    `json:"totalSteps"`
+	Error      string `json:"error,omitempty"`
+}
+
+var runningProvisioning sync.Map
+
+// startProvisioning resumes provisioning for the user in the background unless
+// a run for that user is already active. The map covers this process, the
+// advisory lock other instances.
+func startProvisioning(user provisioningUser) {
+	if _, running := runningProvisioning.LoadOrStore(user.UserID, struct{}{}); running {
+		return
+	}
+
+	go func() {
+		defer runningProvisioning.Delete(user.UserID)
+
+		ctx := context.Background()
+		ran, err := tryWithUserLock(ctx, provisioningLockNamespace, user.UserID, func() error {
+			return provisionUser(ctx, user)
+		})
+		if err != nil {
+			log.Printf("Provisioning for user %d failed: %v\n", user.UserID, err)
+		} else if !ran {
+			log.Printf("Provisioning for user %d is running on another instance", user.UserID)
+		}
+	}()
+}
+
+func provisionUser(ctx context.Context, user provisioningUser) error {
+	var completed int
+	err := pool.QueryRow(ctx, "SELECT provisioning_step FROM users WHERE id = $1", user.UserID).Scan(&completed)
+	if err != nil {
+		return err
+	}
+
+	_, err = pool.Exec(ctx, "UPDATE users SET provisioning_status = $1, provisioning_error = '' WHERE id = $2",
+		provisioningInProgress, user.UserID)
+	if err != nil {
+		return err
+	}
+
+	// A fresh site has none of the blobs recorded for upload deduplication.
+	if completed == 0 {
+		_, err = pool.Exec(ctx, "DELETE FROM published_blobs WHERE user_id = $1", user.UserID)
+		if err != nil {
+			return err
+		}
+	}
+
+	for i := completed; i < len(provisioningSteps); i++ {
+		step := provisioningSteps[i]
+		log.Printf("Provisioning user %d: running step %s", user.UserID, step.Name)
+
+		if err := step.Run(user); err != nil {
+			_, dbErr := pool.Exec(ctx, "UPDATE users SET provisioning_status = $1, provisioning_error = $2 WHERE id = $3",
+				provisioningFailed, step.Name+": "+err.Error(), user.UserID)
+			if dbErr != nil {
+				log.Printf("Failed to record provisioning failure for user %d: %v\n", user.UserID, dbErr)
+			}
+			return err
+		}
+
+		_, err = pool.Exec(ctx, "UPDATE users SET provisioning_step = $1 WHERE id = $2", i+1, user.UserID)
+		if err != nil {
+			return err
+		}
+	}
+
+	_, err = pool.Exec(ctx, "UPDATE users SET provisioning_status = $1 WHERE id = $2", provisioningReady, user.UserID)
+	if err != nil {
+		return err
+	}
+
+	log.Printf("Provisioning for user %d completed", user.UserID)
+	return nil
+}
+
+func GetProvisioningStatus(ctx context.Context, userID int) (ProvisioningStatus, error) {
+	status := ProvisioningStatus{TotalSteps: len(provisioningSteps)}
+	err := pool.QueryRow(ctx, "SELECT provisioning_status, provisioning_step, provisioning_error FROM users WHERE id = $1", userID).
+		Scan(&status.Status, &status.Completed, &status.Error)
+	if err != nil {
+		return status, err
+	}
+
+	if status.Completed < len(provisioningSteps) && status.Status != provisioningReady {
+		status.Step = provisioningSteps[status.Completed].Name
+	}
+
+	return status, nil
+}
+
+func HandleLogin(w http.ResponseWriter, r *http.Request) {
+	userCtx, ok := r.Context().Value("user").(UserContext)
+	if !ok {
+		http.Error(w, "Unauthorized: user context missing", http.StatusUnauthorized)
+		return
+	}
+
+	status, err := GetProvisioningStatus(r.Context(), userCtx.UserID)
+	if err != nil {
+		log.Printf("Error getting provisioning status: %v\n", err)
+		http.Error(w, "Error getting provisioning status", http.StatusInternalServerError)
+		return
+	}
+
+	resp := map[string]interface{}{
+		"userID":       userCtx.UserID,
+		"subdomain":    userCtx.Subdomain,
+		"provisioning": status,
+	}
+	if status.Status == provisioningReady {
+		token, expiresAt, err := issueSessionToken(userCtx)
+		if err != nil {
+			// the client just keeps paying for the lookup
+			log.Printf("Error issuing session token for user %d: %v\n", userCtx.UserID, err)
+		} else {
+			resp["sessionToken"] = token
+			resp["sessionExpiresAt"] = expiresAt
+		}
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+
+	err = json.NewEncoder(w).Encode(resp)
+	if err != nil {
+		http.Error(w, "Error encoding JSON response", http.StatusInternalServerError)
+	}
+}
@@ -0,0 +1,74 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"log"
+	"net/http"
+	"net/url"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+)
+
+// PublicRecipe is a published recipe as the site shows it, Content is the
+// markdown of recipes/{slug}.md.
+type PublicRecipe struct {
+	Slug      string    `json:"slug"`
+	Content   string    `json:"content"`
+	UpdatedAt time.Time `json:"updatedAt"`
+}
+
+// HandleGetPublicRecipe lets the site's JavaScript load a recipe straight
+// from the database, so an edit shows before its page is uploaded again.
+// It needs no login and answers only what the site publishes: archived
+// recipes and drafts are not found, an old slug of a renamed recipe
+// redirects to its current one. Any origin may read it, the sites run on
+// their own domains.
+func HandleGetPublicRecipe(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Access-Control-Allow-Origin", "*")
+
+	var userID int
+	err := pool.QueryRow(r.Context(), "SELECT id FROM users WHERE subdomain = $1", r.PathValue("subdomain")).Scan(&userID)
+	if err != nil {
+		if !errors.Is(err, pgx.ErrNoRows) {
+			log.Printf("Error getting site %s: %v\n", r.PathValue("subdomain"), err)
+		}
+		http.NotFound(w, r)
+		return
+	}
+
+	slug := r.PathValue("slug")
+	content, updatedAt, err := getPublishedRecipe(r.Context(), userID, slug)
+	if err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			current, err := redirectedSlug(r.Context(), userID, slug)
+			if err != nil {
+				if !errors.Is(err, pgx.ErrNoRows) {
+					log.Printf("Error getting redirect of %s: %v\n", slug, err)
+				}
+				http.NotFound(w, r)
+				return
+			}
+			target := "/api/v1/public/" + url.PathEscape(r.PathValue("subdomain")) + "/recipes/" + url.PathEscape(current)
+			http.Redirect(w, r, target, http.StatusMovedPermanently)
+			return
+		}
+		log.Printf("Error getting public recipe %s: %v\n", slug, err)
+		http.Error(w, "Error getting recipe", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Cache-Control", pageCacheControl)
+	if notModified(w, r, content, updatedAt) {
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+
+	err = json.NewEncoder(w).Encode(PublicRecipe{Slug: slug, Content: content, UpdatedAt: updatedAt})
+	if err != nil {
+		http.Error(w, "Error encoding JSON response", http.StatusInternalServerError)
+	}
+}
@@ -0,0 +1,117 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"log"
+	"net/http"
+	"strconv"
+
+	"github.com/jackc/pgx/v5"
+)
+
+// Every recipe keeps the outcome of its last publish: published_at is the
+// last successful upload of its page, publish_error why the page or the
+// index could not be uploaded, empty when the site is up to date. A failed
+// index is noted on every recipe it lists, they are all missing from it or
+// out of date.
+const publishIndexErrorPrefix = "index: "
+
+// recordRecipePublish stores the outcome of uploading the recipe's pages.
+// The status is best effort, failing to store it doesn't fail the publish.
+func recordRecipePublish(ctx context.Context, userID int, slug string, publishErr error) {
+	var err error
+	if publishErr == nil {
+		_, err = pool.Exec(ctx, `
+			UPDATE recipes SET publish_error = '', published_at = CASE WHEN archived OR draft THEN published_at ELSE now() END
+			WHERE user_id = $1 AND slug = $2`, userID, slug)
+	} else {
+		_, err = pool.Exec(ctx, "UPDATE recipes SET publish_error = $3 WHERE user_id = $1 AND slug = $2", userID, slug, publishErr.Error())
+	}
+	if err != nil {
+		log.Printf("Error recording publish status of recipe %s: %v\n", slug, err)
+	}
+	invalidateRecipes(userID)
+}
+
+// recordIndexPublish stores the outcome of uploading recipes.md on the
+// recipes it lists. Page errors are left alone on success, only the
+// index's own are cleared.
+func recordIndexPublish(ctx context.Context, userID int, publishErr error) {
+	var err error
+	if publishErr == nil {
+		_, err = pool.Exec(ctx, "UPDATE recipes SET publish_error = '' WHERE user_id = $1 AND starts_with(publish_error, $2)",
+			userID, publishIndexErrorPrefix)
+	} else {
+		_, err = pool.Exec(ctx, `
+			UPDATE recipes SET publish_error = $2 WHERE user_id = $1 AND NOT archived AND NOT draft AND translation_of IS NULL
+			AND (publish_error = '' OR starts_with(publish_error, $3))`,
+			userID, publishIndexErrorPrefix+publishErr.Error(), publishIndexErrorPrefix)
+	}
+	if err != nil {
+		log.Printf("Error recording index publish status for user %d: %v\n", userID, err)
+	}
+	invalidateRecipes(userID)
+}
+
+// HandleRepublishRecipe uploads the recipe's pages and the index again, for
+// recipes whose publish_error says the site is behind. It answers the
+// recipe with its new status.
+func HandleRepublishRecipe(w http.ResponseWriter, r *http.Request) {
+	userCtx, ok := r.Context().Value("user").(UserContext)
+	if !ok {
+		http.Error(w, "Unauthorized: user context missing", http.StatusUnauthorized)
+		return
+	}
+
+	recipeID, err := strconv.Atoi(r.PathValue("id"))
+	if err != nil {
+		http.Error(w, "Invalid recipe ID", http.StatusBadRequest)
+		return
+	}
+
+	detail, err := getRecipeDetail(r.Context(), userCtx.UserID, recipeID)
+	if err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			http.Error(w, "Recipe not found", http.StatusNotFound)
+			return
+		}
+		log.Printf("Error getting recipe %d: %v\n", recipeID, err)
+		http.Error(w, "Error republishing recipe", http.StatusInternalServerError)
+		return
+	}
+	if detail.Draft {
+		http.Error(w, "Drafts are not published", http.StatusConflict)
+		return
+	}
+
+	if err := publishRecipeBlob(userCtx.Subdomain, userCtx.UserID, detail.Slug); err != nil {
+		log.Printf("Error republishing recipe %d: %v\n", recipeID, err)
+		http.Error(w, "Failed to republish recipe", http.StatusInternalServerError)
+		return
+	}
+	if err := templateRecipesBlob(userCtx.Subdomain, userCtx.UserID); err != nil {
+		log.Printf("Error updating recipe template: %v\n", err)
+		http.Error(w, "Failed to update recipe template", http.StatusInternalServerError)
+		return
+	}
+
+	detail, err = getRecipeDetail(r.Context(), userCtx.UserID, recipeID)
+	if err != nil {
+		log.Printf("Error getting recipe %d: %v\n", recipeID, err)
+		http.Error(w, "Error republishing recipe", http.StatusInternalServerError)
+		return
+	}
+	if !detail.Archived {
+		detail.URL = recipeURL(userCtx.Subdomain, detail.Slug)
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+
+	err = json.NewEncoder(w).Encode(detail)
+	if err != nil {
+		http.Error(w, "Error encoding JSON response", http.StatusInternalServerError)
+	}
+}
@@ -0,0 +1,467 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"image"
+	"image/color"
+	"image/png"
+	"strings"
+)
+
+// A minimal QR code encoder for the recipe links on printed pages: byte
+// mode, error correction level M, versions 1 to 10. That holds up to 213
+// bytes, plenty for a site URL with a slug.
+
+var errQRTooLong = errors.New("text too long for a QR code")
+
+// qrBlocks is the block structure of level M per version: error correction
+// codewords per block, then count and data codewords of both block groups.
+var qrBlocks = [...]struct {
+	ecPerBlock         int
+	blocks1, data1     int
+	blocks2, data2     int
+	alignmentPositions []int
+}{
+	1:  {10, 1, 16, 0, 0, nil},
+	2:  {16, 1, 28, 0, 0, []int{6, 18}},
+	3:  {26, 1, 44, 0, 0, []int{6, 22}},
+	4:  {18, 2, 32, 0, 0, []int{6, 26}},
+	5:  {24, 2, 43, 0, 0, []int{6, 30}},
+	6:  {16, 4, 27, 0, 0, []int{6, 34}},
+	7:  {18, 4, 31, 0, 0, []int{6, 22, 38}},
+	8:  {22, 2, 38, 2, 39, []int{6, 24, 42}},
+	9:  {22, 3, 36, 2, 37, []int{6, 26, 46}},
+	10: {26, 4, 43, 1, 44, []int{6, 28, 50}},
+}
+
+// qrCode is the module matrix, true is dark.
+type qrCode struct {
+	size       int
+	modules    [][]bool
+	isFunction [][]bool
+}
+
+func encodeQR(text string) (*qrCode, error) {
+	data := []byte(text)
+	version := 0
+	for v := 1; v < len(qrBlocks); v++ {
+		countBits := 8
+		if v >= 10 {
+			countBits = 16
+		}
+		capacity := qrBlocks[v].blocks1*qrBlocks[v].data1 + qrBlocks[v].blocks2*qrBlocks[v].data2
+		if 4+countBits+8*len(data) <= 8*capacity {
+			version = v
+			break
+		}
+	}
+	if version == 0 {
+		return nil, errQRTooLong
+	}
+
+	codewords := qrInterleave(version, qrDataCodewords(version, data))
+
+	size := 17 + 4*version
+	qr := &qrCode{size: size, modules: make([][]bool, size), isFunction: make([][]bool, size)}
+	for y := range qr.modules {
+		qr.modules[y] = make([]bool, size)
+		qr.isFunction[y] = make([]bool, size)
+	}
+	qr.drawFunctionPatterns(version)
+	qr.drawCodewords(codewords)
+
+	bestMask, bestPenalty := 0, -1
+	for mask := 0; mask < 8; mask++ {
+		qr.applyMask(mask)
+		qr.drawFormatBits(mask)
+		if penalty := qr.penalty(); bestPenalty < 0 || penalty < bestPenalty {
+			bestMask, bestPenalty = mask, penalty
+		}
+		qr.applyMask(mask) // masks are their own inverse
+	}
+	qr.applyMask(bestMask)
+	qr.drawFormatBits(bestMask)
+
+	return qr, nil
+}
+
+// qrDataCodewords packs the text in byte mode with terminator and padding.
+func qrDataCodewords(version int, data []byte) []byte {
+	blocks := qrBlocks[version]
+	capacity := blocks.blocks1*blocks.data1 + blocks.blocks2*blocks.data2
+
+	var bits []bool
+	appendBits := func(value int, length int) {
+		for i := length - 1; i >= 0; i-- {
+			bits = append(bits, (value>>i)&1 == 1)
+		}
+	}
+	appendBits(0b0100, 4)
+	if version >= 10 {
+		appendBits(len(data), 16)
+	} else {
+		appendBits(len(data), 8)
+	}
+	for _, b := range data {
+		appendBits(int(b), 8)
+	}
+	appendBits(0, min(4, 8*capacity-len(bits)))
+	appendBits(0, (8-len(bits)%8)%8)
+
+	codewords := make([]byte, 0, capacity)
+	for i := 0; i < len(bits); i += 8 {
+		var b byte
+		for j := 0; j < 8; j++ {
+			if bits[i+j] {
+				b |= 1 << (7 - j)
+			}
+		}
+		codewords = append(codewords, b)
+	}
+	for pad := byte(0xEC); len(codewords) < capacity; pad ^= 0xEC ^ 0x11 {
+		codewords = append(codewords, pad)
+	}
+	return codewords
+}
+
+// qrInterleave splits the data into blocks, adds the error correction of
+// each and interleaves them in the order they are placed.
+func qrInterleave(version int, data []byte) []byte {
+	layout := qrBlocks[version]
+	divisor := reedSolomonDivisor(layout.ecPerBlock)
+
+	var dataBlocks, ecBlocks [][]byte
+	offset := 0
+	for i := 0; i < layout.blocks1+layout.blocks2; i++ {
+		length := layout.data1
+		if i >= layout.blocks1 {
+			length = layout.data2
+		}
+		block := data[offset : offset+length]
+		offset += length
+		dataBlocks = append(dataBlocks, block)
+		ecBlocks = append(ecBlocks, reedSolomonRemainder(block, divisor))
+	}
+
+	var result []byte
+	for i := 0; i < max(layout.data1, layout.data2); i++ {
+		for _, block := range dataBlocks {
+			if i < len(block) {
+				result = append(result, block[i])
+			}
+		}
+	}
+	for i := 0; i < layout.ecPerBlock; i++ {
+		for _, block := range ecBlocks {
+			result = append(result, block[i])
+		}
+	}
+	return result
+}
+
+func gfMultiply(x byte, y byte) byte {
+	var z int
+	for i := 7; i >= 0; i-- {
+		z = (z << 1) ^ ((z >> 7) * 0x11D)
+		z ^= int((y>>i)&1) * int(x)
+	}
+	return byte(z)
+}
+
+func reedSolomonDivisor(degree int) []byte {
+	result := make([]byte, degree)
+	result[degree-1] = 1
+	root := byte(1)
+	for i := 0; i < degree; i++ {
+		for j := range result {
+			result[j] = gfMultiply(result[j], root)
+			if j+1 < len(result) {
+				result[j] ^= result[j+1]
+			}
+		}
+		root = gfMultiply(root, 0x02)
+	}
+	return result
+}
+
+func reedSolomonRemainder(data []byte, divisor []byte) []byte {
+	result := make([]byte, len(divisor))
+	for _, b := range data {
+		factor := b ^ result[0]
+		copy(result, result[1:])
+		result[len(result)-1] = 0
+		for i := range result {
+			result[i] ^= gfMultiply(divisor[i], factor)
+		}
+	}
+	return result
+}
+
+func (qr *qrCode) setFunction(x int, y int, dark bool) {
+	qr.modules[y][x] = dark
+	qr.isFunction[y][x] = true
+}
+
+func (qr *qrCode) drawFunctionPatterns(version int) {
+	for i := 0; i < qr.size; i++ {
+		qr.setFunction(6, i, i%2 == 0)
+		qr.setFunction(i, 6, i%2 == 0)
+	}
+
+	for _, center := range [][2]int{{3, 3}, {qr.size - 4, 3}, {3, qr.size - 4}} {
+		for dy := -4; dy <= 4; dy++ {
+			for dx := -4; dx <= 4; dx++ {
+				x, y := center[0]+dx, center[1]+dy
+				if x >= 0 && x < qr.size && y >= 0 && y < qr.size {
+					distance := max(abs(dx), abs(dy))
+					qr.setFunction(x, y, distance != 2 && distance != 4)
+				}
+			}
+		}
+	}
+
+	positions := qrBlocks[version].alignmentPositions
+	last := len(positions) - 1
+	for i, x := range positions {
+		for j, y := range positions {
+			// the corners are taken by the finder patterns
+			if (i == 0 && j == 0) || (i == 0 && j == last) || (i == last && j == 0) {
+				continue
+			}
+			for dy := -2; dy <= 2; dy++ {
+				for dx := -2; dx <= 2; dx++ {
+					qr.setFunction(x+dx, y+dy, max(abs(dx), abs(dy)) != 1)
+				}
+			}
+		}
+	}
+
+	// reserved here, the bits are drawn once the mask is chosen
+	qr.drawFormatBits(0)
+
+	if version >= 7 {
+		remainder := version
+		for i := 0; i < 12; i++ {
+			remainder = (remainder << 1) ^ ((remainder >> 11) * 0x1F25)
+		}
+		bits := version<<12 | remainder
+		for i := 0; i < 18; i++ {
+			dark := (bits>>i)&1 == 1
+			a, b := qr.size-11+i%3, i/3
+			qr.setFunction(a, b, dark)
+			qr.setFunction(b, a, dark)
+		}
+	}
+}
+
+func (qr *qrCode) drawFormatBits(mask int) {
+	// level M is 00
+	data := mask
+	remainder := data
+	for i := 0; i < 10; i++ {
+		remainder = (remainder << 1) ^ ((remainder >> 9) * 0x537)
+	}
+	bits := (data<<10 | remainder) ^ 0x5412
+	bit := func(i int) bool { return (bits>>i)&1 == 1 }
+
+	for i := 0; i <= 5; i++ {
+		qr.setFunction(8, i, bit(i))
+	}
+	qr.setFunction(8, 7, bit(6))
+	qr.setFunction(8, 8, bit(7))
+	qr.setFunction(7, 8, bit(8))
+	for i := 9; i < 15; i++ {
+		qr.setFunction(14-i, 8, bit(i))
+	}
+
+	for i := 0; i < 8; i++ {
+		qr.setFunction(qr.size-1-i, 8, bit(i))
+	}
+	for i := 8; i < 15; i++ {
+		qr.setFunction(8, qr.size-15+i, bit(i))
+	}
+	qr.setFunction(8, qr.size-8, true)
+}
+
+// drawCodewords fills the data area in the zigzag order of the standard,
+// modules left over are the remainder bits and stay light.
+func (qr *qrCode) drawCodewords(codewords []byte) {
+	i := 0
+	for right := qr.size - 1; right >= 1; right -= 2 {
+		if right == 6 {
+			right = 5
+		}
+		for vertical := 0; vertical < qr.size; vertical++ {
+			for j := 0; j < 2; j++ {
+				x := right - j
+				y := vertical
+				if (right+1)&2 == 0 {
+					y = qr.size - 1 - vertical
+				}
+				if !qr.isFunction[y][x] && i < len(codewords)*8 {
+					qr.modules[y][x] = (codewords[i>>3]>>(7-i&7))&1 == 1
+					i++
+				}
+			}
+		}
+	}
+}
+
+func (qr *qrCode) applyMask(mask int) {
+	for y := 0; y < qr.size; y++ {
+		for x := 0; x < qr.size; x++ {
+			var invert bool
+			switch mask {
+			case 0:
+				invert = (x+y)%2 == 0
+			case 1:
+				invert = y%2 == 0
+			case 2:
+				invert = x%3 == 0
+			case 3:
+				invert = (x+y)%3 == 0
+			case 4:
+				invert = (x/3+y/2)%2 == 0
+			case 5:
+				invert = x*y%2+x*y%3 == 0
+			case 6:
+				invert = (x*y%2+x*y%3)%2 == 0
+			case 7:
+				invert = ((x+y)%2+x*y%3)%2 == 0
+			}
+			if invert && !qr.isFunction[y][x] {
+				qr.modules[y][x] = !qr.modules[y][x]
+			}
+		}
+	}
+}
+
+// penalty scores a masked symbol with the four rules of the standard, the
+// mask with the lowest score is used.
+func (qr *qrCode) penalty() int {
+	penalty := 0
+	finderLike := []bool{true, false, true, true, true, false, true}
+
+	line := make([]bool, qr.size)
+	for _, vertical := range []bool{false, true} {
+		for a := 0; a < qr.size; a++ {
+			for b := 0; b < qr.size; b++ {
+				if vertical {
+					line[b] = qr.modules[b][a]
+				} else {
+					line[b] = qr.modules[a][b]
+				}
+			}
+
+			run := 1
+			for b := 1; b <= qr.size; b++ {
+				if b < qr.size && line[b] == line[b-1] {
+					run++
+					continue
+				}
+				if run >= 5 {
+					penalty += run - 2
+				}
+				run = 1
+			}
+
+			for b := 0; b+7 <= qr.size; b++ {
+				matches := true
+				for k, dark := range finderLike {
+					if line[b+k] != dark {
+						matches = false
+						break
+					}
+				}
+				if matches && (qrLightRun(line, b-4, b) || qrLightRun(line, b+7, b+11)) {
+					penalty += 40
+				}
+			}
+		}
+	}
+
+	dark := 0
+	for y := 0; y < qr.size; y++ {
+		for x := 0; x < qr.size; x++ {
+			if qr.modules[y][x] {
+				dark++
+			}
+			if x+1 < qr.size && y+1 < qr.size {
+				color := qr.modules[y][x]
+				if color == qr.modules[y][x+1] && color == qr.modules[y+1][x] && color == qr.modules[y+1][x+1] {
+					penalty += 3
+				}
+			}
+		}
+	}
+	total := qr.size * qr.size
+	deviation := abs(dark*20-total*10) / total
+	penalty += deviation * 10
+
+	return penalty
+}
+
+// qrLightRun reports whether line[from:to] is light, outside the symbol
+// counts as light.
+func qrLightRun(line []bool, from int, to int) bool {
+	for i := from; i < to; i++ {
+		if i >= 0 && i < len(line) && line[i] {
+			return false
+		}
+	}
+	return true
+}
+
+func abs(x int) int {
+	if x < 0 {
+		return -x
+	}
+	return x
+}
+
+// qrQuietZone is the light border the standard asks for, in modules.
+const qrQuietZone = 4
+
+// PNG renders the code with the given pixels per module.
+func (qr *qrCode) PNG(scale int) ([]byte, error) {
+	side := (qr.size + 2*qrQuietZone) * scale
+	img := image.NewGray(image.Rect(0, 0, side, side))
+	for i := range img.Pix {
+		img.Pix[i] = 0xFF
+	}
+	for y := 0; y < qr.size; y++ {
+		for x := 0; x < qr.size; x++ {
+			if !qr.modules[y][x] {
+				continue
+			}
+			for dy := 0; dy < scale; dy++ {
+				for dx := 0; dx < scale; dx++ {
+					img.SetGray((x+qrQuietZone)*scale+dx, (y+qrQuietZone)*scale+dy, color.Gray{})
+				}
+			}
+		}
+	}
+
+	var buf bytes.Buffer
+	if err := png.Encode(&buf, img); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// SVG renders the code as one path, it scales to any print size.
+func (qr *qrCode) SVG() string {
+	side := qr.size + 2*qrQuietZone
+	var path strings.Builder
+	for y := 0; y < qr.size; y++ {
+		for x := 0; x < qr.size; x++ {
+			if qr.modules[y][x] {
+				fmt.Fprintf(&path, "M%d,%dh1v1h-1z", x+qrQuietZone, y+qrQuietZone)
+			}
+		}
+	}
+	return fmt.Sprintf(`<svg xmlns="http://www.w3.org/2000/svg" viewBox="0 0 %d %d" shape-rendering="crispEdges">`+
+		`<rect width="100%%" height="100%%" fill="#fff"/><path d="%s" fill="#000"/></svg>`, side, side, path.String())
+}
@@ -0,0 +1,89 @@
+package main
+
+import (
+	"context"
+	"sort"
+)
+
+// RecipeCounts tallies the collection for the frontend's filter sidebar.
+// Translations are left out like on the published index.
+type RecipeCounts struct {
+	Total      int          `json:"total"`
+	Categories []CountEntry `json:"categories"`
+	Tags       []CountEntry `json:"tags"`
+}
+
+// RecipeList is the get-recipes response when counts are requested.
+type RecipeList struct {
+	Recipes []Recipe     `json:"recipes,omitempty"`
+	Counts  RecipeCounts `json:"counts"`
+}
+
+// recipeCounts counts recipes per category and per season tag in one grouped
+// query, the grouping set with neither column is the total.
+func recipeCounts(ctx context.Context, userID int, includeArchived bool) (RecipeCounts, error) {
+	rows, err := pool.Query(ctx, `
+		SELECT grouping(r.category), grouping(t.tag), coalesce(r.category, ''), coalesce(t.tag, ''), count(DISTINCT r.id)
+		FROM recipes r LEFT JOIN LATERAL unnest(r.season_tags) AS t(tag) ON true
+		WHERE r.user_id = $1 AND r.translation_of IS NULL AND ($2 OR NOT r.archived)
+		GROUP BY GROUPING SETS ((r.category), (t.tag), ())`, userID, includeArchived)
+	if err != nil {
+		return RecipeCounts{}, err
+	}
+	defer rows.Close()
+
+	counts := RecipeCounts{Categories: []CountEntry{}, Tags: []CountEntry{}}
+	for rows.Next() {
+		var categoryGrouped, tagGrouped int
+		var category, tag string
+		var count int
+		if err := rows.Scan(&categoryGrouped, &tagGrouped, &category, &tag, &count); err != nil {
+			return RecipeCounts{}, err
+		}
+
+		switch {
+		case categoryGrouped == 1 && tagGrouped == 1:
+			counts.Total = count
+		case tagGrouped == 1:
+			counts.Categories = append(counts.Categories, CountEntry{Name: category, Count: count})
+		case tag != "":
+			// the empty tag group holds the recipes without tags
+			counts.Tags = append(counts.Tags, CountEntry{Name: tag, Count: count})
+		}
+	}
+	if err := rows.Err(); err != nil {
+		return RecipeCounts{}, err
+	}
+
+	sortCountEntries(counts.Categories)
+	sortCountEntries(counts.Tags)
+	return counts, nil
+}
+
+// tallyRecipes counts an already loaded list, for the mock server.
+func tallyRecipes(recipes []Recipe) RecipeCounts {
+	categories := map[string]int{}
+	tags := map[string]int{}
+	total := 0
+	for _, recipe := range recipes {
+		if recipe.TranslationOf != 0 {
+			continue
+		}
+		total++
+		categories[recipe.Category]++
+		for _, tag := range recipe.SeasonTags {
+			tags[tag]++
+		}
+	}
+	return RecipeCounts{Total: total, Categories: sortedCounts(categories, 0), Tags: sortedCounts(tags, 0)}
+}
+
+// sortCountEntries orders like sortedCounts, by count and then name.
+func sortCountEntries(entries []CountEntry) {
+	sort.Slice(entries, func(i, j int) bool {
+		if entries[i].Count != entries[j].Count {
+			return entries[i].Count > entries[j].Count
+		}
+		return entries[i].Name < entries[j].Name
+	})
+}
@@ -0,0 +1,88 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"log"
+	"net/http"
+	"strconv"
+
+	"github.com/jackc/pgx/v5"
+)
+
+// RecipeDetail is one recipe with what the list leaves out. Versions is how
+// many versions of it were saved, PendingRevisions the generated changes
+// still waiting for a decision.
+type RecipeDetail struct {
+	Recipe
+	Versions         int `json:"versions"`
+	PendingRevisions int `json:"pendingRevisions"`
+}
+
+const queryRecipeByID = `
+	SELECT id, title, content, category, slug, version, updated_at, season_tags, prep_minutes, cook_minutes, total_minutes, difficulty, language,
+		coalesce(translation_of, 0), archived, featured_at, cuisine, coalesce(variant_of, 0), appliance, draft, diet, coalesce(parent_recipe_id, 0), created_at,
+		coalesce(source_url, ''), published_at, publish_error,
+		(SELECT count(*) FROM recipe_revisions WHERE recipe_id = recipes.id AND status = 'pending')
+	FROM recipes WHERE id = $1 AND user_id = $2`
+
+// getRecipeDetail returns pgx.ErrNoRows for recipes of other users too, they
+// are not told apart from missing ones.
+func getRecipeDetail(ctx context.Context, userID int, recipeID int) (RecipeDetail, error) {
+	var detail RecipeDetail
+	recipe := &detail.Recipe
+	err := pool.QueryRow(ctx, queryRecipeByID, recipeID, userID).Scan(&recipe.ID, &recipe.Recipename, &recipe.Recipe, &recipe.Category, &recipe.Slug,
+		&recipe.Version, &recipe.UpdatedAt, &recipe.SeasonTags, &recipe.PrepTime, &recipe.CookTime, &recipe.TotalTime, &recipe.Difficulty, &recipe.Language,
+		&recipe.TranslationOf, &recipe.Archived, &recipe.FeaturedAt, &recipe.Cuisine, &recipe.VariantOf, &recipe.Appliance, &recipe.Draft, &recipe.Diet,
+		&recipe.ParentRecipeID, &recipe.CreatedAt, &recipe.SourceURL, &recipe.PublishedAt, &recipe.PublishError,
+		&detail.PendingRevisions)
+	detail.Versions = recipe.Version
+	return detail, err
+}
+
+// HandleGetRecipe returns one of the user's recipes. URL is only set while
+// the recipe is on the published site.
+func HandleGetRecipe(w http.ResponseWriter, r *http.Request) {
+	userCtx, ok := r.Context().Value("user").(UserContext)
+	if !ok {
+		http.Error(w, "Unauthorized: user context missing", http.StatusUnauthorized)
+		return
+	}
+
+	recipeID, err := strconv.Atoi(r.PathValue("id"))
+	if err != nil {
+		http.Error(w, "Invalid recipe ID", http.StatusBadRequest)
+		return
+	}
+
+	detail, err := getRecipeDetail(r.Context(), userCtx.UserID, recipeID)
+	if err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			http.Error(w, "Recipe not found", http.StatusNotFound)
+			return
+		}
+		log.Printf("Error getting recipe %d: %v\n", recipeID, err)
+		http.Error(w, "Error getting recipe", http.StatusInternalServerError)
+		return
+	}
+	if !detail.Archived && !detail.Draft {
+		detail.URL = recipeURL(userCtx.Subdomain, detail.Slug)
+	}
+
+	body, err := json.Marshal(detail)
+	if err != nil {
+		http.Error(w, "Error encoding JSON response", http.StatusInternalServerError)
+		return
+	}
+	// the recipe is small, the ETag is of the answer itself
+	if notModified(w, r, string(body), *detail.UpdatedAt) {
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	if _, err := w.Write(append(body, '\n')); err != nil {
+		log.Printf("Error writing recipe %d: %v\n", recipeID, err)
+	}
+}
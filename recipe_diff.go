@@ -0,0 +1,139 @@
+package main
+
+import (
+	"strings"
+)
+
+const (
+	diffAdded   = "added"
+	diffRemoved = "removed"
+	diffChanged = "changed"
+)
+
+type IngredientChange struct {
+	Change string            `json:"change"`
+	Before *RecipeIngredient `json:"before,omitempty"`
+	After  *RecipeIngredient `json:"after,omitempty"`
+}
+
+type StepChange struct {
+	Change string      `json:"change"`
+	Before *RecipeStep `json:"before,omitempty"`
+	After  *RecipeStep `json:"after,omitempty"`
+}
+
+// RecipeDiff lists what an edit changed, unchanged lines are left out.
+type RecipeDiff struct {
+	TitleBefore string             `json:"titleBefore,omitempty"`
+	TitleAfter  string             `json:"titleAfter,omitempty"`
+	Ingredients []IngredientChange `json:"ingredients"`
+	Steps       []StepChange       `json:"steps"`
+}
+
+func diffRecipes(before string, after string) RecipeDiff {
+	old := parseRecipeMarkdown(before)
+	updated := parseRecipeMarkdown(after)
+
+	diff := RecipeDiff{
+		Ingredients: diffIngredients(old.Ingredients, updated.Ingredients),
+		Steps:       diffSteps(old.Steps, updated.Steps),
+	}
+	if old.Title != updated.Title {
+		diff.TitleBefore = old.Title
+		diff.TitleAfter = updated.Title
+	}
+	return diff
+}
+
+// diffIngredients matches ingredients by name, so a new amount shows up as a
+// change instead of a removal and an addition.
+func diffIngredients(before []RecipeIngredient, after []RecipeIngredient) []IngredientChange {
+	changes := []IngredientChange{}
+
+	remaining := map[string][]int{}
+	for i, ingredient := range after {
+		key := strings.ToLower(ingredient.Name)
+		remaining[key] = append(remaining[key], i)
+	}
+
+	matched := make([]bool, len(after))
+	for i := range before {
+		key := strings.ToLower(before[i].Name)
+		candidates := remaining[key]
+		if len(candidates) == 0 {
+			changes = append(changes, IngredientChange{Change: diffRemoved, Before: &before[i]})
+			continue
+		}
+
+		j := candidates[0]
+		remaining[key] = candidates[1:]
+		matched[j] = true
+		if before[i].Amount != after[j].Amount {
+			changes = append(changes, IngredientChange{Change: diffChanged, Before: &before[i], After: &after[j]})
+		}
+	}
+
+	for j := range after {
+		if !matched[j] {
+			changes = append(changes, IngredientChange{Change: diffAdded, After: &after[j]})
+		}
+	}
+
+	return changes
+}
+
+// diffSteps keeps the longest common sequence of steps, a removal directly
+// followed by an addition is reported as a changed step.
+func diffSteps(before []RecipeStep, after []RecipeStep) []StepChange {
+	n, m := len(before), len(after)
+	common := make([][]int, n+1)
+	for i := range common {
+		common[i] = make([]int, m+1)
+	}
+	for i := n - 1; i >= 0; i-- {
+		for j := m - 1; j >= 0; j-- {
+			if before[i] == after[j] {
+				common[i][j] = common[i+1][j+1] + 1
+			} else {
+				common[i][j] = max(common[i+1][j], common[i][j+1])
+			}
+		}
+	}
+
+	changes := []StepChange{}
+	var removed []*RecipeStep
+	flush := func(added []*RecipeStep) {
+		for len(removed) > 0 && len(added) > 0 {
+			changes = append(changes, StepChange{Change: diffChanged, Before: removed[0], After: added[0]})
+			removed, added = removed[1:], added[1:]
+		}
+		for _, step := range removed {
+			changes = append(changes, StepChange{Change: diffRemoved, Before: step})
+		}
+		for _, step := range added {
+			changes = append(changes, StepChange{Change: diffAdded, After: step})
+		}
+		removed = nil
+	}
+
+	i, j := 0, 0
+	var added []*RecipeStep
+	for i < n || j < m {
+		switch {
+		case i < n && j < m && before[i] == after[j]:
+			flush(added)
+			added = nil
+			i++
+			j++
+		case j < m && (i == n || common[i][j+1] >= common[i+1][j]):
+			added = append(added, &after[j])
+			j++
+		default:
+			removed = append(removed, &before[i])
+			i++
+		}
+	}
+	flush(added)
+
+	return changes
+}
@@ -0,0 +1,155 @@
+package main
+
+import (
+	"strings"
+)
+
+// isIngredientsHeading matches the "## Zutaten" / "## Ingredients" sections the
+// system prompts ask the model to produce.
+func isIngredientsHeading(line string) bool {
+	heading := strings.ToLower(strings.TrimSpace(strings.TrimLeft(line, "#")))
+	return heading == "zutaten" || heading == "ingredients"
+}
+
+// extractIngredientLines returns the list items of the ingredients section
+// with the markdown bullet and bold markers removed.
+func extractIngredientLines(markdown string) []string {
+	var ingredients []string
+	inIngredients := false
+
+	for _, line := range strings.Split(markdown, "\n") {
+		trimmed := strings.TrimSpace(line)
+		if strings.HasPrefix(trimmed, "#") {
+			inIngredients = isIngredientsHeading(trimmed)
+			continue
+		}
+		if !inIngredients {
+			continue
+		}
+
+		if strings.HasPrefix(trimmed, "- ") || strings.HasPrefix(trimmed, "* ") {
+			item := strings.TrimSpace(strings.ReplaceAll(trimmed[2:], "**", ""))
+			if item != "" {
+				ingredients = append(ingredients, item)
+			}
+		}
+	}
+
+	return ingredients
+}
+
+// RecipeStep is one instruction, Section is the "###" heading it is listed
+// under.
+type RecipeStep struct {
+	Section string `json:"section,omitempty"`
+	Text    string `json:"text"`
+}
+
+// RecipeIngredient keeps the bold amount of "- **200 g** Mehl" apart from
+// the ingredient name.
+type RecipeIngredient struct {
+	Amount string `json:"amount,omitempty"`
+	Name   string `json:"name"`
+}
+
+func (i RecipeIngredient) String() string {
+	if i.Amount == "" {
+		return i.Name
+	}
+	return i.Amount + " " + i.Name
+}
+
+type structuredRecipe struct {
+	Title       string
+	Ingredients []RecipeIngredient
+	Steps       []RecipeStep
+}
+
+// parseRecipeMarkdown reads the format the system prompts ask for: a title,
+// the ingredients list and instruction sections with list items or numbered
+// steps.
+func parseRecipeMarkdown(markdown string) structuredRecipe {
+	var recipe structuredRecipe
+	var section string
+	inIngredients, inSteps := false, false
+
+	for _, line := range strings.Split(markdown, "\n") {
+		trimmed := strings.TrimSpace(line)
+		switch {
+		case strings.HasPrefix(trimmed, "# "):
+			recipe.Title = strings.TrimSpace(trimmed[2:])
+			continue
+		case strings.HasPrefix(trimmed, "### "):
+			section = strings.TrimSpace(trimmed[4:])
+			continue
+		case strings.HasPrefix(trimmed, "#"):
+			inIngredients = isIngredientsHeading(trimmed)
+			inSteps = !inIngredients
+			section = ""
+			continue
+		}
+
+		item, ok := listItem(trimmed)
+		if !ok {
+			continue
+		}
+		if inIngredients {
+			recipe.Ingredients = append(recipe.Ingredients, parseIngredient(item))
+		} else if inSteps {
+			recipe.Steps = append(recipe.Steps, RecipeStep{Section: section, Text: strings.ReplaceAll(item, "**", "")})
+		}
+	}
+
+	return recipe
+}
+
+// listItem returns the text of a "- ", "* " or "1. " list line.
+func listItem(line string) (string, bool) {
+	if strings.HasPrefix(line, "- ") || strings.HasPrefix(line, "* ") {
+		item := strings.TrimSpace(line[2:])
+		return item, item != ""
+	}
+
+	dot := strings.Index(line, ". ")
+	if dot <= 0 {
+		return "", false
+	}
+	for _, c := range line[:dot] {
+		if c < '0' || c > '9' {
+			return "", false
+		}
+	}
+	item := strings.TrimSpace(line[dot+2:])
+	return item, item != ""
+}
+
+func parseIngredient(item string) RecipeIngredient {
+	if strings.HasPrefix(item, "**") {
+		if end := strings.Index(item[2:], "**"); end >= 0 {
+			return RecipeIngredient{
+				Amount: strings.TrimSpace(item[2 : end+2]),
+				Name:   strings.TrimSpace(strings.ReplaceAll(item[end+4:], "**", "")),
+			}
+		}
+	}
+
+	// Without bold markers a leading number and unit are taken as the amount.
+	fields := strings.Fields(strings.ReplaceAll(item, "**", ""))
+	if len(fields) > 1 && strings.IndexAny(fields[0], "0123456789½¼¾") == 0 {
+		amount := 1
+		if len(fields) > 2 && ingredientUnits[strings.ToLower(strings.TrimSuffix(fields[1], "."))] {
+			amount = 2
+		}
+		return RecipeIngredient{
+			Amount: strings.Join(fields[:amount], " "),
+			Name:   strings.Join(fields[amount:], " "),
+		}
+	}
+	return RecipeIngredient{Name: strings.Join(fields, " ")}
+}
+
+var ingredientUnits = map[string]bool{
+	"g": true, "kg": true, "mg": true, "ml": true, "l": true, "cl": true, "dl": true,
+	"el": true, "tl": true, "tbsp": true, "tsp": true, "cup": true, "cups": true,
+	"prise": true, "prisen": true, "stück": true, "pinch": true, "bund": true, "dose": true,
+}
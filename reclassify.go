@@ -0,0 +1,166 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"io"
+	"log"
+	"net/http"
+	"slices"
+	"strings"
+)
+
+// reclassifyBatch is how many recipes one request classifies, each costs two
+// LLM calls.
+const reclassifyBatch = 20
+
+type ReclassifyChange struct {
+	RecipeID         int      `json:"recipeID"`
+	Recipename       string   `json:"recipename"`
+	CategoryBefore   string   `json:"categoryBefore"`
+	CategoryAfter    string   `json:"categoryAfter"`
+	SeasonTagsBefore []string `json:"seasonTagsBefore"`
+	SeasonTagsAfter  []string `json:"seasonTagsAfter"`
+}
+
+type ReclassifyResult struct {
+	DryRun  bool               `json:"dryRun"`
+	Checked int                `json:"checked"`
+	Failed  int                `json:"failed"`
+	Changes []ReclassifyChange `json:"changes"`
+	// NextAfterID continues with the next batch, it is 0 once all recipes
+	// were checked.
+	NextAfterID int `json:"nextAfterID,omitempty"`
+	// LimitReached is set when the daily generation limit stopped the batch
+	// early, the rest can be continued tomorrow from NextAfterID.
+	LimitReached bool `json:"limitReached,omitempty"`
+}
+
+// HandleReclassifyRecipes runs category and season classification again over
+// the user's recipes, reclassifyBatch of them after afterID per request. Each
+// recipe counts as a generation, GenerationLimitMiddleware counted the first.
+// With dryRun the proposed changes are only returned, otherwise they are
+// saved and the recipe index is republished.
+func HandleReclassifyRecipes(w http.ResponseWriter, r *http.Request) {
+	userCtx, ok := r.Context().Value("user").(UserContext)
+	if !ok {
+		http.Error(w, "Unauthorized: user context missing", http.StatusUnauthorized)
+		return
+	}
+
+	var req struct {
+		DryRun  bool `json:"dryRun"`
+		AfterID int  `json:"afterID"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil && !errors.Is(err, io.EOF) {
+		http.Error(w, "Invalid JSON payload", http.StatusBadRequest)
+		return
+	}
+
+	// one more than the batch tells whether there is a next one
+	rows, err := pool.Query(r.Context(), "SELECT id, title, content, category, season_tags FROM recipes WHERE user_id = $1 AND id > $2 ORDER BY id LIMIT $3",
+		userCtx.UserID, req.AfterID, reclassifyBatch+1)
+	if err != nil {
+		log.Printf("Error getting recipes: %v\n", err)
+		http.Error(w, "Error getting recipes", http.StatusInternalServerError)
+		return
+	}
+	var recipes []Recipe
+	for rows.Next() {
+		var recipe Recipe
+		if err := rows.Scan(&recipe.ID, &recipe.Recipename, &recipe.Recipe, &recipe.Category, &recipe.SeasonTags); err != nil {
+			rows.Close()
+			log.Printf("Error scanning recipe: %v\n", err)
+			http.Error(w, "Error getting recipes", http.StatusInternalServerError)
+			return
+		}
+		recipes = append(recipes, recipe)
+	}
+	rows.Close()
+
+	result := ReclassifyResult{DryRun: req.DryRun, Changes: []ReclassifyChange{}}
+	if len(recipes) > reclassifyBatch {
+		recipes = recipes[:reclassifyBatch]
+		result.NextAfterID = recipes[len(recipes)-1].ID
+	}
+	for i, recipe := range recipes {
+		if i > 0 {
+			err := countGeneration(r.Context(), userCtx.UserID)
+			if errors.Is(err, errGenerationLimit) {
+				result.LimitReached = true
+				result.NextAfterID = recipes[i-1].ID
+				break
+			}
+			if err != nil {
+				log.Printf("Error counting generation for user %d: %v\n", userCtx.UserID, err)
+				http.Error(w, "Error counting generation", http.StatusInternalServerError)
+				return
+			}
+		}
+		result.Checked++
+
+		category := goopenAIgenerateRecipeCategory(r.Context(), recipe.Recipe)
+		tags, err := classifySeasonTags(r.Context(), recipe.Recipename, recipe.Recipe)
+		if category == "" || err != nil {
+			log.Printf("Error reclassifying recipe %d: %v\n", recipe.ID, err)
+			result.Failed++
+			continue
+		}
+
+		slices.Sort(tags)
+		before := slices.Clone(recipe.SeasonTags)
+		slices.Sort(before)
+		if category == recipe.Category && slices.Equal(tags, before) {
+			continue
+		}
+
+		result.Changes = append(result.Changes, ReclassifyChange{
+			RecipeID:         recipe.ID,
+			Recipename:       recipe.Recipename,
+			CategoryBefore:   recipe.Category,
+			CategoryAfter:    category,
+			SeasonTagsBefore: recipe.SeasonTags,
+			SeasonTagsAfter:  tags,
+		})
+	}
+
+	if !req.DryRun && len(result.Changes) > 0 {
+		for _, change := range result.Changes {
+			_, err := pool.Exec(r.Context(), `
+				UPDATE recipes SET category = $1, season_tags = $2, season_tagged_at = now(), updated_at = now(), version = version + 1
+				WHERE id = $3 AND user_id = $4`,
+				change.CategoryAfter, change.SeasonTagsAfter, change.RecipeID, userCtx.UserID)
+			if err != nil {
+				log.Printf("Error saving reclassified recipe %d: %v\n", change.RecipeID, err)
+				http.Error(w, "Error saving categories", http.StatusInternalServerError)
+				return
+			}
+			recordAudit(r.Context(), userCtx, AuditEntry{
+				Action:   auditRecipeUpdated,
+				RecipeID: change.RecipeID,
+				Before:   &AuditSummary{Title: change.Recipename, Category: change.CategoryBefore, Detail: strings.Join(change.SeasonTagsBefore, ", ")},
+				After:    &AuditSummary{Title: change.Recipename, Category: change.CategoryAfter, Detail: strings.Join(change.SeasonTagsAfter, ", ")},
+			})
+		}
+		invalidateRecipes(userCtx.UserID)
+
+		// saison.md is rebuilt by the next seasonal job run.
+		if _, err := pool.Exec(r.Context(), "UPDATE users SET season_published_for = '' WHERE id = $1", userCtx.UserID); err != nil {
+			log.Printf("Error scheduling seasonal page refresh: %v\n", err)
+		}
+
+		if err := templateRecipesBlob(userCtx.Subdomain, userCtx.UserID); err != nil {
+			log.Printf("Error updating recipe template: %v\n", err)
+			http.Error(w, "Failed to update recipe template", http.StatusInternalServerError)
+			return
+		}
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+
+	err = json.NewEncoder(w).Encode(result)
+	if err != nil {
+		http.Error(w, "Error encoding JSON response", http.StatusInternalServerError)
+	}
+}
@@ -0,0 +1,150 @@
+package main
+
+import (
+	"context"
+	"log"
+	"time"
+)
+
+// A renamed recipe moves to the page of its new slug. Shared links to the
+// old page keep working: slug_redirects remembers every old slug of a
+// recipe, its page becomes a stub linking the current one, and no other
+// recipe gets the old slug. Recipes and pages linking the renamed one are
+// published again, the links in them are rendered from the current slugs.
+
+// redirectStub is the page left at an old slug.
+func redirectStub(title string, slug string) string {
+	return "# " + title + "\n\nDieses Rezept ist umgezogen: [" + title + "](./?recipe=" + slug + ")\n"
+}
+
+// publishSlugRename records that the recipe moved away from oldSlug and
+// publishes the stubs and the pages that link it. It runs after the
+// recipe's own page is published.
+func publishSlugRename(ctx context.Context, storageAccountName string, userID int, recipeID int, oldSlug string) error {
+	var title, slug string
+	err := pool.QueryRow(ctx, "SELECT title, slug FROM recipes WHERE id = $1 AND user_id = $2", recipeID, userID).Scan(&title, &slug)
+	if err != nil {
+		return err
+	}
+
+	_, err = pool.Exec(ctx, `
+		INSERT INTO slug_redirects (user_id, old_slug, recipe_id) VALUES ($1, $2, $3)
+		ON CONFLICT (user_id, old_slug) DO UPDATE SET recipe_id = $3, created_at = now()`,
+		userID, oldSlug, recipeID)
+	if err != nil {
+		return err
+	}
+	// renamed back, the slug is a page again
+	if _, err := pool.Exec(ctx, "DELETE FROM slug_redirects WHERE user_id = $1 AND old_slug = $2", userID, slug); err != nil {
+		return err
+	}
+
+	oldSlugs, err := redirectedSlugs(ctx, userID, recipeID)
+	if err != nil {
+		return err
+	}
+	err = withUserLock(ctx, publishLockNamespace, userID, func() error {
+		for _, old := range oldSlugs {
+			// every stub links the current slug, not the one after it
+			if err := addBlob(storageAccountName, "recipes/"+old+".md", redirectStub(title, slug)); err != nil {
+				return err
+			}
+		}
+		return removeBlob(storageAccountName, printableRecipePath(oldSlug))
+	})
+	if err != nil {
+		return err
+	}
+
+	linking, err := linkingRecipeSlugs(ctx, userID, recipeID)
+	if err != nil {
+		return err
+	}
+	for _, linked := range linking {
+		if err := publishRecipeBlob(storageAccountName, userID, linked); err != nil {
+			return err
+		}
+	}
+
+	if planned, err := recipeIsPlanned(ctx, userID, recipeID); err != nil {
+		log.Printf("Error looking up meal plan of recipe %d: %v\n", recipeID, err)
+	} else if planned {
+		publishMealPlan(ctx, UserContext{UserID: userID, Subdomain: storageAccountName})
+	}
+	return nil
+}
+
+// getRedirectStub renders the stub of an old slug for embedded sites,
+// pgx.ErrNoRows when the slug never was a recipe's.
+func getRedirectStub(ctx context.Context, userID int, oldSlug string) (string, time.Time, error) {
+	var title, slug string
+	var createdAt time.Time
+	err := pool.QueryRow(ctx, `
+		SELECT r.title, r.slug, s.created_at FROM slug_redirects s JOIN recipes r ON r.id = s.recipe_id
+		WHERE s.user_id = $1 AND s.old_slug = $2 AND NOT r.archived AND NOT r.draft`, userID, oldSlug).Scan(&title, &slug, &createdAt)
+	if err != nil {
+		return "", time.Time{}, err
+	}
+	return redirectStub(title, slug), createdAt, nil
+}
+
+// redirectedSlug is the current slug of the recipe that had oldSlug,
+// pgx.ErrNoRows when there is none or it isn't published.
+func redirectedSlug(ctx context.Context, userID int, oldSlug string) (string, error) {
+	var slug string
+	err := pool.QueryRow(ctx, `
+		SELECT r.slug FROM slug_redirects s JOIN recipes r ON r.id = s.recipe_id
+		WHERE s.user_id = $1 AND s.old_slug = $2 AND NOT r.archived AND NOT r.draft`, userID, oldSlug).Scan(&slug)
+	return slug, err
+}
+
+func redirectedSlugs(ctx context.Context, userID int, recipeID int) ([]string, error) {
+	rows, err := pool.Query(ctx, "SELECT old_slug FROM slug_redirects WHERE user_id = $1 AND recipe_id = $2 ORDER BY old_slug", userID, recipeID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var slugs []string
+	for rows.Next() {
+		var slug string
+		if err := rows.Scan(&slug); err != nil {
+			return nil, err
+		}
+		slugs = append(slugs, slug)
+	}
+	return slugs, rows.Err()
+}
+
+// linkingRecipeSlugs returns the published recipes whose page links the
+// recipe: the copies based on it, its translations and appliance variants
+// and the ones it is a translation or variant of, with their siblings.
+func linkingRecipeSlugs(ctx context.Context, userID int, recipeID int) ([]string, error) {
+	rows, err := pool.Query(ctx, `
+		WITH renamed AS (SELECT translation_of, variant_of FROM recipes WHERE id = $2)
+		SELECT slug FROM recipes, renamed WHERE user_id = $1 AND id <> $2 AND NOT archived AND NOT draft AND (
+			parent_recipe_id = $2 OR recipes.translation_of = $2 OR recipes.variant_of = $2
+			OR id = renamed.translation_of OR id = renamed.variant_of
+			OR recipes.translation_of = renamed.translation_of OR recipes.variant_of = renamed.variant_of)
+		ORDER BY id`, userID, recipeID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var slugs []string
+	for rows.Next() {
+		var slug string
+		if err := rows.Scan(&slug); err != nil {
+			return nil, err
+		}
+		slugs = append(slugs, slug)
+	}
+	return slugs, rows.Err()
+}
+
+func recipeIsPlanned(ctx context.Context, userID int, recipeID int) (bool, error) {
+	var planned bool
+	err := pool.QueryRow(ctx, "SELECT EXISTS (SELECT 1 FROM meal_plan_entries WHERE user_id = $1 AND recipe_id = $2)", userID, recipeID).Scan(&planned)
+	return planned, err
+}
@@ -0,0 +1,272 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"log"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+)
+
+const (
+	revisionPending  = "pending"
+	revisionAccepted = "accepted"
+	revisionRejected = "rejected"
+)
+
+// RecipeRevision is an LLM edit of a stored recipe. Decided revisions are
+// kept as the history of AI modifications.
+type RecipeRevision struct {
+	ID           int64       `json:"id"`
+	RecipeID     int         `json:"recipeID"`
+	BaseVersion  int         `json:"baseVersion"`
+	ChangePrompt string      `json:"changePrompt"`
+	Recipe       string      `json:"recipe"`
+	Status       string      `json:"status"`
+	CreatedAt    time.Time   `json:"createdAt"`
+	DecidedAt    *time.Time  `json:"decidedAt,omitempty"`
+	Diff         *RecipeDiff `json:"diff,omitempty"`
+}
+
+// createPendingRevision runs the change prompt against the stored recipe and
+// keeps the result until the user accepts or rejects it.
+func createPendingRevision(ctx context.Context, userID int, recipeID int, changePrompt string) (RecipeRevision, error) {
+	var current string
+	var version int
+	err := pool.QueryRow(ctx, "SELECT content, version FROM recipes WHERE id = $1 AND user_id = $2",
+		recipeID, userID).Scan(&current, &version)
+	if err != nil {
+		return RecipeRevision{}, err
+	}
+
+	updated, err := goopenaiUpdateRecipe(ctx, current, changePrompt)
+	if err != nil {
+		return RecipeRevision{}, err
+	}
+
+	revision := RecipeRevision{
+		RecipeID:     recipeID,
+		BaseVersion:  version,
+		ChangePrompt: changePrompt,
+		Recipe:       updated,
+		Status:       revisionPending,
+	}
+	err = pool.QueryRow(ctx, `
+		INSERT INTO recipe_revisions (recipe_id, user_id, base_version, change_prompt, content, status)
+		VALUES ($1, $2, $3, $4, $5, $6) RETURNING id, created_at`,
+		recipeID, userID, version, changePrompt, updated, revisionPending).Scan(&revision.ID, &revision.CreatedAt)
+	if err != nil {
+		return RecipeRevision{}, err
+	}
+
+	diff := diffRecipes(current, updated)
+	revision.Diff = &diff
+	return revision, nil
+}
+
+func HandleListRevisions(w http.ResponseWriter, r *http.Request) {
+	userCtx, ok := r.Context().Value("user").(UserContext)
+	if !ok {
+		http.Error(w, "Unauthorized: user context missing", http.StatusUnauthorized)
+		return
+	}
+
+	recipeID, err := strconv.Atoi(r.PathValue("id"))
+	if err != nil {
+		http.Error(w, "Invalid recipe ID", http.StatusBadRequest)
+		return
+	}
+
+	if revisionsNotModified(r.Context(), w, r, userCtx.UserID, recipeID) {
+		return
+	}
+
+	rows, err := pool.Query(r.Context(), `
+		SELECT id, recipe_id, base_version, change_prompt, content, status, created_at, decided_at
+		FROM recipe_revisions WHERE recipe_id = $1 AND user_id = $2 ORDER BY id DESC`,
+		recipeID, userCtx.UserID)
+	if err != nil {
+		log.Printf("Error getting revisions: %v\n", err)
+		http.Error(w, "Error getting revisions", http.StatusInternalServerError)
+		return
+	}
+	defer rows.Close()
+
+	revisions := []RecipeRevision{}
+	for rows.Next() {
+		var revision RecipeRevision
+		err := rows.Scan(&revision.ID, &revision.RecipeID, &revision.BaseVersion, &revision.ChangePrompt,
+			&revision.Recipe, &revision.Status, &revision.CreatedAt, &revision.DecidedAt)
+		if err != nil {
+			log.Printf("Error scanning revision: %v\n", err)
+			http.Error(w, "Error getting revisions", http.StatusInternalServerError)
+			return
+		}
+		revisions = append(revisions, revision)
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+
+	err = json.NewEncoder(w).Encode(revisions)
+	if err != nil {
+		http.Error(w, "Error encoding JSON response", http.StatusInternalServerError)
+	}
+}
+
+// HandleAcceptRevision makes the revision the current recipe and publishes
+// it. Revisions based on an older version are refused, the recipe was edited
+// after the change was generated.
+func HandleAcceptRevision(w http.ResponseWriter, r *http.Request) {
+	userCtx, recipeID, revisionID, ok := revisionPath(w, r)
+	if !ok {
+		return
+	}
+
+	ctx := r.Context()
+	tx, err := pool.Begin(ctx)
+	if err != nil {
+		log.Printf("Error starting transaction: %v\n", err)
+		http.Error(w, "Error accepting revision", http.StatusInternalServerError)
+		return
+	}
+	defer tx.Rollback(ctx)
+
+	var content, status string
+	var baseVersion int
+	err = tx.QueryRow(ctx, `
+		SELECT content, status, base_version FROM recipe_revisions
+		WHERE id = $1 AND recipe_id = $2 AND user_id = $3 FOR UPDATE`,
+		revisionID, recipeID, userCtx.UserID).Scan(&content, &status, &baseVersion)
+	if err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			http.Error(w, "Revision not found", http.StatusNotFound)
+			return
+		}
+		log.Printf("Error getting revision: %v\n", err)
+		http.Error(w, "Error accepting revision", http.StatusInternalServerError)
+		return
+	}
+	if status != revisionPending {
+		http.Error(w, "Revision was already "+status, http.StatusConflict)
+		return
+	}
+
+	var recipe Recipe
+	err = tx.QueryRow(ctx, `
+		UPDATE recipes SET content = $1, updated_at = now(), version = version + 1, season_tagged_at = NULL, season_tag_failures = 0, diet = ''
+		WHERE id = $2 AND user_id = $3 AND version = $4
+		RETURNING title, category, slug, version, updated_at, prep_minutes, cook_minutes, total_minutes, difficulty`,
+		content, recipeID, userCtx.UserID, baseVersion).Scan(&recipe.Recipename, &recipe.Category, &recipe.Slug,
+		&recipe.Version, &recipe.UpdatedAt, &recipe.PrepTime, &recipe.CookTime, &recipe.TotalTime, &recipe.Difficulty)
+	if err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			writeVersionConflict(w, 0)
+			return
+		}
+		log.Printf("Error updating recipe: %v\n", err)
+		http.Error(w, "Error accepting revision", http.StatusInternalServerError)
+		return
+	}
+
+	_, err = tx.Exec(ctx, "UPDATE recipe_revisions SET status = $1, decided_at = now() WHERE id = $2", revisionAccepted, revisionID)
+	if err != nil {
+		log.Printf("Error updating revision: %v\n", err)
+		http.Error(w, "Error accepting revision", http.StatusInternalServerError)
+		return
+	}
+
+	if err := tx.Commit(ctx); err != nil {
+		log.Printf("Error committing revision: %v\n", err)
+		http.Error(w, "Error accepting revision", http.StatusInternalServerError)
+		return
+	}
+	invalidateRecipes(userCtx.UserID)
+
+	after := recipeAuditSummary(recipe.Recipename, recipe.Category, recipe.Version, content)
+	after.Detail = "revision " + strconv.FormatInt(revisionID, 10)
+	recordAudit(ctx, userCtx, AuditEntry{
+		Action:   auditRevisionAccepted,
+		RecipeID: recipeID,
+		Slug:     recipe.Slug,
+		Before:   &AuditSummary{Version: baseVersion},
+		After:    after,
+	})
+
+	if err := publishRecipeBlob(userCtx.Subdomain, userCtx.UserID, recipe.Slug); err != nil {
+		log.Printf("Error updating recipe in blob storage: %v\n", err)
+		http.Error(w, "Failed to update recipe in storage", http.StatusInternalServerError)
+		return
+	}
+
+	if err := templateRecipesBlob(userCtx.Subdomain, userCtx.UserID); err != nil {
+		log.Printf("Error updating recipe template: %v\n", err)
+		http.Error(w, "Failed to update recipe template", http.StatusInternalServerError)
+		return
+	}
+
+	recipe.ID = recipeID
+	emitEvent(userCtx.UserID, eventRecipeUpdated, recipe)
+
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("ETag", recipeETag(recipe.Version))
+	w.WriteHeader(http.StatusOK)
+
+	err = json.NewEncoder(w).Encode(map[string]interface{}{
+		"message":   "Revision accepted",
+		"version":   recipe.Version,
+		"updatedAt": recipe.UpdatedAt,
+	})
+	if err != nil {
+		http.Error(w, "Error encoding JSON response", http.StatusInternalServerError)
+	}
+}
+
+func HandleRejectRevision(w http.ResponseWriter, r *http.Request) {
+	userCtx, recipeID, revisionID, ok := revisionPath(w, r)
+	if !ok {
+		return
+	}
+
+	tag, err := pool.Exec(r.Context(), `
+		UPDATE recipe_revisions SET status = $1, decided_at = now()
+		WHERE id = $2 AND recipe_id = $3 AND user_id = $4 AND status = $5`,
+		revisionRejected, revisionID, recipeID, userCtx.UserID, revisionPending)
+	if err != nil {
+		log.Printf("Error rejecting revision: %v\n", err)
+		http.Error(w, "Error rejecting revision", http.StatusInternalServerError)
+		return
+	}
+	if tag.RowsAffected() == 0 {
+		http.Error(w, "No pending revision found", http.StatusNotFound)
+		return
+	}
+
+	w.WriteHeader(http.StatusOK)
+}
+
+func revisionPath(w http.ResponseWriter, r *http.Request) (UserContext, int, int64, bool) {
+	userCtx, ok := r.Context().Value("user").(UserContext)
+	if !ok {
+		http.Error(w, "Unauthorized: user context missing", http.StatusUnauthorized)
+		return UserContext{}, 0, 0, false
+	}
+
+	recipeID, err := strconv.Atoi(r.PathValue("id"))
+	if err != nil {
+		http.Error(w, "Invalid recipe ID", http.StatusBadRequest)
+		return UserContext{}, 0, 0, false
+	}
+
+	revisionID, err := strconv.ParseInt(r.PathValue("revisionID"), 10, 64)
+	if err != nil {
+		http.Error(w, "Invalid revision ID", http.StatusBadRequest)
+		return UserContext{}, 0, 0, false
+	}
+
+	return userCtx, recipeID, revisionID, true
+}
@@ -0,0 +1,234 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"log"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+)
+
+const scheduleSystemMessage = `You extract the schedule of a recipe with long waiting times, like sourdough, fermenting or curing.
+Answer with a JSON object {"steps": [{"title": "", "offsetMinutes": 0, "durationMinutes": 0, "reminder": false}]}.
+offsetMinutes is when the step starts counted from the first step, durationMinutes how long the step keeps the cook busy.
+reminder is true for steps that have to happen at their time, like feeding a starter, shaping a dough or turning meat.
+Waiting is not a step of its own. Titles are short and in the language of the recipe.
+Answer with an empty list of steps when the recipe is done within a few hours.`
+
+// A schedule spans two weeks at most, longer cures are cut off rather than
+// filling the calendar.
+const (
+	maxScheduleSteps   = 30
+	maxScheduleOffset  = 14 * 24 * 60
+	minScheduleMinutes = 15
+)
+
+// mealTimes are when a planned meal is ready, the schedule of an entry ends
+// there.
+var mealTimes = map[string]time.Duration{
+	mealBreakfast: 8 * time.Hour,
+	mealLunch:     12 * time.Hour,
+	mealDinner:    18 * time.Hour,
+}
+
+type ScheduleStep struct {
+	Title           string `json:"title"`
+	OffsetMinutes   int    `json:"offsetMinutes"`
+	DurationMinutes int    `json:"durationMinutes,omitempty"`
+	Reminder        bool   `json:"reminder"`
+}
+
+type ScheduledStep struct {
+	ScheduleStep
+	// Day counts from 0 for the day the schedule starts.
+	Day int       `json:"day"`
+	At  time.Time `json:"at"`
+}
+
+// RecipeSchedule is the timeline of a recipe's steps. MultiDay is false for
+// recipes done on the day they are started.
+type RecipeSchedule struct {
+	RecipeID int             `json:"recipeID"`
+	Start    time.Time       `json:"start"`
+	End      time.Time       `json:"end"`
+	MultiDay bool            `json:"multiDay"`
+	Steps    []ScheduledStep `json:"steps"`
+}
+
+// normalizeScheduleSteps drops steps without a title or outside the schedule
+// and orders them by their offset.
+func normalizeScheduleSteps(steps []ScheduleStep) []ScheduleStep {
+	normalized := []ScheduleStep{}
+	for _, step := range steps {
+		step.Title = strings.TrimSpace(step.Title)
+		if step.Title == "" || step.OffsetMinutes < 0 || step.OffsetMinutes > maxScheduleOffset {
+			continue
+		}
+		step.DurationMinutes = max(step.DurationMinutes, 0)
+		normalized = append(normalized, step)
+	}
+	sort.SliceStable(normalized, func(i, j int) bool {
+		return normalized[i].OffsetMinutes < normalized[j].OffsetMinutes
+	})
+	if len(normalized) > maxScheduleSteps {
+		normalized = normalized[:maxScheduleSteps]
+	}
+	return normalized
+}
+
+// recipeScheduleSteps returns the steps extracted for the recipe's current
+// version, they are extracted again once the recipe was edited.
+func recipeScheduleSteps(ctx context.Context, userID int, recipeID int) ([]ScheduleStep, error) {
+	var content string
+	var version int
+	var scheduledVersion *int
+	var steps []ScheduleStep
+	err := pool.QueryRow(ctx, `
+		SELECT r.content, r.version, s.version, coalesce(s.steps, '[]') FROM recipes r LEFT JOIN recipe_schedules s ON s.recipe_id = r.id
+		WHERE r.id = $1 AND r.user_id = $2`,
+		recipeID, userID).Scan(&content, &version, &scheduledVersion, &steps)
+	if err != nil {
+		return nil, err
+	}
+	if scheduledVersion != nil && *scheduledVersion == version {
+		return steps, nil
+	}
+
+	var extracted struct {
+		Steps []ScheduleStep `json:"steps"`
+	}
+	err = completeJSON(ctx, llmRequest{
+		Task:   llmTaskSchedule,
+		System: scheduleSystemMessage,
+		Prompt: content,
+	}, &extracted)
+	if err != nil {
+		return nil, err
+	}
+	steps = normalizeScheduleSteps(extracted.Steps)
+
+	_, err = pool.Exec(ctx, `
+		INSERT INTO recipe_schedules (recipe_id, version, steps) VALUES ($1, $2, $3)
+		ON CONFLICT (recipe_id) DO UPDATE SET version = $2, steps = $3`,
+		recipeID, version, steps)
+	if err != nil {
+		return nil, err
+	}
+	return steps, nil
+}
+
+// buildSchedule lays the steps out from start.
+func buildSchedule(recipeID int, steps []ScheduleStep, start time.Time) RecipeSchedule {
+	schedule := RecipeSchedule{RecipeID: recipeID, Start: start, End: start, Steps: []ScheduledStep{}}
+	for _, step := range steps {
+		at := start.Add(time.Duration(step.OffsetMinutes) * time.Minute)
+		schedule.Steps = append(schedule.Steps, ScheduledStep{ScheduleStep: step, Day: scheduleDay(start, at), At: at})
+		if end := at.Add(time.Duration(step.DurationMinutes) * time.Minute); end.After(schedule.End) {
+			schedule.End = end
+		}
+	}
+	schedule.MultiDay = scheduleDay(start, schedule.End) > 0
+	return schedule
+}
+
+// scheduleReadyAt lays the steps out so the last one is done at ready.
+func scheduleReadyAt(recipeID int, steps []ScheduleStep, ready time.Time) RecipeSchedule {
+	span := buildSchedule(recipeID, steps, ready).End.Sub(ready)
+	return buildSchedule(recipeID, steps, ready.Add(-span))
+}
+
+// scheduleDay counts calendar days, a step after midnight is on the next
+// day however late the schedule started.
+func scheduleDay(start time.Time, at time.Time) int {
+	startDay := time.Date(start.Year(), start.Month(), start.Day(), 0, 0, 0, 0, time.UTC)
+	atDay := time.Date(at.Year(), at.Month(), at.Day(), 0, 0, 0, 0, time.UTC)
+	return int(atDay.Sub(startDay).Hours() / 24)
+}
+
+// HandleGetRecipeSchedule returns the timeline of a recipe. It starts at
+// start (RFC 3339, default now) or, with ready, ends there.
+func HandleGetRecipeSchedule(w http.ResponseWriter, r *http.Request) {
+	userCtx, ok := r.Context().Value("user").(UserContext)
+	if !ok {
+		http.Error(w, "Unauthorized: user context missing", http.StatusUnauthorized)
+		return
+	}
+
+	recipeID, err := strconv.Atoi(r.PathValue("id"))
+	if err != nil {
+		http.Error(w, "Invalid recipe ID", http.StatusBadRequest)
+		return
+	}
+
+	query := r.URL.Query()
+	if query.Get("start") != "" && query.Get("ready") != "" {
+		http.Error(w, "Pass either start or ready", http.StatusBadRequest)
+		return
+	}
+	var start, ready time.Time
+	for name, value := range map[string]*time.Time{"start": &start, "ready": &ready} {
+		if query.Get(name) == "" {
+			continue
+		}
+		*value, err = time.Parse(time.RFC3339, query.Get(name))
+		if err != nil {
+			http.Error(w, name+" must be an RFC 3339 time", http.StatusBadRequest)
+			return
+		}
+	}
+
+	steps, err := recipeScheduleSteps(r.Context(), userCtx.UserID, recipeID)
+	if err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			http.Error(w, "Recipe not found", http.StatusNotFound)
+			return
+		}
+		log.Printf("Error getting schedule of recipe %d: %v\n", recipeID, err)
+		http.Error(w, "Error getting schedule", http.StatusInternalServerError)
+		return
+	}
+
+	var schedule RecipeSchedule
+	switch {
+	case !ready.IsZero():
+		schedule = scheduleReadyAt(recipeID, steps, ready)
+	case !start.IsZero():
+		schedule = buildSchedule(recipeID, steps, start)
+	default:
+		schedule = buildSchedule(recipeID, steps, time.Now().Truncate(time.Minute))
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+
+	err = json.NewEncoder(w).Encode(schedule)
+	if err != nil {
+		http.Error(w, "Error encoding JSON response", http.StatusInternalServerError)
+	}
+}
+
+// mealPlanSchedules lays out the schedules of the entries that asked for
+// one, keyed by entry. An entry whose schedule can't be extracted is left
+// out so the feed still works.
+func mealPlanSchedules(ctx context.Context, userID int, entries []MealPlanEntry) map[int64]RecipeSchedule {
+	schedules := map[int64]RecipeSchedule{}
+	for _, entry := range entries {
+		if !entry.Schedule {
+			continue
+		}
+		steps, err := recipeScheduleSteps(ctx, userID, entry.RecipeID)
+		if err != nil {
+			log.Printf("Error getting schedule of recipe %d: %v\n", entry.RecipeID, err)
+			continue
+		}
+		day, _ := time.Parse(time.DateOnly, entry.Day)
+		schedules[entry.ID] = scheduleReadyAt(entry.RecipeID, steps, day.Add(mealTimes[entry.Meal]))
+	}
+	return schedules
+}
@@ -0,0 +1,187 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"log"
+	"net/http"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgxpool"
+)
+
+// schedulerTick is how often the leader checks for due jobs, job intervals
+// are rounded up to it.
+const schedulerTick = time.Minute
+
+// usageRetention is how long daily generation counts are kept before they
+// are rolled up into monthly totals.
+const usageRetention = 90 * 24 * time.Hour
+
+type scheduledJob struct {
+	Name     string
+	Interval time.Duration
+	Run      func(ctx context.Context) error
+}
+
+// scheduledJobs are run by one instance at a time. When and how a job last
+// ran is kept in scheduled_jobs, so a restart or a new leader doesn't run
+// jobs early.
+var scheduledJobs = []scheduledJob{
+	{Name: "weekly-mails", Interval: time.Hour, Run: sendWeeklyMails},
+	{Name: "seasonal-refresh", Interval: time.Hour, Run: refreshSeasonalContent},
+	{Name: "meal-plan-refresh", Interval: time.Hour, Run: refreshMealPlanPages},
+	{Name: "usage-aggregation", Interval: 24 * time.Hour, Run: aggregateGenerationUsage},
+	{Name: "source-recheck", Interval: 6 * time.Hour, Run: recheckSources},
+	{Name: "diet-classification", Interval: 10 * time.Minute, Run: classifyRecipeDiets},
+	{Name: "cuisine-classification", Interval: 10 * time.Minute, Run: classifyAllCuisines},
+	{Name: "generation-expiry", Interval: 24 * time.Hour, Run: expireGenerations},
+	{Name: "ingredient-dictionary", Interval: 24 * time.Hour, Run: populateIngredients},
+	{Name: "dead-links", Interval: 24 * time.Hour, Run: checkDeadLinks},
+	{Name: "storage-lifecycle", Interval: 24 * time.Hour, Run: applyStorageLifecycles},
+	{Name: "storage-inventory", Interval: 24 * time.Hour, Run: inventoryStorage},
+}
+
+type JobStatus struct {
+	Name      string     `json:"name"`
+	Interval  string     `json:"interval"`
+	LastRunAt *time.Time `json:"lastRunAt,omitempty"`
+	Duration  string     `json:"duration,omitempty"`
+	LastError string     `json:"lastError,omitempty"`
+}
+
+// runScheduler runs due jobs while this instance holds the scheduler lock.
+// The lock lives in a Postgres session, so a crashed leader frees it and the
+// next instance to try takes over.
+func runScheduler() {
+	ticker := time.NewTicker(schedulerTick)
+	defer ticker.Stop()
+
+	var leader *pgxpool.Conn
+	for range ticker.C {
+		ctx := context.Background()
+
+		if leader != nil {
+			if _, err := leader.Exec(ctx, "SELECT 1"); err != nil {
+				log.Printf("Lost scheduler leadership: %v\n", err)
+				_ = leader.Conn().Close(ctx)
+				leader.Release()
+				leader = nil
+			}
+		}
+
+		if leader == nil {
+			conn, err := pool.Acquire(ctx)
+			if err != nil {
+				log.Printf("Error acquiring connection for scheduler: %v\n", err)
+				continue
+			}
+
+			var acquired bool
+			err = conn.QueryRow(ctx, "SELECT pg_try_advisory_lock($1, 0)", schedulerLockNamespace).Scan(&acquired)
+			if err != nil || !acquired {
+				if err != nil {
+					log.Printf("Error taking scheduler lock: %v\n", err)
+				}
+				conn.Release()
+				continue
+			}
+			log.Println("This instance runs the scheduled jobs")
+			leader = conn
+		}
+
+		for _, job := range scheduledJobs {
+			runJobIfDue(ctx, job)
+		}
+	}
+}
+
+func runJobIfDue(ctx context.Context, job scheduledJob) {
+	var due bool
+	err := pool.QueryRow(ctx, `
+		SELECT coalesce((SELECT last_run_at < now() - $2 * interval '1 second' FROM scheduled_jobs WHERE name = $1), true)`,
+		job.Name, int64(job.Interval.Seconds())).Scan(&due)
+	if err != nil {
+		log.Printf("Error checking job %s: %v\n", job.Name, err)
+		return
+	}
+	if !due {
+		return
+	}
+
+	start := time.Now()
+	runErr := job.Run(ctx)
+	lastError := ""
+	if runErr != nil {
+		log.Printf("Error running job %s: %v\n", job.Name, runErr)
+		lastError = runErr.Error()
+	}
+
+	_, err = pool.Exec(ctx, `
+		INSERT INTO scheduled_jobs (name, last_run_at, duration_ms, last_error) VALUES ($1, $2, $3, $4)
+		ON CONFLICT (name) DO UPDATE SET last_run_at = $2, duration_ms = $3, last_error = $4`,
+		job.Name, start, time.Since(start).Milliseconds(), lastError)
+	if err != nil {
+		log.Printf("Error recording run of job %s: %v\n", job.Name, err)
+	}
+}
+
+// aggregateGenerationUsage rolls old daily counts into generation_usage_monthly,
+// the plan limits only look at the current day.
+func aggregateGenerationUsage(ctx context.Context) error {
+	tx, err := pool.Begin(ctx)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback(ctx)
+
+	cutoff := time.Now().Add(-usageRetention)
+	_, err = tx.Exec(ctx, `
+		INSERT INTO generation_usage_monthly (user_id, month, count)
+		SELECT user_id, date_trunc('month', day)::date, sum(count) FROM generation_usage WHERE day < $1
+		GROUP BY user_id, date_trunc('month', day)
+		ON CONFLICT (user_id, month) DO UPDATE SET count = generation_usage_monthly.count + excluded.count`, cutoff)
+	if err != nil {
+		return err
+	}
+
+	_, err = tx.Exec(ctx, "DELETE FROM generation_usage WHERE day < $1", cutoff)
+	if err != nil {
+		return err
+	}
+
+	return tx.Commit(ctx)
+}
+
+func HandleListJobs(w http.ResponseWriter, r *http.Request) {
+	statuses := make([]JobStatus, 0, len(scheduledJobs))
+	for _, job := range scheduledJobs {
+		status := JobStatus{Name: job.Name, Interval: job.Interval.String()}
+
+		var lastRunAt time.Time
+		var durationMs int64
+		err := pool.QueryRow(r.Context(), "SELECT last_run_at, duration_ms, last_error FROM scheduled_jobs WHERE name = $1",
+			job.Name).Scan(&lastRunAt, &durationMs, &status.LastError)
+		switch {
+		case err == nil:
+			status.LastRunAt = &lastRunAt
+			status.Duration = (time.Duration(durationMs) * time.Millisecond).String()
+		case !errors.Is(err, pgx.ErrNoRows):
+			log.Printf("Error getting job status: %v\n", err)
+			http.Error(w, "Error getting jobs", http.StatusInternalServerError)
+			return
+		}
+
+		statuses = append(statuses, status)
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+
+	err := json.NewEncoder(w).Encode(statuses)
+	if err != nil {
+		http.Error(w, "Error encoding JSON response", http.StatusInternalServerError)
+	}
+}
@@ -0,0 +1,361 @@
+package main
+
+import (
+	"context"
+	"log"
+)
+
+// schemaMigrations are applied in order on every start, so each statement has
+// to be idempotent. Append new statements, never edit released ones.
+var schemaMigrations = []string{
+	`ALTER TABLE users ADD COLUMN IF NOT EXISTS provisioning_status text NOT NULL DEFAULT 'ready'`,
+	`ALTER TABLE users ADD COLUMN IF NOT EXISTS provisioning_step integer NOT NULL DEFAULT 0`,
+	`ALTER TABLE users ADD COLUMN IF NOT EXISTS provisioning_error text NOT NULL DEFAULT ''`,
+	`ALTER TABLE users ADD COLUMN IF NOT EXISTS storage_mode text NOT NULL DEFAULT 'dedicated'`,
+	`ALTER TABLE users ADD COLUMN IF NOT EXISTS site_updated_at timestamptz NOT NULL DEFAULT now()`,
+	`ALTER TABLE recipes ADD COLUMN IF NOT EXISTS updated_at timestamptz NOT NULL DEFAULT now()`,
+	`ALTER TABLE recipes ADD COLUMN IF NOT EXISTS slug text NOT NULL DEFAULT ''`,
+	`CREATE UNIQUE INDEX IF NOT EXISTS recipes_user_slug_idx ON recipes (user_id, slug) WHERE slug <> ''`,
+	`ALTER TABLE recipes ADD COLUMN IF NOT EXISTS version integer NOT NULL DEFAULT 1`,
+	// users from before plans keep what they had, new users start free
+	`ALTER TABLE users ADD COLUMN IF NOT EXISTS plan text NOT NULL DEFAULT 'legacy'`,
+	`ALTER TABLE users ALTER COLUMN plan SET DEFAULT 'free'`,
+	`CREATE TABLE IF NOT EXISTS generation_usage (
+		user_id integer NOT NULL REFERENCES users (id) ON DELETE CASCADE,
+		day date NOT NULL,
+		count integer NOT NULL DEFAULT 0,
+		PRIMARY KEY (user_id, day)
+	)`,
+	`CREATE TABLE IF NOT EXISTS webhooks (
+		id serial PRIMARY KEY,
+		user_id integer NOT NULL REFERENCES users (id) ON DELETE CASCADE,
+		url text NOT NULL,
+		secret text NOT NULL,
+		events text[] NOT NULL,
+		created_at timestamptz NOT NULL DEFAULT now()
+	)`,
+	`ALTER TABLE recipes ADD COLUMN IF NOT EXISTS created_at timestamptz NOT NULL DEFAULT now()`,
+	`CREATE TABLE IF NOT EXISTS notification_settings (
+		user_id integer PRIMARY KEY REFERENCES users (id) ON DELETE CASCADE,
+		weekly_digest boolean NOT NULL DEFAULT false,
+		weekly_suggestions boolean NOT NULL DEFAULT false,
+		last_weekly_at timestamptz
+	)`,
+	`CREATE TABLE IF NOT EXISTS user_openai_keys (
+		user_id integer PRIMARY KEY REFERENCES users (id) ON DELETE CASCADE,
+		encrypted_key bytea NOT NULL,
+		key_hint text NOT NULL DEFAULT '',
+		base_url text NOT NULL DEFAULT '',
+		enabled boolean NOT NULL DEFAULT true,
+		created_at timestamptz NOT NULL DEFAULT now(),
+		updated_at timestamptz NOT NULL DEFAULT now()
+	)`,
+	`CREATE TABLE IF NOT EXISTS published_blobs (
+		user_id integer NOT NULL REFERENCES users (id) ON DELETE CASCADE,
+		blob text NOT NULL,
+		content_hash text NOT NULL,
+		updated_at timestamptz NOT NULL DEFAULT now(),
+		PRIMARY KEY (user_id, blob)
+	)`,
+	`CREATE TABLE IF NOT EXISTS recipe_ask_sessions (
+		id uuid PRIMARY KEY DEFAULT gen_random_uuid(),
+		user_id integer NOT NULL REFERENCES users (id) ON DELETE CASCADE,
+		recipe_id integer NOT NULL REFERENCES recipes (id) ON DELETE CASCADE,
+		created_at timestamptz NOT NULL DEFAULT now()
+	)`,
+	`CREATE TABLE IF NOT EXISTS recipe_ask_messages (
+		id bigserial PRIMARY KEY,
+		session_id uuid NOT NULL REFERENCES recipe_ask_sessions (id) ON DELETE CASCADE,
+		role text NOT NULL,
+		content text NOT NULL,
+		created_at timestamptz NOT NULL DEFAULT now()
+	)`,
+	`CREATE INDEX IF NOT EXISTS recipe_ask_messages_session_idx ON recipe_ask_messages (session_id, id)`,
+	`ALTER TABLE recipes ADD COLUMN IF NOT EXISTS season_tags text[] NOT NULL DEFAULT '{}'`,
+	`ALTER TABLE recipes ADD COLUMN IF NOT EXISTS season_tagged_at timestamptz`,
+	`ALTER TABLE users ADD COLUMN IF NOT EXISTS season_published_for text NOT NULL DEFAULT ''`,
+	`ALTER TABLE recipes ADD COLUMN IF NOT EXISTS prep_minutes integer NOT NULL DEFAULT 0`,
+	`ALTER TABLE recipes ADD COLUMN IF NOT EXISTS cook_minutes integer NOT NULL DEFAULT 0`,
+	`ALTER TABLE recipes ADD COLUMN IF NOT EXISTS total_minutes integer NOT NULL DEFAULT 0`,
+	`ALTER TABLE recipes ADD COLUMN IF NOT EXISTS difficulty text NOT NULL DEFAULT ''`,
+	`CREATE TABLE IF NOT EXISTS recipe_revisions (
+		id bigserial PRIMARY KEY,
+		recipe_id integer NOT NULL REFERENCES recipes (id) ON DELETE CASCADE,
+		user_id integer NOT NULL REFERENCES users (id) ON DELETE CASCADE,
+		base_version integer NOT NULL,
+		change_prompt text NOT NULL,
+		content text NOT NULL,
+		status text NOT NULL DEFAULT 'pending',
+		created_at timestamptz NOT NULL DEFAULT now(),
+		decided_at timestamptz
+	)`,
+	`CREATE INDEX IF NOT EXISTS recipe_revisions_recipe_idx ON recipe_revisions (recipe_id, id)`,
+	`CREATE TABLE IF NOT EXISTS scheduled_jobs (
+		name text PRIMARY KEY,
+		last_run_at timestamptz NOT NULL,
+		duration_ms bigint NOT NULL DEFAULT 0,
+		last_error text NOT NULL DEFAULT ''
+	)`,
+	`CREATE TABLE IF NOT EXISTS generation_usage_monthly (
+		user_id integer NOT NULL REFERENCES users (id) ON DELETE CASCADE,
+		month date NOT NULL,
+		count integer NOT NULL DEFAULT 0,
+		PRIMARY KEY (user_id, month)
+	)`,
+	`CREATE TABLE IF NOT EXISTS meal_plan_entries (
+		id bigserial PRIMARY KEY,
+		user_id integer NOT NULL REFERENCES users (id) ON DELETE CASCADE,
+		recipe_id integer NOT NULL REFERENCES recipes (id) ON DELETE CASCADE,
+		day date NOT NULL,
+		meal text NOT NULL DEFAULT 'dinner',
+		note text NOT NULL DEFAULT '',
+		created_at timestamptz NOT NULL DEFAULT now()
+	)`,
+	`CREATE INDEX IF NOT EXISTS meal_plan_entries_user_day_idx ON meal_plan_entries (user_id, day)`,
+	`ALTER TABLE users ADD COLUMN IF NOT EXISTS calendar_token text NOT NULL DEFAULT ''`,
+	`CREATE UNIQUE INDEX IF NOT EXISTS users_calendar_token_idx ON users (calendar_token) WHERE calendar_token <> ''`,
+	`CREATE TABLE IF NOT EXISTS api_keys (
+		id bigserial PRIMARY KEY,
+		user_id integer NOT NULL REFERENCES users (id) ON DELETE CASCADE,
+		name text NOT NULL,
+		key_hash text NOT NULL UNIQUE,
+		hint text NOT NULL,
+		created_at timestamptz NOT NULL DEFAULT now(),
+		last_used_at timestamptz
+	)`,
+	`ALTER TABLE recipes ADD COLUMN IF NOT EXISTS source_url text NOT NULL DEFAULT ''`,
+	`CREATE INDEX IF NOT EXISTS recipes_source_url_idx ON recipes (user_id, source_url) WHERE source_url <> ''`,
+	`ALTER TABLE users ADD COLUMN IF NOT EXISTS inbound_token text NOT NULL DEFAULT ''`,
+	`CREATE UNIQUE INDEX IF NOT EXISTS users_inbound_token_idx ON users (inbound_token) WHERE inbound_token <> ''`,
+	`ALTER TABLE recipes ADD COLUMN IF NOT EXISTS language text NOT NULL DEFAULT ''`,
+	`ALTER TABLE recipes ADD COLUMN IF NOT EXISTS translation_of integer REFERENCES recipes (id) ON DELETE CASCADE`,
+	`CREATE UNIQUE INDEX IF NOT EXISTS recipes_translation_idx ON recipes (translation_of, language) WHERE translation_of IS NOT NULL`,
+	`CREATE TABLE IF NOT EXISTS source_archives (
+		user_id integer NOT NULL REFERENCES users (id) ON DELETE CASCADE,
+		url text NOT NULL,
+		content text NOT NULL,
+		fetched_at timestamptz NOT NULL DEFAULT now(),
+		PRIMARY KEY (user_id, url)
+	)`,
+	`ALTER TABLE recipes ADD COLUMN IF NOT EXISTS source_checked_at timestamptz`,
+	`ALTER TABLE recipes ADD COLUMN IF NOT EXISTS cuisine text NOT NULL DEFAULT ''`,
+	`CREATE TABLE IF NOT EXISTS audit_log (
+		id bigserial PRIMARY KEY,
+		user_id integer NOT NULL REFERENCES users (id) ON DELETE CASCADE,
+		actor text NOT NULL,
+		via text NOT NULL,
+		action text NOT NULL,
+		recipe_id integer,
+		slug text NOT NULL DEFAULT '',
+		before jsonb,
+		after jsonb,
+		created_at timestamptz NOT NULL DEFAULT now()
+	)`,
+	`CREATE INDEX IF NOT EXISTS audit_log_user_idx ON audit_log (user_id, id)`,
+	`ALTER TABLE recipes ADD COLUMN IF NOT EXISTS archived boolean NOT NULL DEFAULT false`,
+	`ALTER TABLE recipes ADD COLUMN IF NOT EXISTS featured_at timestamptz`,
+	`CREATE TABLE IF NOT EXISTS index_settings (
+		user_id integer PRIMARY KEY REFERENCES users (id) ON DELETE CASCADE,
+		grouping text NOT NULL DEFAULT 'category',
+		sort text NOT NULL DEFAULT 'added',
+		sections jsonb NOT NULL DEFAULT '{}'
+	)`,
+	`ALTER TABLE users ADD COLUMN IF NOT EXISTS site_indexing boolean NOT NULL DEFAULT true`,
+	`CREATE TABLE IF NOT EXISTS collections (
+		id serial PRIMARY KEY,
+		user_id integer NOT NULL REFERENCES users (id) ON DELETE CASCADE,
+		name text NOT NULL,
+		slug text NOT NULL,
+		description text NOT NULL DEFAULT '',
+		created_at timestamptz NOT NULL DEFAULT now(),
+		UNIQUE (user_id, slug)
+	)`,
+	`CREATE TABLE IF NOT EXISTS collection_recipes (
+		collection_id integer NOT NULL REFERENCES collections (id) ON DELETE CASCADE,
+		recipe_id integer NOT NULL REFERENCES recipes (id) ON DELETE CASCADE,
+		added_at timestamptz NOT NULL DEFAULT now(),
+		PRIMARY KEY (collection_id, recipe_id)
+	)`,
+	`CREATE TABLE IF NOT EXISTS cooking_sessions (
+		id serial PRIMARY KEY,
+		user_id integer NOT NULL REFERENCES users (id) ON DELETE CASCADE,
+		recipe_id integer NOT NULL REFERENCES recipes (id) ON DELETE CASCADE,
+		cooked_at timestamptz NOT NULL DEFAULT now(),
+		notes text NOT NULL DEFAULT '',
+		photo text NOT NULL DEFAULT '',
+		photo_data bytea
+	)`,
+	`CREATE INDEX IF NOT EXISTS cooking_sessions_recipe_idx ON cooking_sessions (recipe_id, cooked_at)`,
+	`ALTER TABLE recipes ADD COLUMN IF NOT EXISTS variant_of integer REFERENCES recipes (id) ON DELETE SET NULL`,
+	`ALTER TABLE recipes ADD COLUMN IF NOT EXISTS appliance text NOT NULL DEFAULT ''`,
+	`CREATE UNIQUE INDEX IF NOT EXISTS recipes_variant_idx ON recipes (variant_of, appliance) WHERE variant_of IS NOT NULL`,
+	`CREATE TABLE IF NOT EXISTS recipe_embeddings (
+		recipe_id integer PRIMARY KEY REFERENCES recipes (id) ON DELETE CASCADE,
+		version integer NOT NULL,
+		embedding real[] NOT NULL
+	)`,
+	`CREATE TABLE IF NOT EXISTS user_settings (
+		user_id integer PRIMARY KEY REFERENCES users (id) ON DELETE CASCADE,
+		language text NOT NULL DEFAULT 'de',
+		units text NOT NULL DEFAULT 'metric',
+		categories text[] NOT NULL DEFAULT '{}',
+		publish_on_save boolean NOT NULL DEFAULT true,
+		model_tier text NOT NULL DEFAULT 'standard',
+		theme text NOT NULL DEFAULT 'system'
+	)`,
+	`ALTER TABLE recipes ADD COLUMN IF NOT EXISTS draft boolean NOT NULL DEFAULT false`,
+	`CREATE TABLE IF NOT EXISTS recipe_schedules (
+		recipe_id integer PRIMARY KEY REFERENCES recipes (id) ON DELETE CASCADE,
+		version integer NOT NULL,
+		steps jsonb NOT NULL DEFAULT '[]'
+	)`,
+	`ALTER TABLE meal_plan_entries ADD COLUMN IF NOT EXISTS schedule boolean NOT NULL DEFAULT false`,
+	`ALTER TABLE user_settings ADD COLUMN IF NOT EXISTS food_safety_notes boolean NOT NULL DEFAULT true`,
+	`ALTER TABLE recipes ADD COLUMN IF NOT EXISTS diet text NOT NULL DEFAULT ''`,
+	`ALTER TABLE recipes ADD COLUMN IF NOT EXISTS community_shared_at timestamptz`,
+	`CREATE INDEX IF NOT EXISTS recipes_community_idx ON recipes (community_shared_at) WHERE community_shared_at IS NOT NULL`,
+	`ALTER TABLE recipes ADD COLUMN IF NOT EXISTS parent_recipe_id integer REFERENCES recipes (id) ON DELETE SET NULL`,
+	`CREATE INDEX IF NOT EXISTS recipes_parent_idx ON recipes (parent_recipe_id) WHERE parent_recipe_id IS NOT NULL`,
+	`CREATE TABLE IF NOT EXISTS invitations (
+		id bigserial PRIMARY KEY,
+		code text UNIQUE,
+		oauth_id text UNIQUE,
+		note text NOT NULL DEFAULT '',
+		created_by integer REFERENCES users (id) ON DELETE SET NULL,
+		created_at timestamptz NOT NULL DEFAULT now(),
+		expires_at timestamptz,
+		redeemed_by integer REFERENCES users (id) ON DELETE SET NULL,
+		redeemed_at timestamptz,
+		CHECK (code IS NOT NULL OR oauth_id IS NOT NULL)
+	)`,
+	`CREATE TABLE IF NOT EXISTS user_identities (
+		oauth_id text PRIMARY KEY,
+		user_id integer NOT NULL REFERENCES users (id) ON DELETE CASCADE,
+		provider text NOT NULL DEFAULT '',
+		linked_at timestamptz NOT NULL DEFAULT now()
+	)`,
+	`CREATE INDEX IF NOT EXISTS user_identities_user_idx ON user_identities (user_id)`,
+	`CREATE TABLE IF NOT EXISTS account_link_codes (
+		user_id integer PRIMARY KEY REFERENCES users (id) ON DELETE CASCADE,
+		code_hash text NOT NULL UNIQUE,
+		expires_at timestamptz NOT NULL
+	)`,
+	`ALTER TABLE audit_log ADD COLUMN IF NOT EXISTS client_ip text NOT NULL DEFAULT ''`,
+	`CREATE TABLE IF NOT EXISTS judge_rejections (
+		id bigserial PRIMARY KEY,
+		user_id integer NOT NULL REFERENCES users (id) ON DELETE CASCADE,
+		mode text NOT NULL,
+		input_hash text NOT NULL,
+		input text NOT NULL,
+		verdict text NOT NULL,
+		model text NOT NULL,
+		reason text NOT NULL DEFAULT '',
+		created_at timestamptz NOT NULL DEFAULT now(),
+		appeal_status text NOT NULL DEFAULT '',
+		appeal_message text NOT NULL DEFAULT '',
+		appealed_at timestamptz,
+		reviewed_by text NOT NULL DEFAULT '',
+		reviewed_at timestamptz
+	)`,
+	`CREATE INDEX IF NOT EXISTS judge_rejections_user_idx ON judge_rejections (user_id, input_hash)`,
+	`CREATE INDEX IF NOT EXISTS judge_rejections_appeal_idx ON judge_rejections (appeal_status, appealed_at) WHERE appeal_status <> ''`,
+	`CREATE TABLE IF NOT EXISTS generations (
+		id bigserial PRIMARY KEY,
+		user_id integer NOT NULL REFERENCES users (id) ON DELETE CASCADE,
+		endpoint text NOT NULL,
+		task text NOT NULL,
+		model text NOT NULL,
+		system text NOT NULL,
+		prompt text NOT NULL,
+		response text NOT NULL,
+		has_image boolean NOT NULL DEFAULT false,
+		retry_of bigint REFERENCES generations (id) ON DELETE SET NULL,
+		created_at timestamptz NOT NULL DEFAULT now()
+	)`,
+	`CREATE INDEX IF NOT EXISTS generations_created_idx ON generations (created_at)`,
+	`CREATE TABLE IF NOT EXISTS generation_feedback (
+		generation_id bigint PRIMARY KEY REFERENCES generations (id) ON DELETE CASCADE,
+		user_id integer NOT NULL REFERENCES users (id) ON DELETE CASCADE,
+		rating text NOT NULL,
+		comment text NOT NULL DEFAULT '',
+		created_at timestamptz NOT NULL DEFAULT now(),
+		updated_at timestamptz NOT NULL DEFAULT now()
+	)`,
+	`CREATE TABLE IF NOT EXISTS experiments (
+		id bigserial PRIMARY KEY,
+		name text NOT NULL UNIQUE,
+		task text NOT NULL,
+		model text NOT NULL DEFAULT '',
+		instruction text NOT NULL DEFAULT '',
+		percent integer NOT NULL,
+		created_at timestamptz NOT NULL DEFAULT now(),
+		ended_at timestamptz
+	)`,
+	`CREATE UNIQUE INDEX IF NOT EXISTS experiments_running_idx ON experiments (task) WHERE ended_at IS NULL`,
+	`ALTER TABLE generations ADD COLUMN IF NOT EXISTS latency_ms bigint NOT NULL DEFAULT 0`,
+	`ALTER TABLE generations ADD COLUMN IF NOT EXISTS experiment_id bigint REFERENCES experiments (id) ON DELETE SET NULL`,
+	`ALTER TABLE generations ADD COLUMN IF NOT EXISTS variant text NOT NULL DEFAULT ''`,
+	`CREATE INDEX IF NOT EXISTS generations_experiment_idx ON generations (experiment_id) WHERE experiment_id IS NOT NULL`,
+	`ALTER TABLE generations ADD COLUMN IF NOT EXISTS provider text NOT NULL DEFAULT 'openai'`,
+	`ALTER TABLE user_settings ADD COLUMN IF NOT EXISTS cookbook_context boolean NOT NULL DEFAULT true`,
+	`CREATE TABLE IF NOT EXISTS ingredients (
+		id bigserial PRIMARY KEY,
+		name_de text NOT NULL,
+		name_en text NOT NULL DEFAULT '',
+		default_unit text NOT NULL DEFAULT '',
+		synonyms text[] NOT NULL DEFAULT '{}',
+		created_at timestamptz NOT NULL DEFAULT now()
+	)`,
+	`CREATE UNIQUE INDEX IF NOT EXISTS ingredients_name_idx ON ingredients (lower(name_de))`,
+	`CREATE TABLE IF NOT EXISTS categories (
+		user_id integer NOT NULL REFERENCES users (id) ON DELETE CASCADE,
+		name text NOT NULL,
+		display_name text NOT NULL DEFAULT '',
+		emoji text NOT NULL DEFAULT '',
+		sort_order integer NOT NULL DEFAULT 0,
+		labels jsonb NOT NULL DEFAULT '{}',
+		PRIMARY KEY (user_id, name)
+	)`,
+	`ALTER TABLE recipes ADD COLUMN IF NOT EXISTS published_at timestamptz`,
+	`ALTER TABLE recipes ADD COLUMN IF NOT EXISTS publish_error text NOT NULL DEFAULT ''`,
+	`CREATE TABLE IF NOT EXISTS slug_redirects (
+		user_id integer NOT NULL REFERENCES users (id) ON DELETE CASCADE,
+		old_slug text NOT NULL,
+		recipe_id integer NOT NULL REFERENCES recipes (id) ON DELETE CASCADE,
+		created_at timestamptz NOT NULL DEFAULT now(),
+		PRIMARY KEY (user_id, old_slug)
+	)`,
+	`CREATE TABLE IF NOT EXISTS storage_usage (
+		user_id integer PRIMARY KEY REFERENCES users (id) ON DELETE CASCADE,
+		blob_count bigint NOT NULL,
+		total_bytes bigint NOT NULL,
+		measured_at timestamptz NOT NULL DEFAULT now()
+	)`,
+	`ALTER TABLE notification_settings ADD COLUMN IF NOT EXISTS created_at timestamptz NOT NULL DEFAULT now()`,
+	`ALTER TABLE recipes ADD COLUMN IF NOT EXISTS season_tag_failures integer NOT NULL DEFAULT 0`,
+	`ALTER TABLE recipes ADD COLUMN IF NOT EXISTS season_tag_failed_at timestamptz`,
+	`ALTER TABLE users ADD COLUMN IF NOT EXISTS plan_published_for text NOT NULL DEFAULT ''`,
+	`CREATE TABLE IF NOT EXISTS source_rechecks (
+		user_id integer NOT NULL REFERENCES users (id) ON DELETE CASCADE,
+		url text NOT NULL,
+		content text NOT NULL,
+		checked_at timestamptz NOT NULL DEFAULT now(),
+		PRIMARY KEY (user_id, url)
+	)`,
+	`ALTER TABLE recipes ADD COLUMN IF NOT EXISTS cuisine_checked_at timestamptz`,
+	`ALTER TABLE user_settings ADD COLUMN IF NOT EXISTS community_name text NOT NULL DEFAULT ''`,
+	`CREATE TABLE IF NOT EXISTS link_code_attempts (
+		user_id integer PRIMARY KEY REFERENCES users (id) ON DELETE CASCADE,
+		day date NOT NULL,
+		count integer NOT NULL DEFAULT 0
+	)`,
+}
+
+func migrateDB() {
+	for _, statement := range schemaMigrations {
+		_, err := pool.Exec(context.Background(), statement)
+		if err != nil {
+			log.Fatalf("Unable to apply schema migration %q: %v\n", statement, err)
+		}
+	}
+}
@@ -0,0 +1,347 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+)
+
+const (
+	seasonSpring = "spring"
+	seasonSummer = "summer"
+	seasonAutumn = "autumn"
+	seasonWinter = "winter"
+
+	holidayChristmas  = "christmas"
+	holidayEaster     = "easter"
+	holidayHalloween  = "halloween"
+	holidayValentines = "valentines"
+	holidayNewYear    = "newyear"
+)
+
+var seasonalTagLabels = map[string]string{
+	seasonSpring:      "🌱 Frühling",
+	seasonSummer:      "☀️ Sommer",
+	seasonAutumn:      "🍂 Herbst",
+	seasonWinter:      "❄️ Winter",
+	holidayChristmas:  "🎄 Weihnachten",
+	holidayEaster:     "🐣 Ostern",
+	holidayHalloween:  "🎃 Halloween",
+	holidayValentines: "💝 Valentinstag",
+	holidayNewYear:    "🎆 Silvester & Neujahr",
+}
+
+var germanMonths = []string{"Januar", "Februar", "März", "April", "Mai", "Juni", "Juli", "August", "September", "Oktober", "November", "Dezember"}
+
+// seasonalTagBatch is how many untagged recipes one job run sends to the LLM.
+const seasonalTagBatch = 50
+
+// A recipe the LLM fails to classify is tried again after
+// seasonalTagRetryDelay, after seasonalTagMaxFailures failures it stays
+// untagged until it is edited. Until then it would take a place in every
+// batch.
+const (
+	seasonalTagRetryDelay  = 24 * time.Hour
+	seasonalTagMaxFailures = 3
+)
+
+const seasonalTagSystemMessage = `You classify recipes by season and holiday for a German cookbook.
+Answer with a JSON object {"seasons": [...], "holidays": [...]}.
+seasons may contain "spring", "summer", "autumn", "winter": the seasons whose produce or weather the dish suits. Leave it empty for dishes that fit all year.
+holidays may contain "christmas", "easter", "halloween", "valentines", "newyear": only holidays the dish is traditional or typical for.`
+
+type SeasonalCollection struct {
+	Month   string   `json:"month"`
+	Tags    []string `json:"tags"`
+	Recipes []Recipe `json:"recipes"`
+}
+
+// seasonalTagsForMonth returns the season and the holidays that are coming
+// up in the given month (northern hemisphere).
+func seasonalTagsForMonth(t time.Time) []string {
+	var tags []string
+
+	switch t.Month() {
+	case time.March, time.April, time.May:
+		tags = append(tags, seasonSpring)
+	case time.June, time.July, time.August:
+		tags = append(tags, seasonSummer)
+	case time.September, time.October, time.November:
+		tags = append(tags, seasonAutumn)
+	default:
+		tags = append(tags, seasonWinter)
+	}
+
+	switch t.Month() {
+	case time.January:
+		tags = append(tags, holidayNewYear)
+	case time.February:
+		tags = append(tags, holidayValentines)
+	case time.October:
+		tags = append(tags, holidayHalloween)
+	case time.November:
+		tags = append(tags, holidayChristmas)
+	case time.December:
+		tags = append(tags, holidayChristmas, holidayNewYear)
+	}
+
+	// Easter falls in March or April, the collection shows up in the month
+	// before Easter too.
+	easter := easterSunday(t.Year())
+	if t.Month() == easter.Month() || t.Month() == easter.AddDate(0, -1, 0).Month() {
+		tags = append(tags, holidayEaster)
+	}
+
+	return tags
+}
+
+// easterSunday uses the anonymous Gregorian algorithm.
+func easterSunday(year int) time.Time {
+	a := year % 19
+	b := year / 100
+	c := year % 100
+	d := b / 4
+	e := b % 4
+	f := (b + 8) / 25
+	g := (b - f + 1) / 3
+	h := (19*a + b - d - g + 15) % 30
+	i := c / 4
+	k := c % 4
+	l := (32 + 2*e + 2*i - h - k) % 7
+	m := (a + 11*h + 22*l) / 451
+	month := (h + l - 7*m + 114) / 31
+	day := (h+l-7*m+114)%31 + 1
+	return time.Date(year, time.Month(month), day, 0, 0, 0, 0, time.UTC)
+}
+
+func seasonalRecipes(ctx context.Context, userID int, tags []string) ([]Recipe, error) {
+	rows, err := pool.Query(ctx, "SELECT id, title, slug, category, season_tags FROM recipes WHERE user_id = $1 AND season_tags && $2 AND translation_of IS NULL AND NOT archived AND NOT draft ORDER BY title",
+		userID, tags)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	recipes := []Recipe{}
+	for rows.Next() {
+		var recipe Recipe
+		if err := rows.Scan(&recipe.ID, &recipe.Recipename, &recipe.Slug, &recipe.Category, &recipe.SeasonTags); err != nil {
+			return nil, err
+		}
+		recipes = append(recipes, recipe)
+	}
+	return recipes, rows.Err()
+}
+
+func HandleGetSeasonal(w http.ResponseWriter, r *http.Request) {
+	userCtx, ok := r.Context().Value("user").(UserContext)
+	if !ok {
+		http.Error(w, "Unauthorized: user context missing", http.StatusUnauthorized)
+		return
+	}
+
+	now := time.Now()
+	if month := r.URL.Query().Get("month"); month != "" {
+		m, err := strconv.Atoi(month)
+		if err != nil || m < 1 || m > 12 {
+			http.Error(w, "month must be 1-12", http.StatusBadRequest)
+			return
+		}
+		now = time.Date(now.Year(), time.Month(m), 1, 0, 0, 0, 0, time.UTC)
+	}
+
+	tags := seasonalTagsForMonth(now)
+	recipes, err := seasonalRecipes(r.Context(), userCtx.UserID, tags)
+	if err != nil {
+		log.Printf("Error getting seasonal recipes: %v\n", err)
+		http.Error(w, "Error getting seasonal recipes", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+
+	err = json.NewEncoder(w).Encode(SeasonalCollection{
+		Month:   germanMonths[now.Month()-1],
+		Tags:    tags,
+		Recipes: recipes,
+	})
+	if err != nil {
+		http.Error(w, "Error encoding JSON response", http.StatusInternalServerError)
+	}
+}
+
+func renderSeasonalPage(ctx context.Context, userID int, now time.Time) (string, error) {
+	tags := seasonalTagsForMonth(now)
+	recipes, err := seasonalRecipes(ctx, userID, tags)
+	if err != nil {
+		return "", err
+	}
+
+	var page strings.Builder
+	page.WriteString("# Saisonal im " + germanMonths[now.Month()-1] + "\n\n[Alle Rezepte](./)\n")
+	for _, tag := range tags {
+		var section strings.Builder
+		for _, recipe := range recipes {
+			for _, recipeTag := range recipe.SeasonTags {
+				if recipeTag == tag {
+					section.WriteString("- [" + recipe.Recipename + "](./?recipe=" + recipe.Slug + ")\n")
+					break
+				}
+			}
+		}
+		if section.Len() > 0 {
+			page.WriteString("\n" + seasonalTagLabels[tag] + "\n" + section.String())
+		}
+	}
+
+	return page.String(), nil
+}
+
+// refreshSeasonalContent tags new and changed recipes and republishes
+// saison.md once a month per user, it runs as a scheduled job.
+func refreshSeasonalContent(ctx context.Context) error {
+	if err := tagUntaggedRecipes(ctx); err != nil {
+		log.Printf("Error tagging recipes with seasons: %v\n", err)
+	}
+	return publishSeasonalPages(ctx)
+}
+
+func tagUntaggedRecipes(ctx context.Context) error {
+	rows, err := pool.Query(ctx, `
+		SELECT id, title, content FROM recipes
+		WHERE season_tagged_at IS NULL AND season_tag_failures < $1 AND (season_tag_failed_at IS NULL OR season_tag_failed_at < $2)
+		ORDER BY season_tag_failures, id LIMIT $3`,
+		seasonalTagMaxFailures, time.Now().Add(-seasonalTagRetryDelay), seasonalTagBatch)
+	if err != nil {
+		return err
+	}
+
+	var pending []Recipe
+	for rows.Next() {
+		var recipe Recipe
+		if err := rows.Scan(&recipe.ID, &recipe.Recipename, &recipe.Recipe); err != nil {
+			rows.Close()
+			return err
+		}
+		pending = append(pending, recipe)
+	}
+	rows.Close()
+
+	for _, recipe := range pending {
+		tags, err := classifySeasonTags(ctx, recipe.Recipename, recipe.Recipe)
+		if err != nil {
+			log.Printf("Error tagging recipe %d with seasons: %v\n", recipe.ID, err)
+			_, err = pool.Exec(ctx, "UPDATE recipes SET season_tag_failures = season_tag_failures + 1, season_tag_failed_at = now() WHERE id = $1", recipe.ID)
+			if err != nil {
+				return err
+			}
+			continue
+		}
+
+		var userID int
+		err = pool.QueryRow(ctx, "UPDATE recipes SET season_tags = $1, season_tagged_at = now() WHERE id = $2 RETURNING user_id", tags, recipe.ID).Scan(&userID)
+		if errors.Is(err, pgx.ErrNoRows) {
+			// deleted while it was classified
+			continue
+		}
+		if err != nil {
+			return err
+		}
+		// the tags group the index, see indexGroupTag
+		invalidateRecipes(userID)
+	}
+
+	return nil
+}
+
+// classifySeasonTags asks the LLM for the recipe's seasons and holidays and
+// drops anything that isn't a known tag.
+func classifySeasonTags(ctx context.Context, title string, content string) ([]string, error) {
+	var answer struct {
+		Seasons  []string `json:"seasons"`
+		Holidays []string `json:"holidays"`
+	}
+	err := completeJSON(ctx, llmRequest{
+		Task:   llmTaskSeasonTags,
+		System: seasonalTagSystemMessage,
+		Prompt: "# " + title + "\n\n" + content,
+	}, &answer)
+	if err != nil {
+		return nil, err
+	}
+
+	tags := []string{}
+	for _, tag := range append(answer.Seasons, answer.Holidays...) {
+		if _, known := seasonalTagLabels[tag]; known {
+			tags = append(tags, tag)
+		}
+	}
+	return tags, nil
+}
+
+func publishSeasonalPages(ctx context.Context) error {
+	now := time.Now()
+	month := now.Format("2006-01")
+
+	rows, err := pool.Query(ctx, "SELECT id, subdomain FROM users WHERE provisioning_status = $1 AND season_published_for <> $2",
+		provisioningReady, month)
+	if err != nil {
+		return err
+	}
+
+	type dueUser struct {
+		id        int
+		subdomain string
+	}
+	var due []dueUser
+	for rows.Next() {
+		var u dueUser
+		if err := rows.Scan(&u.id, &u.subdomain); err != nil {
+			rows.Close()
+			return err
+		}
+		due = append(due, u)
+	}
+	rows.Close()
+
+	for _, u := range due {
+		page, err := renderSeasonalPage(ctx, u.id, now)
+		if err != nil {
+			log.Printf("Error rendering seasonal page for user %d: %v\n", u.id, err)
+			continue
+		}
+
+		// The year in review is refreshed along with it, it changes as slowly.
+		stats, err := recipeStats(ctx, u.id, now)
+		if err != nil {
+			log.Printf("Error getting stats for user %d: %v\n", u.id, err)
+			continue
+		}
+
+		err = withUserLock(ctx, publishLockNamespace, u.id, func() error {
+			if err := addBlob(u.subdomain, "stats.md", renderStatsPage(stats, now)); err != nil {
+				return err
+			}
+			return addBlob(u.subdomain, "saison.md", page)
+		})
+		if err != nil {
+			log.Printf("Error publishing seasonal page for user %d: %v\n", u.id, err)
+			continue
+		}
+
+		_, err = pool.Exec(ctx, "UPDATE users SET season_published_for = $1 WHERE id = $2", month, u.id)
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,142 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/Azure/azure-sdk-for-go/sdk/azcore"
+	"github.com/Azure/azure-sdk-for-go/sdk/azidentity"
+	"github.com/Azure/azure-sdk-for-go/sdk/security/keyvault/azsecrets"
+)
+
+var errSecretNotFound = errors.New("secret not found")
+
+// secretsProvider looks secrets up by their environment variable name,
+// e.g. OPENAI_KEY.
+type secretsProvider interface {
+	GetSecret(ctx context.Context, name string) (string, error)
+}
+
+type envSecrets struct{}
+
+// keyVaultSecrets stores OPENAI_KEY as the Key Vault secret "openai-key",
+// since secret names may not contain underscores.
+type keyVaultSecrets struct {
+	client *azsecrets.Client
+}
+
+// fallbackSecrets asks each provider in turn until one knows the secret.
+type fallbackSecrets []secretsProvider
+
+var secrets secretsProvider = envSecrets{}
+
+// openAIKeyCacheTTL bounds how long a rotated OPENAI_KEY takes to be picked up.
+const openAIKeyCacheTTL = 5 * time.Minute
+
+var openAIKeyCache struct {
+	sync.Mutex
+	key       string
+	found     bool
+	fetchedAt time.Time
+}
+
+// initSecrets uses Key Vault when KEY_VAULT_URL is set, secrets missing there
+// are still read from the environment.
+func initSecrets() {
+	vaultURL := os.Getenv("KEY_VAULT_URL")
+	if vaultURL == "" {
+		return
+	}
+
+	cred, err := azidentity.NewDefaultAzureCredential(nil)
+	if err != nil {
+		log.Fatalf("Failed to obtain a credential for Key Vault: %v", err)
+	}
+
+	client, err := azsecrets.NewClient(vaultURL, cred, nil)
+	if err != nil {
+		log.Fatalf("Failed to create Key Vault client: %v", err)
+	}
+
+	secrets = fallbackSecrets{keyVaultSecrets{client: client}, envSecrets{}}
+	log.Printf("reading secrets from Key Vault %s", vaultURL)
+}
+
+func (envSecrets) GetSecret(_ context.Context, name string) (string, error) {
+	value, found := os.LookupEnv(name)
+	if !found {
+		return "", errSecretNotFound
+	}
+	return value, nil
+}
+
+func (s keyVaultSecrets) GetSecret(ctx context.Context, name string) (string, error) {
+	secretName := strings.ReplaceAll(strings.ToLower(name), "_", "-")
+
+	// An empty version returns the latest version of the secret.
+	resp, err := s.client.GetSecret(ctx, secretName, "", nil)
+	if err != nil {
+		var respErr *azcore.ResponseError
+		if errors.As(err, &respErr) && respErr.StatusCode == http.StatusNotFound {
+			return "", errSecretNotFound
+		}
+		return "", fmt.Errorf("failed to get secret %s from Key Vault: %w", secretName, err)
+	}
+	if resp.Value == nil {
+		return "", errSecretNotFound
+	}
+	return *resp.Value, nil
+}
+
+func (s fallbackSecrets) GetSecret(ctx context.Context, name string) (string, error) {
+	for _, provider := range s {
+		value, err := provider.GetSecret(ctx, name)
+		if !errors.Is(err, errSecretNotFound) {
+			return value, err
+		}
+	}
+	return "", errSecretNotFound
+}
+
+func lookupSecret(name string) (string, bool) {
+	value, err := secrets.GetSecret(context.Background(), name)
+	if err != nil {
+		if !errors.Is(err, errSecretNotFound) {
+			log.Printf("Error reading secret %s: %v\n", name, err)
+		}
+		return "", false
+	}
+	return value, true
+}
+
+// serverOpenAIKey re-reads OPENAI_KEY once the cache expires so the key can be
+// rotated in Key Vault without a restart. On a lookup error the last known key
+// is kept.
+func serverOpenAIKey(ctx context.Context) (string, bool) {
+	openAIKeyCache.Lock()
+	defer openAIKeyCache.Unlock()
+
+	if !openAIKeyCache.fetchedAt.IsZero() && time.Since(openAIKeyCache.fetchedAt) < openAIKeyCacheTTL {
+		return openAIKeyCache.key, openAIKeyCache.found
+	}
+
+	key, err := secrets.GetSecret(ctx, "OPENAI_KEY")
+	switch {
+	case err == nil:
+		openAIKeyCache.key, openAIKeyCache.found = key, true
+	case errors.Is(err, errSecretNotFound):
+		openAIKeyCache.key, openAIKeyCache.found = "", false
+	default:
+		log.Printf("Error refreshing OPENAI_KEY, keeping the cached key: %v\n", err)
+	}
+	openAIKeyCache.fetchedAt = time.Now()
+
+	return openAIKeyCache.key, openAIKeyCache.found
+}
@@ -0,0 +1,104 @@
+package main
+
+import (
+	"context"
+	"crypto/rand"
+	"fmt"
+	"log"
+	"strconv"
+	"time"
+
+	"github.com/golang-jwt/jwt/v4"
+)
+
+// /login hands out a session token naming the user's ID and storage
+// account. Clients send it back with the Keycloak token, which is still
+// checked on every request, and LoginMiddleware takes the user from it
+// instead of looking them up. A missing, expired or foreign token only
+// costs the lookup.
+const (
+	sessionHeader   = "X-Session-Token"
+	sessionTokenTTL = 15 * time.Minute
+	sessionIssuer   = "recipe-generator"
+)
+
+var sessionKey []byte
+
+// sessionClaims are bound to the Keycloak subject, a token leaked to
+// another user is worthless next to their own bearer token.
+type sessionClaims struct {
+	UserID    int    `json:"uid"`
+	Subdomain string `json:"subdomain"`
+	jwt.RegisteredClaims
+}
+
+// initSessionTokens reads SESSION_TOKEN_SECRET. Without it a key is
+// generated, the tokens then only save the lookup on this instance.
+func initSessionTokens() {
+	if secret, ok := lookupSecret("SESSION_TOKEN_SECRET"); ok && secret != "" {
+		sessionKey = []byte(secret)
+		return
+	}
+	sessionKey = make([]byte, 32)
+	if _, err := rand.Read(sessionKey); err != nil {
+		log.Fatalf("Unable to generate session token key: %v\n", err)
+	}
+}
+
+func sessionRevokedKey(userID int) string {
+	return "session-revoked:" + strconv.Itoa(userID)
+}
+
+// issueSessionToken signs a session token for the user. Like cachedUser it
+// is only issued once provisioning is ready, until then Login has to see
+// the current status.
+func issueSessionToken(userCtx UserContext) (string, time.Time, error) {
+	now := time.Now()
+	expiresAt := now.Add(sessionTokenTTL)
+	token := jwt.NewWithClaims(jwt.SigningMethodHS256, sessionClaims{
+		UserID:    userCtx.UserID,
+		Subdomain: userCtx.Subdomain,
+		RegisteredClaims: jwt.RegisteredClaims{
+			Issuer:    sessionIssuer,
+			Subject:   userCtx.oauthID,
+			IssuedAt:  jwt.NewNumericDate(now),
+			ExpiresAt: jwt.NewNumericDate(expiresAt),
+		},
+	})
+	signed, err := token.SignedString(sessionKey)
+	if err != nil {
+		return "", time.Time{}, err
+	}
+	return signed, expiresAt, nil
+}
+
+// parseSessionToken returns the claims of a valid token of the subject
+// that wasn't revoked since it was issued.
+func parseSessionToken(ctx context.Context, tokenStr string, oauthID string) (sessionClaims, error) {
+	var claims sessionClaims
+	_, err := jwt.ParseWithClaims(tokenStr, &claims, func(token *jwt.Token) (interface{}, error) {
+		if _, ok := token.Method.(*jwt.SigningMethodHMAC); !ok {
+			return nil, fmt.Errorf("unexpected signing method %v", token.Header["alg"])
+		}
+		return sessionKey, nil
+	})
+	if err != nil {
+		return sessionClaims{}, err
+	}
+	if claims.Issuer != sessionIssuer || claims.Subject != oauthID || claims.IssuedAt == nil || claims.UserID == 0 {
+		return sessionClaims{}, fmt.Errorf("session token of another user")
+	}
+
+	var revokedAt time.Time
+	if appCache.Get(ctx, sessionRevokedKey(claims.UserID), &revokedAt) && !claims.IssuedAt.Time.After(revokedAt) {
+		return sessionClaims{}, fmt.Errorf("session token revoked")
+	}
+	return claims, nil
+}
+
+// revokeSessionTokens rejects the tokens issued to the user so far, for
+// accounts that are deleted or linked into another.
+func revokeSessionTokens(ctx context.Context, userID int) {
+	// the token's IssuedAt has whole seconds
+	appCache.Set(ctx, sessionRevokedKey(userID), time.Now().Truncate(time.Second), sessionTokenTTL)
+}
@@ -0,0 +1,280 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"log"
+	"net/http"
+	"slices"
+	"strconv"
+	"strings"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/openai/openai-go"
+)
+
+const (
+	languageGerman  = "de"
+	languageEnglish = "en"
+
+	unitsMetric   = "metric"
+	unitsImperial = "imperial"
+
+	modelTierStandard = "standard"
+	modelTierPremium  = "premium"
+
+	themeSystem = "system"
+	themeLight  = "light"
+	themeDark   = "dark"
+)
+
+const (
+	maxSettingsCategories     = 20
+	maxSettingsCategoryLength = 40
+	maxCommunityNameLength    = 40
+)
+
+// modelTiers map the tier a user picks to the chat model, premium needs a
+// plan with featurePremiumModel.
+var modelTiers = map[string]string{
+	modelTierStandard: defaultLLMModel,
+	modelTierPremium:  openai.ChatModelGPT4o,
+}
+
+// defaultCategories are the categories recipes are sorted into unless the
+// user sets their own.
+var defaultCategories = []string{"Hauptgericht", "Vorspeise", "Brot", "Dessert"}
+
+// UserSettings are the user's defaults, endpoints use them where a request
+// leaves a value out. Theme is only stored for the clients.
+type UserSettings struct {
+	Language   string   `json:"language"`
+	Units      string   `json:"units"`
+	Categories []string `json:"categories"`
+	// PublishOnSave off saves new recipes as drafts.
+	PublishOnSave bool   `json:"publishOnSave"`
+	ModelTier     string `json:"modelTier"`
+	Theme         string `json:"theme"`
+	// FoodSafetyNotes off leaves core temperatures out of generated recipes.
+	FoodSafetyNotes bool `json:"foodSafetyNotes"`
+	// CookbookContext off generates without the user's recipes as examples.
+	CookbookContext bool `json:"cookbookContext"`
+	// CommunityName is the author shown with shared recipes, the subdomain
+	// when it is empty.
+	CommunityName string `json:"communityName"`
+}
+
+func defaultUserSettings() UserSettings {
+	return UserSettings{
+		Language:        languageGerman,
+		Units:           unitsMetric,
+		Categories:      slices.Clone(defaultCategories),
+		PublishOnSave:   true,
+		ModelTier:       modelTierStandard,
+		Theme:           themeSystem,
+		FoodSafetyNotes: true,
+		CookbookContext: true,
+	}
+}
+
+func (s UserSettings) german() bool {
+	return s.Language != languageEnglish
+}
+
+func GetUserSettings(ctx context.Context, userID int) (UserSettings, error) {
+	settings := defaultUserSettings()
+	err := pool.QueryRow(ctx, `
+		SELECT language, units, categories, publish_on_save, model_tier, theme, food_safety_notes, cookbook_context, community_name
+		FROM user_settings WHERE user_id = $1`, userID).
+		Scan(&settings.Language, &settings.Units, &settings.Categories, &settings.PublishOnSave, &settings.ModelTier, &settings.Theme,
+			&settings.FoodSafetyNotes, &settings.CookbookContext, &settings.CommunityName)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return defaultUserSettings(), nil
+	}
+	if len(settings.Categories) == 0 {
+		settings.Categories = slices.Clone(defaultCategories)
+	}
+	return settings, err
+}
+
+// loadUserSettings is swapped for the in-memory settings by the mock server.
+var loadUserSettings = GetUserSettings
+
+// requestUserSettings returns the settings of the user the context belongs
+// to. Without a user, or when they can't be read, the defaults apply so a
+// request never fails over its defaults.
+func requestUserSettings(ctx context.Context) UserSettings {
+	userCtx, ok := ctx.Value("user").(UserContext)
+	if !ok {
+		return defaultUserSettings()
+	}
+	settings, err := loadUserSettings(ctx, userCtx.UserID)
+	if err != nil {
+		log.Printf("Error getting settings of user %d, using defaults: %v\n", userCtx.UserID, err)
+		return defaultUserSettings()
+	}
+	return settings
+}
+
+// parseIsGerman reads an isGerman form or query value, an empty one falls
+// back to the user's language.
+func parseIsGerman(ctx context.Context, value string) (bool, string) {
+	switch value {
+	case "true":
+		return true, ""
+	case "false":
+		return false, ""
+	case "":
+		return requestUserSettings(ctx).german(), ""
+	default:
+		return false, "isGerman must be 'true' or 'false'"
+	}
+}
+
+// recipeSystemMessage is the format prompt in the recipe's language with the
+// user's units.
+func recipeSystemMessage(ctx context.Context, isGerman bool) string {
+	imperial := requestUserSettings(ctx).Units == unitsImperial
+	switch {
+	case isGerman && imperial:
+		return strings.TrimSuffix(germanSystemMessage, germanMetricUnits) + germanImperialUnits
+	case isGerman:
+		return germanSystemMessage
+	case imperial:
+		return strings.TrimSuffix(englishSystemMessage, englishMetricUnits) + englishImperialUnits
+	default:
+		return englishSystemMessage
+	}
+}
+
+// modelFor returns the chat model of the user's tier.
+func modelFor(ctx context.Context) string {
+	if model, found := modelTiers[requestUserSettings(ctx).ModelTier]; found {
+		return model
+	}
+	return defaultLLMModel
+}
+
+func validateUserSettings(settings *UserSettings) string {
+	switch settings.Language {
+	case languageGerman, languageEnglish:
+	default:
+		return "language must be de or en"
+	}
+	switch settings.Units {
+	case unitsMetric, unitsImperial:
+	default:
+		return "units must be metric or imperial"
+	}
+	if _, found := modelTiers[settings.ModelTier]; !found {
+		return "modelTier must be standard or premium"
+	}
+	switch settings.Theme {
+	case themeSystem, themeLight, themeDark:
+	default:
+		return "theme must be system, light or dark"
+	}
+
+	settings.CommunityName = strings.Join(strings.Fields(settings.CommunityName), " ")
+	if len([]rune(settings.CommunityName)) > maxCommunityNameLength {
+		return "communityName must be at most " + strconv.Itoa(maxCommunityNameLength) + " characters"
+	}
+
+	var categories []string
+	for _, category := range settings.Categories {
+		category = strings.Join(strings.Fields(category), " ")
+		if category == "" || slices.Contains(categories, category) {
+			continue
+		}
+		if len([]rune(category)) > maxSettingsCategoryLength {
+			return "categories must be at most " + strconv.Itoa(maxSettingsCategoryLength) + " characters"
+		}
+		categories = append(categories, category)
+	}
+	if len(categories) == 0 {
+		return "categories must not be empty"
+	}
+	if len(categories) > maxSettingsCategories {
+		return "at most " + strconv.Itoa(maxSettingsCategories) + " categories are allowed"
+	}
+	settings.Categories = categories
+	return ""
+}
+
+func HandleGetSettings(w http.ResponseWriter, r *http.Request) {
+	userCtx, ok := r.Context().Value("user").(UserContext)
+	if !ok {
+		http.Error(w, "Unauthorized: user context missing", http.StatusUnauthorized)
+		return
+	}
+
+	settings, err := GetUserSettings(r.Context(), userCtx.UserID)
+	if err != nil {
+		log.Printf("Error getting settings: %v\n", err)
+		http.Error(w, "Error getting settings", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+
+	err = json.NewEncoder(w).Encode(settings)
+	if err != nil {
+		http.Error(w, "Error encoding JSON response", http.StatusInternalServerError)
+	}
+}
+
+// HandleUpdateSettings replaces the settings, fields left out of the body
+// keep their defaults.
+func HandleUpdateSettings(w http.ResponseWriter, r *http.Request) {
+	userCtx, ok := r.Context().Value("user").(UserContext)
+	if !ok {
+		http.Error(w, "Unauthorized: user context missing", http.StatusUnauthorized)
+		return
+	}
+
+	settings := defaultUserSettings()
+	if err := json.NewDecoder(r.Body).Decode(&settings); err != nil {
+		http.Error(w, "Invalid JSON payload", http.StatusBadRequest)
+		return
+	}
+	if message := validateUserSettings(&settings); message != "" {
+		http.Error(w, message, http.StatusBadRequest)
+		return
+	}
+
+	if settings.ModelTier == modelTierPremium {
+		_, limits, err := GetUserPlan(r.Context(), userCtx.UserID)
+		if err != nil {
+			log.Printf("Error getting plan for user %d: %v\n", userCtx.UserID, err)
+			http.Error(w, "Error getting plan", http.StatusInternalServerError)
+			return
+		}
+		if !limits.Features[featurePremiumModel] {
+			http.Error(w, "The premium model is not available on your plan", http.StatusForbidden)
+			return
+		}
+	}
+
+	_, err := pool.Exec(r.Context(), `
+		INSERT INTO user_settings (user_id, language, units, categories, publish_on_save, model_tier, theme, food_safety_notes, cookbook_context, community_name)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10)
+		ON CONFLICT (user_id) DO UPDATE SET language = $2, units = $3, categories = $4, publish_on_save = $5, model_tier = $6, theme = $7,
+		food_safety_notes = $8, cookbook_context = $9, community_name = $10`,
+		userCtx.UserID, settings.Language, settings.Units, settings.Categories, settings.PublishOnSave, settings.ModelTier, settings.Theme,
+		settings.FoodSafetyNotes, settings.CookbookContext, settings.CommunityName)
+	if err != nil {
+		log.Printf("Error updating settings: %v\n", err)
+		http.Error(w, "Error updating settings", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+
+	err = json.NewEncoder(w).Encode(settings)
+	if err != nil {
+		http.Error(w, "Error encoding JSON response", http.StatusInternalServerError)
+	}
+}
@@ -0,0 +1,231 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	_ "embed"
+	"encoding/hex"
+	"errors"
+	"html"
+	"log"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+)
+
+//go:embed site/index.html
+var embeddedIndexHTML []byte
+
+// siteIndexHTML is the site's index.html for the user. Its script loads
+// recipes from the recipe-api meta tag, HandleGetPublicRecipe, and falls back
+// to the published recipes/{slug}.md. Without PUBLIC_URL the address is
+// relative, which only reaches the API from embedded sites. noindexMeta is
+// added when the user opted out of indexing.
+func siteIndexHTML(subdomain string, allowed bool) []byte {
+	api := strings.TrimSuffix(os.Getenv("PUBLIC_URL"), "/") + "/api/v1/public/" + url.PathEscape(subdomain) + "/recipes/"
+	meta := `    <meta name="recipe-api" content="` + html.EscapeString(api) + `">` + "\n"
+	if !allowed {
+		meta += "    " + noindexMeta + "\n"
+	}
+	return bytes.Replace(embeddedIndexHTML, []byte("</head>"), []byte(meta+"</head>"), 1)
+}
+
+// HandleServeSite renders a user's recipe site straight from the database for
+// deployments running in embedded storage mode.
+func HandleServeSite(w http.ResponseWriter, r *http.Request) {
+	subdomain := r.PathValue("subdomain")
+	path := r.PathValue("path")
+
+	var userID int
+	var storageMode string
+	var siteUpdatedAt time.Time
+	var allowed bool
+	err := pool.QueryRow(r.Context(), "SELECT id, storage_mode, site_updated_at, site_indexing FROM users WHERE subdomain = $1", subdomain).
+		Scan(&userID, &storageMode, &siteUpdatedAt, &allowed)
+	if err != nil || storageMode != storageModeEmbedded {
+		http.NotFound(w, r)
+		return
+	}
+	// robots.txt below /u/ is ignored as well, the header covers every page
+	if !allowed {
+		w.Header().Set("X-Robots-Tag", "noindex")
+	}
+
+	switch {
+	case path == "" || path == "index.html":
+		serveSiteContent(w, r, "index.html", siteIndexHTML(subdomain, allowed), siteUpdatedAt)
+	case path == "recipes.md":
+		recipes, err := indexRecipes(r.Context(), userID)
+		if err != nil {
+			log.Printf("Error getting recipes for site %s: %v\n", subdomain, err)
+			http.Error(w, "Error rendering recipes", http.StatusInternalServerError)
+			return
+		}
+		index, err := renderRecipesIndex(userID, recipes)
+		if err != nil {
+			http.Error(w, "Error rendering recipes", http.StatusInternalServerError)
+			return
+		}
+		serveSiteContent(w, r, path, []byte(index), siteUpdatedAt)
+	case path == "sitemap.xml" || path == "robots.txt":
+		content := renderRobots(subdomain, allowed)
+		if path == "sitemap.xml" {
+			w.Header().Set("Content-Type", "application/xml")
+			var recipes []Recipe
+			recipes, err = indexRecipes(r.Context(), userID)
+			if err == nil {
+				content, err = renderSitemap(subdomain, userID, allowed, recipes)
+			}
+			if err != nil {
+				log.Printf("Error rendering sitemap for site %s: %v\n", subdomain, err)
+				http.Error(w, "Error rendering site metadata", http.StatusInternalServerError)
+				return
+			}
+		}
+		serveSiteContent(w, r, path, []byte(content), siteUpdatedAt)
+	case path == "saison.md":
+		page, err := renderSeasonalPage(r.Context(), userID, time.Now())
+		if err != nil {
+			log.Printf("Error rendering seasonal page for site %s: %v\n", subdomain, err)
+			http.Error(w, "Error rendering seasonal recipes", http.StatusInternalServerError)
+			return
+		}
+		serveSiteContent(w, r, path, []byte(page), siteUpdatedAt)
+	case path == "stats.md":
+		stats, err := recipeStats(r.Context(), userID, time.Now())
+		if err != nil {
+			log.Printf("Error rendering stats for site %s: %v\n", subdomain, err)
+			http.Error(w, "Error rendering stats", http.StatusInternalServerError)
+			return
+		}
+		serveSiteContent(w, r, path, []byte(renderStatsPage(stats, time.Now())), siteUpdatedAt)
+	case path == "plan.md":
+		page, err := renderMealPlanPage(r.Context(), userID)
+		if err != nil {
+			log.Printf("Error rendering meal plan for site %s: %v\n", subdomain, err)
+			http.Error(w, "Error rendering meal plan", http.StatusInternalServerError)
+			return
+		}
+		serveSiteContent(w, r, path, []byte(page), siteUpdatedAt)
+	case path == "print/print.css":
+		w.Header().Set("Content-Type", "text/css; charset=utf-8")
+		serveSiteContent(w, r, path, []byte(printStylesheet), siteUpdatedAt)
+	case strings.HasPrefix(path, "print/") && strings.HasSuffix(path, ".html"):
+		slug := strings.TrimSuffix(strings.TrimPrefix(path, "print/"), ".html")
+		page, err := renderPrintableRecipe(r.Context(), subdomain, userID, slug)
+		if err != nil {
+			if !errors.Is(err, pgx.ErrNoRows) {
+				log.Printf("Error rendering print page %s for site %s: %v\n", slug, subdomain, err)
+			}
+			http.NotFound(w, r)
+			return
+		}
+		serveSiteContent(w, r, path, []byte(page), siteUpdatedAt)
+	case strings.HasPrefix(path, "photos/"):
+		photo, cookedAt, err := getSessionPhoto(r.Context(), userID, path)
+		if err != nil {
+			if !errors.Is(err, pgx.ErrNoRows) {
+				log.Printf("Error getting photo %s for site %s: %v\n", path, subdomain, err)
+			}
+			http.NotFound(w, r)
+			return
+		}
+		serveSiteContent(w, r, path, photo, cookedAt)
+	case strings.HasPrefix(path, "collections/") && strings.HasSuffix(path, ".md"):
+		slug := strings.TrimSuffix(strings.TrimPrefix(path, "collections/"), ".md")
+		collection, err := getCollectionBySlug(r.Context(), userID, slug)
+		if err != nil {
+			if !errors.Is(err, pgx.ErrNoRows) {
+				log.Printf("Error getting collection %s for site %s: %v\n", slug, subdomain, err)
+			}
+			http.NotFound(w, r)
+			return
+		}
+		recipes, err := collectionRecipes(r.Context(), userID, collection.ID)
+		if err != nil {
+			log.Printf("Error rendering collection %s for site %s: %v\n", slug, subdomain, err)
+			http.Error(w, "Error rendering collection", http.StatusInternalServerError)
+			return
+		}
+		serveSiteContent(w, r, path, []byte(renderCollectionPage(collection, recipes)), siteUpdatedAt)
+	case strings.HasPrefix(path, "recipes/") && strings.HasSuffix(path, ".md"):
+		slug := strings.TrimSuffix(strings.TrimPrefix(path, "recipes/"), ".md")
+		content, updatedAt, err := getPublishedRecipe(r.Context(), userID, slug)
+		if errors.Is(err, pgx.ErrNoRows) {
+			content, updatedAt, err = getRedirectStub(r.Context(), userID, slug)
+		}
+		if err != nil {
+			if !errors.Is(err, pgx.ErrNoRows) {
+				log.Printf("Error getting recipe %s for site %s: %v\n", slug, subdomain, err)
+			}
+			http.NotFound(w, r)
+			return
+		}
+		serveSiteContent(w, r, path, []byte(content), updatedAt)
+	default:
+		http.NotFound(w, r)
+	}
+}
+
+func getPublishedRecipe(ctx context.Context, userID int, slug string) (string, time.Time, error) {
+	var id, translationOf, variantOf, parentID int
+	var content string
+	var updatedAt time.Time
+	var timing RecipeTiming
+	err := pool.QueryRow(ctx, `
+		SELECT id, coalesce(translation_of, 0), coalesce(variant_of, 0), coalesce(parent_recipe_id, 0), content, updated_at,
+		prep_minutes, cook_minutes, total_minutes, difficulty
+		FROM recipes WHERE user_id = $1 AND slug = $2 AND NOT archived AND NOT draft`,
+		userID, slug).Scan(&id, &translationOf, &variantOf, &parentID, &content, &updatedAt, &timing.PrepTime, &timing.CookTime, &timing.TotalTime, &timing.Difficulty)
+	if err != nil {
+		return "", time.Time{}, err
+	}
+
+	published := publishedRecipeMarkdown(content, timing)
+	basedOn, err := basedOnLine(ctx, userID, parentID)
+	if err != nil {
+		return "", time.Time{}, err
+	}
+	if basedOn != "" {
+		published = strings.TrimRight(published, "\n") + "\n\n" + basedOn + "\n"
+	}
+	switcher, err := languageSwitcher(ctx, userID, id, translationOf)
+	if err != nil {
+		return "", time.Time{}, err
+	}
+	if switcher != "" {
+		published = strings.TrimRight(published, "\n") + "\n\n" + switcher + "\n"
+	}
+	variants, err := variantLinks(ctx, userID, id, variantOf)
+	if err != nil {
+		return "", time.Time{}, err
+	}
+	if variants != "" {
+		published = strings.TrimRight(published, "\n") + "\n\n" + variants + "\n"
+	}
+	sessions, err := cookingSessions(ctx, userID, id, true)
+	if err != nil {
+		return "", time.Time{}, err
+	}
+	if gallery := renderGallery(sessions); gallery != "" {
+		published = strings.TrimRight(published, "\n") + "\n\n" + gallery
+	}
+	published = strings.TrimRight(published, "\n") + "\n\n🖨️ [Druckansicht](./" + printableRecipePath(slug) + ")\n"
+	return published, updatedAt, nil
+}
+
+// serveSiteContent lets http.ServeContent answer conditional requests, the
+// ETag takes precedence so deletions that don't move Last-Modified are seen.
+func serveSiteContent(w http.ResponseWriter, r *http.Request, name string, content []byte, modified time.Time) {
+	sum := sha256.Sum256(content)
+	w.Header().Set("ETag", `"`+hex.EncodeToString(sum[:])+`"`)
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Header().Set("Content-Type", blobContentType(name))
+
+	http.ServeContent(w, r, name, modified, bytes.NewReader(content))
+}
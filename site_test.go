@@ -0,0 +1,23 @@
+package main
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestSiteIndexHTML(t *testing.T) {
+	t.Setenv("PUBLIC_URL", "https://rezepte.example/")
+
+	page := string(siteIndexHTML("anna", true))
+	if !strings.Contains(page, `<meta name="recipe-api" content="https://rezepte.example/api/v1/public/anna/recipes/">`) {
+		t.Errorf("index without the recipe API: %s", page)
+	}
+	if strings.Contains(page, noindexMeta) {
+		t.Error("index of an indexed site has noindex")
+	}
+
+	page = string(siteIndexHTML("anna", false))
+	if head, _, _ := strings.Cut(page, "</head>"); !strings.Contains(head, noindexMeta) {
+		t.Errorf("index of an opted-out site has no noindex in its head: %s", page)
+	}
+}
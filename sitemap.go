@@ -0,0 +1,160 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"encoding/xml"
+	"log"
+	"net/http"
+	"time"
+)
+
+// sitePages are the published pages besides the index and the recipes.
+var sitePages = []string{"saison", "plan", "stats"}
+
+type sitemapURLSet struct {
+	XMLName xml.Name     `xml:"urlset"`
+	XMLNS   string       `xml:"xmlns,attr"`
+	URLs    []sitemapURL `xml:"url"`
+}
+
+type sitemapURL struct {
+	Loc     string `xml:"loc"`
+	LastMod string `xml:"lastmod,omitempty"`
+}
+
+type SiteIndexing struct {
+	AllowIndexing bool `json:"allowIndexing"`
+}
+
+func siteIndexingAllowed(ctx context.Context, userID int) (bool, error) {
+	var allowed bool
+	err := pool.QueryRow(ctx, "SELECT site_indexing FROM users WHERE id = $1", userID).Scan(&allowed)
+	return allowed, err
+}
+
+// noindexMeta keeps a page out of search engines when robots.txt can't:
+// crawlers only read it at the root of a host, not below a shared-mode or
+// embedded-mode prefix.
+const noindexMeta = `<meta name="robots" content="noindex">`
+
+// publishSiteMetadata uploads index.html, sitemap.xml and robots.txt.
+// Shared-mode sites live below a prefix where robots.txt is ignored, their
+// index.html carries noindexMeta instead. See siteIndexHTML.
+func publishSiteMetadata(ctx context.Context, subdomain string, userID int, recipes []Recipe) error {
+	allowed, err := siteIndexingAllowed(ctx, userID)
+	if err != nil {
+		return err
+	}
+
+	if err := addBlob(subdomain, "index.html", string(siteIndexHTML(subdomain, allowed))); err != nil {
+		return err
+	}
+
+	sitemap, err := renderSitemap(subdomain, userID, allowed, recipes)
+	if err != nil {
+		return err
+	}
+	if err := addBlob(subdomain, "sitemap.xml", sitemap); err != nil {
+		return err
+	}
+	return addBlob(subdomain, "robots.txt", renderRobots(subdomain, allowed))
+}
+
+// renderSitemap lists the index, the pages and every published recipe of
+// the index rows, see loadIndexRecipes. It is empty when the user opted out
+// of indexing.
+func renderSitemap(subdomain string, userID int, allowed bool, recipes []Recipe) (string, error) {
+	urlSet := sitemapURLSet{XMLNS: "http://www.sitemaps.org/schemas/sitemap/0.9", URLs: []sitemapURL{}}
+	if allowed {
+		base := siteURL(subdomain)
+		urlSet.URLs = append(urlSet.URLs, sitemapURL{Loc: base})
+		for _, page := range sitePages {
+			urlSet.URLs = append(urlSet.URLs, sitemapURL{Loc: base + "?page=" + page})
+		}
+		for _, recipe := range recipes {
+			if recipe.Archived || recipe.Draft {
+				continue
+			}
+			entry := sitemapURL{Loc: base + "?recipe=" + recipe.Slug}
+			if recipe.UpdatedAt != nil {
+				entry.LastMod = recipe.UpdatedAt.UTC().Format(time.DateOnly)
+			}
+			urlSet.URLs = append(urlSet.URLs, entry)
+		}
+
+		collections, err := listCollections(context.Background(), userID)
+		if err != nil {
+			return "", err
+		}
+		for _, collection := range collections {
+			urlSet.URLs = append(urlSet.URLs, sitemapURL{Loc: base + "?collection=" + collection.Slug})
+		}
+	}
+
+	sitemap, err := xml.MarshalIndent(urlSet, "", "  ")
+	if err != nil {
+		return "", err
+	}
+	return xml.Header + string(sitemap) + "\n", nil
+}
+
+func renderRobots(subdomain string, allowed bool) string {
+	if !allowed {
+		return "User-agent: *\nDisallow: /\n"
+	}
+	return "User-agent: *\nAllow: /\n\nSitemap: " + siteURL(subdomain) + "sitemap.xml\n"
+}
+
+func HandleGetSiteIndexing(w http.ResponseWriter, r *http.Request) {
+	userCtx, ok := r.Context().Value("user").(UserContext)
+	if !ok {
+		http.Error(w, "Unauthorized: user context missing", http.StatusUnauthorized)
+		return
+	}
+
+	allowed, err := siteIndexingAllowed(r.Context(), userCtx.UserID)
+	if err != nil {
+		log.Printf("Error getting site indexing: %v\n", err)
+		http.Error(w, "Error getting site indexing", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+
+	err = json.NewEncoder(w).Encode(SiteIndexing{AllowIndexing: allowed})
+	if err != nil {
+		http.Error(w, "Error encoding JSON response", http.StatusInternalServerError)
+	}
+}
+
+// HandleUpdateSiteIndexing lets users keep their site out of search engines.
+func HandleUpdateSiteIndexing(w http.ResponseWriter, r *http.Request) {
+	userCtx, ok := r.Context().Value("user").(UserContext)
+	if !ok {
+		http.Error(w, "Unauthorized: user context missing", http.StatusUnauthorized)
+		return
+	}
+
+	var settings SiteIndexing
+	if err := json.NewDecoder(r.Body).Decode(&settings); err != nil {
+		http.Error(w, "Invalid JSON payload", http.StatusBadRequest)
+		return
+	}
+
+	_, err := pool.Exec(r.Context(), "UPDATE users SET site_indexing = $1 WHERE id = $2", settings.AllowIndexing, userCtx.UserID)
+	if err != nil {
+		log.Printf("Error updating site indexing: %v\n", err)
+		http.Error(w, "Error updating site indexing", http.StatusInternalServerError)
+		return
+	}
+
+	if err := templateRecipesBlob(userCtx.Subdomain, userCtx.UserID); err != nil {
+		log.Printf("Error updating recipe template: %v\n", err)
+		http.Error(w, "Failed to update recipe template", http.StatusInternalServerError)
+		return
+	}
+
+	w.WriteHeader(http.StatusOK)
+}
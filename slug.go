@@ -0,0 +1,190 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"strings"
+	"unicode"
+
+	"golang.org/x/text/runes"
+	"golang.org/x/text/transform"
+	"golang.org/x/text/unicode/norm"
+)
+
+var slugTransliterations = strings.NewReplacer(
+	"ä", "ae", "ö", "oe", "ü", "ue",
+	"Ä", "ae", "Ö", "oe", "Ü", "ue",
+	"ß", "ss", "ẞ", "ss",
+	"æ", "ae", "œ", "oe", "ø", "o", "ł", "l",
+)
+
+// slugify turns a recipe title into a URL and blob-path safe name: German
+// umlauts are transliterated, other accents stripped and everything that is
+// not a letter or digit (emoji included) collapses into single dashes.
+func slugify(title string) string {
+	title = slugTransliterations.Replace(title)
+
+	stripAccents := transform.Chain(norm.NFD, runes.Remove(runes.In(unicode.Mn)), norm.NFC)
+	stripped, _, err := transform.String(stripAccents, title)
+	if err == nil {
+		title = stripped
+	}
+
+	var b strings.Builder
+	dash := false
+	for _, r := range strings.ToLower(title) {
+		if r < unicode.MaxASCII && (unicode.IsLetter(r) || unicode.IsDigit(r)) {
+			b.WriteRune(r)
+			dash = false
+		} else if !dash && b.Len() > 0 {
+			b.WriteRune('-')
+			dash = true
+		}
+	}
+
+	slug := strings.TrimSuffix(b.String(), "-")
+	if slug == "" {
+		return "rezept"
+	}
+	return slug
+}
+
+// uniqueRecipeSlug slugifies the title and appends -2, -3, ... until it does not
+// collide with another recipe of the user. excludeID keeps a recipe from
+// colliding with itself when it is renamed.
+func uniqueRecipeSlug(ctx context.Context, userID int, title string, excludeID int) (string, error) {
+	// the old slugs of renamed recipes stay taken, shared links redirect from
+	// them
+	return uniqueSlug(ctx, slugify(title), `
+		SELECT slug FROM recipes WHERE user_id = $3 AND id <> $4 AND (slug = $1 OR slug LIKE $2)
+		UNION SELECT old_slug FROM slug_redirects WHERE user_id = $3 AND recipe_id <> $4 AND (old_slug = $1 OR old_slug LIKE $2)`,
+		userID, excludeID)
+}
+
+// uniqueSlug appends -2, -3, ... to base until it is not among the slugs the
+// query returns. $1 and $2 of the query are the base and its LIKE pattern,
+// args follow from $3.
+func uniqueSlug(ctx context.Context, base string, query string, args ...interface{}) (string, error) {
+	rows, err := pool.Query(ctx, query, append([]interface{}{base, base + "-%"}, args...)...)
+	if err != nil {
+		return "", err
+	}
+	defer rows.Close()
+
+	taken := make(map[string]bool)
+	for rows.Next() {
+		var slug string
+		if err := rows.Scan(&slug); err != nil {
+			return "", err
+		}
+		taken[slug] = true
+	}
+	if err := rows.Err(); err != nil {
+		return "", err
+	}
+
+	slug := base
+	for i := 2; taken[slug]; i++ {
+		slug = fmt.Sprintf("%s-%d", base, i)
+	}
+	return slug, nil
+}
+
+// legacySlug is the page name recipes had before slugs, the title with
+// dashes for spaces. Shared links still point there.
+func legacySlug(title string) string {
+	return strings.ReplaceAll(title, " ", "-")
+}
+
+type backfilledRecipe struct {
+	id        int
+	userID    int
+	subdomain string
+	title     string
+	oldSlug   string
+}
+
+// backfillRecipeSlugs assigns slugs to recipes stored before slugs existed.
+// Their old page names are kept as redirects and the sites are published
+// again in the background, the server does not wait for storage.
+func backfillRecipeSlugs() {
+	ctx := context.Background()
+	rows, err := pool.Query(ctx, `
+		SELECT r.id, r.user_id, u.subdomain, r.title FROM recipes r JOIN users u ON u.id = r.user_id
+		WHERE r.slug = '' ORDER BY r.id`)
+	if err != nil {
+		log.Fatalf("Unable to query recipes without slug: %v\n", err)
+	}
+
+	var pending []backfilledRecipe
+	for rows.Next() {
+		var recipe backfilledRecipe
+		if err := rows.Scan(&recipe.id, &recipe.userID, &recipe.subdomain, &recipe.title); err != nil {
+			log.Fatalf("Unable to scan recipe without slug: %v\n", err)
+		}
+		pending = append(pending, recipe)
+	}
+	rows.Close()
+
+	for i := range pending {
+		recipe := &pending[i]
+		slug, err := uniqueRecipeSlug(ctx, recipe.userID, recipe.title, recipe.id)
+		if err != nil {
+			log.Fatalf("Unable to generate slug for recipe %d: %v\n", recipe.id, err)
+		}
+
+		_, err = pool.Exec(ctx, "UPDATE recipes SET slug = $1 WHERE id = $2", slug, recipe.id)
+		if err != nil {
+			log.Fatalf("Unable to store slug for recipe %d: %v\n", recipe.id, err)
+		}
+		if old := legacySlug(recipe.title); old != slug && old != "" {
+			// recorded right away, the slugs of the next recipes must not take it
+			_, err = pool.Exec(ctx, `
+				INSERT INTO slug_redirects (user_id, old_slug, recipe_id)
+				SELECT $1, $2, $3 WHERE NOT EXISTS (SELECT 1 FROM recipes WHERE user_id = $1 AND slug = $2)
+				ON CONFLICT (user_id, old_slug) DO NOTHING`, recipe.userID, old, recipe.id)
+			if err != nil {
+				log.Fatalf("Unable to store old slug of recipe %d: %v\n", recipe.id, err)
+			}
+			recipe.oldSlug = old
+		}
+		invalidateRecipes(recipe.userID)
+	}
+
+	if len(pending) > 0 {
+		log.Printf("assigned slugs to %d recipes", len(pending))
+		go republishBackfilledSlugs(pending...)
+	}
+}
+
+// republishBackfilledSlugs publishes the recipes under their new slugs,
+// leaves stubs at the old page names and templates the indexes again.
+func republishBackfilledSlugs(recipes ...backfilledRecipe) {
+	ctx := context.Background()
+	sites := map[int]string{}
+	for _, recipe := range recipes {
+		sites[recipe.userID] = recipe.subdomain
+
+		var slug string
+		if err := pool.QueryRow(ctx, "SELECT slug FROM recipes WHERE id = $1", recipe.id).Scan(&slug); err != nil {
+			log.Printf("Error looking up slug of recipe %d: %v\n", recipe.id, err)
+			continue
+		}
+		if err := publishRecipeBlob(recipe.subdomain, recipe.userID, slug); err != nil {
+			log.Printf("Error publishing recipe %d under its slug: %v\n", recipe.id, err)
+			continue
+		}
+		if recipe.oldSlug == "" {
+			continue
+		}
+		if err := publishSlugRename(ctx, recipe.subdomain, recipe.userID, recipe.id, recipe.oldSlug); err != nil {
+			log.Printf("Error leaving a redirect for recipe %d: %v\n", recipe.id, err)
+		}
+	}
+	for userID, subdomain := range sites {
+		if err := templateRecipesBlob(subdomain, userID); err != nil {
+			log.Printf("Error updating recipe template of user %d: %v\n", userID, err)
+		}
+	}
+}
@@ -0,0 +1,121 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"html"
+	"log"
+	"net/http"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+)
+
+// maxArchivedSourceLength caps the archived text, recipe pages are far
+// shorter once the markup is gone.
+const maxArchivedSourceLength = 200 << 10
+
+var (
+	htmlHiddenPattern = regexp.MustCompile(`(?is)<(script|style|noscript|svg|template)\b.*?</(script|style|noscript|svg|template)\s*>|<!--.*?-->`)
+	htmlBlockPattern  = regexp.MustCompile(`(?i)<(br|/?p|/?div|/?li|/?h[1-6]|/?tr|/?ul|/?ol|/?section|/?article)\b[^>]*>`)
+	htmlTagPattern    = regexp.MustCompile(`(?s)<[^>]*>`)
+	blankLinesPattern = regexp.MustCompile(`\n{3,}`)
+)
+
+type RecipeSource struct {
+	URL       string    `json:"url"`
+	FetchedAt time.Time `json:"fetchedAt"`
+	Content   string    `json:"content"`
+}
+
+// archiveSource keeps the text of an imported page, so a recipe still shows
+// where it came from after the site is gone. It is kept in the database
+// rather than the user's storage, which is the public website container.
+// The first import of a page is kept, later imports and the re-check don't
+// replace it.
+func archiveSource(ctx context.Context, rawURL string, page string) {
+	userCtx, ok := ctx.Value("user").(UserContext)
+	if !ok {
+		return
+	}
+
+	source, err := normalizeSourceURL(rawURL)
+	if err != nil {
+		return
+	}
+
+	_, err = pool.Exec(ctx, `
+		INSERT INTO source_archives (user_id, url, content) VALUES ($1, $2, $3)
+		ON CONFLICT (user_id, url) DO NOTHING`,
+		userCtx.UserID, source, archivedSourceText(page))
+	if err != nil {
+		log.Printf("Error archiving source %s: %v\n", source, err)
+	}
+}
+
+// archivedSourceText is the text of the page as it is archived.
+func archivedSourceText(page string) string {
+	text := htmlText(page)
+	if len(text) > maxArchivedSourceLength {
+		text = strings.ToValidUTF8(text[:maxArchivedSourceLength], "")
+	}
+	return text
+}
+
+// htmlText reduces a page to its visible text with one block per line.
+func htmlText(page string) string {
+	text := htmlHiddenPattern.ReplaceAllString(page, "")
+	text = htmlBlockPattern.ReplaceAllString(text, "\n")
+	text = htmlTagPattern.ReplaceAllString(text, "")
+	text = html.UnescapeString(text)
+
+	lines := strings.Split(text, "\n")
+	for i, line := range lines {
+		lines[i] = strings.Join(strings.Fields(line), " ")
+	}
+	text = blankLinesPattern.ReplaceAllString(strings.Join(lines, "\n"), "\n\n")
+
+	return strings.TrimSpace(text)
+}
+
+func HandleGetRecipeSource(w http.ResponseWriter, r *http.Request) {
+	userCtx, ok := r.Context().Value("user").(UserContext)
+	if !ok {
+		http.Error(w, "Unauthorized: user context missing", http.StatusUnauthorized)
+		return
+	}
+
+	recipeID, err := strconv.Atoi(r.PathValue("id"))
+	if err != nil {
+		http.Error(w, "Invalid recipe ID", http.StatusBadRequest)
+		return
+	}
+
+	var source RecipeSource
+	err = pool.QueryRow(r.Context(), `
+		SELECT s.url, s.fetched_at, s.content FROM recipes r
+		JOIN source_archives s ON s.user_id = r.user_id AND s.url = r.source_url
+		WHERE r.id = $1 AND r.user_id = $2`,
+		recipeID, userCtx.UserID).Scan(&source.URL, &source.FetchedAt, &source.Content)
+	if err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			http.Error(w, "No archived source for this recipe", http.StatusNotFound)
+			return
+		}
+		log.Printf("Error getting recipe source: %v\n", err)
+		http.Error(w, "Error getting recipe source", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+
+	err = json.NewEncoder(w).Encode(source)
+	if err != nil {
+		http.Error(w, "Error encoding JSON response", http.StatusInternalServerError)
+	}
+}
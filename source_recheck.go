@@ -0,0 +1,155 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"log"
+	"strings"
+	"time"
+)
+
+const (
+	// sourceRecheckAge is how long an imported recipe goes without a look at
+	// its source page.
+	sourceRecheckAge = 30 * 24 * time.Hour
+	// sourceRecheckBatch limits the pages fetched per job run.
+	sourceRecheckBatch = 20
+	// sourceRecheckTimeout bounds one page, a hanging source must not hold
+	// up the job.
+	sourceRecheckTimeout = 30 * time.Second
+)
+
+// sourceUpdatePrefix starts the change prompt of revisions proposed by the
+// re-check, so a second one isn't proposed while the first is pending.
+const sourceUpdatePrefix = "Source updated: "
+
+// recheckSources fetches the source pages of imported recipes again. When an
+// ingredient amount changed on the page, the new amounts are proposed as a
+// pending revision the user can accept or reject. Changes to the wording
+// alone are ignored. The page is compared with what the last check saw, the
+// archived original stays as it was imported. Converting the page and
+// proposing the revision count as generations of the user, a user over the
+// limit is checked again in the next interval.
+func recheckSources(ctx context.Context) error {
+	rows, err := pool.Query(ctx, `
+		SELECT r.id, r.user_id, u.subdomain, r.content, r.source_url, coalesce(c.content, s.content, '')
+		FROM recipes r JOIN users u ON u.id = r.user_id
+		LEFT JOIN source_archives s ON s.user_id = r.user_id AND s.url = r.source_url
+		LEFT JOIN source_rechecks c ON c.user_id = r.user_id AND c.url = r.source_url
+		WHERE r.source_url <> '' AND r.translation_of IS NULL
+		AND coalesce(r.source_checked_at, r.created_at) < now() - $1 * interval '1 second'
+		ORDER BY coalesce(r.source_checked_at, r.created_at) LIMIT $2`,
+		int64(sourceRecheckAge.Seconds()), sourceRecheckBatch)
+	if err != nil {
+		return err
+	}
+
+	type importedRecipe struct {
+		id        int
+		userID    int
+		subdomain string
+		content   string
+		source    string
+		// seen is the text of the last check, or the archived original
+		seen string
+	}
+	var recipes []importedRecipe
+	for rows.Next() {
+		var recipe importedRecipe
+		if err := rows.Scan(&recipe.id, &recipe.userID, &recipe.subdomain, &recipe.content, &recipe.source, &recipe.seen); err != nil {
+			rows.Close()
+			return err
+		}
+		recipes = append(recipes, recipe)
+	}
+	rows.Close()
+
+	for _, recipe := range recipes {
+		// Marked first, an unreachable page waits for the next interval.
+		if _, err := pool.Exec(ctx, "UPDATE recipes SET source_checked_at = now() WHERE id = $1", recipe.id); err != nil {
+			return err
+		}
+
+		// user-given URLs, GetWebsite only reaches public addresses and
+		// caps the page
+		fetchCtx, cancel := context.WithTimeout(ctx, sourceRecheckTimeout)
+		page, err := GetWebsite(fetchCtx, recipe.source)
+		cancel()
+		if err != nil {
+			log.Printf("Error fetching source of recipe %d: %v\n", recipe.id, err)
+			continue
+		}
+		text := archivedSourceText(page)
+		if text == recipe.seen {
+			continue
+		}
+
+		var pending bool
+		err = pool.QueryRow(ctx, `
+			SELECT EXISTS (SELECT 1 FROM recipe_revisions WHERE recipe_id = $1 AND status = $2 AND change_prompt LIKE $3)`,
+			recipe.id, revisionPending, sourceUpdatePrefix+"%").Scan(&pending)
+		if err != nil || pending {
+			continue
+		}
+
+		if err := countGeneration(ctx, recipe.userID); err != nil {
+			if !errors.Is(err, errGenerationLimit) {
+				log.Printf("Error counting generation for user %d: %v\n", recipe.userID, err)
+			}
+			continue
+		}
+		userCtx := context.WithValue(ctx, "user", UserContext{UserID: recipe.userID, Subdomain: recipe.subdomain})
+		userCtx = withLLMBudget(userCtx, &llmBudget{remaining: backgroundRecipeBudget})
+		changes, err := sourceAmountChanges(userCtx, recipe.content, page)
+		if err != nil {
+			log.Printf("Error comparing source of recipe %d: %v\n", recipe.id, err)
+			continue
+		}
+		// compared, the same text isn't converted again
+		if err := recordSourceRecheck(ctx, recipe.userID, recipe.source, text); err != nil {
+			return err
+		}
+		if len(changes) == 0 {
+			continue
+		}
+
+		if err := countGeneration(ctx, recipe.userID); err != nil {
+			if !errors.Is(err, errGenerationLimit) {
+				log.Printf("Error counting generation for user %d: %v\n", recipe.userID, err)
+			}
+			continue
+		}
+		prompt := sourceUpdatePrefix + "change these ingredient amounts and leave everything else as it is: " + strings.Join(changes, "; ")
+		if _, err := createPendingRevision(userCtx, recipe.userID, recipe.id, prompt); err != nil {
+			log.Printf("Error proposing source update for recipe %d: %v\n", recipe.id, err)
+		}
+	}
+
+	return nil
+}
+
+func recordSourceRecheck(ctx context.Context, userID int, source string, text string) error {
+	_, err := pool.Exec(ctx, `
+		INSERT INTO source_rechecks (user_id, url, content) VALUES ($1, $2, $3)
+		ON CONFLICT (user_id, url) DO UPDATE SET content = $3, checked_at = now()`,
+		userID, source, text)
+	return err
+}
+
+// sourceAmountChanges converts the page like a link import and lists the
+// ingredients whose amount differs from the stored recipe.
+func sourceAmountChanges(ctx context.Context, current string, page string) ([]string, error) {
+	isGerman := strings.Contains(current, "## Zutaten")
+	fresh, err := openAIgenerateRecipeLink(ctx, page, isGerman)
+	if err != nil {
+		return nil, err
+	}
+
+	var changes []string
+	for _, change := range diffRecipes(current, fresh).Ingredients {
+		if change.Change == diffChanged && change.Before.Amount != "" && change.After.Amount != "" {
+			changes = append(changes, change.Before.Name+": "+change.Before.Amount+" → "+change.After.Amount)
+		}
+	}
+	return changes, nil
+}
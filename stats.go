@@ -0,0 +1,302 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// estimatedTokensPerGeneration is a rough average over all generation modes,
+// prompt and answer together.
+const estimatedTokensPerGeneration = 2500
+
+// cuisineBatchSize limits how many recipes of a user are classified in one
+// call, cuisineUsersPerRun how many users one job run gets to. The rest
+// follow in later runs.
+const (
+	cuisineBatchSize   = 50
+	cuisineUsersPerRun = 20
+)
+
+const cuisineSystemMessage = "You classify recipes by cuisine. Answer with a JSON object mapping each recipe ID to one cuisine " +
+	"in German, for example {\"12\": \"Italienisch\", \"13\": \"Deutsch\"}. Use \"International\" when no cuisine fits."
+
+type CountEntry struct {
+	Name  string `json:"name"`
+	Count int    `json:"count"`
+}
+
+type RecipeStats struct {
+	TotalRecipes     int          `json:"totalRecipes"`
+	Categories       []CountEntry `json:"categories"`
+	TopIngredients   []CountEntry `json:"topIngredients"`
+	Cuisines         []CountEntry `json:"cuisines"`
+	AddedPerMonth    []CountEntry `json:"addedPerMonth"`
+	Generations      int          `json:"generations"`
+	EstimatedTokens  int          `json:"estimatedTokens"`
+	YearRecipes      int          `json:"yearRecipes"`
+	YearGenerations  int          `json:"yearGenerations"`
+	YearBusiestMonth string       `json:"yearBusiestMonth,omitempty"`
+}
+
+// HandleGetStats returns the user's collection statistics. It only reads,
+// cuisines are classified by the cuisine-classification job and stats.md is
+// published with saison.md, see publishSeasonalPages.
+func HandleGetStats(w http.ResponseWriter, r *http.Request) {
+	userCtx, ok := r.Context().Value("user").(UserContext)
+	if !ok {
+		http.Error(w, "Unauthorized: user context missing", http.StatusUnauthorized)
+		return
+	}
+
+	stats, err := recipeStats(r.Context(), userCtx.UserID, time.Now())
+	if err != nil {
+		log.Printf("Error getting stats: %v\n", err)
+		http.Error(w, "Error getting stats", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+
+	err = json.NewEncoder(w).Encode(stats)
+	if err != nil {
+		http.Error(w, "Error encoding JSON response", http.StatusInternalServerError)
+	}
+}
+
+func recipeStats(ctx context.Context, userID int, now time.Time) (RecipeStats, error) {
+	stats := RecipeStats{}
+
+	recipes, err := GetRecipes(userID)
+	if err != nil {
+		return RecipeStats{}, err
+	}
+
+	categories := map[string]int{}
+	ingredients := map[string]int{}
+	dictionary, isGerman := loadIngredientDictionary(ctx), requestUserSettings(ctx).german()
+	for _, recipe := range recipes {
+		if recipe.TranslationOf != 0 {
+			continue
+		}
+		stats.TotalRecipes++
+		categories[recipe.Category]++
+
+		seen := map[string]bool{}
+		for _, ingredient := range parseRecipeMarkdown(recipe.Recipe).Ingredients {
+			name := canonicalIngredientName(dictionary, ingredient.Name, isGerman)
+			if name != "" && !seen[name] {
+				seen[name] = true
+				ingredients[name]++
+			}
+		}
+	}
+	stats.Categories = sortedCounts(categories, 0)
+	stats.TopIngredients = sortedCounts(ingredients, 10)
+
+	stats.Cuisines, err = countQuery(ctx, `
+		SELECT cuisine, count(*) FROM recipes WHERE user_id = $1 AND translation_of IS NULL AND cuisine <> ''
+		GROUP BY cuisine ORDER BY count(*) DESC, cuisine`, userID)
+	if err != nil {
+		return RecipeStats{}, err
+	}
+
+	stats.AddedPerMonth, err = countQuery(ctx, `
+		SELECT to_char(date_trunc('month', created_at), 'YYYY-MM'), count(*) FROM recipes
+		WHERE user_id = $1 AND translation_of IS NULL GROUP BY 1 ORDER BY 1`, userID)
+	if err != nil {
+		return RecipeStats{}, err
+	}
+
+	yearStart := time.Date(now.Year(), time.January, 1, 0, 0, 0, 0, now.Location())
+	err = pool.QueryRow(ctx, `
+		SELECT coalesce((SELECT sum(count) FROM generation_usage WHERE user_id = $1), 0)
+			+ coalesce((SELECT sum(count) FROM generation_usage_monthly WHERE user_id = $1), 0),
+		coalesce((SELECT sum(count) FROM generation_usage WHERE user_id = $1 AND day >= $2), 0)
+			+ coalesce((SELECT sum(count) FROM generation_usage_monthly WHERE user_id = $1 AND month >= $2), 0)`,
+		userID, yearStart).Scan(&stats.Generations, &stats.YearGenerations)
+	if err != nil {
+		return RecipeStats{}, err
+	}
+	stats.EstimatedTokens = stats.Generations * estimatedTokensPerGeneration
+
+	busiest := 0
+	year := strconv.Itoa(now.Year())
+	for _, month := range stats.AddedPerMonth {
+		if !strings.HasPrefix(month.Name, year+"-") {
+			continue
+		}
+		stats.YearRecipes += month.Count
+		if month.Count > busiest {
+			busiest = month.Count
+			stats.YearBusiestMonth = month.Name
+		}
+	}
+
+	return stats, nil
+}
+
+func countQuery(ctx context.Context, query string, args ...interface{}) ([]CountEntry, error) {
+	rows, err := pool.Query(ctx, query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	counts := []CountEntry{}
+	for rows.Next() {
+		var entry CountEntry
+		if err := rows.Scan(&entry.Name, &entry.Count); err != nil {
+			return nil, err
+		}
+		counts = append(counts, entry)
+	}
+	return counts, rows.Err()
+}
+
+// sortedCounts orders by count and then name, limit 0 keeps all.
+func sortedCounts(counts map[string]int, limit int) []CountEntry {
+	entries := make([]CountEntry, 0, len(counts))
+	for name, count := range counts {
+		entries = append(entries, CountEntry{Name: name, Count: count})
+	}
+	sortCountEntries(entries)
+	if limit > 0 && len(entries) > limit {
+		entries = entries[:limit]
+	}
+	return entries
+}
+
+// ingredientKey drops preparation notes, "Zwiebel, fein gehackt" and
+// "Zwiebel (rot)" both count as Zwiebel.
+func ingredientKey(name string) string {
+	name, _, _ = strings.Cut(name, ",")
+	name, _, _ = strings.Cut(name, "(")
+	return strings.TrimSpace(name)
+}
+
+// classifyAllCuisines classifies the recipes of users who have unchecked
+// ones, it runs as a scheduled job.
+func classifyAllCuisines(ctx context.Context) error {
+	rows, err := pool.Query(ctx, `
+		SELECT DISTINCT user_id FROM recipes
+		WHERE cuisine = '' AND cuisine_checked_at IS NULL AND translation_of IS NULL
+		ORDER BY user_id LIMIT $1`, cuisineUsersPerRun)
+	if err != nil {
+		return err
+	}
+	var users []int
+	for rows.Next() {
+		var userID int
+		if err := rows.Scan(&userID); err != nil {
+			rows.Close()
+			return err
+		}
+		users = append(users, userID)
+	}
+	rows.Close()
+
+	for _, userID := range users {
+		if err := classifyCuisines(ctx, userID); err != nil {
+			log.Printf("Error classifying cuisines for user %d: %v\n", userID, err)
+		}
+	}
+	return nil
+}
+
+// classifyCuisines fills recipes.cuisine for recipes that have none yet,
+// titles are enough to tell a cuisine. Recipes the answer leaves out are
+// marked as checked, they would otherwise be sent again on every run.
+func classifyCuisines(ctx context.Context, userID int) error {
+	rows, err := pool.Query(ctx, `
+		SELECT id, title FROM recipes
+		WHERE user_id = $1 AND cuisine = '' AND cuisine_checked_at IS NULL AND translation_of IS NULL
+		ORDER BY id LIMIT $2`,
+		userID, cuisineBatchSize)
+	if err != nil {
+		return err
+	}
+	var list strings.Builder
+	var ids []int
+	for rows.Next() {
+		var id int
+		var title string
+		if err := rows.Scan(&id, &title); err != nil {
+			rows.Close()
+			return err
+		}
+		fmt.Fprintf(&list, "%d: %s\n", id, title)
+		ids = append(ids, id)
+	}
+	rows.Close()
+	if len(ids) == 0 {
+		return nil
+	}
+
+	answer := map[string]string{}
+	err = completeJSON(ctx, llmRequest{
+		Task:   llmTaskCuisine,
+		System: cuisineSystemMessage,
+		Prompt: list.String(),
+	}, &answer)
+	if err != nil {
+		return err
+	}
+
+	// cached lists carry the cuisine for the index grouping
+	defer invalidateRecipes(userID)
+	for idText, cuisine := range answer {
+		id, err := strconv.Atoi(idText)
+		cuisine = strings.TrimSpace(cuisine)
+		if err != nil || cuisine == "" {
+			continue
+		}
+		_, err = pool.Exec(ctx, "UPDATE recipes SET cuisine = $1 WHERE id = $2 AND user_id = $3", cuisine, id, userID)
+		if err != nil {
+			return err
+		}
+	}
+	_, err = pool.Exec(ctx, "UPDATE recipes SET cuisine_checked_at = now() WHERE id = ANY($1) AND user_id = $2", ids, userID)
+	return err
+}
+
+func renderStatsPage(stats RecipeStats, now time.Time) string {
+	var page strings.Builder
+	fmt.Fprintf(&page, "# Dein Kochjahr %d\n\n[Alle Rezepte](./)\n\n", now.Year())
+	fmt.Fprintf(&page, "- **%d** neue Rezepte in diesem Jahr, **%d** insgesamt\n", stats.YearRecipes, stats.TotalRecipes)
+	fmt.Fprintf(&page, "- **%d** Rezepte mit KI erstellt oder bearbeitet\n", stats.YearGenerations)
+	if stats.YearBusiestMonth != "" {
+		month, err := time.Parse("2006-01", stats.YearBusiestMonth)
+		if err == nil {
+			fmt.Fprintf(&page, "- Fleißigster Monat: **%s**\n", germanMonths[month.Month()-1])
+		}
+	}
+
+	writeCounts := func(title string, entries []CountEntry, limit int) {
+		if len(entries) == 0 {
+			return
+		}
+		page.WriteString("\n## " + title + "\n\n")
+		for i, entry := range entries {
+			if i == limit {
+				break
+			}
+			name := entry.Name
+			if name == "" {
+				name = "Ohne Kategorie"
+			}
+			fmt.Fprintf(&page, "%d. %s (%d)\n", i+1, name, entry.Count)
+		}
+	}
+	writeCounts("Lieblingszutaten", stats.TopIngredients, 5)
+	writeCounts("Kategorien", stats.Categories, 5)
+	writeCounts("Küchen", stats.Cuisines, 5)
+
+	return page.String()
+}
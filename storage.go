@@ -0,0 +1,137 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"log"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+)
+
+const (
+	storageModeDedicated = "dedicated"
+	storageModeShared    = "shared"
+	storageModeEmbedded  = "embedded"
+)
+
+// storageLocation is where a user's site lives: its own storage account, a
+// prefix inside the shared account in multi-tenant mode, or nowhere when the
+// service renders the site itself in embedded mode.
+type storageLocation struct {
+	Account string
+	Prefix  string
+	Mode    string
+}
+
+// configuredStorageMode is the mode assigned to newly registered users.
+// Existing users keep the mode they were provisioned with.
+func configuredStorageMode() string {
+	if testMode() {
+		return storageModeEmbedded
+	}
+
+	switch os.Getenv("STORAGE_MODE") {
+	case storageModeShared:
+		return storageModeShared
+	case storageModeEmbedded:
+		return storageModeEmbedded
+	default:
+		return storageModeDedicated
+	}
+}
+
+func sharedStorageAccount() string {
+	return os.Getenv("SHARED_STORAGE_ACCOUNT")
+}
+
+func resolveStorage(subdomain string) storageLocation {
+	// the mock server runs without a database
+	if pool == nil {
+		return storageLocationFor(subdomain, configuredStorageMode())
+	}
+
+	var mode string
+	err := pool.QueryRow(context.Background(), "SELECT storage_mode FROM users WHERE subdomain = $1", subdomain).Scan(&mode)
+	if err != nil {
+		log.Printf("Failed to look up storage mode for %s, assuming dedicated: %v", subdomain, err)
+		mode = storageModeDedicated
+	}
+
+	return storageLocationFor(subdomain, mode)
+}
+
+func storageLocationFor(subdomain string, mode string) storageLocation {
+	if mode == storageModeEmbedded {
+		return storageLocation{Mode: storageModeEmbedded}
+	}
+
+	if mode == storageModeShared {
+		return storageLocation{
+			Account: sharedStorageAccount(),
+			Prefix:  subdomain + "/",
+			Mode:    storageModeShared,
+		}
+	}
+
+	return storageLocation{
+		Account: subdomain,
+		Mode:    storageModeDedicated,
+	}
+}
+
+func HandleGetStorageSAS(w http.ResponseWriter, r *http.Request) {
+	userCtx, ok := r.Context().Value("user").(UserContext)
+	if !ok {
+		http.Error(w, "Unauthorized: user context missing", http.StatusUnauthorized)
+		return
+	}
+
+	location := resolveStorage(userCtx.Subdomain)
+	if location.Mode == storageModeEmbedded {
+		http.Error(w, "No object storage in embedded mode", http.StatusBadRequest)
+		return
+	}
+
+	expiry := time.Now().UTC().Add(time.Hour)
+	sasURL, err := userDelegationSASURL(location, expiry)
+	if err != nil {
+		log.Printf("Error creating SAS for user %d: %v\n", userCtx.UserID, err)
+		http.Error(w, "Error creating storage access token", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+
+	err = json.NewEncoder(w).Encode(map[string]string{
+		"url":       sasURL,
+		"expiresAt": expiry.Format(time.RFC3339),
+	})
+	if err != nil {
+		http.Error(w, "Error encoding JSON response", http.StatusInternalServerError)
+	}
+}
+
+// siteURL is the public address of the user's recipe site. The Azure static
+// website host is zone specific, so it has to be configured.
+func siteURL(subdomain string) string {
+	location := resolveStorage(subdomain)
+	switch location.Mode {
+	case storageModeEmbedded:
+		return strings.TrimSuffix(os.Getenv("PUBLIC_URL"), "/") + "/u/" + subdomain + "/"
+	case storageModeShared:
+		return strings.TrimSuffix(os.Getenv("SHARED_SITE_URL"), "/") + "/" + location.Prefix
+	default:
+		template := os.Getenv("SITE_URL_TEMPLATE")
+		if template == "" {
+			template = "https://{subdomain}.z6.web.core.windows.net/"
+		}
+		return strings.ReplaceAll(template, "{subdomain}", subdomain)
+	}
+}
+
+func recipeURL(subdomain string, slug string) string {
+	return siteURL(subdomain) + "?recipe=" + slug
+}
@@ -0,0 +1,222 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"strconv"
+
+	"github.com/Azure/azure-sdk-for-go/sdk/azcore"
+	"github.com/Azure/azure-sdk-for-go/sdk/azcore/to"
+	"github.com/Azure/azure-sdk-for-go/sdk/resourcemanager/storage/armstorage"
+	"github.com/minio/minio-go/v7"
+	"github.com/minio/minio-go/v7/pkg/lifecycle"
+)
+
+// Lifecycle management keeps what a site costs in storage predictable: the
+// versions and snapshots every re-render leaves behind are deleted, and the
+// photos of cooking sessions move to a cheaper tier once they are old. The
+// rules are the same for every account of a deployment and set by
+// STORAGE_VERSION_RETENTION_DAYS, STORAGE_PHOTO_TIER_DAYS and
+// STORAGE_PHOTO_TIER, a 0 turns the rule off.
+var (
+	// storageVersionRetentionDays is how long versions and snapshots of a
+	// blob are kept after they were taken.
+	storageVersionRetentionDays = 30
+
+	// storagePhotoTierDays is how long after their upload photos move to
+	// storagePhotoTier.
+	storagePhotoTierDays = 365
+
+	// storagePhotoTier is cool, cold or archive. Cool and cold photos are
+	// still served by the site. Archived photos are offline and break every
+	// gallery that shows them until they are rehydrated, only pick archive
+	// when old photos are no longer published.
+	storagePhotoTier = "cool"
+)
+
+// In the shared account the photos of all users live below their own
+// prefixes, more than a rule can list. They are tagged on upload and the
+// rule filters on the tag instead.
+const (
+	photoBlobTag      = "kind"
+	photoBlobTagValue = "photo"
+)
+
+func initStorageLifecycle() {
+	for name, dest := range map[string]*int{
+		"STORAGE_VERSION_RETENTION_DAYS": &storageVersionRetentionDays,
+		"STORAGE_PHOTO_TIER_DAYS":        &storagePhotoTierDays,
+	} {
+		if value := os.Getenv(name); value != "" {
+			days, err := strconv.Atoi(value)
+			if err != nil || days < 0 {
+				log.Fatalf("Invalid %s %q\n", name, value)
+			}
+			*dest = days
+		}
+	}
+
+	if value := os.Getenv("STORAGE_PHOTO_TIER"); value != "" {
+		if value != "cool" && value != "cold" && value != "archive" {
+			log.Fatalf("Invalid STORAGE_PHOTO_TIER %q, must be cool, cold or archive\n", value)
+		}
+		storagePhotoTier = value
+	}
+}
+
+// storageLifecycleRules are the rules of an account in the given storage
+// mode, none when the deployment turned both off.
+func storageLifecycleRules(mode string) []*armstorage.ManagementPolicyRule {
+	var rules []*armstorage.ManagementPolicyRule
+
+	if storageVersionRetentionDays > 0 {
+		days := to.Ptr(float32(storageVersionRetentionDays))
+		rules = append(rules, &armstorage.ManagementPolicyRule{
+			Name:    to.Ptr("delete-old-versions"),
+			Enabled: to.Ptr(true),
+			Type:    to.Ptr(armstorage.RuleTypeLifecycle),
+			Definition: &armstorage.ManagementPolicyDefinition{
+				Actions: &armstorage.ManagementPolicyAction{
+					Version:  &armstorage.ManagementPolicyVersion{Delete: &armstorage.DateAfterCreation{DaysAfterCreationGreaterThan: days}},
+					Snapshot: &armstorage.ManagementPolicySnapShot{Delete: &armstorage.DateAfterCreation{DaysAfterCreationGreaterThan: days}},
+				},
+				Filters: &armstorage.ManagementPolicyFilter{
+					BlobTypes:   []*string{to.Ptr("blockBlob")},
+					PrefixMatch: []*string{to.Ptr("$web/")},
+				},
+			},
+		})
+	}
+
+	if storagePhotoTierDays > 0 {
+		after := &armstorage.DateAfterModification{DaysAfterModificationGreaterThan: to.Ptr(float32(storagePhotoTierDays))}
+		baseBlob := &armstorage.ManagementPolicyBaseBlob{}
+		switch storagePhotoTier {
+		case "cool":
+			baseBlob.TierToCool = after
+		case "cold":
+			baseBlob.TierToCold = after
+		default:
+			baseBlob.TierToArchive = after
+		}
+
+		filters := &armstorage.ManagementPolicyFilter{BlobTypes: []*string{to.Ptr("blockBlob")}}
+		if mode == storageModeShared {
+			filters.PrefixMatch = []*string{to.Ptr("$web/")}
+			filters.BlobIndexMatch = []*armstorage.TagFilter{{
+				Name: to.Ptr(photoBlobTag), Op: to.Ptr("=="), Value: to.Ptr(photoBlobTagValue),
+			}}
+		} else {
+			filters.PrefixMatch = []*string{to.Ptr("$web/photos/")}
+		}
+
+		rules = append(rules, &armstorage.ManagementPolicyRule{
+			Name:    to.Ptr("tier-session-photos"),
+			Enabled: to.Ptr(true),
+			Type:    to.Ptr(armstorage.RuleTypeLifecycle),
+			Definition: &armstorage.ManagementPolicyDefinition{
+				Actions: &armstorage.ManagementPolicyAction{BaseBlob: baseBlob},
+				Filters: filters,
+			},
+		})
+	}
+
+	return rules
+}
+
+// applyStorageLifecycle replaces the lifecycle policy of the account with
+// the configured one, or removes it when there are no rules.
+func applyStorageLifecycle(storageAccountName string, mode string) error {
+	err := initAccountsClient()
+	if err != nil {
+		return err
+	}
+	ctx := context.Background()
+	client := storageClientFactory.NewManagementPoliciesClient()
+
+	rules := storageLifecycleRules(mode)
+	if len(rules) == 0 {
+		_, err = client.Delete(ctx, resourceGroupName, storageAccountName, armstorage.ManagementPolicyNameDefault, nil)
+		var respErr *azcore.ResponseError
+		if errors.As(err, &respErr) && respErr.StatusCode == http.StatusNotFound {
+			return nil
+		}
+		return err
+	}
+
+	_, err = client.CreateOrUpdate(ctx, resourceGroupName, storageAccountName, armstorage.ManagementPolicyNameDefault,
+		armstorage.ManagementPolicy{
+			Properties: &armstorage.ManagementPolicyProperties{
+				Policy: &armstorage.ManagementPolicySchema{Rules: rules},
+			},
+		}, nil)
+	if err != nil {
+		return fmt.Errorf("failed to set lifecycle policy of %s: %w", storageAccountName, err)
+	}
+	return nil
+}
+
+// applyStorageLifecycles sets the policy on the shared account and on the
+// accounts of users provisioned before there was a policy, and picks up
+// changed settings. It runs as a scheduled job.
+func applyStorageLifecycles(ctx context.Context) error {
+	if account := sharedStorageAccount(); account != "" {
+		if err := applyStorageLifecycle(account, storageModeShared); err != nil {
+			log.Printf("Error setting lifecycle policy of the shared account: %v\n", err)
+		}
+	}
+
+	rows, err := pool.Query(ctx, "SELECT subdomain FROM users WHERE provisioning_status = $1 AND storage_mode = $2",
+		provisioningReady, storageModeDedicated)
+	if err != nil {
+		return err
+	}
+	var accounts []string
+	for rows.Next() {
+		var account string
+		if err := rows.Scan(&account); err != nil {
+			rows.Close()
+			return err
+		}
+		accounts = append(accounts, account)
+	}
+	rows.Close()
+
+	for _, account := range accounts {
+		if err := applyStorageLifecycle(account, storageModeDedicated); err != nil {
+			log.Printf("Error setting lifecycle policy of %s: %v\n", account, err)
+		}
+	}
+	return nil
+}
+
+// setBucketLifecycle is applyStorageLifecycle for S3 buckets. Transitions
+// need a remote tier configured on the server, S3_PHOTO_TIER names it.
+func setBucketLifecycle(ctx context.Context, s3client *minio.Client, bucketName string) error {
+	config := lifecycle.NewConfiguration()
+	if storageVersionRetentionDays > 0 {
+		config.Rules = append(config.Rules, lifecycle.Rule{
+			ID:     "delete-old-versions",
+			Status: "Enabled",
+			NoncurrentVersionExpiration: lifecycle.NoncurrentVersionExpiration{
+				NoncurrentDays: lifecycle.ExpirationDays(storageVersionRetentionDays),
+			},
+		})
+	}
+	if tier := os.Getenv("S3_PHOTO_TIER"); tier != "" && storagePhotoTierDays > 0 {
+		config.Rules = append(config.Rules, lifecycle.Rule{
+			ID:         "tier-session-photos",
+			Status:     "Enabled",
+			RuleFilter: lifecycle.Filter{Prefix: "photos/"},
+			Transition: lifecycle.Transition{
+				Days:         lifecycle.ExpirationDays(storagePhotoTierDays),
+				StorageClass: tier,
+			},
+		})
+	}
+	return s3client.SetBucketLifecycle(ctx, bucketName, config)
+}
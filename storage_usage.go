@@ -0,0 +1,190 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"log"
+	"net/http"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+)
+
+// storageWarningShare is the share of the plan's storage from which users
+// are told they are close to it.
+const storageWarningShare = 0.8
+
+// StorageUsage is what the last inventory measured of the user's site.
+// Embedded sites and sites not measured yet have no MeasuredAt.
+type StorageUsage struct {
+	BlobCount  int64      `json:"blobCount"`
+	TotalBytes int64      `json:"totalBytes"`
+	MeasuredAt *time.Time `json:"measuredAt,omitempty"`
+	LimitBytes int64      `json:"limitBytes"`
+	NearLimit  bool       `json:"nearLimit"`
+}
+
+func (u *StorageUsage) applyLimit(limits PlanLimits) {
+	u.LimitBytes = limits.MaxStorageBytes
+	u.NearLimit = u.LimitBytes != 0 && float64(u.TotalBytes) >= storageWarningShare*float64(u.LimitBytes)
+}
+
+// UserStorageUsage is a row of the admin report.
+type UserStorageUsage struct {
+	StorageUsage
+	UserID      int    `json:"userID"`
+	Subdomain   string `json:"subdomain"`
+	Plan        string `json:"plan"`
+	StorageMode string `json:"storageMode"`
+}
+
+type StorageUsageReport struct {
+	BlobCount  int64              `json:"blobCount"`
+	TotalBytes int64              `json:"totalBytes"`
+	NearLimit  int                `json:"nearLimit"`
+	Users      []UserStorageUsage `json:"users"`
+}
+
+// inventoryStorage measures the site of every ready user with storage, it
+// runs as a scheduled job. Listing is cheap next to the storage itself, so
+// the sites are listed instead of keeping count on every upload.
+func inventoryStorage(ctx context.Context) error {
+	rows, err := pool.Query(ctx, "SELECT id, subdomain, plan FROM users WHERE provisioning_status = $1 AND storage_mode <> $2",
+		provisioningReady, storageModeEmbedded)
+	if err != nil {
+		return err
+	}
+
+	type site struct {
+		id        int
+		subdomain string
+		plan      string
+	}
+	var sites []site
+	for rows.Next() {
+		var s site
+		if err := rows.Scan(&s.id, &s.subdomain, &s.plan); err != nil {
+			rows.Close()
+			return err
+		}
+		sites = append(sites, s)
+	}
+	rows.Close()
+
+	for _, s := range sites {
+		count, bytes, err := measureBlobs(s.subdomain)
+		if err != nil {
+			log.Printf("Error measuring storage of site %s: %v\n", s.subdomain, err)
+			continue
+		}
+		_, err = pool.Exec(ctx, `
+			INSERT INTO storage_usage (user_id, blob_count, total_bytes) VALUES ($1, $2, $3)
+			ON CONFLICT (user_id) DO UPDATE SET blob_count = $2, total_bytes = $3, measured_at = now()`,
+			s.id, count, bytes)
+		if err != nil {
+			return err
+		}
+
+		usage := StorageUsage{TotalBytes: bytes}
+		usage.applyLimit(limitsOfPlan(s.plan))
+		if usage.NearLimit {
+			log.Printf("Site %s uses %d of %d bytes of its plan", s.subdomain, bytes, usage.LimitBytes)
+		}
+	}
+	return nil
+}
+
+// limitsOfPlan is GetUserPlan for a plan already read, unknown plans get
+// the free one.
+func limitsOfPlan(plan string) PlanLimits {
+	if limits, ok := planLimits[plan]; ok {
+		return limits
+	}
+	return planLimits[planFree]
+}
+
+func HandleGetStorageUsage(w http.ResponseWriter, r *http.Request) {
+	userCtx, ok := r.Context().Value("user").(UserContext)
+	if !ok {
+		http.Error(w, "Unauthorized: user context missing", http.StatusUnauthorized)
+		return
+	}
+
+	_, limits, err := GetUserPlan(r.Context(), userCtx.UserID)
+	if err != nil {
+		log.Printf("Error getting plan for user %d: %v\n", userCtx.UserID, err)
+		http.Error(w, "Error getting storage usage", http.StatusInternalServerError)
+		return
+	}
+
+	var usage StorageUsage
+	var measuredAt time.Time
+	err = pool.QueryRow(r.Context(), "SELECT blob_count, total_bytes, measured_at FROM storage_usage WHERE user_id = $1",
+		userCtx.UserID).Scan(&usage.BlobCount, &usage.TotalBytes, &measuredAt)
+	if err == nil {
+		usage.MeasuredAt = &measuredAt
+	} else if !errors.Is(err, pgx.ErrNoRows) {
+		log.Printf("Error getting storage usage for user %d: %v\n", userCtx.UserID, err)
+		http.Error(w, "Error getting storage usage", http.StatusInternalServerError)
+		return
+	}
+	usage.applyLimit(limits)
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+
+	err = json.NewEncoder(w).Encode(usage)
+	if err != nil {
+		http.Error(w, "Error encoding JSON response", http.StatusInternalServerError)
+	}
+}
+
+// HandleListStorageUsage reports the storage of every measured site, the
+// largest first, with the totals that end up on the Azure bill.
+func HandleListStorageUsage(w http.ResponseWriter, r *http.Request) {
+	rows, err := pool.Query(r.Context(), `
+		SELECT u.id, u.subdomain, u.plan, u.storage_mode, s.blob_count, s.total_bytes, s.measured_at
+		FROM storage_usage s JOIN users u ON u.id = s.user_id
+		ORDER BY s.total_bytes DESC, u.id`)
+	if err != nil {
+		log.Printf("Error getting storage usage: %v\n", err)
+		http.Error(w, "Error getting storage usage", http.StatusInternalServerError)
+		return
+	}
+	defer rows.Close()
+
+	report := StorageUsageReport{Users: []UserStorageUsage{}}
+	for rows.Next() {
+		var usage UserStorageUsage
+		var measuredAt time.Time
+		err := rows.Scan(&usage.UserID, &usage.Subdomain, &usage.Plan, &usage.StorageMode, &usage.BlobCount, &usage.TotalBytes, &measuredAt)
+		if err != nil {
+			log.Printf("Error scanning storage usage: %v\n", err)
+			http.Error(w, "Error getting storage usage", http.StatusInternalServerError)
+			return
+		}
+		usage.MeasuredAt = &measuredAt
+		usage.applyLimit(limitsOfPlan(usage.Plan))
+
+		report.BlobCount += usage.BlobCount
+		report.TotalBytes += usage.TotalBytes
+		if usage.NearLimit {
+			report.NearLimit++
+		}
+		report.Users = append(report.Users, usage)
+	}
+	if err := rows.Err(); err != nil {
+		log.Printf("Error getting storage usage: %v\n", err)
+		http.Error(w, "Error getting storage usage", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+
+	err = json.NewEncoder(w).Encode(report)
+	if err != nil {
+		http.Error(w, "Error encoding JSON response", http.StatusInternalServerError)
+	}
+}
@@ -0,0 +1,45 @@
+package main
+
+import (
+	"log"
+	"os"
+
+	"github.com/golang-jwt/jwt/v4"
+)
+
+// TEST_MODE=true runs the service without any external dependency besides
+// Postgres, for integration tests and local frontend development:
+//   - the LLM is replaced by fakeLLM
+//   - new users get embedded storage, their site is served by the service
+//   - bearer tokens are HS256 JWTs signed with TEST_JWT_SECRET instead of
+//     Keycloak tokens, and are not introspected
+//
+// Accepting self-signed tokens is only compiled into builds with
+// -tags testmode, see testmode_auth.go, other builds refuse to start in
+// test mode.
+
+// testKeyfunc verifies the tokens of test mode, nil in release builds.
+var testKeyfunc jwt.Keyfunc
+
+func initTestMode() {
+	if !testMode() {
+		return
+	}
+
+	if testKeyfunc == nil {
+		log.Fatal("TEST_MODE needs a build with -tags testmode")
+	}
+	if os.Getenv("TEST_JWT_SECRET") == "" {
+		log.Fatal("TEST_JWT_SECRET must be set in test mode")
+	}
+	log.Println("running in test mode, do not use in production")
+}
+
+// authKeyfunc verifies tokens against Keycloak's JWKS, or with testKeyfunc
+// in test mode.
+func authKeyfunc(token *jwt.Token) (interface{}, error) {
+	if !testMode() || testKeyfunc == nil {
+		return jwks.Keyfunc(token)
+	}
+	return testKeyfunc(token)
+}
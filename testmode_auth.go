@@ -0,0 +1,19 @@
+//go:build testmode
+
+package main
+
+import (
+	"fmt"
+	"os"
+
+	"github.com/golang-jwt/jwt/v4"
+)
+
+func init() {
+	testKeyfunc = func(token *jwt.Token) (interface{}, error) {
+		if _, ok := token.Method.(*jwt.SigningMethodHMAC); !ok {
+			return nil, fmt.Errorf("unexpected signing method %v in test mode", token.Header["alg"])
+		}
+		return []byte(os.Getenv("TEST_JWT_SECRET")), nil
+	}
+}
@@ -0,0 +1,122 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+// maxImportTextLength is plenty for a recipe pasted from a chat or mail, and
+// keeps pasted conversations from filling the prompt.
+const maxImportTextLength = 20000
+
+// textImportInstruction replaces the units sentence of the format prompts:
+// a pasted recipe is converted as it is, not rewritten.
+const textImportInstruction = "\n\nThe recipe was pasted from a chat or mail. Only change its format: keep every quantity, unit, " +
+	"ingredient and step as written, and do not add, drop or convert anything. Leave out greetings and other text around the recipe. " +
+	"When the format needs a value the text doesn't give, like an amount or a time, make your best guess and list it. " +
+	`Answer with a JSON object {"title": "...", "recipe": "...", "guesses": [{"value": "...", "reason": "..."}]} ` +
+	"holding the title, the full markdown of the recipe and every value you guessed, empty when you guessed nothing."
+
+type RecipeTextRequest struct {
+	Text     string `json:"text"`
+	IsGerman bool   `json:"isGerman"`
+}
+
+// GuessedValue is a value the pasted text did not give, the user should
+// check it before saving.
+type GuessedValue struct {
+	Value  string `json:"value"`
+	Reason string `json:"reason,omitempty"`
+}
+
+type TextImportResult struct {
+	Recipe
+	Guesses []GuessedValue `json:"guesses"`
+}
+
+// HandleGenerateByText converts an unstructured recipe pasted from WhatsApp
+// or a mail. Unlike by-description the model keeps the quantities and steps
+// and only formats them, anything it had to make up is returned as guesses.
+func HandleGenerateByText(w http.ResponseWriter, r *http.Request) {
+	// isGerman falls back to the user's language when left out
+	req := RecipeTextRequest{IsGerman: requestUserSettings(r.Context()).german()}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "Invalid JSON payload", http.StatusBadRequest)
+		return
+	}
+	req.Text = strings.TrimSpace(req.Text)
+	if req.Text == "" {
+		http.Error(w, "Missing text", http.StatusBadRequest)
+		return
+	}
+	if len([]rune(req.Text)) > maxImportTextLength {
+		http.Error(w, "text must be at most "+strconv.Itoa(maxImportTextLength)+" characters", http.StatusBadRequest)
+		return
+	}
+
+	if related, rejectionID := isRecipeRelated(r.Context(), judgeModeText, req.Text); !related {
+		writeJudgeRejection(w, rejectionID)
+		return
+	}
+
+	title, recipe, guesses, err := convertPastedRecipe(r.Context(), req.Text, req.IsGerman)
+	if err != nil {
+		log.Printf("Error converting pasted recipe: %v\n", err)
+		http.Error(w, "Error generating recipe", http.StatusInternalServerError)
+		return
+	}
+
+	resp := TextImportResult{
+		Recipe:  Recipe{Recipename: title, Recipe: addFoodSafetyNote(r.Context(), recipe)},
+		Guesses: guesses,
+	}
+	addTimingEstimate(r.Context(), &resp.Recipe)
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+
+	err = json.NewEncoder(w).Encode(resp)
+	if err != nil {
+		http.Error(w, "Error encoding JSON response", http.StatusInternalServerError)
+	}
+}
+
+// convertPastedRecipe formats a recipe that is already written down without
+// rewriting it, it returns the title, the markdown and the values the model
+// had to guess.
+func convertPastedRecipe(ctx context.Context, text string, isGerman bool) (string, string, []GuessedValue, error) {
+	system := strings.TrimSuffix(englishSystemMessage, englishMetricUnits)
+	if isGerman {
+		system = strings.TrimSuffix(germanSystemMessage, germanMetricUnits)
+	}
+	var converted struct {
+		Title   string         `json:"title"`
+		Recipe  string         `json:"recipe"`
+		Guesses []GuessedValue `json:"guesses"`
+	}
+	err := completeJSON(ctx, llmRequest{
+		Task:   llmTaskRecipeText,
+		System: system + textImportInstruction,
+		Prompt: text,
+	}, &converted)
+	if err != nil {
+		return "", "", nil, err
+	}
+	title, recipe := strings.TrimSpace(converted.Title), strings.TrimSpace(converted.Recipe)
+	if title == "" || recipe == "" {
+		return "", "", nil, errors.New("no recipe in the answer")
+	}
+
+	guesses := []GuessedValue{}
+	for _, guess := range converted.Guesses {
+		if guess.Value = strings.TrimSpace(guess.Value); guess.Value != "" {
+			guesses = append(guesses, guess)
+		}
+	}
+	return title, recipe + "\n", guesses, nil
+}
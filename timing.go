@@ -0,0 +1,142 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"strings"
+)
+
+const (
+	difficultyEasy   = "easy"
+	difficultyMedium = "medium"
+	difficultyHard   = "hard"
+)
+
+var difficultyLabels = map[string]string{
+	difficultyEasy:   "einfach",
+	difficultyMedium: "mittel",
+	difficultyHard:   "anspruchsvoll",
+}
+
+// RecipeTiming holds estimated times in minutes, zero means unknown.
+type RecipeTiming struct {
+	PrepTime   int    `json:"prepTime,omitempty"`
+	CookTime   int    `json:"cookTime,omitempty"`
+	TotalTime  int    `json:"totalTime,omitempty"`
+	Difficulty string `json:"difficulty,omitempty"`
+}
+
+const timingSystemMessage = `You estimate how long a recipe takes and how difficult it is.
+Answer with a JSON object {"prepTime": 0, "cookTime": 0, "totalTime": 0, "difficulty": ""}.
+Times are whole minutes: prepTime for the active preparation, cookTime for cooking, baking and resting, totalTime for everything from start to serving.
+difficulty is one of "easy", "medium", "hard".`
+
+func (t RecipeTiming) isEmpty() bool {
+	return t.PrepTime == 0 && t.CookTime == 0 && t.TotalTime == 0 && t.Difficulty == ""
+}
+
+// normalized drops values the columns can't hold and fills in the total time
+// when only the parts are known.
+func (t RecipeTiming) normalized() RecipeTiming {
+	t.PrepTime = max(t.PrepTime, 0)
+	t.CookTime = max(t.CookTime, 0)
+	t.TotalTime = max(t.TotalTime, 0)
+	if t.TotalTime < t.PrepTime+t.CookTime {
+		t.TotalTime = t.PrepTime + t.CookTime
+	}
+
+	t.Difficulty = strings.ToLower(strings.TrimSpace(t.Difficulty))
+	if _, known := difficultyLabels[t.Difficulty]; !known {
+		t.Difficulty = ""
+	}
+	return t
+}
+
+func estimateRecipeTiming(ctx context.Context, recipe string) (RecipeTiming, error) {
+	var timing RecipeTiming
+	err := completeJSON(ctx, llmRequest{
+		Task:   llmTaskTiming,
+		System: timingSystemMessage,
+		Prompt: recipe,
+	}, &timing)
+	if err != nil {
+		return RecipeTiming{}, err
+	}
+	return timing.normalized(), nil
+}
+
+// addTimingEstimate is used by the generate handlers, the recipe is still
+// returned without times when the estimate fails.
+func addTimingEstimate(ctx context.Context, recipe *Recipe) {
+	timing, err := estimateRecipeTiming(ctx, recipe.Recipe)
+	if err != nil {
+		log.Printf("Error estimating recipe timing: %v\n", err)
+		return
+	}
+	recipe.RecipeTiming = timing
+}
+
+// timingLine renders the times and difficulty as one markdown line, it is
+// empty when nothing is known.
+func timingLine(t RecipeTiming) string {
+	var parts []string
+	if t.PrepTime > 0 {
+		parts = append(parts, "Vorbereitung: "+formatMinutes(t.PrepTime))
+	}
+	if t.CookTime > 0 {
+		parts = append(parts, "Kochzeit: "+formatMinutes(t.CookTime))
+	}
+	if t.TotalTime > 0 {
+		parts = append(parts, "Gesamt: "+formatMinutes(t.TotalTime))
+	}
+	if t.Difficulty != "" {
+		parts = append(parts, "Schwierigkeit: "+difficultyLabels[t.Difficulty])
+	}
+	if len(parts) == 0 {
+		return ""
+	}
+	return "⏱️ " + strings.Join(parts, " · ")
+}
+
+func formatMinutes(minutes int) string {
+	if minutes < 60 {
+		return fmt.Sprintf("%d Min.", minutes)
+	}
+	if minutes%60 == 0 {
+		return fmt.Sprintf("%d Std.", minutes/60)
+	}
+	return fmt.Sprintf("%d Std. %d Min.", minutes/60, minutes%60)
+}
+
+// publishedRecipeMarkdown puts the timing line under the recipe's title, or
+// on top when the recipe has no title heading.
+func publishedRecipeMarkdown(content string, t RecipeTiming) string {
+	line := timingLine(t)
+	if line == "" {
+		return content
+	}
+
+	lines := strings.SplitN(content, "\n", 2)
+	if strings.HasPrefix(lines[0], "# ") {
+		rest := ""
+		if len(lines) == 2 {
+			rest = lines[1]
+		}
+		return lines[0] + "\n\n" + line + "\n" + rest
+	}
+	return line + "\n\n" + content
+}
+
+// filterRecipesByMaxTime keeps recipes whose total time fits. A total time
+// of 0 is unknown, most recipes saved before the timings were extracted
+// have none, so those are kept as well.
+func filterRecipesByMaxTime(recipes []Recipe, minutes int) []Recipe {
+	filtered := []Recipe{}
+	for _, recipe := range recipes {
+		if recipe.TotalTime <= minutes {
+			filtered = append(filtered, recipe)
+		}
+	}
+	return filtered
+}
@@ -0,0 +1,214 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/jackc/pgx/v5"
+)
+
+const translateSystemMessage = "You translate cooking recipes written in markdown. Keep the markdown structure, " +
+	"amounts and units exactly as they are and only translate the text. " +
+	`Answer with a JSON object {"title": "...", "recipe": "..."} holding the translated title and the full translated markdown.`
+
+// translationLanguages are the languages a recipe can be translated into,
+// with the name shown in the site's language switcher.
+var translationLanguages = map[string]string{
+	"de": "Deutsch",
+	"en": "English",
+	"fr": "Français",
+	"it": "Italiano",
+	"es": "Español",
+	"nl": "Nederlands",
+}
+
+var translationPromptLanguages = map[string]string{
+	"de": "German",
+	"en": "English",
+	"fr": "French",
+	"it": "Italian",
+	"es": "Spanish",
+	"nl": "Dutch",
+}
+
+// HandleTranslateRecipe stores a translated copy of the recipe next to it.
+// Translating into a language again replaces the earlier translation, so
+// there is one copy per language.
+func HandleTranslateRecipe(w http.ResponseWriter, r *http.Request) {
+	userCtx, ok := r.Context().Value("user").(UserContext)
+	if !ok {
+		http.Error(w, "Unauthorized: user context missing", http.StatusUnauthorized)
+		return
+	}
+
+	recipeID, err := strconv.Atoi(r.PathValue("id"))
+	if err != nil {
+		http.Error(w, "Invalid recipe ID", http.StatusBadRequest)
+		return
+	}
+
+	lang := strings.ToLower(r.URL.Query().Get("lang"))
+	if _, supported := translationLanguages[lang]; !supported {
+		http.Error(w, "lang must be one of de, en, fr, it, es, nl", http.StatusBadRequest)
+		return
+	}
+
+	var original Recipe
+	var translationOf int
+	err = pool.QueryRow(r.Context(), `
+		SELECT id, title, content, category, slug, prep_minutes, cook_minutes, total_minutes, difficulty, coalesce(translation_of, 0)
+		FROM recipes WHERE id = $1 AND user_id = $2`,
+		recipeID, userCtx.UserID).Scan(&original.ID, &original.Recipename, &original.Recipe, &original.Category, &original.Slug,
+		&original.PrepTime, &original.CookTime, &original.TotalTime, &original.Difficulty, &translationOf)
+	if err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			http.Error(w, "Recipe not found", http.StatusNotFound)
+			return
+		}
+		log.Printf("Error getting recipe: %v\n", err)
+		http.Error(w, "Error getting recipe", http.StatusInternalServerError)
+		return
+	}
+	if translationOf != 0 {
+		http.Error(w, "Translate the original recipe instead of a translation", http.StatusBadRequest)
+		return
+	}
+
+	var translated struct {
+		Title  string `json:"title"`
+		Recipe string `json:"recipe"`
+	}
+	err = completeJSON(r.Context(), llmRequest{
+		Task:   llmTaskTranslate,
+		System: translateSystemMessage,
+		Prompt: "Translate this recipe to " + translationPromptLanguages[lang] + ":\n\nTitle: " + original.Recipename + "\n\n" + original.Recipe,
+	}, &translated)
+	if err != nil || translated.Title == "" || translated.Recipe == "" {
+		log.Printf("Error translating recipe %d: %v\n", recipeID, err)
+		http.Error(w, "Error translating recipe", http.StatusInternalServerError)
+		return
+	}
+
+	translation, err := saveTranslation(r.Context(), userCtx.UserID, original, lang, translated.Title, translated.Recipe)
+	if err != nil {
+		log.Printf("Error saving translation of recipe %d: %v\n", recipeID, err)
+		http.Error(w, "Error saving translation", http.StatusInternalServerError)
+		return
+	}
+
+	// The original is published again for its language switcher.
+	for _, slug := range []string{translation.Slug, original.Slug} {
+		if err := publishRecipeBlob(userCtx.Subdomain, userCtx.UserID, slug); err != nil {
+			log.Printf("Error publishing recipe: %v\n", err)
+			http.Error(w, "Failed to publish recipe", http.StatusInternalServerError)
+			return
+		}
+	}
+	if err := templateRecipesBlob(userCtx.Subdomain, userCtx.UserID); err != nil {
+		log.Printf("Error updating recipe template: %v\n", err)
+		http.Error(w, "Failed to update recipe template", http.StatusInternalServerError)
+		return
+	}
+
+	after := recipeAuditSummary(translation.Recipename, translation.Category, translation.Version, translation.Recipe)
+	after.Detail = "translation of recipe " + strconv.Itoa(original.ID) + " to " + lang
+	recordAudit(r.Context(), userCtx, AuditEntry{Action: auditRecipeTranslated, RecipeID: translation.ID, Slug: translation.Slug, After: after})
+
+	emitEvent(userCtx.UserID, eventRecipeCreated, translation)
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+
+	err = json.NewEncoder(w).Encode(translation)
+	if err != nil {
+		http.Error(w, "Error encoding JSON response", http.StatusInternalServerError)
+	}
+}
+
+func saveTranslation(ctx context.Context, userID int, original Recipe, lang string, title string, content string) (Recipe, error) {
+	translation := Recipe{
+		Recipename:    title,
+		Recipe:        content,
+		Category:      original.Category,
+		RecipeTiming:  original.RecipeTiming,
+		Language:      lang,
+		TranslationOf: original.ID,
+	}
+
+	var existingID int
+	err := pool.QueryRow(ctx, "SELECT id FROM recipes WHERE user_id = $1 AND translation_of = $2 AND language = $3",
+		userID, original.ID, lang).Scan(&existingID)
+	if err != nil && !errors.Is(err, pgx.ErrNoRows) {
+		return Recipe{}, err
+	}
+
+	translation.Slug, err = uniqueRecipeSlug(ctx, userID, title, existingID)
+	if err != nil {
+		return Recipe{}, err
+	}
+
+	if existingID != 0 {
+		err = pool.QueryRow(ctx, `
+			UPDATE recipes SET title = $1, content = $2, slug = $3, updated_at = now(), version = version + 1
+			WHERE id = $4 RETURNING id, version`,
+			title, content, translation.Slug, existingID).Scan(&translation.ID, &translation.Version)
+	} else {
+		err = pool.QueryRow(ctx, `
+			INSERT INTO recipes (user_id, title, content, category, slug, prep_minutes, cook_minutes, total_minutes, difficulty, language, translation_of, draft)
+			VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11, (SELECT draft FROM recipes WHERE id = $11)) RETURNING id, version`,
+			userID, title, content, original.Category, translation.Slug, original.PrepTime, original.CookTime, original.TotalTime,
+			original.Difficulty, lang, original.ID).Scan(&translation.ID, &translation.Version)
+	}
+	if err != nil {
+		return Recipe{}, err
+	}
+	invalidateRecipes(userID)
+
+	return translation, nil
+}
+
+// languageSwitcher links the original and all translations of a recipe,
+// empty when there are none.
+func languageSwitcher(ctx context.Context, userID int, recipeID int, translationOf int) (string, error) {
+	originalID := recipeID
+	if translationOf != 0 {
+		originalID = translationOf
+	}
+
+	rows, err := pool.Query(ctx, `
+		SELECT slug, language, id = $2 FROM recipes WHERE user_id = $1 AND (id = $2 OR translation_of = $2) AND NOT archived AND NOT draft
+		ORDER BY translation_of NULLS FIRST, language`,
+		userID, originalID)
+	if err != nil {
+		return "", err
+	}
+	defer rows.Close()
+
+	var links []string
+	for rows.Next() {
+		var slug, lang string
+		var isOriginal bool
+		if err := rows.Scan(&slug, &lang, &isOriginal); err != nil {
+			return "", err
+		}
+
+		label := translationLanguages[lang]
+		if isOriginal {
+			label = "Original"
+		}
+		links = append(links, "["+label+"](./?recipe="+slug+")")
+	}
+	if err := rows.Err(); err != nil {
+		return "", err
+	}
+
+	if len(links) < 2 {
+		return "", nil
+	}
+	return "🌐 " + strings.Join(links, " · "), nil
+}
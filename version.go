@@ -0,0 +1,45 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+func recipeETag(version int) string {
+	return `"` + strconv.Itoa(version) + `"`
+}
+
+// parseIfMatch extracts the recipe version from an If-Match header written by
+// recipeETag. Weak validators are accepted since the version is exact anyway.
+func parseIfMatch(header string) (int, bool) {
+	header = strings.TrimPrefix(strings.TrimSpace(header), "W/")
+	if len(header) < 2 || !strings.HasPrefix(header, `"`) || !strings.HasSuffix(header, `"`) {
+		return 0, false
+	}
+
+	version, err := strconv.Atoi(header[1 : len(header)-1])
+	if err != nil {
+		return 0, false
+	}
+	return version, true
+}
+
+// writeVersionConflict answers a stale update. currentVersion is 0 when the
+// recipe changed so recently that it isn't known.
+func writeVersionConflict(w http.ResponseWriter, currentVersion int) {
+	w.Header().Set("Content-Type", "application/json")
+	if currentVersion != 0 {
+		w.Header().Set("ETag", recipeETag(currentVersion))
+	}
+	w.WriteHeader(http.StatusConflict)
+
+	resp := map[string]interface{}{
+		"error": "Recipe was changed in the meantime, reload it before saving",
+	}
+	if currentVersion != 0 {
+		resp["currentVersion"] = currentVersion
+	}
+	_ = json.NewEncoder(w).Encode(resp)
+}
@@ -0,0 +1,41 @@
+package main
+
+import (
+	"context"
+	"fmt"
+)
+
+// VoiceAnswer is the spoken confirmation for recipes created by voice,
+// Audio is MP3 (base64 in JSON).
+type VoiceAnswer struct {
+	Text        string `json:"text"`
+	Audio       []byte `json:"audio"`
+	ContentType string `json:"contentType"`
+}
+
+func voiceAnswerText(recipename string, recipe string, isGerman bool) string {
+	ingredients := len(extractIngredientLines(recipe))
+
+	if isGerman {
+		if ingredients == 0 {
+			return fmt.Sprintf("Ich habe ein Rezept für %s erstellt.", recipename)
+		}
+		return fmt.Sprintf("Ich habe ein Rezept für %s mit %d Zutaten erstellt.", recipename, ingredients)
+	}
+
+	if ingredients == 0 {
+		return fmt.Sprintf("I created a recipe for %s.", recipename)
+	}
+	return fmt.Sprintf("I created a recipe for %s with %d ingredients.", recipename, ingredients)
+}
+
+func generateVoiceAnswer(ctx context.Context, recipename string, recipe string, isGerman bool) (*VoiceAnswer, error) {
+	text := voiceAnswerText(recipename, recipe, isGerman)
+
+	audio, err := llm.Speak(ctx, text)
+	if err != nil {
+		return nil, err
+	}
+
+	return &VoiceAnswer{Text: text, Audio: audio, ContentType: "audio/mpeg"}, nil
+}
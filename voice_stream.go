@@ -0,0 +1,230 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"log"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"golang.org/x/net/websocket"
+)
+
+// The transcription stream takes the audio of a dictation over a WebSocket
+// while it is recorded. The client sends the recording as binary frames,
+// each continuing the previous ones like MediaRecorder chunks, and a text
+// frame "stop" at the end. The server answers with JSON text frames:
+//
+//	{"type": "partial", "text": "..."} while recording
+//	{"type": "final", "text": "..."} after stop, then it closes
+//	{"type": "error", "error": "..."}
+//
+// Whisper has no streaming, so a partial transcript is the whole recording
+// so far transcribed again. That gets more expensive as the recording grows,
+// the interval grows by streamPartialInterval with every streamPartialStep
+// of audio and a stream gets at most streamMaxPartials. A stream counts as a
+// generation of the voice import.
+const (
+	streamPartialInterval = 4 * time.Second
+	streamPartialStep     = 1 << 20
+	streamMaxPartials     = 30
+	streamMaxAudio        = 10 << 20
+	streamMaxDuration     = 10 * time.Minute
+	streamMaxFrame        = 1 << 20
+)
+
+type streamMessage struct {
+	Type  string `json:"type"`
+	Text  string `json:"text,omitempty"`
+	Error string `json:"error,omitempty"`
+}
+
+// streamFrame is a received frame with its kind, websocket.Message does
+// not tell text from binary.
+type streamFrame struct {
+	binary bool
+	data   []byte
+}
+
+var streamFrames = websocket.Codec{
+	Marshal: func(v any) ([]byte, byte, error) {
+		return nil, websocket.UnknownFrame, websocket.ErrNotSupported
+	},
+	Unmarshal: func(data []byte, payloadType byte, v any) error {
+		frame := v.(*streamFrame)
+		frame.binary, frame.data = payloadType == websocket.BinaryFrame, data
+		return nil
+	},
+}
+
+// tokenFromQuery lets WebSocket clients, which cannot set headers in the
+// browser, pass the bearer token as the token query parameter. It is
+// redacted from the request log.
+func tokenFromQuery(next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if token := r.URL.Query().Get("token"); token != "" && r.Header.Get("Authorization") == "" {
+			r.Header.Set("Authorization", "Bearer "+token)
+		}
+		next(w, r)
+	}
+}
+
+// HandleTranscriptionStream upgrades to the WebSocket of the transcription
+// stream. Only the CORS_ORIGIN may connect from a browser when it is set.
+// The generation is counted before the upgrade, GenerationLimitMiddleware
+// would wrap the writer the upgrade needs.
+func HandleTranscriptionStream(w http.ResponseWriter, r *http.Request) {
+	userCtx, ok := r.Context().Value("user").(UserContext)
+	if !ok {
+		http.Error(w, "Unauthorized: user context missing", http.StatusUnauthorized)
+		return
+	}
+	if err := countGeneration(r.Context(), userCtx.UserID); err != nil {
+		if errors.Is(err, errGenerationLimit) {
+			http.Error(w, "Daily generation limit reached", http.StatusTooManyRequests)
+			return
+		}
+		log.Printf("Error counting generation for user %d: %v\n", userCtx.UserID, err)
+		http.Error(w, "Error counting generation", http.StatusInternalServerError)
+		return
+	}
+
+	server := websocket.Server{
+		Handshake: func(config *websocket.Config, r *http.Request) error {
+			if allowed := os.Getenv("CORS_ORIGIN"); allowed != "" && allowed != "*" {
+				if origin := r.Header.Get("Origin"); origin != "" && strings.TrimSuffix(origin, "/") != strings.TrimSuffix(allowed, "/") {
+					return websocket.ErrBadWebSocketOrigin
+				}
+			}
+			config.Origin, _ = url.Parse(r.Header.Get("Origin"))
+			return nil
+		},
+		Handler: func(ws *websocket.Conn) {
+			ws.MaxPayloadBytes = streamMaxFrame
+			streamTranscription(ws.Request().Context(), ws)
+		},
+	}
+	server.ServeHTTP(w, r)
+}
+
+// transcriptionStream is the state of one connection. Partial transcripts
+// run in the background so frames keep being read, sends are serialized.
+type transcriptionStream struct {
+	ws *websocket.Conn
+
+	sendMu sync.Mutex
+
+	mu          sync.Mutex
+	audio       bytes.Buffer
+	running     bool
+	lastPartial time.Time
+	started     int
+	partials    sync.WaitGroup
+}
+
+// partialDue tells whether the next partial transcript should start, with
+// s.mu held.
+func (s *transcriptionStream) partialDue() bool {
+	interval := streamPartialInterval * time.Duration(1+s.audio.Len()/streamPartialStep)
+	return !s.running && s.started < streamMaxPartials && time.Since(s.lastPartial) >= interval
+}
+
+func streamTranscription(ctx context.Context, ws *websocket.Conn) {
+	defer ws.Close()
+	ctx, cancel := context.WithTimeout(ctx, streamMaxDuration)
+	defer cancel()
+
+	stream := &transcriptionStream{ws: ws}
+	_ = ws.SetReadDeadline(time.Now().Add(streamMaxDuration))
+	for {
+		var frame streamFrame
+		if err := streamFrames.Receive(ws, &frame); err != nil {
+			// the client went away or took too long, nothing to answer
+			stream.partials.Wait()
+			return
+		}
+
+		if !frame.binary {
+			if strings.TrimSpace(string(frame.data)) == "stop" {
+				stream.finish(ctx)
+				return
+			}
+			stream.send(streamMessage{Type: "error", Error: "unknown message, send audio as binary frames and stop as text"})
+			continue
+		}
+
+		stream.mu.Lock()
+		if stream.audio.Len()+len(frame.data) > streamMaxAudio {
+			stream.mu.Unlock()
+			stream.send(streamMessage{Type: "error", Error: "recording too long"})
+			stream.finish(ctx)
+			return
+		}
+		stream.audio.Write(frame.data)
+		due := stream.partialDue()
+		if due {
+			stream.running, stream.lastPartial = true, time.Now()
+			stream.started++
+			stream.partials.Add(1)
+		}
+		stream.mu.Unlock()
+
+		if due {
+			go stream.partial(ctx)
+		}
+	}
+}
+
+func (s *transcriptionStream) partial(ctx context.Context) {
+	defer s.partials.Done()
+
+	s.mu.Lock()
+	audio := bytes.Clone(s.audio.Bytes())
+	s.mu.Unlock()
+
+	text, err := llm.Transcribe(ctx, bytes.NewReader(audio))
+
+	s.mu.Lock()
+	s.running = false
+	s.mu.Unlock()
+
+	if err != nil {
+		// an unfinished chunk may not decode yet, the next one will
+		log.Printf("Error transcribing partial recording: %v\n", err)
+		return
+	}
+	s.send(streamMessage{Type: "partial", Text: text})
+}
+
+func (s *transcriptionStream) finish(ctx context.Context) {
+	s.partials.Wait()
+
+	s.mu.Lock()
+	audio := bytes.Clone(s.audio.Bytes())
+	s.mu.Unlock()
+	if len(audio) == 0 {
+		s.send(streamMessage{Type: "final"})
+		return
+	}
+
+	text, err := llm.Transcribe(ctx, bytes.NewReader(audio))
+	if err != nil {
+		log.Printf("Error transcribing recording: %v\n", err)
+		s.send(streamMessage{Type: "error", Error: "Failed to transcribe the recording"})
+		return
+	}
+	s.send(streamMessage{Type: "final", Text: text})
+}
+
+func (s *transcriptionStream) send(message streamMessage) {
+	s.sendMu.Lock()
+	defer s.sendMu.Unlock()
+	if err := websocket.JSON.Send(s.ws, message); err != nil {
+		log.Printf("Error sending to transcription stream: %v\n", err)
+	}
+}
@@ -0,0 +1,31 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+func TestStreamPartialsBackOff(t *testing.T) {
+	stream := &transcriptionStream{}
+	if !stream.partialDue() {
+		t.Fatal("first partial not due")
+	}
+
+	stream.lastPartial = time.Now().Add(-streamPartialInterval)
+	if !stream.partialDue() {
+		t.Error("partial of a short recording not due after streamPartialInterval")
+	}
+	stream.audio.Write(make([]byte, 2*streamPartialStep))
+	if stream.partialDue() {
+		t.Error("partial of a long recording due after streamPartialInterval")
+	}
+	stream.lastPartial = time.Now().Add(-3 * streamPartialInterval)
+	if !stream.partialDue() {
+		t.Error("partial of a long recording not due after the longer interval")
+	}
+
+	stream.started = streamMaxPartials
+	if stream.partialDue() {
+		t.Error("partial due after streamMaxPartials")
+	}
+}
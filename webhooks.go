@@ -0,0 +1,259 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	eventRecipeCreated = "recipe.created"
+	eventRecipeUpdated = "recipe.updated"
+	eventRecipeDeleted = "recipe.deleted"
+	eventPlanPublished = "plan.published"
+)
+
+var webhookEvents = map[string]bool{
+	eventRecipeCreated: true,
+	eventRecipeUpdated: true,
+	eventRecipeDeleted: true,
+	eventPlanPublished: true,
+}
+
+// webhookClient delivers to public addresses only, a webhook must not be a
+// way into internal services. A redirect counts as a failed delivery.
+var webhookClient = publicHTTPClient(10 * time.Second)
+
+type Webhook struct {
+	ID        int       `json:"id"`
+	URL       string    `json:"url"`
+	Events    []string  `json:"events"`
+	Secret    string    `json:"secret,omitempty"`
+	CreatedAt time.Time `json:"createdAt"`
+}
+
+type WebhookEvent struct {
+	Event     string      `json:"event"`
+	Timestamp time.Time   `json:"timestamp"`
+	Data      interface{} `json:"data"`
+}
+
+func HandleListWebhooks(w http.ResponseWriter, r *http.Request) {
+	userCtx, ok := r.Context().Value("user").(UserContext)
+	if !ok {
+		http.Error(w, "Unauthorized: user context missing", http.StatusUnauthorized)
+		return
+	}
+
+	rows, err := pool.Query(r.Context(), "SELECT id, url, events, created_at FROM webhooks WHERE user_id = $1 ORDER BY id", userCtx.UserID)
+	if err != nil {
+		log.Printf("Error getting webhooks: %v\n", err)
+		http.Error(w, "Error getting webhooks", http.StatusInternalServerError)
+		return
+	}
+	defer rows.Close()
+
+	webhooks := []Webhook{}
+	for rows.Next() {
+		var webhook Webhook
+		if err := rows.Scan(&webhook.ID, &webhook.URL, &webhook.Events, &webhook.CreatedAt); err != nil {
+			log.Printf("Error scanning webhook: %v\n", err)
+			http.Error(w, "Error getting webhooks", http.StatusInternalServerError)
+			return
+		}
+		webhooks = append(webhooks, webhook)
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+
+	err = json.NewEncoder(w).Encode(webhooks)
+	if err != nil {
+		http.Error(w, "Error encoding JSON response", http.StatusInternalServerError)
+	}
+}
+
+// HandleAddWebhook returns the signing secret, it is not shown again afterwards.
+func HandleAddWebhook(w http.ResponseWriter, r *http.Request) {
+	userCtx, ok := r.Context().Value("user").(UserContext)
+	if !ok {
+		http.Error(w, "Unauthorized: user context missing", http.StatusUnauthorized)
+		return
+	}
+
+	var req struct {
+		URL    string   `json:"url"`
+		Events []string `json:"events"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "Invalid JSON payload", http.StatusBadRequest)
+		return
+	}
+
+	target, err := url.Parse(req.URL)
+	if err != nil || target.Scheme != "https" || target.Host == "" {
+		http.Error(w, "Webhook URL must be an absolute https URL", http.StatusBadRequest)
+		return
+	}
+
+	if len(req.Events) == 0 {
+		http.Error(w, "Missing events", http.StatusBadRequest)
+		return
+	}
+	for _, event := range req.Events {
+		if !webhookEvents[event] {
+			http.Error(w, "Unknown event: "+event, http.StatusBadRequest)
+			return
+		}
+	}
+
+	secret, err := webhookSecret()
+	if err != nil {
+		log.Printf("Error generating webhook secret: %v\n", err)
+		http.Error(w, "Error adding webhook", http.StatusInternalServerError)
+		return
+	}
+
+	webhook := Webhook{URL: req.URL, Events: req.Events, Secret: secret}
+	err = pool.QueryRow(r.Context(), "INSERT INTO webhooks (user_id, url, secret, events) VALUES ($1, $2, $3, $4) RETURNING id, created_at",
+		userCtx.UserID, webhook.URL, webhook.Secret, webhook.Events).Scan(&webhook.ID, &webhook.CreatedAt)
+	if err != nil {
+		log.Printf("Error adding webhook: %v\n", err)
+		http.Error(w, "Error adding webhook", http.StatusInternalServerError)
+		return
+	}
+	recordAudit(r.Context(), userCtx, AuditEntry{Action: auditWebhookCreated, After: &AuditSummary{Title: webhook.URL, Detail: strings.Join(webhook.Events, ", ")}})
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+
+	err = json.NewEncoder(w).Encode(webhook)
+	if err != nil {
+		http.Error(w, "Error encoding JSON response", http.StatusInternalServerError)
+	}
+}
+
+func HandleDeleteWebhook(w http.ResponseWriter, r *http.Request) {
+	userCtx, ok := r.Context().Value("user").(UserContext)
+	if !ok {
+		http.Error(w, "Unauthorized: user context missing", http.StatusUnauthorized)
+		return
+	}
+
+	webhookID, err := strconv.Atoi(r.PathValue("id"))
+	if err != nil {
+		http.Error(w, "Invalid webhook ID", http.StatusBadRequest)
+		return
+	}
+
+	tag, err := pool.Exec(r.Context(), "DELETE FROM webhooks WHERE id = $1 AND user_id = $2", webhookID, userCtx.UserID)
+	if err != nil {
+		log.Printf("Error deleting webhook: %v\n", err)
+		http.Error(w, "Error deleting webhook", http.StatusInternalServerError)
+		return
+	}
+	if tag.RowsAffected() == 0 {
+		http.Error(w, "Webhook not found", http.StatusNotFound)
+		return
+	}
+	recordAudit(r.Context(), userCtx, AuditEntry{Action: auditWebhookDeleted, Before: &AuditSummary{Detail: "webhook " + strconv.Itoa(webhookID)}})
+
+	w.WriteHeader(http.StatusOK)
+}
+
+func webhookSecret() (string, error) {
+	b := make([]byte, 32)
+	_, err := rand.Read(b)
+	if err != nil {
+		return "", fmt.Errorf("failed to generate random bytes: %v", err)
+	}
+	return hex.EncodeToString(b), nil
+}
+
+// emitEvent delivers the event to the user's subscribed webhooks in the
+// background; a failing endpoint never fails the request that caused it.
+func emitEvent(userID int, event string, data interface{}) {
+	go func() {
+		ctx := context.Background()
+		rows, err := pool.Query(ctx, "SELECT url, secret FROM webhooks WHERE user_id = $1 AND $2 = ANY(events)", userID, event)
+		if err != nil {
+			log.Printf("Failed to get webhooks for user %d: %v\n", userID, err)
+			return
+		}
+
+		type target struct{ url, secret string }
+		var targets []target
+		for rows.Next() {
+			var t target
+			if err := rows.Scan(&t.url, &t.secret); err != nil {
+				log.Printf("Failed to scan webhook: %v\n", err)
+				rows.Close()
+				return
+			}
+			targets = append(targets, t)
+		}
+		rows.Close()
+
+		if len(targets) == 0 {
+			return
+		}
+
+		body, err := json.Marshal(WebhookEvent{Event: event, Timestamp: time.Now().UTC(), Data: data})
+		if err != nil {
+			log.Printf("Failed to encode webhook event %s: %v\n", event, err)
+			return
+		}
+
+		for _, t := range targets {
+			deliverWebhook(t.url, t.secret, event, body)
+		}
+	}()
+}
+
+func deliverWebhook(targetURL string, secret string, event string, body []byte) {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write(body)
+	signature := "sha256=" + hex.EncodeToString(mac.Sum(nil))
+
+	const maxAttempts = 3
+	for attempt := 1; attempt <= maxAttempts; attempt++ {
+		req, err := http.NewRequest("POST", targetURL, bytes.NewReader(body))
+		if err != nil {
+			log.Printf("Invalid webhook request for %s: %v\n", targetURL, err)
+			return
+		}
+		req.Header.Set("Content-Type", "application/json")
+		req.Header.Set("X-Webhook-Event", event)
+		req.Header.Set("X-Webhook-Signature", signature)
+
+		resp, err := webhookClient.Do(req)
+		if errors.Is(err, errPrivateAddress) {
+			log.Printf("Webhook %s for %s not delivered: %v", event, targetURL, err)
+			return
+		}
+		if err == nil {
+			resp.Body.Close()
+			if resp.StatusCode < 300 {
+				return
+			}
+			err = fmt.Errorf("status %d", resp.StatusCode)
+		}
+
+		log.Printf("Attempt %d/%d: webhook %s for %s failed: %v", attempt, maxAttempts, event, targetURL, err)
+		if attempt < maxAttempts {
+			time.Sleep(time.Duration(attempt*attempt) * time.Second)
+		}
+	}
+}
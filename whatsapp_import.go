@@ -0,0 +1,394 @@
+package main
+
+import (
+	"archive/zip"
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"path"
+	"regexp"
+	"strings"
+)
+
+// maxWhatsAppImports caps the recipes of one export, every one of them is a
+// generation.
+const maxWhatsAppImports = 20
+
+// An export is read into memory and the photos stay there until the
+// background import is done, so the upload, the number of files in a .zip
+// and what is unpacked from it are all limited.
+const (
+	maxWhatsAppUploadSize   = 50 << 20
+	maxWhatsAppFileSize     = 10 << 20
+	maxWhatsAppEntries      = 10000
+	maxWhatsAppUnpackedSize = 100 << 20
+)
+
+var errWhatsAppExportTooLarge = errors.New("WhatsApp export too large")
+
+// whatsAppMessagePattern matches the first line of a message in the export
+// formats of iOS ("[12.03.21, 18:45:12] Anna: ...") and Android
+// ("12.03.21, 18:45 - Anna: ..." or "3/12/21, 6:45 PM - Anna: ...").
+var whatsAppMessagePattern = regexp.MustCompile(`^\[?(\d{1,2}[./]\d{1,2}[./]\d{2,4}),? (\d{1,2}:\d{2}(?::\d{2})?(?:\s?[APap]\.?[Mm]\.?)?)\]?(?: -)? ([^:]+): (.*)$`)
+
+var whatsAppAttachmentPattern = regexp.MustCompile(`<(?:attached|Anhang): ([^>]+)>|(\S+\.(?:jpe?g|png|webp)) \((?:file attached|Datei angehängt)\)`)
+
+var whatsAppPhotoPattern = regexp.MustCompile(`(?i)\.(?:jpe?g|png|webp)$`)
+
+var whatsAppChatName = regexp.MustCompile(`(?i)^WhatsApp[ -]Chat(?: with| mit| -) (.+)$`)
+
+// A message is taken for a recipe when it lists a few quantities, or says
+// it has ingredients.
+var (
+	recipeQuantityPattern = regexp.MustCompile(`(?i)\b\d+(?:[.,/]\d+)?\s?(?:g|kg|ml|l|el|tl|prisen?|stk|stück|tassen?|cups?|tbsp|tsp|oz|lbs?)\b`)
+	recipeKeywordPattern  = regexp.MustCompile(`(?i)\b(?:zutaten|ingredients)\b`)
+	recipeMentionPattern  = regexp.MustCompile(`(?i)rezept|zutaten|recipe|ingredients`)
+)
+
+type whatsAppMessage struct {
+	Date       string
+	Sender     string
+	Text       string
+	Attachment string
+}
+
+// whatsAppCandidate is a message to import, either its text or its photo.
+type whatsAppCandidate struct {
+	message whatsAppMessage
+	photo   bool
+}
+
+type WhatsAppImportResponse struct {
+	Chat    string `json:"chat"`
+	Recipes int    `json:"recipes"`
+	Photos  int    `json:"photos"`
+}
+
+// HandleImportWhatsApp imports the recipes of an exported WhatsApp chat, the
+// chat file is uploaded as "chat", either the .txt or the .zip with media.
+// Detected recipes are imported in the background and each one names the
+// chat, sender and date it came from, the outcome is mailed like an inbound
+// import.
+func HandleImportWhatsApp(w http.ResponseWriter, r *http.Request) {
+	userCtx, ok := r.Context().Value("user").(UserContext)
+	if !ok {
+		http.Error(w, "Unauthorized: user context missing", http.StatusUnauthorized)
+		return
+	}
+
+	r.Body = http.MaxBytesReader(w, r.Body, maxWhatsAppUploadSize)
+	if err := r.ParseMultipartForm(maxWhatsAppUploadSize); err != nil {
+		var tooLarge *http.MaxBytesError
+		if errors.As(err, &tooLarge) {
+			http.Error(w, "Export too large, please upload at most 50 MB", http.StatusRequestEntityTooLarge)
+			return
+		}
+		http.Error(w, "Failed to parse form data", http.StatusBadRequest)
+		return
+	}
+	isGerman, reason := parseIsGerman(r.Context(), r.FormValue("isGerman"))
+	if reason != "" {
+		http.Error(w, reason, http.StatusBadRequest)
+		return
+	}
+
+	file, header, err := r.FormFile("chat")
+	if err != nil {
+		http.Error(w, "Failed to read chat file", http.StatusBadRequest)
+		return
+	}
+	defer file.Close()
+	data, err := io.ReadAll(file)
+	if err != nil {
+		http.Error(w, "Failed to read chat file", http.StatusBadRequest)
+		return
+	}
+
+	export, err := openWhatsAppExport(data)
+	if errors.Is(err, errWhatsAppExportTooLarge) {
+		http.Error(w, "Export too large, please export the chat without older media", http.StatusRequestEntityTooLarge)
+		return
+	}
+	if err != nil {
+		http.Error(w, "Upload the exported chat as .txt or .zip", http.StatusBadRequest)
+		return
+	}
+	messages := parseWhatsAppChat(export.chat)
+	candidates := whatsAppCandidates(messages)
+	if len(candidates) == 0 {
+		http.Error(w, "No recipes found in the chat", http.StatusUnprocessableEntity)
+		return
+	}
+	photos, err := export.readPhotos(candidates[:min(len(candidates), maxWhatsAppImports)])
+	if errors.Is(err, errWhatsAppExportTooLarge) {
+		http.Error(w, "Export too large, please export the chat without older media", http.StatusRequestEntityTooLarge)
+		return
+	}
+	if err != nil {
+		http.Error(w, "Upload the exported chat as .txt or .zip", http.StatusBadRequest)
+		return
+	}
+
+	resp := WhatsAppImportResponse{Chat: whatsAppChatTitle(header.Filename)}
+	for _, candidate := range candidates[:min(len(candidates), maxWhatsAppImports)] {
+		if candidate.photo {
+			resp.Photos++
+		} else {
+			resp.Recipes++
+		}
+	}
+
+	go importWhatsAppChat(userCtx, resp.Chat, candidates, photos, isGerman)
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusAccepted)
+
+	err = json.NewEncoder(w).Encode(resp)
+	if err != nil {
+		http.Error(w, "Error encoding JSON response", http.StatusInternalServerError)
+	}
+}
+
+// whatsAppExport is an opened export. The photos of a .zip are only listed,
+// readPhotos unpacks the ones the import needs.
+type whatsAppExport struct {
+	chat   string
+	photos map[string]*zip.File
+	// unpacked counts what was read from the archive so far
+	unpacked int64
+}
+
+// openWhatsAppExport reads the chat text, data is either the .txt itself or
+// the .zip with media.
+func openWhatsAppExport(data []byte) (*whatsAppExport, error) {
+	export := &whatsAppExport{photos: map[string]*zip.File{}}
+	if !bytes.HasPrefix(data, []byte("PK\x03\x04")) {
+		export.chat = string(data)
+		return export, nil
+	}
+
+	archive, err := zip.NewReader(bytes.NewReader(data), int64(len(data)))
+	if err != nil {
+		return nil, err
+	}
+	if len(archive.File) > maxWhatsAppEntries {
+		return nil, fmt.Errorf("%w: %d files", errWhatsAppExportTooLarge, len(archive.File))
+	}
+	var chat *zip.File
+	for _, entry := range archive.File {
+		name := path.Base(entry.Name)
+		switch {
+		case name == "_chat.txt":
+			chat = entry
+		case strings.HasSuffix(name, ".txt") && chat == nil:
+			chat = entry
+		case whatsAppPhotoPattern.MatchString(name):
+			export.photos[name] = entry
+		}
+	}
+	if chat == nil {
+		return nil, errors.New("no chat in the archive")
+	}
+	content, err := export.readFile(chat)
+	if err != nil {
+		return nil, err
+	}
+	export.chat = string(content)
+	return export, nil
+}
+
+// readPhotos unpacks the photos the candidates refer to, by file name.
+func (export *whatsAppExport) readPhotos(candidates []whatsAppCandidate) (map[string][]byte, error) {
+	photos := map[string][]byte{}
+	for _, candidate := range candidates {
+		name := candidate.message.Attachment
+		entry, found := export.photos[name]
+		if !candidate.photo || !found || photos[name] != nil {
+			continue
+		}
+		content, err := export.readFile(entry)
+		if err != nil {
+			return nil, err
+		}
+		photos[name] = content
+	}
+	return photos, nil
+}
+
+// readFile unpacks one file. The sizes in the archive can't be trusted, so
+// the limits are applied to what is actually read.
+func (export *whatsAppExport) readFile(entry *zip.File) ([]byte, error) {
+	reader, err := entry.Open()
+	if err != nil {
+		return nil, err
+	}
+	defer reader.Close()
+
+	limit := min(maxWhatsAppFileSize, maxWhatsAppUnpackedSize-export.unpacked)
+	content, err := io.ReadAll(io.LimitReader(reader, limit+1))
+	if err != nil {
+		return nil, err
+	}
+	if int64(len(content)) > limit {
+		return nil, fmt.Errorf("%w: %s", errWhatsAppExportTooLarge, entry.Name)
+	}
+	export.unpacked += int64(len(content))
+	return content, nil
+}
+
+// parseWhatsAppChat splits the export into messages, lines that don't start
+// a message continue the one before.
+func parseWhatsAppChat(chat string) []whatsAppMessage {
+	// iOS marks attachments with a left-to-right mark and times with a
+	// narrow space.
+	chat = strings.NewReplacer("\ufeff", "", "\u200e", "", "\u202f", " ", "\r", "").Replace(chat)
+
+	var messages []whatsAppMessage
+	scanner := bufio.NewScanner(strings.NewReader(chat))
+	scanner.Buffer(make([]byte, 0, 64*1024), maxWhatsAppFileSize)
+	for scanner.Scan() {
+		line := scanner.Text()
+		match := whatsAppMessagePattern.FindStringSubmatch(line)
+		if match == nil {
+			if len(messages) > 0 {
+				messages[len(messages)-1].Text += "\n" + line
+			}
+			continue
+		}
+		messages = append(messages, whatsAppMessage{Date: match[1], Sender: strings.TrimSpace(match[3]), Text: match[4]})
+	}
+
+	for i := range messages {
+		message := &messages[i]
+		if match := whatsAppAttachmentPattern.FindStringSubmatch(message.Text); match != nil {
+			message.Attachment = strings.TrimSpace(match[1] + match[2])
+			message.Text = strings.Replace(message.Text, match[0], "", 1)
+		}
+		message.Text = strings.TrimSpace(message.Text)
+	}
+	return messages
+}
+
+func isRecipeMessage(text string) bool {
+	if len(text) >= 150 && len(recipeQuantityPattern.FindAllString(text, 3)) == 3 {
+		return true
+	}
+	return len(text) >= 100 && recipeKeywordPattern.MatchString(text)
+}
+
+// whatsAppCandidates picks the messages holding a recipe. A photo counts
+// when its caption or the sender's message next to it mentions a recipe,
+// unless that message is the recipe itself and the photo shows the dish.
+func whatsAppCandidates(messages []whatsAppMessage) []whatsAppCandidate {
+	var candidates []whatsAppCandidate
+	for i, message := range messages {
+		if isRecipeMessage(message.Text) {
+			candidates = append(candidates, whatsAppCandidate{message: message})
+			continue
+		}
+		if message.Attachment == "" || !whatsAppPhotoPattern.MatchString(message.Attachment) {
+			continue
+		}
+
+		mentioned := recipeMentionPattern.MatchString(message.Text)
+		for _, j := range []int{i - 1, i + 1} {
+			if j < 0 || j >= len(messages) || messages[j].Sender != message.Sender || messages[j].Attachment != "" {
+				continue
+			}
+			if isRecipeMessage(messages[j].Text) {
+				mentioned = false
+				break
+			}
+			mentioned = mentioned || recipeMentionPattern.MatchString(messages[j].Text)
+		}
+		if mentioned {
+			candidates = append(candidates, whatsAppCandidate{message: message, photo: true})
+		}
+	}
+	return candidates
+}
+
+// whatsAppChatTitle takes the chat's name from the export's file name, like
+// "WhatsApp Chat with Family.zip".
+func whatsAppChatTitle(filename string) string {
+	name := strings.TrimSuffix(path.Base(filename), path.Ext(filename))
+	if match := whatsAppChatName.FindStringSubmatch(name); match != nil {
+		return strings.TrimSpace(match[1])
+	}
+	return ""
+}
+
+func whatsAppAttribution(chat string, message whatsAppMessage, isGerman bool) string {
+	switch {
+	case isGerman && chat != "":
+		return fmt.Sprintf("> Aus dem WhatsApp-Chat „%s“, von %s am %s.", chat, message.Sender, message.Date)
+	case isGerman:
+		return fmt.Sprintf("> Aus einem WhatsApp-Chat, von %s am %s.", message.Sender, message.Date)
+	case chat != "":
+		return fmt.Sprintf("> From the WhatsApp chat “%s”, shared by %s on %s.", chat, message.Sender, message.Date)
+	default:
+		return fmt.Sprintf("> From a WhatsApp chat, shared by %s on %s.", message.Sender, message.Date)
+	}
+}
+
+func importWhatsAppChat(userCtx UserContext, chat string, candidates []whatsAppCandidate, photos map[string][]byte, isGerman bool) {
+	ctx := context.WithValue(context.Background(), "user", userCtx)
+	batch := &importBatch{ctx: ctx, userCtx: userCtx}
+
+	_, limits, err := GetUserPlan(ctx, userCtx.UserID)
+	if err != nil {
+		log.Printf("Error getting plan for user %d: %v\n", userCtx.UserID, err)
+	}
+
+	for i, candidate := range candidates {
+		if i == maxWhatsAppImports {
+			batch.fail(fmt.Sprintf("%d weitere Rezepte", len(candidates)-i), fmt.Sprintf("höchstens %d pro Import", maxWhatsAppImports))
+			break
+		}
+
+		message := candidate.message
+		describe := fmt.Sprintf("Nachricht von %s am %s", message.Sender, message.Date)
+		var generate func(ctx context.Context) (string, string, error)
+		if candidate.photo {
+			describe = message.Attachment
+			data, found := photos[message.Attachment]
+			switch {
+			case !limits.Features[featureImageImport]:
+				batch.fail(describe, "Foto-Import ist in deinem Tarif nicht enthalten")
+				continue
+			case !found:
+				batch.fail(describe, "Foto fehlt im Export, exportiere den Chat mit Medien")
+				continue
+			}
+			generate = func(ctx context.Context) (string, string, error) {
+				return recipeFromImage(ctx, data, isGerman)
+			}
+		} else {
+			generate = func(ctx context.Context) (string, string, error) {
+				title, recipe, _, err := convertPastedRecipe(ctx, message.Text, isGerman)
+				return title, recipe, err
+			}
+		}
+
+		batch.add(describe, "", func(ctx context.Context) (string, string, error) {
+			recipename, recipe, err := generate(ctx)
+			if err != nil {
+				return "", "", err
+			}
+			recipe = strings.TrimRight(recipe, "\n") + "\n\n" + whatsAppAttribution(chat, message, isGerman) + "\n"
+			return recipename, recipe, nil
+		})
+	}
+
+	subject := "WhatsApp-Import"
+	if chat != "" {
+		subject += ": " + chat
+	}
+	batch.confirm(subject)
+}
@@ -0,0 +1,64 @@
+package main
+
+import (
+	"archive/zip"
+	"bytes"
+	"errors"
+	"fmt"
+	"testing"
+)
+
+func whatsAppZip(t *testing.T, files map[string][]byte) []byte {
+	t.Helper()
+	var buf bytes.Buffer
+	archive := zip.NewWriter(&buf)
+	for name, content := range files {
+		file, err := archive.Create(name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err := file.Write(content); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := archive.Close(); err != nil {
+		t.Fatal(err)
+	}
+	return buf.Bytes()
+}
+
+func TestWhatsAppExportReadsReferencedPhotos(t *testing.T) {
+	data := whatsAppZip(t, map[string][]byte{
+		"_chat.txt":          []byte("[12.03.21, 18:45:12] Anna: <attached: 00000001-PHOTO.jpg>\n"),
+		"00000001-PHOTO.jpg": []byte("used"),
+		"00000002-PHOTO.jpg": []byte("unused"),
+	})
+	export, err := openWhatsAppExport(data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	candidates := []whatsAppCandidate{{message: whatsAppMessage{Attachment: "00000001-PHOTO.jpg"}, photo: true}}
+	photos, err := export.readPhotos(candidates)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(photos) != 1 || string(photos["00000001-PHOTO.jpg"]) != "used" {
+		t.Fatalf("readPhotos = %v, want only the referenced photo", photos)
+	}
+}
+
+func TestWhatsAppExportLimits(t *testing.T) {
+	files := map[string][]byte{"_chat.txt": nil}
+	for i := 0; i < maxWhatsAppEntries; i++ {
+		files[fmt.Sprintf("%08d-PHOTO.jpg", i)] = nil
+	}
+	if _, err := openWhatsAppExport(whatsAppZip(t, files)); !errors.Is(err, errWhatsAppExportTooLarge) {
+		t.Errorf("too many entries: got %v, want errWhatsAppExportTooLarge", err)
+	}
+
+	// compresses to a few KB
+	bomb := whatsAppZip(t, map[string][]byte{"_chat.txt": make([]byte, maxWhatsAppFileSize+1)})
+	if _, err := openWhatsAppExport(bomb); !errors.Is(err, errWhatsAppExportTooLarge) {
+		t.Errorf("oversized chat: got %v, want errWhatsAppExportTooLarge", err)
+	}
+}